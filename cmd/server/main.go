@@ -1,79 +1,42 @@
-// @title           GitSync API
-// @version         1.0
-// @description     API for managing git repositories and replication targets
-// @host            localhost:8080
-// @BasePath        /
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"net/http"
-	"os"
 
-	"gitsync/internal/database"
-	"gitsync/internal/handlers"
-
-	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
-	httpSwagger "github.com/swaggo/http-swagger/v2"
-
-	swaggerdocs "gitsync/docs"
+	"gitsync"
 )
 
-func init() {
-	// Initialize swagger docs
-	swaggerdocs.SwaggerInfo.Host = "localhost:8080"
-}
-
 func main() {
-	// Connect to database
-	db, err := database.New()
+	cfg, err := gitsync.ConfigFromEnv()
 	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	defer db.Close()
-
-	// Run migrations
-	if err := db.RunMigrations(); err != nil {
-		log.Fatalf("failed to run migrations: %v", err)
+	serverCfg, err := gitsync.ServerConfigFromEnv()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
 	}
 
-	// Initialize handlers
-	h := handlers.NewHandler(db)
-
-	// Setup router
-	r := mux.NewRouter()
-	r.HandleFunc("/health", h.HealthCheck).Methods("GET")
-	r.HandleFunc("/repositories", h.CreateRepository).Methods("POST")
-	r.HandleFunc("/repositories", h.ListRepositories).Methods("GET")
-	r.HandleFunc("/repositories/{id}/targets", h.CreateTarget).Methods("POST")
-
-	// Swagger documentation - serve swagger.json from embedded docs
-	r.HandleFunc("/swagger/swagger.json", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./docs/swagger.json")
-	})
-
-	// Swagger UI
-	r.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
-		httpSwagger.URL("/swagger/swagger.json"),
-	))
-
-	// Get server configuration
-	host := getEnv("SERVER_HOST", "0.0.0.0")
-	port := getEnv("SERVER_PORT", "8080")
-	addr := fmt.Sprintf("%s:%s", host, port)
-
-	log.Printf("Starting server on %s", addr)
-	log.Printf("Swagger UI available at http://%s/swagger/index.html", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("failed to start server: %v", err)
+	app, err := gitsync.New(cfg)
+	if err != nil {
+		log.Fatalf("failed to start: %v", err)
 	}
-}
+	defer app.Close()
+	app.Start(context.Background())
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	srv := app.Server(serverCfg)
+	scheme := "http"
+	if serverCfg.TLS() {
+		scheme = "https"
+	}
+	log.Printf("Starting server on %s (HTTP/2 over TLS: %t, cleartext HTTP/2: %t)", srv.Addr, serverCfg.TLS(), serverCfg.H2C)
+	log.Printf("Swagger UI available at %s://%s/swagger/index.html", scheme, srv.Addr)
+	if serverCfg.TLS() {
+		err = srv.ListenAndServeTLS(serverCfg.TLSCertFile, serverCfg.TLSKeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		log.Fatalf("failed to start server: %v", err)
 	}
-	return defaultValue
 }
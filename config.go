@@ -0,0 +1,379 @@
+package gitsync
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/backup"
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/consistency"
+	"gitsync/internal/credentials"
+	"gitsync/internal/digest"
+	"gitsync/internal/events"
+	"gitsync/internal/federation"
+	"gitsync/internal/gitops"
+	"gitsync/internal/handlers"
+	"gitsync/internal/hostkeys"
+	"gitsync/internal/ids"
+	"gitsync/internal/maintenance"
+	"gitsync/internal/models"
+	"gitsync/internal/objectstore"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/retention"
+	"gitsync/internal/schedule"
+	"gitsync/internal/sessions"
+	"gitsync/internal/tracing"
+	"gitsync/internal/validation"
+	"gitsync/internal/worker"
+)
+
+// Config holds everything needed to assemble a GitSync server.
+// ConfigFromEnv fills it the way the standalone server is configured;
+// embedders usually start from that and override individual fields.
+type Config struct {
+	// DB is used instead of connecting with the DB_* variables when set.
+	// It is not closed by App.Close.
+	DB *sql.DB
+
+	// ProviderTokens maps provider names to API tokens used when a
+	// repository has no credential of its own
+	ProviderTokens map[string]string
+	// CredentialKeys encrypts the secrets of credential profiles at rest.
+	// New fails without it unless AllowPlaintextCredentials is set.
+	CredentialKeys *credentials.Keyring
+	// AllowPlaintextCredentials stores credential secrets in plaintext
+	// when no CredentialKeys are set. Meant for local development only.
+	AllowPlaintextCredentials bool
+	// ProviderTLS trusts internal CAs for, or stops verifying, the
+	// certificates of self-hosted provider instances
+	ProviderTLS provider.InstanceTLS
+	// Transfer tunes the git wire protocol and packing of every sync;
+	// repositories may override it
+	Transfer models.Transfer
+	// WebhookBaseURL is the public URL of this server; webhook management
+	// is disabled when empty
+	WebhookBaseURL string
+	// WebhookSecrets maps provider names to the secret of webhooks
+	// configured by hand, used for repositories without a webhook managed
+	// by GitSync
+	WebhookSecrets map[string]string
+	// DeployKeys gives SSH targets a key pair of their own, added to the
+	// target repository as a deploy key through the provider API
+	DeployKeys bool
+	// DeployKeyReconcileInterval is how often deploy keys are re-added and
+	// those of deleted targets removed
+	DeployKeyReconcileInterval time.Duration
+
+	WebhookReconcileInterval time.Duration
+	ProviderProbeInterval    time.Duration
+	SourceCheckInterval      time.Duration
+	SLOCheckInterval         time.Duration
+	RetentionInterval        time.Duration
+	DigestCheckInterval      time.Duration
+	GitOpsInterval           time.Duration
+	// FederationInterval is how often the state is pulled from the primary
+	// or pushed to the standby
+	FederationInterval time.Duration
+	// SyncCheckInterval is how often repositories are checked for a due sync
+	SyncCheckInterval time.Duration
+	// BackupCheckInterval is how often repositories are checked for a due
+	// snapshot
+	BackupCheckInterval time.Duration
+	// ConsistencyCheckInterval is how often a scheduled consistency audit is
+	// checked for being due
+	ConsistencyCheckInterval time.Duration
+	// EventPublishInterval is how often new events are published to each
+	// event sink
+	EventPublishInterval time.Duration
+	// MaintenanceCheckInterval is how often mirrors are checked for a due
+	// repack
+	MaintenanceCheckInterval time.Duration
+
+	// ArchiveAction is applied to targets of archived or deleted sources
+	// without their own archive_action
+	ArchiveAction string
+	DigestPeriods []string
+
+	// ComplianceSigningKey is a base64 Ed25519 key; exports are disabled
+	// when empty
+	ComplianceSigningKey string
+	ExportDir            string
+	ExportS3             *objectstore.S3
+
+	CacheDir string
+	// CacheStore keeps a bundle of every full mirror, from which workers
+	// whose cache directory is empty restore them; nil keeps mirrors in
+	// the cache directory only
+	CacheStore objectstore.Store
+	// CacheStoreInterval is the least time between saves of one mirror
+	CacheStoreInterval time.Duration
+	SSHControlDir      string
+	SSHControlPersist  time.Duration
+	// SSHKnownHostsFile is the known_hosts file written from the SSH host
+	// keys kept in the database; the files of the system are used when
+	// empty
+	SSHKnownHostsFile string
+	// SSHHostKeyPolicy decides whether hosts without a trusted key are
+	// refused (strict, also when empty) or trusted on first use (tofu)
+	SSHHostKeyPolicy string
+	// SSHHostKeySyncInterval is how often keys trusted on first use are
+	// recorded and changes made through other servers picked up
+	SSHHostKeySyncInterval time.Duration
+	// MaxRepoSize is the size cap in bytes above which syncs are aborted
+	// and the repository flagged; 0 disables it
+	MaxRepoSize int64
+	// MaxRequestBodySize caps API request bodies in bytes; 0 disables it
+	MaxRequestBodySize int64
+	// RequestTimeouts bound how long API requests may run
+	RequestTimeouts handlers.Timeouts
+	// MirrorVerify is the verification run on mirrors and targets around
+	// each push: off, connectivity or full
+	MirrorVerify string
+	// SecretScan scans new commits for credentials and quarantines syncs
+	// that would push them; nil disables it
+	SecretScan *replication.SecretScanner
+	// Anomalies sets which source changes pause syncs until acknowledged;
+	// detection is off unless enabled
+	Anomalies replication.AnomalyConfig
+
+	// Schedule sets the sync intervals of repositories without their own
+	Schedule schedule.Adaptive
+	// SyncWindow restricts when scheduled syncs of repositories without a
+	// window of their own may start; nil allows any time
+	SyncWindow *models.SyncWindow
+	// SyncSchedule schedules syncs of repositories without a schedule or
+	// interval of their own instead of the adaptive interval; nil keeps it
+	SyncSchedule *schedule.Cron
+	// SyncRetry sets how failed syncs of a target are retried before it is
+	// dead-lettered
+	SyncRetry replication.RetryConfig
+	// Workers sizes the pool that runs syncs
+	Workers worker.Config
+	// HostLimits bounds the pushes to one host in progress at once
+	HostLimits worker.HostLimits
+	// Maintenance repacks cached mirrors on a schedule
+	Maintenance maintenance.Config
+	// Backup takes bundle snapshots of every repository when its store is
+	// set
+	Backup backup.Config
+	// Consistency audits every target against its source on a schedule
+	Consistency consistency.Config
+	// Events publishes domain events to Kafka and NATS when their servers
+	// are set
+	Events events.Config
+	// Tracing exports spans of requests, syncs, queries and git commands
+	// over OTLP when enabled
+	Tracing tracing.Config
+
+	URLPolicy validation.URLPolicy
+	Retention models.Retention
+	Identity  auth.ProxyIdentity
+	// Sessions logs browser users in through an OIDC provider; disabled
+	// when its Issuer is empty
+	Sessions sessions.Config
+	// SessionCleanupInterval is how often expired sessions are deleted
+	SessionCleanupInterval time.Duration
+	// Cache keeps hot listings between writes; nil disables it
+	Cache *cache.Cache
+	// TrashRetention is how long deleted repositories and targets can be
+	// restored; 0 deletes them outright
+	TrashRetention time.Duration
+
+	RequireTargetApproval bool
+	RBAC                  bool
+
+	// GitOps reconciles repositories with a state file in a git
+	// repository; disabled when its RepoURL is empty
+	GitOps gitops.Config
+	// Federation replicates the configuration of a primary instance to a
+	// standby; disabled when its Role is empty
+	Federation federation.Config
+
+	// Clock and IDs stamp new records; the wall clock and random UUIDs
+	// when nil
+	Clock clock.Clock
+	IDs   ids.Generator
+}
+
+// ConfigFromEnv reads the configuration of the standalone server from the
+// environment
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		ProviderTokens: map[string]string{
+			"github": os.Getenv("GITHUB_TOKEN"),
+			"gitlab": os.Getenv("GITLAB_TOKEN"),
+			"gitea":  os.Getenv("GITEA_TOKEN"),
+		},
+		WebhookBaseURL: os.Getenv("WEBHOOK_BASE_URL"),
+		WebhookSecrets: map[string]string{
+			"github": os.Getenv("GITHUB_WEBHOOK_SECRET"),
+			"gitlab": os.Getenv("GITLAB_WEBHOOK_SECRET"),
+			"gitea":  os.Getenv("GITEA_WEBHOOK_SECRET"),
+		},
+		DeployKeys:            getEnv("DEPLOY_KEYS", "false") == "true",
+		ArchiveAction:         getEnv("ARCHIVE_ACTION", models.ArchiveActionFlag),
+		DigestCheckInterval:   15 * time.Minute,
+		ComplianceSigningKey:  os.Getenv("COMPLIANCE_SIGNING_KEY"),
+		ExportDir:             getEnv("EXPORT_DIR", "./exports"),
+		ExportS3:              objectstore.S3FromEnv("EXPORT_S3"),
+		CacheDir:              getEnv("CACHE_DIR", filepath.Join(os.TempDir(), "gitsync-cache")),
+		SSHControlDir:         getEnv("SSH_CONTROL_DIR", filepath.Join(os.TempDir(), "gitsync-ssh")),
+		SSHKnownHostsFile:     getEnv("SSH_KNOWN_HOSTS_FILE", filepath.Join(os.TempDir(), "gitsync-known_hosts")),
+		SSHHostKeyPolicy:      getEnv("SSH_HOST_KEY_POLICY", hostkeys.TOFU),
+		Identity:              auth.ProxyIdentityFromEnv(),
+		RequireTargetApproval: getEnv("TARGET_APPROVAL", "false") == "true",
+		RBAC:                  getEnv("RBAC_ENABLED", "false") == "true",
+		// Set explicitly, as credential secrets are encrypted otherwise
+		AllowPlaintextCredentials: getEnv("CREDENTIALS_ALLOW_PLAINTEXT", "false") == "true",
+	}
+
+	for _, d := range []struct {
+		name     string
+		fallback string
+		field    *time.Duration
+	}{
+		{"WEBHOOK_RECONCILE_INTERVAL", "1h", &cfg.WebhookReconcileInterval},
+		{"DEPLOY_KEY_RECONCILE_INTERVAL", "1h", &cfg.DeployKeyReconcileInterval},
+		{"PROVIDER_PROBE_INTERVAL", "5m", &cfg.ProviderProbeInterval},
+		{"SOURCE_CHECK_INTERVAL", "6h", &cfg.SourceCheckInterval},
+		{"SLO_CHECK_INTERVAL", "5m", &cfg.SLOCheckInterval},
+		{"RETENTION_INTERVAL", "1h", &cfg.RetentionInterval},
+		{"GITOPS_INTERVAL", "5m", &cfg.GitOpsInterval},
+		{"FEDERATION_INTERVAL", "1m", &cfg.FederationInterval},
+		{"SYNC_CHECK_INTERVAL", "1m", &cfg.SyncCheckInterval},
+		{"BACKUP_CHECK_INTERVAL", "5m", &cfg.BackupCheckInterval},
+		{"CONSISTENCY_AUDIT_CHECK_INTERVAL", "15m", &cfg.ConsistencyCheckInterval},
+		{"EVENT_PUBLISH_INTERVAL", "10s", &cfg.EventPublishInterval},
+		{"MAINTENANCE_CHECK_INTERVAL", "15m", &cfg.MaintenanceCheckInterval},
+		{"CACHE_STORE_INTERVAL", "1h", &cfg.CacheStoreInterval},
+		{"SSH_CONTROL_PERSIST", "60s", &cfg.SSHControlPersist},
+		{"SSH_HOST_KEY_SYNC_INTERVAL", "1m", &cfg.SSHHostKeySyncInterval},
+		{"SESSION_CLEANUP_INTERVAL", "1h", &cfg.SessionCleanupInterval},
+		{"TRASH_RETENTION", "168h", &cfg.TrashRetention},
+		{"REQUEST_TIMEOUT", "30s", &cfg.RequestTimeouts.Default},
+		{"REQUEST_TIMEOUT_LONG", "10m", &cfg.RequestTimeouts.Long},
+		{"REQUEST_TIMEOUT_STREAM", "0", &cfg.RequestTimeouts.Stream},
+	} {
+		v, err := time.ParseDuration(getEnv(d.name, d.fallback))
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		*d.field = v
+	}
+
+	var err error
+	if cfg.DigestPeriods, err = digest.ParsePeriods(getEnv("DIGEST_PERIODS", "daily,weekly")); err != nil {
+		return cfg, fmt.Errorf("invalid DIGEST_PERIODS: %w", err)
+	}
+	if err := hostkeys.ValidatePolicy(cfg.SSHHostKeyPolicy); err != nil {
+		return cfg, fmt.Errorf("invalid SSH_HOST_KEY_POLICY: %w", err)
+	}
+	if cfg.ProviderTLS, err = provider.InstanceTLSFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Transfer, err = replication.TransferFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.CacheStore, err = objectstore.FromURL(os.Getenv("CACHE_STORE")); err != nil {
+		return cfg, fmt.Errorf("invalid CACHE_STORE: %w", err)
+	}
+	if cfg.URLPolicy, err = validation.URLPolicyFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Retention, err = retention.DefaultsFromEnv(); err != nil {
+		return cfg, fmt.Errorf("invalid retention setting: %w", err)
+	}
+	if cfg.Cache, err = cache.FromEnv(); err != nil {
+		return cfg, fmt.Errorf("failed to configure cache: %w", err)
+	}
+	if cfg.IDs, err = ids.FromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.CredentialKeys, err = credentials.KeyringFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.GitOps, err = gitops.ConfigFromEnv(); err != nil {
+		return cfg, fmt.Errorf("invalid GitOps setting: %w", err)
+	}
+	if cfg.Federation, err = federation.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Schedule, err = schedule.AdaptiveFromEnv(); err != nil {
+		return cfg, err
+	}
+	if raw := os.Getenv("MAX_REPO_SIZE_GB"); raw != "" {
+		gb, err := strconv.ParseFloat(raw, 64)
+		if err != nil || gb < 0 {
+			return cfg, fmt.Errorf("MAX_REPO_SIZE_GB must be a non-negative number")
+		}
+		cfg.MaxRepoSize = int64(gb * (1 << 30))
+	}
+	cfg.MaxRequestBodySize = 1 << 20
+	if raw := os.Getenv("MAX_REQUEST_BODY_MB"); raw != "" {
+		mb, err := strconv.ParseFloat(raw, 64)
+		if err != nil || mb <= 0 {
+			return cfg, fmt.Errorf("MAX_REQUEST_BODY_MB must be a positive number")
+		}
+		cfg.MaxRequestBodySize = int64(mb * (1 << 20))
+	}
+	if cfg.MirrorVerify = os.Getenv("MIRROR_VERIFY"); cfg.MirrorVerify == "off" {
+		cfg.MirrorVerify = replication.VerifyOff
+	}
+	if err := replication.ValidateVerify(cfg.MirrorVerify); err != nil {
+		return cfg, fmt.Errorf("invalid MIRROR_VERIFY: %w", err)
+	}
+	if cfg.SecretScan, err = replication.SecretScannerFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Anomalies, err = replication.AnomalyConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.SyncWindow, err = schedule.WindowFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.SyncSchedule, err = schedule.CronFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.SyncRetry, err = replication.RetryConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Workers, err = worker.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.HostLimits, err = worker.HostLimitsFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Backup, err = backup.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Consistency, err = consistency.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Maintenance, err = maintenance.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Events, err = events.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Sessions, err = sessions.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	if cfg.Tracing, err = tracing.ConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
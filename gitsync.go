@@ -0,0 +1,368 @@
+// Package gitsync assembles the GitSync server: database, background jobs
+// and HTTP API. cmd/server runs it standalone; other binaries can embed it
+// by mounting App.Handler and calling Start.
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"gitsync/internal/archival"
+	"gitsync/internal/backup"
+	"gitsync/internal/buildinfo"
+	"gitsync/internal/clock"
+	"gitsync/internal/compliance"
+	"gitsync/internal/consistency"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/deploykeys"
+	"gitsync/internal/digest"
+	"gitsync/internal/events"
+	"gitsync/internal/federation"
+	"gitsync/internal/git"
+	"gitsync/internal/gitops"
+	"gitsync/internal/handlers"
+	"gitsync/internal/hostkeys"
+	"gitsync/internal/ids"
+	"gitsync/internal/maintenance"
+	"gitsync/internal/metrics"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+	"gitsync/internal/policy"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/retention"
+	"gitsync/internal/schedule"
+	"gitsync/internal/sessions"
+	"gitsync/internal/slo"
+	"gitsync/internal/tracing"
+	"gitsync/internal/webhooks"
+	"gitsync/internal/worker"
+)
+
+// App is an assembled GitSync server
+type App struct {
+	db     *database.DB
+	ownsDB bool
+	router http.Handler
+	// stopTracing exports the spans left and puts back the tracer provider
+	// replaced when tracing is enabled
+	stopTracing func(context.Context) error
+
+	// jobs are the background loops run by Start
+	jobs []func(ctx context.Context)
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New connects to the database, applies migrations and wires all services.
+// Background jobs do not run until Start is called.
+func New(cfg Config) (*App, error) {
+	switch cfg.ArchiveAction {
+	case models.ArchiveActionFlag, models.ArchiveActionArchive, models.ArchiveActionBanner:
+	default:
+		return nil, fmt.Errorf("invalid archive action %q. allowed: flag, archive, banner", cfg.ArchiveAction)
+	}
+	if cfg.CredentialKeys == nil && !cfg.AllowPlaintextCredentials {
+		return nil, fmt.Errorf("no credentials master key is configured: set CREDENTIALS_MASTER_KEY or CREDENTIALS_MASTER_KEY_FILE, " +
+			"or CREDENTIALS_ALLOW_PLAINTEXT=true to store credential secrets in plaintext")
+	}
+
+	app := &App{}
+	if cfg.Tracing.Enabled {
+		stop, err := tracing.Setup(context.Background(), cfg.Tracing)
+		if err != nil {
+			return nil, err
+		}
+		app.stopTracing = stop
+	}
+	if cfg.DB != nil {
+		app.db = &database.DB{DB: cfg.DB}
+	} else {
+		db, err := database.New()
+		if err != nil {
+			app.Close()
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		app.db, app.ownsDB = db, true
+	}
+	if err := app.db.RunMigrations(); err != nil {
+		app.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	if err := app.wire(cfg); err != nil {
+		app.Close()
+		return nil, err
+	}
+	return app, nil
+}
+
+func (a *App) wire(cfg Config) error {
+	db := a.db
+	clk, gen := cfg.Clock, cfg.IDs
+	if clk == nil {
+		clk = clock.System{}
+	}
+	if gen == nil {
+		gen = ids.Random{}
+	}
+
+	// Provider API client shared by all provider integrations
+	clientOpts := provider.DefaultClientOptions()
+	var err error
+	if clientOpts.HTTPClient, err = cfg.ProviderTLS.Client(clientOpts.HTTPClient); err != nil {
+		return fmt.Errorf("invalid provider TLS setting: %w", err)
+	}
+	if cfg.Tracing.Enabled {
+		clientOpts.HTTPClient.Transport = tracing.Transport(clientOpts.HTTPClient.Transport)
+	}
+	clientOpts.Clock = clk
+	providerClient := provider.NewClient(clientOpts)
+	creds := credentials.NewStore(db, cfg.ProviderTokens, cfg.CredentialKeys)
+	if err := creds.Reseal(context.Background()); err != nil {
+		return err
+	}
+
+	notifier := notify.NewDispatcher(db, clk)
+	a.jobs = append(a.jobs, notifier.Run)
+
+	// Webhook management is enabled when a public receiver URL is configured
+	hooks := webhooks.NewManager(db, providerClient, creds, clk, cfg.WebhookBaseURL)
+	if err := hooks.Reseal(context.Background()); err != nil {
+		return err
+	}
+	a.every(hooks.Run, cfg.WebhookReconcileInterval)
+
+	// Periodically verify provider credentials so expiry shows up before a sync fails
+	prober := provider.NewProber(providerClient, cfg.ProviderTokens, clk)
+	a.every(prober.Run, cfg.ProviderProbeInterval)
+
+	// Pushes to the same host share one SSH connection while it stays in use
+	gitRunner, err := git.NewRunner(cfg.SSHControlDir, cfg.SSHControlPersist)
+	if err != nil {
+		return err
+	}
+	gitRunner.Config = cfg.ProviderTLS.GitConfig()
+	maps.Copy(gitRunner.Config, replication.TransferConfig(cfg.Transfer))
+
+	// Detect archived or deleted sources and propagate that to targets
+	watcher := archival.NewWatcher(db, providerClient, creds, gitRunner, notifier, cfg.Cache, clk, cfg.ArchiveAction)
+	a.every(watcher.Run, cfg.SourceCheckInterval)
+
+	// Open and resolve incidents for targets that miss their repository's sync SLO
+	a.every(slo.NewMonitor(db, notifier).Run, cfg.SLOCheckInterval)
+
+	// Send daily and weekly activity digests to subscribed channels
+	digests := digest.NewBuilder(db)
+	a.every(digest.NewScheduler(digests, notifier, cfg.DigestPeriods, clk).Run, cfg.DigestCheckInterval)
+
+	// Signed compliance archives of the audit log and sync history
+	signingKey, err := compliance.ParseSigningKey(cfg.ComplianceSigningKey)
+	if err != nil {
+		return fmt.Errorf("invalid compliance signing key: %w", err)
+	}
+	exporter := compliance.NewExporter(db, signingKey, cfg.ExportDir, cfg.ExportS3, clk)
+
+	// Keep a bundle of every mirror in object storage when a store is set,
+	// so workers with an empty cache directory restore mirrors from it
+	var storage replication.CacheStorage = replication.LocalStorage{}
+	if cfg.CacheStore != nil {
+		storage = replication.NewBundleStorage(cfg.CacheStore, gitRunner, cfg.CacheStoreInterval, clk)
+	}
+
+	// Expire sync history, logs and cached mirrors per repository
+	pools := replication.NewPools(cfg.CacheDir, gitRunner)
+	a.every(retention.NewJob(db, cfg.Retention, cfg.CacheDir, pools, storage).Run, cfg.RetentionInterval)
+
+	// Check SSH host keys against those kept in the database rather than
+	// the known_hosts files of the system
+	hostKeys := hostkeys.NewStore(db, cfg.SSHKnownHostsFile, cfg.SSHHostKeyPolicy, clk, gen)
+	if hostKeys.Enabled() {
+		gitRunner.KnownHostsFile = cfg.SSHKnownHostsFile
+		gitRunner.AcceptNewHostKeys = cfg.SSHHostKeyPolicy == hostkeys.TOFU
+		a.every(hostKeys.Run, cfg.SSHHostKeySyncInterval)
+	}
+	policies := policy.NewEngine(db)
+
+	// Sync repositories when their schedule says so or on demand, on a pool
+	// sized to the load
+	syncPool := worker.NewPool("sync", cfg.Workers)
+	a.jobs = append(a.jobs, syncPool.Run)
+	var anomalies *replication.AnomalyDetector
+	if cfg.Anomalies.Enabled() {
+		anomalies = replication.NewAnomalyDetector(db, notifier, cfg.Anomalies, clk, gen)
+	}
+	// However many workers sync, each host only receives so many pushes
+	pushHosts := worker.NewLimiter("push", cfg.HostLimits)
+	pusher := replication.NewPusher(db, gitRunner, cfg.CacheDir, pools, cfg.MaxRepoSize, cfg.MirrorVerify, cfg.SecretScan, storage, anomalies, pushHosts, clk)
+	// SSH targets push with a deploy key of their own when enabled
+	deployKeys := deploykeys.NewManager(db, providerClient, creds, clk, cfg.DeployKeys)
+	a.every(deployKeys.Run, cfg.DeployKeyReconcileInterval)
+	syncer := replication.NewSyncer(db, pusher, creds, providerClient, policies, deployKeys, cfg.SyncRetry, clk, gen)
+	runner := replication.NewRunner(db, syncer, schedule.NewPlanner(db, cfg.Schedule, cfg.SyncWindow, cfg.SyncSchedule), syncPool)
+	a.every(runner.Run, cfg.SyncCheckInterval)
+	// Syncs are queued in the database, shared with the other instances
+	a.jobs = append(a.jobs, runner.Dispatch)
+
+	// Repack cached mirrors weekly, or early once they pile up loose
+	// objects or packs
+	maint := maintenance.NewJob(gitRunner, runner, cfg.CacheDir, cfg.Maintenance, clk)
+	if cfg.Maintenance.Enabled() {
+		a.every(maint.Run, cfg.MaintenanceCheckInterval)
+	}
+
+	// Snapshot every repository to object storage when a store is set
+	var backups *backup.Job
+	if cfg.Backup.Store != nil {
+		backups = backup.NewJob(db, gitRunner, runner, cfg.Backup, clk, gen)
+		a.every(backups.Run, cfg.BackupCheckInterval)
+	}
+
+	// Audit every target against its source, on schedule when an interval
+	// is set and otherwise on demand
+	audits := consistency.NewJob(db, runner, cfg.Consistency, clk, gen)
+	a.every(audits.Run, cfg.ConsistencyCheckInterval)
+
+	// Publish repository lifecycle and sync events to each configured
+	// Kafka or NATS sink
+	for _, sink := range events.NewSinks(cfg.Events) {
+		a.every(events.NewRelay(db, sink).Run, cfg.EventPublishInterval)
+	}
+
+	// Reconcile repositories declared in the GitOps state file
+	reconciler := gitops.NewReconciler(db, gitRunner, creds, cfg.URLPolicy, policies, cfg.Cache, clk, gen, cfg.GitOps)
+	a.every(reconciler.Run, cfg.GitOpsInterval)
+
+	// Replicate the configuration of the primary instance to its standby
+	federated := federation.NewSyncer(db, gitRunner, creds, cfg.URLPolicy, policies, cfg.Cache, clk, gen, cfg.Federation)
+	a.every(federated.Run, cfg.FederationInterval)
+
+	// Log browser users in through the OIDC provider, if one is set
+	logins := sessions.NewManager(db, cfg.Sessions, cfg.Identity.AdminGroups, clk)
+	a.every(logins.Run, cfg.SessionCleanupInterval)
+
+	h := handlers.NewHandler(handlers.Deps{
+		DB:                    db,
+		Webhooks:              hooks,
+		WebhookSecrets:        cfg.WebhookSecrets,
+		DeployKeys:            deployKeys,
+		Prober:                prober,
+		Credentials:           creds,
+		Client:                providerClient,
+		URLPolicy:             cfg.URLPolicy,
+		Notifier:              notifier,
+		Digest:                digests,
+		Policies:              policies,
+		Exports:               exporter,
+		GitOps:                reconciler,
+		Federation:            federated,
+		Runner:                runner,
+		Backups:               backups,
+		Consistency:           audits,
+		Maintenance:           maint,
+		Retention:             cfg.Retention,
+		Transfer:              cfg.Transfer,
+		RequireTargetApproval: cfg.RequireTargetApproval,
+		RBAC:                  cfg.RBAC,
+		Cache:                 cfg.Cache,
+		Clock:                 clk,
+		IDs:                   gen,
+		Build:                 build(cfg),
+		TrashRetention:        cfg.TrashRetention,
+		HostKeys:              hostKeys,
+		Sessions:              logins,
+	})
+	a.router = newRouter(h, cfg.Identity, logins, cfg.MaxRequestBodySize, cfg.RequestTimeouts, metrics.NewCollector(db, syncPool))
+	return nil
+}
+
+// build returns the build info of the server with the optional features
+// cfg enables
+func build(cfg Config) models.BuildInfo {
+	info := buildinfo.Get()
+	info.Features = map[string]bool{
+		"webhooks":              cfg.WebhookBaseURL != "",
+		"credential_encryption": cfg.CredentialKeys != nil,
+		"deploy_keys":           cfg.DeployKeys,
+		"compliance_export":     cfg.ComplianceSigningKey != "",
+		"size_limit":            cfg.MaxRepoSize > 0,
+		"mirror_verify":         cfg.MirrorVerify != replication.VerifyOff,
+		"secret_scan":           cfg.SecretScan != nil,
+		"anomaly_detection":     cfg.Anomalies.Enabled(),
+		"sync_window":           cfg.SyncWindow != nil,
+		"sync_schedule":         cfg.SyncSchedule != nil,
+		"backups":               cfg.Backup.Store != nil,
+		"consistency_audit":     cfg.Consistency.Interval > 0,
+		"events_kafka":          len(cfg.Events.Kafka.Brokers) > 0,
+		"events_nats":           len(cfg.Events.NATS.Servers) > 0,
+		"cache":                 cfg.Cache != nil,
+		"cache_store":           cfg.CacheStore != nil,
+		"target_approval":       cfg.RequireTargetApproval,
+		"rbac":                  cfg.RBAC,
+		"gitops":                cfg.GitOps.RepoURL != "",
+		"federation":            cfg.Federation.Enabled(),
+		"trash":                 cfg.TrashRetention > 0,
+		"ssh_host_keys":         cfg.SSHKnownHostsFile != "",
+		"sso_sessions":          cfg.Sessions.Enabled(),
+		"protocol_v2":           cfg.Transfer.ProtocolVersion != nil && *cfg.Transfer.ProtocolVersion == 2,
+		"tracing":               cfg.Tracing.Enabled,
+		// Set when the certificates of some provider instance are not verified
+		"insecure_tls": len(cfg.ProviderTLS.Insecure) > 0,
+	}
+	return info
+}
+
+// every schedules a periodic background loop
+func (a *App) every(run func(context.Context, time.Duration), interval time.Duration) {
+	a.jobs = append(a.jobs, func(ctx context.Context) { run(ctx, interval) })
+}
+
+// Handler returns the HTTP API
+func (a *App) Handler() http.Handler {
+	return a.router
+}
+
+// Start runs the background jobs until ctx is cancelled or Stop is called
+func (a *App) Start(ctx context.Context) {
+	ctx, a.cancel = context.WithCancel(ctx)
+	for _, job := range a.jobs {
+		a.wg.Add(1)
+		go func(job func(context.Context)) {
+			defer a.wg.Done()
+			job(ctx)
+		}(job)
+	}
+}
+
+// Stop cancels the background jobs and waits for them to return
+func (a *App) Stop() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.wg.Wait()
+}
+
+// Close stops the background jobs, exports the spans left and closes the
+// database connection if New opened it
+func (a *App) Close() error {
+	a.Stop()
+	var errs []error
+	if a.stopTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		errs = append(errs, a.stopTracing(ctx))
+		cancel()
+	}
+	if a.ownsDB && a.db != nil {
+		errs = append(errs, a.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// tracingShutdownTimeout bounds exporting the spans left on Close
+const tracingShutdownTimeout = 5 * time.Second
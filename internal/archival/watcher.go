@@ -0,0 +1,246 @@
+// Package archival detects source repositories that were archived or deleted
+// on their provider and propagates that state to the replication targets.
+package archival
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+	"gitsync/internal/provider"
+)
+
+// BannerBranch is the branch pushed to targets by the banner action
+const BannerBranch = "gitsync-source-archived"
+
+// Watcher polls source repositories for archival or deletion
+type Watcher struct {
+	DB          *database.DB
+	Client      *provider.Client
+	Credentials *credentials.Store
+	Git         *git.Runner
+	Notifier    *notify.Dispatcher
+	Cache       *cache.Cache
+	Clock       clock.Clock
+	// DefaultAction applies to repositories without their own archive_action
+	DefaultAction string
+}
+
+// NewWatcher creates a Watcher
+func NewWatcher(db *database.DB, client *provider.Client, creds *credentials.Store, runner *git.Runner, notifier *notify.Dispatcher, c *cache.Cache, clk clock.Clock, defaultAction string) *Watcher {
+	return &Watcher{
+		DB:            db,
+		Client:        client,
+		Credentials:   creds,
+		Git:           runner,
+		Notifier:      notifier,
+		Cache:         c,
+		Clock:         clk,
+		DefaultAction: defaultAction,
+	}
+}
+
+// CheckAll inspects every repository whose source is still considered active
+func (w *Watcher) CheckAll(ctx context.Context) error {
+	rows, err := w.DB.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, credential_id, source_state, archive_action, created_at
+		 FROM repositories WHERE source_state = $1`, models.SourceActive)
+	if err != nil {
+		return fmt.Errorf("failed to fetch repositories: %w", err)
+	}
+
+	var repos []models.Repository
+	for rows.Next() {
+		var repo models.Repository
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.CredentialID,
+			&repo.SourceState, &repo.ArchiveAction, &repo.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan repository: %w", err)
+		}
+		repos = append(repos, repo)
+	}
+	rows.Close()
+
+	for _, repo := range repos {
+		if err := w.Check(ctx, repo); err != nil {
+			log.Printf("WARN: source state check failed for repository %s: %v", repo.ID, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Check fetches the source metadata of repo and, when it is no longer
+// active, records the new state and applies the archive action
+func (w *Watcher) Check(ctx context.Context, repo models.Repository) error {
+	token, err := w.Credentials.Resolve(ctx, repo.CredentialID, repo.SourceProvider)
+	if err != nil {
+		return fmt.Errorf("failed to resolve credential: %w", err)
+	}
+
+	state := models.SourceActive
+	meta, err := w.Client.GetRepository(ctx, repo.SourceProvider, repo.SourceURL, token)
+	switch {
+	case provider.IsNotFound(err):
+		state = models.SourceDeleted
+	case err != nil:
+		return err
+	case meta.Archived:
+		state = models.SourceArchived
+	}
+
+	if _, err := w.DB.ExecContext(ctx,
+		`UPDATE repositories SET source_state = $2, source_checked_at = $3 WHERE id = $1`,
+		repo.ID, state, w.Clock.Now()); err != nil {
+		return fmt.Errorf("failed to record source state: %w", err)
+	}
+	if state == models.SourceActive {
+		return nil
+	}
+	w.Cache.Invalidate(ctx, cache.Repositories)
+
+	action := w.DefaultAction
+	if repo.ArchiveAction != nil {
+		action = *repo.ArchiveAction
+	}
+	log.Printf("Source of repository %s is %s; applying %q to targets", repo.ID, state, action)
+
+	eventType := notify.EventSourceArchived
+	if state == models.SourceDeleted {
+		eventType = notify.EventSourceDeleted
+	}
+	w.Notifier.Notify(notify.Event{
+		Type:           eventType,
+		Severity:       notify.SeverityWarning,
+		RepositoryID:   repo.ID,
+		RepositoryName: repo.Name,
+		Message:        fmt.Sprintf("Source of %s was %s; applying %q to targets", repo.Name, state, action),
+		Data:           map[string]any{"source_url": repo.SourceURL, "action": action},
+	})
+
+	if action == models.ArchiveActionFlag {
+		return nil
+	}
+	return w.applyToTargets(ctx, repo, state, action)
+}
+
+func (w *Watcher) applyToTargets(ctx context.Context, repo models.Repository, state, action string) error {
+	rows, err := w.DB.QueryContext(ctx,
+		`SELECT id, repository_id, provider, remote_url, credential_id, http_headers, approval_state, created_at
+		 FROM replication_targets WHERE repository_id = $1 AND approval_state = $2`, repo.ID, models.ApprovalApproved)
+	if err != nil {
+		return fmt.Errorf("failed to fetch targets: %w", err)
+	}
+
+	var targets []models.Target
+	for rows.Next() {
+		var t models.Target
+		if err := rows.Scan(&t.ID, &t.RepositoryID, &t.Provider, &t.RemoteURL, &t.CredentialID, &t.HTTPHeaders, &t.ApprovalState, &t.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan target: %w", err)
+		}
+		targets = append(targets, t)
+	}
+	rows.Close()
+
+	var failed []string
+	for _, t := range targets {
+		token, err := w.Credentials.Resolve(ctx, t.CredentialID, t.Provider)
+		if err == nil {
+			switch action {
+			case models.ArchiveActionArchive:
+				err = w.Client.ArchiveRepository(provider.WithHeaders(ctx, t.HTTPHeaders), t.Provider, t.RemoteURL, token)
+			case models.ArchiveActionBanner:
+				err = w.pushBanner(ctx, repo, t, state)
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR: failed to apply %q to target %s: %v", action, t.ID, err)
+			failed = append(failed, t.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s failed for targets %s", action, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// pushBanner pushes a single-commit branch whose README explains that the
+// mirror is no longer updated
+func (w *Watcher) pushBanner(ctx context.Context, repo models.Repository, target models.Target, state string) error {
+	dir, err := os.MkdirTemp("", "gitsync-banner-")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	readme := fmt.Sprintf("# %s\n\nThe source of this mirror (%s) was %s on %s.\n"+
+		"This repository is no longer updated by GitSync.\n",
+		repo.Name, repo.SourceURL, state, w.Clock.Now().UTC().Format("2006-01-02"))
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(readme), 0o644); err != nil {
+		return fmt.Errorf("failed to write README: %w", err)
+	}
+
+	identity := map[string]string{
+		"user.name":  "GitSync",
+		"user.email": "gitsync@localhost",
+	}
+	steps := [][]string{
+		{"init", "-q"},
+		{"add", "README.md"},
+		{"commit", "-q", "-m", "Source repository " + state},
+	}
+	for _, args := range steps {
+		if _, err := w.Git.Run(ctx, git.Command{Dir: dir, Args: args, Config: identity}); err != nil {
+			return err
+		}
+	}
+
+	auth, err := w.Credentials.GitAuth(ctx, target.CredentialID, target.Provider)
+	if err != nil {
+		return fmt.Errorf("failed to resolve target credential: %w", err)
+	}
+	if len(target.HTTPHeaders) > 0 {
+		if auth == nil {
+			auth = &git.Auth{}
+		}
+		auth.Headers = target.HTTPHeaders
+	}
+	_, err = w.Git.Run(ctx, git.Command{
+		Dir:  dir,
+		Args: []string{"push", "--force", target.RemoteURL, "HEAD:refs/heads/" + BannerBranch},
+		Auth: auth,
+	})
+	return err
+}
+
+// Run checks all repositories every interval until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.CheckAll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: source state check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Package audit records who changed what through the API.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/database"
+)
+
+// Anonymous is the actor recorded for requests without an identity
+const Anonymous = "anonymous"
+
+// System is the actor recorded for changes gitsync makes on its own, such
+// as holding a sync for approval
+const System = "system"
+
+// Actor returns the name recorded for the caller of a request
+func Actor(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return p.User
+	}
+	return Anonymous
+}
+
+// Record appends an entry to the audit log. Failures are logged rather than
+// returned so that a completed change is never reported as failed.
+func Record(ctx context.Context, db *database.DB, actor, action, resourceType, resourceID string, details map[string]any) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		log.Printf("ERROR: failed to encode audit details for %s: %v", action, err)
+		data = []byte("{}")
+	}
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO audit_log (actor, action, resource_type, resource_id, details) VALUES ($1, $2, $3, $4, $5)`,
+		actor, action, resourceType, resourceID, data); err != nil {
+		log.Printf("ERROR: failed to record audit entry %s on %s %s: %v", action, resourceType, resourceID, err)
+	}
+}
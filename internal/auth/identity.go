@@ -0,0 +1,100 @@
+// Package auth identifies the caller of an API request.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups"`
+	// Admin is set for members of the configured admin groups
+	Admin bool `json:"admin"`
+}
+
+// NewPrincipal returns the principal of user in groups, an admin when
+// one of groups is among adminGroups
+func NewPrincipal(user string, groups, adminGroups []string) *Principal {
+	p := &Principal{User: user, Groups: groups}
+	for _, g := range groups {
+		if slices.Contains(adminGroups, g) {
+			p.Admin = true
+		}
+	}
+	return p
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of the request, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// ProxyIdentity trusts the user and group headers set by an authenticating
+// reverse proxy in front of GitSync. The proxy must strip these headers from
+// client requests.
+type ProxyIdentity struct {
+	UserHeader   string
+	GroupsHeader string
+	AdminGroups  []string
+}
+
+// ProxyIdentityFromEnv reads AUTH_USER_HEADER, AUTH_GROUPS_HEADER and
+// AUTH_ADMIN_GROUPS, defaulting to X-Remote-User, X-Remote-Groups and
+// gitsync-admins
+func ProxyIdentityFromEnv() ProxyIdentity {
+	id := ProxyIdentity{UserHeader: "X-Remote-User", GroupsHeader: "X-Remote-Groups", AdminGroups: []string{"gitsync-admins"}}
+	if v := os.Getenv("AUTH_USER_HEADER"); v != "" {
+		id.UserHeader = v
+	}
+	if v := os.Getenv("AUTH_GROUPS_HEADER"); v != "" {
+		id.GroupsHeader = v
+	}
+	if v := os.Getenv("AUTH_ADMIN_GROUPS"); v != "" {
+		id.AdminGroups = splitList(v)
+	}
+	return id
+}
+
+func splitList(s string) []string {
+	out := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// IsAdmin reports whether the request was made by an admin
+func IsAdmin(ctx context.Context) bool {
+	p, ok := FromContext(ctx)
+	return ok && p.Admin
+}
+
+// Middleware attaches the principal named by the proxy headers to the
+// request context. Requests without a user header pass through anonymously.
+func (id ProxyIdentity) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimSpace(r.Header.Get(id.UserHeader))
+		if user == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p := NewPrincipal(user, splitList(r.Header.Get(id.GroupsHeader)), id.AdminGroups)
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
@@ -0,0 +1,325 @@
+// Package backup keeps periodic git bundle snapshots of every repository
+// in object storage, independently of the pushes to its targets.
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/objectstore"
+	"gitsync/internal/replication"
+)
+
+// Config sets where and how often repositories are snapshotted
+type Config struct {
+	// StoreURL is the object storage snapshots are kept in, in the form
+	// taken by objectstore.FromURL; snapshots are disabled when empty
+	StoreURL string
+	Store    objectstore.Store
+	// Interval is how often each repository is snapshotted
+	Interval time.Duration
+	// RetentionDays is how long snapshots are kept; the latest successful
+	// snapshot of a repository is kept regardless
+	RetentionDays int
+	// Concurrency bounds the snapshots in progress at once
+	Concurrency int
+}
+
+// ConfigFromEnv reads BACKUP_STORE, BACKUP_INTERVAL, BACKUP_RETENTION_DAYS
+// and BACKUP_CONCURRENCY, defaulting to daily snapshots kept for 30 days
+// and taken two at a time
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{StoreURL: os.Getenv("BACKUP_STORE"), Interval: 24 * time.Hour, RetentionDays: 30, Concurrency: 2}
+	store, err := objectstore.FromURL(cfg.StoreURL)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid BACKUP_STORE: %w", err)
+	}
+	cfg.Store = store
+	if raw := os.Getenv("BACKUP_INTERVAL"); raw != "" {
+		if cfg.Interval, err = time.ParseDuration(raw); err != nil || cfg.Interval <= 0 {
+			return cfg, fmt.Errorf("BACKUP_INTERVAL must be a positive duration")
+		}
+	}
+	for name, field := range map[string]*int{
+		"BACKUP_RETENTION_DAYS": &cfg.RetentionDays,
+		"BACKUP_CONCURRENCY":    &cfg.Concurrency,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("%s must be a positive number", name)
+		}
+		*field = n
+	}
+	return cfg, nil
+}
+
+// SnapshotColumns are the backup_snapshots columns read by ScanSnapshot
+const SnapshotColumns = `id, repository_id, repository_name, status, object_key, location, size_bytes, sha256, error, started_at, finished_at`
+
+// ScanSnapshot scans a row of SnapshotColumns
+func ScanSnapshot(row interface{ Scan(...any) error }) (models.BackupSnapshot, error) {
+	var s models.BackupSnapshot
+	err := row.Scan(&s.ID, &s.RepositoryID, &s.RepositoryName, &s.Status, &s.ObjectKey, &s.Location,
+		&s.SizeBytes, &s.SHA256, &s.Error, &s.StartedAt, &s.FinishedAt)
+	return s, err
+}
+
+// Job snapshots the repositories that are due. Snapshots run on the sync
+// runner, so they never overlap a sync of the same repository, and bundle
+// the mirror it keeps.
+type Job struct {
+	DB     *database.DB
+	Git    *git.Runner
+	Runner *replication.Runner
+	Config Config
+	Clock  clock.Clock
+	IDs    ids.Generator
+
+	inflight atomic.Int32
+}
+
+// NewJob creates a Job
+func NewJob(db *database.DB, runner *git.Runner, syncs *replication.Runner, cfg Config, clk clock.Clock, gen ids.Generator) *Job {
+	return &Job{DB: db, Git: runner, Runner: syncs, Config: cfg, Clock: clk, IDs: gen}
+}
+
+// Run queues due snapshots and prunes expired ones every interval until ctx
+// is cancelled
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		j.queueDue(ctx)
+		if err := j.prune(ctx); err != nil {
+			log.Printf("ERROR: failed to prune backup snapshots: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueDue queues the repositories whose latest snapshot is older than the
+// interval, those never snapshotted first. Partial clones are left out: a
+// bundle of a mirror lacking objects would not restore the repository.
+func (j *Job) queueDue(ctx context.Context) {
+	free := j.Config.Concurrency - int(j.inflight.Load())
+	if free <= 0 {
+		return
+	}
+	rows, err := j.DB.QueryContext(ctx,
+		`SELECT r.id FROM repositories r
+		 LEFT JOIN LATERAL (
+		     SELECT started_at FROM backup_snapshots s
+		     WHERE s.repository_id = r.id ORDER BY started_at DESC LIMIT 1
+		 ) s ON true
+		 WHERE r.clone_filter IS NULL AND r.size_exceeded_at IS NULL
+		   AND (s.started_at IS NULL OR s.started_at <= $1)
+		 ORDER BY s.started_at NULLS FIRST
+		 LIMIT $2`, j.Clock.Now().Add(-j.Config.Interval), free)
+	if err != nil {
+		log.Printf("ERROR: failed to find repositories due for a snapshot: %v", err)
+		return
+	}
+	var due []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Printf("ERROR: failed to scan repository due for a snapshot: %v", err)
+			return
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+
+	for _, id := range due {
+		j.inflight.Add(1)
+		queued := j.Runner.Submit(id, func(ctx context.Context) {
+			defer j.inflight.Add(-1)
+			j.snapshot(ctx, id)
+		})
+		// A repository busy syncing is picked up again next time
+		if !queued {
+			j.inflight.Add(-1)
+		}
+	}
+}
+
+// snapshot bundles every ref of the refreshed mirror of a repository,
+// uploads the bundle and records the outcome
+func (j *Job) snapshot(ctx context.Context, repoID string) {
+	repo, err := j.Runner.Repository(ctx, repoID)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+	snap := models.BackupSnapshot{
+		ID: j.IDs.NewID(), RepositoryID: repo.ID, RepositoryName: repo.Name,
+		Status: models.SnapshotRunning, StartedAt: j.Clock.Now(),
+	}
+	if _, err := j.DB.ExecContext(ctx,
+		`INSERT INTO backup_snapshots (id, repository_id, repository_name, status, started_at) VALUES ($1, $2, $3, $4, $5)`,
+		snap.ID, snap.RepositoryID, snap.RepositoryName, snap.Status, snap.StartedAt); err != nil {
+		log.Printf("ERROR: failed to record snapshot of repository %s: %v", repo.ID, err)
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s-%s.bundle", repo.ID, snap.StartedAt.UTC().Format("20060102T150405Z"), snap.ID)
+	location, size, sum, err := j.upload(ctx, repo, key)
+	if err == nil {
+		snap.Status, snap.ObjectKey, snap.Location, snap.SizeBytes, snap.SHA256 =
+			models.SnapshotSucceeded, &key, &location, &size, &sum
+	} else {
+		msg := err.Error()
+		snap.Status, snap.Error = models.SnapshotFailed, &msg
+		log.Printf("WARN: snapshot of repository %s failed: %v", repo.ID, err)
+	}
+	// The record is written even when ctx was cancelled mid-upload
+	if _, err := j.DB.ExecContext(context.WithoutCancel(ctx),
+		`UPDATE backup_snapshots
+		 SET status = $2, object_key = $3, location = $4, size_bytes = $5, sha256 = $6, error = $7, finished_at = $8
+		 WHERE id = $1`,
+		snap.ID, snap.Status, snap.ObjectKey, snap.Location, snap.SizeBytes, snap.SHA256, snap.Error, j.Clock.Now()); err != nil {
+		log.Printf("ERROR: failed to finish snapshot %s: %v", snap.ID, err)
+	}
+}
+
+// upload writes the bundle of repo to a temporary file and stores it under
+// key, returning its location, size and SHA-256
+func (j *Job) upload(ctx context.Context, repo models.Repository, key string) (string, int64, string, error) {
+	dir, err := j.Runner.Syncer.Mirror(ctx, repo)
+	if err != nil {
+		return "", 0, "", err
+	}
+	f, err := os.CreateTemp("", "gitsync-*.bundle")
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := j.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"bundle", "create", "--quiet", f.Name(), "--all"}}); err != nil {
+		return "", 0, "", err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, "", fmt.Errorf("failed to read bundle: %w", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	location, err := j.Config.Store.Put(ctx, key, f, size, sum)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return location, size, sum, nil
+}
+
+// prune deletes snapshots older than the retention period from the store
+// and then from the database. The latest successful snapshot of each
+// existing repository is kept.
+func (j *Job) prune(ctx context.Context) error {
+	cutoff := j.Clock.Now().AddDate(0, 0, -j.Config.RetentionDays)
+	rows, err := j.DB.QueryContext(ctx,
+		`SELECT id, object_key FROM backup_snapshots s
+		 WHERE started_at < $1
+		   AND NOT (status = $2
+		       AND EXISTS (SELECT 1 FROM repositories r WHERE r.id = s.repository_id)
+		       AND NOT EXISTS (
+		           SELECT 1 FROM backup_snapshots n
+		           WHERE n.repository_id = s.repository_id AND n.status = $2 AND n.started_at > s.started_at))
+		 ORDER BY started_at LIMIT 100`, cutoff, models.SnapshotSucceeded)
+	if err != nil {
+		return err
+	}
+	type expired struct {
+		id  string
+		key *string
+	}
+	var snapshots []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.key); err != nil {
+			rows.Close()
+			return err
+		}
+		snapshots = append(snapshots, e)
+	}
+	rows.Close()
+
+	pruned := 0
+	for _, e := range snapshots {
+		if e.key != nil {
+			if err := j.Config.Store.Delete(ctx, *e.key); err != nil {
+				log.Printf("WARN: failed to delete snapshot %s from the store: %v", e.id, err)
+				continue
+			}
+		}
+		if _, err := j.DB.ExecContext(ctx, `DELETE FROM backup_snapshots WHERE id = $1`, e.id); err != nil {
+			return err
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d expired backup snapshots", pruned)
+	}
+	return nil
+}
+
+// Status rolls up the latest finished snapshot of every repository that is
+// snapshotted
+func (j *Job) Status(ctx context.Context) (models.BackupStatus, error) {
+	st := models.BackupStatus{
+		Enabled:         true,
+		Store:           j.Config.StoreURL,
+		IntervalSeconds: int(j.Config.Interval.Seconds()),
+		RetentionDays:   j.Config.RetentionDays,
+	}
+	err := j.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*),
+		        COUNT(*) FILTER (WHERE l.status = $1 AND l.started_at > $3),
+		        COUNT(*) FILTER (WHERE l.status = $2)
+		 FROM repositories r
+		 LEFT JOIN LATERAL (
+		     SELECT status, started_at FROM backup_snapshots s
+		     WHERE s.repository_id = r.id AND s.status <> $4
+		     ORDER BY started_at DESC LIMIT 1
+		 ) l ON true
+		 WHERE r.clone_filter IS NULL`,
+		models.SnapshotSucceeded, models.SnapshotFailed, j.Clock.Now().Add(-2*j.Config.Interval), models.SnapshotRunning).
+		Scan(&st.Repositories, &st.Current, &st.Failing)
+	if err != nil {
+		return st, fmt.Errorf("failed to roll up backup status: %w", err)
+	}
+	st.Stale = st.Repositories - st.Current - st.Failing
+	err = j.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(size_bytes), 0), MAX(finished_at)
+		 FROM backup_snapshots WHERE status = $1`, models.SnapshotSucceeded).
+		Scan(&st.Snapshots, &st.StoredBytes, &st.LastSnapshotAt)
+	if err != nil {
+		return st, fmt.Errorf("failed to count backup snapshots: %w", err)
+	}
+	return st, nil
+}
@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"gitsync/internal/models"
+)
+
+// Restore downloads snap, checks it against its recorded SHA-256 and pushes
+// it to target. It runs on the sync runner so no sync of the repository
+// overwrites the target meanwhile, and returns the pushed refs. It fails
+// with replication.ErrBusy while the repository is syncing.
+func (j *Job) Restore(ctx context.Context, snap models.BackupSnapshot, target models.Target) ([]string, error) {
+	var refs []string
+	if err := j.Runner.Do(ctx, snap.RepositoryID, func(ctx context.Context) error {
+		var err error
+		refs, err = j.restore(ctx, snap, target)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func (j *Job) restore(ctx context.Context, snap models.BackupSnapshot, target models.Target) ([]string, error) {
+	if snap.Status != models.SnapshotSucceeded || snap.ObjectKey == nil {
+		return nil, fmt.Errorf("snapshot %s has no stored bundle", snap.ID)
+	}
+	auth, err := j.Runner.Syncer.TargetAuth(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve target credential: %w", err)
+	}
+	path, err := j.download(ctx, snap)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	return j.Runner.Syncer.Pusher.PushBundle(ctx, path, target, auth)
+}
+
+// download copies the bundle of snap to a temporary file and returns its
+// path, failing when its content does not match the recorded hash
+func (j *Job) download(ctx context.Context, snap models.BackupSnapshot) (string, error) {
+	body, err := j.Config.Store.Open(ctx, *snap.ObjectKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	defer body.Close()
+
+	f, err := os.CreateTemp("", "gitsync-*.bundle")
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); snap.SHA256 != nil && sum != *snap.SHA256 {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("snapshot %s is corrupt: SHA-256 %s, recorded %s", snap.ID, sum, *snap.SHA256)
+	}
+	return f.Name(), nil
+}
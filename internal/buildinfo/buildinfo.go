@@ -0,0 +1,49 @@
+// Package buildinfo identifies the running build of GitSync.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"gitsync/internal/models"
+)
+
+// Version, Commit and Date are set at link time, as in
+//
+//	go build -ldflags "-X gitsync/internal/buildinfo.Version=1.4.0 -X gitsync/internal/buildinfo.Commit=$(git rev-parse HEAD) -X gitsync/internal/buildinfo.Date=$(date -u +%FT%TZ)"
+//
+// Without them, Commit and Date fall back to the revision and commit time
+// Go stamps into binaries built from a checkout.
+var (
+	Version = "dev"
+	Commit  string
+	Date    string
+)
+
+// Get returns the build info of the running binary, without features
+func Get() models.BuildInfo {
+	info := models.BuildInfo{Version: Version, Commit: Commit, BuildDate: Date, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	modified := false
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified && Commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
@@ -0,0 +1,126 @@
+// Package cache keeps rendered responses of hot read endpoints, in process
+// or in Redis when several servers share one database.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Namespaces group entries that are invalidated together
+const (
+	Repositories = "repositories"
+)
+
+// Backend stores cache entries
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value; a zero ttl keeps it until it is overwritten
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Incr(ctx context.Context, key string) (int64, error)
+}
+
+// Cache stores entries per namespace. Invalidating a namespace bumps its
+// generation, which is part of every key, so stale entries are never read
+// again and simply expire. A nil Cache caches nothing.
+type Cache struct {
+	Backend Backend
+	TTL     time.Duration
+	// MaxEntryBytes bounds the size of a single entry
+	MaxEntryBytes int
+}
+
+// New creates a Cache
+func New(backend Backend, ttl time.Duration) *Cache {
+	return &Cache{Backend: backend, TTL: ttl, MaxEntryBytes: 8 << 20}
+}
+
+// FromEnv configures the cache from CACHE_TTL and REDIS_URL. A TTL of 0
+// disables caching; without REDIS_URL entries are kept in process.
+func FromEnv() (*Cache, error) {
+	ttl := 30 * time.Second
+	if v := os.Getenv("CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CACHE_TTL: %w", err)
+		}
+		ttl = d
+	}
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		redis, err := NewRedis(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return New(redis, ttl), nil
+	}
+	return New(NewMemory(), ttl), nil
+}
+
+// Generation returns the current generation of ns. It is read once before
+// the data is loaded so an invalidation during the load is not lost.
+func (c *Cache) Generation(ctx context.Context, ns string) int64 {
+	if c == nil {
+		return 0
+	}
+	v, ok, err := c.Backend.Get(ctx, genKey(ns))
+	if err != nil {
+		log.Printf("WARN: cache generation lookup failed: %v", err)
+		return 0
+	}
+	if !ok {
+		return 0
+	}
+	gen, _ := strconv.ParseInt(string(v), 10, 64)
+	return gen
+}
+
+// Get returns the entry stored under key in generation gen of ns
+func (c *Cache) Get(ctx context.Context, ns string, gen int64, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	v, ok, err := c.Backend.Get(ctx, entryKey(ns, gen, key))
+	if err != nil {
+		log.Printf("WARN: cache lookup failed: %v", err)
+		return nil, false
+	}
+	return v, ok
+}
+
+// Set stores value under key in generation gen of ns
+func (c *Cache) Set(ctx context.Context, ns string, gen int64, key string, value []byte) {
+	if c == nil || len(value) > c.MaxEntryBytes {
+		return
+	}
+	if err := c.Backend.Set(ctx, entryKey(ns, gen, key), value, c.TTL); err != nil {
+		log.Printf("WARN: cache store failed: %v", err)
+	}
+}
+
+// Invalidate drops every entry of ns
+func (c *Cache) Invalidate(ctx context.Context, ns string) {
+	if c == nil {
+		return
+	}
+	// The write is committed by then; a cancelled request must not leave
+	// its listings stale
+	if _, err := c.Backend.Incr(context.WithoutCancel(ctx), genKey(ns)); err != nil {
+		log.Printf("WARN: cache invalidation of %s failed: %v", ns, err)
+	}
+}
+
+func genKey(ns string) string {
+	return "gitsync:gen:" + ns
+}
+
+func entryKey(ns string, gen int64, key string) string {
+	return fmt.Sprintf("gitsync:%s:%d:%s", ns, gen, key)
+}
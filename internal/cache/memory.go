@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// Memory is an in-process Backend
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemory creates an empty Memory backend
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry), lastSweep: time.Now()}
+}
+
+// Get implements Backend
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || (!e.expires.IsZero() && time.Now().After(e.expires)) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Set implements Backend
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	m.entries[key] = e
+
+	// Entries of old generations are never read again; drop expired ones
+	// now and then so they do not accumulate
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, e := range m.entries {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	return nil
+}
+
+// Incr implements Backend
+func (m *Memory) Incr(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, _ := strconv.ParseInt(string(m.entries[key].value), 10, 64)
+	n++
+	m.entries[key] = memoryEntry{value: []byte(strconv.FormatInt(n, 10))}
+	return n, nil
+}
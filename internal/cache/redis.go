@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisTimeout bounds a single command when ctx has no earlier deadline
+const redisTimeout = 2 * time.Second
+
+// Redis is a Backend shared by all servers through a Redis instance. It
+// speaks just enough RESP for GET, SET and INCR over a small connection pool.
+type Redis struct {
+	Addr     string
+	Password string
+	DB       int
+
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewRedis parses a redis://[:password@]host[:port][/db] URL
+func NewRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	rd := &Redis{Addr: u.Host, idle: make(chan *redisConn, 8)}
+	if u.Port() == "" {
+		rd.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if pw, ok := u.User.Password(); ok {
+		rd.Password = pw
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if rd.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return rd, nil
+}
+
+// Get implements Backend
+func (rd *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := rd.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	if v == nil {
+		return nil, false, nil
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected GET reply %v", v)
+	}
+	return b, true, nil
+}
+
+// Set implements Backend
+func (rd *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := rd.do(ctx, args...)
+	return err
+}
+
+// Incr implements Backend
+func (rd *Redis) Incr(ctx context.Context, key string) (int64, error) {
+	v, err := rd.do(ctx, "INCR", key)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected INCR reply %v", v)
+	}
+	return n, nil
+}
+
+func (rd *Redis) do(ctx context.Context, args ...string) (any, error) {
+	conn, err := rd.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := conn.command(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection state is unknown after an I/O error
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case rd.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return v, err
+}
+
+func (rd *Redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-rd.idle:
+		return c, nil
+	default:
+	}
+
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	nc, err := d.DialContext(dialCtx, "tcp", rd.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if rd.Password != "" {
+		if _, err := c.command(ctx, "AUTH", rd.Password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("redis AUTH failed: %w", err)
+		}
+	}
+	if rd.DB != 0 {
+		if _, err := c.command(ctx, "SELECT", strconv.Itoa(rd.DB)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("redis SELECT failed: %w", err)
+		}
+	}
+	return c, nil
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// command sends args as a RESP array and reads one reply: nil, string,
+// int64 or []byte
+func (c *redisConn) command(ctx context.Context, args ...string) (any, error) {
+	deadline := time.Now().Add(redisTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
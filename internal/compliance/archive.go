@@ -0,0 +1,187 @@
+// Package compliance produces tamper-evident archives of the audit log and
+// sync history for evidence requests.
+//
+// Every record of a table carries chain_hash = sha256(previous chain_hash ||
+// record), where record is the JSON array of the record's other fields in
+// column order and the first previous hash is 64 zeros. Removing, reordering
+// or editing a record changes every later hash. The manifest lists each
+// file's SHA-256 and final chain hash and is signed with Ed25519.
+package compliance
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Archive formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// genesisHash precedes the first record of every chain
+var genesisHash = strings.Repeat("0", 64)
+
+// Table is an ordered set of records to archive
+type Table struct {
+	Name    string
+	Columns []string
+	Rows    [][]string
+}
+
+// FileEntry describes one archived table in the manifest
+type FileEntry struct {
+	Name      string `json:"name"`
+	Records   int    `json:"records"`
+	SHA256    string `json:"sha256"`
+	ChainHead string `json:"chain_head"`
+}
+
+// Manifest is signed to make the archive tamper-evident
+type Manifest struct {
+	PeriodFrom  time.Time   `json:"period_from"`
+	PeriodTo    time.Time   `json:"period_to"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	Format      string      `json:"format"`
+	HashChain   string      `json:"hash_chain"`
+	Files       []FileEntry `json:"files"`
+	// PublicKey is the base64 Ed25519 key that verifies manifest.json.sig
+	PublicKey string `json:"public_key"`
+}
+
+// chainHash links a record to its predecessor
+func chainHash(prev string, fields []string) (string, error) {
+	record, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(prev), record...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// encode renders t in format with a trailing chain_hash column, returning
+// the file content and the final chain hash
+func encode(t Table, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	head := genesisHash
+
+	switch format {
+	case FormatCSV:
+		w := csv.NewWriter(&buf)
+		if err := w.Write(append(append([]string{}, t.Columns...), "chain_hash")); err != nil {
+			return nil, "", err
+		}
+		for _, row := range t.Rows {
+			h, err := chainHash(head, row)
+			if err != nil {
+				return nil, "", err
+			}
+			head = h
+			if err := w.Write(append(append([]string{}, row...), h)); err != nil {
+				return nil, "", err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, "", err
+		}
+
+	case FormatJSON:
+		enc := json.NewEncoder(&buf)
+		for _, row := range t.Rows {
+			h, err := chainHash(head, row)
+			if err != nil {
+				return nil, "", err
+			}
+			head = h
+			obj := make(map[string]string, len(row)+1)
+			for i, col := range t.Columns {
+				obj[col] = row[i]
+			}
+			obj["chain_hash"] = h
+			if err := enc.Encode(obj); err != nil {
+				return nil, "", err
+			}
+		}
+
+	default:
+		return nil, "", fmt.Errorf("invalid format %q. allowed: csv, json", format)
+	}
+	return buf.Bytes(), head, nil
+}
+
+// Build writes the tables, the manifest and its signature, generated at
+// generated, into a gzipped tar archive
+func Build(tables []Table, format string, from, to, generated time.Time, key ed25519.PrivateKey) ([]byte, error) {
+	ext := "csv"
+	if format == FormatJSON {
+		ext = "jsonl"
+	}
+
+	manifest := Manifest{
+		PeriodFrom:  from,
+		PeriodTo:    to,
+		GeneratedAt: generated.UTC(),
+		Format:      format,
+		HashChain:   "chain_hash = sha256(previous chain_hash || JSON array of the record's fields); first previous = 64 zeros",
+		PublicKey:   base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+
+	type file struct {
+		name string
+		data []byte
+	}
+	var files []file
+	for _, t := range tables {
+		data, head, err := encode(t, format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", t.Name, err)
+		}
+		name := t.Name + "." + ext
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, FileEntry{
+			Name:      name,
+			Records:   len(t.Rows),
+			SHA256:    hex.EncodeToString(sum[:]),
+			ChainHead: head,
+		})
+		files = append(files, file{name, data})
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestJSON))
+	files = append(files, file{"manifest.json", manifestJSON}, file{"manifest.json.sig", []byte(signature + "\n")})
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: manifest.GeneratedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,202 @@
+package compliance
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/objectstore"
+)
+
+// Export statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// ErrNoSigningKey is returned when exports are requested without a key
+var ErrNoSigningKey = errors.New("compliance export signing key is not configured")
+
+// Exporter builds compliance archives, keeps them in Dir and uploads them
+// to S3 when configured
+type Exporter struct {
+	DB  *database.DB
+	Key ed25519.PrivateKey
+	Dir string
+	S3  *objectstore.S3
+	// Clock stamps the archives and the outcome of exports
+	Clock clock.Clock
+}
+
+// NewExporter creates an Exporter. key may be nil, in which case every
+// export fails with ErrNoSigningKey.
+func NewExporter(db *database.DB, key ed25519.PrivateKey, dir string, s3 *objectstore.S3, clk clock.Clock) *Exporter {
+	return &Exporter{DB: db, Key: key, Dir: dir, S3: s3, Clock: clk}
+}
+
+// ParseSigningKey decodes a base64 Ed25519 seed (32 bytes) or private key
+// (64 bytes). An empty string yields a nil key.
+func ParseSigningKey(raw string) (ed25519.PrivateKey, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	default:
+		return nil, fmt.Errorf("key must be %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(b))
+	}
+}
+
+// Enabled reports whether exports can be signed
+func (e *Exporter) Enabled() bool {
+	return e.Key != nil
+}
+
+// Run builds the archive of exp and records the outcome on its row
+func (e *Exporter) Run(ctx context.Context, exp models.ComplianceExport) {
+	if _, err := e.DB.ExecContext(ctx,
+		`UPDATE compliance_exports SET status = $2 WHERE id = $1`, exp.ID, StatusRunning); err != nil {
+		log.Printf("ERROR: failed to update compliance export %s: %v", exp.ID, err)
+	}
+
+	path, location, sum, err := e.export(ctx, exp)
+	if err != nil {
+		log.Printf("ERROR: compliance export %s failed: %v", exp.ID, err)
+		if _, dbErr := e.DB.ExecContext(ctx,
+			`UPDATE compliance_exports SET status = $2, error = $3, finished_at = $4 WHERE id = $1`,
+			exp.ID, StatusFailed, err.Error(), e.Clock.Now()); dbErr != nil {
+			log.Printf("ERROR: failed to update compliance export %s: %v", exp.ID, dbErr)
+		}
+		return
+	}
+
+	if _, err := e.DB.ExecContext(ctx,
+		`UPDATE compliance_exports SET status = $2, file_path = $3, location = $4, sha256 = $5, finished_at = $6
+		 WHERE id = $1`,
+		exp.ID, StatusSucceeded, path, location, sum, e.Clock.Now()); err != nil {
+		log.Printf("ERROR: failed to update compliance export %s: %v", exp.ID, err)
+	}
+}
+
+func (e *Exporter) export(ctx context.Context, exp models.ComplianceExport) (string, *string, string, error) {
+	if !e.Enabled() {
+		return "", nil, "", ErrNoSigningKey
+	}
+
+	auditLog, err := e.auditTable(ctx, exp.From, exp.To)
+	if err != nil {
+		return "", nil, "", err
+	}
+	syncs, err := e.syncTable(ctx, exp.From, exp.To)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	archive, err := Build([]Table{auditLog, syncs}, exp.Format, exp.From, exp.To, e.Clock.Now(), e.Key)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("failed to build archive: %w", err)
+	}
+	sum := sha256.Sum256(archive)
+
+	name := fmt.Sprintf("gitsync-compliance-%s-%s-%s.tar.gz",
+		exp.From.UTC().Format("20060102"), exp.To.UTC().Format("20060102"), exp.ID)
+	if err := os.MkdirAll(e.Dir, 0o750); err != nil {
+		return "", nil, "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+	path := filepath.Join(e.Dir, name)
+	if err := os.WriteFile(path, archive, 0o640); err != nil {
+		return "", nil, "", fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	var location *string
+	if e.S3 != nil {
+		loc, err := e.S3.Put(ctx, name, bytes.NewReader(archive), int64(len(archive)), hex.EncodeToString(sum[:]))
+		if err != nil {
+			return "", nil, "", err
+		}
+		location = &loc
+	}
+	return path, location, hex.EncodeToString(sum[:]), nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (e *Exporter) auditTable(ctx context.Context, from, to time.Time) (Table, error) {
+	t := Table{
+		Name:    "audit_log",
+		Columns: []string{"id", "created_at", "actor", "action", "resource_type", "resource_id", "details"},
+	}
+	rows, err := e.DB.QueryContext(ctx,
+		`SELECT id, created_at, actor, action, resource_type, resource_id, details
+		 FROM audit_log WHERE created_at >= $1 AND created_at < $2 ORDER BY id`, from, to)
+	if err != nil {
+		return t, fmt.Errorf("failed to fetch audit log: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var created time.Time
+		var actor, action, resourceType, resourceID, details string
+		if err := rows.Scan(&id, &created, &actor, &action, &resourceType, &resourceID, &details); err != nil {
+			return t, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		t.Rows = append(t.Rows, []string{
+			fmt.Sprint(id), formatTime(&created), actor, action, resourceType, resourceID, details,
+		})
+	}
+	return t, rows.Err()
+}
+
+func (e *Exporter) syncTable(ctx context.Context, from, to time.Time) (Table, error) {
+	t := Table{
+		Name:    "sync_history",
+		Columns: []string{"id", "repository_id", "target_id", "status", "error", "started_at", "finished_at"},
+	}
+	rows, err := e.DB.QueryContext(ctx,
+		`SELECT id, repository_id, target_id, status, error, started_at, finished_at
+		 FROM executions WHERE started_at >= $1 AND started_at < $2 ORDER BY started_at, id`, from, to)
+	if err != nil {
+		return t, fmt.Errorf("failed to fetch sync history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ex models.Execution
+		if err := rows.Scan(&ex.ID, &ex.RepositoryID, &ex.TargetID, &ex.Status, &ex.Error, &ex.StartedAt, &ex.FinishedAt); err != nil {
+			return t, fmt.Errorf("failed to scan execution: %w", err)
+		}
+		var errText string
+		if ex.Error != nil {
+			errText = *ex.Error
+		}
+		t.Rows = append(t.Rows, []string{
+			ex.ID, ex.RepositoryID, ex.TargetID, string(ex.Status), errText, formatTime(&ex.StartedAt), formatTime(ex.FinishedAt),
+		})
+	}
+	return t, rows.Err()
+}
@@ -0,0 +1,426 @@
+// Package consistency audits every target of every repository against its
+// source on a schedule, apart from syncs, to catch targets that drifted or
+// lost objects without a sync failing.
+package consistency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+
+	"github.com/lib/pq"
+)
+
+// Audit triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// ErrRunning is returned when an audit is started while another runs
+var ErrRunning = errors.New("a consistency audit is already running")
+
+// Config sets how often and how thoroughly targets are audited
+type Config struct {
+	// Interval is how often an audit starts; scheduled audits are disabled
+	// when zero, though audits can still be started through the API
+	Interval time.Duration
+	// Sample is how many refs of each target have their objects fetched;
+	// refs are only compared when zero
+	Sample int
+	// Concurrency bounds the repositories audited at once
+	Concurrency int
+	// Keep is how many audits are kept with their results
+	Keep int
+}
+
+// ConfigFromEnv reads CONSISTENCY_AUDIT_INTERVAL, CONSISTENCY_AUDIT_SAMPLE,
+// CONSISTENCY_AUDIT_CONCURRENCY and CONSISTENCY_AUDIT_KEEP, defaulting to
+// no scheduled audits, no sampling, two repositories at a time and the
+// last 30 audits kept
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Concurrency: 2, Keep: 30}
+	if raw := os.Getenv("CONSISTENCY_AUDIT_INTERVAL"); raw != "" {
+		var err error
+		if cfg.Interval, err = time.ParseDuration(raw); err != nil || cfg.Interval < 0 {
+			return cfg, fmt.Errorf("CONSISTENCY_AUDIT_INTERVAL must be a duration")
+		}
+	}
+	if raw := os.Getenv("CONSISTENCY_AUDIT_SAMPLE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("CONSISTENCY_AUDIT_SAMPLE must be a number")
+		}
+		cfg.Sample = n
+	}
+	for name, field := range map[string]*int{
+		"CONSISTENCY_AUDIT_CONCURRENCY": &cfg.Concurrency,
+		"CONSISTENCY_AUDIT_KEEP":        &cfg.Keep,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("%s must be a positive number", name)
+		}
+		*field = n
+	}
+	return cfg, nil
+}
+
+// AuditColumns are the consistency_audits columns read by ScanAudit
+const AuditColumns = `id, trigger, status, targets, in_sync, drifted, corrupt, errors, skipped, error, started_at, finished_at`
+
+// ScanAudit scans a row of AuditColumns
+func ScanAudit(row interface{ Scan(...any) error }) (models.ConsistencyAudit, error) {
+	var a models.ConsistencyAudit
+	err := row.Scan(&a.ID, &a.Trigger, &a.Status, &a.Targets, &a.InSync, &a.Drifted, &a.Corrupt, &a.Errors, &a.Skipped,
+		&a.Error, &a.StartedAt, &a.FinishedAt)
+	return a, err
+}
+
+// ResultColumns are the consistency_results columns, joined with
+// repositories r and replication_targets t, read by ScanResult
+const ResultColumns = `c.repository_id, r.name, c.target_id, t.remote_url, c.status, c.diff, c.sampled_refs, c.failed_samples, c.detail, c.checked_at`
+
+// ScanResult scans a row of ResultColumns
+func ScanResult(row interface{ Scan(...any) error }) (models.ConsistencyResult, error) {
+	var (
+		res  models.ConsistencyResult
+		diff []byte
+	)
+	err := row.Scan(&res.RepositoryID, &res.RepositoryName, &res.TargetID, &res.RemoteURL, &res.Status, &diff,
+		pq.Array(&res.SampledRefs), pq.Array(&res.FailedSamples), &res.Detail, &res.CheckedAt)
+	if err == nil && diff != nil {
+		res.Diff = &models.TargetDiff{}
+		err = json.Unmarshal(diff, res.Diff)
+	}
+	return res, err
+}
+
+// last is the latest finished audit, published under consistency_audit in
+// /debug/vars
+var (
+	lastMu sync.Mutex
+	last   models.ConsistencyAudit
+)
+
+func init() {
+	expvar.Publish("consistency_audit", expvar.Func(func() any {
+		lastMu.Lock()
+		defer lastMu.Unlock()
+		return last
+	}))
+}
+
+// Job audits every repository with an active source and approved targets.
+// Each repository is audited on the sync runner, so an audit never
+// overlaps a sync of it; repositories busy syncing are skipped.
+type Job struct {
+	DB     *database.DB
+	Runner *replication.Runner
+	Config Config
+	Clock  clock.Clock
+	IDs    ids.Generator
+
+	mu      sync.Mutex
+	running bool
+	ctx     context.Context
+}
+
+// NewJob creates a Job
+func NewJob(db *database.DB, runner *replication.Runner, cfg Config, clk clock.Clock, gen ids.Generator) *Job {
+	return &Job{DB: db, Runner: runner, Config: cfg, Clock: clk, IDs: gen, ctx: context.Background()}
+}
+
+// Run starts an audit whenever the last one started more than the
+// configured interval ago, checking every interval until ctx is cancelled.
+// Audits started through Start also end with ctx.
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	j.mu.Lock()
+	j.ctx = ctx
+	j.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if j.Config.Interval > 0 {
+			j.startDue(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startDue starts a scheduled audit when none started within the interval
+func (j *Job) startDue(ctx context.Context) {
+	var due bool
+	err := j.DB.QueryRowContext(ctx,
+		`SELECT NOT EXISTS (SELECT 1 FROM consistency_audits WHERE started_at > $1)`,
+		j.Clock.Now().Add(-j.Config.Interval)).Scan(&due)
+	if err != nil {
+		log.Printf("ERROR: failed to check for a due consistency audit: %v", err)
+		return
+	}
+	if !due {
+		return
+	}
+	if _, err := j.Start(TriggerSchedule); err != nil && !errors.Is(err, ErrRunning) {
+		log.Printf("ERROR: failed to start consistency audit: %v", err)
+	}
+}
+
+// Start records a new audit and runs it in the background, returning it
+// as started. It returns ErrRunning while another audit runs.
+func (j *Job) Start(trigger string) (models.ConsistencyAudit, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running {
+		return models.ConsistencyAudit{}, ErrRunning
+	}
+	audit := models.ConsistencyAudit{
+		ID: j.IDs.NewID(), Trigger: trigger, Status: models.ConsistencyAuditRunning, StartedAt: j.Clock.Now(),
+	}
+	// An audit left running by a restart is never finished
+	if _, err := j.DB.ExecContext(j.ctx,
+		`UPDATE consistency_audits SET status = $1, error = 'interrupted', finished_at = $2 WHERE status = $3`,
+		models.ConsistencyAuditFailed, audit.StartedAt, models.ConsistencyAuditRunning); err != nil {
+		return audit, fmt.Errorf("failed to close interrupted audits: %w", err)
+	}
+	if _, err := j.DB.ExecContext(j.ctx,
+		`INSERT INTO consistency_audits (id, trigger, status, started_at) VALUES ($1, $2, $3, $4)`,
+		audit.ID, audit.Trigger, audit.Status, audit.StartedAt); err != nil {
+		return audit, fmt.Errorf("failed to record consistency audit: %w", err)
+	}
+	j.running = true
+	go func(ctx context.Context) {
+		defer func() {
+			j.mu.Lock()
+			j.running = false
+			j.mu.Unlock()
+		}()
+		j.audit(ctx, audit)
+	}(j.ctx)
+	return audit, nil
+}
+
+// Running reports whether an audit is in progress
+func (j *Job) Running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.running
+}
+
+// audit checks every repository, records the results and totals of audit
+// and prunes older audits
+func (j *Job) audit(ctx context.Context, audit models.ConsistencyAudit) {
+	log.Printf("Consistency audit %s started (%s)", audit.ID, audit.Trigger)
+	repos, err := j.repositories(ctx)
+	if err == nil {
+		var mu sync.Mutex
+		sem := make(chan struct{}, j.Config.Concurrency)
+		var wg sync.WaitGroup
+		for _, id := range repos {
+			if ctx.Err() != nil {
+				break
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(id string) {
+				defer func() { <-sem; wg.Done() }()
+				results := j.check(ctx, audit.ID, id)
+				mu.Lock()
+				defer mu.Unlock()
+				for _, status := range results {
+					audit.Targets++
+					switch status {
+					case models.ConsistencyInSync:
+						audit.InSync++
+					case models.ConsistencyDrifted:
+						audit.Drifted++
+					case models.ConsistencyCorrupt:
+						audit.Corrupt++
+					case models.ConsistencySkipped:
+						audit.Skipped++
+					default:
+						audit.Errors++
+					}
+				}
+			}(id)
+		}
+		wg.Wait()
+		err = ctx.Err()
+	}
+
+	audit.Status = models.ConsistencyAuditCompleted
+	if err != nil {
+		msg := err.Error()
+		audit.Status, audit.Error = models.ConsistencyAuditFailed, &msg
+		log.Printf("ERROR: consistency audit %s failed: %v", audit.ID, err)
+	}
+	finished := j.Clock.Now()
+	audit.FinishedAt = &finished
+	// The totals are written even when ctx was cancelled mid-audit
+	wctx := context.WithoutCancel(ctx)
+	if _, err := j.DB.ExecContext(wctx,
+		`UPDATE consistency_audits
+		 SET status = $2, targets = $3, in_sync = $4, drifted = $5, corrupt = $6, errors = $7, skipped = $8, error = $9, finished_at = $10
+		 WHERE id = $1`,
+		audit.ID, audit.Status, audit.Targets, audit.InSync, audit.Drifted, audit.Corrupt, audit.Errors, audit.Skipped,
+		audit.Error, finished); err != nil {
+		log.Printf("ERROR: failed to finish consistency audit %s: %v", audit.ID, err)
+	}
+	lastMu.Lock()
+	last = audit
+	lastMu.Unlock()
+	log.Printf("Consistency audit %s %s: %d targets, %d in sync, %d drifted, %d corrupt, %d errors, %d skipped",
+		audit.ID, audit.Status, audit.Targets, audit.InSync, audit.Drifted, audit.Corrupt, audit.Errors, audit.Skipped)
+
+	if _, err := j.DB.ExecContext(wctx,
+		`DELETE FROM consistency_audits
+		 WHERE id NOT IN (SELECT id FROM consistency_audits ORDER BY started_at DESC LIMIT $1)`, j.Config.Keep); err != nil {
+		log.Printf("ERROR: failed to prune consistency audits: %v", err)
+	}
+}
+
+// repositories returns the IDs of the repositories to audit: those with an
+// active source and at least one approved target
+func (j *Job) repositories(ctx context.Context) ([]string, error) {
+	rows, err := j.DB.QueryContext(ctx,
+		`SELECT r.id FROM repositories r
+		 WHERE r.source_state = $1
+		   AND EXISTS (SELECT 1 FROM replication_targets t WHERE t.repository_id = r.id AND t.approval_state = $2)
+		 ORDER BY r.name`, models.SourceActive, models.ApprovalApproved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch repositories: %w", err)
+	}
+	defer rows.Close()
+	var repos []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan repository: %w", err)
+		}
+		repos = append(repos, id)
+	}
+	return repos, rows.Err()
+}
+
+// check audits the targets of one repository on the sync runner and
+// records a result for each, returning their statuses
+func (j *Job) check(ctx context.Context, auditID, repoID string) []string {
+	var checks []replication.TargetCheck
+	err := j.Runner.Do(ctx, repoID, func(ctx context.Context) error {
+		repo, err := j.Runner.Repository(ctx, repoID)
+		if err != nil {
+			return err
+		}
+		checks, err = j.Runner.Syncer.Check(ctx, repo, j.Config.Sample)
+		return err
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	if errors.Is(err, replication.ErrBusy) {
+		checks, err = j.skipped(ctx, repoID)
+	}
+	if err != nil {
+		log.Printf("WARN: consistency audit of repository %s failed: %v", repoID, err)
+		return nil
+	}
+
+	statuses := make([]string, 0, len(checks))
+	for _, c := range checks {
+		res := result(c)
+		var diff []byte
+		if c.Diff != nil {
+			if diff, err = json.Marshal(c.Diff); err != nil {
+				log.Printf("ERROR: failed to encode diff of target %s: %v", c.Target.ID, err)
+			}
+		}
+		if _, err := j.DB.ExecContext(context.WithoutCancel(ctx),
+			`INSERT INTO consistency_results (audit_id, repository_id, target_id, status, diff, sampled_refs, failed_samples, detail, checked_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			 ON CONFLICT DO NOTHING`,
+			auditID, repoID, c.Target.ID, res.Status, diff, pq.Array(res.SampledRefs), pq.Array(res.FailedSamples),
+			res.Detail, j.Clock.Now()); err != nil {
+			log.Printf("ERROR: failed to record consistency of target %s: %v", c.Target.ID, err)
+		}
+		statuses = append(statuses, res.Status)
+	}
+	return statuses
+}
+
+// skipped returns a check without a diff for each approved target of a
+// repository busy syncing
+func (j *Job) skipped(ctx context.Context, repoID string) ([]replication.TargetCheck, error) {
+	rows, err := j.DB.QueryContext(ctx,
+		`SELECT id FROM replication_targets WHERE repository_id = $1 AND approval_state = $2`, repoID, models.ApprovalApproved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch targets: %w", err)
+	}
+	defer rows.Close()
+	var checks []replication.TargetCheck
+	for rows.Next() {
+		var c replication.TargetCheck
+		if err := rows.Scan(&c.Target.ID); err != nil {
+			return nil, fmt.Errorf("failed to scan target: %w", err)
+		}
+		c.Err = replication.ErrBusy
+		checks = append(checks, c)
+	}
+	return checks, rows.Err()
+}
+
+// result classifies a check: drift in refs counts before failed samples,
+// as the samples of a drifted target are expected to differ
+func result(c replication.TargetCheck) models.ConsistencyResult {
+	res := models.ConsistencyResult{
+		TargetID: c.Target.ID, Diff: c.Diff, SampledRefs: c.SampledRefs, FailedSamples: c.FailedSamples,
+	}
+	if res.SampledRefs == nil {
+		res.SampledRefs = []string{}
+	}
+	if res.FailedSamples == nil {
+		res.FailedSamples = []string{}
+	}
+	switch {
+	case errors.Is(c.Err, replication.ErrBusy):
+		res.Status = models.ConsistencySkipped
+	case c.Diff == nil:
+		res.Status = models.ConsistencyError
+	case !c.Diff.InSync:
+		res.Status = models.ConsistencyDrifted
+	case len(c.FailedSamples) > 0:
+		res.Status = models.ConsistencyCorrupt
+		detail := strings.Join(c.FailedSamples, "; ")
+		res.Detail = &detail
+	case c.Err != nil:
+		res.Status = models.ConsistencyError
+	default:
+		res.Status = models.ConsistencyInSync
+	}
+	if c.Err != nil && res.Detail == nil {
+		detail := c.Err.Error()
+		res.Detail = &detail
+	}
+	return res
+}
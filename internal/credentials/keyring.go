@@ -0,0 +1,165 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Keyring seals the secrets of credential profiles at rest in AES-256-GCM
+// envelopes: each secret is encrypted with a data key of its own, which is
+// encrypted with a master key. Rows record the ID of their master key, so
+// master keys can be rotated: rows sealed with an earlier key are resealed
+// with the current one on startup, as long as the earlier key is listed.
+type Keyring struct {
+	// Current is the ID of the key new secrets are sealed with
+	Current string
+	Keys    map[string][]byte
+}
+
+// KeyringFromEnv reads the master key, 32 bytes in base64, from
+// CREDENTIALS_MASTER_KEY or the file named by CREDENTIALS_MASTER_KEY_FILE,
+// and its ID from CREDENTIALS_MASTER_KEY_ID, a fingerprint of the key when
+// unset. Earlier keys are listed in CREDENTIALS_PREVIOUS_KEYS as
+// comma-separated id:key pairs. It returns nil when no key is set, which
+// the server only accepts with CREDENTIALS_ALLOW_PLAINTEXT=true.
+func KeyringFromEnv() (*Keyring, error) {
+	raw := os.Getenv("CREDENTIALS_MASTER_KEY")
+	if file := os.Getenv("CREDENTIALS_MASTER_KEY_FILE"); file != "" {
+		if raw != "" {
+			return nil, errors.New("CREDENTIALS_MASTER_KEY and CREDENTIALS_MASTER_KEY_FILE cannot both be set")
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CREDENTIALS_MASTER_KEY_FILE: %w", err)
+		}
+		raw = string(data)
+	}
+	if raw == "" {
+		if os.Getenv("CREDENTIALS_PREVIOUS_KEYS") != "" {
+			return nil, errors.New("CREDENTIALS_PREVIOUS_KEYS requires a current master key")
+		}
+		return nil, nil
+	}
+	key, err := decodeKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials master key: %w", err)
+	}
+	id := strings.TrimSpace(os.Getenv("CREDENTIALS_MASTER_KEY_ID"))
+	if id == "" {
+		sum := sha256.Sum256(key)
+		id = hex.EncodeToString(sum[:4])
+	}
+
+	k := &Keyring{Current: id, Keys: map[string][]byte{id: key}}
+	for _, entry := range strings.Split(os.Getenv("CREDENTIALS_PREVIOUS_KEYS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prev, encoded, ok := strings.Cut(entry, ":")
+		if !ok || prev == "" {
+			return nil, errors.New("CREDENTIALS_PREVIOUS_KEYS must list id:key pairs")
+		}
+		if _, dup := k.Keys[prev]; dup {
+			return nil, fmt.Errorf("master key ID %s is listed twice", prev)
+		}
+		if k.Keys[prev], err = decodeKey(encoded); err != nil {
+			return nil, fmt.Errorf("invalid previous master key %s: %w", prev, err)
+		}
+	}
+	return k, nil
+}
+
+// decodeKey decodes a 256-bit key in base64
+func decodeKey(raw string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("must be 32 bytes in base64")
+	}
+	return key, nil
+}
+
+// Seal encrypts secret with the current master key, returning the envelope
+// to store and the ID of the key
+func (k *Keyring) Seal(secret string) (envelope, keyID string, err error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	// The key ID is authenticated with the data key, so an envelope cannot
+	// pass for one sealed with another key
+	wrapped, err := seal(k.Keys[k.Current], dataKey, []byte(k.Current))
+	if err != nil {
+		return "", "", err
+	}
+	sealed, err := seal(dataKey, []byte(secret), nil)
+	if err != nil {
+		return "", "", err
+	}
+	enc := base64.StdEncoding
+	return enc.EncodeToString(wrapped) + "." + enc.EncodeToString(sealed), k.Current, nil
+}
+
+// Open decrypts an envelope sealed with the master key keyID
+func (k *Keyring) Open(envelope, keyID string) (string, error) {
+	key, ok := k.Keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("master key %s is not configured", keyID)
+	}
+	wrappedText, sealedText, _ := strings.Cut(envelope, ".")
+	wrapped, err1 := base64.StdEncoding.DecodeString(wrappedText)
+	sealed, err2 := base64.StdEncoding.DecodeString(sealedText)
+	if err := errors.Join(err1, err2); err != nil {
+		return "", errors.New("malformed credential envelope")
+	}
+	dataKey, err := open(key, wrapped, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data key with master key %s: %w", keyID, err)
+	}
+	secret, err := open(dataKey, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// seal encrypts plaintext with key, prefixed with a random nonce
+func seal(key, plaintext, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, data), nil
+}
+
+// open decrypts the output of seal
+func open(key, sealed, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	n := gcm.NonceSize()
+	return gcm.Open(nil, sealed[:n], sealed[n:], data)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
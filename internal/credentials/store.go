@@ -0,0 +1,251 @@
+package credentials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+)
+
+// ErrNotFound is returned when a credential profile does not exist
+var ErrNotFound = errors.New("credential not found")
+
+// Store reads and writes credential profiles. All access to secret material
+// goes through the Store.
+type Store struct {
+	DB *database.DB
+	// Defaults maps a provider name to the token used for resources that
+	// do not reference a credential profile
+	Defaults map[string]string
+	// Keys encrypts secrets at rest; they are stored in plaintext when nil,
+	// which the server only allows when told to
+	Keys *Keyring
+}
+
+// NewStore creates a new credential Store
+func NewStore(db *database.DB, defaults map[string]string, keys *Keyring) *Store {
+	return &Store{DB: db, Defaults: defaults, Keys: keys}
+}
+
+// Seal returns the stored form of secret and the ID of the master key it
+// was sealed with, nil when stored in plaintext. Other secrets kept at
+// rest, such as those of webhooks, are sealed with it too.
+func (s *Store) Seal(secret string) (string, *string, error) {
+	if s.Keys == nil {
+		return secret, nil, nil
+	}
+	envelope, keyID, err := s.Keys.Seal(secret)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	return envelope, &keyID, nil
+}
+
+// Open returns the secret stored as stored, sealed with the master key
+// keyID unless nil
+func (s *Store) Open(stored string, keyID *string) (string, error) {
+	if keyID == nil {
+		return stored, nil
+	}
+	if s.Keys == nil {
+		return "", errors.New("secret is encrypted but no master key is configured")
+	}
+	return s.Keys.Open(stored, *keyID)
+}
+
+// Reseal encrypts the secrets stored in plaintext or sealed with an
+// earlier master key with the current one. Without a keyring it only
+// checks that no secret is encrypted, as none could be read.
+func (s *Store) Reseal(ctx context.Context) error {
+	if s.Keys == nil {
+		var sealed int
+		if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM credentials WHERE key_id IS NOT NULL`).Scan(&sealed); err != nil {
+			return fmt.Errorf("failed to count encrypted credentials: %w", err)
+		}
+		if sealed > 0 {
+			return fmt.Errorf("%d credential secrets are encrypted but no master key is configured", sealed)
+		}
+		log.Printf("WARN: no credentials master key is configured; credential secrets are stored in plaintext as allowed by CREDENTIALS_ALLOW_PLAINTEXT")
+		return nil
+	}
+
+	type row struct {
+		id, stored string
+		keyID      *string
+	}
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT id, secret, key_id FROM credentials WHERE key_id IS DISTINCT FROM $1`, s.Keys.Current)
+	if err != nil {
+		return fmt.Errorf("failed to fetch credentials to encrypt: %w", err)
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.stored, &r.keyID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan credential: %w", err)
+		}
+		stale = append(stale, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range stale {
+		secret, err := s.Open(r.stored, r.keyID)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt credential %s: %w", r.id, err)
+		}
+		stored, keyID, err := s.Seal(secret)
+		if err != nil {
+			return err
+		}
+		// Another instance may have resealed the row meanwhile
+		if _, err := s.DB.ExecContext(ctx,
+			`UPDATE credentials SET secret = $2, key_id = $3 WHERE id = $1 AND key_id IS NOT DISTINCT FROM $4`,
+			r.id, stored, keyID, r.keyID); err != nil {
+			return fmt.Errorf("failed to encrypt credential %s: %w", r.id, err)
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("Encrypted %d credential secrets with master key %s", len(stale), s.Keys.Current)
+	}
+	return nil
+}
+
+// Create inserts a new credential profile and fills in its ID and
+// timestamps, and its kind when empty
+func (s *Store) Create(ctx context.Context, cred *models.Credential, secret string) error {
+	if cred.Kind == "" {
+		cred.Kind = models.CredentialToken
+	}
+	stored, keyID, err := s.Seal(secret)
+	if err != nil {
+		return err
+	}
+	err = s.DB.QueryRowContext(ctx,
+		`INSERT INTO credentials (name, provider, host, secret, kind, username, key_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id, created_at, updated_at`,
+		cred.Name, cred.Provider, cred.Host, stored, cred.Kind, cred.Username, keyID).Scan(&cred.ID, &cred.CreatedAt, &cred.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert credential: %w", err)
+	}
+	return nil
+}
+
+// Get returns the credential profile with the given name
+func (s *Store) Get(ctx context.Context, name string) (*models.Credential, error) {
+	var cred models.Credential
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT `+Columns+` FROM credentials WHERE name = $1`, name).
+		Scan(&cred.ID, &cred.Name, &cred.Provider, &cred.Host, &cred.Kind, &cred.Username, &cred.CreatedAt, &cred.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch credential: %w", err)
+	}
+	return &cred, nil
+}
+
+// Replace swaps the secret of a credential profile in place, and its
+// username when set, so every repository and target referencing it picks
+// up the new value
+func (s *Store) Replace(ctx context.Context, id, username, secret string) error {
+	stored, keyID, err := s.Seal(secret)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx,
+		`UPDATE credentials SET secret = $2, key_id = $3, username = COALESCE(NULLIF($4, ''), username), updated_at = NOW()
+		 WHERE id = $1`,
+		id, stored, keyID, username)
+	if err != nil {
+		return fmt.Errorf("failed to update credential: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Columns are the credentials columns scanned into a models.Credential,
+// in order
+const Columns = `id, name, provider, host, kind, username, created_at, updated_at`
+
+// Material is the secret material of a credential profile
+type Material struct {
+	Kind     string
+	Username string
+	Secret   string
+}
+
+// Material returns the secret material of the credential with the given ID
+func (s *Store) Material(ctx context.Context, id string) (Material, error) {
+	var m Material
+	var username, keyID *string
+	var stored string
+	err := s.DB.QueryRowContext(ctx, `SELECT kind, username, secret, key_id FROM credentials WHERE id = $1`, id).
+		Scan(&m.Kind, &username, &stored, &keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, ErrNotFound
+	}
+	if err != nil {
+		return m, fmt.Errorf("failed to fetch credential secret: %w", err)
+	}
+	if username != nil {
+		m.Username = *username
+	}
+	if m.Secret, err = s.Open(stored, keyID); err != nil {
+		return m, fmt.Errorf("failed to decrypt credential %s: %w", id, err)
+	}
+	return m, nil
+}
+
+// Resolve returns the API token for a resource: the secret of its
+// credential profile when it has one, otherwise the provider default
+// (possibly empty). Credentials other than tokens only authenticate git, so
+// resources referencing one have no token.
+func (s *Store) Resolve(ctx context.Context, credentialID *string, providerName string) (string, error) {
+	if credentialID == nil {
+		return s.Defaults[providerName], nil
+	}
+	m, err := s.Material(ctx, *credentialID)
+	if err != nil || m.Kind != models.CredentialToken {
+		return "", err
+	}
+	return m.Secret, nil
+}
+
+// GitAuth returns the git credentials for a resource of the given
+// provider, from its credential profile or else the provider default. It
+// returns nil when there are none.
+func (s *Store) GitAuth(ctx context.Context, credentialID *string, providerName string) (*git.Auth, error) {
+	m := Material{Kind: models.CredentialToken, Secret: s.Defaults[providerName]}
+	if credentialID != nil {
+		var err error
+		if m, err = s.Material(ctx, *credentialID); err != nil {
+			return nil, err
+		}
+	}
+	switch m.Kind {
+	case models.CredentialBasic:
+		return &git.Auth{Username: m.Username, Password: m.Secret}, nil
+	case models.CredentialSSHKey:
+		return &git.Auth{SSHKey: m.Secret}, nil
+	}
+	p, ok := provider.Get(providerName)
+	if !ok || m.Secret == "" {
+		return nil, nil
+	}
+	user, pass := p.GitAuth(m.Secret)
+	return &git.Auth{Username: user, Password: pass}, nil
+}
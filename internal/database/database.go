@@ -1,13 +1,23 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+
+	"gitsync/internal/tracing"
 
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DB is the connection pool. Queries run through its context methods are
+// traced.
 type DB struct {
 	*sql.DB
 }
@@ -42,3 +52,93 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// ExecContext is sql.DB.ExecContext, traced
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuery(ctx, query)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return res, err
+}
+
+// QueryContext is sql.DB.QueryContext, traced until its rows are closed
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	ctx, span := startQuery(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, span: span}, nil
+}
+
+// QueryRowContext is sql.DB.QueryRowContext, traced until its row is
+// scanned
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	ctx, span := startQuery(ctx, query)
+	return &Row{Row: db.DB.QueryRowContext(ctx, query, args...), span: span}
+}
+
+// Rows are the rows of a traced query. The span of the query ends when
+// they are closed, as they are once Next returns false, so it covers
+// reading them.
+type Rows struct {
+	*sql.Rows
+	span trace.Span
+	once sync.Once
+}
+
+// Next is sql.Rows.Next, ending the span after the last row
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.end()
+	return false
+}
+
+// Close is sql.Rows.Close, ending the span
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.end()
+	return err
+}
+
+func (r *Rows) end() {
+	r.once.Do(func() { tracing.End(r.span, r.Rows.Err()) })
+}
+
+// Row is the row of a traced query, whose span ends when it is scanned
+type Row struct {
+	*sql.Row
+	span trace.Span
+}
+
+// Scan is sql.Row.Scan, ending the span. Finding no row is not an error of
+// the query.
+func (r *Row) Scan(dest ...any) error {
+	err := r.Row.Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		tracing.End(r.span, nil)
+	} else {
+		tracing.End(r.span, err)
+	}
+	return err
+}
+
+// startQuery starts the span of a query, named after its SQL verb such as
+// SELECT. The query is recorded without its arguments. Queries outside of
+// a trace, such as the polls of background jobs, are not traced.
+func startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	if parent := trace.SpanFromContext(ctx); !parent.SpanContext().IsValid() {
+		return ctx, parent
+	}
+	verb := "QUERY"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		verb = strings.ToUpper(fields[0])
+	}
+	return tracing.Start(ctx, verb,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation.name", verb),
+		attribute.String("db.query.text", query))
+}
@@ -0,0 +1,2 @@
+-- Throttled executions record when the provider will accept calls again
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS retry_at TIMESTAMP;
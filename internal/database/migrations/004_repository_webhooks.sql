@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS repository_webhooks (
+    repository_id UUID PRIMARY KEY REFERENCES repositories(id) ON DELETE CASCADE,
+    hook_id TEXT,
+    hook_url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    status TEXT NOT NULL,
+    last_error TEXT,
+    last_checked_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
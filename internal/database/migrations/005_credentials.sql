@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS credentials (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    provider TEXT NOT NULL,
+    host TEXT,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS credential_id UUID REFERENCES credentials(id);
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS credential_id UUID REFERENCES credentials(id);
+
+CREATE INDEX IF NOT EXISTS idx_repositories_credential_id ON repositories(credential_id);
+CREATE INDEX IF NOT EXISTS idx_replication_targets_credential_id ON replication_targets(credential_id);
@@ -0,0 +1,7 @@
+-- Lifecycle of the source repository as last observed on the provider
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS source_state TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS source_checked_at TIMESTAMP;
+
+-- Action applied to targets once the source is archived or deleted;
+-- NULL uses the server-wide default
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS archive_action TEXT;
@@ -0,0 +1,6 @@
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS idx_repositories_labels ON repositories USING GIN (labels);
+
+-- Channels may be limited to specific repositories or a label selector
+ALTER TABLE notification_channels ADD COLUMN IF NOT EXISTS repository_ids UUID[] NOT NULL DEFAULT '{}';
+ALTER TABLE notification_channels ADD COLUMN IF NOT EXISTS label_selector TEXT;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS notification_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    priority INT NOT NULL DEFAULT 100,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    label_selector TEXT,
+    providers TEXT[] NOT NULL DEFAULT '{}',
+    min_severity TEXT,
+    active_from TEXT,
+    active_to TEXT,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    channel_ids UUID[] NOT NULL,
+    stop BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
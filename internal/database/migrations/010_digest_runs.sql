@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS digest_runs (
+    period TEXT NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (period, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_executions_started_at ON executions(started_at);
@@ -0,0 +1,17 @@
+-- Repositories with a sync SLO are monitored for targets that fall behind
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS sync_slo_seconds INT;
+
+-- Credential used by incident channels (PagerDuty routing key, Opsgenie API key)
+ALTER TABLE notification_channels ADD COLUMN IF NOT EXISTS secret TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS slo_breaches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    opened_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP
+);
+
+-- At most one open breach per target
+CREATE UNIQUE INDEX IF NOT EXISTS idx_slo_breaches_open
+ON slo_breaches(target_id) WHERE resolved_at IS NULL;
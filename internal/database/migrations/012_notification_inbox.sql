@@ -0,0 +1,27 @@
+-- Events shown in the dashboard inbox
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    event_type TEXT NOT NULL,
+    severity TEXT NOT NULL,
+    title TEXT NOT NULL,
+    message TEXT NOT NULL,
+    repository_id UUID REFERENCES repositories(id) ON DELETE CASCADE,
+    target_id UUID REFERENCES replication_targets(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_created_at ON notifications(created_at DESC);
+
+-- Individually read notifications per user
+CREATE TABLE IF NOT EXISTS notification_reads (
+    notification_id UUID NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    user_name TEXT NOT NULL,
+    read_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (notification_id, user_name)
+);
+
+-- "Mark all as read": everything created up to read_before counts as read
+CREATE TABLE IF NOT EXISTS notification_read_marks (
+    user_name TEXT PRIMARY KEY,
+    read_before TIMESTAMP NOT NULL
+);
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS policies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    kind TEXT NOT NULL,
+    -- Repositories the policy applies to; NULL means every repository
+    label_selector TEXT,
+    domains TEXT[] NOT NULL DEFAULT '{}',
+    providers TEXT[] NOT NULL DEFAULT '{}',
+    scope TEXT NOT NULL DEFAULT 'all',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS policy_violations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    policy_id UUID REFERENCES policies(id) ON DELETE SET NULL,
+    policy_name TEXT NOT NULL,
+    stage TEXT NOT NULL,
+    repository_id UUID REFERENCES repositories(id) ON DELETE CASCADE,
+    target_id UUID REFERENCES replication_targets(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_policy_violations_created_at ON policy_violations(created_at DESC);
@@ -0,0 +1,5 @@
+-- Targets created by non-admins may need approval before they are synced
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS approval_state TEXT NOT NULL DEFAULT 'approved';
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS created_by TEXT;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS approved_by TEXT;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS approved_at TIMESTAMP;
@@ -0,0 +1,4 @@
+-- Per-repository overrides of the global retention defaults, in days
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS history_retention_days INT;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS log_retention_days INT;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS cache_retention_days INT;
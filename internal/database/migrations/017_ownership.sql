@@ -0,0 +1,4 @@
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS owner TEXT;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS team TEXT;
+CREATE INDEX IF NOT EXISTS idx_repositories_owner ON repositories(owner);
+CREATE INDEX IF NOT EXISTS idx_repositories_team ON repositories(team);
@@ -0,0 +1,8 @@
+-- Last SHA pushed per ref to each target, so unchanged sources skip the push
+CREATE TABLE IF NOT EXISTS target_refs (
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    ref TEXT NOT NULL,
+    sha TEXT NOT NULL,
+    pushed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (target_id, ref)
+);
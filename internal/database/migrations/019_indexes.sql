@@ -0,0 +1,19 @@
+-- Source URLs are normalized before they are stored, so equal URLs are
+-- duplicates; the index also serves the existence checks on create
+CREATE UNIQUE INDEX IF NOT EXISTS idx_repositories_source_url ON repositories(source_url);
+
+-- A repository replicates to each remote once. The composite index also
+-- covers lookups by repository alone.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_replication_targets_repository_remote
+ON replication_targets(repository_id, remote_url);
+DROP INDEX IF EXISTS idx_replication_targets_repository_id;
+
+-- Repository listing order
+CREATE INDEX IF NOT EXISTS idx_repositories_created_at ON repositories(created_at DESC, id);
+
+-- Last successful sync per target (SLO monitor, digest drift)
+CREATE INDEX IF NOT EXISTS idx_executions_target_status_finished
+ON executions(target_id, status, finished_at DESC);
+
+-- Retention deletes and cascading lookups by repository
+CREATE INDEX IF NOT EXISTS idx_executions_repository_id ON executions(repository_id, started_at);
@@ -0,0 +1,2 @@
+-- Partial clone filter for the cached mirror of very large repositories
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS clone_filter TEXT;
@@ -0,0 +1,3 @@
+-- Repositories in the same cache pool share one object store; without a
+-- pool the source URL is used, so duplicates of one upstream share anyway
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS cache_pool TEXT;
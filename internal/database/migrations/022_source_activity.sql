@@ -0,0 +1,3 @@
+-- When the source refs last changed, for activity-aware sync intervals
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS source_refs_hash TEXT;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS source_changed_at TIMESTAMP;
@@ -0,0 +1,3 @@
+-- Repositories declared in the GitOps state file, and fixed sync intervals
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS managed_by TEXT;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS sync_interval_seconds INTEGER;
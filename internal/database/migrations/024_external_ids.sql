@@ -0,0 +1,13 @@
+-- Caller-assigned identifiers for cross-referencing resources, such as the
+-- addresses of an infrastructure-as-code tool
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE policies ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE notification_rules ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE notification_channels ADD COLUMN IF NOT EXISTS external_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_repositories_external_id ON repositories (external_id) WHERE external_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_replication_targets_external_id ON replication_targets (external_id) WHERE external_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_policies_external_id ON policies (external_id) WHERE external_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_rules_external_id ON notification_rules (external_id) WHERE external_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_channels_external_id ON notification_channels (external_id) WHERE external_id IS NOT NULL;
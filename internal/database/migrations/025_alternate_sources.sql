@@ -0,0 +1,5 @@
+-- Fallback source URLs tried in order when the primary source is unreachable
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS alternate_source_urls TEXT[] NOT NULL DEFAULT '{}';
+
+-- The source URL a sync fetched from
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS source_url TEXT;
@@ -0,0 +1,4 @@
+-- Targets are pushed in ascending priority. A sync succeeds when all of its
+-- required targets succeed; best-effort targets may fail.
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 100;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS required BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,38 @@
+-- Named sets of repositories that are synced, paused and scheduled together
+CREATE TABLE IF NOT EXISTS sync_groups (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    -- Members of a paused group are skipped by scheduled syncs
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Sync interval of members without their own; NULL keeps the adaptive schedule
+    sync_interval_seconds INTEGER,
+    external_id TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sync_groups_external_id ON sync_groups (external_id) WHERE external_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS sync_group_members (
+    group_id UUID NOT NULL REFERENCES sync_groups(id) ON DELETE CASCADE,
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, repository_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_group_members_repository_id ON sync_group_members(repository_id);
+
+-- One manual sync of a whole group; the counts roll up the member syncs
+CREATE TABLE IF NOT EXISTS sync_group_runs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    group_id UUID NOT NULL REFERENCES sync_groups(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    triggered_by TEXT,
+    repositories INTEGER NOT NULL,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_group_runs_group_id ON sync_group_runs(group_id, started_at DESC);
@@ -0,0 +1,3 @@
+-- Daily window during which scheduled syncs of a repository may start,
+-- as {"start": "22:00", "end": "06:00", "timezone": "Europe/Berlin"}
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS sync_window JSONB;
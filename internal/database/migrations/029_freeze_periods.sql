@@ -0,0 +1,13 @@
+-- Organization-wide periods, such as release weekends or provider
+-- maintenance, during which automatic syncing is suspended
+CREATE TABLE IF NOT EXISTS freeze_periods (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    reason TEXT,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL CHECK (ends_at > starts_at),
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_freeze_periods_ends_at ON freeze_periods(ends_at);
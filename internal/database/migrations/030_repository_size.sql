@@ -0,0 +1,4 @@
+-- Set when a sync was aborted because the repository exceeds the size cap;
+-- flagged repositories are left out of scheduled syncs until a sync succeeds
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS size_exceeded_at TIMESTAMP;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS size_bytes BIGINT;
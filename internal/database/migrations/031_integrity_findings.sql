@@ -0,0 +1,13 @@
+-- Corruption found by mirror verification: a cached mirror failing git
+-- fsck, or a target whose refs do not match what was pushed
+CREATE TABLE IF NOT EXISTS integrity_findings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    target_id UUID REFERENCES replication_targets(id) ON DELETE CASCADE,
+    execution_id UUID REFERENCES executions(id) ON DELETE SET NULL,
+    kind TEXT NOT NULL,
+    detail TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_integrity_findings_repository ON integrity_findings(repository_id, created_at DESC);
@@ -0,0 +1,18 @@
+-- git bundle snapshots of repositories kept in object storage. Snapshots
+-- outlive their repository so they remain available for restores until
+-- retention removes them.
+CREATE TABLE IF NOT EXISTS backup_snapshots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    repository_id UUID NOT NULL,
+    repository_name TEXT NOT NULL,
+    status TEXT NOT NULL,
+    object_key TEXT,
+    location TEXT,
+    size_bytes BIGINT,
+    sha256 TEXT,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_backup_snapshots_repository ON backup_snapshots(repository_id, started_at DESC);
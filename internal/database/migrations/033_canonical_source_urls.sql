@@ -0,0 +1,17 @@
+-- Host and path of the source URL, which identifies the upstream whatever
+-- scheme, user or port it is cloned over (provider.CanonicalURL). Source
+-- URLs are stored normalized as scheme://[user@]host[:port]/path.
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS canonical_url TEXT
+    GENERATED ALWAYS AS (regexp_replace(source_url, '^[a-z]+://([^@/]+@)?([^/:]+)(:[0-9]+)?/', '\2/')) STORED;
+
+-- Repositories mirroring the same upstream under different URLs before
+-- this migration are left as they are; the index is created once they are
+-- merged and the migration runs again
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM repositories GROUP BY canonical_url HAVING COUNT(*) > 1) THEN
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_repositories_canonical_url ON repositories (canonical_url);
+    ELSE
+        RAISE WARNING 'repositories share a canonical source URL; idx_repositories_canonical_url not created';
+    END IF;
+END $$;
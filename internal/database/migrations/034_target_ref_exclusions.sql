@@ -0,0 +1,2 @@
+-- Ref patterns a target never receives, whatever the source holds
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS exclude_refs TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,5 @@
+-- Targets in tags mode receive only tags, optionally only annotated ones
+-- matching tag_pattern
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS mode TEXT NOT NULL DEFAULT 'all';
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS tag_pattern TEXT;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS annotated_tags_only BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,9 @@
+-- Position of each event relay in the audit log and the executions
+CREATE TABLE IF NOT EXISTS event_cursors (
+    name TEXT PRIMARY KEY,
+    audit_id BIGINT NOT NULL,
+    execution_finished_at TIMESTAMP NOT NULL,
+    execution_id UUID NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_executions_finished_at ON executions (finished_at, id) WHERE finished_at IS NOT NULL;
@@ -0,0 +1,21 @@
+-- Possible credentials found in commits about to be pushed. A finding is
+-- kept once per repository; allowing it lets the commits holding it through.
+CREATE TABLE IF NOT EXISTS secret_findings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    target_id UUID REFERENCES replication_targets(id) ON DELETE SET NULL,
+    execution_id UUID REFERENCES executions(id) ON DELETE SET NULL,
+    rule TEXT NOT NULL,
+    commit_sha TEXT NOT NULL,
+    path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    match TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    allowed BOOLEAN NOT NULL DEFAULT FALSE,
+    allowed_by TEXT,
+    allowed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (repository_id, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS idx_secret_findings_repository ON secret_findings(repository_id, created_at DESC);
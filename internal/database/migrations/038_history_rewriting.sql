@@ -0,0 +1,15 @@
+-- Targets with rewrite rules receive a filtered history. rewrite_pushed is
+-- the hash of the rules the history on the target was rewritten with, NULL
+-- when it is the source history.
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS rewrite JSONB;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS rewrite_pushed TEXT;
+
+-- The rewritten commit of every source commit rewritten for a target, kept
+-- to check that rewriting the history again gives the same commits
+CREATE TABLE IF NOT EXISTS rewritten_commits (
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    rules_hash TEXT NOT NULL,
+    source_sha TEXT NOT NULL,
+    rewritten_sha TEXT NOT NULL,
+    PRIMARY KEY (target_id, rules_hash, source_sha)
+);
@@ -0,0 +1,3 @@
+-- Targets in subdirectory mode receive the history of one directory of the
+-- source as the root of their repository
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS subdirectory TEXT;
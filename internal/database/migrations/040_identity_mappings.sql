@@ -0,0 +1,10 @@
+-- Versions of the identity rules of a target; the latest one rewrites the
+-- authors, committers and taggers of the history pushed to it
+CREATE TABLE IF NOT EXISTS identity_mappings (
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    rules JSONB NOT NULL,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (target_id, version)
+);
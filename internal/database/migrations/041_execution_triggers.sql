@@ -0,0 +1,3 @@
+-- What started the sync an execution belongs to, such as schedule:hot for a
+-- scheduled sync with the reason of its interval
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS trigger_reason TEXT;
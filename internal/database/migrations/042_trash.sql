@@ -0,0 +1,16 @@
+-- Deleted repositories and targets with the rows of their configuration and
+-- sync history, kept as JSON until expires_at so they can be restored
+CREATE TABLE IF NOT EXISTS trash (
+    id UUID PRIMARY KEY,
+    resource_type TEXT NOT NULL,
+    resource_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    repository_id UUID,
+    team TEXT,
+    data JSONB NOT NULL,
+    deleted_by TEXT,
+    deleted_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trash_expires_at ON trash (expires_at);
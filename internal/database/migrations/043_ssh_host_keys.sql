@@ -0,0 +1,13 @@
+-- Public keys of SSH hosts written to the managed known_hosts file, pinned
+-- through the API or trusted on first use
+CREATE TABLE IF NOT EXISTS ssh_host_keys (
+    id UUID PRIMARY KEY,
+    host TEXT NOT NULL,
+    key_type TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    source TEXT NOT NULL,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (host, key_type, public_key)
+);
@@ -0,0 +1,2 @@
+-- Extra HTTP headers sent with git and provider API requests to a target
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS http_headers JSONB;
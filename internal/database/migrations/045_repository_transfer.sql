@@ -0,0 +1,2 @@
+-- Per-repository overrides of the global git transfer settings
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS transfer JSONB;
@@ -0,0 +1,25 @@
+-- Targets whose force pushes and ref deletions need the approval of two
+-- operators
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS protected BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Force pushes and ref deletions held for approval; a target has at most
+-- one open approval, pending or approved but not yet pushed
+CREATE TABLE IF NOT EXISTS push_approvals (
+    id UUID PRIMARY KEY,
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    execution_id UUID REFERENCES executions(id) ON DELETE SET NULL,
+    status TEXT NOT NULL,
+    forced TEXT[] NOT NULL DEFAULT '{}',
+    deleted TEXT[] NOT NULL DEFAULT '{}',
+    fingerprint TEXT NOT NULL,
+    approved_by TEXT[] NOT NULL DEFAULT '{}',
+    rejected_by TEXT,
+    decided_at TIMESTAMP,
+    executed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_push_approvals_open ON push_approvals (target_id)
+    WHERE status IN ('pending', 'approved');
+CREATE INDEX IF NOT EXISTS idx_push_approvals_status ON push_approvals (status, created_at);
@@ -0,0 +1,17 @@
+-- Anomalous source changes found while fetching; syncs of the repository
+-- are paused while one is not acknowledged
+CREATE TABLE IF NOT EXISTS anomalies (
+    id UUID PRIMARY KEY,
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    kinds TEXT[] NOT NULL,
+    size_before BIGINT NOT NULL,
+    size_after BIGINT NOT NULL,
+    deleted TEXT[] NOT NULL DEFAULT '{}',
+    rewritten TEXT[] NOT NULL DEFAULT '{}',
+    acknowledged BOOLEAN NOT NULL DEFAULT FALSE,
+    acknowledged_by TEXT,
+    acknowledged_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_open ON anomalies (repository_id) WHERE NOT acknowledged;
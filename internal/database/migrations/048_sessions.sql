@@ -0,0 +1,22 @@
+-- Browser sessions of users logged in through the OIDC provider. Only a
+-- hash of the session cookie is kept.
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_name TEXT NOT NULL,
+    groups TEXT[] NOT NULL DEFAULT '{}',
+    csrf_token TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions (expires_at);
+
+-- Logins redirected to the OIDC provider and not yet completed; each is
+-- consumed by its callback
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    verifier TEXT NOT NULL,
+    return_to TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,17 @@
+-- Deploy keys GitSync generated for SSH targets and added to their remote.
+-- Rows outlive their target, which has no foreign key here, until the key
+-- is removed from the remote it was added to.
+CREATE TABLE IF NOT EXISTS target_deploy_keys (
+    target_id UUID PRIMARY KEY,
+    provider TEXT NOT NULL,
+    remote_url TEXT NOT NULL,
+    credential_id UUID,
+    key_id TEXT,
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status TEXT NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    last_checked_at TIMESTAMP NOT NULL
+);
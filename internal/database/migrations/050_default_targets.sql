@@ -0,0 +1,10 @@
+-- Targets added to every repository created from then on. target holds the
+-- target as its create request, with a remote_url template filled in from
+-- the source URL of each repository.
+CREATE TABLE IF NOT EXISTS default_targets (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    target JSONB NOT NULL,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,27 @@
+-- Named bundles of repository configuration, applied when a repository is
+-- created from one and again whenever the template is re-applied. NULL
+-- columns leave the settings of the repositories alone.
+CREATE TABLE IF NOT EXISTS repository_templates (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT,
+    labels JSONB NOT NULL DEFAULT '{}',
+    sync_interval_seconds INTEGER,
+    sync_window JSONB,
+    sync_slo_seconds INTEGER,
+    clone_filter TEXT,
+    exclude_refs TEXT[],
+    mode TEXT,
+    tag_pattern TEXT,
+    annotated_tags_only BOOLEAN NOT NULL DEFAULT FALSE,
+    notification_rule_ids UUID[] NOT NULL DEFAULT '{}',
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- The template a repository was created from or last had applied. There is
+-- no foreign key: repositories restored from the trash may name a template
+-- deleted meanwhile.
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS template_id UUID;
+CREATE INDEX IF NOT EXISTS idx_repositories_template_id ON repositories(template_id);
@@ -0,0 +1,34 @@
+-- Fleet-wide comparisons of every target with its source, run on a
+-- schedule independently of syncs to catch silent drift
+CREATE TABLE IF NOT EXISTS consistency_audits (
+    id UUID PRIMARY KEY,
+    trigger TEXT NOT NULL,
+    status TEXT NOT NULL,
+    targets INTEGER NOT NULL DEFAULT 0,
+    in_sync INTEGER NOT NULL DEFAULT 0,
+    drifted INTEGER NOT NULL DEFAULT 0,
+    corrupt INTEGER NOT NULL DEFAULT 0,
+    errors INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_consistency_audits_started ON consistency_audits(started_at DESC);
+
+-- One row per target compared by an audit
+CREATE TABLE IF NOT EXISTS consistency_results (
+    audit_id UUID NOT NULL REFERENCES consistency_audits(id) ON DELETE CASCADE,
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES replication_targets(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    diff JSONB,
+    sampled_refs TEXT[] NOT NULL DEFAULT '{}',
+    failed_samples TEXT[] NOT NULL DEFAULT '{}',
+    detail TEXT,
+    checked_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (audit_id, target_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_consistency_results_target ON consistency_results(target_id, checked_at DESC);
@@ -0,0 +1,26 @@
+-- Daily rollup of finished syncs per repository, kept after the executions
+-- themselves expire so long-range statistics need no raw history
+CREATE TABLE IF NOT EXISTS sync_stats_daily (
+    day DATE NOT NULL,
+    repository_id UUID NOT NULL,
+    syncs INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    bytes_pushed BIGINT NOT NULL DEFAULT 0,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, repository_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_stats_daily_repository ON sync_stats_daily(repository_id, day);
+
+-- Seed the rollup once from the history still kept; bytes were not
+-- recorded before it existed
+INSERT INTO sync_stats_daily (day, repository_id, syncs, succeeded, failed, duration_ms)
+SELECT started_at::date, repository_id, COUNT(*),
+       COUNT(*) FILTER (WHERE status = 'success'),
+       COUNT(*) FILTER (WHERE status = 'failed'),
+       COALESCE(SUM(EXTRACT(EPOCH FROM finished_at - started_at) * 1000), 0)::BIGINT
+FROM executions
+WHERE finished_at IS NOT NULL AND status IN ('success', 'failed')
+  AND NOT EXISTS (SELECT 1 FROM sync_stats_daily)
+GROUP BY started_at::date, repository_id;
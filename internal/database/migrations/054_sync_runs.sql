@@ -0,0 +1,25 @@
+-- Syncs of a repository, with the executions of their targets linked to
+-- them. Syncs requested through the API are recorded as queued before they
+-- start.
+CREATE TABLE IF NOT EXISTS sync_runs (
+    id UUID PRIMARY KEY,
+    repository_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    trigger TEXT NOT NULL,
+    status TEXT NOT NULL,
+    -- The targets requested; empty for every approved target
+    target_ids UUID[] NOT NULL DEFAULT '{}',
+    targets INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    requested_by TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_runs_repository ON sync_runs(repository_id, created_at DESC);
+
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS run_id UUID REFERENCES sync_runs(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_executions_run ON executions(run_id);
@@ -0,0 +1,3 @@
+-- Cron expression scheduling syncs of a repository, such as 0 */6 * * *,
+-- instead of its sync interval
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS sync_schedule TEXT;
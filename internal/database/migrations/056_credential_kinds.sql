@@ -0,0 +1,4 @@
+-- Credentials other than provider tokens: username and password pairs for
+-- git over HTTPS, and SSH private keys for git over SSH
+ALTER TABLE credentials ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'token';
+ALTER TABLE credentials ADD COLUMN IF NOT EXISTS username TEXT;
@@ -0,0 +1,2 @@
+-- Disabled repositories are not synced on schedule or by push webhooks
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,4 @@
+-- Latest finished sync per target, looked up for every target by the
+-- health of repository listings
+CREATE INDEX IF NOT EXISTS idx_executions_target_latest
+ON executions(target_id, started_at DESC) WHERE finished_at IS NOT NULL;
@@ -0,0 +1,18 @@
+-- Syncs are queued as sync runs, which every instance claims from. A run
+-- is claimed by one instance, which heartbeats it while it runs; runs
+-- whose instance stopped heartbeating are claimed again.
+ALTER TABLE sync_runs ADD COLUMN IF NOT EXISTS claimed_by TEXT;
+ALTER TABLE sync_runs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
+
+-- A repository has one queued or running sync at most. Runs left behind
+-- by earlier versions give way to the latest of their repository.
+UPDATE sync_runs s
+SET status = 'failed', error = 'superseded by a later sync of the repository', finished_at = NOW()
+WHERE s.status IN ('queued', 'running') AND EXISTS (
+    SELECT 1 FROM sync_runs o
+    WHERE o.repository_id = s.repository_id AND o.status IN ('queued', 'running')
+      AND (o.created_at, o.id) > (s.created_at, s.id));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sync_runs_active ON sync_runs(repository_id) WHERE status IN ('queued', 'running');
+
+CREATE INDEX IF NOT EXISTS idx_sync_runs_queue ON sync_runs(created_at) WHERE status IN ('queued', 'running');
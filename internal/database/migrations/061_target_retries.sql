@@ -0,0 +1,8 @@
+-- Failed syncs of a target are retried with backoff until it fails too
+-- many times in a row, when it is dead-lettered: left out of syncs until
+-- an operator re-drives it
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS sync_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS retry_at TIMESTAMP;
+ALTER TABLE replication_targets ADD COLUMN IF NOT EXISTS dead_lettered_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_replication_targets_retry_at ON replication_targets(retry_at) WHERE retry_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- The trace context of the request that queued a sync run, so the
+-- instance claiming it continues that trace
+ALTER TABLE sync_runs ADD COLUMN IF NOT EXISTS trace_parent TEXT;
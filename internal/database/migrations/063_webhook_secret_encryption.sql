@@ -0,0 +1,3 @@
+-- ID of the master key the secret of a webhook is sealed with; NULL for
+-- secrets stored in plaintext before a master key was configured
+ALTER TABLE repository_webhooks ADD COLUMN IF NOT EXISTS key_id TEXT;
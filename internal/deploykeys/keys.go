@@ -0,0 +1,79 @@
+package deploykeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"gitsync/internal/hostkeys"
+)
+
+// keyPair is a generated key in the formats ssh and the forges read
+type keyPair struct {
+	// public is in authorized_keys format
+	public string
+	// private is in OpenSSH format
+	private     string
+	fingerprint string
+}
+
+// generate creates an Ed25519 key pair commented with comment
+func generate(comment string) (keyPair, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return keyPair{}, fmt.Errorf("failed to generate deploy key: %w", err)
+	}
+	blob := sshString(nil, "ssh-ed25519")
+	blob = sshString(blob, string(pub))
+
+	// The private section holds a random check value twice, the key and
+	// its comment, padded to the cipher block size of 8 for "none"
+	var check [4]byte
+	if _, err := rand.Read(check[:]); err != nil {
+		return keyPair{}, fmt.Errorf("failed to generate deploy key: %w", err)
+	}
+	secret := append(check[:], check[:]...)
+	secret = sshString(secret, "ssh-ed25519")
+	secret = sshString(secret, string(pub))
+	secret = sshString(secret, string(priv))
+	secret = sshString(secret, comment)
+	for i := byte(1); len(secret)%8 != 0; i++ {
+		secret = append(secret, i)
+	}
+
+	data := []byte("openssh-key-v1\x00")
+	data = sshString(data, "none")
+	data = sshString(data, "none")
+	data = sshString(data, "")
+	data = binary.BigEndian.AppendUint32(data, 1)
+	data = sshString(data, string(blob))
+	data = sshString(data, string(secret))
+
+	public := "ssh-ed25519 " + base64.StdEncoding.EncodeToString(blob)
+	_, _, fingerprint, err := hostkeys.ParseKey(public)
+	if err != nil {
+		return keyPair{}, err
+	}
+	return keyPair{
+		public:      public + " " + comment,
+		private:     string(pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: data})),
+		fingerprint: fingerprint,
+	}, nil
+}
+
+// sshString appends s to b as a length-prefixed SSH string
+func sshString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sameKey reports whether two keys in authorized_keys format are the same,
+// whatever their comments
+func sameKey(a, b string) bool {
+	fa, fb := strings.Fields(a), strings.Fields(b)
+	return len(fa) >= 2 && len(fb) >= 2 && fa[0] == fb[0] && fa[1] == fb[1]
+}
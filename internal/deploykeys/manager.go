@@ -0,0 +1,318 @@
+// Package deploykeys gives every SSH target a key pair of its own and adds
+// its public half to the target repository as a write-enabled deploy key
+// through the provider API, removing it again when the target goes.
+package deploykeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+)
+
+// Title is the title deploy keys are added under
+const Title = "gitsync"
+
+const (
+	statusActive = "active"
+	statusError  = "error"
+)
+
+// key is a row of target_deploy_keys
+type key struct {
+	targetID     string
+	provider     string
+	remoteURL    string
+	credentialID *string
+	keyID        sql.NullString
+	public       string
+	private      string
+	fingerprint  string
+}
+
+// Manager provisions deploy keys on the remotes of SSH targets
+type Manager struct {
+	DB          *database.DB
+	Client      *provider.Client
+	Credentials *credentials.Store
+	Clock       clock.Clock
+	enabled     bool
+}
+
+// NewManager creates a deploy key Manager; keys are only provisioned when
+// enabled is set
+func NewManager(db *database.DB, client *provider.Client, creds *credentials.Store, clk clock.Clock, enabled bool) *Manager {
+	return &Manager{DB: db, Client: client, Credentials: creds, Clock: clk, enabled: enabled}
+}
+
+// Enabled reports whether deploy keys are provisioned
+func (m *Manager) Enabled() bool {
+	return m != nil && m.enabled
+}
+
+// isSSH reports whether git reaches remote over SSH
+func isSSH(remote string) bool {
+	u, err := provider.ParseRepoURL(remote)
+	return err == nil && u.Scheme == "ssh"
+}
+
+// Ensure makes sure an SSH target has a deploy key on its remote, adding
+// one when it has none yet and replacing the key of a remote it no longer
+// replicates to. Targets whose provider has no token are skipped.
+func (m *Manager) Ensure(ctx context.Context, target models.Target) error {
+	if !m.Enabled() || !isSSH(target.RemoteURL) {
+		return nil
+	}
+	token, err := m.Credentials.Resolve(ctx, target.CredentialID, target.Provider)
+	if err != nil {
+		return fmt.Errorf("failed to resolve credential: %w", err)
+	}
+	if token == "" {
+		return nil
+	}
+
+	k, err := m.load(ctx, target.ID)
+	if err != nil {
+		return err
+	}
+	if k != nil && (k.remoteURL != target.RemoteURL || k.provider != target.Provider) {
+		if err := m.remove(ctx, *k); err != nil {
+			log.Printf("WARN: failed to remove deploy key of target %s from %s: %v", target.ID, k.remoteURL, err)
+		}
+		k = nil
+	}
+	if k == nil {
+		pair, err := generate(Title)
+		if err != nil {
+			return err
+		}
+		k = &key{targetID: target.ID, public: pair.public, private: pair.private, fingerprint: pair.fingerprint}
+	}
+	k.provider, k.remoteURL, k.credentialID = target.Provider, target.RemoteURL, target.CredentialID
+
+	id, err := m.install(ctx, *k, token)
+	if err != nil {
+		m.record(ctx, *k, statusError, err.Error())
+		return err
+	}
+	k.keyID = sql.NullString{String: id, Valid: true}
+	m.record(ctx, *k, statusActive, "")
+	return nil
+}
+
+// install adds the key to its remote unless it is there already and
+// returns its ID there
+func (m *Manager) install(ctx context.Context, k key, token string) (string, error) {
+	keys, err := m.Client.ListDeployKeys(ctx, k.provider, k.remoteURL, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to list deploy keys: %w", err)
+	}
+	for _, dk := range keys {
+		if dk.ID == k.keyID.String || sameKey(dk.Key, k.public) {
+			if dk.ReadOnly {
+				return "", fmt.Errorf("deploy key %s was made read-only; remove it so it is added again", dk.ID)
+			}
+			return dk.ID, nil
+		}
+	}
+	dk, err := m.Client.CreateDeployKey(ctx, k.provider, k.remoteURL, token, Title, k.public)
+	if err != nil {
+		return "", fmt.Errorf("failed to add deploy key: %w", err)
+	}
+	return dk.ID, nil
+}
+
+// remove takes the key off its remote, if it was added there
+func (m *Manager) remove(ctx context.Context, k key) error {
+	if !k.keyID.Valid {
+		return nil
+	}
+	token, err := m.Credentials.Resolve(ctx, k.credentialID, k.provider)
+	if err != nil {
+		return fmt.Errorf("failed to resolve credential: %w", err)
+	}
+	err = m.Client.DeleteDeployKey(ctx, k.provider, k.remoteURL, token, k.keyID.String)
+	if err != nil && !provider.IsNotFound(err) {
+		return fmt.Errorf("failed to remove deploy key: %w", err)
+	}
+	return nil
+}
+
+// PrivateKey returns the private key git authenticates to the remote of
+// target with, or "" when it has no active deploy key there
+func (m *Manager) PrivateKey(ctx context.Context, target models.Target) (string, error) {
+	if !m.Enabled() {
+		return "", nil
+	}
+	var private string
+	err := m.DB.QueryRowContext(ctx,
+		`SELECT private_key FROM target_deploy_keys WHERE target_id = $1 AND remote_url = $2 AND status = $3`,
+		target.ID, target.RemoteURL, statusActive).Scan(&private)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch deploy key: %w", err)
+	}
+	return private, nil
+}
+
+const keyColumns = `target_id, provider, remote_url, credential_id, key_id, public_key, private_key, fingerprint`
+
+func scan(row interface{ Scan(...any) error }) (key, error) {
+	var k key
+	err := row.Scan(&k.targetID, &k.provider, &k.remoteURL, &k.credentialID, &k.keyID, &k.public, &k.private, &k.fingerprint)
+	return k, err
+}
+
+// load returns the key of the target, or nil when it has none
+func (m *Manager) load(ctx context.Context, targetID string) (*key, error) {
+	k, err := scan(m.DB.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM target_deploy_keys WHERE target_id = $1`, targetID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deploy key: %w", err)
+	}
+	return &k, nil
+}
+
+func (m *Manager) record(ctx context.Context, k key, status, lastError string) {
+	now := m.Clock.Now()
+	_, err := m.DB.ExecContext(ctx,
+		`INSERT INTO target_deploy_keys (target_id, provider, remote_url, credential_id, key_id, public_key, private_key, fingerprint,
+		     status, last_error, created_at, last_checked_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, $11)
+		 ON CONFLICT (target_id) DO UPDATE
+		 SET provider = EXCLUDED.provider, remote_url = EXCLUDED.remote_url, credential_id = EXCLUDED.credential_id,
+		     key_id = EXCLUDED.key_id, public_key = EXCLUDED.public_key, private_key = EXCLUDED.private_key,
+		     fingerprint = EXCLUDED.fingerprint, status = EXCLUDED.status, last_error = EXCLUDED.last_error,
+		     created_at = CASE WHEN target_deploy_keys.public_key = EXCLUDED.public_key
+		                       THEN target_deploy_keys.created_at ELSE EXCLUDED.created_at END,
+		     last_checked_at = EXCLUDED.last_checked_at`,
+		k.targetID, k.provider, k.remoteURL, k.credentialID, k.keyID, k.public, k.private, k.fingerprint, status, lastError, now)
+	if err != nil {
+		log.Printf("ERROR: failed to record deploy key of target %s: %v", k.targetID, err)
+	}
+}
+
+// Prune removes the deploy keys of deleted targets from their remotes. Keys
+// that cannot be removed are retried on the next run.
+func (m *Manager) Prune(ctx context.Context) error {
+	if !m.Enabled() {
+		return nil
+	}
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT `+keyColumns+` FROM target_deploy_keys k
+		 WHERE NOT EXISTS (SELECT 1 FROM replication_targets t WHERE t.id = k.target_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to fetch deploy keys of deleted targets: %w", err)
+	}
+	var keys []key
+	for rows.Next() {
+		k, err := scan(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan deploy key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := m.remove(ctx, k); err != nil {
+			log.Printf("WARN: failed to remove deploy key of deleted target %s from %s: %v", k.targetID, k.remoteURL, err)
+			m.record(ctx, k, statusError, err.Error())
+			continue
+		}
+		// A target restored from the trash meanwhile keeps its key
+		if _, err := m.DB.ExecContext(ctx,
+			`DELETE FROM target_deploy_keys WHERE target_id = $1
+			 AND NOT EXISTS (SELECT 1 FROM replication_targets WHERE id = $1)`, k.targetID); err != nil {
+			log.Printf("ERROR: failed to delete deploy key of target %s: %v", k.targetID, err)
+		}
+		log.Printf("Removed deploy key %s of deleted target %s from %s", k.fingerprint, k.targetID, k.remoteURL)
+	}
+	return nil
+}
+
+// ReconcileAll removes the deploy keys of deleted targets and runs Ensure
+// for every SSH target
+func (m *Manager) ReconcileAll(ctx context.Context) error {
+	if err := m.Prune(ctx); err != nil {
+		log.Printf("ERROR: deploy key prune failed: %v", err)
+	}
+	return m.ensureWhere(ctx, "TRUE")
+}
+
+// EnsureRepository runs Ensure for the SSH targets of a repository, as
+// after it or one of its targets was restored from the trash
+func (m *Manager) EnsureRepository(ctx context.Context, repoID string) error {
+	if !m.Enabled() {
+		return nil
+	}
+	return m.ensureWhere(ctx, "repository_id = $1", repoID)
+}
+
+// ensureWhere runs Ensure for the SSH targets matching cond
+func (m *Manager) ensureWhere(ctx context.Context, cond string, args ...any) error {
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT id, provider, remote_url, credential_id FROM replication_targets WHERE `+cond, args...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch targets: %w", err)
+	}
+	var targets []models.Target
+	for rows.Next() {
+		var t models.Target
+		if err := rows.Scan(&t.ID, &t.Provider, &t.RemoteURL, &t.CredentialID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan target: %w", err)
+		}
+		if isSSH(t.RemoteURL) {
+			targets = append(targets, t)
+		}
+	}
+	rows.Close()
+
+	for _, t := range targets {
+		if err := m.Ensure(ctx, t); err != nil {
+			log.Printf("WARN: deploy key reconcile failed for target %s: %v", t.ID, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Run periodically reconciles deploy keys until ctx is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	if !m.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.ReconcileAll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: deploy key reconcile failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
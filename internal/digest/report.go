@@ -0,0 +1,155 @@
+// Package digest summarizes replication activity over a period and sends
+// the summary as a scheduled notification.
+package digest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+)
+
+// Periods a digest can cover
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// RepositoryFailures counts failed executions of one repository
+type RepositoryFailures struct {
+	RepositoryID string `json:"repository_id"`
+	Name         string `json:"name"`
+	Failures     int    `json:"failures"`
+}
+
+// DriftedTarget is a target that had no successful execution in the period
+type DriftedTarget struct {
+	TargetID     string     `json:"target_id"`
+	RepositoryID string     `json:"repository_id"`
+	RemoteURL    string     `json:"remote_url"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+}
+
+// Report summarizes activity between From and To
+type Report struct {
+	Period          string               `json:"period"`
+	From            time.Time            `json:"from"`
+	To              time.Time            `json:"to"`
+	Syncs           int                  `json:"syncs"`
+	Succeeded       int                  `json:"succeeded"`
+	Failed          int                  `json:"failed"`
+	Throttled       int                  `json:"throttled"`
+	TopFailing      []RepositoryFailures `json:"top_failing"`
+	NewRepositories []models.Repository  `json:"new_repositories"`
+	DriftedTargets  []DriftedTarget      `json:"drifted_targets"`
+}
+
+// Builder computes digest reports from the execution history
+type Builder struct {
+	DB *database.DB
+}
+
+// NewBuilder creates a Builder
+func NewBuilder(db *database.DB) *Builder {
+	return &Builder{DB: db}
+}
+
+// PeriodLength returns the duration covered by a period
+func PeriodLength(period string) (time.Duration, error) {
+	switch period {
+	case Daily:
+		return 24 * time.Hour, nil
+	case Weekly:
+		return 7 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid period %q. allowed: daily, weekly", period)
+	}
+}
+
+// Build computes the report for [from, to)
+func (b *Builder) Build(ctx context.Context, period string, from, to time.Time) (*Report, error) {
+	report := &Report{
+		Period:          period,
+		From:            from,
+		To:              to,
+		TopFailing:      []RepositoryFailures{},
+		NewRepositories: []models.Repository{},
+		DriftedTargets:  []DriftedTarget{},
+	}
+
+	err := b.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*),
+		        COUNT(*) FILTER (WHERE status = $3),
+		        COUNT(*) FILTER (WHERE status = $4),
+		        COUNT(*) FILTER (WHERE status = $5)
+		 FROM executions WHERE started_at >= $1 AND started_at < $2`,
+		from, to, models.ExecutionSuccess, models.ExecutionFailed, models.ExecutionThrottled).
+		Scan(&report.Syncs, &report.Succeeded, &report.Failed, &report.Throttled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count executions: %w", err)
+	}
+
+	rows, err := b.DB.QueryContext(ctx,
+		`SELECT r.id, r.name, COUNT(*) AS failures
+		 FROM executions e JOIN repositories r ON r.id = e.repository_id
+		 WHERE e.started_at >= $1 AND e.started_at < $2 AND e.status = $3
+		 GROUP BY r.id, r.name ORDER BY failures DESC, r.name LIMIT 10`,
+		from, to, models.ExecutionFailed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch failing repositories: %w", err)
+	}
+	for rows.Next() {
+		var f RepositoryFailures
+		if err := rows.Scan(&f.RepositoryID, &f.Name, &f.Failures); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan failing repository: %w", err)
+		}
+		report.TopFailing = append(report.TopFailing, f)
+	}
+	rows.Close()
+
+	rows, err = b.DB.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, created_at
+		 FROM repositories WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch new repositories: %w", err)
+	}
+	for rows.Next() {
+		var repo models.Repository
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan repository: %w", err)
+		}
+		report.NewRepositories = append(report.NewRepositories, repo)
+	}
+	rows.Close()
+
+	// Targets that existed for the whole period without succeeding since it began
+	rows, err = b.DB.QueryContext(ctx,
+		`SELECT t.id, t.repository_id, t.remote_url, MAX(e.finished_at) FILTER (WHERE e.status = $2)
+		 FROM replication_targets t LEFT JOIN executions e ON e.target_id = t.id
+		 WHERE t.created_at < $1 AND t.approval_state = $3
+		 GROUP BY t.id, t.repository_id, t.remote_url
+		 HAVING COALESCE(MAX(e.finished_at) FILTER (WHERE e.status = $2), 'epoch') < $1
+		 ORDER BY t.repository_id`, from, models.ExecutionSuccess, models.ApprovalApproved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch drifted targets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t DriftedTarget
+		if err := rows.Scan(&t.TargetID, &t.RepositoryID, &t.RemoteURL, &t.LastSuccess); err != nil {
+			return nil, fmt.Errorf("failed to scan drifted target: %w", err)
+		}
+		report.DriftedTargets = append(report.DriftedTargets, t)
+	}
+	return report, rows.Err()
+}
+
+// Summary renders the report as a short plain-text message
+func (r *Report) Summary() string {
+	return fmt.Sprintf("%d syncs (%d succeeded, %d failed, %d throttled), %d new repositories, %d drifted targets",
+		r.Syncs, r.Succeeded, r.Failed, r.Throttled, len(r.NewRepositories), len(r.DriftedTargets))
+}
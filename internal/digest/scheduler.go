@@ -0,0 +1,129 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/notify"
+)
+
+// Scheduler sends a digest notification once per completed period. Daily
+// periods end at midnight UTC and weekly periods at midnight UTC on Monday.
+type Scheduler struct {
+	Builder  *Builder
+	Notifier *notify.Dispatcher
+	Periods  []string
+	Clock    clock.Clock
+}
+
+// NewScheduler creates a Scheduler for the given periods
+func NewScheduler(builder *Builder, notifier *notify.Dispatcher, periods []string, clk clock.Clock) *Scheduler {
+	return &Scheduler{Builder: builder, Notifier: notifier, Periods: periods, Clock: clk}
+}
+
+// ParsePeriods parses a comma-separated list of periods such as
+// "daily,weekly". An empty list disables digests.
+func ParsePeriods(raw string) ([]string, error) {
+	var periods []string
+	for _, p := range strings.Split(raw, ",") {
+		p = strings.TrimSpace(strings.ToLower(p))
+		if p == "" {
+			continue
+		}
+		if _, err := PeriodLength(p); err != nil {
+			return nil, err
+		}
+		periods = append(periods, p)
+	}
+	return periods, nil
+}
+
+// LastCompleted returns the bounds of the most recent period that ended at
+// or before now
+func LastCompleted(period string, now time.Time) (time.Time, time.Time, error) {
+	length, err := PeriodLength(period)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	now = now.UTC()
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if period == Weekly {
+		end = end.AddDate(0, 0, -((int(end.Weekday()) + 6) % 7))
+	}
+	return end.Add(-length), end, nil
+}
+
+// SendDue sends the digest of every configured period whose last completed
+// period has not been sent yet. The digest_runs row is claimed before
+// sending so that several instances do not send the same digest.
+func (s *Scheduler) SendDue(ctx context.Context) error {
+	for _, period := range s.Periods {
+		from, to, err := LastCompleted(period, s.Clock.Now())
+		if err != nil {
+			return err
+		}
+
+		res, err := s.Builder.DB.ExecContext(ctx,
+			`INSERT INTO digest_runs (period, period_start) VALUES ($1, $2) ON CONFLICT DO NOTHING`, period, from)
+		if err != nil {
+			return fmt.Errorf("failed to record digest run: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		report, err := s.Builder.Build(ctx, period, from, to)
+		if err != nil {
+			// Release the claim so the next run retries
+			s.Builder.DB.ExecContext(ctx,
+				`DELETE FROM digest_runs WHERE period = $1 AND period_start = $2`, period, from)
+			return err
+		}
+		s.Notifier.Notify(Event(report))
+	}
+	return nil
+}
+
+// Event converts a report into the notification sent to channels
+func Event(r *Report) notify.Event {
+	eventType := notify.EventDigestDaily
+	if r.Period == Weekly {
+		eventType = notify.EventDigestWeekly
+	}
+	severity := notify.SeverityInfo
+	if r.Failed > 0 || len(r.DriftedTargets) > 0 {
+		severity = notify.SeverityWarning
+	}
+	return notify.Event{
+		Type:     eventType,
+		Severity: severity,
+		Message: fmt.Sprintf("%s to %s: %s",
+			r.From.Format("2006-01-02"), r.To.Add(-time.Second).Format("2006-01-02"), r.Summary()),
+		Data: map[string]any{"report": r},
+	}
+}
+
+// Run checks for due digests periodically until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	if len(s.Periods) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.SendDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: digest run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Serialization formats of published events
+const (
+	FormatJSON = "json"
+	FormatAvro = "avro"
+)
+
+// Encoder serializes an event published to topic
+type Encoder interface {
+	Encode(ctx context.Context, topic string, ev Event) ([]byte, error)
+}
+
+// JSON encodes events as JSON objects
+type JSON struct{}
+
+// Encode implements Encoder
+func (JSON) Encode(_ context.Context, _ string, ev Event) ([]byte, error) {
+	return json.Marshal(ev)
+}
+
+// AvroSchema is the Avro schema of published events. Data, whose shape
+// depends on the event type, is carried as a JSON document.
+const AvroSchema = `{"type":"record","name":"Event","namespace":"gitsync","fields":[` +
+	`{"name":"id","type":"string"},` +
+	`{"name":"type","type":"string"},` +
+	`{"name":"time","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"repository_id","type":["null","string"],"default":null},` +
+	`{"name":"target_id","type":["null","string"],"default":null},` +
+	`{"name":"actor","type":["null","string"],"default":null},` +
+	`{"name":"data","type":"string"}]}`
+
+// Avro encodes events with AvroSchema in the Confluent wire format: a zero
+// byte and the schema ID assigned by the schema registry at RegistryURL
+// ahead of the Avro binary encoding. The schema is registered under the
+// <topic>-value subject on first use.
+type Avro struct {
+	RegistryURL string
+	HTTP        *http.Client
+
+	mu  sync.Mutex
+	ids map[string]int32
+}
+
+// NewAvro creates an Avro encoder registering its schema at registryURL
+func NewAvro(registryURL string) *Avro {
+	return &Avro{RegistryURL: strings.TrimSuffix(registryURL, "/"), HTTP: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Encode implements Encoder
+func (a *Avro) Encode(ctx context.Context, topic string, ev Event) ([]byte, error) {
+	id, err := a.schemaID(ctx, topic+"-value")
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte{0}
+	out = binary.BigEndian.AppendUint32(out, uint32(id))
+	out = avroString(out, ev.ID)
+	out = avroString(out, ev.Type)
+	out = binary.AppendVarint(out, ev.Time.UnixMilli())
+	for _, s := range []string{ev.RepositoryID, ev.TargetID, ev.Actor} {
+		if s == "" {
+			out = binary.AppendVarint(out, 0)
+		} else {
+			out = avroString(binary.AppendVarint(out, 1), s)
+		}
+	}
+	return avroString(out, string(data)), nil
+}
+
+// avroString appends s with its zigzag varint length
+func avroString(out []byte, s string) []byte {
+	return append(binary.AppendVarint(out, int64(len(s))), s...)
+}
+
+// schemaID registers AvroSchema under subject, which returns the existing
+// ID when the schema is already registered
+func (a *Avro) schemaID(ctx context.Context, subject string) (int32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if id, ok := a.ids[subject]; ok {
+		return id, nil
+	}
+
+	body, _ := json.Marshal(map[string]string{"schema": AvroSchema})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		a.RegistryURL+"/subjects/"+url.PathEscape(subject)+"/versions", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register avro schema: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("failed to register avro schema: schema registry returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var registered struct {
+		ID int32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&registered); err != nil {
+		return 0, fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	if a.ids == nil {
+		a.ids = make(map[string]int32)
+	}
+	a.ids[subject] = registered.ID
+	return registered.ID, nil
+}
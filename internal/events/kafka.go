@@ -0,0 +1,505 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Kafka API keys and the versions spoken. These versions predate flexible
+// encodings and are served by brokers from 1.0 up to 4.x.
+const (
+	apiProduce          = 0
+	apiMetadata         = 3
+	apiSaslHandshake    = 17
+	apiSaslAuthenticate = 36
+
+	versionProduce          = 3
+	versionMetadata         = 4
+	versionSaslHandshake    = 1
+	versionSaslAuthenticate = 0
+)
+
+// Kafka publishes messages to Kafka brokers. It speaks just enough of the
+// protocol to produce record batches: metadata lookups to find partition
+// leaders, produce requests acknowledged by all in-sync replicas, and
+// optional SASL/PLAIN authentication.
+type Kafka struct {
+	// Brokers are the bootstrap host:port addresses
+	Brokers  []string
+	ClientID string
+	// TLS connects over TLS when set
+	TLS *tls.Config
+	// Username and Password authenticate with SASL/PLAIN when set
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+// Message is a record to publish
+type Message struct {
+	// Topic is the Kafka topic or NATS subject
+	Topic string
+	// ID identifies the message to brokers that drop duplicates
+	ID string
+	// Key picks the partition, so messages with the same key keep their
+	// order
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// kafkaError is an error code returned by a broker
+type kafkaError struct {
+	Code int16
+	What string
+}
+
+func (e *kafkaError) Error() string {
+	return fmt.Sprintf("kafka %s failed with error code %d", e.What, e.Code)
+}
+
+// Publish writes msgs to their topics. The messages of one partition are
+// sent in order as one batch; on error some partitions may have been
+// written and others not.
+func (k *Kafka) Publish(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	var topics []string
+	for _, m := range msgs {
+		if !slices.Contains(topics, m.Topic) {
+			topics = append(topics, m.Topic)
+		}
+	}
+	meta, err := k.metadata(ctx, topics)
+	if err != nil {
+		return err
+	}
+
+	// Group the messages by leader, then topic and partition
+	type partitionKey struct {
+		topic     string
+		partition int32
+	}
+	byLeader := make(map[int32]map[partitionKey][]Message)
+	for _, m := range msgs {
+		partitions := meta.topics[m.Topic]
+		if len(partitions) == 0 {
+			return fmt.Errorf("kafka topic %s has no partitions", m.Topic)
+		}
+		p := partitions[partitionFor(m.Key, len(partitions))]
+		if p.leader < 0 {
+			return fmt.Errorf("kafka topic %s partition %d has no leader", m.Topic, p.index)
+		}
+		if byLeader[p.leader] == nil {
+			byLeader[p.leader] = make(map[partitionKey][]Message)
+		}
+		key := partitionKey{m.Topic, p.index}
+		byLeader[p.leader][key] = append(byLeader[p.leader][key], m)
+	}
+
+	for leader, parts := range byLeader {
+		addr, ok := meta.brokers[leader]
+		if !ok {
+			return fmt.Errorf("kafka broker %d is not in the cluster metadata", leader)
+		}
+		var req encoder
+		req.nullableString(nil)
+		req.int16(-1) // acks from all in-sync replicas
+		req.int32(int32(k.timeout().Milliseconds()))
+		byTopic := make(map[string][]partitionKey)
+		for key := range parts {
+			byTopic[key.topic] = append(byTopic[key.topic], key)
+		}
+		req.int32(int32(len(byTopic)))
+		for topic, keys := range byTopic {
+			req.string(topic)
+			req.int32(int32(len(keys)))
+			for _, key := range keys {
+				req.int32(key.partition)
+				req.bytes(recordBatch(parts[key]))
+			}
+		}
+
+		if err := k.produce(ctx, addr, req.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// produce sends a produce request to the broker at addr and checks the
+// result of every partition
+func (k *Kafka) produce(ctx context.Context, addr string, body []byte) error {
+	c, err := k.dial(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	resp, err := c.roundTrip(apiProduce, versionProduce, body)
+	if err != nil {
+		return err
+	}
+	n := resp.int32()
+	for range n {
+		topic := resp.string()
+		partitions := resp.int32()
+		for range partitions {
+			partition := resp.int32()
+			code := resp.int16()
+			resp.int64() // base offset
+			resp.int64() // log append time
+			if code != 0 && resp.err == nil {
+				return &kafkaError{Code: code, What: "produce to " + topic + "/" + strconv.Itoa(int(partition))}
+			}
+		}
+	}
+	return resp.err
+}
+
+type partitionMeta struct {
+	index  int32
+	leader int32
+}
+
+type clusterMeta struct {
+	brokers map[int32]string
+	// topics lists the partitions of each topic ordered by index
+	topics map[string][]partitionMeta
+}
+
+// metadata looks topics up on the first bootstrap broker that answers.
+// Topics are created when the brokers allow automatic creation.
+func (k *Kafka) metadata(ctx context.Context, topics []string) (*clusterMeta, error) {
+	var req encoder
+	req.int32(int32(len(topics)))
+	for _, t := range topics {
+		req.string(t)
+	}
+	req.int8(1) // allow automatic topic creation
+
+	var errs []error
+	for _, addr := range k.Brokers {
+		c, err := k.dial(ctx, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp, err := c.roundTrip(apiMetadata, versionMetadata, req.buf.Bytes())
+		c.Close()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return parseMetadata(resp)
+	}
+	return nil, fmt.Errorf("no kafka broker answered: %w", errors.Join(errs...))
+}
+
+func parseMetadata(resp *decoder) (*clusterMeta, error) {
+	meta := &clusterMeta{brokers: make(map[int32]string), topics: make(map[string][]partitionMeta)}
+	resp.int32() // throttle time
+	brokers := resp.int32()
+	for range brokers {
+		id := resp.int32()
+		host := resp.string()
+		port := resp.int32()
+		resp.nullableString() // rack
+		meta.brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	resp.nullableString() // cluster ID
+	resp.int32()          // controller ID
+	topics := resp.int32()
+	for range topics {
+		code := resp.int16()
+		name := resp.string()
+		resp.int8() // internal
+		var partitions []partitionMeta
+		n := resp.int32()
+		for range n {
+			resp.int16() // partition error, such as an unavailable leader
+			p := partitionMeta{index: resp.int32(), leader: resp.int32()}
+			resp.int32Array() // replicas
+			resp.int32Array() // in-sync replicas
+			partitions = append(partitions, p)
+		}
+		if code != 0 && resp.err == nil {
+			return nil, &kafkaError{Code: code, What: "metadata lookup of topic " + name}
+		}
+		slices.SortFunc(partitions, func(a, b partitionMeta) int { return int(a.index - b.index) })
+		meta.topics[name] = partitions
+	}
+	return meta, resp.err
+}
+
+// partitionFor hashes key onto one of n partitions
+func partitionFor(key []byte, n int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// recordBatch encodes msgs as an uncompressed v2 record batch
+func recordBatch(msgs []Message) []byte {
+	base := msgs[0].Time.UnixMilli()
+	maxTime := base
+	var records encoder
+	for i, m := range msgs {
+		ts := m.Time.UnixMilli()
+		maxTime = max(maxTime, ts)
+		var r encoder
+		r.int8(0) // attributes
+		r.varint(ts - base)
+		r.varint(int64(i))
+		r.varintBytes(m.Key)
+		r.varintBytes(m.Value)
+		r.varint(0) // headers
+		records.varint(int64(r.buf.Len()))
+		records.buf.Write(r.buf.Bytes())
+	}
+
+	// The CRC covers everything from the attributes on
+	var tail encoder
+	tail.int16(0) // attributes: no compression, create time
+	tail.int32(int32(len(msgs) - 1))
+	tail.int64(base)
+	tail.int64(maxTime)
+	tail.int64(-1) // producer ID
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(msgs)))
+	tail.buf.Write(records.buf.Bytes())
+
+	var batch encoder
+	batch.int64(0)                                 // base offset
+	batch.int32(int32(4 + 1 + 4 + tail.buf.Len())) // length after this field
+	batch.int32(-1)                                // partition leader epoch
+	batch.int8(2)                                  // magic
+	batch.int32(int32(crc32.Checksum(tail.buf.Bytes(), castagnoli)))
+	batch.buf.Write(tail.buf.Bytes())
+	return batch.buf.Bytes()
+}
+
+func (k *Kafka) timeout() time.Duration {
+	if k.Timeout > 0 {
+		return k.Timeout
+	}
+	return 10 * time.Second
+}
+
+// kafkaConn is a connection to one broker
+type kafkaConn struct {
+	net.Conn
+	clientID    string
+	correlation int32
+}
+
+// dial connects to the broker at addr and authenticates when credentials
+// are set
+func (k *Kafka) dial(ctx context.Context, addr string) (*kafkaConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.timeout())
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if k.TLS != nil {
+		conn, err = (&tls.Dialer{Config: k.TLS}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kafka broker %s: %w", addr, err)
+	}
+	// Produce requests may wait up to the timeout for replicas on top of
+	// the round trip
+	conn.SetDeadline(time.Now().Add(2 * k.timeout()))
+	c := &kafkaConn{Conn: conn, clientID: k.ClientID}
+	if k.Username != "" {
+		if err := c.authenticate(k.Username, k.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// authenticate runs the SASL/PLAIN exchange
+func (c *kafkaConn) authenticate(username, password string) error {
+	var req encoder
+	req.string("PLAIN")
+	resp, err := c.roundTrip(apiSaslHandshake, versionSaslHandshake, req.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if code := resp.int16(); code != 0 {
+		return &kafkaError{Code: code, What: "SASL handshake"}
+	}
+
+	req = encoder{}
+	req.bytes([]byte("\x00" + username + "\x00" + password))
+	if resp, err = c.roundTrip(apiSaslAuthenticate, versionSaslAuthenticate, req.buf.Bytes()); err != nil {
+		return err
+	}
+	if code := resp.int16(); code != 0 {
+		msg := resp.nullableString()
+		if msg != nil {
+			return fmt.Errorf("kafka SASL authentication failed: %s", *msg)
+		}
+		return &kafkaError{Code: code, What: "SASL authentication"}
+	}
+	return resp.err
+}
+
+// roundTrip sends a request and returns the body of its response
+func (c *kafkaConn) roundTrip(apiKey, version int16, body []byte) (*decoder, error) {
+	c.correlation++
+	var req encoder
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(c.correlation)
+	req.nullableString(&c.clientID)
+	req.buf.Write(body)
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(req.buf.Len()))
+	if _, err := c.Write(append(frame, req.buf.Bytes()...)); err != nil {
+		return nil, fmt.Errorf("failed to send kafka request: %w", err)
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return nil, fmt.Errorf("failed to read kafka response: %w", err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, fmt.Errorf("failed to read kafka response: %w", err)
+	}
+	d := &decoder{buf: resp}
+	if id := d.int32(); id != c.correlation {
+		return nil, fmt.Errorf("kafka response %d does not match request %d", id, c.correlation)
+	}
+	return d, nil
+}
+
+// encoder writes the big-endian primitives of the Kafka protocol
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) int8(v int8)   { e.buf.WriteByte(byte(v)) }
+func (e *encoder) int16(v int16) { e.buf.Write(binary.BigEndian.AppendUint16(nil, uint16(v))) }
+func (e *encoder) int32(v int32) { e.buf.Write(binary.BigEndian.AppendUint32(nil, uint32(v))) }
+func (e *encoder) int64(v int64) { e.buf.Write(binary.BigEndian.AppendUint64(nil, uint64(v))) }
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf.Write(b)
+}
+
+// varint writes a zigzag varint, as used inside records
+func (e *encoder) varint(v int64) { e.buf.Write(binary.AppendVarint(nil, v)) }
+
+// varintBytes writes b prefixed with its varint length; nil is written as
+// length -1
+func (e *encoder) varintBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.buf.Write(b)
+}
+
+// decoder reads the primitives of a Kafka response. The first read past
+// the end sets err and later reads return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errors.New("truncated kafka response")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	if s := d.nullableString(); s != nil {
+		return *s
+	}
+	return ""
+}
+
+func (d *decoder) nullableString() *string {
+	n := d.int16()
+	if n < 0 || d.err != nil {
+		return nil
+	}
+	s := string(d.next(int(n)))
+	return &s
+}
+
+func (d *decoder) int32Array() {
+	n := d.int32()
+	for range max(n, 0) {
+		d.int32()
+	}
+}
@@ -0,0 +1,326 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NATS publishes messages to a NATS server. It speaks just enough of the
+// client protocol to publish: core NATS publishes are confirmed with a
+// PING round trip, and JetStream publishes wait for the stream to
+// acknowledge every message. The servers are tried in order until one
+// accepts the connection.
+type NATS struct {
+	// Servers are nats:// or tls:// URLs, or bare host:port addresses
+	Servers []string
+	Name    string
+	// TLS connects over TLS when set, or when a server requires it
+	TLS *tls.Config
+	// JetStream publishes with the Nats-Msg-Id header set to the message ID
+	// and waits for the acknowledgement of the stream capturing the subject
+	JetStream bool
+	// Username and Password, or Token, authenticate when set
+	Username string
+	Password string
+	Token    string
+	Timeout  time.Duration
+}
+
+// natsInfo is the part of the INFO message of a server that is used
+type natsInfo struct {
+	TLSRequired bool  `json:"tls_required"`
+	Headers     bool  `json:"headers"`
+	MaxPayload  int64 `json:"max_payload"`
+}
+
+// natsAck is the JetStream acknowledgement of a published message
+type natsAck struct {
+	Stream string `json:"stream"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// natsAddress returns the host:port of a server URL and whether its scheme
+// asks for TLS
+func natsAddress(server string) (string, bool, error) {
+	if !strings.Contains(server, "://") {
+		server = "nats://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Hostname() == "" {
+		return "", false, fmt.Errorf("malformed NATS server URL")
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return "", false, fmt.Errorf("unsupported NATS server scheme %q. allowed: nats, tls", u.Scheme)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "4222"
+	}
+	return net.JoinHostPort(u.Hostname(), port), u.Scheme == "tls", nil
+}
+
+// Publish writes msgs to their subjects in order. The Key and Time of a
+// message are not used.
+func (n *NATS) Publish(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	c, err := n.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for _, m := range msgs {
+		if c.info.MaxPayload > 0 && int64(len(m.Value)) > c.info.MaxPayload {
+			return fmt.Errorf("message %s of %d bytes exceeds the NATS max payload of %d bytes", m.ID, len(m.Value), c.info.MaxPayload)
+		}
+	}
+	if !n.JetStream {
+		for _, m := range msgs {
+			fmt.Fprintf(c.w, "PUB %s %d\r\n", m.Topic, len(m.Value))
+			c.w.Write(m.Value)
+			c.w.WriteString("\r\n")
+		}
+		// The server answers the PING after processing the publishes before
+		// it, reporting any error on the way
+		return c.ping()
+	}
+
+	if !c.info.Headers {
+		return errors.New("NATS server does not support headers, which JetStream publishing needs")
+	}
+	inbox, err := natsInbox()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.w, "SUB %s.* 1\r\n", inbox)
+	for i, m := range msgs {
+		hdr := "NATS/1.0\r\nNats-Msg-Id: " + m.ID + "\r\n\r\n"
+		fmt.Fprintf(c.w, "HPUB %s %s.%d %d %d\r\n", m.Topic, inbox, i, len(hdr), len(hdr)+len(m.Value))
+		c.w.WriteString(hdr)
+		c.w.Write(m.Value)
+		c.w.WriteString("\r\n")
+	}
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("failed to send NATS messages: %w", err)
+	}
+
+	for acked := make(map[int]bool); len(acked) < len(msgs); {
+		subject, hdr, payload, err := c.readMsg()
+		if err != nil {
+			return err
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(subject, inbox+"."))
+		if err != nil || i < 0 || i >= len(msgs) {
+			continue
+		}
+		if strings.HasPrefix(hdr, "NATS/1.0 503") {
+			return fmt.Errorf("no JetStream stream captures NATS subject %s", msgs[i].Topic)
+		}
+		var ack natsAck
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			return fmt.Errorf("failed to decode JetStream acknowledgement: %w", err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("JetStream rejected message %s: %s (code %d)", msgs[i].ID, ack.Error.Description, ack.Error.Code)
+		}
+		acked[i] = true
+	}
+	return nil
+}
+
+// natsInbox returns a unique subject prefix for replies
+func natsInbox() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_INBOX." + hex.EncodeToString(b), nil
+}
+
+func (n *NATS) timeout() time.Duration {
+	if n.Timeout > 0 {
+		return n.Timeout
+	}
+	return 10 * time.Second
+}
+
+// natsConn is a connection to one server
+type natsConn struct {
+	net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	info natsInfo
+}
+
+// connect connects to the first server that accepts the connection and
+// the credentials
+func (n *NATS) connect(ctx context.Context) (*natsConn, error) {
+	var errs []error
+	for _, server := range n.Servers {
+		c, err := n.dial(ctx, server)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dial connects to server, upgrading to TLS when configured or required,
+// and authenticates
+func (n *NATS) dial(ctx context.Context, server string) (*natsConn, error) {
+	addr, useTLS, err := natsAddress(server)
+	if err != nil {
+		return nil, err
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, n.timeout())
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS server %s: %w", addr, err)
+	}
+	// JetStream acknowledgements may wait for replicas on top of the round
+	// trip
+	conn.SetDeadline(time.Now().Add(2 * n.timeout()))
+	c := &natsConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	line, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	info, ok := strings.CutPrefix(line, "INFO ")
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("NATS server %s sent %q instead of INFO", addr, line)
+	}
+	if err := json.Unmarshal([]byte(info), &c.info); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to decode NATS server info: %w", err)
+	}
+
+	if useTLS || n.TLS != nil || c.info.TLSRequired {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if n.TLS != nil {
+			cfg = n.TLS.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed TLS handshake with NATS server %s: %w", addr, err)
+		}
+		c.Conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+	}
+	c.w = bufio.NewWriter(c.Conn)
+
+	connect, _ := json.Marshal(map[string]any{
+		"verbose": false, "pedantic": false, "name": n.Name, "lang": "go", "version": "1",
+		"protocol": 1, "headers": true, "no_responders": true,
+		"user": n.Username, "pass": n.Password, "auth_token": n.Token,
+	})
+	fmt.Fprintf(c.w, "CONNECT %s\r\n", connect)
+	if err := c.ping(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// ping flushes pending writes and waits for the server to answer a PING
+func (c *natsConn) ping() error {
+	c.w.WriteString("PING\r\n")
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("failed to send NATS messages: %w", err)
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "PONG" {
+			return nil
+		}
+	}
+}
+
+// readMsg returns the subject, headers and payload of the next message
+// delivered to a subscription
+func (c *natsConn) readMsg() (string, string, []byte, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", "", nil, err
+		}
+		op, args, _ := strings.Cut(line, " ")
+		if op != "MSG" && op != "HMSG" {
+			continue
+		}
+		// MSG <subject> <sid> [reply] <size> and
+		// HMSG <subject> <sid> [reply] <header size> <total size>
+		fields := strings.Fields(args)
+		hdrLen, total := 0, 0
+		if op == "HMSG" && len(fields) >= 4 {
+			hdrLen, err = strconv.Atoi(fields[len(fields)-2])
+			if err == nil {
+				total, err = strconv.Atoi(fields[len(fields)-1])
+			}
+			fields = fields[:len(fields)-2]
+		} else if op == "MSG" && len(fields) >= 3 {
+			total, err = strconv.Atoi(fields[len(fields)-1])
+			fields = fields[:len(fields)-1]
+		} else {
+			err = errors.New("missing fields")
+		}
+		if err != nil || hdrLen > total || len(fields) < 2 {
+			return "", "", nil, fmt.Errorf("malformed NATS message %q", line)
+		}
+		buf := make([]byte, total+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return "", "", nil, fmt.Errorf("failed to read NATS message: %w", err)
+		}
+		// The fields left are the subject, the sid and the reply subject if
+		// any
+		return fields[0], string(buf[:hdrLen]), buf[hdrLen:total], nil
+	}
+}
+
+// readLine returns the next protocol line, answering server PINGs and
+// turning -ERR into an error
+func (c *natsConn) readLine() (string, error) {
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read from NATS server: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			c.Write([]byte("PONG\r\n"))
+		case line == "+OK":
+		case strings.HasPrefix(line, "-ERR"):
+			return "", fmt.Errorf("NATS server error: %s", strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		default:
+			return line, nil
+		}
+	}
+}
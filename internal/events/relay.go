@@ -0,0 +1,408 @@
+// Package events publishes domain events, such as repository lifecycle
+// changes and sync results, to Kafka or NATS for consumers outside GitSync.
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+)
+
+// Event is a domain event. IDs are stable, so consumers can drop the
+// duplicates that at-least-once delivery may produce.
+type Event struct {
+	ID string `json:"id"`
+	// Type is an audited action on a repository or target, such as
+	// repository.create or target.delete, or sync.succeeded, sync.failed
+	// or sync.throttled for a finished sync of a target
+	Type         string         `json:"type"`
+	Time         time.Time      `json:"time"`
+	RepositoryID string         `json:"repository_id,omitempty"`
+	TargetID     string         `json:"target_id,omitempty"`
+	Actor        string         `json:"actor,omitempty"`
+	Data         map[string]any `json:"data,omitempty"`
+}
+
+// Topic returns the topic ev is published to: the prefix followed by the
+// part of its type before the dot, such as gitsync.repository
+func (ev Event) Topic(prefix string) string {
+	category, _, _ := strings.Cut(ev.Type, ".")
+	return prefix + category
+}
+
+// Subject returns the NATS subject ev is published to: the prefix followed
+// by its type, such as gitsync.repository.create
+func (ev Event) Subject(prefix string) string {
+	return prefix + ev.Type
+}
+
+// Config sets where events are published. Each configured sink receives
+// the whole event stream.
+type Config struct {
+	Kafka KafkaConfig
+	NATS  NATSConfig
+}
+
+// KafkaConfig sets the Kafka cluster events are published to
+type KafkaConfig struct {
+	// Brokers are the bootstrap Kafka brokers; publishing to Kafka is
+	// disabled when empty
+	Brokers     []string
+	TopicPrefix string
+	// Format is json or avro; avro needs SchemaRegistryURL
+	Format            string
+	SchemaRegistryURL string
+	TLS               bool
+	// Username and Password authenticate with SASL/PLAIN when set
+	Username string
+	Password string
+}
+
+// NATSConfig sets the NATS servers events are published to as JSON
+type NATSConfig struct {
+	// Servers are nats:// or tls:// server URLs; publishing to NATS is
+	// disabled when empty
+	Servers       []string
+	SubjectPrefix string
+	// JetStream waits for a stream to store each event, deduplicated by
+	// event ID, instead of fire-and-forget core NATS publishing
+	JetStream bool
+	TLS       bool
+	// Username and Password, or Token, authenticate when set
+	Username string
+	Password string
+	Token    string
+}
+
+// ConfigFromEnv reads the Kafka settings KAFKA_BROKERS (comma separated),
+// KAFKA_TOPIC_PREFIX (default gitsync.), KAFKA_FORMAT (json or avro),
+// KAFKA_SCHEMA_REGISTRY_URL, KAFKA_TLS and
+// KAFKA_SASL_USERNAME/KAFKA_SASL_PASSWORD, and the NATS settings NATS_URL
+// (comma separated), NATS_SUBJECT_PREFIX (default gitsync.), NATS_JETSTREAM,
+// NATS_TLS, NATS_USER/NATS_PASSWORD and NATS_TOKEN
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Kafka: KafkaConfig{
+			Brokers:           splitList(os.Getenv("KAFKA_BROKERS")),
+			TopicPrefix:       "gitsync.",
+			Format:            FormatJSON,
+			SchemaRegistryURL: os.Getenv("KAFKA_SCHEMA_REGISTRY_URL"),
+			TLS:               os.Getenv("KAFKA_TLS") == "true",
+			Username:          os.Getenv("KAFKA_SASL_USERNAME"),
+			Password:          os.Getenv("KAFKA_SASL_PASSWORD"),
+		},
+		NATS: NATSConfig{
+			Servers:       splitList(os.Getenv("NATS_URL")),
+			SubjectPrefix: "gitsync.",
+			JetStream:     os.Getenv("NATS_JETSTREAM") == "true",
+			TLS:           os.Getenv("NATS_TLS") == "true",
+			Username:      os.Getenv("NATS_USER"),
+			Password:      os.Getenv("NATS_PASSWORD"),
+			Token:         os.Getenv("NATS_TOKEN"),
+		},
+	}
+	if v, ok := os.LookupEnv("KAFKA_TOPIC_PREFIX"); ok {
+		cfg.Kafka.TopicPrefix = v
+	}
+	if v := os.Getenv("KAFKA_FORMAT"); v != "" {
+		cfg.Kafka.Format = v
+	}
+	switch cfg.Kafka.Format {
+	case FormatJSON:
+	case FormatAvro:
+		if cfg.Kafka.SchemaRegistryURL == "" {
+			return cfg, errors.New("KAFKA_FORMAT avro requires KAFKA_SCHEMA_REGISTRY_URL")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid KAFKA_FORMAT %q. allowed: json, avro", cfg.Kafka.Format)
+	}
+
+	if v, ok := os.LookupEnv("NATS_SUBJECT_PREFIX"); ok {
+		cfg.NATS.SubjectPrefix = v
+	}
+	for _, server := range cfg.NATS.Servers {
+		if _, _, err := natsAddress(server); err != nil {
+			return cfg, fmt.Errorf("invalid NATS_URL: %w", err)
+		}
+	}
+	if cfg.NATS.Token != "" && cfg.NATS.Username != "" {
+		return cfg, errors.New("NATS_TOKEN and NATS_USER are mutually exclusive")
+	}
+	return cfg, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Publisher writes messages to a message broker
+type Publisher interface {
+	Publish(ctx context.Context, msgs []Message) error
+}
+
+// Sink is a destination of the event stream: a broker, how events are
+// encoded for it and where each event goes
+type Sink struct {
+	// Name keys the position of the sink in the event_cursors table
+	Name      string
+	Publisher Publisher
+	Encoder   Encoder
+	// Topic returns the topic or subject ev is published to
+	Topic func(ev Event) string
+}
+
+// NewSinks creates a sink for each broker configured in cfg
+func NewSinks(cfg Config) []Sink {
+	var sinks []Sink
+	if len(cfg.Kafka.Brokers) > 0 {
+		producer := &Kafka{Brokers: cfg.Kafka.Brokers, ClientID: "gitsync",
+			Username: cfg.Kafka.Username, Password: cfg.Kafka.Password}
+		if cfg.Kafka.TLS {
+			producer.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		var encoder Encoder = JSON{}
+		if cfg.Kafka.Format == FormatAvro {
+			encoder = NewAvro(cfg.Kafka.SchemaRegistryURL)
+		}
+		prefix := cfg.Kafka.TopicPrefix
+		sinks = append(sinks, Sink{Name: "kafka", Publisher: producer, Encoder: encoder,
+			Topic: func(ev Event) string { return ev.Topic(prefix) }})
+	}
+	if len(cfg.NATS.Servers) > 0 {
+		client := &NATS{Servers: cfg.NATS.Servers, Name: "gitsync", JetStream: cfg.NATS.JetStream,
+			Username: cfg.NATS.Username, Password: cfg.NATS.Password, Token: cfg.NATS.Token}
+		if cfg.NATS.TLS {
+			client.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		prefix := cfg.NATS.SubjectPrefix
+		sinks = append(sinks, Sink{Name: "nats", Publisher: client, Encoder: JSON{},
+			Topic: func(ev Event) string { return ev.Subject(prefix) }})
+	}
+	return sinks
+}
+
+// Send encodes evs and publishes them in order
+func (s Sink) Send(ctx context.Context, evs []Event) error {
+	msgs := make([]Message, 0, len(evs))
+	for _, ev := range evs {
+		topic := s.Topic(ev)
+		value, err := s.Encoder.Encode(ctx, topic, ev)
+		if err != nil {
+			return fmt.Errorf("failed to encode event %s: %w", ev.ID, err)
+		}
+		// Keying by repository keeps the events of a repository in order
+		msgs = append(msgs, Message{Topic: topic, ID: ev.ID, Key: []byte(ev.RepositoryID), Value: value, Time: ev.Time})
+	}
+	return s.Publisher.Publish(ctx, msgs)
+}
+
+// batchSize bounds the audit entries and executions read per poll
+const batchSize = 500
+
+// Relay publishes events for new audit entries on repositories and targets
+// and for finished executions to one sink. Its position in both tables is
+// kept in the event_cursors table under the name of the sink and only
+// advanced once a batch is published, so events are delivered at least
+// once and each sink progresses on its own. A new relay starts at the
+// current end of both tables rather than replaying history.
+type Relay struct {
+	DB   *database.DB
+	Sink Sink
+}
+
+// NewRelay creates a Relay publishing to sink
+func NewRelay(db *database.DB, sink Sink) *Relay {
+	return &Relay{DB: db, Sink: sink}
+}
+
+// Run publishes new events every interval until ctx is cancelled. A full
+// batch is followed by the next one right away.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		full, err := r.PublishPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: failed to publish events to %s: %v", r.Sink.Name, err)
+		}
+		if full {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cursor is the position of the relay: the last audit entry and the last
+// finished execution published
+type cursor struct {
+	AuditID             int64
+	ExecutionFinishedAt time.Time
+	ExecutionID         string
+}
+
+// PublishPending publishes one batch of new events and reports whether
+// more may be pending
+func (r *Relay) PublishPending(ctx context.Context) (bool, error) {
+	cur, err := r.cursor(ctx)
+	if err != nil {
+		return false, err
+	}
+	audited, next, err := r.audited(ctx, cur)
+	if err != nil {
+		return false, err
+	}
+	synced, next, err := r.synced(ctx, next)
+	if err != nil {
+		return false, err
+	}
+
+	if next == cur {
+		return false, nil
+	}
+	if err := r.Sink.Send(ctx, append(audited, synced...)); err != nil {
+		return false, err
+	}
+
+	if _, err := r.DB.ExecContext(ctx,
+		`UPDATE event_cursors SET audit_id = $1, execution_finished_at = $2, execution_id = $3 WHERE name = $4`,
+		next.AuditID, next.ExecutionFinishedAt, next.ExecutionID, r.Sink.Name); err != nil {
+		return false, fmt.Errorf("failed to advance event cursor: %w", err)
+	}
+	return len(audited) == batchSize || len(synced) == batchSize, nil
+}
+
+// cursor returns the position of the relay, starting it at the end of both
+// tables on first use
+func (r *Relay) cursor(ctx context.Context) (cursor, error) {
+	if _, err := r.DB.ExecContext(ctx,
+		`INSERT INTO event_cursors (name, audit_id, execution_finished_at, execution_id)
+		 SELECT $1, COALESCE((SELECT MAX(id) FROM audit_log), 0), NOW(), '00000000-0000-0000-0000-000000000000'
+		 ON CONFLICT (name) DO NOTHING`, r.Sink.Name); err != nil {
+		return cursor{}, fmt.Errorf("failed to initialize event cursor: %w", err)
+	}
+	var cur cursor
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT audit_id, execution_finished_at, execution_id FROM event_cursors WHERE name = $1`, r.Sink.Name).
+		Scan(&cur.AuditID, &cur.ExecutionFinishedAt, &cur.ExecutionID)
+	if err != nil {
+		return cur, fmt.Errorf("failed to fetch event cursor: %w", err)
+	}
+	return cur, nil
+}
+
+// audited returns events for the audit entries on repositories and
+// targets after cur
+func (r *Relay) audited(ctx context.Context, cur cursor) ([]Event, cursor, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, actor, action, resource_type, resource_id, details, created_at
+		 FROM audit_log
+		 WHERE id > $1 AND resource_type IN ('repository', 'target')
+		 ORDER BY id
+		 LIMIT $2`, cur.AuditID, batchSize)
+	if err != nil {
+		return nil, cur, fmt.Errorf("failed to fetch audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var evs []Event
+	for rows.Next() {
+		var id int64
+		var resourceType, resourceID string
+		var details []byte
+		ev := Event{}
+		if err := rows.Scan(&id, &ev.Actor, &ev.Type, &resourceType, &resourceID, &details, &ev.Time); err != nil {
+			return nil, cur, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		ev.ID = fmt.Sprintf("audit-%d", id)
+		if err := json.Unmarshal(details, &ev.Data); err != nil {
+			log.Printf("WARN: audit entry %d has invalid details: %v", id, err)
+		}
+		switch resourceType {
+		case "repository":
+			ev.RepositoryID = resourceID
+		case "target":
+			ev.TargetID = resourceID
+			ev.RepositoryID, _ = ev.Data["repository_id"].(string)
+		}
+		evs = append(evs, ev)
+		cur.AuditID = id
+	}
+	return evs, cur, rows.Err()
+}
+
+// syncEventTypes maps the status of a finished execution to its event type
+var syncEventTypes = map[models.ExecutionStatus]string{
+	models.ExecutionSuccess:          notify.EventSyncSucceeded,
+	models.ExecutionFailed:           notify.EventSyncFailed,
+	models.ExecutionThrottled:        "sync.throttled",
+	models.ExecutionAwaitingApproval: "sync.awaiting_approval",
+}
+
+// synced returns events for the executions finished after cur. The last
+// few seconds are left for the next poll, so an execution stamped before
+// but committed after a later one is not skipped.
+func (r *Relay) synced(ctx context.Context, cur cursor) ([]Event, cursor, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT e.id, e.repository_id, e.target_id, e.status, e.error, e.source_url, e.trigger_reason, e.started_at, e.finished_at, r.name
+		 FROM executions e LEFT JOIN repositories r ON r.id = e.repository_id
+		 WHERE e.finished_at IS NOT NULL AND (e.finished_at, e.id) > ($1, $2::uuid)
+		   AND e.finished_at < NOW() - INTERVAL '5 seconds'
+		 ORDER BY e.finished_at, e.id
+		 LIMIT $3`, cur.ExecutionFinishedAt, cur.ExecutionID, batchSize)
+	if err != nil {
+		return nil, cur, fmt.Errorf("failed to fetch executions: %w", err)
+	}
+	defer rows.Close()
+
+	var evs []Event
+	for rows.Next() {
+		var e models.Execution
+		var repoName sql.NullString
+		if err := rows.Scan(&e.ID, &e.RepositoryID, &e.TargetID, &e.Status, &e.Error, &e.SourceURL, &e.Trigger, &e.StartedAt, &e.FinishedAt, &repoName); err != nil {
+			return nil, cur, fmt.Errorf("failed to scan execution: %w", err)
+		}
+		cur.ExecutionFinishedAt, cur.ExecutionID = *e.FinishedAt, e.ID
+		typ, ok := syncEventTypes[e.Status]
+		if !ok {
+			continue
+		}
+		data := map[string]any{"execution_id": e.ID, "status": e.Status, "started_at": e.StartedAt}
+		if repoName.Valid {
+			data["repository_name"] = repoName.String
+		}
+		if e.Error != nil {
+			data["error"] = *e.Error
+		}
+		if e.SourceURL != nil {
+			data["source_url"] = *e.SourceURL
+		}
+		if e.Trigger != nil {
+			data["trigger"] = *e.Trigger
+		}
+		evs = append(evs, Event{ID: "execution-" + e.ID, Type: typ, Time: *e.FinishedAt,
+			RepositoryID: e.RepositoryID, TargetID: e.TargetID, Data: data})
+	}
+	return evs, cur, rows.Err()
+}
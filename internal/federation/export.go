@@ -0,0 +1,102 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/gitops"
+	"gitsync/internal/models"
+
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v2"
+)
+
+// Export returns the repositories and approved targets of this instance as
+// a GitOps state file, ordered by name so unchanged configuration exports
+// identically. Credential profiles are referenced by name: their secrets
+// are never replicated and must be created on the standby as well.
+func Export(ctx context.Context, db *database.DB) ([]byte, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT r.id, r.name, r.source_provider, r.source_url, r.alternate_source_urls, c.name, r.archive_action, r.labels, r.owner, r.team,
+		        r.sync_slo_seconds, r.clone_filter, r.cache_pool, r.sync_interval_seconds, r.sync_window, r.sync_schedule
+		 FROM repositories r LEFT JOIN credentials c ON c.id = r.credential_id
+		 ORDER BY r.name, r.source_url`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch repositories: %w", err)
+	}
+	defer rows.Close()
+
+	state := gitops.State{Repositories: []gitops.Repository{}}
+	byID := make(map[string]int)
+	for rows.Next() {
+		var (
+			id                                                   string
+			repo                                                 gitops.Repository
+			credential, archiveAction, owner, team, filter, pool *string
+			syncSchedule                                         *string
+			labels                                               models.Labels
+			interval                                             *int
+		)
+		if err := rows.Scan(&id, &repo.Name, &repo.SourceProvider, &repo.SourceURL, pq.Array(&repo.AlternateSourceURLs), &credential,
+			&archiveAction, &labels, &owner, &team, &repo.SyncSLOSeconds, &filter, &pool, &interval, &repo.SyncWindow, &syncSchedule); err != nil {
+			return nil, fmt.Errorf("failed to scan repository: %w", err)
+		}
+		repo.Credential, repo.ArchiveAction, repo.Owner, repo.Team = value(credential), value(archiveAction), value(owner), value(team)
+		repo.CloneFilter, repo.CachePool, repo.SyncSchedule = value(filter), value(pool), value(syncSchedule)
+		if len(labels) > 0 {
+			repo.Labels = labels
+		}
+		if interval != nil {
+			repo.SyncInterval = (time.Duration(*interval) * time.Second).String()
+		}
+		byID[id] = len(state.Repositories)
+		state.Repositories = append(state.Repositories, repo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx,
+		`SELECT t.repository_id, t.provider, t.remote_url, c.name, t.priority, t.required, t.exclude_refs, t.mode, t.tag_pattern,
+		        t.annotated_tags_only, t.subdirectory, t.rewrite
+		 FROM replication_targets t LEFT JOIN credentials c ON c.id = t.credential_id
+		 WHERE t.approval_state = $1
+		 ORDER BY t.remote_url`, models.ApprovalApproved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch targets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			repoID                               string
+			t                                    gitops.Target
+			credential, tagPattern, subdirectory *string
+			priority                             int
+			required                             bool
+		)
+		if err := rows.Scan(&repoID, &t.Provider, &t.RemoteURL, &credential, &priority, &required, pq.Array(&t.ExcludeRefs),
+			&t.Mode, &tagPattern, &t.AnnotatedTagsOnly, &subdirectory, &t.Rewrite); err != nil {
+			return nil, fmt.Errorf("failed to scan target: %w", err)
+		}
+		i, ok := byID[repoID]
+		if !ok {
+			continue
+		}
+		t.Credential, t.TagPattern, t.Subdirectory = value(credential), value(tagPattern), value(subdirectory)
+		t.Priority, t.Required = &priority, &required
+		state.Repositories[i].Targets = append(state.Repositories[i].Targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(state)
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,394 @@
+// Package federation replicates the repository and target configuration of
+// a primary GitSync instance to a standby one, typically in another
+// datacenter, so the mirroring control plane itself can be failed over.
+// The standby either pulls the primary's state or has it pushed, and
+// applies it with the GitOps reconciler under its own manager, which makes
+// the replicated repositories read-only there until it is promoted.
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/gitops"
+	"gitsync/internal/ids"
+	"gitsync/internal/policy"
+	"gitsync/internal/validation"
+)
+
+// ManagedBy marks repositories replicated from the primary. It is also the
+// actor of their audit entries.
+const ManagedBy = "federation"
+
+// Roles of an instance
+const (
+	RolePrimary = "primary"
+	RoleStandby = "standby"
+)
+
+// Modes say which side moves the state
+const (
+	// ModePull has the standby read the primary's state
+	ModePull = "pull"
+	// ModePush has the primary send its state to the standby
+	ModePush = "push"
+)
+
+// Conflict rules decide what happens to a repository the primary declares
+// that the standby already has without being replicated
+const (
+	// ConflictPrimary takes the repository over with the primary's settings
+	ConflictPrimary = "primary"
+	// ConflictStandby keeps the standby's repository and reports a conflict
+	ConflictStandby = "standby"
+)
+
+// StatePath is where an instance serves and accepts federation state
+const StatePath = "/federation/state"
+
+// MaxStateSize bounds the state read from the primary
+const MaxStateSize = 64 << 20
+
+var (
+	// ErrNotPrimary is returned when the state is requested from an
+	// instance that is not a primary
+	ErrNotPrimary = errors.New("this instance is not a federation primary")
+	// ErrNotAccepted is returned when state is pushed to an instance that
+	// is not a standby in push mode
+	ErrNotAccepted = errors.New("this instance does not accept pushed federation state")
+	// ErrPromoted is returned when state reaches a standby that has been
+	// promoted
+	ErrPromoted = errors.New("this standby has been promoted and no longer applies federation state")
+)
+
+// Config sets the role of the instance and how it reaches its peer
+type Config struct {
+	// Role is primary, standby or empty, which disables federation
+	Role string
+	Mode string
+	// PeerURL is the base URL of the primary for a pulling standby, and of
+	// the standby for a pushing primary
+	PeerURL string
+	// Token is sent as a bearer token to the peer, for the proxy in front
+	// of it to authenticate
+	Token string
+	// Conflict is the rule for repositories the standby already has
+	Conflict string
+	// Prune deletes replicated repositories and targets the primary no
+	// longer has; without it they are only reported as drift
+	Prune bool
+}
+
+// ConfigFromEnv reads FEDERATION_ROLE, FEDERATION_MODE (default pull),
+// FEDERATION_PEER_URL, FEDERATION_TOKEN, FEDERATION_CONFLICT (default
+// primary) and FEDERATION_PRUNE
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Role:     os.Getenv("FEDERATION_ROLE"),
+		Mode:     os.Getenv("FEDERATION_MODE"),
+		PeerURL:  strings.TrimSuffix(os.Getenv("FEDERATION_PEER_URL"), "/"),
+		Token:    os.Getenv("FEDERATION_TOKEN"),
+		Conflict: os.Getenv("FEDERATION_CONFLICT"),
+		Prune:    os.Getenv("FEDERATION_PRUNE") == "true",
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModePull
+	}
+	if cfg.Conflict == "" {
+		cfg.Conflict = ConflictPrimary
+	}
+	switch cfg.Role {
+	case "", RolePrimary, RoleStandby:
+	default:
+		return cfg, errors.New("FEDERATION_ROLE must be primary or standby")
+	}
+	if cfg.Mode != ModePull && cfg.Mode != ModePush {
+		return cfg, errors.New("FEDERATION_MODE must be pull or push")
+	}
+	if cfg.Conflict != ConflictPrimary && cfg.Conflict != ConflictStandby {
+		return cfg, errors.New("FEDERATION_CONFLICT must be primary or standby")
+	}
+	if cfg.Active() {
+		u, err := url.Parse(cfg.PeerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("FEDERATION_PEER_URL must be an http or https URL when this instance %ss", cfg.Mode)
+		}
+	}
+	return cfg, nil
+}
+
+// Enabled reports whether the instance takes part in federation
+func (c Config) Enabled() bool {
+	return c.Role != ""
+}
+
+// Active reports whether the instance moves the state itself, rather than
+// waiting for its peer to
+func (c Config) Active() bool {
+	return (c.Role == RoleStandby && c.Mode == ModePull) || (c.Role == RolePrimary && c.Mode == ModePush)
+}
+
+// Status is the state of federation on this instance. On a standby the
+// changes are those of the last state applied; on a primary the last run
+// is when the standby last read the state or it was last pushed.
+type Status struct {
+	Enabled   bool       `json:"enabled"`
+	Role      string     `json:"role,omitempty"`
+	Mode      string     `json:"mode,omitempty"`
+	PeerURL   string     `json:"peer_url,omitempty"`
+	Conflict  string     `json:"conflict,omitempty"`
+	Prune     bool       `json:"prune"`
+	Promoted  bool       `json:"promoted"`
+	Revision  string     `json:"revision,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	// Applied and Drift are the changes of the last state applied by a
+	// standby; conflicts are part of the drift
+	Applied []gitops.Change `json:"applied"`
+	Drift   []gitops.Change `json:"drift"`
+}
+
+// Syncer moves the state between this instance and its peer
+type Syncer struct {
+	DB *database.DB
+	// Reconciler applies the primary's state on a standby
+	Reconciler *gitops.Reconciler
+	HTTP       *http.Client
+	Config     Config
+	Clock      clock.Clock
+
+	mu       sync.Mutex
+	promoted bool
+	// Last state served or pushed by a primary
+	revision  string
+	lastRunAt *time.Time
+	lastError string
+}
+
+// NewSyncer creates a Syncer. The standby applies the state with the
+// same checks as GitOps: URL policy, credential profiles and policies.
+func NewSyncer(db *database.DB, runner *git.Runner, creds *credentials.Store, urls validation.URLPolicy,
+	policies *policy.Engine, c *cache.Cache, clk clock.Clock, gen ids.Generator, cfg Config) *Syncer {
+	reconciler := gitops.NewReconciler(db, runner, creds, urls, policies, c, clk, gen, gitops.Config{Prune: cfg.Prune})
+	reconciler.Owner = ManagedBy
+	reconciler.KeepUnmanaged = cfg.Conflict == ConflictStandby
+	return &Syncer{
+		DB: db, Reconciler: reconciler, HTTP: &http.Client{Timeout: 5 * time.Minute}, Config: cfg, Clock: clk,
+	}
+}
+
+// Status returns the state of federation on this instance
+func (s *Syncer) Status() Status {
+	status := Status{
+		Enabled: s.Config.Enabled(), Role: s.Config.Role, Mode: s.Config.Mode, PeerURL: s.Config.PeerURL,
+		Conflict: s.Config.Conflict, Prune: s.Config.Prune, Applied: []gitops.Change{}, Drift: []gitops.Change{},
+	}
+	if s.Config.Role == RoleStandby {
+		applied := s.Reconciler.Status()
+		status.Revision, status.LastRunAt, status.LastError = applied.Revision, applied.LastRunAt, applied.LastError
+		status.Applied, status.Drift = applied.Applied, applied.Drift
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status.Promoted = s.promoted
+	if s.Config.Role == RolePrimary {
+		status.Revision, status.LastRunAt, status.LastError = s.revision, s.lastRunAt, s.lastError
+	}
+	return status
+}
+
+// Run pulls or pushes the state every interval until ctx is cancelled. It
+// does nothing on instances that wait for their peer.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	if !s.Config.Active() {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: federation %s failed: %v", s.Config.Mode, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync pulls the primary's state and applies it on a standby, or pushes it
+// to the standby from a primary
+func (s *Syncer) Sync(ctx context.Context) error {
+	switch {
+	case s.Config.Role == RoleStandby && s.Config.Mode == ModePull:
+		if s.Promoted() {
+			return nil
+		}
+		data, err := s.pull(ctx)
+		if err != nil {
+			s.Reconciler.Fail(err)
+			return err
+		}
+		return s.Reconciler.Apply(ctx, data, Revision(data))
+	case s.Config.Role == RolePrimary && s.Config.Mode == ModePush:
+		data, err := Export(ctx, s.DB)
+		if err == nil {
+			err = s.push(ctx, data)
+		}
+		s.record(Revision(data), err)
+		return err
+	}
+	return nil
+}
+
+// State returns the state of a primary for its standby to pull
+func (s *Syncer) State(ctx context.Context) ([]byte, error) {
+	if s.Config.Role != RolePrimary {
+		return nil, ErrNotPrimary
+	}
+	data, err := Export(ctx, s.DB)
+	if err != nil {
+		return nil, err
+	}
+	if s.Config.Mode == ModePull {
+		s.record(Revision(data), nil)
+	}
+	return data, nil
+}
+
+// Receive applies state pushed by the primary to a standby
+func (s *Syncer) Receive(ctx context.Context, data []byte) error {
+	if s.Config.Role != RoleStandby || s.Config.Mode != ModePush {
+		return ErrNotAccepted
+	}
+	if s.Promoted() {
+		return ErrPromoted
+	}
+	return s.Reconciler.Apply(ctx, data, Revision(data))
+}
+
+// Promote turns a standby into an instance of its own: replicated
+// repositories become editable and the primary's state is no longer
+// applied. It returns how many repositories were released. The role stays
+// standby until the configuration is changed, so a restart resumes
+// replication.
+func (s *Syncer) Promote(ctx context.Context) (int64, error) {
+	if s.Config.Role != RoleStandby {
+		return 0, errors.New("only a standby can be promoted")
+	}
+	s.mu.Lock()
+	s.promoted = true
+	s.mu.Unlock()
+
+	res, err := s.DB.ExecContext(ctx, `UPDATE repositories SET managed_by = NULL WHERE managed_by = $1`, ManagedBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release repositories: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	s.Reconciler.Cache.Invalidate(ctx, cache.Repositories)
+	log.Printf("Federation: standby promoted, %d repositories released", n)
+	return n, nil
+}
+
+// Promoted reports whether the standby has been promoted
+func (s *Syncer) Promoted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.promoted
+}
+
+func (s *Syncer) record(revision string, err error) {
+	now := s.Clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRunAt, s.lastError = &now, ""
+	if err != nil {
+		s.lastError = err.Error()
+		return
+	}
+	s.revision = revision
+}
+
+// pull reads the state of the primary
+func (s *Syncer) pull(ctx context.Context) ([]byte, error) {
+	req, err := s.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach primary: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("primary returned %s: %s", resp.Status, errorBody(resp.Body))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxStateSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state from primary: %w", err)
+	}
+	if len(data) > MaxStateSize {
+		return nil, fmt.Errorf("state of primary exceeds %d bytes", MaxStateSize)
+	}
+	return data, nil
+}
+
+// push sends the state to the standby
+func (s *Syncer) push(ctx context.Context, data []byte) error {
+	req, err := s.request(ctx, http.MethodPut, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach standby: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("standby returned %s: %s", resp.Status, errorBody(resp.Body))
+	}
+	return nil
+}
+
+func (s *Syncer) request(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.Config.PeerURL+StatePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.Config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Config.Token)
+	}
+	return req, nil
+}
+
+// errorBody returns the start of an error response
+func errorBody(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 1024))
+	return strings.TrimSpace(string(data))
+}
+
+// Revision identifies a state document by its content
+func Revision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,337 @@
+// Package git runs the git command line client on behalf of the replication
+// engine.
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitsync/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AuthFailurePattern is a case-insensitive POSIX regular expression
+// matching the errors git reports when a remote rejects its credentials
+const AuthFailurePattern = `authentication failed|could not read (username|password)|permission denied|access denied|` +
+	`returned error: 40[13]|invalid credentials|bad credentials`
+
+// Auth carries the credentials for one remote
+type Auth struct {
+	// Username and Password are sent as HTTP basic auth for http(s) remotes
+	Username string
+	Password string
+	// URL limits the credentials to requests below it; they are sent to
+	// every remote when empty
+	URL string
+	// Headers are extra HTTP headers sent along. An Authorization header
+	// among them is sent instead of Username and Password.
+	Headers map[string]string
+	// SSHKey is the private key, in OpenSSH format, ssh remotes are
+	// authenticated with instead of the keys of the user running git
+	SSHKey string
+}
+
+// Runner executes git commands with a fixed set of configuration overrides
+type Runner struct {
+	// Binary is the git executable, "git" when empty
+	Binary string
+	// Config is applied to every command as if passed with -c
+	Config map[string]string
+	// SSHControlDir enables SSH connection multiplexing: the first ssh
+	// remote operation against a host opens a master connection with its
+	// socket in this directory and later operations reuse it
+	SSHControlDir string
+	// SSHControlPersist is how long an idle master connection stays open
+	SSHControlPersist time.Duration
+	// KnownHostsFile is the only source of trusted SSH host keys when set;
+	// the known_hosts files of the system and user are then ignored
+	KnownHostsFile string
+	// AcceptNewHostKeys adds the key of a host missing from KnownHostsFile
+	// to it on first connection. Hosts presenting a key other than the one
+	// recorded are refused either way.
+	AcceptNewHostKeys bool
+}
+
+// NewRunner creates a Runner that multiplexes SSH connections through
+// sockets in controlDir. An empty controlDir disables multiplexing.
+func NewRunner(controlDir string, persist time.Duration) (*Runner, error) {
+	if controlDir != "" {
+		if err := os.MkdirAll(controlDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create SSH control directory: %w", err)
+		}
+	}
+	return &Runner{SSHControlDir: controlDir, SSHControlPersist: persist}, nil
+}
+
+// With returns a copy of r that applies config on top of its own
+func (r *Runner) With(config map[string]string) *Runner {
+	c := *r
+	c.Config = make(map[string]string, len(r.Config)+len(config))
+	maps.Copy(c.Config, r.Config)
+	maps.Copy(c.Config, config)
+	return &c
+}
+
+// Command is a single git invocation
+type Command struct {
+	Dir  string
+	Args []string
+	Auth *Auth
+	// Auths are further credentials, each scoped to its URL, for commands
+	// that talk to more than one remote
+	Auths []*Auth
+	// Config is applied on top of the runner's config
+	Config map[string]string
+	// Stdin is passed to the command when set
+	Stdin io.Reader
+	// Stdout receives the standard output when set, for output streamed to
+	// another command or too large to buffer; Run then returns none
+	Stdout io.Writer
+	// Stderr also receives the standard error when set, such as progress
+	// output; failures still include it in their error
+	Stderr io.Writer
+}
+
+// Run executes cmd and returns its standard output. Failures include git's
+// standard error in the returned error. Each command is traced as a span
+// named after its subcommand, such as git fetch; arguments are left out
+// since they may hold URLs with credentials.
+func (r *Runner) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "git "+subcommand(cmd.Args), attribute.String("git.subcommand", subcommand(cmd.Args)))
+	out, err := r.run(ctx, cmd)
+	tracing.End(span, err)
+	return out, err
+}
+
+func (r *Runner) run(ctx context.Context, cmd Command) ([]byte, error) {
+	binary := r.Binary
+	if binary == "" {
+		binary = "git"
+	}
+
+	c := exec.CommandContext(ctx, binary, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	// Configuration is passed through the environment so secrets never
+	// appear in the process list
+	config := make(map[string]string, len(r.Config)+len(cmd.Config)+2)
+	identity, err := writeIdentity(append([]*Auth{cmd.Auth}, cmd.Auths...))
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		defer os.Remove(identity.path)
+	}
+	if ssh := r.sshCommand(identity); ssh != "" {
+		config["core.sshCommand"] = ssh
+	}
+	for k, v := range r.Config {
+		config[k] = v
+	}
+	for k, v := range cmd.Config {
+		config[k] = v
+	}
+	// http.extraHeader takes one header per value, so these are passed
+	// as repeated keys
+	var headers [][2]string
+	for _, auth := range append([]*Auth{cmd.Auth}, cmd.Auths...) {
+		if auth == nil {
+			continue
+		}
+		key := "http.extraHeader"
+		if auth.URL != "" {
+			key = "http." + auth.URL + ".extraHeader"
+		}
+		authorized := false
+		for _, name := range slices.Sorted(maps.Keys(auth.Headers)) {
+			authorized = authorized || strings.EqualFold(name, "Authorization")
+			headers = append(headers, [2]string{key, name + ": " + auth.Headers[name]})
+		}
+		if !authorized && (auth.Username != "" || auth.Password != "") {
+			basic := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+			headers = append(headers, [2]string{key, "Authorization: Basic " + basic})
+		}
+	}
+	c.Env = append(c.Env, configEnv(config, headers)...)
+
+	var stdout, stderr bytes.Buffer
+	c.Stdin = cmd.Stdin
+	c.Stdout = &stdout
+	if cmd.Stdout != nil {
+		c.Stdout = cmd.Stdout
+	}
+	c.Stderr = &stderr
+	if cmd.Stderr != nil {
+		c.Stderr = io.MultiWriter(&stderr, cmd.Stderr)
+	}
+	if err := c.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("git %s: %w: %s", subcommand(cmd.Args), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// LsRemote lists the branches and tags of remote as ref name to SHA.
+// Peeled tag entries are omitted.
+func (r *Runner) LsRemote(ctx context.Context, remote string, auth *Auth) (map[string]string, error) {
+	refs, _, err := r.LsRemoteTags(ctx, remote, auth)
+	return refs, err
+}
+
+// LsRemoteTags is LsRemote that also returns the annotated tags of remote,
+// which are the ones listed with a peeled entry
+func (r *Runner) LsRemoteTags(ctx context.Context, remote string, auth *Auth) (map[string]string, map[string]bool, error) {
+	out, err := r.Run(ctx, Command{Args: []string{"ls-remote", "--heads", "--tags", remote}, Auth: auth})
+	if err != nil {
+		return nil, nil, err
+	}
+	refs := make(map[string]string)
+	annotated := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		sha, ref, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok {
+			continue
+		}
+		if tag, ok := strings.CutSuffix(ref, "^{}"); ok {
+			annotated[tag] = true
+			continue
+		}
+		refs[ref] = sha
+	}
+	return refs, annotated, nil
+}
+
+// ValidateFilter checks a partial clone filter. Supported are blob:none,
+// blob:limit=<n>[k|m|g] and tree:<depth>.
+func ValidateFilter(spec string) error {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "blob":
+		if arg == "none" {
+			return nil
+		}
+		if limit, ok := strings.CutPrefix(arg, "limit="); ok {
+			limit = strings.TrimRight(strings.ToLower(limit), "kmg")
+			if n, err := strconv.ParseUint(limit, 10, 64); err == nil && n > 0 {
+				return nil
+			}
+		}
+	case "tree":
+		if _, err := strconv.ParseUint(arg, 10, 32); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported filter %q. allowed: blob:none, blob:limit=<size>, tree:<depth>", spec)
+}
+
+// identityFile is a private key written out for ssh to read
+type identityFile struct {
+	path string
+	// id tells keys apart in control socket names
+	id string
+}
+
+// writeIdentity writes the first SSH key among auths to a file only the
+// current user can read. It returns nil when none carries a key.
+func writeIdentity(auths []*Auth) (*identityFile, error) {
+	for _, auth := range auths {
+		if auth == nil || auth.SSHKey == "" {
+			continue
+		}
+		f, err := os.CreateTemp("", "gitsync-key-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to write SSH key: %w", err)
+		}
+		key := strings.TrimSpace(auth.SSHKey) + "\n"
+		if _, err := f.WriteString(key); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, fmt.Errorf("failed to write SSH key: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			os.Remove(f.Name())
+			return nil, fmt.Errorf("failed to write SSH key: %w", err)
+		}
+		sum := sha256.Sum256([]byte(key))
+		return &identityFile{path: f.Name(), id: hex.EncodeToString(sum[:4])}, nil
+	}
+	return nil, nil
+}
+
+// sshCommand returns the ssh invocation that shares master connections,
+// checks host keys against KnownHostsFile and authenticates with identity,
+// or "" when none of these is set. %C hashes host, port and user so the
+// socket path stays short and distinct per destination; connections made
+// with an identity get sockets of their own, as the forge grants access by
+// the key a connection was opened with.
+func (r *Runner) sshCommand(identity *identityFile) string {
+	var opts []string
+	if identity != nil {
+		opts = append(opts, "-i "+identity.path, "-o IdentitiesOnly=yes")
+	}
+	if r.SSHControlDir != "" {
+		persist := int(r.SSHControlPersist.Seconds())
+		if persist <= 0 {
+			persist = 60
+		}
+		socket := "%C"
+		if identity != nil {
+			socket += "-" + identity.id
+		}
+		opts = append(opts, "-o ControlMaster=auto", "-o ControlPath="+filepath.Join(r.SSHControlDir, socket),
+			"-o ControlPersist="+strconv.Itoa(persist))
+	}
+	if r.KnownHostsFile != "" {
+		check := "yes"
+		if r.AcceptNewHostKeys {
+			check = "accept-new"
+		}
+		opts = append(opts, "-o UserKnownHostsFile="+r.KnownHostsFile, "-o GlobalKnownHostsFile=/dev/null",
+			"-o StrictHostKeyChecking="+check, "-o HashKnownHosts=no")
+	}
+	if len(opts) == 0 {
+		return ""
+	}
+	return "ssh " + strings.Join(opts, " ")
+}
+
+// configEnv passes config, followed by the key and value pairs of multi,
+// whose keys may repeat, through the environment
+func configEnv(config map[string]string, multi [][2]string) []string {
+	env := []string{"GIT_CONFIG_COUNT=" + strconv.Itoa(len(config)+len(multi))}
+	i := 0
+	add := func(k, v string) {
+		env = append(env,
+			"GIT_CONFIG_KEY_"+strconv.Itoa(i)+"="+k,
+			"GIT_CONFIG_VALUE_"+strconv.Itoa(i)+"="+v)
+		i++
+	}
+	for k, v := range config {
+		add(k, v)
+	}
+	for _, kv := range multi {
+		add(kv[0], kv[1])
+	}
+	return env
+}
+
+func subcommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
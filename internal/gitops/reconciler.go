@@ -0,0 +1,763 @@
+// Package gitops keeps repositories, their targets and schedules in line
+// with a state file stored in a git repository, so the mirror fleet itself
+// is configuration as code. Differences that are not applied are reported
+// as drift.
+package gitops
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/policy"
+	"gitsync/internal/provider"
+	"gitsync/internal/validation"
+
+	"github.com/lib/pq"
+)
+
+// ManagedBy marks repositories declared in the state file. It is also the
+// actor of their audit entries.
+const ManagedBy = "gitops"
+
+// Config locates the state file and decides how differences are handled
+type Config struct {
+	RepoURL string
+	// Ref is the branch or tag to read; the remote's default branch when empty
+	Ref  string
+	Path string
+	Auth *git.Auth
+	// Prune deletes managed repositories and targets that are no longer
+	// declared; without it they are only reported as drift
+	Prune bool
+	// DryRun reports every difference as drift and changes nothing
+	DryRun bool
+}
+
+// ConfigFromEnv reads GITOPS_REPO_URL, GITOPS_REF, GITOPS_PATH (default
+// gitsync.yaml), GITOPS_PROVIDER and GITOPS_TOKEN for authentication,
+// GITOPS_PRUNE and GITOPS_DRY_RUN. GitOps is disabled without a repository URL.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		RepoURL: os.Getenv("GITOPS_REPO_URL"),
+		Ref:     os.Getenv("GITOPS_REF"),
+		Path:    os.Getenv("GITOPS_PATH"),
+		Prune:   os.Getenv("GITOPS_PRUNE") == "true",
+		DryRun:  os.Getenv("GITOPS_DRY_RUN") == "true",
+	}
+	if cfg.Path == "" {
+		cfg.Path = "gitsync.yaml"
+	}
+	if token := os.Getenv("GITOPS_TOKEN"); token != "" {
+		p, err := provider.Lookup(os.Getenv("GITOPS_PROVIDER"))
+		if err != nil {
+			return cfg, fmt.Errorf("GITOPS_PROVIDER: %w", err)
+		}
+		user, pass := p.GitAuth(token)
+		cfg.Auth = &git.Auth{Username: user, Password: pass}
+	}
+	return cfg, nil
+}
+
+// Enabled reports whether a state repository is configured
+func (c Config) Enabled() bool {
+	return c.RepoURL != ""
+}
+
+// Change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is one difference between the state file and the database
+type Change struct {
+	Action    string `json:"action"`
+	Kind      string `json:"kind"` // repository or target
+	SourceURL string `json:"source_url"`
+	RemoteURL string `json:"remote_url,omitempty"`
+	// Fields lists what an update changes
+	Fields []string `json:"fields,omitempty"`
+	// Error explains why a change could not be applied, such as a policy
+	// blocking it
+	Error string `json:"error,omitempty"`
+}
+
+// Status is the outcome of the last reconciliation
+type Status struct {
+	Enabled   bool       `json:"enabled"`
+	RepoURL   string     `json:"repo_url,omitempty"`
+	Ref       string     `json:"ref,omitempty"`
+	Path      string     `json:"path,omitempty"`
+	Prune     bool       `json:"prune"`
+	DryRun    bool       `json:"dry_run"`
+	Revision  string     `json:"revision,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Applied   []Change   `json:"applied"`
+	Drift     []Change   `json:"drift"`
+}
+
+// Reconciler periodically applies the state file to the database
+type Reconciler struct {
+	DB          *database.DB
+	Git         *git.Runner
+	Credentials *credentials.Store
+	URLPolicy   validation.URLPolicy
+	Policies    *policy.Engine
+	Cache       *cache.Cache
+	Clock       clock.Clock
+	IDs         ids.Generator
+	Config      Config
+	// Owner marks the repositories the reconciler declares and is the actor
+	// of their audit entries; ManagedBy unless set
+	Owner string
+	// KeepUnmanaged leaves declared repositories that exist without a
+	// manager as they are, reporting them as drift, instead of taking them
+	// over
+	KeepUnmanaged bool
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewReconciler creates a Reconciler
+func NewReconciler(db *database.DB, runner *git.Runner, creds *credentials.Store, urls validation.URLPolicy,
+	policies *policy.Engine, c *cache.Cache, clk clock.Clock, gen ids.Generator, cfg Config) *Reconciler {
+	return &Reconciler{
+		DB: db, Git: runner, Credentials: creds, URLPolicy: urls, Policies: policies, Cache: c, Clock: clk, IDs: gen,
+		Config: cfg, Owner: ManagedBy,
+		status: Status{
+			Enabled: cfg.Enabled(), RepoURL: cfg.RepoURL, Ref: cfg.Ref, Path: cfg.Path,
+			Prune: cfg.Prune, DryRun: cfg.DryRun, Applied: []Change{}, Drift: []Change{},
+		},
+	}
+}
+
+// Status returns the outcome of the last reconciliation
+func (r *Reconciler) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Run reconciles periodically until ctx is cancelled. It does nothing when
+// no state repository is configured.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	if !r.Config.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: gitops reconcile failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile reads the state file once and applies it
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	now := r.Clock.Now()
+	data, revision, err := r.fetch(ctx)
+	if err != nil {
+		return r.finish(now, "", nil, nil, err)
+	}
+	return r.apply(ctx, now, data, revision)
+}
+
+// Apply applies a state document read elsewhere, such as from another
+// instance, and records the outcome in the status under revision
+func (r *Reconciler) Apply(ctx context.Context, data []byte, revision string) error {
+	return r.apply(ctx, r.Clock.Now(), data, revision)
+}
+
+// Fail records a reconciliation that failed before the state was read
+func (r *Reconciler) Fail(err error) {
+	r.finish(r.Clock.Now(), "", nil, nil, err)
+}
+
+func (r *Reconciler) apply(ctx context.Context, now time.Time, data []byte, revision string) error {
+	applied, drift, err := r.reconcile(ctx, data, revision)
+	return r.finish(now, revision, applied, drift, err)
+}
+
+func (r *Reconciler) finish(now time.Time, revision string, applied, drift []Change, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.LastRunAt = &now
+	r.status.LastError = ""
+	if err != nil {
+		// Keep reporting the last successful comparison
+		r.status.LastError = err.Error()
+		return err
+	}
+	r.status.Revision, r.status.Applied, r.status.Drift = revision, applied, drift
+	return nil
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, data []byte, revision string) ([]Change, []Change, error) {
+	state, err := Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := state.Validate(r.URLPolicy); err != nil {
+		return nil, nil, err
+	}
+
+	p, err := r.plan(ctx, state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied, drift := []Change{}, p.drift
+	if r.Config.DryRun {
+		for _, op := range p.ops {
+			drift = append(drift, op.change)
+		}
+		return applied, drift, nil
+	}
+	if len(p.ops) == 0 {
+		return applied, drift, nil
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	for _, op := range p.ops {
+		if err := op.apply(ctx, tx); err != nil {
+			return nil, nil, fmt.Errorf("failed to %s %s %s: %w", op.change.Action, op.change.Kind, op.url(), err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	r.Cache.Invalidate(ctx, cache.Repositories)
+	for _, op := range p.ops {
+		audit.Record(ctx, r.DB, r.Owner, op.change.Kind+"."+op.change.Action, op.change.Kind, op.id,
+			map[string]any{"url": op.url(), "fields": op.change.Fields, "revision": revision})
+		applied = append(applied, op.change)
+	}
+	log.Printf("%s: applied %d changes from revision %s", r.Owner, len(applied), revision)
+	return applied, drift, nil
+}
+
+// fetch returns the state file at the configured ref and the commit it
+// was read from. Each read is a fresh shallow clone; state repositories
+// are small.
+func (r *Reconciler) fetch(ctx context.Context) ([]byte, string, error) {
+	dir, err := os.MkdirTemp("", "gitsync-gitops-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"clone", "--quiet", "--depth", "1", "--no-checkout"}
+	if r.Config.Ref != "" {
+		args = append(args, "--branch", r.Config.Ref)
+	}
+	args = append(args, r.Config.RepoURL, dir)
+	if _, err := r.Git.Run(ctx, git.Command{Args: args, Auth: r.Config.Auth}); err != nil {
+		return nil, "", fmt.Errorf("failed to fetch state repository: %w", err)
+	}
+	revision, err := r.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"rev-parse", "HEAD"}})
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := r.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"show", "HEAD:" + r.Config.Path}})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", r.Config.Path, err)
+	}
+	return data, strings.TrimSpace(string(revision)), nil
+}
+
+// op is a change and the statement that applies it
+type op struct {
+	change Change
+	id     string
+	apply  func(ctx context.Context, tx *sql.Tx) error
+}
+
+func (o op) url() string {
+	if o.change.RemoteURL != "" {
+		return o.change.RemoteURL
+	}
+	return o.change.SourceURL
+}
+
+type plan struct {
+	ops   []op
+	drift []Change
+}
+
+// current is a repository as stored, with its targets by remote URL
+type current struct {
+	repo    models.Repository
+	targets map[string]models.Target
+}
+
+func (r *Reconciler) plan(ctx context.Context, state *State) (*plan, error) {
+	existing, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := r.Policies.Enabled(ctx)
+	if err != nil {
+		return nil, err
+	}
+	credentialIDs := make(map[string]*string)
+
+	p := &plan{}
+	declared := make(map[string]bool)
+	for _, d := range state.Repositories {
+		canonical, _ := provider.CanonicalURL(d.SourceURL)
+		declared[canonical] = true
+
+		credentialID, err := r.credential(ctx, credentialIDs, d.Credential, d.SourceProvider)
+		if err != nil {
+			return nil, fmt.Errorf("repository %s: %w", d.SourceURL, err)
+		}
+		want := desiredRepository(d, credentialID, r.Owner)
+
+		cur, ok := existing[canonical]
+		if !ok {
+			if msg := blocked(policies, policy.Subject{Repository: want}); msg != "" {
+				p.drift = append(p.drift, Change{Action: ActionCreate, Kind: "repository", SourceURL: want.SourceURL, Error: msg})
+				continue
+			}
+			want.ID, want.CreatedAt = r.IDs.NewID(), r.Clock.Now()
+			p.ops = append(p.ops, r.createRepository(want))
+			cur = &current{repo: want, targets: map[string]models.Target{}}
+		} else {
+			if msg := r.conflict(cur.repo); msg != "" {
+				p.drift = append(p.drift, Change{Action: ActionUpdate, Kind: "repository", SourceURL: want.SourceURL, Error: msg})
+				continue
+			}
+			want.ID = cur.repo.ID
+			if fields := repositoryDiff(cur.repo, want); len(fields) > 0 {
+				p.ops = append(p.ops, r.updateRepository(want, fields))
+			}
+		}
+
+		if err := r.planTargets(ctx, p, policies, credentialIDs, want, d.Targets, cur.targets); err != nil {
+			return nil, err
+		}
+	}
+
+	for canonical, cur := range existing {
+		if declared[canonical] || cur.repo.ManagedBy == nil || *cur.repo.ManagedBy != r.Owner {
+			continue
+		}
+		change := Change{Action: ActionDelete, Kind: "repository", SourceURL: cur.repo.SourceURL}
+		if !r.Config.Prune {
+			p.drift = append(p.drift, change)
+			continue
+		}
+		p.ops = append(p.ops, r.deleteRepository(cur.repo.ID, change))
+	}
+	return p, nil
+}
+
+func (r *Reconciler) planTargets(ctx context.Context, p *plan, policies []models.Policy, credentialIDs map[string]*string,
+	repo models.Repository, declared []Target, existing map[string]models.Target) error {
+	seen := make(map[string]bool)
+	for _, d := range declared {
+		seen[d.RemoteURL] = true
+		credentialID, err := r.credential(ctx, credentialIDs, d.Credential, d.Provider)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", d.RemoteURL, err)
+		}
+
+		priority, required := models.DefaultTargetPriority, true
+		if d.Priority != nil {
+			priority = *d.Priority
+		}
+		if d.Required != nil {
+			required = *d.Required
+		}
+		excludeRefs := d.ExcludeRefs
+		if excludeRefs == nil {
+			excludeRefs = []string{}
+		}
+		var tagPattern, subdirectory *string
+		if d.TagPattern != "" {
+			tagPattern = &d.TagPattern
+		}
+		if d.Subdirectory != "" {
+			subdirectory = &d.Subdirectory
+		}
+
+		cur, ok := existing[d.RemoteURL]
+		if !ok {
+			t := models.Target{
+				RepositoryID:      repo.ID,
+				Provider:          d.Provider,
+				RemoteURL:         d.RemoteURL,
+				CredentialID:      credentialID,
+				Priority:          priority,
+				Required:          required,
+				ExcludeRefs:       excludeRefs,
+				Mode:              d.Mode,
+				TagPattern:        tagPattern,
+				AnnotatedTagsOnly: d.AnnotatedTagsOnly,
+				Subdirectory:      subdirectory,
+				Rewrite:           d.Rewrite,
+				ApprovalState:     models.ApprovalApproved,
+			}
+			if msg := blocked(policies, policy.Subject{Repository: repo, Target: &t}); msg != "" {
+				p.drift = append(p.drift, Change{Action: ActionCreate, Kind: "target", SourceURL: repo.SourceURL, RemoteURL: t.RemoteURL, Error: msg})
+				continue
+			}
+			t.ID, t.CreatedAt = r.IDs.NewID(), r.Clock.Now()
+			p.ops = append(p.ops, r.createTarget(repo.SourceURL, t))
+			continue
+		}
+
+		var fields []string
+		if cur.Provider != d.Provider {
+			fields = append(fields, "provider")
+		}
+		if !equalPtr(cur.CredentialID, credentialID) {
+			fields = append(fields, "credential")
+		}
+		if cur.Priority != priority {
+			fields = append(fields, "priority")
+		}
+		if cur.Required != required {
+			fields = append(fields, "required")
+		}
+		if !slices.Equal(cur.ExcludeRefs, excludeRefs) {
+			fields = append(fields, "exclude_refs")
+		}
+		if cur.Mode != d.Mode || !equalPtr(cur.TagPattern, tagPattern) || cur.AnnotatedTagsOnly != d.AnnotatedTagsOnly ||
+			!equalPtr(cur.Subdirectory, subdirectory) {
+			fields = append(fields, "mode")
+		}
+		if !equalRewrite(cur.Rewrite, d.Rewrite) {
+			fields = append(fields, "rewrite")
+		}
+		if len(fields) > 0 {
+			cur.Provider, cur.CredentialID, cur.Priority, cur.Required = d.Provider, credentialID, priority, required
+			cur.ExcludeRefs, cur.Mode, cur.TagPattern, cur.AnnotatedTagsOnly = excludeRefs, d.Mode, tagPattern, d.AnnotatedTagsOnly
+			cur.Subdirectory, cur.Rewrite = subdirectory, d.Rewrite
+			p.ops = append(p.ops, r.updateTarget(repo.SourceURL, cur, fields))
+		}
+	}
+
+	for url, cur := range existing {
+		if seen[url] {
+			continue
+		}
+		change := Change{Action: ActionDelete, Kind: "target", SourceURL: repo.SourceURL, RemoteURL: url}
+		if !r.Config.Prune {
+			p.drift = append(p.drift, change)
+			continue
+		}
+		p.ops = append(p.ops, r.deleteTarget(cur.ID, change))
+	}
+	return nil
+}
+
+// conflict returns why an existing repository must not be changed, or ""
+// when the reconciler may manage it. Repositories of another manager are
+// never taken over, so two reconcilers cannot undo each other's changes.
+func (r *Reconciler) conflict(repo models.Repository) string {
+	switch {
+	case repo.ManagedBy == nil && r.KeepUnmanaged:
+		return "conflict: the repository is managed locally"
+	case repo.ManagedBy != nil && *repo.ManagedBy != r.Owner:
+		return "conflict: the repository is managed by " + *repo.ManagedBy
+	}
+	return ""
+}
+
+// blocked returns why policies forbid creating s, or "" when none does.
+// Unlike the API, blocked declarations are reported as drift rather than
+// recorded as violations, which would repeat on every reconciliation.
+func blocked(policies []models.Policy, s policy.Subject) string {
+	for _, p := range policies {
+		if msg := policy.Check(p, s); msg != "" {
+			return fmt.Sprintf("blocked by policy %q: %s", p.Name, msg)
+		}
+	}
+	return ""
+}
+
+// credential resolves a credential profile name, caching lookups for the
+// duration of one reconciliation
+func (r *Reconciler) credential(ctx context.Context, cache map[string]*string, name, providerName string) (*string, error) {
+	if name == "" {
+		return nil, nil
+	}
+	key := providerName + "/" + name
+	if id, ok := cache[key]; ok {
+		return id, nil
+	}
+	cred, err := r.Credentials.Get(ctx, name)
+	if errors.Is(err, credentials.ErrNotFound) {
+		return nil, fmt.Errorf("credential %q not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cred.Provider != providerName {
+		return nil, fmt.Errorf("credential %q belongs to provider %s", name, cred.Provider)
+	}
+	cache[key] = &cred.ID
+	return &cred.ID, nil
+}
+
+// load returns every repository by canonical source URL with its targets
+func (r *Reconciler) load(ctx context.Context) (map[string]*current, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, alternate_source_urls, credential_id, archive_action, labels, owner, team,
+		        sync_slo_seconds, clone_filter, cache_pool, sync_interval_seconds, sync_schedule, sync_window, managed_by
+		 FROM repositories`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch repositories: %w", err)
+	}
+	defer rows.Close()
+
+	repos := make(map[string]*current)
+	byID := make(map[string]*current)
+	for rows.Next() {
+		var repo models.Repository
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, pq.Array(&repo.AlternateSourceURLs), &repo.CredentialID,
+			&repo.ArchiveAction, &repo.Labels, &repo.Owner, &repo.Team, &repo.SyncSLOSeconds, &repo.CloneFilter,
+			&repo.CachePool, &repo.SyncIntervalSeconds, &repo.SyncSchedule, &repo.SyncWindow, &repo.ManagedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan repository: %w", err)
+		}
+		c := &current{repo: repo, targets: make(map[string]models.Target)}
+		canonical, _ := provider.CanonicalURL(repo.SourceURL)
+		repos[canonical] = c
+		byID[repo.ID] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.DB.QueryContext(ctx, `SELECT id, repository_id, provider, remote_url, credential_id, priority, required, exclude_refs, mode, tag_pattern,
+		        annotated_tags_only, subdirectory, rewrite
+		 FROM replication_targets`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch targets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.Target
+		if err := rows.Scan(&t.ID, &t.RepositoryID, &t.Provider, &t.RemoteURL, &t.CredentialID, &t.Priority, &t.Required,
+			pq.Array(&t.ExcludeRefs), &t.Mode, &t.TagPattern, &t.AnnotatedTagsOnly, &t.Subdirectory, &t.Rewrite); err != nil {
+			return nil, fmt.Errorf("failed to scan target: %w", err)
+		}
+		if c, ok := byID[t.RepositoryID]; ok {
+			c.targets[t.RemoteURL] = t
+		}
+	}
+	return repos, rows.Err()
+}
+
+func desiredRepository(d Repository, credentialID *string, managedBy string) models.Repository {
+	repo := models.Repository{
+		Name:                d.Name,
+		SourceProvider:      d.SourceProvider,
+		SourceURL:           d.SourceURL,
+		AlternateSourceURLs: d.AlternateSourceURLs,
+		CredentialID:        credentialID,
+		SourceState:         models.SourceActive,
+		Labels:              models.Labels(d.Labels),
+		SyncSLOSeconds:      d.SyncSLOSeconds,
+		SyncWindow:          d.SyncWindow,
+		ManagedBy:           &managedBy,
+	}
+	if repo.Labels == nil {
+		repo.Labels = models.Labels{}
+	}
+	if repo.AlternateSourceURLs == nil {
+		repo.AlternateSourceURLs = []string{}
+	}
+	repo.SyncIntervalSeconds, _ = d.interval()
+	for field, value := range map[**string]string{
+		&repo.ArchiveAction: d.ArchiveAction,
+		&repo.Owner:         strings.TrimSpace(d.Owner),
+		&repo.Team:          strings.TrimSpace(d.Team),
+		&repo.CloneFilter:   d.CloneFilter,
+		&repo.CachePool:     strings.TrimSpace(d.CachePool),
+		&repo.SyncSchedule:  d.SyncSchedule,
+	} {
+		if value != "" {
+			*field = &value
+		}
+	}
+	return repo
+}
+
+// repositoryDiff names the fields of cur that differ from want
+func repositoryDiff(cur, want models.Repository) []string {
+	var fields []string
+	for _, f := range []struct {
+		name  string
+		equal bool
+	}{
+		{"name", cur.Name == want.Name},
+		{"source_provider", cur.SourceProvider == want.SourceProvider},
+		{"source_url", cur.SourceURL == want.SourceURL},
+		{"alternate_source_urls", slices.Equal(cur.AlternateSourceURLs, want.AlternateSourceURLs)},
+		{"credential", equalPtr(cur.CredentialID, want.CredentialID)},
+		{"archive_action", equalPtr(cur.ArchiveAction, want.ArchiveAction)},
+		{"labels", maps.Equal(cur.Labels, want.Labels)},
+		{"owner", equalPtr(cur.Owner, want.Owner)},
+		{"team", equalPtr(cur.Team, want.Team)},
+		{"sync_slo_seconds", equalPtr(cur.SyncSLOSeconds, want.SyncSLOSeconds)},
+		{"clone_filter", equalPtr(cur.CloneFilter, want.CloneFilter)},
+		{"cache_pool", equalPtr(cur.CachePool, want.CachePool)},
+		{"sync_interval", equalPtr(cur.SyncIntervalSeconds, want.SyncIntervalSeconds)},
+		{"sync_schedule", equalPtr(cur.SyncSchedule, want.SyncSchedule)},
+		{"sync_window", equalPtr(cur.SyncWindow, want.SyncWindow)},
+		{"managed_by", equalPtr(cur.ManagedBy, want.ManagedBy)},
+	} {
+		if !f.equal {
+			fields = append(fields, f.name)
+		}
+	}
+	return fields
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// equalRewrite reports whether a and b rewrite history the same way
+func equalRewrite(a, b *models.RewriteRules) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.MaxBlobMB == b.MaxBlobMB && slices.Equal(a.ExcludePaths, b.ExcludePaths)
+}
+
+func (r *Reconciler) createRepository(repo models.Repository) op {
+	return op{
+		change: Change{Action: ActionCreate, Kind: "repository", SourceURL: repo.SourceURL},
+		id:     repo.ID,
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO repositories (id, name, source_provider, source_url, credential_id, archive_action, labels, owner, team,
+				     sync_slo_seconds, clone_filter, cache_pool, sync_interval_seconds, managed_by, alternate_source_urls, sync_window, created_at, sync_schedule)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
+				repo.ID, repo.Name, repo.SourceProvider, repo.SourceURL, repo.CredentialID, repo.ArchiveAction, repo.Labels,
+				repo.Owner, repo.Team, repo.SyncSLOSeconds, repo.CloneFilter, repo.CachePool, repo.SyncIntervalSeconds,
+				repo.ManagedBy, pq.Array(repo.AlternateSourceURLs), repo.SyncWindow, repo.CreatedAt, repo.SyncSchedule)
+			return err
+		},
+	}
+}
+
+func (r *Reconciler) updateRepository(repo models.Repository, fields []string) op {
+	return op{
+		change: Change{Action: ActionUpdate, Kind: "repository", SourceURL: repo.SourceURL, Fields: fields},
+		id:     repo.ID,
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx,
+				`UPDATE repositories SET name = $2, source_provider = $3, credential_id = $4, archive_action = $5, labels = $6,
+				     owner = $7, team = $8, sync_slo_seconds = $9, clone_filter = $10, cache_pool = $11,
+				     sync_interval_seconds = $12, managed_by = $13, alternate_source_urls = $14, sync_window = $15, source_url = $16,
+				     sync_schedule = $17
+				 WHERE id = $1`,
+				repo.ID, repo.Name, repo.SourceProvider, repo.CredentialID, repo.ArchiveAction, repo.Labels,
+				repo.Owner, repo.Team, repo.SyncSLOSeconds, repo.CloneFilter, repo.CachePool,
+				repo.SyncIntervalSeconds, repo.ManagedBy, pq.Array(repo.AlternateSourceURLs), repo.SyncWindow, repo.SourceURL,
+				repo.SyncSchedule)
+			return err
+		},
+	}
+}
+
+// deleteRepository removes a repository together with its sync history,
+// which does not cascade
+func (r *Reconciler) deleteRepository(id string, change Change) op {
+	return op{
+		change: change,
+		id:     id,
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM executions WHERE repository_id = $1`, id); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `DELETE FROM repositories WHERE id = $1`, id)
+			return err
+		},
+	}
+}
+
+func (r *Reconciler) createTarget(sourceURL string, t models.Target) op {
+	return op{
+		change: Change{Action: ActionCreate, Kind: "target", SourceURL: sourceURL, RemoteURL: t.RemoteURL},
+		id:     t.ID,
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO replication_targets (id, repository_id, provider, remote_url, credential_id, priority, required, exclude_refs,
+				     mode, tag_pattern, annotated_tags_only, subdirectory, rewrite, approval_state, created_by, created_at)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+				t.ID, t.RepositoryID, t.Provider, t.RemoteURL, t.CredentialID, t.Priority, t.Required, pq.Array(t.ExcludeRefs),
+				t.Mode, t.TagPattern, t.AnnotatedTagsOnly, t.Subdirectory, t.Rewrite, t.ApprovalState, r.Owner, t.CreatedAt)
+			return err
+		},
+	}
+}
+
+func (r *Reconciler) updateTarget(sourceURL string, t models.Target, fields []string) op {
+	return op{
+		change: Change{Action: ActionUpdate, Kind: "target", SourceURL: sourceURL, RemoteURL: t.RemoteURL, Fields: fields},
+		id:     t.ID,
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx,
+				`UPDATE replication_targets SET provider = $2, credential_id = $3, priority = $4, required = $5, exclude_refs = $6,
+				     mode = $7, tag_pattern = $8, annotated_tags_only = $9, subdirectory = $10, rewrite = $11
+				 WHERE id = $1`,
+				t.ID, t.Provider, t.CredentialID, t.Priority, t.Required, pq.Array(t.ExcludeRefs),
+				t.Mode, t.TagPattern, t.AnnotatedTagsOnly, t.Subdirectory, t.Rewrite)
+			return err
+		},
+	}
+}
+
+func (r *Reconciler) deleteTarget(id string, change Change) op {
+	return op{
+		change: change,
+		id:     id,
+		apply: func(ctx context.Context, tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM executions WHERE target_id = $1`, id); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `DELETE FROM replication_targets WHERE id = $1`, id)
+			return err
+		},
+	}
+}
@@ -0,0 +1,213 @@
+package gitops
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gitsync/internal/git"
+	"gitsync/internal/labels"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/schedule"
+	"gitsync/internal/validation"
+
+	"gopkg.in/yaml.v2"
+)
+
+// State is the desired configuration read from the state file:
+//
+//	repositories:
+//	  - name: api
+//	    source_provider: github
+//	    source_url: https://github.com/acme/api
+//	    credential: github-bot
+//	    labels: {tier: prod}
+//	    team: platform
+//	    sync_interval: 30m
+//	    sync_schedule: "0 */6 * * *"
+//	    sync_window: {start: "22:00", end: "06:00", timezone: Europe/Berlin}
+//	    targets:
+//	      - provider: gitlab
+//	        remote_url: https://gitlab.example.com/mirrors/api
+//	        exclude_refs: [refs/heads/internal/*]
+type State struct {
+	Repositories []Repository `yaml:"repositories"`
+}
+
+// Repository is a declared repository and its targets
+type Repository struct {
+	Name           string `yaml:"name"`
+	SourceProvider string `yaml:"source_provider"`
+	SourceURL      string `yaml:"source_url"`
+	// AlternateSourceURLs are fetched in order when SourceURL is unreachable
+	AlternateSourceURLs []string          `yaml:"alternate_source_urls"`
+	Credential          string            `yaml:"credential"`
+	Labels              map[string]string `yaml:"labels"`
+	Owner               string            `yaml:"owner"`
+	Team                string            `yaml:"team"`
+	ArchiveAction       string            `yaml:"archive_action"`
+	SyncSLOSeconds      *int              `yaml:"sync_slo_seconds"`
+	CloneFilter         string            `yaml:"clone_filter"`
+	CachePool           string            `yaml:"cache_pool"`
+	// SyncInterval is a duration such as 30m that replaces the adaptive
+	// interval; empty keeps the adaptive schedule
+	SyncInterval string `yaml:"sync_interval"`
+	// SyncSchedule is a cron expression that schedules syncs instead of
+	// SyncInterval
+	SyncSchedule string `yaml:"sync_schedule"`
+	// SyncWindow restricts when scheduled syncs may start
+	SyncWindow *models.SyncWindow `yaml:"sync_window"`
+	Targets    []Target           `yaml:"targets"`
+}
+
+// Target is a declared replication target
+type Target struct {
+	Provider   string `yaml:"provider"`
+	RemoteURL  string `yaml:"remote_url"`
+	Credential string `yaml:"credential"`
+	// Priority defaults to 100 and Required to true, as in the API
+	Priority *int  `yaml:"priority"`
+	Required *bool `yaml:"required"`
+	// ExcludeRefs are ref patterns never pushed to the target
+	ExcludeRefs []string `yaml:"exclude_refs"`
+	// Mode defaults to all; tags targets take TagPattern and
+	// AnnotatedTagsOnly and subdirectory targets Subdirectory, as in the API
+	Mode              string `yaml:"mode"`
+	TagPattern        string `yaml:"tag_pattern"`
+	AnnotatedTagsOnly bool   `yaml:"annotated_tags_only"`
+	Subdirectory      string `yaml:"subdirectory"`
+	// Rewrite filters the history pushed to the target
+	Rewrite *models.RewriteRules `yaml:"rewrite"`
+}
+
+// Parse decodes a state file. Unknown fields are rejected so typos do not
+// silently fall back to defaults.
+func Parse(data []byte) (*State, error) {
+	var s State
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid state file: %w", err)
+	}
+	return &s, nil
+}
+
+// Validate checks every declaration and normalizes URLs with urls, the way
+// the API does for created repositories and targets
+func (s *State) Validate(urls validation.URLPolicy) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i := range s.Repositories {
+		r := &s.Repositories[i]
+		if err := r.validate(urls); err != nil {
+			errs = append(errs, fmt.Errorf("repositories[%d]: %w", i, err))
+			continue
+		}
+		canonical, _ := provider.CanonicalURL(r.SourceURL)
+		if seen[canonical] {
+			errs = append(errs, fmt.Errorf("repositories[%d]: duplicate source_url %s", i, r.SourceURL))
+		}
+		seen[canonical] = true
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Repository) validate(urls validation.URLPolicy) error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if _, err := provider.Lookup(r.SourceProvider); err != nil {
+		return fmt.Errorf("invalid source_provider: %w", err)
+	}
+	sourceURL, err := urls.RepoURL(r.SourceProvider, r.SourceURL)
+	if err != nil {
+		return fmt.Errorf("invalid source_url: %w", err)
+	}
+	r.SourceURL = sourceURL
+	for i, u := range r.AlternateSourceURLs {
+		alternate, err := urls.RepoURL(r.SourceProvider, u)
+		if err != nil {
+			return fmt.Errorf("invalid alternate_source_urls[%d]: %w", i, err)
+		}
+		if alternate == r.SourceURL || slices.Contains(r.AlternateSourceURLs[:i], alternate) {
+			return fmt.Errorf("alternate_source_urls[%d] duplicates another source URL", i)
+		}
+		r.AlternateSourceURLs[i] = alternate
+	}
+
+	if err := labels.Validate(r.Labels); err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
+	switch r.ArchiveAction {
+	case "", models.ArchiveActionFlag, models.ArchiveActionArchive, models.ArchiveActionBanner:
+	default:
+		return errors.New("invalid archive_action. allowed: flag, archive, banner")
+	}
+	if r.SyncSLOSeconds != nil && *r.SyncSLOSeconds <= 0 {
+		return errors.New("sync_slo_seconds must be positive")
+	}
+	if r.CloneFilter != "" {
+		if err := git.ValidateFilter(r.CloneFilter); err != nil {
+			return fmt.Errorf("invalid clone_filter: %w", err)
+		}
+	}
+	if _, err := r.interval(); err != nil {
+		return err
+	}
+	if r.SyncWindow != nil {
+		if err := schedule.ValidateWindow(*r.SyncWindow); err != nil {
+			return fmt.Errorf("invalid sync_window: %w", err)
+		}
+	}
+	r.SyncSchedule = strings.TrimSpace(r.SyncSchedule)
+	if r.SyncSchedule != "" {
+		if _, err := schedule.ParseCron(r.SyncSchedule); err != nil {
+			return fmt.Errorf("invalid sync_schedule: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range r.Targets {
+		t := &r.Targets[i]
+		if _, err := provider.Lookup(t.Provider); err != nil {
+			return fmt.Errorf("targets[%d]: invalid provider: %w", i, err)
+		}
+		remoteURL, err := urls.RepoURL(t.Provider, t.RemoteURL)
+		if err != nil {
+			return fmt.Errorf("targets[%d]: invalid remote_url: %w", i, err)
+		}
+		if seen[remoteURL] {
+			return fmt.Errorf("targets[%d]: duplicate remote_url %s", i, remoteURL)
+		}
+		if err := replication.ValidateRefPatterns(t.ExcludeRefs); err != nil {
+			return fmt.Errorf("targets[%d]: invalid exclude_refs: %w", i, err)
+		}
+		if t.Mode == "" {
+			t.Mode = models.TargetModeAll
+		}
+		if err := replication.ValidateMode(t.Mode, t.TagPattern, t.AnnotatedTagsOnly, t.Subdirectory); err != nil {
+			return fmt.Errorf("targets[%d]: %w", i, err)
+		}
+		if err := replication.ValidateRewrite(t.Rewrite); err != nil {
+			return fmt.Errorf("targets[%d]: invalid rewrite: %w", i, err)
+		}
+		seen[remoteURL] = true
+		t.RemoteURL = remoteURL
+	}
+	return nil
+}
+
+// interval returns SyncInterval in seconds, nil when unset
+func (r *Repository) interval() (*int, error) {
+	if r.SyncInterval == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(r.SyncInterval)
+	if err != nil || d < time.Second {
+		return nil, errors.New("sync_interval must be a duration of at least 1s")
+	}
+	seconds := int(d / time.Second)
+	return &seconds, nil
+}
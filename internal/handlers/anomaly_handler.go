@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// AnomalyHandler serves the anomalous source changes that pause syncs and
+// lets admins acknowledge them
+type AnomalyHandler struct {
+	DB       *database.DB
+	Notifier *notify.Dispatcher
+	Clock    clock.Clock
+}
+
+// NewAnomalyHandler creates a new AnomalyHandler
+func NewAnomalyHandler(db *database.DB, notifier *notify.Dispatcher, clk clock.Clock) *AnomalyHandler {
+	return &AnomalyHandler{DB: db, Notifier: notifier, Clock: clk}
+}
+
+const anomalyColumns = `id, repository_id, kinds, size_before, size_after, deleted, rewritten, acknowledged, acknowledged_by,
+	acknowledged_at, created_at`
+
+func scanAnomaly(row rowScanner) (models.Anomaly, error) {
+	var a models.Anomaly
+	err := row.Scan(&a.ID, &a.RepositoryID, pq.Array(&a.Kinds), &a.SizeBefore, &a.SizeAfter, pq.Array(&a.Deleted),
+		pq.Array(&a.Rewritten), &a.Acknowledged, &a.AcknowledgedBy, &a.AcknowledgedAt, &a.CreatedAt)
+	return a, err
+}
+
+// ListAnomalies handles GET /anomalies, optionally filtered by
+// repository_id and acknowledged
+func (h *AnomalyHandler) ListAnomalies(w http.ResponseWriter, r *http.Request) {
+	var conds []string
+	var args []any
+	if v := r.URL.Query().Get("repository_id"); v != "" {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf("repository_id = $%d", len(args)))
+	}
+	switch v := r.URL.Query().Get("acknowledged"); v {
+	case "":
+	case "true", "false":
+		args = append(args, v == "true")
+		conds = append(conds, fmt.Sprintf("acknowledged = $%d", len(args)))
+	default:
+		http.Error(w, "acknowledged must be true or false", http.StatusBadRequest)
+		return
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+anomalyColumns+` FROM anomalies `+where+` ORDER BY created_at DESC LIMIT 200`, args...)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch anomalies: %v", err)
+		http.Error(w, "failed to fetch anomalies", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	anomalies := []models.Anomaly{}
+	for rows.Next() {
+		a, err := scanAnomaly(rows)
+		if err != nil {
+			http.Error(w, "failed to scan anomaly", http.StatusInternalServerError)
+			return
+		}
+		anomalies = append(anomalies, a)
+	}
+	writeBody(w, r, http.StatusOK, anomalies)
+}
+
+// AcknowledgeAnomaly handles POST /anomalies/{id}/acknowledge. Once every
+// anomaly of a repository is acknowledged, its next sync pushes the
+// changes to the targets and the alert is resolved.
+func (h *AnomalyHandler) AcknowledgeAnomaly(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+
+	a, err := scanAnomaly(h.DB.QueryRowContext(r.Context(),
+		`UPDATE anomalies
+		 SET acknowledged = TRUE,
+		     acknowledged_by = CASE WHEN acknowledged THEN acknowledged_by ELSE $2 END,
+		     acknowledged_at = CASE WHEN acknowledged THEN acknowledged_at ELSE $3 END
+		 WHERE id = $1
+		 RETURNING `+anomalyColumns,
+		mux.Vars(r)["id"], p.User, h.Clock.Now()))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "anomaly not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to acknowledge anomaly: %v", err)
+		http.Error(w, "failed to acknowledge anomaly", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "anomaly.acknowledge", "anomaly", a.ID,
+		map[string]any{"repository_id": a.RepositoryID, "kinds": a.Kinds})
+
+	var name string
+	var open bool
+	err = h.DB.QueryRowContext(r.Context(),
+		`SELECT r.name, EXISTS(SELECT 1 FROM anomalies WHERE repository_id = r.id AND NOT acknowledged)
+		 FROM repositories r WHERE r.id = $1`, a.RepositoryID).Scan(&name, &open)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch anomalies of repository %s: %v", a.RepositoryID, err)
+	} else if !open {
+		log.Printf("Anomalies of repository %s acknowledged by %s; syncs resume", a.RepositoryID, p.User)
+		h.Notifier.Notify(notify.Event{
+			Type:           notify.EventAnomalyAcknowledged,
+			Severity:       notify.SeverityInfo,
+			RepositoryID:   a.RepositoryID,
+			RepositoryName: name,
+			Message:        fmt.Sprintf("%s acknowledged the change of %s; syncs resume", p.User, name),
+			Data:           map[string]any{"anomaly_id": a.ID},
+		})
+	}
+	writeBody(w, r, http.StatusOK, a)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/backup"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/validation"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// BackupHandler serves the status and snapshots of the backup subsystem
+// and restores from them
+type BackupHandler struct {
+	DB *database.DB
+	// Backups is nil when no backup store is configured
+	Backups     *backup.Job
+	Credentials *credentials.Store
+	URLPolicy   validation.URLPolicy
+}
+
+// NewBackupHandler creates a new BackupHandler
+func NewBackupHandler(db *database.DB, backups *backup.Job, creds *credentials.Store, urls validation.URLPolicy) *BackupHandler {
+	return &BackupHandler{DB: db, Backups: backups, Credentials: creds, URLPolicy: urls}
+}
+
+// GetBackupStatus handles GET /backups/status
+func (h *BackupHandler) GetBackupStatus(w http.ResponseWriter, r *http.Request) {
+	status := models.BackupStatus{}
+	if h.Backups != nil {
+		var err error
+		if status, err = h.Backups.Status(r.Context()); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to fetch backup status", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// ListBackupSnapshots handles GET /backups/snapshots, optionally filtered by
+// repository_id
+func (h *BackupHandler) ListBackupSnapshots(w http.ResponseWriter, r *http.Request) {
+	where, args := "", []any{}
+	if id := r.URL.Query().Get("repository_id"); id != "" {
+		where, args = "WHERE repository_id = $1", append(args, id)
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+backup.SnapshotColumns+` FROM backup_snapshots `+where+` ORDER BY started_at DESC LIMIT 200`, args...)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to fetch backup snapshots", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	snapshots := []models.BackupSnapshot{}
+	for rows.Next() {
+		s, err := backup.ScanSnapshot(rows)
+		if err != nil {
+			http.Error(w, "failed to scan backup snapshot", http.StatusInternalServerError)
+			return
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+// GetBackupSnapshot handles GET /backups/snapshots/{id}
+func (h *BackupHandler) GetBackupSnapshot(w http.ResponseWriter, r *http.Request) {
+	s, err := backup.ScanSnapshot(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+backup.SnapshotColumns+` FROM backup_snapshots WHERE id = $1`, mux.Vars(r)["id"]))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "backup snapshot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch backup snapshot: %v", err)
+		http.Error(w, "failed to fetch backup snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// RestoreRepository handles POST /repositories/{id}/restore. It pushes a
+// snapshot to a target of the repository or to a new remote and responds
+// once the push is done. Snapshots of deleted repositories can still be
+// restored to a new remote. Admin only.
+func (h *BackupHandler) RestoreRepository(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if h.Backups == nil {
+		http.Error(w, "no backup store is configured", http.StatusServiceUnavailable)
+		return
+	}
+	var req models.RestoreRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if (req.TargetID == "") == (strings.TrimSpace(req.RemoteURL) == "") {
+		http.Error(w, "exactly one of target_id and remote_url is required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	repoID := mux.Vars(r)["id"]
+
+	query, args := `SELECT `+backup.SnapshotColumns+` FROM backup_snapshots WHERE repository_id = $1 AND status = $2`,
+		[]any{repoID, models.SnapshotSucceeded}
+	if req.SnapshotID != "" {
+		query, args = query+` AND id = $3`, append(args, req.SnapshotID)
+	}
+	snap, err := backup.ScanSnapshot(h.DB.QueryRowContext(ctx, query+` ORDER BY started_at DESC LIMIT 1`, args...))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "no successful snapshot found for this repository", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch backup snapshot: %v", err)
+		http.Error(w, "failed to fetch backup snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	target := models.Target{RepositoryID: repoID}
+	if req.TargetID != "" {
+		err := h.DB.QueryRowContext(ctx,
+			`SELECT id, provider, remote_url, credential_id, exclude_refs, mode, tag_pattern, annotated_tags_only, subdirectory, rewrite, http_headers
+			 FROM replication_targets WHERE id = $1 AND repository_id = $2`,
+			req.TargetID, repoID).Scan(&target.ID, &target.Provider, &target.RemoteURL, &target.CredentialID, pq.Array(&target.ExcludeRefs),
+			&target.Mode, &target.TagPattern, &target.AnnotatedTagsOnly, &target.Subdirectory, &target.Rewrite, &target.HTTPHeaders)
+		if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+			http.Error(w, "target not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: failed to fetch target: %v", err)
+			http.Error(w, "failed to fetch target", http.StatusInternalServerError)
+			return
+		}
+		if target.Rewrite != nil || target.Mode == models.TargetModeSubdirectory {
+			http.Error(w, "restoring to a target that rewrites history is not supported", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if _, err := provider.Lookup(req.Provider); err != nil {
+			http.Error(w, "invalid provider: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		remoteURL, secret := h.URLPolicy.SplitCredentials(req.RemoteURL)
+		remoteURL, err := h.URLPolicy.RepoURL(req.Provider, remoteURL)
+		if err != nil {
+			http.Error(w, "invalid remote_url: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		credentialID, ok := resolveURLCredential(r, w, h.DB, h.Credentials, req.Credential, secret, req.Provider, remoteURL)
+		if !ok {
+			return
+		}
+		target.Provider, target.RemoteURL, target.CredentialID = req.Provider, remoteURL, credentialID
+	}
+
+	refs, err := h.Backups.Restore(r.Context(), snap, target)
+	if errors.Is(err, replication.ErrBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: restore of repository %s from snapshot %s failed: %v", repoID, snap.ID, err)
+		http.Error(w, "restore failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Restored repository %s from snapshot %s to %s", repoID, snap.ID, target.RemoteURL)
+	recordAudit(r, h.DB, "repository.restore", "repository", repoID,
+		map[string]any{"snapshot_id": snap.ID, "target_id": req.TargetID, "remote_url": target.RemoteURL, "refs": len(refs)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RestoreResult{SnapshotID: snap.ID, RemoteURL: target.RemoteURL, Refs: refs})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/cache"
+)
+
+// serveCached answers r from c when possible. Otherwise next renders the
+// response, which is passed through to the client and kept for later
+// requests if it succeeded. Entries are keyed by query and caller, since
+// RBAC makes listings depend on who asks.
+func serveCached(w http.ResponseWriter, r *http.Request, c *cache.Cache, ns string, next http.HandlerFunc) {
+	if c == nil {
+		next(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	key := cacheKey(r)
+	gen := c.Generation(ctx, ns)
+	if body, ok := c.Get(ctx, ns, gen, key); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", "HIT")
+		w.Write(body)
+		return
+	}
+
+	w.Header().Set("X-Cache", "MISS")
+	rec := &teeWriter{ResponseWriter: w, status: http.StatusOK, limit: c.MaxEntryBytes}
+	next(rec, r)
+	// A listing that failed while streaming ends with a truncated array
+	if rec.status == http.StatusOK && !rec.overflow && json.Valid(rec.body.Bytes()) {
+		c.Set(ctx, ns, gen, key, rec.body.Bytes())
+	}
+}
+
+func cacheKey(r *http.Request) string {
+	caller := "anonymous"
+	if p, ok := auth.FromContext(r.Context()); ok {
+		groups := slices.Sorted(slices.Values(p.Groups))
+		caller = strconv.FormatBool(p.Admin) + ":" + strings.Join(groups, ",")
+	}
+	return r.URL.Path + "?" + r.URL.Query().Encode() + "|" + caller
+}
+
+// teeWriter copies a response into memory while writing it, giving up on
+// the copy once it exceeds limit
+type teeWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (t *teeWriter) WriteHeader(status int) {
+	t.status = status
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeWriter) Write(b []byte) (int, error) {
+	if !t.overflow {
+		if t.body.Len()+len(b) > t.limit {
+			t.overflow = true
+			t.body = bytes.Buffer{}
+		} else {
+			t.body.Write(b)
+		}
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses incremental
+func (t *teeWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (t *teeWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTeeWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	tee := &teeWriter{ResponseWriter: rec, status: http.StatusOK, limit: 8}
+
+	// A streamed listing is flushed to the client while it is copied
+	stream := newArrayStream(tee)
+	for i := range streamFlushEvery {
+		if err := stream.Write(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !rec.Flushed {
+		t.Error("a streamed listing was not flushed through the cache")
+	}
+	if err := http.NewResponseController(tee).Flush(); err != nil {
+		t.Errorf("ResponseController.Flush = %v, want nil", err)
+	}
+
+	// The copy is dropped once the response outgrows limit, but the
+	// client still gets all of it
+	if !tee.overflow || tee.body.Len() != 0 {
+		t.Errorf("copy of %d bytes kept past the limit of %d", tee.body.Len(), tee.limit)
+	}
+	if rec.Body.Len() <= tee.limit {
+		t.Errorf("client got %d bytes, want the whole listing", rec.Body.Len())
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/auth"
+	"gitsync/internal/clock"
+	"gitsync/internal/compliance"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// ComplianceHandler handles compliance export HTTP requests
+type ComplianceHandler struct {
+	DB       *database.DB
+	Exporter *compliance.Exporter
+	Clock    clock.Clock
+	IDs      ids.Generator
+}
+
+// NewComplianceHandler creates a new ComplianceHandler
+func NewComplianceHandler(db *database.DB, exporter *compliance.Exporter, clk clock.Clock, gen ids.Generator) *ComplianceHandler {
+	return &ComplianceHandler{DB: db, Exporter: exporter, Clock: clk, IDs: gen}
+}
+
+const exportColumns = `id, period_from, period_to, format, status, requested_by, file_path, location, sha256, error, created_at, finished_at`
+
+func scanExport(row rowScanner) (models.ComplianceExport, error) {
+	var e models.ComplianceExport
+	err := row.Scan(&e.ID, &e.From, &e.To, &e.Format, &e.Status, &e.RequestedBy, &e.FilePath, &e.Location,
+		&e.SHA256, &e.Error, &e.CreatedAt, &e.FinishedAt)
+	return e, err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// requireAdmin writes a 403 unless the caller is an admin
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !auth.IsAdmin(r.Context()) {
+		http.Error(w, "admin privileges required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// CreateComplianceExport handles POST /compliance/exports
+func (h *ComplianceHandler) CreateComplianceExport(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if !h.Exporter.Enabled() {
+		http.Error(w, compliance.ErrNoSigningKey.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var req models.CreateComplianceExportRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.From.IsZero() || req.To.IsZero() || !req.From.Before(req.To) {
+		http.Error(w, "from and to are required and from must be before to", http.StatusBadRequest)
+		return
+	}
+	if req.Format == "" {
+		req.Format = compliance.FormatCSV
+	}
+	if req.Format != compliance.FormatCSV && req.Format != compliance.FormatJSON {
+		http.Error(w, "invalid format. allowed: csv, json", http.StatusBadRequest)
+		return
+	}
+
+	exp := models.ComplianceExport{
+		From:        req.From.UTC(),
+		To:          req.To.UTC(),
+		Format:      req.Format,
+		Status:      compliance.StatusPending,
+		RequestedBy: audit.Actor(r.Context()),
+	}
+	exp.ID, exp.CreatedAt = h.IDs.NewID(), h.Clock.Now()
+	_, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO compliance_exports (id, period_from, period_to, format, status, requested_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		exp.ID, exp.From, exp.To, exp.Format, exp.Status, exp.RequestedBy, exp.CreatedAt)
+	if err != nil {
+		log.Printf("ERROR: failed to insert compliance export: %v", err)
+		http.Error(w, "failed to create export", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "compliance_export.create", "compliance_export", exp.ID,
+		map[string]any{"from": exp.From, "to": exp.To, "format": exp.Format})
+
+	go h.Exporter.Run(context.Background(), exp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(exp)
+}
+
+// ListComplianceExports handles GET /compliance/exports
+func (h *ComplianceHandler) ListComplianceExports(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+exportColumns+` FROM compliance_exports ORDER BY created_at DESC`)
+	if err != nil {
+		http.Error(w, "failed to fetch exports", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	exports := []models.ComplianceExport{}
+	for rows.Next() {
+		exp, err := scanExport(rows)
+		if err != nil {
+			http.Error(w, "failed to scan export", http.StatusInternalServerError)
+			return
+		}
+		exports = append(exports, exp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exports)
+}
+
+// GetComplianceExport handles GET /compliance/exports/{id}
+func (h *ComplianceHandler) GetComplianceExport(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	exp, ok := h.export(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exp)
+}
+
+// DownloadComplianceExport handles GET /compliance/exports/{id}/download
+func (h *ComplianceHandler) DownloadComplianceExport(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	exp, ok := h.export(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	if exp.Status != compliance.StatusSucceeded || exp.FilePath == nil {
+		http.Error(w, "export is not finished", http.StatusConflict)
+		return
+	}
+
+	f, err := os.Open(*exp.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "archive is no longer available locally", http.StatusGone)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to open compliance archive: %v", err)
+		http.Error(w, "failed to open archive", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(*exp.FilePath)+`"`)
+	http.ServeContent(w, r, filepath.Base(*exp.FilePath), *exp.FinishedAt, f)
+}
+
+// export fetches an export by ID, writing the error response if needed
+func (h *ComplianceHandler) export(w http.ResponseWriter, r *http.Request, id string) (*models.ComplianceExport, bool) {
+	exp, err := scanExport(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+exportColumns+` FROM compliance_exports WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "export not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch compliance export: %v", err)
+		http.Error(w, "failed to fetch export", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &exp, true
+}
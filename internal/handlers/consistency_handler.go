@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"gitsync/internal/consistency"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// ConsistencyHandler serves the audits comparing every target with its
+// source and starts them on demand
+type ConsistencyHandler struct {
+	DB    *database.DB
+	Audit *consistency.Job
+}
+
+// NewConsistencyHandler creates a new ConsistencyHandler
+func NewConsistencyHandler(db *database.DB, audit *consistency.Job) *ConsistencyHandler {
+	return &ConsistencyHandler{DB: db, Audit: audit}
+}
+
+// ListConsistencyAudits handles GET /consistency-audits
+func (h *ConsistencyHandler) ListConsistencyAudits(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+consistency.AuditColumns+` FROM consistency_audits ORDER BY started_at DESC LIMIT 200`)
+	if err != nil {
+		http.Error(w, "failed to fetch consistency audits", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	audits := []models.ConsistencyAudit{}
+	for rows.Next() {
+		a, err := consistency.ScanAudit(rows)
+		if err != nil {
+			http.Error(w, "failed to scan consistency audit", http.StatusInternalServerError)
+			return
+		}
+		audits = append(audits, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audits)
+}
+
+// GetConsistencyAudit handles GET /consistency-audits/{id}, with the
+// results of the audit optionally filtered by status and repository_id
+func (h *ConsistencyHandler) GetConsistencyAudit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	a, err := consistency.ScanAudit(h.DB.QueryRowContext(ctx,
+		`SELECT `+consistency.AuditColumns+` FROM consistency_audits WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "consistency audit not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch consistency audit: %v", err)
+		http.Error(w, "failed to fetch consistency audit", http.StatusInternalServerError)
+		return
+	}
+
+	where, args := "c.audit_id = $1", []any{id}
+	for _, column := range []string{"status", "repository_id"} {
+		if v := r.URL.Query().Get(column); v != "" {
+			args = append(args, v)
+			where += fmt.Sprintf(" AND c.%s = $%d", column, len(args))
+		}
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT `+consistency.ResultColumns+`
+		 FROM consistency_results c
+		 JOIN repositories r ON r.id = c.repository_id
+		 JOIN replication_targets t ON t.id = c.target_id
+		 WHERE `+where+`
+		 ORDER BY r.name, t.remote_url`, args...)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to fetch consistency results", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	report := models.ConsistencyReport{ConsistencyAudit: a, Results: []models.ConsistencyResult{}}
+	for rows.Next() {
+		res, err := consistency.ScanResult(rows)
+		if err != nil {
+			http.Error(w, "failed to scan consistency result", http.StatusInternalServerError)
+			return
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// StartConsistencyAudit handles POST /consistency-audits. The audit runs
+// in the background; the response describes it as started. Admin only.
+func (h *ConsistencyHandler) StartConsistencyAudit(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	a, err := h.Audit.Start(consistency.TriggerManual)
+	if errors.Is(err, consistency.ErrRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to start consistency audit", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "consistency_audit.start", "consistency_audit", a.ID, nil)
+
+	writeBody(w, r, http.StatusAccepted, a)
+}
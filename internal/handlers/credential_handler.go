@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+
+	"github.com/gorilla/mux"
+)
+
+// CredentialHandler handles credential profile HTTP requests
+type CredentialHandler struct {
+	DB          *database.DB
+	Credentials *credentials.Store
+	Client      *provider.Client
+}
+
+// NewCredentialHandler creates a new CredentialHandler
+func NewCredentialHandler(db *database.DB, creds *credentials.Store, client *provider.Client) *CredentialHandler {
+	return &CredentialHandler{DB: db, Credentials: creds, Client: client}
+}
+
+// CreateCredential handles POST /credentials
+func (h *CredentialHandler) CreateCredential(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateCredentialRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := provider.Lookup(req.Provider); err != nil {
+		http.Error(w, "invalid provider: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Secret == "" {
+		http.Error(w, "secret is required", http.StatusBadRequest)
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = models.CredentialToken
+	}
+	if err := validateCredential(req.Kind, req.Secret); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (strings.TrimSpace(req.Username) != "") != (req.Kind == models.CredentialBasic) {
+		http.Error(w, "username is required for basic credentials, which alone have one", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := h.Credentials.Get(ctx, req.Name); err == nil {
+		http.Error(w, "credential with this name already exists", http.StatusConflict)
+		return
+	} else if !errors.Is(err, credentials.ErrNotFound) {
+		log.Printf("ERROR: failed to check if credential exists: %v", err)
+		http.Error(w, "failed to check credential existence", http.StatusInternalServerError)
+		return
+	}
+
+	cred := models.Credential{Name: req.Name, Provider: req.Provider, Kind: req.Kind}
+	if username := strings.TrimSpace(req.Username); username != "" {
+		cred.Username = &username
+	}
+	if host := strings.ToLower(strings.TrimSpace(req.Host)); host != "" {
+		cred.Host = &host
+	}
+	if err := h.Credentials.Create(ctx, &cred, req.Secret); err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to create credential", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "credential.create", "credential", cred.ID, map[string]any{"name": cred.Name, "kind": cred.Kind})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cred)
+}
+
+// ListCredentials handles GET /credentials
+func (h *CredentialHandler) ListCredentials(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+credentials.Columns+` FROM credentials ORDER BY name`)
+	if err != nil {
+		http.Error(w, "failed to fetch credentials", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	creds := []models.Credential{}
+	for rows.Next() {
+		var cred models.Credential
+		if err := rows.Scan(&cred.ID, &cred.Name, &cred.Provider, &cred.Host, &cred.Kind, &cred.Username, &cred.CreatedAt, &cred.UpdatedAt); err != nil {
+			http.Error(w, "failed to scan credential", http.StatusInternalServerError)
+			return
+		}
+		creds = append(creds, cred)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creds)
+}
+
+// GetCredential handles GET /credentials/{name}
+func (h *CredentialHandler) GetCredential(w http.ResponseWriter, r *http.Request) {
+	cred, ok := h.lookup(r.Context(), w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+	writeBody(w, r, http.StatusOK, cred)
+}
+
+// GetCredentialUsage handles GET /credentials/{name}/usage
+func (h *CredentialHandler) GetCredentialUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cred, ok := h.lookup(ctx, w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	usage, err := h.usage(ctx, cred.ID)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch credential usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// ReplaceCredential handles PUT /credentials/{name}
+func (h *CredentialHandler) ReplaceCredential(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cred, ok := h.lookup(ctx, w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	var req models.ReplaceCredentialRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Secret == "" {
+		http.Error(w, "secret is required", http.StatusBadRequest)
+		return
+	}
+	if req.Username != "" && cred.Kind != models.CredentialBasic {
+		http.Error(w, "only basic credentials have a username", http.StatusBadRequest)
+		return
+	}
+	if err := validateCredential(cred.Kind, req.Secret); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Only tokens can be checked against the provider API
+	if req.Verify && cred.Kind != models.CredentialToken {
+		http.Error(w, "only token credentials can be verified", http.StatusBadRequest)
+		return
+	}
+
+	// Verifying first keeps a bad token from breaking every resource that shares it
+	if req.Verify {
+		p, err := provider.Lookup(cred.Provider)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		host := p.Hosts()[0]
+		if cred.Host != nil {
+			host = *cred.Host
+		}
+		if err := h.Client.VerifyToken(ctx, p, host, req.Secret); err != nil {
+			http.Error(w, "new secret was rejected by the provider: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if err := h.Credentials.Replace(ctx, cred.ID, strings.TrimSpace(req.Username), req.Secret); err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to replace credential", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "credential.replace", "credential", cred.ID, map[string]any{"name": cred.Name})
+
+	updated, err := h.Credentials.Get(ctx, cred.Name)
+	if err != nil {
+		http.Error(w, "failed to fetch credential", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteCredential handles DELETE /credentials/{name}
+func (h *CredentialHandler) DeleteCredential(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cred, ok := h.lookup(ctx, w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	usage, err := h.usage(ctx, cred.ID)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch credential usage", http.StatusInternalServerError)
+		return
+	}
+	if len(usage.Repositories) > 0 || len(usage.Targets) > 0 {
+		http.Error(w, "credential is still in use", http.StatusConflict)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(ctx, `DELETE FROM credentials WHERE id = $1`, cred.ID); err != nil {
+		log.Printf("ERROR: failed to delete credential: %v", err)
+		http.Error(w, "failed to delete credential", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "credential.delete", "credential", cred.ID, map[string]any{"name": cred.Name})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup fetches a credential by name, writing the error response if needed
+func (h *CredentialHandler) lookup(ctx context.Context, w http.ResponseWriter, name string) (*models.Credential, bool) {
+	cred, err := h.Credentials.Get(ctx, name)
+	if errors.Is(err, credentials.ErrNotFound) {
+		http.Error(w, "credential not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch credential", http.StatusInternalServerError)
+		return nil, false
+	}
+	return cred, true
+}
+
+func (h *CredentialHandler) usage(ctx context.Context, credentialID string) (*models.CredentialUsage, error) {
+	usage := &models.CredentialUsage{
+		Repositories: []models.Repository{},
+		Targets:      []models.Target{},
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, credential_id, created_at
+		 FROM repositories WHERE credential_id = $1 ORDER BY name`, credentialID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var repo models.Repository
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.CredentialID, &repo.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		usage.Repositories = append(usage.Repositories, repo)
+	}
+	rows.Close()
+
+	rows, err = h.DB.QueryContext(ctx,
+		`SELECT id, repository_id, provider, remote_url, credential_id, approval_state, created_at
+		 FROM replication_targets WHERE credential_id = $1 ORDER BY created_at`, credentialID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target models.Target
+		if err := rows.Scan(&target.ID, &target.RepositoryID, &target.Provider, &target.RemoteURL, &target.CredentialID,
+			&target.ApprovalState, &target.CreatedAt); err != nil {
+			return nil, err
+		}
+		usage.Targets = append(usage.Targets, target)
+	}
+	return usage, nil
+}
+
+// validateCredential checks the kind of a credential and that its secret
+// suits it. Errors are meant for a 400 response.
+func validateCredential(kind, secret string) error {
+	switch kind {
+	case models.CredentialToken, models.CredentialBasic:
+	case models.CredentialSSHKey:
+		key := strings.TrimSpace(secret)
+		if !strings.HasPrefix(key, "-----BEGIN ") || !strings.Contains(key, "PRIVATE KEY-----") {
+			return errors.New("secret of ssh_key credentials must be a private key in OpenSSH or PEM format")
+		}
+	default:
+		return errors.New("invalid kind. allowed: token, basic, ssh_key")
+	}
+	return nil
+}
+
+// resolveCredential maps an optional credential name from a request body to
+// its ID, checking that it belongs to the expected provider
+func resolveCredential(ctx context.Context, w http.ResponseWriter, store *credentials.Store, name, providerName string) (*string, bool) {
+	id, err := findCredential(ctx, store, name, providerName)
+	var invalid errCredential
+	if errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch credential", http.StatusInternalServerError)
+		return nil, false
+	}
+	return id, true
+}
+
+// errCredential is returned by findCredential for names of credentials
+// that do not exist or belong to another provider; its message is meant
+// for a 400 response
+type errCredential string
+
+func (e errCredential) Error() string { return string(e) }
+
+// findCredential is resolveCredential returning errors rather than
+// writing them
+func findCredential(ctx context.Context, store *credentials.Store, name, providerName string) (*string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, nil
+	}
+	cred, err := store.Get(ctx, name)
+	if errors.Is(err, credentials.ErrNotFound) {
+		return nil, errCredential("credential not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cred.Provider != providerName {
+		return nil, errCredential("credential belongs to provider " + cred.Provider)
+	}
+	return &cred.ID, nil
+}
+
+// resolveURLCredential is resolveCredential for resources whose URL came
+// with embedded credentials: the secret taken off the URL is stored as a
+// new credential profile for its host, which the resource references.
+// Naming a credential as well is rejected. rawURL must be validated.
+func resolveURLCredential(r *http.Request, w http.ResponseWriter, db *database.DB, store *credentials.Store, name, secret, providerName, rawURL string) (*string, bool) {
+	if secret == "" {
+		return resolveCredential(r.Context(), w, store, name, providerName)
+	}
+	if strings.TrimSpace(name) != "" {
+		http.Error(w, errEmbeddedCredential.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	credentialID, err := storeURLCredential(r, db, store, secret, providerName, rawURL)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to store embedded credential", http.StatusInternalServerError)
+		return nil, false
+	}
+	return credentialID, true
+}
+
+var errEmbeddedCredential = errors.New("credential cannot be combined with credentials embedded in the URL")
+
+// storeURLCredential creates the credential profile holding a secret taken
+// off rawURL and returns its ID
+func storeURLCredential(r *http.Request, db *database.DB, store *credentials.Store, secret, providerName, rawURL string) (*string, error) {
+	parsed, err := provider.ParseRepoURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	cred := models.Credential{Name: "url-" + parsed.Host + "-" + hex.EncodeToString(suffix), Provider: providerName, Host: &parsed.Host}
+	if err := store.Create(r.Context(), &cred, secret); err != nil {
+		return nil, err
+	}
+	recordAudit(r, db, "credential.create", "credential", cred.ID, map[string]any{"name": cred.Name, "extracted_from_url": true})
+	return &cred.ID, nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// LimitBody caps the size of request bodies at limit bytes; 0 leaves them
+// unlimited. Reading past the cap fails, which decodeJSON reports as 413
+// Request Entity Too Large.
+func LimitBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limit > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decodeJSON decodes the body of r into v, rejecting fields v does not
+// have and anything after the first JSON value. On failure it writes an
+// error naming the problem and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must hold a single JSON value")
+	}
+	if err == nil {
+		return true
+	}
+
+	var maxBytes *http.MaxBytesError
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	msg := err.Error()
+	switch {
+	case errors.As(err, &maxBytes):
+		http.Error(w, fmt.Sprintf("request body exceeds the limit of %d bytes", maxBytes.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	case errors.Is(err, io.EOF):
+		msg = "body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		msg = "body ends in the middle of a JSON value"
+	case errors.As(err, &syntax):
+		msg = fmt.Sprintf("malformed JSON at offset %d", syntax.Offset)
+	case errors.As(err, &typ) && typ.Field != "":
+		msg = fmt.Sprintf("field %q must be %s", typ.Field, jsonKind(typ.Type))
+	case errors.As(err, &typ):
+		msg = "body must be " + jsonKind(typ.Type)
+	case strings.HasPrefix(msg, "json: unknown field "):
+		// The decoder has no error type for unknown fields
+		msg = "unknown field " + strings.TrimPrefix(msg, "json: unknown field ")
+	}
+	http.Error(w, "invalid request body: "+strings.TrimPrefix(msg, "json: "), http.StatusBadRequest)
+	return false
+}
+
+// jsonKind describes the JSON value decoded into t
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/auth"
+	"gitsync/internal/models"
+	"gitsync/internal/policy"
+	"gitsync/internal/provider"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultTargetHandler handles the default targets every new repository
+// gets, such as its disaster recovery mirror. Repositories declared in the
+// GitOps state file list their targets there and get none.
+type DefaultTargetHandler struct {
+	// Targets validates and builds the targets
+	Targets *TargetHandler
+}
+
+// NewDefaultTargetHandler creates a new DefaultTargetHandler
+func NewDefaultTargetHandler(targets *TargetHandler) *DefaultTargetHandler {
+	return &DefaultTargetHandler{Targets: targets}
+}
+
+// sampleSourceURL is the source templates are tried with when a default
+// target is saved
+const sampleSourceURL = "https://git.example.com/group/repository.git"
+
+const defaultTargetColumns = `id, name, target, created_by, created_at`
+
+func scanDefaultTarget(row interface{ Scan(...any) error }) (models.DefaultTarget, error) {
+	var d models.DefaultTarget
+	var target []byte
+	if err := row.Scan(&d.ID, &d.Name, &target, &d.CreatedBy, &d.CreatedAt); err != nil {
+		return d, err
+	}
+	if err := json.Unmarshal(target, &d.Target); err != nil {
+		return d, fmt.Errorf("invalid default target %s: %w", d.Name, err)
+	}
+	return d, nil
+}
+
+// defaultTargetFromRequest builds and validates a default target from its
+// request body. Errors are meant for a 400 response.
+func (h *DefaultTargetHandler) defaultTargetFromRequest(req models.DefaultTargetRequest) (models.DefaultTarget, error) {
+	d := models.DefaultTarget{Name: strings.TrimSpace(req.Name), Target: req.Target}
+	if d.Name == "" {
+		return d, errors.New("name is required")
+	}
+	t := &d.Target
+	t.RemoteURL = strings.TrimSpace(t.RemoteURL)
+	switch {
+	case !provider.IsTemplate(t.RemoteURL):
+		return d, errors.New("target.remote_url must be a template using " + strings.Join(provider.Placeholders, ", ") + " so each repository gets a remote of its own")
+	case strings.TrimSpace(t.ExternalID) != "":
+		return d, errors.New("target.external_id cannot be shared by many targets")
+	case len(t.HTTPHeaders) > 0:
+		// Their values would be stored in the clear and returned
+		return d, errors.New("target.http_headers are not supported by default targets")
+	}
+
+	// The template is kept; the rest of the request is normalized
+	template := t.RemoteURL
+	secret, err := h.Targets.validateTargetRequest(t, sampleSourceURL)
+	if err != nil {
+		return d, fmt.Errorf("invalid target: %w", err)
+	}
+	if secret != "" {
+		return d, errors.New("target.remote_url must not embed credentials; name a credential instead")
+	}
+	t.RemoteURL = template
+	return d, nil
+}
+
+// decodeDefaultTarget decodes and validates the body of r, writing an
+// error and returning false when it is invalid
+func (h *DefaultTargetHandler) decodeDefaultTarget(w http.ResponseWriter, r *http.Request) (models.DefaultTarget, []byte, bool) {
+	var req models.DefaultTargetRequest
+	if !decodeBody(w, r, &req) {
+		return models.DefaultTarget{}, nil, false
+	}
+	d, err := h.defaultTargetFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return d, nil, false
+	}
+	if _, ok := resolveCredential(r.Context(), w, h.Targets.Credentials, d.Target.Credential, d.Target.Provider); !ok {
+		return d, nil, false
+	}
+	target, err := json.Marshal(d.Target)
+	if err != nil {
+		http.Error(w, "failed to encode default target", http.StatusInternalServerError)
+		return d, nil, false
+	}
+	return d, target, true
+}
+
+// CreateDefaultTarget handles POST /default-targets
+func (h *DefaultTargetHandler) CreateDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	d, target, ok := h.decodeDefaultTarget(w, r)
+	if !ok {
+		return
+	}
+
+	actor := audit.Actor(r.Context())
+	d.ID, d.CreatedBy, d.CreatedAt = h.Targets.IDs.NewID(), &actor, h.Targets.Clock.Now()
+	_, err := h.Targets.DB.ExecContext(r.Context(),
+		`INSERT INTO default_targets (`+defaultTargetColumns+`) VALUES ($1, $2, $3, $4, $5)`,
+		d.ID, d.Name, target, d.CreatedBy, d.CreatedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "default target with this name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to insert default target: %v", err)
+		http.Error(w, "failed to create default target", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.Targets.DB, "default_target.create", "default_target", d.ID,
+		map[string]any{"name": d.Name, "remote_url": d.Target.RemoteURL})
+	writeBody(w, r, http.StatusCreated, d)
+}
+
+// ListDefaultTargets handles GET /default-targets
+func (h *DefaultTargetHandler) ListDefaultTargets(w http.ResponseWriter, r *http.Request) {
+	defaults, err := h.load(r.Context())
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch default targets", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, http.StatusOK, defaults)
+}
+
+// GetDefaultTarget handles GET /default-targets/{id}
+func (h *DefaultTargetHandler) GetDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	d, err := scanDefaultTarget(h.Targets.DB.QueryRowContext(r.Context(),
+		`SELECT `+defaultTargetColumns+` FROM default_targets WHERE id = $1`, mux.Vars(r)["id"]))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "default target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch default target: %v", err)
+		http.Error(w, "failed to fetch default target", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, http.StatusOK, d)
+}
+
+// PutDefaultTarget handles PUT /default-targets/{id}. A default target that
+// does not exist yet is created with the given ID. Repositories created
+// before keep the targets they got.
+func (h *DefaultTargetHandler) PutDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	d, target, ok := h.decodeDefaultTarget(w, r)
+	if !ok {
+		return
+	}
+
+	d.ID = mux.Vars(r)["id"]
+	var created bool
+	err := h.Targets.DB.QueryRowContext(r.Context(),
+		`INSERT INTO default_targets (`+defaultTargetColumns+`) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, target = EXCLUDED.target
+		 RETURNING created_by, created_at, xmax = 0`,
+		d.ID, d.Name, target, audit.Actor(r.Context()), h.Targets.Clock.Now()).
+		Scan(&d.CreatedBy, &d.CreatedAt, &created)
+	if isInvalidUUID(err) {
+		http.Error(w, "id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "default target with this name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update default target: %v", err)
+		http.Error(w, "failed to save default target", http.StatusInternalServerError)
+		return
+	}
+	action, status := "default_target.update", http.StatusOK
+	if created {
+		action, status = "default_target.create", http.StatusCreated
+	}
+	recordAudit(r, h.Targets.DB, action, "default_target", d.ID,
+		map[string]any{"name": d.Name, "remote_url": d.Target.RemoteURL})
+	writeBody(w, r, status, d)
+}
+
+// DeleteDefaultTarget handles DELETE /default-targets/{id}. The targets
+// it added to repositories stay.
+func (h *DefaultTargetHandler) DeleteDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	res, err := h.Targets.DB.ExecContext(r.Context(),
+		`DELETE FROM default_targets WHERE id = $1`, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "default target not found", http.StatusNotFound)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "default target not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.Targets.DB, "default_target.delete", "default_target", mux.Vars(r)["id"], nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// load returns the default targets by name
+func (h *DefaultTargetHandler) load(ctx context.Context) ([]models.DefaultTarget, error) {
+	rows, err := h.Targets.DB.QueryContext(ctx, `SELECT `+defaultTargetColumns+` FROM default_targets ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch default targets: %w", err)
+	}
+	defer rows.Close()
+
+	defaults := []models.DefaultTarget{}
+	for rows.Next() {
+		d, err := scanDefaultTarget(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan default target: %w", err)
+		}
+		defaults = append(defaults, d)
+	}
+	return defaults, rows.Err()
+}
+
+// errDefaultTarget is why a default target cannot be added to a new
+// repository
+type errDefaultTarget struct {
+	name string
+	err  error
+}
+
+func (e errDefaultTarget) Error() string {
+	return "default target " + e.name + ": " + e.err.Error()
+}
+
+func (e errDefaultTarget) Unwrap() error { return e.err }
+
+// addTo appends the default targets to the targets of repo, a new
+// repository, skipping those whose remote it replicates to already. The
+// targets get no ID. Reasons a default target cannot be added, policy
+// violations included, are errDefaultTarget.
+func (h *DefaultTargetHandler) addTo(ctx context.Context, r *http.Request, defaults []models.DefaultTarget, policies []models.Policy, repo *models.Repository) error {
+	for _, d := range defaults {
+		req := d.Target
+		if _, err := h.Targets.validateTargetRequest(&req, repo.SourceURL); err != nil {
+			return errDefaultTarget{d.Name, err}
+		}
+		if slices.ContainsFunc(repo.Targets, func(t models.Target) bool { return t.RemoteURL == req.RemoteURL }) {
+			continue
+		}
+		credentialID, err := findCredential(ctx, h.Targets.Credentials, req.Credential, req.Provider)
+		var invalid errCredential
+		if errors.As(err, &invalid) {
+			return errDefaultTarget{d.Name, err}
+		}
+		if err != nil {
+			return err
+		}
+
+		// Admins defined the target, so it needs no approval
+		target := h.Targets.newTarget(r, repo.ID, req, credentialID)
+		target.ApprovalState = models.ApprovalApproved
+		if p, ok := auth.FromContext(ctx); ok {
+			target.CreatedBy = &p.User
+		}
+		if err := h.Targets.Policies.EnforceWith(ctx, policy.StageCreate, policies, policy.Subject{Repository: *repo, Target: &target}); err != nil {
+			var violation *policy.ViolationError
+			if errors.As(err, &violation) {
+				return errDefaultTarget{d.Name, err}
+			}
+			return err
+		}
+		repo.Targets = append(repo.Targets, target)
+	}
+	return nil
+}
+
+// add appends the default targets to the targets of repo, a new repository
+// not opted out of them, and gives them IDs. Reasons a default target
+// cannot be added are written as a 422 response; false is returned when
+// anything was written.
+func (h *DefaultTargetHandler) add(w http.ResponseWriter, r *http.Request, repo *models.Repository) bool {
+	ctx := r.Context()
+	defaults, err := h.load(ctx)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch default targets", http.StatusInternalServerError)
+		return false
+	}
+	if len(defaults) == 0 {
+		return true
+	}
+	policies, err := h.Targets.Policies.Enabled(ctx)
+	if err == nil {
+		err = h.addTo(ctx, r, defaults, policies, repo)
+	}
+	if !h.writeError(w, err) {
+		return false
+	}
+	for i := range repo.Targets {
+		repo.Targets[i].ID = h.Targets.IDs.NewID()
+	}
+	return true
+}
+
+// writeError writes the response for an error of addTo and returns
+// whether err was nil
+func (h *DefaultTargetHandler) writeError(w http.ResponseWriter, err error) bool {
+	var invalid errDefaultTarget
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &invalid):
+		http.Error(w, err.Error()+"; set skip_default_targets to create the repository without its default targets", http.StatusUnprocessableEntity)
+	default:
+		log.Printf("ERROR: failed to add default targets: %v", err)
+		http.Error(w, "failed to add default targets", http.StatusInternalServerError)
+	}
+	return false
+}
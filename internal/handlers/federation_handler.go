@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gitsync/internal/database"
+	"gitsync/internal/federation"
+	"gitsync/internal/gitops"
+)
+
+// FederationHandler serves the state exchanged between a primary GitSync
+// instance and its standby
+type FederationHandler struct {
+	DB     *database.DB
+	Syncer *federation.Syncer
+}
+
+// NewFederationHandler creates a new FederationHandler
+func NewFederationHandler(db *database.DB, syncer *federation.Syncer) *FederationHandler {
+	return &FederationHandler{DB: db, Syncer: syncer}
+}
+
+// GetFederationStatus handles GET /federation/status
+func (h *FederationHandler) GetFederationStatus(w http.ResponseWriter, r *http.Request) {
+	status := federation.Status{Applied: []gitops.Change{}, Drift: []gitops.Change{}}
+	if h.Syncer != nil {
+		status = h.Syncer.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// GetFederationState handles GET /federation/state
+func (h *FederationHandler) GetFederationState(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if h.Syncer == nil {
+		http.Error(w, federation.ErrNotPrimary.Error(), http.StatusConflict)
+		return
+	}
+	data, err := h.Syncer.State(r.Context())
+	if errors.Is(err, federation.ErrNotPrimary) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to export state", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("ETag", `"`+federation.Revision(data)+`"`)
+	w.Write(data)
+}
+
+// PutFederationState handles PUT /federation/state
+func (h *FederationHandler) PutFederationState(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if h.Syncer == nil {
+		http.Error(w, federation.ErrNotAccepted.Error(), http.StatusConflict)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		http.Error(w, fmt.Sprintf("request body exceeds the limit of %d bytes", maxBytes.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read state", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Syncer.Receive(r.Context(), data)
+	if errors.Is(err, federation.ErrNotAccepted) || errors.Is(err, federation.ErrPromoted) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to apply state: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Syncer.Status())
+}
+
+// PromoteFederation handles POST /federation/promote
+func (h *FederationHandler) PromoteFederation(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if h.Syncer == nil || h.Syncer.Config.Role != federation.RoleStandby {
+		http.Error(w, "only a standby can be promoted", http.StatusConflict)
+		return
+	}
+	released, err := h.Syncer.Promote(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to promote standby", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "federation.promote", "federation", h.Syncer.Config.PeerURL, map[string]any{"released": released})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Syncer.Status())
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/schedule"
+
+	"github.com/gorilla/mux"
+)
+
+// FreezeHandler handles freeze period HTTP requests
+type FreezeHandler struct {
+	DB    *database.DB
+	Clock clock.Clock
+	IDs   ids.Generator
+}
+
+// NewFreezeHandler creates a new FreezeHandler
+func NewFreezeHandler(db *database.DB, clk clock.Clock, gen ids.Generator) *FreezeHandler {
+	return &FreezeHandler{DB: db, Clock: clk, IDs: gen}
+}
+
+// freezeFromRequest builds and validates a freeze period from its request
+// body. Times are stored in UTC.
+func freezeFromRequest(req models.FreezePeriodRequest) (models.FreezePeriod, error) {
+	f := models.FreezePeriod{
+		Name:     strings.TrimSpace(req.Name),
+		StartsAt: req.StartsAt.UTC(),
+		EndsAt:   req.EndsAt.UTC(),
+	}
+	if f.Name == "" {
+		return f, errors.New("name is required")
+	}
+	if req.StartsAt.IsZero() || req.EndsAt.IsZero() || !f.StartsAt.Before(f.EndsAt) {
+		return f, errors.New("starts_at and ends_at are required and starts_at must be before ends_at")
+	}
+	if reason := strings.TrimSpace(req.Reason); reason != "" {
+		f.Reason = &reason
+	}
+	return f, nil
+}
+
+// checkFreeze writes a 409 response and returns false when a freeze period
+// is in effect, unless an admin overrides it with override=true
+func checkFreeze(ctx context.Context, w http.ResponseWriter, r *http.Request, db *database.DB, now time.Time) bool {
+	freeze, err := schedule.ActiveFreeze(ctx, db, now)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to check freeze periods", http.StatusInternalServerError)
+		return false
+	}
+	if freeze == nil {
+		return true
+	}
+	if r.URL.Query().Get("override") != "true" {
+		http.Error(w, "syncing is frozen by "+freeze.Name+" until "+freeze.EndsAt.Format(time.RFC3339)+
+			"; admins may override with override=true", http.StatusConflict)
+		return false
+	}
+	return requireAdmin(w, r)
+}
+
+// CreateFreezePeriod handles POST /freeze-periods
+func (h *FreezeHandler) CreateFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req models.FreezePeriodRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	f, err := freezeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	actor := audit.Actor(r.Context())
+	f.ID, f.CreatedBy, f.CreatedAt = h.IDs.NewID(), &actor, h.Clock.Now()
+	if _, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO freeze_periods (id, name, reason, starts_at, ends_at, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		f.ID, f.Name, f.Reason, f.StartsAt, f.EndsAt, f.CreatedBy, f.CreatedAt); err != nil {
+		log.Printf("ERROR: failed to insert freeze period: %v", err)
+		http.Error(w, "failed to create freeze period", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "freeze_period.create", "freeze_period", f.ID,
+		map[string]any{"name": f.Name, "starts_at": f.StartsAt, "ends_at": f.EndsAt})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(f)
+}
+
+// ListFreezePeriods handles GET /freeze-periods. With upcoming=true only
+// periods that have not ended are listed.
+func (h *FreezeHandler) ListFreezePeriods(w http.ResponseWriter, r *http.Request) {
+	where, args := "", []any{}
+	if r.URL.Query().Get("upcoming") == "true" {
+		where, args = "WHERE ends_at > $1", append(args, h.Clock.Now().UTC())
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+schedule.FreezeColumns+` FROM freeze_periods `+where+` ORDER BY starts_at DESC`, args...)
+	if err != nil {
+		http.Error(w, "failed to fetch freeze periods", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	periods := []models.FreezePeriod{}
+	for rows.Next() {
+		f, err := schedule.ScanFreeze(rows)
+		if err != nil {
+			http.Error(w, "failed to scan freeze period", http.StatusInternalServerError)
+			return
+		}
+		periods = append(periods, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(periods)
+}
+
+// GetFreezePeriod handles GET /freeze-periods/{id}
+func (h *FreezeHandler) GetFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	f, err := schedule.ScanFreeze(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+schedule.FreezeColumns+` FROM freeze_periods WHERE id = $1`, mux.Vars(r)["id"]))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "freeze period not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch freeze period: %v", err)
+		http.Error(w, "failed to fetch freeze period", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// PutFreezePeriod handles PUT /freeze-periods/{id}. A period that does not
+// exist yet is created with the given ID, so one can be ended early by
+// moving its ends_at.
+func (h *FreezeHandler) PutFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req models.FreezePeriodRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	f, err := freezeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	actor := audit.Actor(r.Context())
+	f.ID = mux.Vars(r)["id"]
+	var created bool
+	err = h.DB.QueryRowContext(r.Context(),
+		`INSERT INTO freeze_periods (id, name, reason, starts_at, ends_at, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (id) DO UPDATE
+		 SET name = EXCLUDED.name, reason = EXCLUDED.reason, starts_at = EXCLUDED.starts_at, ends_at = EXCLUDED.ends_at
+		 RETURNING created_by, created_at, xmax = 0`,
+		f.ID, f.Name, f.Reason, f.StartsAt, f.EndsAt, actor, h.Clock.Now()).
+		Scan(&f.CreatedBy, &f.CreatedAt, &created)
+	if isInvalidUUID(err) {
+		http.Error(w, "id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update freeze period: %v", err)
+		http.Error(w, "failed to update freeze period", http.StatusInternalServerError)
+		return
+	}
+	action, status := "freeze_period.update", http.StatusOK
+	if created {
+		action, status = "freeze_period.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "freeze_period", f.ID,
+		map[string]any{"name": f.Name, "starts_at": f.StartsAt, "ends_at": f.EndsAt})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(f)
+}
+
+// DeleteFreezePeriod handles DELETE /freeze-periods/{id}
+func (h *FreezeHandler) DeleteFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM freeze_periods WHERE id = $1`, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "freeze period not found", http.StatusNotFound)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "freeze period not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "freeze_period.delete", "freeze_period", mux.Vars(r)["id"], nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gitsync/internal/gitops"
+)
+
+// GitOpsHandler reports on declarative management of repositories
+type GitOpsHandler struct {
+	Reconciler *gitops.Reconciler
+}
+
+// NewGitOpsHandler creates a new GitOpsHandler
+func NewGitOpsHandler(reconciler *gitops.Reconciler) *GitOpsHandler {
+	return &GitOpsHandler{Reconciler: reconciler}
+}
+
+// GetGitOpsStatus handles GET /gitops/status
+func (h *GitOpsHandler) GetGitOpsStatus(w http.ResponseWriter, r *http.Request) {
+	status := gitops.Status{Applied: []gitops.Change{}, Drift: []gitops.Change{}}
+	if h.Reconciler != nil {
+		status = h.Reconciler.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
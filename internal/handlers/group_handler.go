@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// GroupHandler handles sync group HTTP requests
+type GroupHandler struct {
+	DB     *database.DB
+	Runner *replication.Runner
+	Clock  clock.Clock
+	IDs    ids.Generator
+}
+
+// NewGroupHandler creates a new GroupHandler
+func NewGroupHandler(db *database.DB, runner *replication.Runner, clk clock.Clock, gen ids.Generator) *GroupHandler {
+	return &GroupHandler{DB: db, Runner: runner, Clock: clk, IDs: gen}
+}
+
+// groupColumns is the column list expected by scanGroup, with the groups
+// aliased as g
+const groupColumns = `g.id, g.name, g.description, g.paused, g.sync_interval_seconds, g.external_id, g.created_at,
+	ARRAY(SELECT m.repository_id::text FROM sync_group_members m WHERE m.group_id = g.id ORDER BY m.repository_id)`
+
+func scanGroup(row rowScanner) (models.SyncGroup, error) {
+	var g models.SyncGroup
+	err := row.Scan(&g.ID, &g.Name, &g.Description, &g.Paused, &g.SyncIntervalSeconds, &g.ExternalID, &g.CreatedAt,
+		pq.Array(&g.RepositoryIDs))
+	return g, err
+}
+
+// groupFromRequest builds and validates a group from its request body
+func groupFromRequest(req models.SyncGroupRequest) (models.SyncGroup, error) {
+	g := models.SyncGroup{
+		Name:                strings.TrimSpace(req.Name),
+		Paused:              req.Paused,
+		SyncIntervalSeconds: req.SyncIntervalSeconds,
+		RepositoryIDs:       slices.Compact(slices.Sorted(slices.Values(req.RepositoryIDs))),
+	}
+	if g.Name == "" {
+		return g, errors.New("name is required")
+	}
+	if g.SyncIntervalSeconds != nil && *g.SyncIntervalSeconds <= 0 {
+		return g, errors.New("sync_interval_seconds must be positive")
+	}
+	if g.RepositoryIDs == nil {
+		g.RepositoryIDs = []string{}
+	}
+	if description := strings.TrimSpace(req.Description); description != "" {
+		g.Description = &description
+	}
+	if externalID := strings.TrimSpace(req.ExternalID); externalID != "" {
+		g.ExternalID = &externalID
+	}
+	return g, nil
+}
+
+// setMembers replaces the members of a group
+func setMembers(ctx context.Context, tx *sql.Tx, groupID string, repoIDs []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sync_group_members WHERE group_id = $1`, groupID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO sync_group_members (group_id, repository_id) SELECT $1, unnest($2::uuid[])`,
+		groupID, pq.Array(repoIDs))
+	return err
+}
+
+// writeGroupError maps the errors of saving a group to a response
+func writeGroupError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case isInvalidUUID(err):
+		http.Error(w, "group and repository ids must be valid UUIDs", http.StatusBadRequest)
+	case isForeignKeyViolation(err):
+		http.Error(w, "repository_ids must name existing repositories", http.StatusBadRequest)
+	case isUniqueViolation(err):
+		http.Error(w, "group with this name or external_id already exists", http.StatusConflict)
+	default:
+		log.Printf("ERROR: failed to %s sync group: %v", action, err)
+		http.Error(w, "failed to "+action+" group", http.StatusInternalServerError)
+	}
+}
+
+// CreateGroup handles POST /groups
+func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req models.SyncGroupRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	g, err := groupFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	g.ID, g.CreatedAt = h.IDs.NewID(), h.Clock.Now()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		writeGroupError(w, err, "create")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO sync_groups (id, name, description, paused, sync_interval_seconds, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		g.ID, g.Name, g.Description, g.Paused, g.SyncIntervalSeconds, g.ExternalID, g.CreatedAt)
+	if err == nil {
+		err = setMembers(ctx, tx, g.ID, g.RepositoryIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeGroupError(w, err, "create")
+		return
+	}
+	recordAudit(r, h.DB, "sync_group.create", "sync_group", g.ID,
+		map[string]any{"name": g.Name, "repositories": len(g.RepositoryIDs)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+// ListGroups handles GET /groups
+func (h *GroupHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	where, args := "", []any{}
+	if externalID := r.URL.Query().Get("external_id"); externalID != "" {
+		where, args = "WHERE g.external_id = $1", append(args, externalID)
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+groupColumns+` FROM sync_groups g `+where+` ORDER BY g.name`, args...)
+	if err != nil {
+		http.Error(w, "failed to fetch groups", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	groups := []models.SyncGroup{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			http.Error(w, "failed to scan group", http.StatusInternalServerError)
+			return
+		}
+		groups = append(groups, g)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// group writes a 404 or 500 response and returns false when the group with
+// the given ID cannot be loaded
+func (h *GroupHandler) group(ctx context.Context, w http.ResponseWriter, id string) (models.SyncGroup, bool) {
+	g, err := scanGroup(h.DB.QueryRowContext(ctx, `SELECT `+groupColumns+` FROM sync_groups g WHERE g.id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return g, false
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch sync group: %v", err)
+		http.Error(w, "failed to fetch group", http.StatusInternalServerError)
+		return g, false
+	}
+	return g, true
+}
+
+// GetGroup handles GET /groups/{id}
+func (h *GroupHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	g, ok := h.group(r.Context(), w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+// PutGroup handles PUT /groups/{id}. A group that does not exist yet is
+// created with the given ID; the members of an existing one are replaced.
+func (h *GroupHandler) PutGroup(w http.ResponseWriter, r *http.Request) {
+	var req models.SyncGroupRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	g, err := groupFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	g.ID = mux.Vars(r)["id"]
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		writeGroupError(w, err, "update")
+		return
+	}
+	defer tx.Rollback()
+
+	var created bool
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO sync_groups (id, name, description, paused, sync_interval_seconds, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (id) DO UPDATE
+		 SET name = EXCLUDED.name, description = EXCLUDED.description, paused = EXCLUDED.paused,
+		     sync_interval_seconds = EXCLUDED.sync_interval_seconds, external_id = EXCLUDED.external_id
+		 RETURNING created_at, xmax = 0`,
+		g.ID, g.Name, g.Description, g.Paused, g.SyncIntervalSeconds, g.ExternalID, h.Clock.Now()).
+		Scan(&g.CreatedAt, &created)
+	if err == nil {
+		err = setMembers(ctx, tx, g.ID, g.RepositoryIDs)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeGroupError(w, err, "update")
+		return
+	}
+	action, status := "sync_group.update", http.StatusOK
+	if created {
+		action, status = "sync_group.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "sync_group", g.ID, map[string]any{"name": g.Name, "repositories": len(g.RepositoryIDs)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(g)
+}
+
+// DeleteGroup handles DELETE /groups/{id}. The member repositories are kept.
+func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM sync_groups WHERE id = $1`, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "sync_group.delete", "sync_group", mux.Vars(r)["id"], nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PauseGroup handles POST /groups/{id}/pause
+func (h *GroupHandler) PauseGroup(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, true)
+}
+
+// ResumeGroup handles POST /groups/{id}/resume
+func (h *GroupHandler) ResumeGroup(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, false)
+}
+
+func (h *GroupHandler) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	res, err := h.DB.ExecContext(ctx, `UPDATE sync_groups SET paused = $2 WHERE id = $1`, id, paused)
+	if isInvalidUUID(err) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update sync group %s: %v", id, err)
+		http.Error(w, "failed to update group", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	action := "sync_group.resume"
+	if paused {
+		action = "sync_group.pause"
+	}
+	recordAudit(r, h.DB, action, "sync_group", id, nil)
+
+	g, ok := h.group(ctx, w, id)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+// SyncGroup handles POST /groups/{id}/sync. Every member is queued in one
+// go and the run completes in the background; members already syncing are
+// skipped. During a freeze period only admins may sync, with override=true.
+func (h *GroupHandler) SyncGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	if !checkFreeze(ctx, w, r, h.DB, h.Clock.Now()) {
+		return
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "failed to start group sync", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// The lock keeps a concurrent pause or membership change from splitting
+	// the run
+	var paused bool
+	err = tx.QueryRowContext(ctx, `SELECT paused FROM sync_groups WHERE id = $1 FOR UPDATE`, id).Scan(&paused)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch sync group %s: %v", id, err)
+		http.Error(w, "failed to start group sync", http.StatusInternalServerError)
+		return
+	}
+	if paused {
+		http.Error(w, "group is paused", http.StatusConflict)
+		return
+	}
+
+	var members []string
+	err = tx.QueryRowContext(ctx,
+		`SELECT ARRAY(SELECT repository_id::text FROM sync_group_members WHERE group_id = $1 ORDER BY repository_id)`, id).
+		Scan(pq.Array(&members))
+	if err != nil {
+		log.Printf("ERROR: failed to fetch members of sync group %s: %v", id, err)
+		http.Error(w, "failed to start group sync", http.StatusInternalServerError)
+		return
+	}
+
+	actor := audit.Actor(r.Context())
+	run := models.SyncGroupRun{
+		ID:           h.IDs.NewID(),
+		GroupID:      id,
+		Status:       models.GroupRunRunning,
+		TriggeredBy:  &actor,
+		Repositories: len(members),
+		StartedAt:    h.Clock.Now(),
+	}
+	if len(members) == 0 {
+		run.Status, run.FinishedAt = models.GroupRunSucceeded, &run.StartedAt
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO sync_group_runs (id, group_id, status, triggered_by, repositories, started_at, finished_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		run.ID, run.GroupID, run.Status, run.TriggeredBy, run.Repositories, run.StartedAt, run.FinishedAt); err != nil {
+		log.Printf("ERROR: failed to record run of sync group %s: %v", id, err)
+		http.Error(w, "failed to start group sync", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: failed to record run of sync group %s: %v", id, err)
+		http.Error(w, "failed to start group sync", http.StatusInternalServerError)
+		return
+	}
+
+	// The members are counted as their syncs finish, by FinishMember
+	for _, repoID := range members {
+		if err := h.Runner.Enqueue(ctx, repoID, replication.TriggerGroup+":"+run.ID); err != nil {
+			if !errors.Is(err, replication.ErrBusy) {
+				log.Printf("ERROR: %v", err)
+			}
+			h.countMember(run.ID, "skipped")
+			run.Skipped++
+		}
+	}
+	details := map[string]any{"run_id": run.ID, "repositories": len(members)}
+	if r.URL.Query().Get("override") == "true" {
+		details["freeze_override"] = true
+	}
+	recordAudit(r, h.DB, "sync_group.sync", "sync_group", id, details)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// FinishMember counts the finished sync of a member in the group run that
+// triggered it, on whichever instance ran the sync
+func (h *GroupHandler) FinishMember(ctx context.Context, run models.SyncRun) {
+	_, groupRunID, _ := strings.Cut(run.Trigger, ":")
+	if run.Status == models.SyncRunSucceeded {
+		h.countMember(groupRunID, "succeeded")
+		return
+	}
+	h.countMember(groupRunID, "failed")
+}
+
+// countMember adds a finished member to one of the succeeded, failed or
+// skipped counts of a run, completing the run with the last member
+func (h *GroupHandler) countMember(runID, column string) {
+	_, err := h.DB.ExecContext(context.Background(),
+		`UPDATE sync_group_runs
+		 SET `+column+` = `+column+` + 1,
+		     status = CASE WHEN succeeded + failed + skipped + 1 < repositories THEN status
+		                   WHEN failed > 0 OR $2 THEN $3 ELSE $4 END,
+		     finished_at = CASE WHEN succeeded + failed + skipped + 1 < repositories THEN NULL ELSE $5::timestamp END
+		 WHERE id = $1`,
+		runID, column == "failed", models.GroupRunFailed, models.GroupRunSucceeded, h.Clock.Now())
+	if err != nil {
+		log.Printf("ERROR: failed to update sync group run %s: %v", runID, err)
+	}
+}
+
+// ListGroupRuns handles GET /groups/{id}/runs
+func (h *GroupHandler) ListGroupRuns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	if _, ok := h.group(ctx, w, id); !ok {
+		return
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT `+groupRunColumns+` FROM sync_group_runs WHERE group_id = $1 ORDER BY started_at DESC LIMIT 50`, id)
+	if err != nil {
+		http.Error(w, "failed to fetch group runs", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	runs := []models.SyncGroupRun{}
+	for rows.Next() {
+		run, err := scanGroupRun(rows)
+		if err != nil {
+			http.Error(w, "failed to scan group run", http.StatusInternalServerError)
+			return
+		}
+		runs = append(runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+const groupRunColumns = `id, group_id, status, triggered_by, repositories, succeeded, failed, skipped, started_at, finished_at`
+
+func scanGroupRun(row rowScanner) (models.SyncGroupRun, error) {
+	var run models.SyncGroupRun
+	err := row.Scan(&run.ID, &run.GroupID, &run.Status, &run.TriggeredBy, &run.Repositories,
+		&run.Succeeded, &run.Failed, &run.Skipped, &run.StartedAt, &run.FinishedAt)
+	return run, err
+}
+
+// GetGroupStatus handles GET /groups/{id}/status. Each member is rolled up
+// from the latest execution of each of its approved targets.
+func (h *GroupHandler) GetGroupStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	g, ok := h.group(ctx, w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE last.status = $2),
+		        COUNT(*) FILTER (WHERE last.status = $3 AND t.required),
+		        COUNT(last.status)
+		 FROM sync_group_members m
+		 LEFT JOIN replication_targets t ON t.repository_id = m.repository_id AND t.approval_state = $4
+		 LEFT JOIN LATERAL (
+		     SELECT e.status FROM executions e WHERE e.target_id = t.id ORDER BY e.started_at DESC LIMIT 1
+		 ) last ON TRUE
+		 WHERE m.group_id = $1
+		 GROUP BY m.repository_id`,
+		g.ID, models.ExecutionRunning, models.ExecutionFailed, models.ApprovalApproved)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch status of sync group %s: %v", g.ID, err)
+		http.Error(w, "failed to fetch group status", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var status models.SyncGroupStatus
+	for rows.Next() {
+		var running, failed, synced int
+		if err := rows.Scan(&running, &failed, &synced); err != nil {
+			http.Error(w, "failed to scan group status", http.StatusInternalServerError)
+			return
+		}
+		status.Repositories++
+		switch {
+		case running > 0:
+			status.Syncing++
+		case failed > 0:
+			status.Failed++
+		case synced == 0:
+			status.NeverSynced++
+		default:
+			status.Healthy++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "failed to fetch group status", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case g.Paused:
+		status.Status = models.GroupStatusPaused
+	case status.Failed > 0:
+		status.Status = models.GroupStatusFailed
+	case status.Syncing > 0:
+		status.Status = models.GroupStatusSyncing
+	case status.Healthy == 0:
+		status.Status = models.GroupStatusNever
+	default:
+		status.Status = models.GroupStatusHealthy
+	}
+
+	run, err := scanGroupRun(h.DB.QueryRowContext(ctx,
+		`SELECT `+groupRunColumns+` FROM sync_group_runs WHERE group_id = $1 ORDER BY started_at DESC LIMIT 1`, g.ID))
+	if err == nil {
+		status.LastRun = &run
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("ERROR: failed to fetch last run of sync group %s: %v", g.ID, err)
+		http.Error(w, "failed to fetch group status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
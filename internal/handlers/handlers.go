@@ -1,34 +1,189 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
+	"gitsync/internal/audit"
+	"gitsync/internal/backup"
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/compliance"
+	"gitsync/internal/consistency"
+	"gitsync/internal/credentials"
 	"gitsync/internal/database"
+	"gitsync/internal/deploykeys"
+	"gitsync/internal/digest"
+	"gitsync/internal/federation"
+	"gitsync/internal/gitops"
+	"gitsync/internal/hostkeys"
+	"gitsync/internal/ids"
+	"gitsync/internal/maintenance"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+	"gitsync/internal/policy"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/sessions"
+	"gitsync/internal/trash"
+	"gitsync/internal/validation"
+	"gitsync/internal/webhooks"
 )
 
+// Deps bundles the services the HTTP handlers depend on
+type Deps struct {
+	DB       *database.DB
+	Webhooks *webhooks.Manager
+	// WebhookSecrets maps provider names to the secret deliveries of
+	// hooks configured by hand are signed with
+	WebhookSecrets map[string]string
+	// DeployKeys are added to the remotes of SSH targets when enabled
+	DeployKeys  *deploykeys.Manager
+	Prober      *provider.Prober
+	Credentials *credentials.Store
+	Client      *provider.Client
+	URLPolicy   validation.URLPolicy
+	Notifier    *notify.Dispatcher
+	Digest      *digest.Builder
+	Policies    *policy.Engine
+	Exports     *compliance.Exporter
+	GitOps      *gitops.Reconciler
+	// Federation exchanges state with the peer instance when enabled
+	Federation  *federation.Syncer
+	Runner      *replication.Runner
+	Backups     *backup.Job
+	Consistency *consistency.Job
+	Maintenance *maintenance.Job
+	Retention   models.Retention
+	// Transfer is the global git transfer settings repositories override
+	Transfer models.Transfer
+	// RequireTargetApproval holds targets created by non-admins for approval
+	RequireTargetApproval bool
+	// RBAC scopes repository listings of non-admins to their teams
+	RBAC bool
+	// Cache keeps hot listings between writes; nil disables it
+	Cache *cache.Cache
+	// Clock and IDs stamp new records; the wall clock and random UUIDs
+	// when nil
+	Clock clock.Clock
+	IDs   ids.Generator
+	// Build is served by the version endpoint
+	Build models.BuildInfo
+	// TrashRetention is how long deleted repositories and targets can be
+	// restored; 0 deletes them outright
+	TrashRetention time.Duration
+	// HostKeys are the SSH host keys git trusts
+	HostKeys *hostkeys.Store
+	// Sessions logs browser users in with SSO; login is disabled when nil
+	Sessions *sessions.Manager
+}
+
 // Handler is a facade that delegates to specialized handlers
 type Handler struct {
 	*RepoHandler
 	*TargetHandler
+	*ProviderHandler
+	*CredentialHandler
+	*NotificationHandler
+	*ReportHandler
+	*InboxHandler
+	*PolicyHandler
+	*ComplianceHandler
+	*RetentionHandler
+	*GitOpsHandler
+	*FederationHandler
+	*GroupHandler
+	*FreezeHandler
+	*IntegrityHandler
+	*BackupHandler
+	*ConsistencyHandler
+	*SecretHandler
+	*IdentityHandler
+	*ScheduleHandler
+	*SyncRunHandler
+	*WebhookHandler
+	*TrashHandler
+	*HostKeyHandler
+	*TransferHandler
+	*MaintenanceHandler
+	*PushApprovalHandler
+	*AnomalyHandler
+	*SessionHandler
+	*MergeHandler
+	*ImportHandler
+	*DefaultTargetHandler
+	*TemplateHandler
+	*PromoteHandler
+
+	build models.BuildInfo
 }
 
 // NewHandler creates a new Handler with all sub-handlers
-func NewHandler(db *database.DB) *Handler {
+func NewHandler(deps Deps) *Handler {
+	if deps.Clock == nil {
+		deps.Clock = clock.System{}
+	}
+	if deps.IDs == nil {
+		deps.IDs = ids.Random{}
+	}
+	bin := trash.NewBin(deps.DB, deps.TrashRetention, deps.Clock, deps.IDs)
+	targets := NewTargetHandler(deps.DB, deps.Credentials, deps.URLPolicy, deps.Policies, deps.RequireTargetApproval, deps.RBAC, deps.Cache, deps.Runner, bin, deps.DeployKeys, deps.Client, deps.Clock, deps.IDs)
+	defaults := NewDefaultTargetHandler(targets)
+	templates := NewTemplateHandler(deps.DB, deps.Policies, deps.Cache, deps.Clock, deps.IDs)
+	repos := NewRepoHandler(deps.DB, deps.Webhooks, deps.Credentials, deps.URLPolicy, deps.Policies, deps.RBAC, deps.Cache, bin, deps.DeployKeys, defaults, templates, deps.Runner, deps.Clock, deps.IDs)
+	groups := NewGroupHandler(deps.DB, deps.Runner, deps.Clock, deps.IDs)
+	if deps.Runner != nil {
+		deps.Runner.OnFinish(replication.TriggerGroup, groups.FinishMember)
+	}
 	return &Handler{
-		RepoHandler:   NewRepoHandler(db),
-		TargetHandler: NewTargetHandler(db),
+		RepoHandler:          repos,
+		TargetHandler:        targets,
+		ProviderHandler:      NewProviderHandler(deps.Prober),
+		CredentialHandler:    NewCredentialHandler(deps.DB, deps.Credentials, deps.Client),
+		NotificationHandler:  NewNotificationHandler(deps.DB, deps.Notifier, deps.Clock, deps.IDs),
+		ReportHandler:        NewReportHandler(deps.DB, deps.Digest, deps.Clock),
+		InboxHandler:         NewInboxHandler(deps.DB),
+		PolicyHandler:        NewPolicyHandler(deps.DB, deps.Clock, deps.IDs),
+		ComplianceHandler:    NewComplianceHandler(deps.DB, deps.Exports, deps.Clock, deps.IDs),
+		RetentionHandler:     NewRetentionHandler(deps.DB, deps.Retention),
+		GitOpsHandler:        NewGitOpsHandler(deps.GitOps),
+		FederationHandler:    NewFederationHandler(deps.DB, deps.Federation),
+		GroupHandler:         groups,
+		FreezeHandler:        NewFreezeHandler(deps.DB, deps.Clock, deps.IDs),
+		IntegrityHandler:     NewIntegrityHandler(deps.DB),
+		BackupHandler:        NewBackupHandler(deps.DB, deps.Backups, deps.Credentials, deps.URLPolicy),
+		ConsistencyHandler:   NewConsistencyHandler(deps.DB, deps.Consistency),
+		SecretHandler:        NewSecretHandler(deps.DB, deps.Clock),
+		IdentityHandler:      NewIdentityHandler(deps.DB, deps.RBAC, deps.Clock),
+		ScheduleHandler:      NewScheduleHandler(deps.DB, deps.RBAC, deps.Runner, deps.Clock),
+		SyncRunHandler:       NewSyncRunHandler(deps.DB, deps.RBAC, deps.Runner, deps.Clock),
+		WebhookHandler:       NewWebhookHandler(deps.DB, deps.Credentials, deps.WebhookSecrets, deps.Runner, deps.Clock),
+		TrashHandler:         NewTrashHandler(deps.DB, deps.RBAC, bin, deps.Cache, deps.DeployKeys),
+		HostKeyHandler:       NewHostKeyHandler(deps.DB, deps.HostKeys),
+		TransferHandler:      NewTransferHandler(deps.DB, deps.Transfer),
+		MaintenanceHandler:   NewMaintenanceHandler(deps.Maintenance),
+		PushApprovalHandler:  NewPushApprovalHandler(deps.DB, deps.Runner, deps.Clock),
+		AnomalyHandler:       NewAnomalyHandler(deps.DB, deps.Notifier, deps.Clock),
+		SessionHandler:       NewSessionHandler(deps.Sessions),
+		MergeHandler:         NewMergeHandler(deps.DB, deps.Webhooks, deps.Runner, deps.Cache),
+		ImportHandler:        NewImportHandler(repos, targets),
+		DefaultTargetHandler: defaults,
+		TemplateHandler:      templates,
+		PromoteHandler:       NewPromoteHandler(deps.DB, deps.Webhooks, deps.DeployKeys, deps.Policies, deps.Runner, bin, deps.Cache, deps.Clock, deps.IDs),
+		build:                deps.Build,
 	}
 }
 
+// recordAudit appends an audit log entry for a change made by the caller of
+// r. The change is done by then, so the entry is written even if the
+// request is cancelled.
+func recordAudit(r *http.Request, db *database.DB, action, resourceType, resourceID string, details map[string]any) {
+	audit.Record(context.WithoutCancel(r.Context()), db, audit.Actor(r.Context()), action, resourceType, resourceID, details)
+}
+
 // HealthCheck returns the health status of the service
-// @Summary Health check
-// @Description Returns the health status of the service
-// @Tags health
-// @Accept json
-// @Produce json
-// @Success 200 {object} map[string]string
-// @Router /health [get]
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -36,17 +191,622 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Version returns the build info of the server
+func (h *Handler) Version(w http.ResponseWriter, r *http.Request) {
+	writeBody(w, r, http.StatusOK, h.build)
+}
+
 // CreateRepository delegates to RepoHandler
 func (h *Handler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 	h.RepoHandler.CreateRepository(w, r)
 }
 
+// ImportRepositories delegates to RepoHandler
+func (h *Handler) ImportRepositories(w http.ResponseWriter, r *http.Request) {
+	h.RepoHandler.ImportRepositories(w, r)
+}
+
 // ListRepositories delegates to RepoHandler
 func (h *Handler) ListRepositories(w http.ResponseWriter, r *http.Request) {
 	h.RepoHandler.ListRepositories(w, r)
 }
 
+// ExportRepositoriesCSV delegates to RepoHandler
+func (h *Handler) ExportRepositoriesCSV(w http.ResponseWriter, r *http.Request) {
+	h.RepoHandler.ExportRepositoriesCSV(w, r)
+}
+
+// GetRepository delegates to RepoHandler
+func (h *Handler) GetRepository(w http.ResponseWriter, r *http.Request) {
+	h.RepoHandler.GetRepository(w, r)
+}
+
+// PutRepository delegates to RepoHandler
+func (h *Handler) PutRepository(w http.ResponseWriter, r *http.Request) {
+	h.RepoHandler.PutRepository(w, r)
+}
+
+// PatchRepository delegates to RepoHandler
+func (h *Handler) PatchRepository(w http.ResponseWriter, r *http.Request) {
+	h.RepoHandler.PatchRepository(w, r)
+}
+
+// DeleteRepository delegates to RepoHandler
+func (h *Handler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
+	h.RepoHandler.DeleteRepository(w, r)
+}
+
 // CreateTarget delegates to TargetHandler
 func (h *Handler) CreateTarget(w http.ResponseWriter, r *http.Request) {
 	h.TargetHandler.CreateTarget(w, r)
 }
+
+// BulkCreateTargets delegates to TargetHandler
+func (h *Handler) BulkCreateTargets(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.BulkCreateTargets(w, r)
+}
+
+// RedriveTarget delegates to TargetHandler
+func (h *Handler) RedriveTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.RedriveTarget(w, r)
+}
+
+// ApproveTarget delegates to TargetHandler
+func (h *Handler) ApproveTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.ApproveTarget(w, r)
+}
+
+// ListTrash delegates to TrashHandler
+func (h *Handler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	h.TrashHandler.ListTrash(w, r)
+}
+
+// RestoreTrash delegates to TrashHandler
+func (h *Handler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	h.TrashHandler.RestoreTrash(w, r)
+}
+
+// ListSSHHostKeys delegates to HostKeyHandler
+func (h *Handler) ListSSHHostKeys(w http.ResponseWriter, r *http.Request) {
+	h.HostKeyHandler.ListSSHHostKeys(w, r)
+}
+
+// PutSSHHostKeys delegates to HostKeyHandler
+func (h *Handler) PutSSHHostKeys(w http.ResponseWriter, r *http.Request) {
+	h.HostKeyHandler.PutSSHHostKeys(w, r)
+}
+
+// DeleteSSHHostKeys delegates to HostKeyHandler
+func (h *Handler) DeleteSSHHostKeys(w http.ResponseWriter, r *http.Request) {
+	h.HostKeyHandler.DeleteSSHHostKeys(w, r)
+}
+
+// SyncRepository delegates to SyncRunHandler
+func (h *Handler) SyncRepository(w http.ResponseWriter, r *http.Request) {
+	h.SyncRunHandler.SyncRepository(w, r)
+}
+
+// ListRepositorySyncs delegates to SyncRunHandler
+func (h *Handler) ListRepositorySyncs(w http.ResponseWriter, r *http.Request) {
+	h.SyncRunHandler.ListRepositorySyncs(w, r)
+}
+
+// GetSync delegates to SyncRunHandler
+func (h *Handler) GetSync(w http.ResponseWriter, r *http.Request) {
+	h.SyncRunHandler.GetSync(w, r)
+}
+
+// ReceiveWebhook delegates to WebhookHandler
+func (h *Handler) ReceiveWebhook(w http.ResponseWriter, r *http.Request) {
+	h.WebhookHandler.ReceiveWebhook(w, r)
+}
+
+// GetRepositorySchedule delegates to ScheduleHandler
+func (h *Handler) GetRepositorySchedule(w http.ResponseWriter, r *http.Request) {
+	h.ScheduleHandler.GetRepositorySchedule(w, r)
+}
+
+// GetIdentityMapping delegates to IdentityHandler
+func (h *Handler) GetIdentityMapping(w http.ResponseWriter, r *http.Request) {
+	h.IdentityHandler.GetIdentityMapping(w, r)
+}
+
+// ListIdentityMappings delegates to IdentityHandler
+func (h *Handler) ListIdentityMappings(w http.ResponseWriter, r *http.Request) {
+	h.IdentityHandler.ListIdentityMappings(w, r)
+}
+
+// PutIdentityMapping delegates to IdentityHandler
+func (h *Handler) PutIdentityMapping(w http.ResponseWriter, r *http.Request) {
+	h.IdentityHandler.PutIdentityMapping(w, r)
+}
+
+// ListTargets delegates to TargetHandler
+func (h *Handler) ListTargets(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.ListTargets(w, r)
+}
+
+// GetTarget delegates to TargetHandler
+func (h *Handler) GetTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.GetTarget(w, r)
+}
+
+// PutTarget delegates to TargetHandler
+func (h *Handler) PutTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.PutTarget(w, r)
+}
+
+// DeleteTarget delegates to TargetHandler
+func (h *Handler) DeleteTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.DeleteTarget(w, r)
+}
+
+// ListRepositoryTargets delegates to TargetHandler
+func (h *Handler) ListRepositoryTargets(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.ListRepositoryTargets(w, r)
+}
+
+// GetRepositoryTarget delegates to TargetHandler
+func (h *Handler) GetRepositoryTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.GetRepositoryTarget(w, r)
+}
+
+// PatchTarget delegates to TargetHandler
+func (h *Handler) PatchTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.PatchTarget(w, r)
+}
+
+// DeleteRepositoryTarget delegates to TargetHandler
+func (h *Handler) DeleteRepositoryTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.DeleteRepositoryTarget(w, r)
+}
+
+// GetProviderStatus delegates to ProviderHandler
+func (h *Handler) GetProviderStatus(w http.ResponseWriter, r *http.Request) {
+	h.ProviderHandler.GetProviderStatus(w, r)
+}
+
+// CreateCredential delegates to CredentialHandler
+func (h *Handler) CreateCredential(w http.ResponseWriter, r *http.Request) {
+	h.CredentialHandler.CreateCredential(w, r)
+}
+
+// GetCredential delegates to CredentialHandler
+func (h *Handler) GetCredential(w http.ResponseWriter, r *http.Request) {
+	h.CredentialHandler.GetCredential(w, r)
+}
+
+// ListCredentials delegates to CredentialHandler
+func (h *Handler) ListCredentials(w http.ResponseWriter, r *http.Request) {
+	h.CredentialHandler.ListCredentials(w, r)
+}
+
+// GetCredentialUsage delegates to CredentialHandler
+func (h *Handler) GetCredentialUsage(w http.ResponseWriter, r *http.Request) {
+	h.CredentialHandler.GetCredentialUsage(w, r)
+}
+
+// ReplaceCredential delegates to CredentialHandler
+func (h *Handler) ReplaceCredential(w http.ResponseWriter, r *http.Request) {
+	h.CredentialHandler.ReplaceCredential(w, r)
+}
+
+// DeleteCredential delegates to CredentialHandler
+func (h *Handler) DeleteCredential(w http.ResponseWriter, r *http.Request) {
+	h.CredentialHandler.DeleteCredential(w, r)
+}
+
+// CreateNotificationChannel delegates to NotificationHandler
+func (h *Handler) CreateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.CreateNotificationChannel(w, r)
+}
+
+// ListNotificationChannels delegates to NotificationHandler
+func (h *Handler) ListNotificationChannels(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.ListNotificationChannels(w, r)
+}
+
+// GetNotificationChannel delegates to NotificationHandler
+func (h *Handler) GetNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.GetNotificationChannel(w, r)
+}
+
+// PutNotificationChannel delegates to NotificationHandler
+func (h *Handler) PutNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.PutNotificationChannel(w, r)
+}
+
+// DeleteNotificationChannel delegates to NotificationHandler
+func (h *Handler) DeleteNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.DeleteNotificationChannel(w, r)
+}
+
+// ListNotificationDeliveries delegates to NotificationHandler
+func (h *Handler) ListNotificationDeliveries(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.ListNotificationDeliveries(w, r)
+}
+
+// TestNotificationChannel delegates to NotificationHandler
+func (h *Handler) TestNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.TestNotificationChannel(w, r)
+}
+
+// CreateNotificationRule delegates to NotificationHandler
+func (h *Handler) CreateNotificationRule(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.CreateNotificationRule(w, r)
+}
+
+// ListNotificationRules delegates to NotificationHandler
+func (h *Handler) ListNotificationRules(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.ListNotificationRules(w, r)
+}
+
+// GetNotificationRule delegates to NotificationHandler
+func (h *Handler) GetNotificationRule(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.GetNotificationRule(w, r)
+}
+
+// UpdateNotificationRule delegates to NotificationHandler
+func (h *Handler) UpdateNotificationRule(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.UpdateNotificationRule(w, r)
+}
+
+// DeleteNotificationRule delegates to NotificationHandler
+func (h *Handler) DeleteNotificationRule(w http.ResponseWriter, r *http.Request) {
+	h.NotificationHandler.DeleteNotificationRule(w, r)
+}
+
+// GetSyncStats delegates to ReportHandler
+func (h *Handler) GetSyncStats(w http.ResponseWriter, r *http.Request) {
+	h.ReportHandler.GetSyncStats(w, r)
+}
+
+// GetDigest delegates to ReportHandler
+func (h *Handler) GetDigest(w http.ResponseWriter, r *http.Request) {
+	h.ReportHandler.GetDigest(w, r)
+}
+
+// ListNotifications delegates to InboxHandler
+func (h *Handler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	h.InboxHandler.ListNotifications(w, r)
+}
+
+// GetUnreadCount delegates to InboxHandler
+func (h *Handler) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	h.InboxHandler.GetUnreadCount(w, r)
+}
+
+// MarkNotificationRead delegates to InboxHandler
+func (h *Handler) MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	h.InboxHandler.MarkNotificationRead(w, r)
+}
+
+// MarkAllNotificationsRead delegates to InboxHandler
+func (h *Handler) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	h.InboxHandler.MarkAllNotificationsRead(w, r)
+}
+
+// CreatePolicy delegates to PolicyHandler
+func (h *Handler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
+	h.PolicyHandler.CreatePolicy(w, r)
+}
+
+// ListPolicies delegates to PolicyHandler
+func (h *Handler) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	h.PolicyHandler.ListPolicies(w, r)
+}
+
+// GetPolicy delegates to PolicyHandler
+func (h *Handler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	h.PolicyHandler.GetPolicy(w, r)
+}
+
+// UpdatePolicy delegates to PolicyHandler
+func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	h.PolicyHandler.UpdatePolicy(w, r)
+}
+
+// DeletePolicy delegates to PolicyHandler
+func (h *Handler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	h.PolicyHandler.DeletePolicy(w, r)
+}
+
+// ListPolicyViolations delegates to PolicyHandler
+func (h *Handler) ListPolicyViolations(w http.ResponseWriter, r *http.Request) {
+	h.PolicyHandler.ListPolicyViolations(w, r)
+}
+
+// CreateComplianceExport delegates to ComplianceHandler
+func (h *Handler) CreateComplianceExport(w http.ResponseWriter, r *http.Request) {
+	h.ComplianceHandler.CreateComplianceExport(w, r)
+}
+
+// ListComplianceExports delegates to ComplianceHandler
+func (h *Handler) ListComplianceExports(w http.ResponseWriter, r *http.Request) {
+	h.ComplianceHandler.ListComplianceExports(w, r)
+}
+
+// GetComplianceExport delegates to ComplianceHandler
+func (h *Handler) GetComplianceExport(w http.ResponseWriter, r *http.Request) {
+	h.ComplianceHandler.GetComplianceExport(w, r)
+}
+
+// DownloadComplianceExport delegates to ComplianceHandler
+func (h *Handler) DownloadComplianceExport(w http.ResponseWriter, r *http.Request) {
+	h.ComplianceHandler.DownloadComplianceExport(w, r)
+}
+
+// GetRepositoryRetention delegates to RetentionHandler
+func (h *Handler) GetRepositoryRetention(w http.ResponseWriter, r *http.Request) {
+	h.RetentionHandler.GetRepositoryRetention(w, r)
+}
+
+// UpdateRepositoryRetention delegates to RetentionHandler
+func (h *Handler) UpdateRepositoryRetention(w http.ResponseWriter, r *http.Request) {
+	h.RetentionHandler.UpdateRepositoryRetention(w, r)
+}
+
+// GetRepositoryTransfer delegates to TransferHandler
+func (h *Handler) GetRepositoryTransfer(w http.ResponseWriter, r *http.Request) {
+	h.TransferHandler.GetRepositoryTransfer(w, r)
+}
+
+// UpdateRepositoryTransfer delegates to TransferHandler
+func (h *Handler) UpdateRepositoryTransfer(w http.ResponseWriter, r *http.Request) {
+	h.TransferHandler.UpdateRepositoryTransfer(w, r)
+}
+
+// MaintainRepository delegates to MaintenanceHandler
+func (h *Handler) MaintainRepository(w http.ResponseWriter, r *http.Request) {
+	h.MaintenanceHandler.MaintainRepository(w, r)
+}
+
+// ListPushApprovals delegates to PushApprovalHandler
+func (h *Handler) ListPushApprovals(w http.ResponseWriter, r *http.Request) {
+	h.PushApprovalHandler.ListPushApprovals(w, r)
+}
+
+// GetPushApproval delegates to PushApprovalHandler
+func (h *Handler) GetPushApproval(w http.ResponseWriter, r *http.Request) {
+	h.PushApprovalHandler.GetPushApproval(w, r)
+}
+
+// ApprovePushApproval delegates to PushApprovalHandler
+func (h *Handler) ApprovePushApproval(w http.ResponseWriter, r *http.Request) {
+	h.PushApprovalHandler.ApprovePushApproval(w, r)
+}
+
+// RejectPushApproval delegates to PushApprovalHandler
+func (h *Handler) RejectPushApproval(w http.ResponseWriter, r *http.Request) {
+	h.PushApprovalHandler.RejectPushApproval(w, r)
+}
+
+// ListAnomalies delegates to AnomalyHandler
+func (h *Handler) ListAnomalies(w http.ResponseWriter, r *http.Request) {
+	h.AnomalyHandler.ListAnomalies(w, r)
+}
+
+// AcknowledgeAnomaly delegates to AnomalyHandler
+func (h *Handler) AcknowledgeAnomaly(w http.ResponseWriter, r *http.Request) {
+	h.AnomalyHandler.AcknowledgeAnomaly(w, r)
+}
+
+// MergeRepository delegates to MergeHandler
+func (h *Handler) MergeRepository(w http.ResponseWriter, r *http.Request) {
+	h.MergeHandler.MergeRepository(w, r)
+}
+
+// ImportCSV delegates to ImportHandler
+func (h *Handler) ImportCSV(w http.ResponseWriter, r *http.Request) {
+	h.ImportHandler.ImportCSV(w, r)
+}
+
+// CreateDefaultTarget delegates to DefaultTargetHandler
+func (h *Handler) CreateDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	h.DefaultTargetHandler.CreateDefaultTarget(w, r)
+}
+
+// ListDefaultTargets delegates to DefaultTargetHandler
+func (h *Handler) ListDefaultTargets(w http.ResponseWriter, r *http.Request) {
+	h.DefaultTargetHandler.ListDefaultTargets(w, r)
+}
+
+// GetDefaultTarget delegates to DefaultTargetHandler
+func (h *Handler) GetDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	h.DefaultTargetHandler.GetDefaultTarget(w, r)
+}
+
+// PutDefaultTarget delegates to DefaultTargetHandler
+func (h *Handler) PutDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	h.DefaultTargetHandler.PutDefaultTarget(w, r)
+}
+
+// DeleteDefaultTarget delegates to DefaultTargetHandler
+func (h *Handler) DeleteDefaultTarget(w http.ResponseWriter, r *http.Request) {
+	h.DefaultTargetHandler.DeleteDefaultTarget(w, r)
+}
+
+// CreateRepositoryTemplate delegates to TemplateHandler
+func (h *Handler) CreateRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	h.TemplateHandler.CreateRepositoryTemplate(w, r)
+}
+
+// ListRepositoryTemplates delegates to TemplateHandler
+func (h *Handler) ListRepositoryTemplates(w http.ResponseWriter, r *http.Request) {
+	h.TemplateHandler.ListRepositoryTemplates(w, r)
+}
+
+// GetRepositoryTemplate delegates to TemplateHandler
+func (h *Handler) GetRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	h.TemplateHandler.GetRepositoryTemplate(w, r)
+}
+
+// PutRepositoryTemplate delegates to TemplateHandler
+func (h *Handler) PutRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	h.TemplateHandler.PutRepositoryTemplate(w, r)
+}
+
+// DeleteRepositoryTemplate delegates to TemplateHandler
+func (h *Handler) DeleteRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	h.TemplateHandler.DeleteRepositoryTemplate(w, r)
+}
+
+// ApplyRepositoryTemplate delegates to TemplateHandler
+func (h *Handler) ApplyRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	h.TemplateHandler.ApplyRepositoryTemplate(w, r)
+}
+
+// PromoteTarget delegates to PromoteHandler
+func (h *Handler) PromoteTarget(w http.ResponseWriter, r *http.Request) {
+	h.PromoteHandler.PromoteTarget(w, r)
+}
+
+// Login delegates to SessionHandler
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	h.SessionHandler.Login(w, r)
+}
+
+// LoginCallback delegates to SessionHandler
+func (h *Handler) LoginCallback(w http.ResponseWriter, r *http.Request) {
+	h.SessionHandler.LoginCallback(w, r)
+}
+
+// GetSession delegates to SessionHandler
+func (h *Handler) GetSession(w http.ResponseWriter, r *http.Request) {
+	h.SessionHandler.GetSession(w, r)
+}
+
+// Logout delegates to SessionHandler
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	h.SessionHandler.Logout(w, r)
+}
+
+// CreateGroup delegates to GroupHandler
+func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.CreateGroup(w, r)
+}
+
+// ListGroups delegates to GroupHandler
+func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.ListGroups(w, r)
+}
+
+// GetGroup delegates to GroupHandler
+func (h *Handler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.GetGroup(w, r)
+}
+
+// PutGroup delegates to GroupHandler
+func (h *Handler) PutGroup(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.PutGroup(w, r)
+}
+
+// DeleteGroup delegates to GroupHandler
+func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.DeleteGroup(w, r)
+}
+
+// PauseGroup delegates to GroupHandler
+func (h *Handler) PauseGroup(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.PauseGroup(w, r)
+}
+
+// ResumeGroup delegates to GroupHandler
+func (h *Handler) ResumeGroup(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.ResumeGroup(w, r)
+}
+
+// SyncGroup delegates to GroupHandler
+func (h *Handler) SyncGroup(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.SyncGroup(w, r)
+}
+
+// ListGroupRuns delegates to GroupHandler
+func (h *Handler) ListGroupRuns(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.ListGroupRuns(w, r)
+}
+
+// GetGroupStatus delegates to GroupHandler
+func (h *Handler) GetGroupStatus(w http.ResponseWriter, r *http.Request) {
+	h.GroupHandler.GetGroupStatus(w, r)
+}
+
+// CreateFreezePeriod delegates to FreezeHandler
+func (h *Handler) CreateFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	h.FreezeHandler.CreateFreezePeriod(w, r)
+}
+
+// ListFreezePeriods delegates to FreezeHandler
+func (h *Handler) ListFreezePeriods(w http.ResponseWriter, r *http.Request) {
+	h.FreezeHandler.ListFreezePeriods(w, r)
+}
+
+// GetFreezePeriod delegates to FreezeHandler
+func (h *Handler) GetFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	h.FreezeHandler.GetFreezePeriod(w, r)
+}
+
+// PutFreezePeriod delegates to FreezeHandler
+func (h *Handler) PutFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	h.FreezeHandler.PutFreezePeriod(w, r)
+}
+
+// DeleteFreezePeriod delegates to FreezeHandler
+func (h *Handler) DeleteFreezePeriod(w http.ResponseWriter, r *http.Request) {
+	h.FreezeHandler.DeleteFreezePeriod(w, r)
+}
+
+// ListIntegrityFindings delegates to IntegrityHandler
+func (h *Handler) ListIntegrityFindings(w http.ResponseWriter, r *http.Request) {
+	h.IntegrityHandler.ListIntegrityFindings(w, r)
+}
+
+// ListSecretFindings delegates to SecretHandler
+func (h *Handler) ListSecretFindings(w http.ResponseWriter, r *http.Request) {
+	h.SecretHandler.ListSecretFindings(w, r)
+}
+
+// AllowSecretFinding delegates to SecretHandler
+func (h *Handler) AllowSecretFinding(w http.ResponseWriter, r *http.Request) {
+	h.SecretHandler.AllowSecretFinding(w, r)
+}
+
+// GetBackupStatus delegates to BackupHandler
+func (h *Handler) GetBackupStatus(w http.ResponseWriter, r *http.Request) {
+	h.BackupHandler.GetBackupStatus(w, r)
+}
+
+// ListBackupSnapshots delegates to BackupHandler
+func (h *Handler) ListBackupSnapshots(w http.ResponseWriter, r *http.Request) {
+	h.BackupHandler.ListBackupSnapshots(w, r)
+}
+
+// GetBackupSnapshot delegates to BackupHandler
+func (h *Handler) GetBackupSnapshot(w http.ResponseWriter, r *http.Request) {
+	h.BackupHandler.GetBackupSnapshot(w, r)
+}
+
+// RestoreRepository delegates to BackupHandler
+func (h *Handler) RestoreRepository(w http.ResponseWriter, r *http.Request) {
+	h.BackupHandler.RestoreRepository(w, r)
+}
+
+// ListConsistencyAudits delegates to ConsistencyHandler
+func (h *Handler) ListConsistencyAudits(w http.ResponseWriter, r *http.Request) {
+	h.ConsistencyHandler.ListConsistencyAudits(w, r)
+}
+
+// GetConsistencyAudit delegates to ConsistencyHandler
+func (h *Handler) GetConsistencyAudit(w http.ResponseWriter, r *http.Request) {
+	h.ConsistencyHandler.GetConsistencyAudit(w, r)
+}
+
+// StartConsistencyAudit delegates to ConsistencyHandler
+func (h *Handler) StartConsistencyAudit(w http.ResponseWriter, r *http.Request) {
+	h.ConsistencyHandler.StartConsistencyAudit(w, r)
+}
+
+// DiffTarget delegates to TargetHandler
+func (h *Handler) DiffTarget(w http.ResponseWriter, r *http.Request) {
+	h.TargetHandler.DiffTarget(w, r)
+}
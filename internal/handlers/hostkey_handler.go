@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/database"
+	"gitsync/internal/hostkeys"
+	"gitsync/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// HostKeyHandler serves the SSH host keys git trusts
+type HostKeyHandler struct {
+	DB       *database.DB
+	HostKeys *hostkeys.Store
+}
+
+// NewHostKeyHandler creates a new HostKeyHandler
+func NewHostKeyHandler(db *database.DB, store *hostkeys.Store) *HostKeyHandler {
+	return &HostKeyHandler{DB: db, HostKeys: store}
+}
+
+// managedHost writes an error and returns "", false unless host keys are
+// managed and the host of r is valid
+func (h *HostKeyHandler) managedHost(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !h.HostKeys.Enabled() {
+		http.Error(w, "SSH host keys are not managed", http.StatusServiceUnavailable)
+		return "", false
+	}
+	host, err := hostkeys.NormalizeHost(mux.Vars(r)["host"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return host, true
+}
+
+// ListSSHHostKeys handles GET /ssh-host-keys, optionally for one host
+func (h *HostKeyHandler) ListSSHHostKeys(w http.ResponseWriter, r *http.Request) {
+	where, args := "", []any{}
+	if v := r.URL.Query().Get("host"); v != "" {
+		host, err := hostkeys.NormalizeHost(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where, args = "WHERE host = $1", append(args, host)
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+hostkeys.Columns+` FROM ssh_host_keys `+where+` ORDER BY host, created_at, key_type`, args...)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch SSH host keys: %v", err)
+		http.Error(w, "failed to fetch SSH host keys", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.SSHHostKey{}
+	for rows.Next() {
+		k, err := hostkeys.Scan(rows)
+		if err != nil {
+			http.Error(w, "failed to scan SSH host key", http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, k)
+	}
+	writeBody(w, r, http.StatusOK, keys)
+}
+
+// PutSSHHostKeys handles PUT /ssh-host-keys/{host}, pinning the given keys
+// as the only ones trusted for the host. This is how a rotated host key is
+// accepted.
+func (h *HostKeyHandler) PutSSHHostKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	host, ok := h.managedHost(w, r)
+	if !ok {
+		return
+	}
+	var req models.SSHHostKeysRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.Keys) == 0 {
+		http.Error(w, "at least one key is required; delete the host to forget its keys", http.StatusBadRequest)
+		return
+	}
+	for i, line := range req.Keys {
+		if _, _, _, err := hostkeys.ParseKey(line); err != nil {
+			http.Error(w, fmt.Sprintf("invalid keys[%d]: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var pinnedBy *string
+	if p, ok := auth.FromContext(r.Context()); ok {
+		pinnedBy = &p.User
+	}
+
+	keys, err := h.HostKeys.Pin(r.Context(), host, req.Keys, pinnedBy)
+	if err != nil {
+		log.Printf("ERROR: failed to pin SSH host keys of %s: %v", host, err)
+		http.Error(w, "failed to pin SSH host keys", http.StatusInternalServerError)
+		return
+	}
+	fingerprints := make([]string, len(keys))
+	for i, k := range keys {
+		fingerprints[i] = k.Fingerprint
+	}
+	recordAudit(r, h.DB, "ssh_host_key.pin", "ssh_host", host, map[string]any{"fingerprints": fingerprints})
+	writeBody(w, r, http.StatusOK, keys)
+}
+
+// DeleteSSHHostKeys handles DELETE /ssh-host-keys/{host}, forgetting the
+// keys trusted for the host
+func (h *HostKeyHandler) DeleteSSHHostKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	host, ok := h.managedHost(w, r)
+	if !ok {
+		return
+	}
+	found, err := h.HostKeys.Forget(r.Context(), host)
+	if err != nil {
+		log.Printf("ERROR: failed to forget SSH host keys of %s: %v", host, err)
+		http.Error(w, "failed to delete SSH host keys", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "host has no trusted keys", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "ssh_host_key.forget", "ssh_host", host, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
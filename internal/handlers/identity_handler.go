@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+
+	"github.com/gorilla/mux"
+)
+
+// IdentityHandler serves the versioned identity mappings that rewrite the
+// authors, committers and taggers of the history pushed to a target
+type IdentityHandler struct {
+	DB    *database.DB
+	RBAC  bool
+	Clock clock.Clock
+}
+
+// NewIdentityHandler creates a new IdentityHandler
+func NewIdentityHandler(db *database.DB, rbac bool, clk clock.Clock) *IdentityHandler {
+	return &IdentityHandler{DB: db, RBAC: rbac, Clock: clk}
+}
+
+const identityMappingColumns = `target_id, version, rules, created_by, created_at`
+
+func scanIdentityMapping(row rowScanner) (models.IdentityMapping, error) {
+	var m models.IdentityMapping
+	err := row.Scan(&m.TargetID, &m.Version, &m.Rules, &m.CreatedBy, &m.CreatedAt)
+	return m, err
+}
+
+// findTarget writes a 404 and returns false unless the target of r exists
+// and the caller may see it
+func (h *IdentityHandler) findTarget(w http.ResponseWriter, r *http.Request) bool {
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"t.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return false
+	}
+	var exists bool
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT EXISTS(SELECT 1 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 WHERE `+strings.Join(conds, " AND ")+`)`, args...).Scan(&exists)
+	if isInvalidUUID(err) || (err == nil && !exists) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch target: %v", err)
+		http.Error(w, "failed to fetch target", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// GetIdentityMapping handles GET /targets/{id}/identity-mapping, returning
+// the latest version or the one given by the version parameter
+func (h *IdentityHandler) GetIdentityMapping(w http.ResponseWriter, r *http.Request) {
+	if !h.findTarget(w, r) {
+		return
+	}
+	query, args := `SELECT `+identityMappingColumns+` FROM identity_mappings WHERE target_id = $1`, []any{mux.Vars(r)["id"]}
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			http.Error(w, "version must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		query, args = query+` AND version = $2`, append(args, version)
+	}
+	m, err := scanIdentityMapping(h.DB.QueryRowContext(r.Context(), query+` ORDER BY version DESC LIMIT 1`, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "identity mapping not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch identity mapping: %v", err)
+		http.Error(w, "failed to fetch identity mapping", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, http.StatusOK, m)
+}
+
+// ListIdentityMappings handles GET /targets/{id}/identity-mapping/versions,
+// newest first
+func (h *IdentityHandler) ListIdentityMappings(w http.ResponseWriter, r *http.Request) {
+	if !h.findTarget(w, r) {
+		return
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+identityMappingColumns+` FROM identity_mappings WHERE target_id = $1 ORDER BY version DESC`, mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("ERROR: failed to fetch identity mappings: %v", err)
+		http.Error(w, "failed to fetch identity mappings", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	mappings := []models.IdentityMapping{}
+	for rows.Next() {
+		m, err := scanIdentityMapping(rows)
+		if err != nil {
+			http.Error(w, "failed to scan identity mapping", http.StatusInternalServerError)
+			return
+		}
+		mappings = append(mappings, m)
+	}
+	writeBody(w, r, http.StatusOK, mappings)
+}
+
+// PutIdentityMapping handles PUT /targets/{id}/identity-mapping. It stores
+// the rules as the next version, which the next sync of the target
+// applies; earlier versions are kept.
+func (h *IdentityHandler) PutIdentityMapping(w http.ResponseWriter, r *http.Request) {
+	if !h.findTarget(w, r) {
+		return
+	}
+	var req models.IdentityMappingRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if err := replication.ValidateIdentities(req.Rules); err != nil {
+		http.Error(w, "invalid rules: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var createdBy *string
+	if p, ok := auth.FromContext(r.Context()); ok {
+		createdBy = &p.User
+	}
+
+	m, err := scanIdentityMapping(h.DB.QueryRowContext(r.Context(),
+		`INSERT INTO identity_mappings (target_id, version, rules, created_by, created_at)
+		 SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4 FROM identity_mappings WHERE target_id = $1
+		 RETURNING `+identityMappingColumns,
+		mux.Vars(r)["id"], models.IdentityRules(req.Rules), createdBy, h.Clock.Now()))
+	if isUniqueViolation(err) {
+		http.Error(w, "identity mapping was changed concurrently, retry", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to save identity mapping: %v", err)
+		http.Error(w, "failed to save identity mapping", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "target.identity_mapping", "target", m.TargetID,
+		map[string]any{"version": m.Version, "rules": len(m.Rules)})
+
+	writeBody(w, r, http.StatusCreated, m)
+}
@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/cache"
+	"gitsync/internal/models"
+	"gitsync/internal/policy"
+
+	"github.com/lib/pq"
+)
+
+// ImportHandler imports inventories of existing mirrors, validating them
+// the way single repositories and targets are
+type ImportHandler struct {
+	Repos   *RepoHandler
+	Targets *TargetHandler
+}
+
+// NewImportHandler creates a new ImportHandler
+func NewImportHandler(repos *RepoHandler, targets *TargetHandler) *ImportHandler {
+	return &ImportHandler{Repos: repos, Targets: targets}
+}
+
+// csvColumns are the columns of a CSV import; the first four are required
+var csvColumns = []string{
+	"name", "provider", "source_url", "target_urls",
+	"target_provider", "credential", "target_credential", "owner", "team", "external_id", "skip_default_targets", "template",
+}
+
+// csvRow is a validated row of a CSV import
+type csvRow struct {
+	line      int
+	canonical string
+	repo      models.Repository
+	// secret was embedded in the source URL, and secrets in the target
+	// URLs by index
+	secret  string
+	secrets map[int]string
+}
+
+// ImportCSV handles POST /import/csv. The upload is the body, or its file
+// field for multipart forms. Every row is validated before anything is
+// written; with validate=true nothing is.
+func (h *ImportHandler) ImportCSV(w http.ResponseWriter, r *http.Request) {
+	validateOnly := r.URL.Query().Get("validate") == "true"
+	body, err := csvUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	rd := csv.NewReader(body)
+	rd.FieldsPerRecord = -1
+	rd.TrimLeadingSpace = true
+	header, err := rd.Read()
+	if err != nil {
+		http.Error(w, "failed to read CSV header: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(csvColumns, name) {
+			http.Error(w, fmt.Sprintf("unknown column %q; columns are %s", name, strings.Join(csvColumns, ", ")), http.StatusBadRequest)
+			return
+		}
+		index[name] = i
+	}
+	for _, name := range csvColumns[:4] {
+		if _, ok := index[name]; !ok {
+			http.Error(w, "missing column "+name, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	policies, err := h.Repos.Policies.Enabled(ctx)
+	if err != nil {
+		log.Printf("ERROR: failed to evaluate policies: %v", err)
+		http.Error(w, "failed to evaluate policies", http.StatusInternalServerError)
+		return
+	}
+	defaults, err := h.Repos.Defaults.load(ctx)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch default targets", http.StatusInternalServerError)
+		return
+	}
+
+	res := models.CSVImportResult{ValidateOnly: validateOnly, Errors: []models.ImportRowError{}}
+	fail := func(line int, column string, err error) {
+		res.Errors = append(res.Errors, models.ImportRowError{Line: line, Column: column, Error: err.Error()})
+	}
+	var rows []csvRow
+	seen := map[string]int{}
+	seenExternal := map[string]int{}
+	credentialIDs := map[[2]string]*string{}
+	templates := map[string]*models.RepositoryTemplate{}
+	for {
+		record, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			http.Error(w, "malformed CSV: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read CSV: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(rows)+len(res.Errors) >= maxImportRepositories {
+			http.Error(w, fmt.Sprintf("at most %d repositories can be imported at once", maxImportRepositories), http.StatusBadRequest)
+			return
+		}
+		line, _ := rd.FieldPos(0)
+		get := func(column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+
+		// Credentials are looked up once per name and provider; lookups
+		// that fail for a reason other than the name are fatal
+		credential := func(column, name, providerName string) (*string, bool, bool) {
+			key := [2]string{name, providerName}
+			if id, ok := credentialIDs[key]; ok {
+				return id, true, true
+			}
+			id, err := findCredential(ctx, h.Repos.Credentials, name, providerName)
+			var invalid errCredential
+			if errors.As(err, &invalid) {
+				fail(line, column, err)
+				return nil, false, true
+			}
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				http.Error(w, "failed to fetch credential", http.StatusInternalServerError)
+				return nil, false, false
+			}
+			credentialIDs[key] = id
+			return id, true, true
+		}
+
+		req := models.CreateRepositoryRequest{
+			Name:           get("name"),
+			SourceProvider: get("provider"),
+			SourceURL:      get("source_url"),
+			Credential:     get("credential"),
+			Owner:          get("owner"),
+			Team:           get("team"),
+			ExternalID:     get("external_id"),
+		}
+		tmpl, err := h.Repos.Templates.lookup(ctx, templates, get("template"))
+		var missing errTemplate
+		if errors.As(err, &missing) {
+			fail(line, "template", err)
+			continue
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to fetch repository template", http.StatusInternalServerError)
+			return
+		}
+		if tmpl != nil {
+			fillFromTemplate(&req, tmpl)
+		}
+		secret, err := h.Repos.validateRepositoryRequest(&req)
+		if err != nil {
+			fail(line, "", err)
+			continue
+		}
+		row := csvRow{line: line, canonical: canonicalURL(req.SourceURL), secret: secret, secrets: map[int]string{}}
+		if prev, dup := seen[row.canonical]; dup {
+			fail(line, "source_url", fmt.Errorf("duplicates the source of line %d", prev))
+			continue
+		}
+		seen[row.canonical] = line
+		if req.ExternalID != "" {
+			if prev, dup := seenExternal[req.ExternalID]; dup {
+				fail(line, "external_id", fmt.Errorf("duplicates the external_id of line %d", prev))
+				continue
+			}
+			seenExternal[req.ExternalID] = line
+		}
+		var credentialID *string
+		if secret != "" && req.Credential != "" {
+			fail(line, "credential", errEmbeddedCredential)
+			continue
+		} else if secret == "" {
+			var ok, fine bool
+			if credentialID, ok, fine = credential("credential", req.Credential, req.SourceProvider); !fine {
+				return
+			} else if !ok {
+				continue
+			}
+		}
+		row.repo = h.Repos.newRepository(req, credentialID)
+		if !h.enforce(ctx, policies, policy.Subject{Repository: row.repo}, func(err error) { fail(line, "", err) }) {
+			continue
+		}
+
+		valid := true
+		targetProvider := get("target_provider")
+		if targetProvider == "" {
+			targetProvider = req.SourceProvider
+		}
+		for _, remote := range strings.FieldsFunc(get("target_urls"), func(c rune) bool { return c == ';' || c == ' ' || c == '\n' }) {
+			treq := models.CreateTargetRequest{Provider: targetProvider, RemoteURL: remote, Credential: get("target_credential")}
+			tsecret, err := h.Targets.validateTargetRequest(&treq, req.SourceURL)
+			if err != nil {
+				fail(line, "target_urls", fmt.Errorf("%s: %w", remote, err))
+				valid = false
+				continue
+			}
+			if slices.ContainsFunc(row.repo.Targets, func(t models.Target) bool { return t.RemoteURL == treq.RemoteURL }) {
+				fail(line, "target_urls", fmt.Errorf("%s is listed twice", remote))
+				valid = false
+				continue
+			}
+			var targetCredentialID *string
+			if tsecret != "" && treq.Credential != "" {
+				fail(line, "target_credential", errEmbeddedCredential)
+				valid = false
+				continue
+			} else if tsecret == "" {
+				var ok, fine bool
+				if targetCredentialID, ok, fine = credential("target_credential", treq.Credential, treq.Provider); !fine {
+					return
+				} else if !ok {
+					valid = false
+					continue
+				}
+			}
+			target := h.Targets.newTarget(r, "", treq, targetCredentialID)
+			if p, ok := auth.FromContext(ctx); ok {
+				target.CreatedBy = &p.User
+			}
+			if !h.enforce(ctx, policies, policy.Subject{Repository: row.repo, Target: &target}, func(err error) { fail(line, "target_urls", err) }) {
+				valid = false
+				continue
+			}
+			if tsecret != "" {
+				row.secrets[len(row.repo.Targets)] = tsecret
+			}
+			row.repo.Targets = append(row.repo.Targets, target)
+		}
+		if skip := get("skip_default_targets"); valid && skip != "" {
+			if req.SkipDefaultTargets, err = strconv.ParseBool(skip); err != nil {
+				fail(line, "skip_default_targets", errors.New("must be true or false"))
+				valid = false
+			}
+		}
+		if valid && !req.SkipDefaultTargets {
+			err := h.Repos.Defaults.addTo(ctx, r, defaults, policies, &row.repo)
+			var invalid errDefaultTarget
+			if errors.As(err, &invalid) {
+				fail(line, "", err)
+				valid = false
+			} else if err != nil {
+				log.Printf("ERROR: failed to add default targets: %v", err)
+				http.Error(w, "failed to add default targets", http.StatusInternalServerError)
+				return
+			}
+		}
+		if valid && tmpl != nil {
+			adoptTemplate(&row.repo, tmpl)
+		}
+		if valid {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 && len(res.Errors) == 0 {
+		http.Error(w, "no repositories to import", http.StatusBadRequest)
+		return
+	}
+	if !h.checkExisting(w, r, rows, fail) {
+		return
+	}
+
+	for _, row := range rows {
+		res.Repositories++
+		res.Targets += len(row.repo.Targets)
+	}
+	if len(res.Errors) > 0 {
+		slices.SortStableFunc(res.Errors, func(a, b models.ImportRowError) int { return a.Line - b.Line })
+		writeBody(w, r, http.StatusUnprocessableEntity, res)
+		return
+	}
+	if validateOnly {
+		writeBody(w, r, http.StatusOK, res)
+		return
+	}
+	h.commit(w, r, rows, res)
+}
+
+// enforce checks s against policies, passing violations to fail
+func (h *ImportHandler) enforce(ctx context.Context, policies []models.Policy, s policy.Subject, fail func(error)) bool {
+	err := h.Repos.Policies.EnforceWith(ctx, policy.StageCreate, policies, s)
+	if err != nil {
+		fail(err)
+		return false
+	}
+	return true
+}
+
+// checkExisting reports rows whose source or external ID a repository
+// holds already. It writes an error and returns false when they cannot be
+// checked.
+func (h *ImportHandler) checkExisting(w http.ResponseWriter, r *http.Request, rows []csvRow, fail func(int, string, error)) bool {
+	lines := map[string]int{}
+	externalLines := map[string]int{}
+	var urls, externalIDs []string
+	for _, row := range rows {
+		lines[row.canonical] = row.line
+		urls = append(urls, row.canonical)
+		if row.repo.ExternalID != nil {
+			externalLines[*row.repo.ExternalID] = row.line
+			externalIDs = append(externalIDs, *row.repo.ExternalID)
+		}
+	}
+	existing, err := h.Repos.DB.QueryContext(r.Context(),
+		`SELECT canonical_url, source_url, external_id FROM repositories WHERE canonical_url = ANY($1) OR external_id = ANY($2)`,
+		pq.Array(urls), pq.Array(externalIDs))
+	if err != nil {
+		log.Printf("ERROR: failed to check if repositories exist: %v", err)
+		http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
+		return false
+	}
+	defer existing.Close()
+	for existing.Next() {
+		var canonical, sourceURL string
+		var externalID *string
+		if err := existing.Scan(&canonical, &sourceURL, &externalID); err != nil {
+			log.Printf("ERROR: failed to scan repository: %v", err)
+			http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
+			return false
+		}
+		if line, ok := lines[canonical]; ok {
+			fail(line, "source_url", errors.New("repository with this source_url already exists: "+sourceURL))
+		}
+		if externalID != nil {
+			if line, ok := externalLines[*externalID]; ok {
+				fail(line, "external_id", errors.New("repository with this external_id already exists"))
+			}
+		}
+	}
+	return true
+}
+
+// commit stores the credentials embedded in the URLs of rows and creates
+// their repositories and targets in one transaction
+func (h *ImportHandler) commit(w http.ResponseWriter, r *http.Request, rows []csvRow, res models.CSVImportResult) {
+	ctx := r.Context()
+	repos := make([]models.Repository, 0, len(rows))
+	for _, row := range rows {
+		repo := row.repo
+		var err error
+		if row.secret != "" {
+			repo.CredentialID, err = storeURLCredential(r, h.Repos.DB, h.Repos.Credentials, row.secret, repo.SourceProvider, repo.SourceURL)
+		}
+		for i, secret := range row.secrets {
+			if err == nil {
+				t := &repo.Targets[i]
+				t.CredentialID, err = storeURLCredential(r, h.Repos.DB, h.Repos.Credentials, secret, t.Provider, t.RemoteURL)
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to store embedded credential", http.StatusInternalServerError)
+			return
+		}
+		repo.ID = h.Repos.IDs.NewID()
+		for i := range repo.Targets {
+			repo.Targets[i].ID = h.Repos.IDs.NewID()
+			repo.Targets[i].RepositoryID = repo.ID
+		}
+		repos = append(repos, repo)
+	}
+
+	var targets []models.Target
+	for _, repo := range repos {
+		targets = append(targets, repo.Targets...)
+	}
+	err := h.Repos.insertRepositories(ctx, repos, func(tx *sql.Tx) error {
+		return insertTargets(ctx, tx, targets)
+	})
+	if isUniqueViolation(err) {
+		http.Error(w, "a repository with one of these source_urls or external_ids already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to import repositories: %v", err)
+		http.Error(w, "failed to import repositories", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Imported %d repositories with %d targets from CSV", res.Repositories, res.Targets)
+
+	h.Repos.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.Repos.DB, "repository.import", "repository", "",
+		map[string]any{"count": res.Repositories, "targets": res.Targets, "format": "csv"})
+
+	ensureDeployKeys(h.Targets.DeployKeys, targets...)
+	if h.Repos.Webhooks.Enabled() {
+		go func(repos []models.Repository) {
+			for _, repo := range repos {
+				if err := h.Repos.Webhooks.Ensure(context.Background(), repo); err != nil {
+					log.Printf("WARN: failed to install webhook for repository %s: %v", repo.ID, err)
+				}
+			}
+		}(repos)
+	}
+	res.Created = repos
+	writeBody(w, r, http.StatusCreated, res)
+}
+
+// csvUpload returns the CSV uploaded with r: the file field of a
+// multipart form or else the body
+func csvUpload(r *http.Request) (io.ReadCloser, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("multipart upload needs a file field: %w", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// InboxHandler serves the per-user in-app notification inbox
+type InboxHandler struct {
+	DB *database.DB
+}
+
+// NewInboxHandler creates a new InboxHandler
+func NewInboxHandler(db *database.DB) *InboxHandler {
+	return &InboxHandler{DB: db}
+}
+
+// readExpr evaluates to whether notification n has been read by user $1
+const readExpr = `(r.notification_id IS NOT NULL OR n.created_at <= COALESCE(m.read_before, 'epoch'))`
+
+const inboxJoins = `
+	 FROM notifications n
+	 LEFT JOIN notification_reads r ON r.notification_id = n.id AND r.user_name = $1
+	 LEFT JOIN notification_read_marks m ON m.user_name = $1`
+
+// principal returns the caller, writing a 401 when the request is anonymous
+func principal(w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return nil, false
+	}
+	return p, true
+}
+
+// ListNotifications handles GET /notifications
+func (h *InboxHandler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	p, ok := principal(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 200)
+	}
+	query := `SELECT n.id, n.event_type, n.severity, n.title, n.message, n.repository_id, n.target_id, ` + readExpr + `, n.created_at` +
+		inboxJoins
+	if r.URL.Query().Get("unread") == "true" {
+		query += ` WHERE NOT ` + readExpr
+	}
+	query += ` ORDER BY n.created_at DESC LIMIT $2`
+
+	rows, err := h.DB.QueryContext(r.Context(), query, p.User, limit)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch notifications: %v", err)
+		http.Error(w, "failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.EventType, &n.Severity, &n.Title, &n.Message, &n.RepositoryID, &n.TargetID,
+			&n.Read, &n.CreatedAt); err != nil {
+			http.Error(w, "failed to scan notification", http.StatusInternalServerError)
+			return
+		}
+		notifications = append(notifications, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// GetUnreadCount handles GET /notifications/unread-count
+func (h *InboxHandler) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	p, ok := principal(w, r)
+	if !ok {
+		return
+	}
+
+	var count int
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*)`+inboxJoins+` WHERE NOT `+readExpr, p.User).Scan(&count); err != nil {
+		log.Printf("ERROR: failed to count notifications: %v", err)
+		http.Error(w, "failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unread": count})
+}
+
+// MarkNotificationRead handles POST /notifications/{id}/read
+func (h *InboxHandler) MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	p, ok := principal(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var id string
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT id FROM notifications WHERE id = $1`, mux.Vars(r)["id"]).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch notification: %v", err)
+		http.Error(w, "failed to fetch notification", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(ctx,
+		`INSERT INTO notification_reads (notification_id, user_name) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		id, p.User); err != nil {
+		log.Printf("ERROR: failed to mark notification read: %v", err)
+		http.Error(w, "failed to mark notification read", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MarkAllNotificationsRead handles POST /notifications/read-all
+func (h *InboxHandler) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	p, ok := principal(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := h.DB.ExecContext(ctx,
+		`INSERT INTO notification_read_marks (user_name, read_before) VALUES ($1, NOW())
+		 ON CONFLICT (user_name) DO UPDATE SET read_before = EXCLUDED.read_before`, p.User); err != nil {
+		log.Printf("ERROR: failed to mark notifications read: %v", err)
+		http.Error(w, "failed to mark notifications read", http.StatusInternalServerError)
+		return
+	}
+	// Individual marks older than the new watermark are now redundant
+	if _, err := h.DB.ExecContext(ctx,
+		`DELETE FROM notification_reads r USING notifications n
+		 WHERE r.notification_id = n.id AND r.user_name = $1
+		   AND n.created_at <= (SELECT read_before FROM notification_read_marks WHERE user_name = $1)`, p.User); err != nil {
+		log.Printf("WARN: failed to prune notification reads: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
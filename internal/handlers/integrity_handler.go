@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+)
+
+// IntegrityHandler serves the corruption findings of mirror verification
+type IntegrityHandler struct {
+	DB *database.DB
+}
+
+// NewIntegrityHandler creates a new IntegrityHandler
+func NewIntegrityHandler(db *database.DB) *IntegrityHandler {
+	return &IntegrityHandler{DB: db}
+}
+
+// ListIntegrityFindings handles GET /integrity-findings, optionally filtered
+// by repository_id and kind
+func (h *IntegrityHandler) ListIntegrityFindings(w http.ResponseWriter, r *http.Request) {
+	var conds []string
+	var args []any
+	for _, column := range []string{"repository_id", "kind"} {
+		if v := r.URL.Query().Get(column); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT id, repository_id, target_id, execution_id, kind, detail, created_at
+		 FROM integrity_findings `+where+` ORDER BY created_at DESC LIMIT 200`, args...)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to fetch integrity findings", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	findings := []models.IntegrityFinding{}
+	for rows.Next() {
+		var f models.IntegrityFinding
+		if err := rows.Scan(&f.ID, &f.RepositoryID, &f.TargetID, &f.ExecutionID, &f.Kind, &f.Detail, &f.CreatedAt); err != nil {
+			http.Error(w, "failed to scan integrity finding", http.StatusInternalServerError)
+			return
+		}
+		findings = append(findings, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(findings)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"gitsync/internal/maintenance"
+	"gitsync/internal/replication"
+
+	"github.com/gorilla/mux"
+)
+
+// MaintenanceHandler repacks cached mirrors on demand
+type MaintenanceHandler struct {
+	Maintenance *maintenance.Job
+}
+
+// NewMaintenanceHandler creates a new MaintenanceHandler
+func NewMaintenanceHandler(job *maintenance.Job) *MaintenanceHandler {
+	return &MaintenanceHandler{Maintenance: job}
+}
+
+// MaintainRepository handles POST /repositories/{id}/maintenance. It
+// repacks the cached mirror of the repository and responds once done.
+// Admin only.
+func (h *MaintenanceHandler) MaintainRepository(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	res, err := h.Maintenance.Repository(r.Context(), id)
+	if errors.Is(err, maintenance.ErrNotCached) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, replication.ErrBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: maintenance of repository %s failed: %v", id, err)
+		http.Error(w, "maintenance failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Repacked mirror of repository %s: %d loose objects in %d packs to %d in %d",
+		id, res.Before.LooseObjects, res.Before.Packs, res.After.LooseObjects, res.After.Packs)
+	writeBody(w, r, http.StatusOK, res)
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"gitsync/internal/cache"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/webhooks"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// MergeHandler merges repositories that turned out to mirror the same
+// upstream, as when URL normalization was tightened after both were added
+type MergeHandler struct {
+	DB       *database.DB
+	Webhooks *webhooks.Manager
+	// Runner keeps both repositories from syncing while they are merged
+	Runner *replication.Runner
+	Cache  *cache.Cache
+}
+
+// NewMergeHandler creates a new MergeHandler
+func NewMergeHandler(db *database.DB, hooks *webhooks.Manager, runner *replication.Runner, c *cache.Cache) *MergeHandler {
+	return &MergeHandler{DB: db, Webhooks: hooks, Runner: runner, Cache: c}
+}
+
+// errMerge is returned for merges that are refused; its message is meant
+// for a 409 response
+type errMerge string
+
+func (e errMerge) Error() string { return string(e) }
+
+// MergeRepository handles POST /repositories/{id}/merge. The targets and
+// history of the duplicate move to the repository, which keeps its own
+// settings, and the duplicate is deleted. Admin only.
+func (h *MergeHandler) MergeRepository(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req models.MergeRepositoryRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if req.DuplicateID == "" || req.DuplicateID == id {
+		http.Error(w, "duplicate_id must name another repository", http.StatusBadRequest)
+		return
+	}
+
+	release, ok := h.Runner.Hold(id, req.DuplicateID)
+	if !ok {
+		http.Error(w, replication.ErrBusy.Error(), http.StatusConflict)
+		return
+	}
+	defer release()
+
+	res, kept, err := h.merge(r.Context(), id, req.DuplicateID)
+	var refused errMerge
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err):
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	case errors.As(err, &refused):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("ERROR: failed to merge repository %s into %s: %v", req.DuplicateID, id, err)
+		http.Error(w, "failed to merge repositories", http.StatusInternalServerError)
+		return
+	}
+
+	h.Cache.Invalidate(r.Context(), cache.Repositories)
+	log.Printf("Merged repository %s into %s: %d targets moved, %d merged, %d executions",
+		res.DuplicateID, res.RepositoryID, len(res.MovedTargets), len(res.MergedTargets), res.Executions)
+	recordAudit(r, h.DB, "repository.merge", "repository", id, map[string]any{
+		"duplicate_id": res.DuplicateID, "moved_targets": res.MovedTargets, "merged_targets": res.MergedTargets,
+		"executions": res.Executions,
+	})
+
+	// Both records had a webhook on the same upstream, which the provider
+	// delivers to one receiver; re-apply it with the secret that is kept
+	if h.Webhooks.Enabled() {
+		go func(repo models.Repository) {
+			if err := h.Webhooks.Ensure(context.Background(), repo); err != nil {
+				log.Printf("WARN: failed to install webhook for repository %s: %v", repo.ID, err)
+			}
+		}(kept)
+	}
+	writeBody(w, r, http.StatusOK, res)
+}
+
+// mergeSource is what a merge reads of each repository
+type mergeSource struct {
+	models.Repository
+	labels    string
+	managedBy *string
+}
+
+// merge moves the targets and history of the repository dupID to the
+// repository id in one transaction and deletes it. It returns sql.ErrNoRows
+// when either does not exist and errMerge when they may not be merged.
+func (h *MergeHandler) merge(ctx context.Context, id, dupID string) (models.RepositoryMerge, models.Repository, error) {
+	res := models.RepositoryMerge{RepositoryID: id, DuplicateID: dupID, MovedTargets: []string{}, MergedTargets: []string{}}
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return res, models.Repository{}, err
+	}
+	defer tx.Rollback()
+
+	repos := map[string]*mergeSource{}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, alternate_source_urls, credential_id, labels, managed_by, created_at
+		 FROM repositories WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, id, dupID)
+	if err != nil {
+		return res, models.Repository{}, err
+	}
+	for rows.Next() {
+		var s mergeSource
+		if err := rows.Scan(&s.ID, &s.Name, &s.SourceProvider, &s.SourceURL, pq.Array(&s.AlternateSourceURLs),
+			&s.CredentialID, &s.labels, &s.managedBy, &s.CreatedAt); err != nil {
+			rows.Close()
+			return res, models.Repository{}, err
+		}
+		repos[s.ID] = &s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, models.Repository{}, err
+	}
+	kept, dup := repos[id], repos[dupID]
+	if kept == nil || dup == nil {
+		return res, models.Repository{}, sql.ErrNoRows
+	}
+	if dup.managedBy != nil {
+		return res, kept.Repository, errMerge("duplicate repository is managed by " + *dup.managedBy + "; remove it from its state instead")
+	}
+	if a, b := upstream(kept.Repository), upstream(dup.Repository); kept.SourceProvider != dup.SourceProvider || a != b {
+		return res, kept.Repository, errMerge(fmt.Sprintf("repositories mirror different upstreams: %s %s and %s %s",
+			kept.SourceProvider, a, dup.SourceProvider, b))
+	}
+
+	// Targets of the duplicate replicating to a remote the repository
+	// replicates to as well are folded into the target of the repository
+	pairs := map[string]string{}
+	rows, err = tx.QueryContext(ctx,
+		`SELECT d.id, k.id FROM replication_targets d
+		 JOIN replication_targets k ON k.repository_id = $1 AND k.remote_url = d.remote_url
+		 WHERE d.repository_id = $2`, id, dupID)
+	if err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to match targets: %w", err)
+	}
+	for rows.Next() {
+		var from, into string
+		if err := rows.Scan(&from, &into); err != nil {
+			rows.Close()
+			return res, kept.Repository, err
+		}
+		pairs[from] = into
+	}
+	rows.Close()
+	for from, into := range pairs {
+		for _, table := range []string{"executions", "integrity_findings", "secret_findings", "notifications", "policy_violations"} {
+			if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET target_id = $1 WHERE target_id = $2`, into, from); err != nil {
+				return res, kept.Repository, fmt.Errorf("failed to move %s of target %s: %w", table, from, err)
+			}
+		}
+		// Its pushed refs, identity mappings, approvals and open SLO
+		// breaches are superseded by those of the target kept and are
+		// deleted with it
+		if _, err := tx.ExecContext(ctx, `DELETE FROM replication_targets WHERE id = $1`, from); err != nil {
+			return res, kept.Repository, fmt.Errorf("failed to delete target %s: %w", from, err)
+		}
+		res.MergedTargets = append(res.MergedTargets, from)
+	}
+	slices.Sort(res.MergedTargets)
+
+	rows, err = tx.QueryContext(ctx,
+		`UPDATE replication_targets SET repository_id = $1 WHERE repository_id = $2 RETURNING id`, id, dupID)
+	if err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to move targets: %w", err)
+	}
+	for rows.Next() {
+		var target string
+		if err := rows.Scan(&target); err != nil {
+			rows.Close()
+			return res, kept.Repository, err
+		}
+		res.MovedTargets = append(res.MovedTargets, target)
+	}
+	rows.Close()
+
+	moved, err := tx.ExecContext(ctx, `UPDATE executions SET repository_id = $1 WHERE repository_id = $2`, id, dupID)
+	if err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to move executions: %w", err)
+	}
+	res.Executions, _ = moved.RowsAffected()
+	// A sync of the duplicate still queued would sync a repository gone
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sync_runs WHERE repository_id = $1 AND status = $2`, dupID, models.SyncRunQueued); err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to drop queued syncs: %w", err)
+	}
+	for _, table := range []string{"slo_breaches", "notifications", "policy_violations", "integrity_findings", "push_approvals", "anomalies", "backup_snapshots", "consistency_results", "sync_runs"} {
+		if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET repository_id = $1 WHERE repository_id = $2`, id, dupID); err != nil {
+			return res, kept.Repository, fmt.Errorf("failed to move %s: %w", table, err)
+		}
+	}
+	// The daily sync statistics of both repositories add up
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO sync_stats_daily (day, repository_id, syncs, succeeded, failed, bytes_pushed, duration_ms)
+		 SELECT day, $1, syncs, succeeded, failed, bytes_pushed, duration_ms FROM sync_stats_daily WHERE repository_id = $2
+		 ON CONFLICT (day, repository_id) DO UPDATE SET
+		     syncs = sync_stats_daily.syncs + EXCLUDED.syncs,
+		     succeeded = sync_stats_daily.succeeded + EXCLUDED.succeeded,
+		     failed = sync_stats_daily.failed + EXCLUDED.failed,
+		     bytes_pushed = sync_stats_daily.bytes_pushed + EXCLUDED.bytes_pushed,
+		     duration_ms = sync_stats_daily.duration_ms + EXCLUDED.duration_ms`, id, dupID); err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to merge sync statistics: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sync_stats_daily WHERE repository_id = $1`, dupID); err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to merge sync statistics: %w", err)
+	}
+	// Rows the repository holds already are deleted with the duplicate
+	for _, stmt := range []string{
+		`UPDATE secret_findings f SET repository_id = $1 WHERE repository_id = $2
+		 AND NOT EXISTS (SELECT 1 FROM secret_findings WHERE repository_id = $1 AND fingerprint = f.fingerprint)`,
+		`UPDATE sync_group_members m SET repository_id = $1 WHERE repository_id = $2
+		 AND NOT EXISTS (SELECT 1 FROM sync_group_members WHERE repository_id = $1 AND group_id = m.group_id)`,
+		`UPDATE repository_webhooks SET repository_id = $1 WHERE repository_id = $2
+		 AND NOT EXISTS (SELECT 1 FROM repository_webhooks WHERE repository_id = $1)`,
+		`UPDATE notification_channels
+		 SET repository_ids = ARRAY(SELECT DISTINCT unnest(array_replace(repository_ids, $2::uuid, $1::uuid)))
+		 WHERE $2::uuid = ANY(repository_ids)`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, id, dupID); err != nil {
+			return res, kept.Repository, fmt.Errorf("failed to move rows of repository: %w", err)
+		}
+	}
+
+	// The duplicate goes before its external ID and alternate sources are
+	// taken over, which are unique
+	var externalID, owner, team *string
+	if err := tx.QueryRowContext(ctx,
+		`DELETE FROM repositories WHERE id = $1 RETURNING external_id, owner, team`, dupID).Scan(&externalID, &owner, &team); err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to delete repository %s: %w", dupID, err)
+	}
+	alternates := slices.Clone(kept.AlternateSourceURLs)
+	for _, u := range dup.AlternateSourceURLs {
+		if u != kept.SourceURL && !slices.Contains(alternates, u) {
+			alternates = append(alternates, u)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE repositories
+		 SET alternate_source_urls = $2, labels = $3::jsonb || labels, external_id = COALESCE(external_id, $4),
+		     owner = COALESCE(owner, $5), team = COALESCE(team, $6)
+		 WHERE id = $1`,
+		id, pq.Array(alternates), dup.labels, externalID, owner, team); err != nil {
+		return res, kept.Repository, fmt.Errorf("failed to update repository %s: %w", id, err)
+	}
+	return res, kept.Repository, tx.Commit()
+}
+
+// upstream returns the canonical URL of the source of repo under the
+// current normalization rules, which may be stricter than those it was
+// added under
+func upstream(repo models.Repository) string {
+	url := repo.SourceURL
+	if normalized, err := provider.NormalizeURL(repo.SourceProvider, url); err == nil {
+		url = normalized
+	}
+	if canonical := canonicalURL(url); canonical != "" {
+		return canonical
+	}
+	return url
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// isYAML reports whether mediaType names YAML
+func isYAML(mediaType string) bool {
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// sendsYAML reports whether the body of r is YAML by its Content-Type
+func sendsYAML(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return isYAML(mediaType)
+}
+
+// acceptsYAML reports whether the Accept header of r prefers YAML over
+// JSON. Ties go to the type listed first.
+func acceptsYAML(r *http.Request) bool {
+	var yamlQ, jsonQ float64
+	yamlFirst := false
+	for _, entry := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case isYAML(mediaType):
+			yamlQ = max(yamlQ, q)
+			yamlFirst = yamlFirst || jsonQ == 0
+		case mediaType == "application/json" || mediaType == "application/*" || mediaType == "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return yamlQ > jsonQ || (yamlQ > 0 && yamlQ == jsonQ && yamlFirst)
+}
+
+// decodeBody decodes the body of r into v like decodeJSON, reading it as
+// YAML when its Content-Type says so. YAML documents are converted to JSON
+// first, so they use the JSON field names and the same strict checks.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if !sendsYAML(r) {
+		return decodeJSON(w, r, v)
+	}
+	data, err := io.ReadAll(r.Body)
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		http.Error(w, fmt.Sprintf("request body exceeds the limit of %d bytes", maxBytes.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	} else if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return false
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		http.Error(w, "invalid request body: "+strings.TrimPrefix(err.Error(), "yaml: "), http.StatusBadRequest)
+		return false
+	}
+	if doc == nil {
+		http.Error(w, "invalid request body: body is empty", http.StatusBadRequest)
+		return false
+	}
+	if doc, err = jsonValue(doc); err == nil {
+		data, err = json.Marshal(doc)
+	}
+	if err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return decodeJSON(w, r, v)
+}
+
+// jsonValue converts a decoded YAML value to one encoding/json can
+// marshal, whose mappings must have string keys
+func jsonValue(v any) (any, error) {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, elem := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("mapping key %v is not a string", k)
+			}
+			var err error
+			if m[key], err = jsonValue(elem); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []any:
+		for i, elem := range v {
+			var err error
+			if v[i], err = jsonValue(elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// writeBody writes v with status, as YAML when the Accept header of r
+// prefers it and as JSON otherwise. YAML is rendered from the JSON
+// encoding, keeping its field names and order.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, v any) {
+	if !acceptsYAML(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		var doc any
+		if doc, err = yamlValue(json.NewDecoder(bytes.NewReader(data))); err == nil {
+			data, err = yaml.Marshal(doc)
+		}
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to encode YAML response: %v", err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// yamlValue reads the next JSON value from dec as a value yaml.Marshal
+// renders in the same order, with objects as yaml.MapSlice
+func yamlValue(dec *json.Decoder) (any, error) {
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			list := []any{}
+			for dec.More() {
+				elem, err := yamlValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, elem)
+			}
+			_, err := dec.Token()
+			return list, err
+		}
+		m := yaml.MapSlice{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			elem, err := yamlValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, yaml.MapItem{Key: key, Value: elem})
+		}
+		_, err := dec.Token()
+		return m, err
+	case json.Number:
+		if n, err := tok.Int64(); err == nil {
+			return n, nil
+		}
+		return tok.Float64()
+	default:
+		return tok, nil
+	}
+}
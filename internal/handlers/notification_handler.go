@@ -0,0 +1,541 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/labels"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// NotificationHandler handles notification channel HTTP requests
+type NotificationHandler struct {
+	DB       *database.DB
+	Notifier *notify.Dispatcher
+	Clock    clock.Clock
+	IDs      ids.Generator
+}
+
+// NewNotificationHandler creates a new NotificationHandler
+func NewNotificationHandler(db *database.DB, notifier *notify.Dispatcher, clk clock.Clock, gen ids.Generator) *NotificationHandler {
+	return &NotificationHandler{DB: db, Notifier: notifier, Clock: clk, IDs: gen}
+}
+
+// channelFromRequest builds and validates a channel from its request body
+func channelFromRequest(req models.CreateNotificationChannelRequest) (models.NotificationChannel, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return models.NotificationChannel{}, errors.New("name is required")
+	}
+	if req.Type == "" {
+		req.Type = "template"
+	}
+	formatter, err := notify.LookupFormatter(req.Type)
+	if err != nil {
+		return models.NotificationChannel{}, fmt.Errorf("invalid type: %w", err)
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return models.NotificationChannel{}, errors.New("url must be an absolute http:// or https:// url")
+	}
+
+	ch := models.NotificationChannel{
+		Name:        req.Name,
+		Type:        req.Type,
+		URL:         req.URL,
+		ContentType: req.ContentType,
+		Events:      req.Events,
+		Enabled:     true,
+		Secret:      req.Secret,
+	}
+	if ch.ContentType == "" {
+		ch.ContentType = "application/json"
+	}
+	if ch.Events == nil && notify.IsIncidentChannel(ch.Type) {
+		// Incident channels only open and resolve incidents for SLO breaches
+		// unless told otherwise
+		ch.Events = notify.IncidentEvents
+	}
+	if ch.Events == nil {
+		ch.Events = []string{}
+	}
+	ch.RepositoryIDs = req.RepositoryIDs
+	if ch.RepositoryIDs == nil {
+		ch.RepositoryIDs = []string{}
+	}
+	if req.LabelSelector != "" {
+		if _, err := labels.ParseSelector(req.LabelSelector); err != nil {
+			return ch, fmt.Errorf("invalid label_selector: %w", err)
+		}
+		ch.LabelSelector = &req.LabelSelector
+	}
+	if req.Template != "" {
+		ch.Template = &req.Template
+	}
+	if externalID := strings.TrimSpace(req.ExternalID); externalID != "" {
+		ch.ExternalID = &externalID
+	}
+	if err := formatter.Validate(ch); err != nil {
+		return ch, fmt.Errorf("invalid channel: %w", err)
+	}
+	return ch, nil
+}
+
+// CreateNotificationChannel handles POST /notification-channels
+func (h *NotificationHandler) CreateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateNotificationChannelRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	ch, err := channelFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM notification_channels WHERE name = $1)", ch.Name).Scan(&exists); err != nil {
+		log.Printf("ERROR: failed to check if notification channel exists: %v", err)
+		http.Error(w, "failed to check channel existence", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "channel with this name already exists", http.StatusConflict)
+		return
+	}
+
+	ch.ID, ch.CreatedAt = h.IDs.NewID(), h.Clock.Now()
+	_, err = h.DB.ExecContext(ctx,
+		`INSERT INTO notification_channels (id, name, type, url, template, content_type, events, repository_ids, label_selector, enabled, secret, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		ch.ID, ch.Name, ch.Type, ch.URL, ch.Template, ch.ContentType, pq.Array(ch.Events), pq.Array(ch.RepositoryIDs),
+		ch.LabelSelector, ch.Enabled, ch.Secret, ch.ExternalID, ch.CreatedAt)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_ids must be valid UUIDs", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "channel with this name or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to insert notification channel: %v", err)
+		http.Error(w, "failed to create channel", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "notification_channel.create", "notification_channel", ch.ID,
+		map[string]any{"name": ch.Name, "type": ch.Type})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ch)
+}
+
+// GetNotificationChannel handles GET /notification-channels/{id}
+func (h *NotificationHandler) GetNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	ch, ok := h.channel(r.Context(), w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ch)
+}
+
+// PutNotificationChannel handles PUT /notification-channels/{id}. It
+// creates the channel with the given ID or replaces every field of an
+// existing one; its delivery log is kept.
+func (h *NotificationHandler) PutNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateNotificationChannelRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	ch, err := channelFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ch.ID = mux.Vars(r)["id"]
+	var created bool
+	err = h.DB.QueryRowContext(r.Context(),
+		`INSERT INTO notification_channels (id, name, type, url, template, content_type, events, repository_ids, label_selector, enabled, secret, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		 ON CONFLICT (id) DO UPDATE
+		 SET name = EXCLUDED.name, type = EXCLUDED.type, url = EXCLUDED.url, template = EXCLUDED.template,
+		     content_type = EXCLUDED.content_type, events = EXCLUDED.events, repository_ids = EXCLUDED.repository_ids,
+		     label_selector = EXCLUDED.label_selector, secret = EXCLUDED.secret, external_id = EXCLUDED.external_id
+		 RETURNING enabled, created_at, xmax = 0`,
+		ch.ID, ch.Name, ch.Type, ch.URL, ch.Template, ch.ContentType, pq.Array(ch.Events), pq.Array(ch.RepositoryIDs),
+		ch.LabelSelector, ch.Enabled, ch.Secret, ch.ExternalID, h.Clock.Now()).
+		Scan(&ch.Enabled, &ch.CreatedAt, &created)
+	if isInvalidUUID(err) {
+		http.Error(w, "channel or repository id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "channel with this name or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to upsert notification channel: %v", err)
+		http.Error(w, "failed to save channel", http.StatusInternalServerError)
+		return
+	}
+	action, status := "notification_channel.update", http.StatusOK
+	if created {
+		action, status = "notification_channel.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "notification_channel", ch.ID, map[string]any{"name": ch.Name, "type": ch.Type})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ch)
+}
+
+// ListNotificationChannels handles GET /notification-channels
+func (h *NotificationHandler) ListNotificationChannels(w http.ResponseWriter, r *http.Request) {
+	where, args := "", []any{}
+	if externalID := r.URL.Query().Get("external_id"); externalID != "" {
+		where, args = "WHERE external_id = $1", append(args, externalID)
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+notify.ChannelColumns+` FROM notification_channels `+where+` ORDER BY name`, args...)
+	if err != nil {
+		http.Error(w, "failed to fetch channels", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	channels := []models.NotificationChannel{}
+	for rows.Next() {
+		ch, err := notify.ScanChannel(rows)
+		if err != nil {
+			http.Error(w, "failed to scan channel", http.StatusInternalServerError)
+			return
+		}
+		channels = append(channels, ch)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// DeleteNotificationChannel handles DELETE /notification-channels/{id}
+func (h *NotificationHandler) DeleteNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM notification_channels WHERE id = $1`, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "notification_channel.delete", "notification_channel", mux.Vars(r)["id"], nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListNotificationDeliveries handles GET /notification-channels/{id}/deliveries
+func (h *NotificationHandler) ListNotificationDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := h.channel(ctx, w, mux.Vars(r)["id"]); !ok {
+		return
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, channel_id, event_type, status, attempts, response_code, error, created_at, finished_at
+		 FROM notification_deliveries WHERE channel_id = $1 ORDER BY created_at DESC LIMIT 100`, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.NotificationDelivery{}
+	for rows.Next() {
+		var d models.NotificationDelivery
+		if err := rows.Scan(&d.ID, &d.ChannelID, &d.EventType, &d.Status, &d.Attempts, &d.ResponseCode,
+			&d.Error, &d.CreatedAt, &d.FinishedAt); err != nil {
+			http.Error(w, "failed to scan delivery", http.StatusInternalServerError)
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// TestNotificationChannel handles POST /notification-channels/{id}/test
+func (h *NotificationHandler) TestNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ch, ok := h.channel(ctx, w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	ev := notify.Event{
+		Type:     notify.EventTest,
+		Severity: notify.SeverityInfo,
+		Message:  "Test notification from GitSync",
+	}
+	if err := h.Notifier.Deliver(ctx, *ch, ev); err != nil {
+		http.Error(w, "delivery failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// channel fetches a channel by ID, writing the error response if needed
+func (h *NotificationHandler) channel(ctx context.Context, w http.ResponseWriter, id string) (*models.NotificationChannel, bool) {
+	ch, err := notify.ScanChannel(h.DB.QueryRowContext(ctx,
+		`SELECT `+notify.ChannelColumns+` FROM notification_channels WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch notification channel: %v", err)
+		http.Error(w, "failed to fetch channel", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &ch, true
+}
+
+// isInvalidUUID reports whether err is Postgres rejecting a malformed UUID,
+// which handlers treat as "not found"
+func isInvalidUUID(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "22P02"
+}
+
+// ruleFromRequest builds and validates a rule from its request body
+func ruleFromRequest(req models.NotificationRuleRequest) (models.NotificationRule, error) {
+	rule := models.NotificationRule{
+		Name:       strings.TrimSpace(req.Name),
+		Priority:   100,
+		EventTypes: req.EventTypes,
+		Providers:  req.Providers,
+		Timezone:   req.Timezone,
+		ChannelIDs: req.ChannelIDs,
+		Stop:       req.Stop,
+		Enabled:    true,
+	}
+	if rule.Name == "" {
+		return rule, errors.New("name is required")
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if rule.EventTypes == nil {
+		rule.EventTypes = []string{}
+	}
+	if rule.Providers == nil {
+		rule.Providers = []string{}
+	}
+	if rule.Timezone == "" {
+		rule.Timezone = "UTC"
+	}
+	if req.LabelSelector != "" {
+		rule.LabelSelector = &req.LabelSelector
+	}
+	if req.MinSeverity != "" {
+		rule.MinSeverity = &req.MinSeverity
+	}
+	if req.ActiveFrom != "" {
+		rule.ActiveFrom = &req.ActiveFrom
+	}
+	if req.ActiveTo != "" {
+		rule.ActiveTo = &req.ActiveTo
+	}
+	if externalID := strings.TrimSpace(req.ExternalID); externalID != "" {
+		rule.ExternalID = &externalID
+	}
+	return rule, notify.ValidateRule(rule)
+}
+
+// CreateNotificationRule handles POST /notification-rules
+func (h *NotificationHandler) CreateNotificationRule(w http.ResponseWriter, r *http.Request) {
+	var req models.NotificationRuleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	rule, err := ruleFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule.ID, rule.CreatedAt = h.IDs.NewID(), h.Clock.Now()
+	_, err = h.DB.ExecContext(r.Context(),
+		`INSERT INTO notification_rules (id, name, priority, event_types, label_selector, providers, min_severity,
+		     active_from, active_to, timezone, channel_ids, stop, enabled, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		rule.ID, rule.Name, rule.Priority, pq.Array(rule.EventTypes), rule.LabelSelector, pq.Array(rule.Providers), rule.MinSeverity,
+		rule.ActiveFrom, rule.ActiveTo, rule.Timezone, pq.Array(rule.ChannelIDs), rule.Stop, rule.Enabled, rule.ExternalID, rule.CreatedAt)
+	if isInvalidUUID(err) {
+		http.Error(w, "channel_ids must be valid UUIDs", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "rule with this name or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to insert notification rule: %v", err)
+		http.Error(w, "failed to create rule", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "notification_rule.create", "notification_rule", rule.ID, map[string]any{"name": rule.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// ListNotificationRules handles GET /notification-rules
+func (h *NotificationHandler) ListNotificationRules(w http.ResponseWriter, r *http.Request) {
+	where, args := "", []any{}
+	if externalID := r.URL.Query().Get("external_id"); externalID != "" {
+		where, args = "WHERE external_id = $1", append(args, externalID)
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+notify.RuleColumns+` FROM notification_rules `+where+` ORDER BY priority, name`, args...)
+	if err != nil {
+		http.Error(w, "failed to fetch rules", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rules := []models.NotificationRule{}
+	for rows.Next() {
+		rule, err := notify.ScanRule(rows)
+		if err != nil {
+			http.Error(w, "failed to scan rule", http.StatusInternalServerError)
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// GetNotificationRule handles GET /notification-rules/{id}
+func (h *NotificationHandler) GetNotificationRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := notify.ScanRule(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+notify.RuleColumns+` FROM notification_rules WHERE id = $1`, mux.Vars(r)["id"]))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch notification rule: %v", err)
+		http.Error(w, "failed to fetch rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// UpdateNotificationRule handles PUT /notification-rules/{id}. A rule that
+// does not exist yet is created with the given ID.
+func (h *NotificationHandler) UpdateNotificationRule(w http.ResponseWriter, r *http.Request) {
+	var req models.NotificationRuleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	rule, err := ruleFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule.ID = mux.Vars(r)["id"]
+	var created bool
+	err = h.DB.QueryRowContext(r.Context(),
+		`INSERT INTO notification_rules (id, name, priority, event_types, label_selector, providers, min_severity,
+		     active_from, active_to, timezone, channel_ids, stop, enabled, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		 ON CONFLICT (id) DO UPDATE
+		 SET name = EXCLUDED.name, priority = EXCLUDED.priority, event_types = EXCLUDED.event_types,
+		     label_selector = EXCLUDED.label_selector, providers = EXCLUDED.providers, min_severity = EXCLUDED.min_severity,
+		     active_from = EXCLUDED.active_from, active_to = EXCLUDED.active_to, timezone = EXCLUDED.timezone,
+		     channel_ids = EXCLUDED.channel_ids, stop = EXCLUDED.stop, enabled = EXCLUDED.enabled,
+		     external_id = EXCLUDED.external_id
+		 RETURNING created_at, xmax = 0`,
+		rule.ID, rule.Name, rule.Priority, pq.Array(rule.EventTypes), rule.LabelSelector, pq.Array(rule.Providers),
+		rule.MinSeverity, rule.ActiveFrom, rule.ActiveTo, rule.Timezone, pq.Array(rule.ChannelIDs), rule.Stop, rule.Enabled,
+		rule.ExternalID, h.Clock.Now()).
+		Scan(&rule.CreatedAt, &created)
+	if isInvalidUUID(err) {
+		http.Error(w, "rule or channel id is not a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "rule with this name or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update notification rule: %v", err)
+		http.Error(w, "failed to update rule", http.StatusInternalServerError)
+		return
+	}
+	action, status := "notification_rule.update", http.StatusOK
+	if created {
+		action, status = "notification_rule.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "notification_rule", rule.ID, map[string]any{"name": rule.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteNotificationRule handles DELETE /notification-rules/{id}
+func (h *NotificationHandler) DeleteNotificationRule(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM notification_rules WHERE id = $1`, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "rule not found", http.StatusNotFound)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "rule not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "notification_rule.delete", "notification_rule", mux.Vars(r)["id"], nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isForeignKeyViolation reports whether err is a Postgres foreign key
+// constraint error, such as a reference to a missing row
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
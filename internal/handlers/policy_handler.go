@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/policy"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// PolicyHandler handles policy HTTP requests
+type PolicyHandler struct {
+	DB    *database.DB
+	Clock clock.Clock
+	IDs   ids.Generator
+}
+
+// NewPolicyHandler creates a new PolicyHandler
+func NewPolicyHandler(db *database.DB, clk clock.Clock, gen ids.Generator) *PolicyHandler {
+	return &PolicyHandler{DB: db, Clock: clk, IDs: gen}
+}
+
+// policyFromRequest builds and validates a policy from its request body
+func policyFromRequest(req models.PolicyRequest) (models.Policy, error) {
+	p := models.Policy{
+		Name:      strings.TrimSpace(req.Name),
+		Kind:      req.Kind,
+		Domains:   req.Domains,
+		Providers: req.Providers,
+		Scope:     req.Scope,
+		Enabled:   true,
+	}
+	if p.Name == "" {
+		return p, errors.New("name is required")
+	}
+	if req.Enabled != nil {
+		p.Enabled = *req.Enabled
+	}
+	if p.Domains == nil {
+		p.Domains = []string{}
+	}
+	if p.Providers == nil {
+		p.Providers = []string{}
+	}
+	if p.Scope == "" {
+		p.Scope = policy.ScopeAll
+	}
+	if req.LabelSelector != "" {
+		p.LabelSelector = &req.LabelSelector
+	}
+	if externalID := strings.TrimSpace(req.ExternalID); externalID != "" {
+		p.ExternalID = &externalID
+	}
+	return p, policy.Validate(p)
+}
+
+// enforcePolicy evaluates the policies for s, writing a 403 response listing
+// the violations when s is blocked
+func enforcePolicy(ctx context.Context, w http.ResponseWriter, engine *policy.Engine, s policy.Subject) bool {
+	err := engine.Enforce(ctx, policy.StageCreate, s)
+	var violation *policy.ViolationError
+	if errors.As(err, &violation) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to evaluate policies: %v", err)
+		http.Error(w, "failed to evaluate policies", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// CreatePolicy handles POST /policies
+func (h *PolicyHandler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
+	var req models.PolicyRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	p, err := policyFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.ID, p.CreatedAt = h.IDs.NewID(), h.Clock.Now()
+	_, err = h.DB.ExecContext(r.Context(),
+		`INSERT INTO policies (id, name, kind, label_selector, domains, providers, scope, enabled, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		p.ID, p.Name, p.Kind, p.LabelSelector, pq.Array(p.Domains), pq.Array(p.Providers), p.Scope, p.Enabled,
+		p.ExternalID, p.CreatedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "policy with this name or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to insert policy: %v", err)
+		http.Error(w, "failed to create policy", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "policy.create", "policy", p.ID, map[string]any{"name": p.Name, "kind": p.Kind})
+
+	writeBody(w, r, http.StatusCreated, p)
+}
+
+// ListPolicies handles GET /policies
+func (h *PolicyHandler) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	where, args := "", []any{}
+	if externalID := r.URL.Query().Get("external_id"); externalID != "" {
+		where, args = "WHERE external_id = $1", append(args, externalID)
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+policy.Columns+` FROM policies `+where+` ORDER BY name`, args...)
+	if err != nil {
+		http.Error(w, "failed to fetch policies", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	policies := []models.Policy{}
+	for rows.Next() {
+		p, err := policy.Scan(rows)
+		if err != nil {
+			http.Error(w, "failed to scan policy", http.StatusInternalServerError)
+			return
+		}
+		policies = append(policies, p)
+	}
+
+	writeBody(w, r, http.StatusOK, policies)
+}
+
+// GetPolicy handles GET /policies/{id}
+func (h *PolicyHandler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	p, err := policy.Scan(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+policy.Columns+` FROM policies WHERE id = $1`, mux.Vars(r)["id"]))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "policy not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch policy: %v", err)
+		http.Error(w, "failed to fetch policy", http.StatusInternalServerError)
+		return
+	}
+
+	writeBody(w, r, http.StatusOK, p)
+}
+
+// UpdatePolicy handles PUT /policies/{id}. A policy that does not exist yet
+// is created with the given ID.
+func (h *PolicyHandler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	var req models.PolicyRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	p, err := policyFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.ID = mux.Vars(r)["id"]
+	var created bool
+	err = h.DB.QueryRowContext(r.Context(),
+		`INSERT INTO policies (id, name, kind, label_selector, domains, providers, scope, enabled, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 ON CONFLICT (id) DO UPDATE
+		 SET name = EXCLUDED.name, kind = EXCLUDED.kind, label_selector = EXCLUDED.label_selector,
+		     domains = EXCLUDED.domains, providers = EXCLUDED.providers, scope = EXCLUDED.scope,
+		     enabled = EXCLUDED.enabled, external_id = EXCLUDED.external_id
+		 RETURNING created_at, xmax = 0`,
+		p.ID, p.Name, p.Kind, p.LabelSelector, pq.Array(p.Domains), pq.Array(p.Providers), p.Scope, p.Enabled,
+		p.ExternalID, h.Clock.Now()).
+		Scan(&p.CreatedAt, &created)
+	if isInvalidUUID(err) {
+		http.Error(w, "id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "policy with this name or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update policy: %v", err)
+		http.Error(w, "failed to update policy", http.StatusInternalServerError)
+		return
+	}
+	action, status := "policy.update", http.StatusOK
+	if created {
+		action, status = "policy.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "policy", p.ID, map[string]any{"name": p.Name, "kind": p.Kind})
+
+	writeBody(w, r, status, p)
+}
+
+// DeletePolicy handles DELETE /policies/{id}
+func (h *PolicyHandler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM policies WHERE id = $1`, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "policy not found", http.StatusNotFound)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "policy not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "policy.delete", "policy", mux.Vars(r)["id"], nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListPolicyViolations handles GET /policy-violations
+func (h *PolicyHandler) ListPolicyViolations(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT id, policy_id, policy_name, stage, repository_id, target_id, url, message, created_at
+		 FROM policy_violations ORDER BY created_at DESC LIMIT 200`)
+	if err != nil {
+		http.Error(w, "failed to fetch policy violations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	violations := []models.PolicyViolation{}
+	for rows.Next() {
+		var v models.PolicyViolation
+		if err := rows.Scan(&v.ID, &v.PolicyID, &v.PolicyName, &v.Stage, &v.RepositoryID, &v.TargetID,
+			&v.URL, &v.Message, &v.CreatedAt); err != nil {
+			http.Error(w, "failed to scan policy violation", http.StatusInternalServerError)
+			return
+		}
+		violations = append(violations, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(violations)
+}
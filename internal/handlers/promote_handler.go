@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/deploykeys"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/policy"
+	"gitsync/internal/replication"
+	"gitsync/internal/trash"
+	"gitsync/internal/webhooks"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// PromoteHandler fails a repository over to one of its targets when the
+// upstream is lost, making the target its source
+type PromoteHandler struct {
+	DB         *database.DB
+	Webhooks   *webhooks.Manager
+	DeployKeys *deploykeys.Manager
+	Policies   *policy.Engine
+	// Runner keeps the repository from syncing while its source changes,
+	// and syncs it from the new source afterwards
+	Runner *replication.Runner
+	// Trash keeps the promoted target and its sync history
+	Trash *trash.Bin
+	Cache *cache.Cache
+	Clock clock.Clock
+	IDs   ids.Generator
+}
+
+// NewPromoteHandler creates a new PromoteHandler
+func NewPromoteHandler(db *database.DB, hooks *webhooks.Manager, keys *deploykeys.Manager, policies *policy.Engine, runner *replication.Runner,
+	bin *trash.Bin, c *cache.Cache, clk clock.Clock, gen ids.Generator) *PromoteHandler {
+	return &PromoteHandler{DB: db, Webhooks: hooks, DeployKeys: keys, Policies: policies, Runner: runner, Trash: bin, Cache: c, Clock: clk, IDs: gen}
+}
+
+// errPromote is returned for promotions that are refused; its message is
+// meant for a 409 response
+type errPromote string
+
+func (e errPromote) Error() string { return string(e) }
+
+// PromoteTarget handles POST /repositories/{id}/promote?target={tid}. The
+// target becomes the source of the repository, with its provider and
+// credential, and with demote=true the previous source becomes a target.
+// Admin only.
+func (h *PromoteHandler) PromoteTarget(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	targetID := r.URL.Query().Get("target")
+	if targetID == "" {
+		http.Error(w, "target is required", http.StatusBadRequest)
+		return
+	}
+	demote := false
+	if raw := r.URL.Query().Get("demote"); raw != "" {
+		var err error
+		if demote, err = strconv.ParseBool(raw); err != nil {
+			http.Error(w, "demote must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	release, ok := h.Runner.Hold(id)
+	if !ok {
+		http.Error(w, replication.ErrBusy.Error(), http.StatusConflict)
+		return
+	}
+	res, repo, err := h.promote(r, id, targetID, demote)
+	release()
+
+	var refused errPromote
+	var violation *policy.ViolationError
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err):
+		http.Error(w, "repository or target not found", http.StatusNotFound)
+		return
+	case errors.As(err, &refused):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.As(err, &violation):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case isUniqueViolation(err):
+		http.Error(w, "another repository mirrors the target, or a target of the repository replicates to its source", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("ERROR: failed to promote target %s of repository %s: %v", targetID, id, err)
+		http.Error(w, "failed to promote target", http.StatusInternalServerError)
+		return
+	}
+
+	h.Cache.Invalidate(r.Context(), cache.Repositories)
+	log.Printf("Promoted target %s of repository %s: source %s is now %s", targetID, id, res.PreviousSourceURL, res.SourceURL)
+	details := map[string]any{
+		"target_id": targetID, "source_url": res.SourceURL, "previous_source_url": res.PreviousSourceURL,
+	}
+	if res.DemotedTarget != nil {
+		details["demoted_target_id"] = res.DemotedTarget.ID
+	}
+	recordAudit(r, h.DB, "repository.promote", "repository", id, details)
+
+	// The webhook moves to the new source; the one on the previous source
+	// is left in place, as that source is usually unreachable
+	if h.Webhooks.Enabled() {
+		go func(repo models.Repository) {
+			if err := h.Webhooks.Ensure(context.Background(), repo); err != nil {
+				log.Printf("WARN: failed to install webhook for repository %s: %v", repo.ID, err)
+			}
+		}(repo)
+	}
+	if res.DemotedTarget != nil {
+		ensureDeployKeys(h.DeployKeys, *res.DemotedTarget)
+	}
+	pruneDeployKeys(h.DeployKeys)
+	err = h.Runner.Enqueue(r.Context(), id, replication.TriggerPromotion)
+	if err != nil && !errors.Is(err, replication.ErrBusy) {
+		log.Printf("ERROR: %v", err)
+	}
+	res.SyncQueued = err == nil
+	writeBody(w, r, http.StatusOK, res)
+}
+
+// promote swaps the target into the source role in one transaction. It
+// returns sql.ErrNoRows when the repository or target does not exist,
+// errPromote when the target may not become the source and the
+// repository with its new source.
+func (h *PromoteHandler) promote(r *http.Request, id, targetID string, demote bool) (models.RepositoryPromotion, models.Repository, error) {
+	ctx := r.Context()
+	var res models.RepositoryPromotion
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return res, models.Repository{}, err
+	}
+	defer tx.Rollback()
+
+	var repo models.Repository
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, name, source_provider, source_url, alternate_source_urls, credential_id, labels, owner, team, managed_by
+		 FROM repositories WHERE id = $1 FOR UPDATE`, id).
+		Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, pq.Array(&repo.AlternateSourceURLs),
+			&repo.CredentialID, &repo.Labels, &repo.Owner, &repo.Team, &repo.ManagedBy)
+	if err != nil {
+		return res, repo, err
+	}
+	var t models.Target
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, provider, remote_url, credential_id, mode, rewrite, approval_state
+		 FROM replication_targets WHERE id = $1 AND repository_id = $2 FOR UPDATE`, targetID, id).
+		Scan(&t.ID, &t.Provider, &t.RemoteURL, &t.CredentialID, &t.Mode, &t.Rewrite, &t.ApprovalState)
+	if err != nil {
+		return res, repo, err
+	}
+	switch {
+	case repo.ManagedBy != nil:
+		return res, repo, errPromote("repository is managed by " + *repo.ManagedBy + "; change the source in its state instead")
+	case t.ApprovalState != models.ApprovalApproved:
+		return res, repo, errPromote("target is pending approval")
+	case t.Mode != models.TargetModeAll || t.Rewrite != nil:
+		// Such targets hold part of the history only
+		return res, repo, errPromote("only targets replicating all refs without rewriting history can be promoted")
+	}
+	var existing string
+	err = tx.QueryRowContext(ctx,
+		`SELECT source_url FROM repositories WHERE canonical_url = $1 AND id <> $2`, canonicalURL(t.RemoteURL), id).Scan(&existing)
+	if err == nil {
+		return res, repo, errPromote("repository with this source_url already exists: " + existing)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return res, repo, fmt.Errorf("failed to check repository existence: %w", err)
+	}
+
+	previous := repo
+	res = models.RepositoryPromotion{
+		RepositoryID: id, PromotedTargetID: targetID, SourceProvider: t.Provider, SourceURL: t.RemoteURL,
+		PreviousSourceProvider: previous.SourceProvider, PreviousSourceURL: previous.SourceURL, DroppedAlternateSourceURLs: []string{},
+	}
+	// Alternate sources are fetched with the credential of the source, so
+	// those of another provider go
+	alternates := []string{}
+	for _, u := range repo.AlternateSourceURLs {
+		if t.Provider != previous.SourceProvider || canonicalURL(u) == canonicalURL(t.RemoteURL) {
+			res.DroppedAlternateSourceURLs = append(res.DroppedAlternateSourceURLs, u)
+			continue
+		}
+		alternates = append(alternates, u)
+	}
+	repo.SourceProvider, repo.SourceURL, repo.CredentialID, repo.AlternateSourceURLs = t.Provider, t.RemoteURL, t.CredentialID, alternates
+	repo.SourceState = models.SourceActive
+
+	subjects := []policy.Subject{{Repository: repo}}
+	if demote {
+		demoted := models.Target{
+			ID:            h.IDs.NewID(),
+			RepositoryID:  id,
+			Provider:      previous.SourceProvider,
+			RemoteURL:     previous.SourceURL,
+			CredentialID:  previous.CredentialID,
+			Priority:      models.DefaultTargetPriority,
+			Required:      true,
+			ExcludeRefs:   []string{},
+			Mode:          models.TargetModeAll,
+			ApprovalState: models.ApprovalApproved,
+			CreatedBy:     deletedBy(r),
+			CreatedAt:     h.Clock.Now(),
+		}
+		res.DemotedTarget = &demoted
+		subjects = append(subjects, policy.Subject{Repository: repo, Target: &demoted})
+	}
+	for _, s := range subjects {
+		if err := h.Policies.Enforce(ctx, policy.StageCreate, s); err != nil {
+			return res, repo, err
+		}
+	}
+
+	// The target goes first, as the source may not replicate to itself
+	if err := h.Trash.Discard(ctx, tx, trash.Target, targetID, deletedBy(r)); err != nil {
+		return res, repo, err
+	}
+	// The source is checked and its activity tracked afresh, so the
+	// adaptive schedule syncs it often until it settles
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE repositories
+		 SET source_provider = $2, source_url = $3, credential_id = $4, alternate_source_urls = $5, source_state = $6,
+		     source_checked_at = NULL, source_refs_hash = NULL, source_changed_at = $7
+		 WHERE id = $1`,
+		id, repo.SourceProvider, repo.SourceURL, repo.CredentialID, pq.Array(repo.AlternateSourceURLs), repo.SourceState,
+		h.Clock.Now()); err != nil {
+		return res, repo, fmt.Errorf("failed to update repository: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM repository_webhooks WHERE repository_id = $1`, id); err != nil {
+		return res, repo, fmt.Errorf("failed to reset webhook: %w", err)
+	}
+	if res.DemotedTarget != nil {
+		if err := insertTargets(ctx, tx, []models.Target{*res.DemotedTarget}); err != nil {
+			return res, repo, err
+		}
+	}
+	return res, repo, tx.Commit()
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gitsync/internal/provider"
+)
+
+// ProviderHandler handles provider-related HTTP requests
+type ProviderHandler struct {
+	Prober *provider.Prober
+}
+
+// NewProviderHandler creates a new ProviderHandler
+func NewProviderHandler(prober *provider.Prober) *ProviderHandler {
+	return &ProviderHandler{Prober: prober}
+}
+
+// GetProviderStatus handles GET /providers/status
+func (h *ProviderHandler) GetProviderStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Prober.Statuses())
+}
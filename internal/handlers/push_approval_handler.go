@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// PushApprovalHandler serves the force pushes and ref deletions held for
+// approval on protected targets, and lets admins approve or reject them
+type PushApprovalHandler struct {
+	DB *database.DB
+	// Runner syncs the repository once its changes are approved
+	Runner *replication.Runner
+	Clock  clock.Clock
+}
+
+// NewPushApprovalHandler creates a new PushApprovalHandler
+func NewPushApprovalHandler(db *database.DB, runner *replication.Runner, clk clock.Clock) *PushApprovalHandler {
+	return &PushApprovalHandler{DB: db, Runner: runner, Clock: clk}
+}
+
+const pushApprovalColumns = `id, repository_id, target_id, execution_id, status, forced, deleted, fingerprint, approved_by,
+	rejected_by, decided_at, executed_at, created_at`
+
+func scanPushApproval(row rowScanner) (models.PushApproval, error) {
+	var a models.PushApproval
+	err := row.Scan(&a.ID, &a.RepositoryID, &a.TargetID, &a.ExecutionID, &a.Status, pq.Array(&a.Forced), pq.Array(&a.Deleted),
+		&a.Fingerprint, pq.Array(&a.ApprovedBy), &a.RejectedBy, &a.DecidedAt, &a.ExecutedAt, &a.CreatedAt)
+	return a, err
+}
+
+// ListPushApprovals handles GET /push-approvals, optionally filtered by
+// status, repository_id and target_id
+func (h *PushApprovalHandler) ListPushApprovals(w http.ResponseWriter, r *http.Request) {
+	var conds []string
+	var args []any
+	for _, filter := range []string{"status", "repository_id", "target_id"} {
+		if v := r.URL.Query().Get(filter); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf("%s = $%d", filter, len(args)))
+		}
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+pushApprovalColumns+` FROM push_approvals `+where+` ORDER BY created_at DESC LIMIT 200`, args...)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id and target_id must be valid UUIDs", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch push approvals: %v", err)
+		http.Error(w, "failed to fetch push approvals", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	approvals := []models.PushApproval{}
+	for rows.Next() {
+		a, err := scanPushApproval(rows)
+		if err != nil {
+			http.Error(w, "failed to scan push approval", http.StatusInternalServerError)
+			return
+		}
+		approvals = append(approvals, a)
+	}
+	writeBody(w, r, http.StatusOK, approvals)
+}
+
+// GetPushApproval handles GET /push-approvals/{id}
+func (h *PushApprovalHandler) GetPushApproval(w http.ResponseWriter, r *http.Request) {
+	a, err := scanPushApproval(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+pushApprovalColumns+` FROM push_approvals WHERE id = $1`, mux.Vars(r)["id"]))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "push approval not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch push approval: %v", err)
+		http.Error(w, "failed to fetch push approval", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, http.StatusOK, a)
+}
+
+// ApprovePushApproval handles POST /push-approvals/{id}/approve. The
+// changes are approved once replication.PushApprovers distinct admins
+// approved them, and the repository is synced right away to push them.
+func (h *PushApprovalHandler) ApprovePushApproval(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	a, err := scanPushApproval(h.DB.QueryRowContext(r.Context(),
+		`UPDATE push_approvals
+		 SET approved_by = array_append(approved_by, $3),
+		     status = CASE WHEN cardinality(approved_by) + 1 >= $4 THEN $5 ELSE status END,
+		     decided_at = CASE WHEN cardinality(approved_by) + 1 >= $4 THEN $6 ELSE decided_at END
+		 WHERE id = $1 AND status = $2 AND NOT ($3 = ANY(approved_by))
+		 RETURNING `+pushApprovalColumns,
+		id, models.PushApprovalPending, p.User, replication.PushApprovers, models.PushApprovalApproved, h.Clock.Now()))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.undecidable(w, r, id, p.User)
+		return
+	}
+	if isInvalidUUID(err) {
+		http.Error(w, "push approval not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to approve push approval %s: %v", id, err)
+		http.Error(w, "failed to approve push approval", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "push_approval.approve", "push_approval", a.ID, map[string]any{
+		"repository_id": a.RepositoryID, "target_id": a.TargetID, "approvals": len(a.ApprovedBy), "status": a.Status,
+	})
+
+	if a.Status == models.PushApprovalApproved {
+		log.Printf("Push approval %s approved by %s", a.ID, strings.Join(a.ApprovedBy, ", "))
+		// A repository syncing now is pushed by its next sync
+		err := h.Runner.Enqueue(r.Context(), a.RepositoryID, replication.TriggerApproval+":"+a.ID)
+		if errors.Is(err, replication.ErrBusy) {
+			log.Printf("WARN: repository %s is busy; approved push %s waits for its next sync", a.RepositoryID, a.ID)
+		} else if err != nil {
+			log.Printf("ERROR: %v; approved push %s waits for the next sync", err, a.ID)
+		}
+	}
+	writeBody(w, r, http.StatusOK, a)
+}
+
+// RejectPushApproval handles POST /push-approvals/{id}/reject. Rejected
+// changes stay held; only a sync with other changes asks for approval
+// again.
+func (h *PushApprovalHandler) RejectPushApproval(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	a, err := scanPushApproval(h.DB.QueryRowContext(r.Context(),
+		`UPDATE push_approvals SET status = $4, rejected_by = $5, decided_at = $6
+		 WHERE id = $1 AND status IN ($2, $3)
+		 RETURNING `+pushApprovalColumns,
+		id, models.PushApprovalPending, models.PushApprovalApproved, models.PushApprovalRejected, p.User, h.Clock.Now()))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.undecidable(w, r, id, "")
+		return
+	}
+	if isInvalidUUID(err) {
+		http.Error(w, "push approval not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to reject push approval %s: %v", id, err)
+		http.Error(w, "failed to reject push approval", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Push approval %s rejected by %s", a.ID, p.User)
+	recordAudit(r, h.DB, "push_approval.reject", "push_approval", a.ID,
+		map[string]any{"repository_id": a.RepositoryID, "target_id": a.TargetID})
+	writeBody(w, r, http.StatusOK, a)
+}
+
+// undecidable writes why the approval with the given ID cannot be decided
+// by user: it does not exist, it is decided already, or user approved it
+// before
+func (h *PushApprovalHandler) undecidable(w http.ResponseWriter, r *http.Request, id, user string) {
+	a, err := scanPushApproval(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+pushApprovalColumns+` FROM push_approvals WHERE id = $1`, id))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "push approval not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("ERROR: failed to fetch push approval: %v", err)
+		http.Error(w, "failed to fetch push approval", http.StatusInternalServerError)
+	case user != "" && a.Status == models.PushApprovalPending && slices.Contains(a.ApprovedBy, user):
+		http.Error(w, "push approval needs another operator", http.StatusConflict)
+	default:
+		http.Error(w, "push approval is "+a.Status, http.StatusConflict)
+	}
+}
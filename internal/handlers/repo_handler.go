@@ -2,167 +2,1369 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"gitsync/internal/auth"
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
 	"gitsync/internal/database"
+	"gitsync/internal/deploykeys"
+	"gitsync/internal/git"
+	"gitsync/internal/ids"
+	"gitsync/internal/labels"
 	"gitsync/internal/models"
-)
+	"gitsync/internal/policy"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/schedule"
+	"gitsync/internal/trash"
+	"gitsync/internal/validation"
+	"gitsync/internal/webhooks"
 
-var allowedProviders = map[string]bool{
-	"github": true,
-	"gitlab": true,
-	"gitea":  true,
-}
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
 
 // RepoHandler handles repository-related HTTP requests
 type RepoHandler struct {
-	DB *database.DB
+	DB          *database.DB
+	Webhooks    *webhooks.Manager
+	Credentials *credentials.Store
+	URLPolicy   validation.URLPolicy
+	Policies    *policy.Engine
+	// RBAC scopes listings of non-admins to the teams they belong to
+	RBAC  bool
+	Cache *cache.Cache
+	// Trash keeps deleted repositories for restoring
+	Trash *trash.Bin
+	// DeployKeys are added to the remotes of default targets and removed
+	// from those of deleted targets
+	DeployKeys *deploykeys.Manager
+	// Defaults are the targets new repositories get
+	Defaults *DefaultTargetHandler
+	// Templates fill in the settings of repositories created from them
+	Templates *TemplateHandler
+	// Runner keeps syncs off repositories being deleted
+	Runner *replication.Runner
+	Clock  clock.Clock
+	IDs    ids.Generator
 }
 
 // NewRepoHandler creates a new RepoHandler
-func NewRepoHandler(db *database.DB) *RepoHandler {
-	return &RepoHandler{DB: db}
+func NewRepoHandler(db *database.DB, hooks *webhooks.Manager, creds *credentials.Store, urls validation.URLPolicy, policies *policy.Engine, rbac bool, c *cache.Cache, bin *trash.Bin, keys *deploykeys.Manager, defaults *DefaultTargetHandler, templates *TemplateHandler, runner *replication.Runner, clk clock.Clock, gen ids.Generator) *RepoHandler {
+	return &RepoHandler{DB: db, Webhooks: hooks, Credentials: creds, URLPolicy: urls, Policies: policies, RBAC: rbac, Cache: c, Trash: bin, DeployKeys: keys, Defaults: defaults, Templates: templates, Runner: runner, Clock: clk, IDs: gen}
 }
 
 // CreateRepository handles POST /repositories
-// @Summary Create a repository
-// @Description Create a new repository with source provider and URL
-// @Tags repositories
-// @Accept json
-// @Produce json
-// @Param repository body models.CreateRepositoryRequest true "Repository data"
-// @Success 201 {object} models.Repository
-// @Router /repositories [post]
 func (h *RepoHandler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateRepositoryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	tmpl, ok := h.Templates.fill(w, r, &req)
+	if !ok {
 		return
 	}
 
-	// Validate required fields
-	if strings.TrimSpace(req.Name) == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+	secret, err := h.validateRepositoryRequest(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if strings.TrimSpace(req.SourceProvider) == "" {
-		http.Error(w, "source_provider is required", http.StatusBadRequest)
+
+	// Verify if a repository of the same upstream already exists in the
+	// database, whatever URL it was added with
+	var existing string
+	err = h.DB.QueryRowContext(r.Context(),
+		"SELECT source_url FROM repositories WHERE canonical_url = $1", canonicalURL(req.SourceURL)).Scan(&existing)
+	if err == nil {
+		http.Error(w, "repository with this source_url already exists: "+existing, http.StatusConflict)
 		return
 	}
-	if strings.TrimSpace(req.SourceURL) == "" {
-		http.Error(w, "source_url is required", http.StatusBadRequest)
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("ERROR: failed to check if repository exists: %v", err)
+		http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
 		return
 	}
 
-	// Validate provider
-	if !allowedProviders[req.SourceProvider] {
-		http.Error(w, "invalid source_provider. allowed: github, gitlab, gitea", http.StatusBadRequest)
+	ctx := r.Context()
+	credentialID, ok := resolveURLCredential(r, w, h.DB, h.Credentials, req.Credential, secret, req.SourceProvider, req.SourceURL)
+	if !ok {
 		return
 	}
 
-	// Validate URL (must start with https:// or ssh)
-	if !strings.HasPrefix(req.SourceURL, "https://") && !strings.HasPrefix(req.SourceURL, "ssh://") {
-		http.Error(w, "source_url must start with https:// or ssh://", http.StatusBadRequest)
+	repo := h.newRepository(req, credentialID)
+	if !enforcePolicy(ctx, w, h.Policies, policy.Subject{Repository: repo}) {
+		return
+	}
+	repo.ID = h.IDs.NewID()
+	if !req.SkipDefaultTargets && !h.Defaults.add(w, r, &repo) {
 		return
 	}
+	if tmpl != nil {
+		adoptTemplate(&repo, tmpl)
+	}
 
-	// Verify if repository URL already exists in the database
-	var exists bool
-	if err := h.DB.QueryRowContext(context.Background(),
-		"SELECT EXISTS(SELECT 1 FROM repositories WHERE source_url = $1)", req.SourceURL).Scan(&exists); err != nil {
-		log.Printf("ERROR: failed to check if repository exists: %v", err)
-		http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
+	err = h.insertRepositories(ctx, []models.Repository{repo}, func(tx *sql.Tx) error {
+		return insertTargets(ctx, tx, repo.Targets)
+	})
+	// A concurrent create of the same source passes the existence check
+	if isUniqueViolation(err) {
+		http.Error(w, "repository with this source_url or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to insert repository: %v", err)
+		http.Error(w, "failed to create repository: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if exists {
-		http.Error(w, "repository with this source_url already exists", http.StatusConflict)
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "repository.create", "repository", repo.ID,
+		map[string]any{"name": repo.Name, "source_url": repo.SourceURL, "default_targets": len(repo.Targets)})
+	ensureDeployKeys(h.DeployKeys, repo.Targets...)
+
+	// Install the push webhook in the background; the periodic reconcile
+	// retries if the provider is unavailable right now
+	if h.Webhooks.Enabled() {
+		go func(repo models.Repository) {
+			if err := h.Webhooks.Ensure(context.Background(), repo); err != nil {
+				log.Printf("WARN: failed to install webhook for repository %s: %v", repo.ID, err)
+			}
+		}(repo)
+	}
+
+	writeBody(w, r, http.StatusCreated, repo)
+}
+
+// Bulk import limits. Each batch is one multi-row INSERT and stays well
+// below PostgreSQL's 65535 bind parameter limit.
+const (
+	maxImportRepositories = 50000
+	importBatchSize       = 1000
+)
+
+// ImportRepositories handles POST /repositories/import
+func (h *RepoHandler) ImportRepositories(w http.ResponseWriter, r *http.Request) {
+	var reqs []models.CreateRepositoryRequest
+	if !decodeBody(w, r, &reqs) {
+		return
+	}
+	if len(reqs) == 0 {
+		http.Error(w, "no repositories to import", http.StatusBadRequest)
+		return
+	}
+	if len(reqs) > maxImportRepositories {
+		http.Error(w, fmt.Sprintf("at most %d repositories can be imported at once", maxImportRepositories), http.StatusBadRequest)
 		return
 	}
 
-	repo := models.Repository{
-		Name:           req.Name,
-		SourceProvider: req.SourceProvider,
-		SourceURL:      req.SourceURL,
-		CreatedAt:      time.Now(),
+	ctx := r.Context()
+	policies, err := h.Policies.Enabled(ctx)
+	if err != nil {
+		log.Printf("ERROR: failed to evaluate policies: %v", err)
+		http.Error(w, "failed to evaluate policies", http.StatusInternalServerError)
+		return
+	}
+	defaults, err := h.Defaults.load(ctx)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch default targets", http.StatusInternalServerError)
+		return
+	}
+
+	repos := make([]models.Repository, 0, len(reqs))
+	urls := make([]string, 0, len(reqs))
+	seen := make(map[string]int, len(reqs))
+	seenExternal := make(map[string]int)
+	credentialIDs := make(map[[2]string]*string)
+	secrets := make(map[int]string)
+	templates := make(map[string]*models.RepositoryTemplate)
+	var targets []models.Target
+	for i := range reqs {
+		req := &reqs[i]
+		tmpl, err := h.Templates.lookup(ctx, templates, req.Template)
+		var missing errTemplate
+		if errors.As(err, &missing) {
+			http.Error(w, fmt.Sprintf("repository %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to fetch repository template", http.StatusInternalServerError)
+			return
+		}
+		if tmpl != nil {
+			fillFromTemplate(req, tmpl)
+		}
+		secret, err := h.validateRepositoryRequest(req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("repository %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		canonical := canonicalURL(req.SourceURL)
+		if j, dup := seen[canonical]; dup {
+			http.Error(w, fmt.Sprintf("repository %d: duplicates source_url of repository %d", i, j), http.StatusBadRequest)
+			return
+		}
+		seen[canonical] = i
+		if req.ExternalID != "" {
+			if j, dup := seenExternal[req.ExternalID]; dup {
+				http.Error(w, fmt.Sprintf("repository %d: duplicates external_id of repository %d", i, j), http.StatusBadRequest)
+				return
+			}
+			seenExternal[req.ExternalID] = i
+		}
+
+		key := [2]string{req.Credential, req.SourceProvider}
+		credentialID, ok := credentialIDs[key]
+		if secret != "" {
+			// Stored once the import can no longer fail validation
+			if req.Credential != "" {
+				http.Error(w, fmt.Sprintf("repository %d: %v", i, errEmbeddedCredential), http.StatusBadRequest)
+				return
+			}
+			secrets[i] = secret
+		} else if !ok {
+			if credentialID, ok = resolveCredential(ctx, w, h.Credentials, req.Credential, req.SourceProvider); !ok {
+				return
+			}
+			credentialIDs[key] = credentialID
+		}
+
+		repo := h.newRepository(*req, credentialID)
+		err = h.Policies.EnforceWith(ctx, policy.StageCreate, policies, policy.Subject{Repository: repo})
+		var violation *policy.ViolationError
+		if errors.As(err, &violation) {
+			http.Error(w, fmt.Sprintf("repository %d: %v", i, err), http.StatusForbidden)
+			return
+		}
+		repo.ID = h.IDs.NewID()
+		if !req.SkipDefaultTargets {
+			err := h.Defaults.addTo(ctx, r, defaults, policies, &repo)
+			var invalid errDefaultTarget
+			if errors.As(err, &invalid) {
+				http.Error(w, fmt.Sprintf("repository %d: %v", i, err), http.StatusUnprocessableEntity)
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR: failed to add default targets: %v", err)
+				http.Error(w, "failed to add default targets", http.StatusInternalServerError)
+				return
+			}
+		}
+		if tmpl != nil {
+			adoptTemplate(&repo, tmpl)
+		}
+		for j := range repo.Targets {
+			repo.Targets[j].ID = h.IDs.NewID()
+			targets = append(targets, repo.Targets[j])
+		}
+		repos = append(repos, repo)
+		urls = append(urls, canonical)
 	}
 
-	ctx := context.Background()
-	err := h.DB.QueryRowContext(ctx,
-		`INSERT INTO repositories (name, source_provider, source_url, created_at) 
-		 VALUES ($1, $2, $3, $4) 
-		 RETURNING id`,
-		repo.Name, repo.SourceProvider, repo.SourceURL, repo.CreatedAt).Scan(&repo.ID)
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT source_url FROM repositories WHERE canonical_url = ANY($1) ORDER BY source_url LIMIT 10`, pq.Array(urls))
 	if err != nil {
-		log.Printf("ERROR: failed to insert repository: %v", err)
-		http.Error(w, "failed to create repository: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("ERROR: failed to check if repositories exist: %v", err)
+		http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var existing []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			log.Printf("ERROR: failed to scan existing repository: %v", err)
+			http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
+			return
+		}
+		existing = append(existing, u)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ERROR: failed to check if repositories exist: %v", err)
+		http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+	if len(existing) > 0 {
+		http.Error(w, "repositories with these source_urls already exist: "+strings.Join(existing, ", "), http.StatusConflict)
+		return
+	}
+	for i, secret := range secrets {
+		if repos[i].CredentialID, err = storeURLCredential(r, h.DB, h.Credentials, secret, repos[i].SourceProvider, repos[i].SourceURL); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to store embedded credential", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = h.insertRepositories(ctx, repos, func(tx *sql.Tx) error {
+		return insertTargets(ctx, tx, targets)
+	})
+	if isUniqueViolation(err) {
+		http.Error(w, "a repository with one of these source_urls or external_ids already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to import repositories: %v", err)
+		http.Error(w, "failed to import repositories", http.StatusInternalServerError)
 		return
 	}
+	log.Printf("Imported %d repositories", len(repos))
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(repo)
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "repository.import", "repository", "", map[string]any{"count": len(repos), "default_targets": len(targets)})
+	ensureDeployKeys(h.DeployKeys, targets...)
+
+	if h.Webhooks.Enabled() {
+		go func(repos []models.Repository) {
+			for _, repo := range repos {
+				if err := h.Webhooks.Ensure(context.Background(), repo); err != nil {
+					log.Printf("WARN: failed to install webhook for repository %s: %v", repo.ID, err)
+				}
+			}
+		}(repos)
+	}
+
+	writeBody(w, r, http.StatusCreated, repos)
 }
 
-// ListRepositories handles GET /repositories
-// @Summary List repositories
-// @Description Get all repositories with their replication targets
-// @Tags repositories
-// @Accept json
-// @Produce json
-// @Success 200 {array} models.Repository
-// @Router /repositories [get]
+// insertRepositories inserts repos with one multi-row INSERT per batch,
+// then calls also, when set, in a single transaction
+func (h *RepoHandler) insertRepositories(ctx context.Context, repos []models.Repository, also func(*sql.Tx) error) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(repos); start += importBatchSize {
+		query, args := repositoryInsert(repos[start:min(start+importBatchSize, len(repos))])
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	if also != nil {
+		if err := also(tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// repositoryInsert builds the multi-row INSERT of batch and its arguments
+func repositoryInsert(batch []models.Repository) (string, []any) {
+	const columns = 19
+	var query strings.Builder
+	query.WriteString(`INSERT INTO repositories (id, name, source_provider, source_url, alternate_source_urls, credential_id, archive_action, labels, owner, team, sync_slo_seconds, clone_filter, cache_pool, sync_window, external_id, created_at, sync_interval_seconds, template_id, sync_schedule) VALUES `)
+	args := make([]any, 0, len(batch)*columns)
+	for i, repo := range batch {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for c := 1; c <= columns; c++ {
+			if c > 1 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*columns+c)
+		}
+		query.WriteString(")")
+		args = append(args, repo.ID, repo.Name, repo.SourceProvider, repo.SourceURL, pq.Array(repo.AlternateSourceURLs), repo.CredentialID, repo.ArchiveAction,
+			repo.Labels, repo.Owner, repo.Team, repo.SyncSLOSeconds, repo.CloneFilter, repo.CachePool, repo.SyncWindow, repo.ExternalID, repo.CreatedAt,
+			repo.SyncIntervalSeconds, repo.TemplateID, repo.SyncSchedule)
+	}
+	return query.String(), args
+}
+
+// ListRepositories handles GET /repositories. YAML listings, used to
+// export repositories to declarative tooling, are rendered whole and not
+// cached. Nor are pages, as the cache keeps no headers.
 func (h *RepoHandler) ListRepositories(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	q := r.URL.Query()
+	if acceptsYAML(r) || q.Has("page") || q.Has("per_page") {
+		h.listRepositories(w, r)
+		return
+	}
+	serveCached(w, r, h.Cache, cache.Repositories, h.listRepositories)
+}
+
+// repositorySort orders repository listings by key, an SQL expression of
+// type typ that is never NULL, then by ID in the same direction, so a
+// listing can be read in batches each starting after the last repository
+// of the one before
+type repositorySort struct {
+	key, typ string
+	desc     bool
+}
+
+// repositorySorts are the orders of repository listings by sort parameter,
+// newest first by default. health sorts the least healthy first, so they
+// can be triaged.
+var repositorySorts = map[string]repositorySort{
+	"":                {key: "r.created_at", typ: "timestamp", desc: true},
+	"health":          {key: "hs.score", typ: "int"},
+	"-health":         {key: "hs.score", typ: "int", desc: true},
+	"created_at":      {key: "r.created_at", typ: "timestamp"},
+	"-created_at":     {key: "r.created_at", typ: "timestamp", desc: true},
+	"name":            {key: "r.name", typ: "text"},
+	"-name":           {key: "r.name", typ: "text", desc: true},
+	"last_synced_at":  {key: lastSyncedAt, typ: "timestamp"},
+	"-last_synced_at": {key: lastSyncedAt, typ: "timestamp", desc: true},
+}
+
+// lastSyncedAt is when the latest sync of the repository r finished, or
+// -infinity when none did, so those come first in ascending order and last
+// in descending order
+const lastSyncedAt = `COALESCE((SELECT MAX(e.finished_at) FROM executions e WHERE e.repository_id = r.id), '-infinity')`
 
-	// Get all repositories
+// maxPerPage caps the page size of repository listings
+const maxPerPage = 500
+
+// repositoryPage selects a page of a repository listing in an order
+type repositoryPage struct {
+	// Sort is a key of repositorySorts
+	Sort string
+	// Limit, when positive, lists that many repositories from Offset on
+	Limit, Offset int
+}
+
+func (h *RepoHandler) listRepositories(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page := repositoryPage{Sort: q.Get("sort")}
+	if _, ok := repositorySorts[page.Sort]; !ok {
+		http.Error(w, "sort must be health, created_at, name or last_synced_at, prefixed with - for descending order", http.StatusBadRequest)
+		return
+	}
+	number := 1
+	if raw := q.Get("page"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			http.Error(w, "page must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		number, page.Limit = n, 50
+	}
+	if raw := q.Get("per_page"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPerPage {
+			http.Error(w, fmt.Sprintf("per_page must be between 1 and %d", maxPerPage), http.StatusBadRequest)
+			return
+		}
+		page.Limit = n
+	}
+	page.Offset = (number - 1) * page.Limit
+	conds, args := repositoryFilters(r)
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, conds, args)
+	if !ok {
+		return
+	}
+
+	if page.Limit > 0 {
+		where := ""
+		if len(conds) > 0 {
+			where = "WHERE " + strings.Join(conds, " AND ")
+		}
+		var total int
+		if err := h.DB.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM repositories r `+where, args...).Scan(&total); err != nil {
+			log.Printf("ERROR: failed to count repositories: %v", err)
+			http.Error(w, "failed to fetch repositories", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		if page.Offset+page.Limit < total {
+			next := *r.URL
+			query := next.Query()
+			query.Set("page", strconv.Itoa(number+1))
+			query.Set("per_page", strconv.Itoa(page.Limit))
+			next.RawQuery = query.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+		}
+	}
+
+	if acceptsYAML(r) {
+		repos := []models.Repository{}
+		err := h.queryRepositories(r.Context(), conds, args, page, func(repo *models.Repository) error {
+			repos = append(repos, *repo)
+			return nil
+		})
+		if err != nil {
+			log.Printf("ERROR: failed to fetch repositories: %v", err)
+			http.Error(w, "failed to fetch repositories", http.StatusInternalServerError)
+			return
+		}
+		writeBody(w, r, http.StatusOK, repos)
+		return
+	}
+
+	stream := newArrayStream(w)
+	defer stream.Close()
+
+	err := h.queryRepositories(r.Context(), conds, args, page, func(repo *models.Repository) error {
+		return stream.Write(repo)
+	})
+	if err != nil {
+		stream.Fail("failed to fetch repositories", err)
+	}
+}
+
+// GetRepository handles GET /repositories/{id}, returning the repository
+// with its targets and their latest syncs, and its schedule
+func (h *RepoHandler) GetRepository(w http.ResponseWriter, r *http.Request) {
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"r.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return
+	}
+
+	var found *models.Repository
+	err := h.queryRepositories(r.Context(), conds, args, repositoryPage{}, func(repo *models.Repository) error {
+		found = repo
+		return nil
+	})
+	if isInvalidUUID(err) || (err == nil && found == nil) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository: %v", err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+	ctx := r.Context()
+	if err := h.lastExecutions(ctx, found); err != nil {
+		log.Printf("ERROR: failed to fetch latest executions of repository %s: %v", found.ID, err)
+		http.Error(w, "failed to fetch executions", http.StatusInternalServerError)
+		return
+	}
+	plan, err := h.Runner.Planner.Plan(ctx, found.ID, h.Clock.Now())
+	if err != nil {
+		log.Printf("ERROR: failed to plan repository %s: %v", found.ID, err)
+		http.Error(w, "failed to compute schedule", http.StatusInternalServerError)
+		return
+	}
+	found.Schedule = &models.RepositorySchedule{
+		IntervalSeconds: plan.IntervalSeconds, Reason: plan.Reason, Cron: plan.Schedule,
+		LastSyncAt: plan.LastSyncAt, NextSyncAt: plan.NextSyncAt, HeldBy: plan.HeldBy,
+	}
+
+	writeBody(w, r, http.StatusOK, found)
+}
+
+// lastExecutions sets the latest execution of each target of repo
+func (h *RepoHandler) lastExecutions(ctx context.Context, repo *models.Repository) error {
 	rows, err := h.DB.QueryContext(ctx,
-		`SELECT id, name, source_provider, source_url, created_at FROM repositories ORDER BY created_at DESC`)
+		`SELECT DISTINCT ON (target_id) id, repository_id, target_id, status, error, source_url, trigger_reason, retry_at, started_at, finished_at
+		 FROM executions WHERE repository_id = $1 ORDER BY target_id, started_at DESC`, repo.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	latest := make(map[string]*models.Execution)
+	for rows.Next() {
+		var e models.Execution
+		if err := rows.Scan(&e.ID, &e.RepositoryID, &e.TargetID, &e.Status, &e.Error, &e.SourceURL, &e.Trigger,
+			&e.RetryAt, &e.StartedAt, &e.FinishedAt); err != nil {
+			return err
+		}
+		latest[e.TargetID] = &e
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range repo.Targets {
+		repo.Targets[i].LastExecution = latest[repo.Targets[i].ID]
+	}
+	return nil
+}
+
+// repositoryFilters returns the conditions, on repositories as r, of the
+// filters in the query of a repository listing
+func repositoryFilters(r *http.Request) ([]string, []any) {
+	var conds []string
+	var args []any
+	for _, filter := range []struct{ param, cond string }{
+		{"owner", "r.owner = $%d"},
+		{"team", "r.team = $%d"},
+		{"name", "r.name = $%d"},
+		{"name_contains", "strpos(lower(r.name), lower($%d)) > 0"},
+		{"provider", "r.source_provider = $%d"},
+		{"source_url", "r.source_url = $%d"},
+		{"external_id", "r.external_id = $%d"},
+		// The status of the latest execution, or never
+		{"sync_status", `COALESCE((SELECT e.status FROM executions e WHERE e.repository_id = r.id
+		                           ORDER BY e.started_at DESC LIMIT 1), 'never') = $%d`},
+	} {
+		if v := r.URL.Query().Get(filter.param); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf(filter.cond, len(args)))
+		}
+	}
+	return conds, args
+}
+
+// ExportRepositoriesCSV handles GET /repositories/export.csv. It streams
+// one row per repository with its targets and the outcome of its latest
+// finished sync, taking the same filters as the listing.
+func (h *RepoHandler) ExportRepositoriesCSV(w http.ResponseWriter, r *http.Request) {
+	conds, args := repositoryFilters(r)
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, conds, args)
+	if !ok {
+		return
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT r.id, r.name, r.source_provider, r.source_url, r.source_state, r.owner, r.team,
+		        (SELECT COUNT(*) FROM replication_targets t WHERE t.repository_id = r.id),
+		        COALESCE((SELECT string_agg(t.remote_url, ' ' ORDER BY t.priority, t.created_at)
+		                  FROM replication_targets t WHERE t.repository_id = r.id), ''),
+		        last.finished_at, last.status,
+		        (SELECT MAX(e.finished_at) FROM executions e WHERE e.repository_id = r.id AND e.status = 'success')
+		 FROM repositories r
+		 LEFT JOIN LATERAL (
+		     SELECT e.finished_at, e.status FROM executions e
+		     WHERE e.repository_id = r.id AND e.finished_at IS NOT NULL
+		     ORDER BY e.finished_at DESC LIMIT 1
+		 ) last ON true
+		 `+where+`
+		 ORDER BY r.created_at DESC, r.id`, args...)
 	if err != nil {
+		log.Printf("ERROR: failed to fetch repositories: %v", err)
 		http.Error(w, "failed to fetch repositories", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var repos []models.Repository
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="repositories.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "name", "source_provider", "source_url", "source_state", "owner", "team",
+		"targets", "target_urls", "last_sync_at", "last_sync_status", "last_success_at"})
 	for rows.Next() {
-		var repo models.Repository
-		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.CreatedAt); err != nil {
-			http.Error(w, "failed to scan repository", http.StatusInternalServerError)
+		var id, name, provider, sourceURL, state, targetURLs string
+		var owner, team, lastStatus *string
+		var targets int
+		var lastSync, lastSuccess *time.Time
+		if err := rows.Scan(&id, &name, &provider, &sourceURL, &state, &owner, &team,
+			&targets, &targetURLs, &lastSync, &lastStatus, &lastSuccess); err != nil {
+			log.Printf("ERROR: failed to scan repository: %v", err)
+			break
+		}
+		out.Write([]string{id, name, provider, sourceURL, state, csvString(owner), csvString(team),
+			strconv.Itoa(targets), targetURLs, csvTime(lastSync), csvString(lastStatus), csvTime(lastSuccess)})
+		if out.Error() != nil {
+			// The client went away
 			return
 		}
+	}
+	if err := rows.Err(); err != nil {
+		// The status is already sent; the export ends short
+		log.Printf("ERROR: failed to fetch repositories: %v", err)
+	}
+	out.Flush()
+}
+
+// csvTime formats t for a CSV cell, empty when nil
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvString returns *s for a CSV cell, empty when nil
+func csvString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// scopeToTeams adds the condition limiting non-admins to the repositories
+// of their teams when RBAC is enabled. It writes a 401 and returns false
+// for anonymous callers.
+func scopeToTeams(w http.ResponseWriter, r *http.Request, rbac bool, conds []string, args []any) ([]string, []any, bool) {
+	if !rbac {
+		return conds, args, true
+	}
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if !p.Admin {
+		args = append(args, pq.Array(p.Groups))
+		conds = append(conds, fmt.Sprintf("r.team = ANY($%d)", len(args)))
+	}
+	return conds, args, true
+}
+
+// healthJoin returns a join computing the health of the repositories r as
+// hs, with its arguments appended to args. Credentials count as rejected
+// when the latest sync of the source or a target failed with an
+// authentication error. The latest sync of each target is looked up once,
+// for drift and credentials alike.
+func (h *RepoHandler) healthJoin(args []any) (string, []any) {
+	n := len(args)
+	args = append(args, models.ExecutionSuccess, models.ApprovalApproved, git.AuthFailurePattern, h.Webhooks.Enabled())
+	success, approved, authFailure, webhooks := n+1, n+2, n+3, n+4
+	join := fmt.Sprintf(`LEFT JOIN LATERAL (
+		     SELECT s.rate, d.targets, d.drifted, d.ok, COALESCE(wh.status, 'none') AS webhook,
+		            ROUND(40 * COALESCE(s.rate, 0.5)
+		                  + 30 * CASE WHEN d.targets = 0 THEN 1 ELSE (d.targets - d.drifted)::numeric / d.targets END
+		                  + 15 * d.ok::int
+		                  + 15 * (NOT $%[4]d OR COALESCE(wh.status = 'active', FALSE))::int)::int AS score
+		     FROM (SELECT AVG((e.status = $%[1]d)::int) AS rate FROM executions e
+		           WHERE e.repository_id = r.id AND e.finished_at IS NOT NULL AND e.started_at > NOW() - INTERVAL '7 days') s
+		     CROSS JOIN (SELECT COUNT(*) FILTER (WHERE ht.approval_state = $%[2]d) AS targets,
+		                        COUNT(*) FILTER (WHERE ht.approval_state = $%[2]d AND (le.status IS DISTINCT FROM $%[1]d OR EXISTS (
+		                            SELECT 1 FROM slo_breaches b WHERE b.target_id = ht.id AND b.resolved_at IS NULL))) AS drifted,
+		                        NOT COALESCE(bool_or(le.error ~* $%[3]d), FALSE) AS ok
+		                 FROM replication_targets ht
+		                 LEFT JOIN LATERAL (SELECT e.status, e.error FROM executions e WHERE e.target_id = ht.id AND e.finished_at IS NOT NULL
+		                                    ORDER BY e.started_at DESC LIMIT 1) le ON TRUE
+		                 WHERE ht.repository_id = r.id) d
+		     LEFT JOIN repository_webhooks wh ON wh.repository_id = r.id
+		 ) hs ON TRUE`, success, approved, authFailure, webhooks)
+	return join, args
+}
+
+// targetBatch is the number of repositories read, and whose targets are
+// fetched, by one query each while listing
+const targetBatch = 500
 
-		// Get targets for this repository
-		targetRows, err := h.DB.QueryContext(ctx,
-			`SELECT id, repository_id, provider, remote_url, created_at 
-			 FROM replication_targets WHERE repository_id = $1`, repo.ID)
+// queryRepositories calls emit with each repository of page matching
+// conds, which refer to the repositories as r, complete with its targets
+// and health. Repositories come in the order of page. They are read in
+// batches of targetBatch, each starting after the last repository of the
+// one before, and the rows of a batch are closed before its targets are
+// fetched and it is emitted, so a listing holds one connection at a time
+// and no more than a batch in memory.
+func (h *RepoHandler) queryRepositories(ctx context.Context, conds []string, args []any, page repositoryPage, emit func(*models.Repository) error) error {
+	health, args := h.healthJoin(args)
+	order := repositorySorts[page.Sort]
+	offset, remaining := page.Offset, page.Limit
+	var after *repositoryKey
+	for {
+		n := targetBatch
+		if page.Limit > 0 {
+			n = min(n, remaining)
+		}
+		batch, last, err := h.repositoryBatch(ctx, health, conds, args, order, after, n, offset)
 		if err != nil {
-			http.Error(w, "failed to fetch targets", http.StatusInternalServerError)
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := h.loadTargets(ctx, batch); err != nil {
+			return err
+		}
+		for _, repo := range batch {
+			// emit only fails when the client went away
+			if err := emit(repo); err != nil {
+				return nil
+			}
+		}
+		remaining -= len(batch)
+		if len(batch) < n || (page.Limit > 0 && remaining == 0) {
+			return nil
+		}
+		offset, after = 0, &last
+	}
+}
+
+// repositoryKey is the sort key and ID of the last repository of a batch
+type repositoryKey struct {
+	value any
+	id    string
+}
+
+// repositoryBatch reads up to n repositories matching conds in order,
+// after the repository after when set and skipping offset of them, and
+// returns them with the key of the last one
+func (h *RepoHandler) repositoryBatch(ctx context.Context, health string, conds []string, args []any, order repositorySort, after *repositoryKey, n, offset int) ([]*models.Repository, repositoryKey, error) {
+	var last repositoryKey
+	args = slices.Clip(args)
+	if after != nil {
+		cmp := ">"
+		if order.desc {
+			cmp = "<"
+		}
+		args = append(args, after.value, after.id)
+		conds = append(slices.Clip(conds), fmt.Sprintf("(%s, r.id) %s ($%d::%s, $%d::uuid)", order.key, cmp, len(args)-1, order.typ, len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	dir := ""
+	if order.desc {
+		dir = " DESC"
+	}
+	args = append(args, n, offset)
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT r.id, r.name, r.source_provider, r.source_url, r.alternate_source_urls, r.credential_id, r.source_state, r.archive_action,
+		        r.labels, r.owner, r.team, r.sync_slo_seconds, r.clone_filter, r.cache_pool, r.sync_interval_seconds, r.sync_schedule, r.enabled, r.sync_window, r.size_exceeded_at, r.size_bytes, r.managed_by, r.template_id, r.external_id, r.created_at,
+		        hs.score, hs.rate, hs.targets, hs.drifted, hs.ok, hs.webhook, `+order.key+`
+		 FROM repositories r `+health+`
+		 `+where+`
+		 ORDER BY `+order.key+dir+`, r.id`+dir+fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, last, err
+	}
+	defer rows.Close()
+
+	batch := make([]*models.Repository, 0, n)
+	for rows.Next() {
+		repo := &models.Repository{Health: &models.RepositoryHealth{}}
+		health := repo.Health
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, pq.Array(&repo.AlternateSourceURLs), &repo.CredentialID,
+			&repo.SourceState, &repo.ArchiveAction, &repo.Labels, &repo.Owner, &repo.Team, &repo.SyncSLOSeconds, &repo.CloneFilter, &repo.CachePool, &repo.SyncIntervalSeconds, &repo.SyncSchedule, &repo.Enabled, &repo.SyncWindow, &repo.SizeExceededAt, &repo.SizeBytes, &repo.ManagedBy, &repo.TemplateID, &repo.ExternalID, &repo.CreatedAt,
+			&health.Score, &health.SuccessRate, &health.Targets, &health.DriftedTargets, &health.CredentialsOK, &health.Webhook, &last.value); err != nil {
+			return nil, last, err
+		}
+		batch = append(batch, repo)
+	}
+	// Text comes back as bytes, which would be sent back as bytea
+	if b, ok := last.value.([]byte); ok {
+		last.value = string(b)
+	}
+	if len(batch) > 0 {
+		last.id = batch[len(batch)-1].ID
+	}
+	return batch, last, rows.Err()
+}
+
+// loadTargets fetches the targets of repos with one query, in the order of
+// their priority
+func (h *RepoHandler) loadTargets(ctx context.Context, repos []*models.Repository) error {
+	byID := make(map[string]*models.Repository, len(repos))
+	ids := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		byID[repo.ID] = repo
+		ids = append(ids, repo.ID)
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT `+targetColumns+` FROM replication_targets
+		 WHERE repository_id = ANY($1::uuid[]) ORDER BY priority, created_at`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.Target
+		if err := rows.Scan(targetFields(&t)...); err != nil {
+			return err
+		}
+		repo := byID[t.RepositoryID]
+		repo.Targets = append(repo.Targets, t)
+	}
+	return rows.Err()
+}
+
+// PutRepository handles PUT /repositories/{id}. It creates the repository
+// with the given ID or replaces the fields of an existing one, so clients
+// such as infrastructure-as-code tools can apply the same request
+// repeatedly.
+func (h *RepoHandler) PutRepository(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateRepositoryRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	tmpl, ok := h.Templates.fill(w, r, &req)
+	if !ok {
+		return
+	}
+	secret, err := h.validateRepositoryRequest(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	credentialID, ok := resolveURLCredential(r, w, h.DB, h.Credentials, req.Credential, secret, req.SourceProvider, req.SourceURL)
+	if !ok {
+		return
+	}
+	repo := h.newRepository(req, credentialID)
+	if !enforcePolicy(ctx, w, h.Policies, policy.Subject{Repository: repo}) {
+		return
+	}
+	repo.ID = mux.Vars(r)["id"]
+	if tmpl != nil {
+		adoptTemplate(&repo, tmpl)
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "failed to save repository", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Repositories declared in the GitOps state file are left alone; the
+	// reconciler would revert any change on its next run
+	var created bool
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO repositories (id, name, source_provider, source_url, alternate_source_urls, credential_id, archive_action, labels, owner, team, sync_slo_seconds, clone_filter, cache_pool, sync_window, external_id, created_at, sync_interval_seconds, template_id, sync_schedule)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		 ON CONFLICT (id) DO UPDATE
+		 SET name = EXCLUDED.name, source_provider = EXCLUDED.source_provider, source_url = EXCLUDED.source_url,
+		     alternate_source_urls = EXCLUDED.alternate_source_urls,
+		     credential_id = EXCLUDED.credential_id, archive_action = EXCLUDED.archive_action, labels = EXCLUDED.labels,
+		     owner = EXCLUDED.owner, team = EXCLUDED.team, sync_slo_seconds = EXCLUDED.sync_slo_seconds,
+		     clone_filter = EXCLUDED.clone_filter, cache_pool = EXCLUDED.cache_pool, sync_window = EXCLUDED.sync_window,
+		     external_id = EXCLUDED.external_id, template_id = EXCLUDED.template_id, sync_schedule = EXCLUDED.sync_schedule,
+		     sync_interval_seconds = COALESCE(EXCLUDED.sync_interval_seconds, repositories.sync_interval_seconds)
+		 WHERE repositories.managed_by IS NULL
+		 RETURNING source_state, sync_interval_seconds, enabled, created_at, xmax = 0`,
+		repo.ID, repo.Name, repo.SourceProvider, repo.SourceURL, pq.Array(repo.AlternateSourceURLs), repo.CredentialID,
+		repo.ArchiveAction, repo.Labels, repo.Owner, repo.Team, repo.SyncSLOSeconds, repo.CloneFilter, repo.CachePool,
+		repo.SyncWindow, repo.ExternalID, repo.CreatedAt, repo.SyncIntervalSeconds, repo.TemplateID, repo.SyncSchedule).
+		Scan(&repo.SourceState, &repo.SyncIntervalSeconds, &repo.Enabled, &repo.CreatedAt, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "repository is managed by gitops", http.StatusConflict)
+		return
+	}
+	if isInvalidUUID(err) {
+		http.Error(w, "id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "repository with this source_url or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to upsert repository: %v", err)
+		http.Error(w, "failed to save repository", http.StatusInternalServerError)
+		return
+	}
+	// Only new repositories get the default targets; the targets of
+	// others take the push settings of the template
+	switch {
+	case created && !req.SkipDefaultTargets:
+		if !h.Defaults.add(w, r, &repo) {
 			return
 		}
+		if tmpl != nil {
+			adoptTemplate(&repo, tmpl)
+		}
+		err = insertTargets(ctx, tx, repo.Targets)
+	case !created && tmpl != nil:
+		_, err = applyTemplateToTargets(ctx, tx, *tmpl, []string{repo.ID})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to save repository %s: %v", repo.ID, err)
+		http.Error(w, "failed to save repository", http.StatusInternalServerError)
+		return
+	}
 
-		for targetRows.Next() {
-			var target models.Target
-			if err := targetRows.Scan(&target.ID, &target.RepositoryID, &target.Provider, &target.RemoteURL, &target.CreatedAt); err != nil {
-				targetRows.Close()
-				http.Error(w, "failed to scan target", http.StatusInternalServerError)
-				return
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	action, status := "repository.update", http.StatusOK
+	if created {
+		action, status = "repository.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "repository", repo.ID,
+		map[string]any{"name": repo.Name, "source_url": repo.SourceURL, "default_targets": len(repo.Targets)})
+	ensureDeployKeys(h.DeployKeys, repo.Targets...)
+
+	if h.Webhooks.Enabled() {
+		go func(repo models.Repository) {
+			if err := h.Webhooks.Ensure(context.Background(), repo); err != nil {
+				log.Printf("WARN: failed to install webhook for repository %s: %v", repo.ID, err)
 			}
-			repo.Targets = append(repo.Targets, target)
+		}(repo)
+	}
+
+	writeBody(w, r, status, repo)
+}
+
+// PatchRepository handles PATCH /repositories/{id}, changing the fields the
+// request sets. The result is validated as a new repository would be.
+func (h *RepoHandler) PatchRepository(w http.ResponseWriter, r *http.Request) {
+	var patch models.UpdateRepositoryRequest
+	if !decodeBody(w, r, &patch) {
+		return
+	}
+	if patch == (models.UpdateRepositoryRequest{}) {
+		http.Error(w, "no fields to update", http.StatusBadRequest)
+		return
+	}
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"r.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var repo *models.Repository
+	err := h.queryRepositories(ctx, conds, args, repositoryPage{}, func(found *models.Repository) error {
+		repo = found
+		return nil
+	})
+	if isInvalidUUID(err) || (err == nil && repo == nil) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository: %v", err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+	if repo.ManagedBy != nil {
+		http.Error(w, "repository is managed by "+*repo.ManagedBy, http.StatusConflict)
+		return
+	}
+
+	req := models.CreateRepositoryRequest{
+		Name: repo.Name, SourceProvider: repo.SourceProvider, SourceURL: repo.SourceURL,
+		AlternateSourceURLs: repo.AlternateSourceURLs, Labels: repo.Labels,
+	}
+	if repo.SyncSchedule != nil {
+		req.SyncSchedule = *repo.SyncSchedule
+	}
+	changes := map[string]any{}
+	if patch.Name != nil {
+		req.Name, changes["name"] = *patch.Name, *patch.Name
+	}
+	if patch.SourceProvider != nil {
+		req.SourceProvider, changes["source_provider"] = *patch.SourceProvider, *patch.SourceProvider
+	}
+	if patch.SourceURL != nil {
+		req.SourceURL = *patch.SourceURL
+	}
+	if patch.SyncSchedule != nil {
+		req.SyncSchedule = *patch.SyncSchedule
+	}
+	secret, err := h.validateRepositoryRequest(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The URL is recorded without the credentials it may have embedded
+	if patch.SourceURL != nil {
+		changes["source_url"] = req.SourceURL
+	}
+	if patch.SyncSchedule != nil {
+		changes["sync_schedule"] = req.SyncSchedule
+	}
+
+	updated := *repo
+	updated.Name, updated.SourceProvider, updated.SourceURL = req.Name, req.SourceProvider, req.SourceURL
+	updated.AlternateSourceURLs = req.AlternateSourceURLs
+	updated.SyncSchedule = nil
+	if req.SyncSchedule != "" {
+		updated.SyncSchedule = &req.SyncSchedule
+	}
+	if patch.Enabled != nil {
+		updated.Enabled, changes["enabled"] = *patch.Enabled, *patch.Enabled
+	}
+	if !enforcePolicy(ctx, w, h.Policies, policy.Subject{Repository: updated}) {
+		return
+	}
+	if secret != "" {
+		if updated.CredentialID, ok = resolveURLCredential(r, w, h.DB, h.Credentials, "", secret, req.SourceProvider, req.SourceURL); !ok {
+			return
 		}
-		targetRows.Close()
+	}
 
-		repos = append(repos, repo)
+	// As on create: the unique index on the upstream is missing from
+	// databases that held duplicates before it was added
+	if patch.SourceURL != nil {
+		var existing string
+		err := h.DB.QueryRowContext(ctx,
+			`SELECT source_url FROM repositories WHERE canonical_url = $1 AND id <> $2`, canonicalURL(updated.SourceURL), updated.ID).Scan(&existing)
+		if err == nil {
+			http.Error(w, "repository with this source_url already exists: "+existing, http.StatusConflict)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("ERROR: failed to check if repository exists: %v", err)
+			http.Error(w, "failed to check repository existence", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	if repos == nil {
-		repos = []models.Repository{}
+	res, err := h.DB.ExecContext(ctx,
+		`UPDATE repositories SET name = $2, source_provider = $3, source_url = $4, alternate_source_urls = $5,
+		     credential_id = $6, sync_schedule = $7, enabled = $8
+		 WHERE id = $1 AND managed_by IS NULL`,
+		updated.ID, updated.Name, updated.SourceProvider, updated.SourceURL, pq.Array(updated.AlternateSourceURLs),
+		updated.CredentialID, updated.SyncSchedule, updated.Enabled)
+	if isUniqueViolation(err) {
+		http.Error(w, "repository with this source_url already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update repository %s: %v", updated.ID, err)
+		http.Error(w, "failed to update repository", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "repository.update", "repository", updated.ID, changes)
+	if h.Webhooks.Enabled() && (patch.SourceProvider != nil || patch.SourceURL != nil) {
+		go func(repo models.Repository) {
+			if err := h.Webhooks.Ensure(context.Background(), repo); err != nil {
+				log.Printf("WARN: failed to install webhook for repository %s: %v", repo.ID, err)
+			}
+		}(updated)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(repos)
+	writeBody(w, r, http.StatusOK, updated)
+}
+
+// DeleteRepository handles DELETE /repositories/{id}. The repository is
+// moved to the trash along with its targets and sync history, and its
+// mirror is removed. A repository with a sync run queued or running on any
+// instance is deleted only with force=true, which fails the run and stops
+// its sync: here before the mirror is removed, on other instances on their
+// next heartbeat.
+func (h *RepoHandler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	force := false
+	if raw := r.URL.Query().Get("force"); raw != "" {
+		var err error
+		if force, err = strconv.ParseBool(raw); err != nil {
+			http.Error(w, "force must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	if force {
+		if err := h.Runner.Cancel(ctx, id, "cancelled: repository deleted"); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to cancel the syncs of the repository", http.StatusInternalServerError)
+			return
+		}
+	}
+	// Held, no other job of the repository starts here while it is deleted
+	release, ok := h.Runner.Hold(id)
+	if !ok {
+		http.Error(w, replication.ErrBusy.Error(), http.StatusConflict)
+		return
+	}
+	defer release()
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "failed to delete repository", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var managedBy *string
+	err = tx.QueryRowContext(ctx, `SELECT managed_by FROM repositories WHERE id = $1 FOR UPDATE`, id).Scan(&managedBy)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository: %v", err)
+		http.Error(w, "failed to delete repository", http.StatusInternalServerError)
+		return
+	}
+	if managedBy != nil {
+		http.Error(w, "repository is managed by "+*managedBy, http.StatusConflict)
+		return
+	}
+	// Queueing a run locks the repository as well, so none is queued from
+	// here on
+	var busy bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM sync_runs WHERE repository_id = $1 AND status IN ($2, $3))`,
+		id, models.SyncRunQueued, models.SyncRunRunning).Scan(&busy); err != nil {
+		log.Printf("ERROR: failed to check sync runs of repository %s: %v", id, err)
+		http.Error(w, "failed to delete repository", http.StatusInternalServerError)
+		return
+	}
+	if busy {
+		msg := replication.ErrBusy.Error() + ", or delete it with force=true"
+		if force {
+			// Queued again since its runs were cancelled
+			msg = replication.ErrBusy.Error()
+		}
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+
+	if err := h.Trash.Discard(ctx, tx, trash.Repository, id, deletedBy(r)); err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to delete repository", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: failed to delete repository %s: %v", id, err)
+		http.Error(w, "failed to delete repository", http.StatusInternalServerError)
+		return
+	}
+
+	// No job of the repository runs here, so the mirror is not in use. A
+	// restored repository clones it again.
+	if err := h.Runner.Syncer.Pusher.RemoveMirror(id); err != nil {
+		log.Printf("WARN: %v", err)
+	}
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "repository.delete", "repository", id, map[string]any{"force": force})
+	pruneDeployKeys(h.DeployKeys)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateRepositoryRequest checks req and normalizes its URL and labels,
+// returning the secret taken off the URLs when the policy extracts embedded
+// credentials. Errors are meant for a 400 response.
+func (h *RepoHandler) validateRepositoryRequest(req *models.CreateRepositoryRequest) (string, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return "", errors.New("name is required")
+	}
+	if strings.TrimSpace(req.SourceProvider) == "" {
+		return "", errors.New("source_provider is required")
+	}
+	if strings.TrimSpace(req.SourceURL) == "" {
+		return "", errors.New("source_url is required")
+	}
+	if _, err := provider.Lookup(req.SourceProvider); err != nil {
+		return "", fmt.Errorf("invalid source_provider: %w", err)
+	}
+
+	// Normalize the URL so equivalent spellings are detected as duplicates
+	sourceURL, secret := h.URLPolicy.SplitCredentials(req.SourceURL)
+	sourceURL, err := h.URLPolicy.RepoURL(req.SourceProvider, sourceURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid source_url: %w", err)
+	}
+	req.SourceURL = sourceURL
+	req.ExternalID = strings.TrimSpace(req.ExternalID)
+
+	for i, u := range req.AlternateSourceURLs {
+		u, alternateSecret := h.URLPolicy.SplitCredentials(u)
+		if alternateSecret != "" && alternateSecret != secret {
+			return "", fmt.Errorf("alternate_source_urls[%d] embeds other credentials than source_url", i)
+		}
+		alternate, err := h.URLPolicy.RepoURL(req.SourceProvider, u)
+		if err != nil {
+			return "", fmt.Errorf("invalid alternate_source_urls[%d]: %w", i, err)
+		}
+		if alternate == req.SourceURL || slices.Contains(req.AlternateSourceURLs[:i], alternate) {
+			return "", fmt.Errorf("alternate_source_urls[%d] duplicates another source URL", i)
+		}
+		req.AlternateSourceURLs[i] = alternate
+	}
+
+	if err := labels.Validate(req.Labels); err != nil {
+		return "", fmt.Errorf("invalid labels: %w", err)
+	}
+	if req.Labels == nil {
+		req.Labels = models.Labels{}
+	}
+	if req.ArchiveAction != "" && !validArchiveAction(req.ArchiveAction) {
+		return "", errors.New("invalid archive_action. allowed: flag, archive, banner")
+	}
+	if req.SyncSLOSeconds != nil && *req.SyncSLOSeconds <= 0 {
+		return "", errors.New("sync_slo_seconds must be positive")
+	}
+	if req.CloneFilter != "" {
+		if err := git.ValidateFilter(req.CloneFilter); err != nil {
+			return "", fmt.Errorf("invalid clone_filter: %w", err)
+		}
+	}
+	if req.SyncWindow != nil {
+		if err := schedule.ValidateWindow(*req.SyncWindow); err != nil {
+			return "", fmt.Errorf("invalid sync_window: %w", err)
+		}
+	}
+	req.SyncSchedule = strings.TrimSpace(req.SyncSchedule)
+	if req.SyncSchedule != "" {
+		if _, err := schedule.ParseCron(req.SyncSchedule); err != nil {
+			return "", fmt.Errorf("invalid sync_schedule: %w", err)
+		}
+	}
+	return secret, nil
+}
+
+// newRepository builds the repository described by a validated request.
+// The ID is left empty: policy violations of a blocked repository are
+// recorded without one, as it never exists.
+func (h *RepoHandler) newRepository(req models.CreateRepositoryRequest, credentialID *string) models.Repository {
+	repo := models.Repository{
+		Name:           req.Name,
+		SourceProvider: req.SourceProvider,
+		SourceURL:      req.SourceURL,
+		CredentialID:   credentialID,
+		SourceState:    models.SourceActive,
+		Enabled:        true,
+		Labels:         req.Labels,
+		SyncSLOSeconds: req.SyncSLOSeconds,
+		SyncWindow:     req.SyncWindow,
+		CreatedAt:      h.Clock.Now(),
+	}
+	// The column is never NULL
+	repo.AlternateSourceURLs = req.AlternateSourceURLs
+	if repo.AlternateSourceURLs == nil {
+		repo.AlternateSourceURLs = []string{}
+	}
+	if req.ArchiveAction != "" {
+		action := req.ArchiveAction
+		repo.ArchiveAction = &action
+	}
+	if req.CloneFilter != "" {
+		filter := req.CloneFilter
+		repo.CloneFilter = &filter
+	}
+	if pool := strings.TrimSpace(req.CachePool); pool != "" {
+		repo.CachePool = &pool
+	}
+	if req.SyncSchedule != "" {
+		expr := req.SyncSchedule
+		repo.SyncSchedule = &expr
+	}
+	if owner := strings.TrimSpace(req.Owner); owner != "" {
+		repo.Owner = &owner
+	}
+	if team := strings.TrimSpace(req.Team); team != "" {
+		repo.Team = &team
+	}
+	if req.ExternalID != "" {
+		externalID := req.ExternalID
+		repo.ExternalID = &externalID
+	}
+	return repo
+}
+
+// canonicalURL returns the canonical form of a validated source URL, which
+// duplicates are detected by
+func canonicalURL(sourceURL string) string {
+	canonical, _ := provider.CanonicalURL(sourceURL)
+	return canonical
+}
+
+func validArchiveAction(action string) bool {
+	switch action {
+	case models.ArchiveActionFlag, models.ArchiveActionArchive, models.ArchiveActionBanner:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gitsync/internal/models"
+)
+
+// importedRepositories returns n repositories as an import creates them
+func importedRepositories(n int) []models.Repository {
+	repos := make([]models.Repository, n)
+	created := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	for i := range repos {
+		repos[i] = models.Repository{
+			ID:             fmt.Sprintf("00000000-0000-0000-0000-%012d", i),
+			Name:           fmt.Sprintf("repo-%d", i),
+			SourceProvider: "github",
+			SourceURL:      fmt.Sprintf("https://github.com/org/repo-%d.git", i),
+			CreatedAt:      created,
+		}
+	}
+	return repos
+}
+
+func TestRepositoryInsert(t *testing.T) {
+	for _, n := range []int{1, 3, importBatchSize} {
+		query, args := repositoryInsert(importedRepositories(n))
+		if got := strings.Count(query, "), ("); got != n-1 {
+			t.Errorf("%d repositories: %d row separators, want %d", n, got, n-1)
+		}
+		if len(args) != n*19 {
+			t.Errorf("%d repositories: %d arguments, want %d", n, len(args), n*19)
+		}
+		if last := fmt.Sprintf("$%d)", n*19); !strings.HasSuffix(query, last) {
+			t.Errorf("%d repositories: query ends with %q, want %q", n, query[len(query)-10:], last)
+		}
+	}
+}
+
+// BenchmarkRepositoryInsert builds the INSERTs of an import of 10,000
+// repositories, in batches as insertRepositories sends them
+func BenchmarkRepositoryInsert(b *testing.B) {
+	repos := importedRepositories(10000)
+	b.ReportAllocs()
+	for b.Loop() {
+		for start := 0; start < len(repos); start += importBatchSize {
+			repositoryInsert(repos[start:min(start+importBatchSize, len(repos))])
+		}
+	}
+}
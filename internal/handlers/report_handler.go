@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/digest"
+	"gitsync/internal/stats"
+)
+
+// ReportHandler handles report-related HTTP requests
+type ReportHandler struct {
+	DB     *database.DB
+	Digest *digest.Builder
+	Clock  clock.Clock
+}
+
+// NewReportHandler creates a new ReportHandler
+func NewReportHandler(db *database.DB, builder *digest.Builder, clk clock.Clock) *ReportHandler {
+	return &ReportHandler{DB: db, Digest: builder, Clock: clk}
+}
+
+// GetDigest handles GET /reports/digest
+func (h *ReportHandler) GetDigest(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = digest.Daily
+	}
+	length, err := digest.PeriodLength(period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := h.Clock.Now().UTC()
+	report, err := h.Digest.Build(r.Context(), period, to.Add(-length), to)
+	if err != nil {
+		log.Printf("ERROR: failed to build digest: %v", err)
+		http.Error(w, "Failed to build digest", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetSyncStats handles GET /stats/syncs?group_by={day|week|month}&since={30d},
+// optionally filtered by repository_id
+func (h *ReportHandler) GetSyncStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	groupBy, err := stats.ParseGroupBy(q.Get("group_by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	days := 30
+	if raw := q.Get("since"); raw != "" {
+		if days, err = stats.ParseSince(raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := stats.Query(r.Context(), h.DB, groupBy, days, q.Get("repository_id"), h.Clock.Now())
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "Failed to compute sync statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
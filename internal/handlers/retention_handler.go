@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/retention"
+
+	"github.com/gorilla/mux"
+)
+
+// RetentionHandler handles data retention HTTP requests
+type RetentionHandler struct {
+	DB       *database.DB
+	Defaults models.Retention
+}
+
+// NewRetentionHandler creates a new RetentionHandler
+func NewRetentionHandler(db *database.DB, defaults models.Retention) *RetentionHandler {
+	return &RetentionHandler{DB: db, Defaults: defaults}
+}
+
+// GetRepositoryRetention handles GET /repositories/{id}/retention
+func (h *RetentionHandler) GetRepositoryRetention(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var o models.RetentionOverrides
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT history_retention_days, log_retention_days, cache_retention_days FROM repositories WHERE id = $1`, id).
+		Scan(&o.HistoryDays, &o.LogDays, &o.CacheDays)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository retention: %v", err)
+		http.Error(w, "failed to fetch retention", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RepositoryRetention{
+		RepositoryID: id,
+		Overrides:    o,
+		Effective:    retention.Effective(h.Defaults, o),
+	})
+}
+
+// UpdateRepositoryRetention handles PUT /repositories/{id}/retention
+func (h *RetentionHandler) UpdateRepositoryRetention(w http.ResponseWriter, r *http.Request) {
+	var o models.RetentionOverrides
+	if !decodeJSON(w, r, &o) {
+		return
+	}
+	for _, d := range []*int{o.HistoryDays, o.LogDays, o.CacheDays} {
+		if d != nil && *d <= 0 {
+			http.Error(w, "retention periods must be a positive number of days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := mux.Vars(r)["id"]
+	res, err := h.DB.ExecContext(r.Context(),
+		`UPDATE repositories SET history_retention_days = $2, log_retention_days = $3, cache_retention_days = $4
+		 WHERE id = $1`, id, o.HistoryDays, o.LogDays, o.CacheDays)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update repository retention: %v", err)
+		http.Error(w, "failed to update retention", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "repository.retention", "repository", id,
+		map[string]any{"history_days": o.HistoryDays, "log_days": o.LogDays, "cache_days": o.CacheDays})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RepositoryRetention{
+		RepositoryID: id,
+		Overrides:    o,
+		Effective:    retention.Effective(h.Defaults, o),
+	})
+}
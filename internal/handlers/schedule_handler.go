@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+	"gitsync/internal/schedule"
+
+	"github.com/gorilla/mux"
+)
+
+// ScheduleHandler explains when repositories are synced next and why
+type ScheduleHandler struct {
+	DB   *database.DB
+	RBAC bool
+	// Runner holds the planner of scheduled syncs
+	Runner *replication.Runner
+	Clock  clock.Clock
+}
+
+// NewScheduleHandler creates a new ScheduleHandler
+func NewScheduleHandler(db *database.DB, rbac bool, runner *replication.Runner, clk clock.Clock) *ScheduleHandler {
+	return &ScheduleHandler{DB: db, RBAC: rbac, Runner: runner, Clock: clk}
+}
+
+// recentExecutions is how many of the latest executions a schedule report
+// lists
+const recentExecutions = 10
+
+// GetRepositorySchedule handles GET /repositories/{id}/schedule, returning
+// the effective interval of the repository, why it was chosen, when the
+// next scheduled sync is due and what holds it back, along with what
+// triggered its recent syncs
+func (h *ScheduleHandler) GetRepositorySchedule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"r.id = $1"}, []any{id})
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var exists bool
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM repositories r WHERE `+strings.Join(conds, " AND ")+`)`, args...).Scan(&exists)
+	if isInvalidUUID(err) || (err == nil && !exists) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository: %v", err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+
+	plan, err := h.Runner.Planner.Plan(ctx, id, h.Clock.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to plan repository %s: %v", id, err)
+		http.Error(w, "failed to compute schedule", http.StatusInternalServerError)
+		return
+	}
+	report := schedule.Report{Plan: plan, RecentExecutions: []models.Execution{}}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, repository_id, target_id, status, error, source_url, trigger_reason, retry_at, started_at, finished_at
+		 FROM executions WHERE repository_id = $1 ORDER BY started_at DESC LIMIT $2`, id, recentExecutions)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch executions of repository %s: %v", id, err)
+		http.Error(w, "failed to fetch executions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.Execution
+		if err := rows.Scan(&e.ID, &e.RepositoryID, &e.TargetID, &e.Status, &e.Error, &e.SourceURL, &e.Trigger,
+			&e.RetryAt, &e.StartedAt, &e.FinishedAt); err != nil {
+			http.Error(w, "failed to scan execution", http.StatusInternalServerError)
+			return
+		}
+		report.RecentExecutions = append(report.RecentExecutions, e)
+	}
+
+	writeBody(w, r, http.StatusOK, report)
+}
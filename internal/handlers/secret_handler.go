@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// SecretHandler serves the possible credentials found by secret scanning
+// and lets admins allow them
+type SecretHandler struct {
+	DB    *database.DB
+	Clock clock.Clock
+}
+
+// NewSecretHandler creates a new SecretHandler
+func NewSecretHandler(db *database.DB, clk clock.Clock) *SecretHandler {
+	return &SecretHandler{DB: db, Clock: clk}
+}
+
+const secretFindingColumns = `id, repository_id, target_id, execution_id, rule, commit_sha, path, line, match, fingerprint,
+	allowed, allowed_by, allowed_at, created_at`
+
+func scanSecretFinding(row rowScanner) (models.SecretFinding, error) {
+	var f models.SecretFinding
+	err := row.Scan(&f.ID, &f.RepositoryID, &f.TargetID, &f.ExecutionID, &f.Rule, &f.Commit, &f.Path, &f.Line, &f.Match,
+		&f.Fingerprint, &f.Allowed, &f.AllowedBy, &f.AllowedAt, &f.CreatedAt)
+	return f, err
+}
+
+// ListSecretFindings handles GET /secret-findings, optionally filtered by
+// repository_id and allowed
+func (h *SecretHandler) ListSecretFindings(w http.ResponseWriter, r *http.Request) {
+	var conds []string
+	var args []any
+	if v := r.URL.Query().Get("repository_id"); v != "" {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf("repository_id = $%d", len(args)))
+	}
+	switch v := r.URL.Query().Get("allowed"); v {
+	case "":
+	case "true", "false":
+		args = append(args, v == "true")
+		conds = append(conds, fmt.Sprintf("allowed = $%d", len(args)))
+	default:
+		http.Error(w, "allowed must be true or false", http.StatusBadRequest)
+		return
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+secretFindingColumns+` FROM secret_findings `+where+` ORDER BY created_at DESC LIMIT 200`, args...)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to fetch secret findings", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	findings := []models.SecretFinding{}
+	for rows.Next() {
+		f, err := scanSecretFinding(rows)
+		if err != nil {
+			http.Error(w, "failed to scan secret finding", http.StatusInternalServerError)
+			return
+		}
+		findings = append(findings, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(findings)
+}
+
+// AllowSecretFinding handles POST /secret-findings/{id}/allow. Once
+// allowed, the finding no longer quarantines syncs of its repository.
+func (h *SecretHandler) AllowSecretFinding(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+
+	f, err := scanSecretFinding(h.DB.QueryRowContext(r.Context(),
+		`UPDATE secret_findings
+		 SET allowed = TRUE,
+		     allowed_by = CASE WHEN allowed THEN allowed_by ELSE $2 END,
+		     allowed_at = CASE WHEN allowed THEN allowed_at ELSE $3 END
+		 WHERE id = $1
+		 RETURNING `+secretFindingColumns,
+		mux.Vars(r)["id"], p.User, h.Clock.Now()))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "secret finding not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to allow secret finding: %v", err)
+		http.Error(w, "failed to allow secret finding", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "secret_finding.allow", "secret_finding", f.ID, map[string]any{
+		"repository_id": f.RepositoryID, "rule": f.Rule, "commit": f.Commit, "path": f.Path, "line": f.Line,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"gitsync/internal/sessions"
+)
+
+// SessionHandler logs browser users in through the OIDC provider and out
+// again
+type SessionHandler struct {
+	Sessions *sessions.Manager
+}
+
+// NewSessionHandler creates a new SessionHandler
+func NewSessionHandler(manager *sessions.Manager) *SessionHandler {
+	return &SessionHandler{Sessions: manager}
+}
+
+// enabled writes an error and returns false unless users log in through a
+// provider
+func (h *SessionHandler) enabled(w http.ResponseWriter) bool {
+	if !h.Sessions.Enabled() {
+		http.Error(w, "SSO login is not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// Login handles GET /auth/login, redirecting the browser to the provider.
+// After logging in the user returns to the return_to path.
+func (h *SessionHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w) {
+		return
+	}
+	redirect, state, err := h.Sessions.Begin(r.Context(), r.URL.Query().Get("return_to"))
+	if err != nil {
+		log.Printf("ERROR: failed to start login: %v", err)
+		http.Error(w, "failed to start login", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, h.Sessions.Cookie(sessions.LoginCookie, state, sessions.LoginTTL))
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// LoginCallback handles GET /auth/callback, where the provider returns the
+// browser with an authorization code. It opens a session and returns the
+// user to where the login started.
+func (h *SessionHandler) LoginCallback(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w) {
+		return
+	}
+	q := r.URL.Query()
+	http.SetCookie(w, h.Sessions.Cookie(sessions.LoginCookie, "", 0))
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "login failed: "+e+" "+q.Get("error_description"), http.StatusBadRequest)
+		return
+	}
+	// The state must come back to the browser that started the login, so
+	// no one can log a victim into their own session
+	state, err := r.Cookie(sessions.LoginCookie)
+	if err != nil || state.Value == "" || state.Value != q.Get("state") {
+		http.Error(w, "login state does not match; start again at /auth/login", http.StatusBadRequest)
+		return
+	}
+
+	token, s, returnTo, err := h.Sessions.Complete(r.Context(), state.Value, q.Get("code"))
+	if errors.Is(err, sessions.ErrInvalidLogin) {
+		http.Error(w, err.Error()+"; start again at /auth/login", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to complete login: %v", err)
+		http.Error(w, "failed to complete login", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, h.Sessions.Cookie(sessions.CookieName, token, s.ExpiresAt.Sub(s.CreatedAt)))
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// GetSession handles GET /auth/session, returning the user of the session
+// and the CSRF token its other requests send
+func (h *SessionHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w) {
+		return
+	}
+	cookie, err := r.Cookie(sessions.CookieName)
+	if err != nil {
+		http.Error(w, "not logged in", http.StatusUnauthorized)
+		return
+	}
+	s, found, err := h.Sessions.Lookup(r.Context(), cookie.Value)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch session", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "not logged in", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, r, http.StatusOK, s)
+}
+
+// Logout handles POST /auth/logout, ending the session of the caller
+func (h *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled(w) {
+		return
+	}
+	if cookie, err := r.Cookie(sessions.CookieName); err == nil {
+		if err := h.Sessions.End(r.Context(), cookie.Value); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to end session", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, h.Sessions.Cookie(sessions.CookieName, "", 0))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// streamFlushEvery is how many elements are written between flushes
+const streamFlushEvery = 100
+
+// arrayStream writes a JSON array one element at a time so large listings
+// are never held in memory. Nothing is sent until the first element or
+// Close, so errors before that can still produce a normal error response.
+type arrayStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	started bool
+	n       int
+}
+
+func newArrayStream(w http.ResponseWriter) *arrayStream {
+	return &arrayStream{w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *arrayStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.Write([]byte("["))
+}
+
+// Write appends v to the array
+func (s *arrayStream) Write(v any) error {
+	s.start()
+	if s.n > 0 {
+		if _, err := s.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	if err := s.enc.Encode(v); err != nil {
+		return err
+	}
+	s.n++
+	if s.n%streamFlushEvery == 0 {
+		if f, ok := s.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return nil
+}
+
+// Fail reports an error. Before anything was written it sends a 500;
+// afterwards the status is already out, so the array is left unterminated
+// for the client to detect and the error is logged.
+func (s *arrayStream) Fail(msg string, err error) {
+	log.Printf("ERROR: %s: %v", msg, err)
+	if !s.started {
+		s.started = true
+		s.n = -1
+		http.Error(s.w, msg, http.StatusInternalServerError)
+		return
+	}
+	s.n = -1
+}
+
+// Close terminates the array, writing [] when it is empty
+func (s *arrayStream) Close() {
+	if s.n < 0 {
+		return
+	}
+	s.start()
+	s.w.Write([]byte("]\n"))
+}
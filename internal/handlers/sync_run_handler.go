@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// SyncRunHandler syncs repositories on request and serves the history of
+// their syncs
+type SyncRunHandler struct {
+	DB   *database.DB
+	RBAC bool
+	// Runner queues the requested syncs
+	Runner *replication.Runner
+	Clock  clock.Clock
+}
+
+// NewSyncRunHandler creates a new SyncRunHandler
+func NewSyncRunHandler(db *database.DB, rbac bool, runner *replication.Runner, clk clock.Clock) *SyncRunHandler {
+	return &SyncRunHandler{DB: db, RBAC: rbac, Runner: runner, Clock: clk}
+}
+
+// SyncRepository handles POST /repositories/{id}/sync, queueing a sync of
+// every approved target of the repository, or of the targets named by
+// repeated target parameters, and responding with its run right away
+func (h *SyncRunHandler) SyncRepository(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"r.id = $1"}, []any{id})
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var approved []string
+	args = append(args, models.ApprovalApproved)
+	err := h.DB.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT ARRAY(SELECT t.id::text FROM replication_targets t WHERE t.repository_id = r.id AND t.approval_state = $%d)
+		 FROM repositories r WHERE %s`, len(args), strings.Join(conds, " AND ")), args...).Scan(pq.Array(&approved))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository %s: %v", id, err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+	targets := r.URL.Query()["target"]
+	for _, t := range targets {
+		if !slices.Contains(approved, t) {
+			http.Error(w, "target "+t+" is not an approved target of the repository", http.StatusBadRequest)
+			return
+		}
+	}
+	if !checkFreeze(ctx, w, r, h.DB, h.Clock.Now()) {
+		return
+	}
+
+	run, err := h.Runner.Trigger(ctx, id, targets, audit.Actor(ctx))
+	if errors.Is(err, replication.ErrBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to queue sync", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "repository.sync", "repository", id, map[string]any{"run_id": run.ID, "target_ids": run.TargetIDs})
+
+	writeBody(w, r, http.StatusAccepted, run)
+}
+
+// ListRepositorySyncs handles GET /repositories/{id}/syncs, newest first,
+// optionally filtered by status
+func (h *SyncRunHandler) ListRepositorySyncs(w http.ResponseWriter, r *http.Request) {
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"r.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return
+	}
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > 200 {
+			http.Error(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	ctx := r.Context()
+	var exists bool
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM repositories r WHERE `+strings.Join(conds, " AND ")+`)`, args...).Scan(&exists)
+	if isInvalidUUID(err) || (err == nil && !exists) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository: %v", err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+
+	where, args := "repository_id = $1", []any{mux.Vars(r)["id"], limit}
+	if status := r.URL.Query().Get("status"); status != "" {
+		where, args = where+" AND status = $3", append(args, status)
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT `+replication.SyncRunColumns+` FROM sync_runs WHERE `+where+` ORDER BY created_at DESC LIMIT $2`, args...)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch sync runs: %v", err)
+		http.Error(w, "failed to fetch sync runs", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	runs := []models.SyncRun{}
+	for rows.Next() {
+		run, err := replication.ScanSyncRun(rows)
+		if err != nil {
+			http.Error(w, "failed to scan sync run", http.StatusInternalServerError)
+			return
+		}
+		runs = append(runs, run)
+	}
+
+	writeBody(w, r, http.StatusOK, runs)
+}
+
+// GetSync handles GET /syncs/{id}, returning a sync run with the execution
+// of each of its targets
+func (h *SyncRunHandler) GetSync(w http.ResponseWriter, r *http.Request) {
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"s.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	run, err := replication.ScanSyncRun(h.DB.QueryRowContext(ctx,
+		`SELECT `+prefixColumns("s", replication.SyncRunColumns)+`
+		 FROM sync_runs s JOIN repositories r ON r.id = s.repository_id
+		 WHERE `+strings.Join(conds, " AND "), args...))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "sync run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch sync run: %v", err)
+		http.Error(w, "failed to fetch sync run", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT e.id, e.target_id, t.remote_url, e.status, e.error, e.source_url, e.started_at, e.finished_at
+		 FROM executions e JOIN replication_targets t ON t.id = e.target_id
+		 WHERE e.run_id = $1 ORDER BY e.started_at`, run.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch executions of sync run %s: %v", run.ID, err)
+		http.Error(w, "failed to fetch executions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	detail := models.SyncRunDetail{SyncRun: run, Results: []models.SyncRunResult{}}
+	for rows.Next() {
+		var res models.SyncRunResult
+		if err := rows.Scan(&res.ExecutionID, &res.TargetID, &res.RemoteURL, &res.Status, &res.Error, &res.SourceURL,
+			&res.StartedAt, &res.FinishedAt); err != nil {
+			http.Error(w, "failed to scan execution", http.StatusInternalServerError)
+			return
+		}
+		if res.FinishedAt != nil {
+			d := res.FinishedAt.Sub(res.StartedAt).Seconds()
+			res.DurationSeconds = &d
+		}
+		detail.Results = append(detail.Results, res)
+	}
+
+	writeBody(w, r, http.StatusOK, detail)
+}
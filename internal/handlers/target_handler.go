@@ -2,80 +2,94 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
-	"time"
 
+	"gitsync/internal/audit"
+	"gitsync/internal/auth"
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
 	"gitsync/internal/database"
+	"gitsync/internal/deploykeys"
+	"gitsync/internal/ids"
 	"gitsync/internal/models"
+	"gitsync/internal/policy"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+	"gitsync/internal/trash"
+	"gitsync/internal/validation"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
 // TargetHandler handles target-related HTTP requests
 type TargetHandler struct {
-	DB *database.DB
+	DB          *database.DB
+	Credentials *credentials.Store
+	URLPolicy   validation.URLPolicy
+	Policies    *policy.Engine
+	// RequireApproval holds targets created by non-admins in
+	// pending_approval until an admin approves them
+	RequireApproval bool
+	// RBAC scopes listings of non-admins to the targets of their teams'
+	// repositories
+	RBAC  bool
+	Cache *cache.Cache
+	// Runner compares targets with their source
+	Runner *replication.Runner
+	// Trash keeps deleted targets for restoring
+	Trash *trash.Bin
+	// DeployKeys are added to the remotes of SSH targets
+	DeployKeys *deploykeys.Manager
+	// Client deletes the remotes of deleted targets on request
+	Client *provider.Client
+	Clock  clock.Clock
+	IDs    ids.Generator
 }
 
 // NewTargetHandler creates a new TargetHandler
-func NewTargetHandler(db *database.DB) *TargetHandler {
-	return &TargetHandler{DB: db}
+func NewTargetHandler(db *database.DB, creds *credentials.Store, urls validation.URLPolicy, policies *policy.Engine, requireApproval, rbac bool, c *cache.Cache, runner *replication.Runner, bin *trash.Bin, keys *deploykeys.Manager, client *provider.Client, clk clock.Clock, gen ids.Generator) *TargetHandler {
+	return &TargetHandler{DB: db, Credentials: creds, URLPolicy: urls, Policies: policies, RequireApproval: requireApproval, RBAC: rbac, Cache: c, Runner: runner, Trash: bin, DeployKeys: keys, Client: client, Clock: clk, IDs: gen}
 }
 
 // CreateTarget handles POST /repositories/{id}/targets
-// @Summary Create a replication target
-// @Description Add a replication target to an existing repository
-// @Tags targets
-// @Accept json
-// @Produce json
-// @Param id path string true "Repository ID"
-// @Param target body models.CreateTargetRequest true "Target data"
-// @Success 201 {object} models.Target
-// @Router /repositories/{id}/targets [post]
 func (h *TargetHandler) CreateTarget(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repoID := vars["id"]
 
-	// Verify repository exists
-	var exists bool
-	err := h.DB.QueryRowContext(context.Background(),
-		"SELECT EXISTS(SELECT 1 FROM repositories WHERE id = $1)", repoID).Scan(&exists)
-	if err != nil || !exists {
+	// Verify repository exists; its labels decide which policies apply
+	repo := models.Repository{ID: repoID}
+	err := h.DB.QueryRowContext(r.Context(),
+		"SELECT name, source_provider, source_url, labels FROM repositories WHERE id = $1", repoID).
+		Scan(&repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.Labels)
+	if err != nil {
 		http.Error(w, "repository not found", http.StatusNotFound)
 		return
 	}
 
 	var req models.CreateTargetRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate provider
-	if strings.TrimSpace(req.Provider) == "" {
-		http.Error(w, "provider is required", http.StatusBadRequest)
+	if !decodeBody(w, r, &req) {
 		return
 	}
-	if !allowedProviders[req.Provider] {
-		http.Error(w, "invalid provider. allowed: github, gitlab, gitea", http.StatusBadRequest)
-		return
-	}
-
-	// Validate URL
-	if strings.TrimSpace(req.RemoteURL) == "" {
-		http.Error(w, "remote_url is required", http.StatusBadRequest)
-		return
-	}
-	if !strings.HasPrefix(req.RemoteURL, "https://") && !strings.HasPrefix(req.RemoteURL, "ssh://") {
-		http.Error(w, "remote_url must start with https:// or ssh://", http.StatusBadRequest)
+	secret, err := h.validateTargetRequest(&req, repo.SourceURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Verify if target URL already exists for this repository
 	var target_exists bool
-	if err := h.DB.QueryRowContext(context.Background(),
+	if err := h.DB.QueryRowContext(r.Context(),
 		"SELECT EXISTS(SELECT 1 FROM replication_targets WHERE repository_id = $1 AND remote_url = $2)",
 		repoID, req.RemoteURL).Scan(&target_exists); err != nil {
 		log.Printf("ERROR: failed to check if target exists: %v", err)
@@ -88,25 +102,969 @@ func (h *TargetHandler) CreateTarget(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	target := models.Target{
-		RepositoryID: repoID,
-		Provider:     req.Provider,
-		RemoteURL:    req.RemoteURL,
-		CreatedAt:    time.Now(),
+	ctx := r.Context()
+	credentialID, ok := resolveURLCredential(r, w, h.DB, h.Credentials, req.Credential, secret, req.Provider, req.RemoteURL)
+	if !ok {
+		return
 	}
 
-	ctx := context.Background()
-	err = h.DB.QueryRowContext(ctx,
-		`INSERT INTO replication_targets (repository_id, provider, remote_url, created_at) 
-		 VALUES ($1, $2, $3, $4) 
-		 RETURNING id`,
-		target.RepositoryID, target.Provider, target.RemoteURL, target.CreatedAt).Scan(&target.ID)
+	target := h.newTarget(r, repoID, req, credentialID)
+	if p, ok := auth.FromContext(r.Context()); ok {
+		target.CreatedBy = &p.User
+	}
+
+	if !enforcePolicy(ctx, w, h.Policies, policy.Subject{Repository: repo, Target: &target}) {
+		return
+	}
+	target.ID = h.IDs.NewID()
+
+	_, err = h.DB.ExecContext(ctx,
+		`INSERT INTO replication_targets (id, repository_id, provider, remote_url, credential_id, priority, required, exclude_refs, mode, tag_pattern, annotated_tags_only, subdirectory, rewrite, http_headers, protected, approval_state, created_by, external_id, created_at) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		target.ID, target.RepositoryID, target.Provider, target.RemoteURL, target.CredentialID, target.Priority, target.Required,
+		pq.Array(target.ExcludeRefs), target.Mode, target.TagPattern, target.AnnotatedTagsOnly, target.Subdirectory, target.Rewrite, target.HTTPHeaders, target.Protected, target.ApprovalState, target.CreatedBy,
+		target.ExternalID, target.CreatedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "target with this remote_url or external_id already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "failed to create target", http.StatusInternalServerError)
 		return
 	}
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "target.create", "target", target.ID,
+		map[string]any{"repository_id": repoID, "remote_url": target.RemoteURL, "approval_state": target.ApprovalState, "protected": target.Protected})
+	ensureDeployKeys(h.DeployKeys, target)
+
+	writeBody(w, r, http.StatusCreated, target)
+}
+
+// BulkCreateTargets handles POST /targets/bulk, adding a target to each
+// repository listed with its remote URL filled in from the repository's
+// source. Every target is validated first; with validate=true nothing is
+// written, and neither is anything when a target is invalid.
+func (h *TargetHandler) BulkCreateTargets(w http.ResponseWriter, r *http.Request) {
+	var req models.BulkTargetRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	req.RepositoryIDs = slices.Compact(slices.Sorted(slices.Values(req.RepositoryIDs)))
+	switch {
+	case len(req.RepositoryIDs) == 0:
+		http.Error(w, "repository_ids is required", http.StatusBadRequest)
+		return
+	case len(req.RepositoryIDs) > maxImportRepositories:
+		http.Error(w, fmt.Sprintf("at most %d targets can be created at once", maxImportRepositories), http.StatusBadRequest)
+		return
+	case len(req.RepositoryIDs) > 1 && !provider.IsTemplate(req.Target.RemoteURL):
+		http.Error(w, "remote_url must be a template using "+strings.Join(provider.Placeholders, ", ")+" so each repository gets a remote of its own", http.StatusBadRequest)
+		return
+	case len(req.RepositoryIDs) > 1 && strings.TrimSpace(req.Target.ExternalID) != "":
+		http.Error(w, "external_id cannot be shared by many targets", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	repos := map[string]models.Repository{}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, labels, managed_by FROM repositories WHERE id = ANY($1)`, pq.Array(req.RepositoryIDs))
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_ids must be valid UUIDs", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repositories: %v", err)
+		http.Error(w, "failed to fetch repositories", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var repo models.Repository
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.Labels, &repo.ManagedBy); err != nil {
+			rows.Close()
+			log.Printf("ERROR: failed to scan repository: %v", err)
+			http.Error(w, "failed to fetch repositories", http.StatusInternalServerError)
+			return
+		}
+		repos[repo.ID] = repo
+	}
+	rows.Close()
+
+	credentialID, ok := resolveCredential(ctx, w, h.Credentials, req.Target.Credential, req.Target.Provider)
+	if !ok {
+		return
+	}
+	policies, err := h.Policies.Enabled(ctx)
+	if err != nil {
+		log.Printf("ERROR: failed to evaluate policies: %v", err)
+		http.Error(w, "failed to evaluate policies", http.StatusInternalServerError)
+		return
+	}
+
+	res := models.BulkTargetResult{ValidateOnly: r.URL.Query().Get("validate") == "true", Errors: []models.BulkTargetError{}}
+	fail := func(repoID, remoteURL string, err error) {
+		res.Errors = append(res.Errors, models.BulkTargetError{RepositoryID: repoID, RemoteURL: remoteURL, Error: err.Error()})
+	}
+	var targets []models.Target
+	remotes := map[string]string{}
+	for _, id := range req.RepositoryIDs {
+		repo, found := repos[id]
+		if !found {
+			fail(id, "", errors.New("repository not found"))
+			continue
+		}
+		if repo.ManagedBy != nil {
+			fail(id, "", errors.New("repository is managed by "+*repo.ManagedBy))
+			continue
+		}
+		treq := req.Target
+		secret, err := h.validateTargetRequest(&treq, repo.SourceURL)
+		if err != nil {
+			fail(id, "", err)
+			continue
+		}
+		if secret != "" {
+			fail(id, treq.RemoteURL, errors.New("remote_url must not embed credentials; name a credential instead"))
+			continue
+		}
+		if other, dup := remotes[treq.RemoteURL]; dup {
+			fail(id, treq.RemoteURL, errors.New("remote_url is the same as that of repository "+other))
+			continue
+		}
+		remotes[treq.RemoteURL] = id
+
+		target := h.newTarget(r, id, treq, credentialID)
+		if p, ok := auth.FromContext(ctx); ok {
+			target.CreatedBy = &p.User
+		}
+		if err := h.Policies.EnforceWith(ctx, policy.StageCreate, policies, policy.Subject{Repository: repo, Target: &target}); err != nil {
+			fail(id, treq.RemoteURL, err)
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	existing, err := h.DB.QueryContext(ctx,
+		`SELECT repository_id, remote_url FROM replication_targets WHERE repository_id = ANY($1) AND remote_url = ANY($2)`,
+		pq.Array(req.RepositoryIDs), pq.Array(slices.Collect(maps.Keys(remotes))))
+	if err != nil {
+		log.Printf("ERROR: failed to check if targets exist: %v", err)
+		http.Error(w, "failed to check target existence", http.StatusInternalServerError)
+		return
+	}
+	for existing.Next() {
+		var repoID, remoteURL string
+		if err := existing.Scan(&repoID, &remoteURL); err != nil {
+			existing.Close()
+			log.Printf("ERROR: failed to scan target: %v", err)
+			http.Error(w, "failed to check target existence", http.StatusInternalServerError)
+			return
+		}
+		if remotes[remoteURL] == repoID {
+			fail(repoID, remoteURL, errors.New("target with this remote_url already exists for this repository"))
+		}
+	}
+	existing.Close()
+
+	res.Targets = len(targets)
+	if len(res.Errors) > 0 {
+		writeBody(w, r, http.StatusUnprocessableEntity, res)
+		return
+	}
+	if res.ValidateOnly {
+		writeBody(w, r, http.StatusOK, res)
+		return
+	}
+
+	for i := range targets {
+		targets[i].ID = h.IDs.NewID()
+	}
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("ERROR: failed to begin transaction: %v", err)
+		http.Error(w, "failed to create targets", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	err = insertTargets(ctx, tx, targets)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "target with one of these remote_urls already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to create targets: %v", err)
+		http.Error(w, "failed to create targets", http.StatusInternalServerError)
+		return
+	}
+
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "target.bulk_create", "target", "",
+		map[string]any{"count": len(targets), "remote_url": req.Target.RemoteURL, "repository_ids": req.RepositoryIDs})
+	ensureDeployKeys(h.DeployKeys, targets...)
+	res.Created = targets
+	writeBody(w, r, http.StatusCreated, res)
+}
+
+// insertTargets creates targets within tx
+func insertTargets(ctx context.Context, tx *sql.Tx, targets []models.Target) error {
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT INTO replication_targets (id, repository_id, provider, remote_url, credential_id, priority, required, exclude_refs, mode, tag_pattern, annotated_tags_only, subdirectory, rewrite, http_headers, protected, approval_state, created_by, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range targets {
+		if _, err := stmt.ExecContext(ctx, t.ID, t.RepositoryID, t.Provider, t.RemoteURL, t.CredentialID, t.Priority, t.Required,
+			pq.Array(t.ExcludeRefs), t.Mode, t.TagPattern, t.AnnotatedTagsOnly, t.Subdirectory, t.Rewrite, t.HTTPHeaders, t.Protected,
+			t.ApprovalState, t.CreatedBy, t.ExternalID, t.CreatedAt); err != nil {
+			return fmt.Errorf("failed to insert target %s: %w", t.RemoteURL, err)
+		}
+	}
+	return nil
+}
+
+// ApproveTarget handles POST /targets/{id}/approve
+func (h *TargetHandler) ApproveTarget(w http.ResponseWriter, r *http.Request) {
+	p, ok := auth.FromContext(r.Context())
+	if !ok || !p.Admin {
+		http.Error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	var target models.Target
+	err := h.DB.QueryRowContext(r.Context(),
+		`UPDATE replication_targets
+		 SET approval_state = $2,
+		     approved_by = CASE WHEN approval_state = $2 THEN approved_by ELSE $3 END,
+		     approved_at = CASE WHEN approval_state = $2 THEN approved_at ELSE $4 END
+		 WHERE id = $1
+		 RETURNING `+targetColumns,
+		mux.Vars(r)["id"], models.ApprovalApproved, p.User, h.Clock.Now()).
+		Scan(targetFields(&target)...)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to approve target: %v", err)
+		http.Error(w, "failed to approve target", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Target %s approved by %s", target.ID, p.User)
+	h.Cache.Invalidate(r.Context(), cache.Repositories)
+	recordAudit(r, h.DB, "target.approve", "target", target.ID, map[string]any{"remote_url": target.RemoteURL})
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(target)
 }
+
+// targetColumns is the column list read by targetFields
+const targetColumns = `id, repository_id, provider, remote_url, credential_id, priority, required, exclude_refs, mode, tag_pattern, annotated_tags_only, subdirectory, rewrite, http_headers, protected, approval_state, created_by, approved_by, approved_at, external_id, created_at, sync_failures, retry_at, dead_lettered_at`
+
+// targetFields returns the scan destinations of targetColumns
+func targetFields(t *models.Target) []any {
+	return []any{&t.ID, &t.RepositoryID, &t.Provider, &t.RemoteURL, &t.CredentialID, &t.Priority, &t.Required, pq.Array(&t.ExcludeRefs),
+		&t.Mode, &t.TagPattern, &t.AnnotatedTagsOnly, &t.Subdirectory, &t.Rewrite, &t.HTTPHeaders, &t.Protected, &t.ApprovalState, &t.CreatedBy, &t.ApprovedBy, &t.ApprovedAt, &t.ExternalID, &t.CreatedAt,
+		&t.SyncFailures, &t.RetryAt, &t.DeadLetteredAt}
+}
+
+// validateTargetRequest checks req and normalizes its URL, filling in the
+// placeholders of a template from sourceURL, and returns the secret taken
+// off it when the policy extracts embedded credentials. Errors are meant
+// for a 400 response.
+func (h *TargetHandler) validateTargetRequest(req *models.CreateTargetRequest, sourceURL string) (string, error) {
+	if strings.TrimSpace(req.Provider) == "" {
+		return "", errors.New("provider is required")
+	}
+	if _, err := provider.Lookup(req.Provider); err != nil {
+		return "", fmt.Errorf("invalid provider: %w", err)
+	}
+	if strings.TrimSpace(req.RemoteURL) == "" {
+		return "", errors.New("remote_url is required")
+	}
+	remoteURL, err := provider.ExpandTemplate(req.RemoteURL, sourceURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote_url: %w", err)
+	}
+	remoteURL, secret := h.URLPolicy.SplitCredentials(remoteURL)
+	remoteURL, err = h.URLPolicy.RepoURL(req.Provider, remoteURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote_url: %w", err)
+	}
+	req.RemoteURL = remoteURL
+	req.ExternalID = strings.TrimSpace(req.ExternalID)
+	if err := replication.ValidateRefPatterns(req.ExcludeRefs); err != nil {
+		return "", fmt.Errorf("invalid exclude_refs: %w", err)
+	}
+	if req.Mode == "" {
+		req.Mode = models.TargetModeAll
+	}
+	req.TagPattern = strings.TrimSpace(req.TagPattern)
+	req.Subdirectory = strings.TrimSpace(req.Subdirectory)
+	if err := replication.ValidateMode(req.Mode, req.TagPattern, req.AnnotatedTagsOnly, req.Subdirectory); err != nil {
+		return "", err
+	}
+	if err := replication.ValidateRewrite(req.Rewrite); err != nil {
+		return "", fmt.Errorf("invalid rewrite: %w", err)
+	}
+	if err := replication.ValidateHeaders(req.HTTPHeaders); err != nil {
+		return "", fmt.Errorf("invalid http_headers: %w", err)
+	}
+	return secret, nil
+}
+
+// newTarget builds the target described by a validated request, without
+// an ID
+func (h *TargetHandler) newTarget(r *http.Request, repoID string, req models.CreateTargetRequest, credentialID *string) models.Target {
+	target := models.Target{
+		RepositoryID:      repoID,
+		Provider:          req.Provider,
+		RemoteURL:         req.RemoteURL,
+		CredentialID:      credentialID,
+		Priority:          models.DefaultTargetPriority,
+		Required:          true,
+		ExcludeRefs:       req.ExcludeRefs,
+		Mode:              req.Mode,
+		AnnotatedTagsOnly: req.AnnotatedTagsOnly,
+		Rewrite:           req.Rewrite,
+		HTTPHeaders:       req.HTTPHeaders,
+		Protected:         req.Protected,
+		ApprovalState:     h.approvalState(r),
+		CreatedAt:         h.Clock.Now(),
+	}
+	if req.TagPattern != "" {
+		target.TagPattern = &req.TagPattern
+	}
+	if req.Subdirectory != "" {
+		target.Subdirectory = &req.Subdirectory
+	}
+	if target.ExcludeRefs == nil {
+		target.ExcludeRefs = []string{}
+	}
+	if req.Priority != nil {
+		target.Priority = *req.Priority
+	}
+	if req.Required != nil {
+		target.Required = *req.Required
+	}
+	if req.ExternalID != "" {
+		target.ExternalID = &req.ExternalID
+	}
+	return target
+}
+
+// approvalState is the initial approval state of a target created by the
+// caller of r
+func (h *TargetHandler) approvalState(r *http.Request) string {
+	if h.RequireApproval && !auth.IsAdmin(r.Context()) {
+		return models.ApprovalPending
+	}
+	return models.ApprovalApproved
+}
+
+// ListTargets handles GET /targets
+func (h *TargetHandler) ListTargets(w http.ResponseWriter, r *http.Request) {
+	var conds []string
+	var args []any
+	for _, filter := range []struct{ param, column string }{
+		{"repository_id", "t.repository_id"},
+		{"remote_url", "t.remote_url"},
+		{"external_id", "t.external_id"},
+	} {
+		if v := r.URL.Query().Get(filter.param); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf("%s = $%d", filter.column, len(args)))
+		}
+	}
+	switch r.URL.Query().Get("dead_lettered") {
+	case "":
+	case "true":
+		conds = append(conds, "t.dead_lettered_at IS NOT NULL")
+	case "false":
+		conds = append(conds, "t.dead_lettered_at IS NULL")
+	default:
+		http.Error(w, "dead_lettered must be true or false", http.StatusBadRequest)
+		return
+	}
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, conds, args)
+	if !ok {
+		return
+	}
+	h.listTargets(w, r, conds, args)
+}
+
+// ListRepositoryTargets handles GET /repositories/{id}/targets
+func (h *TargetHandler) ListRepositoryTargets(w http.ResponseWriter, r *http.Request) {
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"r.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return
+	}
+	var exists bool
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT EXISTS(SELECT 1 FROM repositories r WHERE `+strings.Join(conds, " AND ")+`)`, args...).Scan(&exists)
+	if isInvalidUUID(err) || (err == nil && !exists) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository: %v", err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+	h.listTargets(w, r, conds, args)
+}
+
+// listTargets responds with the targets matching conds, which refer to the
+// targets as t and their repositories as r
+func (h *TargetHandler) listTargets(w http.ResponseWriter, r *http.Request, conds []string, args []any) {
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+prefixColumns("t", targetColumns)+`
+		 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 `+where+`
+		 ORDER BY t.repository_id, t.priority, t.created_at`, args...)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch targets: %v", err)
+		http.Error(w, "failed to fetch targets", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	targets := []models.Target{}
+	for rows.Next() {
+		var t models.Target
+		if err := rows.Scan(targetFields(&t)...); err != nil {
+			http.Error(w, "failed to scan target", http.StatusInternalServerError)
+			return
+		}
+		targets = append(targets, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targets)
+}
+
+// GetTarget handles GET /targets/{id}
+func (h *TargetHandler) GetTarget(w http.ResponseWriter, r *http.Request) {
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"t.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return
+	}
+	h.getTarget(w, r, conds, args)
+}
+
+// GetRepositoryTarget handles GET /repositories/{id}/targets/{target_id}
+func (h *TargetHandler) GetRepositoryTarget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"t.id = $1", "t.repository_id = $2"}, []any{vars["target_id"], vars["id"]})
+	if !ok {
+		return
+	}
+	h.getTarget(w, r, conds, args)
+}
+
+// getTarget responds with the target matching conds, which refer to it as
+// t and to its repository as r
+func (h *TargetHandler) getTarget(w http.ResponseWriter, r *http.Request, conds []string, args []any) {
+	var target models.Target
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT `+prefixColumns("t", targetColumns)+`
+		 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 WHERE `+strings.Join(conds, " AND "), args...).
+		Scan(targetFields(&target)...)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch target: %v", err)
+		http.Error(w, "failed to fetch target", http.StatusInternalServerError)
+		return
+	}
+
+	writeBody(w, r, http.StatusOK, target)
+}
+
+// RedriveTarget handles POST /targets/{id}/redrive, giving a dead-lettered
+// target its attempts back and queueing a sync of it. When the repository
+// is queued or syncing already, its next sync includes the target again.
+func (h *TargetHandler) RedriveTarget(w http.ResponseWriter, r *http.Request) {
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"t.id = $1"}, []any{mux.Vars(r)["id"]})
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var target models.Target
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT `+prefixColumns("t", targetColumns)+`
+		 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 WHERE `+strings.Join(conds, " AND "), args...).
+		Scan(targetFields(&target)...)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch target: %v", err)
+		http.Error(w, "failed to fetch target", http.StatusInternalServerError)
+		return
+	}
+	if target.DeadLetteredAt == nil {
+		http.Error(w, "target is not dead-lettered", http.StatusConflict)
+		return
+	}
+
+	res := models.TargetRedrive{TargetID: target.ID}
+	run, err := h.Runner.Redrive(ctx, target, audit.Actor(ctx))
+	switch {
+	case errors.Is(err, replication.ErrBusy):
+		res.Detail = "repository is already queued or syncing; its next sync includes the target"
+	case err != nil:
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to re-drive target", http.StatusInternalServerError)
+		return
+	default:
+		res.Run = &run
+	}
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	details := map[string]any{"repository_id": target.RepositoryID, "sync_failures": target.SyncFailures}
+	if res.Run != nil {
+		details["run_id"] = res.Run.ID
+	}
+	recordAudit(r, h.DB, "target.redrive", "target", target.ID, details)
+
+	writeBody(w, r, http.StatusAccepted, res)
+}
+
+// DiffTarget handles GET /repositories/{id}/targets/{target_id}/diff. It
+// fetches from the source and the target to count how far each branch of
+// the target is behind or ahead of the source, so drift can be judged
+// before a forced sync.
+func (h *TargetHandler) DiffTarget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"t.id = $1", "t.repository_id = $2"}, []any{vars["target_id"], vars["id"]})
+	if !ok {
+		return
+	}
+
+	var target models.Target
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT `+prefixColumns("t", targetColumns)+`
+		 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 WHERE `+strings.Join(conds, " AND "), args...).
+		Scan(targetFields(&target)...)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch target: %v", err)
+		http.Error(w, "failed to fetch target", http.StatusInternalServerError)
+		return
+	}
+
+	var diff models.TargetDiff
+	err = h.Runner.Do(r.Context(), target.RepositoryID, func(ctx context.Context) error {
+		repo, err := h.Runner.Repository(ctx, target.RepositoryID)
+		if err != nil {
+			return err
+		}
+		diff, err = h.Runner.Syncer.Diff(ctx, repo, target)
+		return err
+	})
+	if errors.Is(err, replication.ErrBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to compare target %s: %v", target.ID, err)
+		http.Error(w, "failed to compare target: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// PutTarget handles PUT /repositories/{id}/targets/{target_id}. It creates
+// the target with the given ID or replaces the fields of an existing one.
+// Changing the remote URL of an approved target requires approval again.
+func (h *TargetHandler) PutTarget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ctx := r.Context()
+
+	repo := models.Repository{ID: vars["id"]}
+	err := h.DB.QueryRowContext(ctx,
+		"SELECT name, source_provider, source_url, labels, managed_by FROM repositories WHERE id = $1", repo.ID).
+		Scan(&repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.Labels, &repo.ManagedBy)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository: %v", err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+	if repo.ManagedBy != nil {
+		http.Error(w, "repository is managed by "+*repo.ManagedBy, http.StatusConflict)
+		return
+	}
+
+	var req models.CreateTargetRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	secret, err := h.validateTargetRequest(&req, repo.SourceURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	credentialID, ok := resolveURLCredential(r, w, h.DB, h.Credentials, req.Credential, secret, req.Provider, req.RemoteURL)
+	if !ok {
+		return
+	}
+
+	var existing models.Target
+	err = h.DB.QueryRowContext(ctx,
+		`SELECT `+targetColumns+` FROM replication_targets WHERE id = $1`, vars["target_id"]).
+		Scan(targetFields(&existing)...)
+	found := err == nil
+	if isInvalidUUID(err) {
+		http.Error(w, "target_id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("ERROR: failed to fetch target: %v", err)
+		http.Error(w, "failed to fetch target", http.StatusInternalServerError)
+		return
+	}
+	if found && existing.RepositoryID != repo.ID {
+		http.Error(w, "target belongs to another repository", http.StatusConflict)
+		return
+	}
+
+	target := h.newTarget(r, repo.ID, req, credentialID)
+	target.ID = vars["target_id"]
+	if found {
+		target.CreatedBy, target.CreatedAt = existing.CreatedBy, existing.CreatedAt
+		// An approval covers the URL that was approved
+		if existing.RemoteURL == target.RemoteURL {
+			target.ApprovalState, target.ApprovedBy, target.ApprovedAt =
+				existing.ApprovalState, existing.ApprovedBy, existing.ApprovedAt
+		}
+	} else if p, ok := auth.FromContext(r.Context()); ok {
+		target.CreatedBy = &p.User
+	}
+
+	if !enforcePolicy(ctx, w, h.Policies, policy.Subject{Repository: repo, Target: &target}) {
+		return
+	}
+
+	_, err = h.DB.ExecContext(ctx,
+		`INSERT INTO replication_targets (id, repository_id, provider, remote_url, credential_id, priority, required, exclude_refs, mode, tag_pattern, annotated_tags_only, subdirectory, rewrite, http_headers, protected, approval_state, created_by, approved_by, approved_at, external_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		 ON CONFLICT (id) DO UPDATE
+		 SET provider = EXCLUDED.provider, remote_url = EXCLUDED.remote_url, credential_id = EXCLUDED.credential_id,
+		     priority = EXCLUDED.priority, required = EXCLUDED.required, exclude_refs = EXCLUDED.exclude_refs,
+		     mode = EXCLUDED.mode, tag_pattern = EXCLUDED.tag_pattern, annotated_tags_only = EXCLUDED.annotated_tags_only,
+		     subdirectory = EXCLUDED.subdirectory, rewrite = EXCLUDED.rewrite, http_headers = EXCLUDED.http_headers, protected = EXCLUDED.protected, approval_state = EXCLUDED.approval_state, approved_by = EXCLUDED.approved_by,
+		     approved_at = EXCLUDED.approved_at, external_id = EXCLUDED.external_id`,
+		target.ID, target.RepositoryID, target.Provider, target.RemoteURL, target.CredentialID, target.Priority, target.Required,
+		pq.Array(target.ExcludeRefs), target.Mode, target.TagPattern, target.AnnotatedTagsOnly, target.Subdirectory, target.Rewrite, target.HTTPHeaders, target.Protected, target.ApprovalState, target.CreatedBy,
+		target.ApprovedBy, target.ApprovedAt, target.ExternalID, target.CreatedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "target with this remote_url or external_id already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to upsert target: %v", err)
+		http.Error(w, "failed to save target", http.StatusInternalServerError)
+		return
+	}
+
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	action, status := "target.update", http.StatusOK
+	if !found {
+		action, status = "target.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "target", target.ID,
+		map[string]any{"repository_id": repo.ID, "remote_url": target.RemoteURL, "approval_state": target.ApprovalState, "protected": target.Protected})
+	ensureDeployKeys(h.DeployKeys, target)
+
+	writeBody(w, r, status, target)
+}
+
+// PatchTarget handles PATCH /repositories/{id}/targets/{target_id},
+// changing the fields the request sets. The result is validated as a new
+// target would be, and changing the remote URL requires approval again.
+func (h *TargetHandler) PatchTarget(w http.ResponseWriter, r *http.Request) {
+	var patch models.UpdateTargetRequest
+	if !decodeBody(w, r, &patch) {
+		return
+	}
+	if patch.RemoteURL == nil && patch.Credential == nil && patch.Priority == nil && patch.Required == nil &&
+		patch.ExcludeRefs == nil && patch.Protected == nil {
+		http.Error(w, "no fields to update", http.StatusBadRequest)
+		return
+	}
+	vars := mux.Vars(r)
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"t.id = $1", "t.repository_id = $2"}, []any{vars["target_id"], vars["id"]})
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var existing models.Target
+	var repo models.Repository
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT `+prefixColumns("t", targetColumns)+`, r.name, r.source_provider, r.source_url, r.labels, r.managed_by
+		 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 WHERE `+strings.Join(conds, " AND "), args...).
+		Scan(append(targetFields(&existing), &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.Labels, &repo.ManagedBy)...)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch target: %v", err)
+		http.Error(w, "failed to fetch target", http.StatusInternalServerError)
+		return
+	}
+	if repo.ManagedBy != nil {
+		http.Error(w, "repository is managed by "+*repo.ManagedBy, http.StatusConflict)
+		return
+	}
+	repo.ID = existing.RepositoryID
+
+	req := models.CreateTargetRequest{
+		Provider: existing.Provider, RemoteURL: existing.RemoteURL, Priority: &existing.Priority, Required: &existing.Required,
+		ExcludeRefs: existing.ExcludeRefs, Mode: existing.Mode, AnnotatedTagsOnly: existing.AnnotatedTagsOnly,
+		Rewrite: existing.Rewrite, HTTPHeaders: existing.HTTPHeaders, Protected: existing.Protected,
+	}
+	if existing.TagPattern != nil {
+		req.TagPattern = *existing.TagPattern
+	}
+	if existing.Subdirectory != nil {
+		req.Subdirectory = *existing.Subdirectory
+	}
+	changes := map[string]any{"repository_id": repo.ID}
+	if patch.RemoteURL != nil {
+		req.RemoteURL = *patch.RemoteURL
+	}
+	if patch.Priority != nil {
+		req.Priority, changes["priority"] = patch.Priority, *patch.Priority
+	}
+	if patch.Required != nil {
+		req.Required, changes["required"] = patch.Required, *patch.Required
+	}
+	if patch.ExcludeRefs != nil {
+		req.ExcludeRefs, changes["exclude_refs"] = patch.ExcludeRefs, patch.ExcludeRefs
+	}
+	if patch.Protected != nil {
+		req.Protected, changes["protected"] = *patch.Protected, *patch.Protected
+	}
+	secret, err := h.validateTargetRequest(&req, repo.SourceURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target := existing
+	target.RemoteURL, target.Priority, target.Required = req.RemoteURL, *req.Priority, *req.Required
+	target.ExcludeRefs, target.Protected = req.ExcludeRefs, req.Protected
+	if target.ExcludeRefs == nil {
+		target.ExcludeRefs = []string{}
+	}
+	switch {
+	case patch.Credential != nil:
+		name := *patch.Credential
+		if target.CredentialID, ok = resolveURLCredential(r, w, h.DB, h.Credentials, name, secret, target.Provider, target.RemoteURL); !ok {
+			return
+		}
+		changes["credential"] = name
+	case secret != "":
+		if target.CredentialID, ok = resolveURLCredential(r, w, h.DB, h.Credentials, "", secret, target.Provider, target.RemoteURL); !ok {
+			return
+		}
+	}
+	// An approval covers the URL that was approved
+	if target.RemoteURL != existing.RemoteURL {
+		target.ApprovalState, target.ApprovedBy, target.ApprovedAt = h.approvalState(r), nil, nil
+		changes["remote_url"], changes["approval_state"] = target.RemoteURL, target.ApprovalState
+	}
+	if !enforcePolicy(ctx, w, h.Policies, policy.Subject{Repository: repo, Target: &target}) {
+		return
+	}
+
+	_, err = h.DB.ExecContext(ctx,
+		`UPDATE replication_targets SET remote_url = $2, credential_id = $3, priority = $4, required = $5, exclude_refs = $6,
+		     protected = $7, approval_state = $8, approved_by = $9, approved_at = $10
+		 WHERE id = $1`,
+		target.ID, target.RemoteURL, target.CredentialID, target.Priority, target.Required, pq.Array(target.ExcludeRefs),
+		target.Protected, target.ApprovalState, target.ApprovedBy, target.ApprovedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "target with this remote_url already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update target %s: %v", target.ID, err)
+		http.Error(w, "failed to update target", http.StatusInternalServerError)
+		return
+	}
+
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "target.update", "target", target.ID, changes)
+	if target.RemoteURL != existing.RemoteURL {
+		ensureDeployKeys(h.DeployKeys, target)
+	}
+
+	writeBody(w, r, http.StatusOK, target)
+}
+
+// DeleteTarget handles DELETE /targets/{id}
+func (h *TargetHandler) DeleteTarget(w http.ResponseWriter, r *http.Request) {
+	h.deleteTarget(w, r, []string{"t.id = $1"}, []any{mux.Vars(r)["id"]})
+}
+
+// DeleteRepositoryTarget handles DELETE /repositories/{id}/targets/{target_id}
+func (h *TargetHandler) DeleteRepositoryTarget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	h.deleteTarget(w, r, []string{"t.id = $1", "t.repository_id = $2"}, []any{vars["target_id"], vars["id"]})
+}
+
+// deleteTarget moves the target matching conds, which refer to it as t, to
+// the trash along with its sync history. With delete_remote=true, an admin
+// deletes its remote repository at the provider as well, which restoring
+// the target does not undo.
+func (h *TargetHandler) deleteTarget(w http.ResponseWriter, r *http.Request, conds []string, args []any) {
+	deleteRemote := false
+	if raw := r.URL.Query().Get("delete_remote"); raw != "" {
+		var err error
+		if deleteRemote, err = strconv.ParseBool(raw); err != nil {
+			http.Error(w, "delete_remote must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	if deleteRemote && !requireAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "failed to delete target", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var target models.Target
+	var managedBy *string
+	err = tx.QueryRowContext(ctx,
+		`SELECT `+prefixColumns("t", targetColumns)+`, r.managed_by
+		 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 WHERE `+strings.Join(conds, " AND ")+` FOR UPDATE OF t`, args...).
+		Scan(append(targetFields(&target), &managedBy)...)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "target not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch target: %v", err)
+		http.Error(w, "failed to delete target", http.StatusInternalServerError)
+		return
+	}
+	if managedBy != nil {
+		http.Error(w, "repository is managed by "+*managedBy, http.StatusConflict)
+		return
+	}
+
+	if err := h.Trash.Discard(ctx, tx, trash.Target, target.ID, deletedBy(r)); err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to delete target", http.StatusInternalServerError)
+		return
+	}
+	// The remote goes first, so the target is kept when it cannot be
+	// deleted; no sync pushes to it meanwhile
+	if deleteRemote {
+		release, ok := h.Runner.Hold(target.RepositoryID)
+		if !ok {
+			http.Error(w, replication.ErrBusy.Error(), http.StatusConflict)
+			return
+		}
+		defer release()
+		token, err := h.Credentials.Resolve(ctx, target.CredentialID, target.Provider)
+		if err != nil {
+			log.Printf("ERROR: failed to resolve credential of target %s: %v", target.ID, err)
+			http.Error(w, "failed to resolve credential", http.StatusInternalServerError)
+			return
+		}
+		if err := h.Client.DeleteRepository(provider.WithHeaders(ctx, target.HTTPHeaders), target.Provider, target.RemoteURL, token); err != nil {
+			log.Printf("WARN: failed to delete remote of target %s: %v", target.ID, err)
+			http.Error(w, "failed to delete remote repository: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: failed to delete target %s: %v", target.ID, err)
+		http.Error(w, "failed to delete target", http.StatusInternalServerError)
+		return
+	}
+
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	var details map[string]any
+	if deleteRemote {
+		details = map[string]any{"remote_url": target.RemoteURL, "remote_deleted": true}
+	}
+	recordAudit(r, h.DB, "target.delete", "target", target.ID, details)
+	pruneDeployKeys(h.DeployKeys)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ensureDeployKeys adds the deploy keys of SSH targets to their remotes in
+// the background
+func ensureDeployKeys(keys *deploykeys.Manager, targets ...models.Target) {
+	if !keys.Enabled() {
+		return
+	}
+	go func() {
+		for _, t := range targets {
+			if err := keys.Ensure(context.Background(), t); err != nil {
+				log.Printf("WARN: failed to add deploy key for target %s: %v", t.ID, err)
+			}
+		}
+	}()
+}
+
+// pruneDeployKeys removes the deploy keys of deleted targets from their
+// remotes in the background
+func pruneDeployKeys(keys *deploykeys.Manager) {
+	if !keys.Enabled() {
+		return
+	}
+	go func() {
+		if err := keys.Prune(context.Background()); err != nil {
+			log.Printf("WARN: failed to remove deploy keys of deleted targets: %v", err)
+		}
+	}()
+}
+
+// prefixColumns qualifies each column of a comma-separated list with table
+func prefixColumns(table, columns string) string {
+	cols := strings.Split(columns, ", ")
+	for i, c := range cols {
+		cols[i] = table + "." + c
+	}
+	return strings.Join(cols, ", ")
+}
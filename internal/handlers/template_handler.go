@@ -0,0 +1,592 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/cache"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/ids"
+	"gitsync/internal/labels"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+	"gitsync/internal/policy"
+	"gitsync/internal/replication"
+	"gitsync/internal/schedule"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// TemplateHandler handles repository templates, which keep the schedule,
+// filters, push mode, labels and notification routing of many
+// repositories the same
+type TemplateHandler struct {
+	DB *database.DB
+	// Policies are checked against the labels templates give repositories
+	Policies *policy.Engine
+	Cache    *cache.Cache
+	Clock    clock.Clock
+	IDs      ids.Generator
+}
+
+// NewTemplateHandler creates a new TemplateHandler
+func NewTemplateHandler(db *database.DB, policies *policy.Engine, c *cache.Cache, clk clock.Clock, gen ids.Generator) *TemplateHandler {
+	return &TemplateHandler{DB: db, Policies: policies, Cache: c, Clock: clk, IDs: gen}
+}
+
+const templateColumns = `id, name, description, labels, sync_interval_seconds, sync_window, sync_slo_seconds, clone_filter,
+	exclude_refs, mode, tag_pattern, annotated_tags_only, notification_rule_ids, created_by, created_at, updated_at`
+
+func scanTemplate(row interface{ Scan(...any) error }) (models.RepositoryTemplate, error) {
+	var t models.RepositoryTemplate
+	err := row.Scan(&t.ID, &t.Name, &t.Description, &t.Labels, &t.SyncIntervalSeconds, &t.SyncWindow, &t.SyncSLOSeconds, &t.CloneFilter,
+		pq.Array(&t.ExcludeRefs), &t.Mode, &t.TagPattern, &t.AnnotatedTagsOnly, pq.Array(&t.NotificationRuleIDs), &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+// templateFromRequest builds and validates a template from its request
+// body
+func templateFromRequest(req models.RepositoryTemplateRequest) (models.RepositoryTemplate, error) {
+	t := models.RepositoryTemplate{
+		Name:                strings.TrimSpace(req.Name),
+		Labels:              req.Labels,
+		SyncIntervalSeconds: req.SyncIntervalSeconds,
+		SyncWindow:          req.SyncWindow,
+		SyncSLOSeconds:      req.SyncSLOSeconds,
+		ExcludeRefs:         req.ExcludeRefs,
+		AnnotatedTagsOnly:   req.AnnotatedTagsOnly,
+		NotificationRuleIDs: slices.Compact(slices.Sorted(slices.Values(req.NotificationRuleIDs))),
+	}
+	if t.Name == "" {
+		return t, errors.New("name is required")
+	}
+	if description := strings.TrimSpace(req.Description); description != "" {
+		t.Description = &description
+	}
+	if err := labels.Validate(t.Labels); err != nil {
+		return t, fmt.Errorf("invalid labels: %w", err)
+	}
+	if t.Labels == nil {
+		t.Labels = models.Labels{}
+	}
+	if t.NotificationRuleIDs == nil {
+		t.NotificationRuleIDs = []string{}
+	}
+	if t.SyncIntervalSeconds != nil && *t.SyncIntervalSeconds <= 0 {
+		return t, errors.New("sync_interval_seconds must be positive")
+	}
+	if t.SyncSLOSeconds != nil && *t.SyncSLOSeconds <= 0 {
+		return t, errors.New("sync_slo_seconds must be positive")
+	}
+	if t.SyncWindow != nil {
+		if err := schedule.ValidateWindow(*t.SyncWindow); err != nil {
+			return t, fmt.Errorf("invalid sync_window: %w", err)
+		}
+	}
+	if filter := strings.TrimSpace(req.CloneFilter); filter != "" {
+		if err := git.ValidateFilter(filter); err != nil {
+			return t, fmt.Errorf("invalid clone_filter: %w", err)
+		}
+		t.CloneFilter = &filter
+	}
+	if err := replication.ValidateRefPatterns(t.ExcludeRefs); err != nil {
+		return t, fmt.Errorf("invalid exclude_refs: %w", err)
+	}
+
+	tagPattern := strings.TrimSpace(req.TagPattern)
+	switch req.Mode {
+	case "":
+		if tagPattern != "" || req.AnnotatedTagsOnly {
+			return t, errors.New("tag_pattern and annotated_tags_only need mode tags")
+		}
+	case models.TargetModeSubdirectory:
+		return t, errors.New("mode subdirectory cannot be templated; each target needs its own subdirectory")
+	default:
+		if err := replication.ValidateMode(req.Mode, tagPattern, req.AnnotatedTagsOnly, ""); err != nil {
+			return t, err
+		}
+		mode := req.Mode
+		t.Mode = &mode
+	}
+	if tagPattern != "" {
+		t.TagPattern = &tagPattern
+	}
+	return t, nil
+}
+
+// checkRules writes a 400 response and returns false unless every
+// notification rule of t exists and selects repositories with its labels
+func (h *TemplateHandler) checkRules(w http.ResponseWriter, r *http.Request, t models.RepositoryTemplate) bool {
+	if len(t.NotificationRuleIDs) == 0 {
+		return true
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+notify.RuleColumns+` FROM notification_rules WHERE id = ANY($1)`, pq.Array(t.NotificationRuleIDs))
+	if isInvalidUUID(err) {
+		http.Error(w, "notification_rule_ids must be valid UUIDs", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch notification rules: %v", err)
+		http.Error(w, "failed to fetch notification rules", http.StatusInternalServerError)
+		return false
+	}
+	defer rows.Close()
+
+	found := map[string]bool{}
+	for rows.Next() {
+		rule, err := notify.ScanRule(rows)
+		if err != nil {
+			log.Printf("ERROR: failed to scan notification rule: %v", err)
+			http.Error(w, "failed to fetch notification rules", http.StatusInternalServerError)
+			return false
+		}
+		found[rule.ID] = true
+		if rule.LabelSelector == nil {
+			continue
+		}
+		if sel, err := labels.ParseSelector(*rule.LabelSelector); err == nil && !sel.Matches(t.Labels) {
+			http.Error(w, fmt.Sprintf("notification rule %s selects %s, which the labels of the template do not match",
+				rule.Name, *rule.LabelSelector), http.StatusBadRequest)
+			return false
+		}
+	}
+	for _, id := range t.NotificationRuleIDs {
+		if !found[id] {
+			http.Error(w, "notification rule "+id+" not found", http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// decodeTemplate decodes and validates the body of r, writing an error and
+// returning false when it is invalid
+func (h *TemplateHandler) decodeTemplate(w http.ResponseWriter, r *http.Request) (models.RepositoryTemplate, bool) {
+	var req models.RepositoryTemplateRequest
+	if !decodeBody(w, r, &req) {
+		return models.RepositoryTemplate{}, false
+	}
+	t, err := templateFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return t, false
+	}
+	return t, h.checkRules(w, r, t)
+}
+
+// CreateRepositoryTemplate handles POST /repository-templates
+func (h *TemplateHandler) CreateRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	t, ok := h.decodeTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	actor := audit.Actor(r.Context())
+	t.ID, t.CreatedBy, t.CreatedAt = h.IDs.NewID(), &actor, h.Clock.Now()
+	t.UpdatedAt = t.CreatedAt
+	_, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO repository_templates (`+templateColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+		t.ID, t.Name, t.Description, t.Labels, t.SyncIntervalSeconds, t.SyncWindow, t.SyncSLOSeconds, t.CloneFilter,
+		pq.Array(t.ExcludeRefs), t.Mode, t.TagPattern, t.AnnotatedTagsOnly, pq.Array(t.NotificationRuleIDs), t.CreatedBy, t.CreatedAt, t.UpdatedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "repository template with this name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to insert repository template: %v", err)
+		http.Error(w, "failed to create repository template", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, h.DB, "repository_template.create", "repository_template", t.ID, map[string]any{"name": t.Name})
+	writeBody(w, r, http.StatusCreated, t)
+}
+
+// ListRepositoryTemplates handles GET /repository-templates
+func (h *TemplateHandler) ListRepositoryTemplates(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `SELECT `+templateColumns+` FROM repository_templates ORDER BY name`)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository templates: %v", err)
+		http.Error(w, "failed to fetch repository templates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	templates := []models.RepositoryTemplate{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			log.Printf("ERROR: failed to scan repository template: %v", err)
+			http.Error(w, "failed to scan repository template", http.StatusInternalServerError)
+			return
+		}
+		templates = append(templates, t)
+	}
+	writeBody(w, r, http.StatusOK, templates)
+}
+
+// GetRepositoryTemplate handles GET /repository-templates/{id}
+func (h *TemplateHandler) GetRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.template(w, r)
+	if ok {
+		writeBody(w, r, http.StatusOK, t)
+	}
+}
+
+// template fetches the template of the request path, writing an error and
+// returning false when it cannot
+func (h *TemplateHandler) template(w http.ResponseWriter, r *http.Request) (models.RepositoryTemplate, bool) {
+	t, err := scanTemplate(h.DB.QueryRowContext(r.Context(),
+		`SELECT `+templateColumns+` FROM repository_templates WHERE id = $1`, mux.Vars(r)["id"]))
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "repository template not found", http.StatusNotFound)
+		return t, false
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository template: %v", err)
+		http.Error(w, "failed to fetch repository template", http.StatusInternalServerError)
+		return t, false
+	}
+	return t, true
+}
+
+// PutRepositoryTemplate handles PUT /repository-templates/{id}. A template
+// that does not exist yet is created with the given ID. Repositories pick
+// up the changes when the template is re-applied.
+func (h *TemplateHandler) PutRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	t, ok := h.decodeTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	t.ID, t.UpdatedAt = mux.Vars(r)["id"], h.Clock.Now()
+	var created bool
+	err := h.DB.QueryRowContext(r.Context(),
+		`INSERT INTO repository_templates (`+templateColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $15)
+		 ON CONFLICT (id) DO UPDATE
+		 SET name = EXCLUDED.name, description = EXCLUDED.description, labels = EXCLUDED.labels,
+		     sync_interval_seconds = EXCLUDED.sync_interval_seconds, sync_window = EXCLUDED.sync_window,
+		     sync_slo_seconds = EXCLUDED.sync_slo_seconds, clone_filter = EXCLUDED.clone_filter,
+		     exclude_refs = EXCLUDED.exclude_refs, mode = EXCLUDED.mode, tag_pattern = EXCLUDED.tag_pattern,
+		     annotated_tags_only = EXCLUDED.annotated_tags_only, notification_rule_ids = EXCLUDED.notification_rule_ids,
+		     updated_at = EXCLUDED.updated_at
+		 RETURNING created_by, created_at, xmax = 0`,
+		t.ID, t.Name, t.Description, t.Labels, t.SyncIntervalSeconds, t.SyncWindow, t.SyncSLOSeconds, t.CloneFilter,
+		pq.Array(t.ExcludeRefs), t.Mode, t.TagPattern, t.AnnotatedTagsOnly, pq.Array(t.NotificationRuleIDs),
+		audit.Actor(r.Context()), t.UpdatedAt).
+		Scan(&t.CreatedBy, &t.CreatedAt, &created)
+	if isInvalidUUID(err) {
+		http.Error(w, "id must be a valid UUID", http.StatusBadRequest)
+		return
+	}
+	if isUniqueViolation(err) {
+		http.Error(w, "repository template with this name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update repository template: %v", err)
+		http.Error(w, "failed to save repository template", http.StatusInternalServerError)
+		return
+	}
+	action, status := "repository_template.update", http.StatusOK
+	if created {
+		action, status = "repository_template.create", http.StatusCreated
+	}
+	recordAudit(r, h.DB, action, "repository_template", t.ID, map[string]any{"name": t.Name})
+	writeBody(w, r, status, t)
+}
+
+// DeleteRepositoryTemplate handles DELETE /repository-templates/{id}. Its
+// repositories keep their settings.
+func (h *TemplateHandler) DeleteRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "failed to delete repository template", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM repository_templates WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, "repository template not found", http.StatusNotFound)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "repository template not found", http.StatusNotFound)
+		return
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE repositories SET template_id = NULL WHERE template_id = $1`, id)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to delete repository template %s: %v", id, err)
+		http.Error(w, "failed to delete repository template", http.StatusInternalServerError)
+		return
+	}
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "repository_template.delete", "repository_template", id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ApplyRepositoryTemplate handles POST /repository-templates/{id}/apply,
+// setting what the template holds on the listed repositories, or else on
+// every repository of the template, and on all their targets. Repositories
+// managed by GitOps are left alone. Nothing is changed if the new labels
+// of any repository violate a policy.
+func (h *TemplateHandler) ApplyRepositoryTemplate(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req models.ApplyTemplateRequest
+	if r.ContentLength != 0 && !decodeBody(w, r, &req) {
+		return
+	}
+	t, ok := h.template(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	cond, arg := "template_id = $1", any(t.ID)
+	if len(req.RepositoryIDs) > 0 {
+		cond, arg = "id = ANY($1)", pq.Array(req.RepositoryIDs)
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, labels, owner, team, managed_by FROM repositories WHERE `+cond, arg)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository_ids must be valid UUIDs", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repositories: %v", err)
+		http.Error(w, "failed to fetch repositories", http.StatusInternalServerError)
+		return
+	}
+	repos := map[string]models.Repository{}
+	for rows.Next() {
+		var repo models.Repository
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.Labels, &repo.Owner, &repo.Team, &repo.ManagedBy); err != nil {
+			rows.Close()
+			log.Printf("ERROR: failed to scan repository: %v", err)
+			http.Error(w, "failed to fetch repositories", http.StatusInternalServerError)
+			return
+		}
+		repos[repo.ID] = repo
+	}
+	rows.Close()
+	for _, id := range req.RepositoryIDs {
+		repo, found := repos[id]
+		if !found {
+			http.Error(w, "repository "+id+" not found", http.StatusNotFound)
+			return
+		}
+		if repo.ManagedBy != nil {
+			http.Error(w, "repository "+repo.Name+" is managed by "+*repo.ManagedBy, http.StatusConflict)
+			return
+		}
+	}
+
+	policies, err := h.Policies.Enabled(ctx)
+	if err != nil {
+		log.Printf("ERROR: failed to evaluate policies: %v", err)
+		http.Error(w, "failed to evaluate policies", http.StatusInternalServerError)
+		return
+	}
+	var ids []string
+	for _, id := range slices.Sorted(maps.Keys(repos)) {
+		repo := repos[id]
+		if repo.ManagedBy != nil {
+			continue
+		}
+		if repo.Labels == nil {
+			repo.Labels = models.Labels{}
+		}
+		maps.Copy(repo.Labels, t.Labels)
+		err := h.Policies.EnforceWith(ctx, policy.StageCreate, policies, policy.Subject{Repository: repo})
+		var violation *policy.ViolationError
+		if errors.As(err, &violation) {
+			http.Error(w, "repository "+repo.Name+": "+err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: failed to evaluate policies: %v", err)
+			http.Error(w, "failed to evaluate policies", http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	res := models.TemplateApplication{TemplateID: t.ID}
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "failed to apply repository template", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	updated, err := tx.ExecContext(ctx,
+		`UPDATE repositories
+		 SET template_id = $2, labels = labels || $3,
+		     sync_interval_seconds = COALESCE($4, sync_interval_seconds), sync_window = COALESCE($5, sync_window),
+		     sync_slo_seconds = COALESCE($6, sync_slo_seconds), clone_filter = COALESCE($7, clone_filter)
+		 WHERE id = ANY($1) AND managed_by IS NULL`,
+		pq.Array(ids), t.ID, t.Labels, t.SyncIntervalSeconds, t.SyncWindow, t.SyncSLOSeconds, t.CloneFilter)
+	var targets int64
+	if err == nil {
+		n, _ := updated.RowsAffected()
+		res.Repositories = int(n)
+		targets, err = applyTemplateToTargets(ctx, tx, t, ids)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to apply repository template %s: %v", t.ID, err)
+		http.Error(w, "failed to apply repository template", http.StatusInternalServerError)
+		return
+	}
+	res.Targets = int(targets)
+
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, "repository_template.apply", "repository_template", t.ID,
+		map[string]any{"name": t.Name, "repositories": res.Repositories, "targets": res.Targets})
+	writeBody(w, r, http.StatusOK, res)
+}
+
+// applyTemplateToTargets sets the ref filters and push mode of t on the
+// targets of the repositories, returning how many it changed. Targets in
+// mode subdirectory keep their mode.
+func applyTemplateToTargets(ctx context.Context, tx *sql.Tx, t models.RepositoryTemplate, repoIDs []string) (int64, error) {
+	if t.ExcludeRefs == nil && t.Mode == nil {
+		return 0, nil
+	}
+	res, err := tx.ExecContext(ctx,
+		`UPDATE replication_targets
+		 SET exclude_refs = COALESCE($2, exclude_refs),
+		     mode = CASE WHEN $3::text IS NULL OR mode = $6 THEN mode ELSE $3 END,
+		     tag_pattern = CASE WHEN $3::text IS NULL OR mode = $6 THEN tag_pattern ELSE $4 END,
+		     annotated_tags_only = CASE WHEN $3::text IS NULL OR mode = $6 THEN annotated_tags_only ELSE $5 END
+		 WHERE repository_id = ANY($1)`,
+		pq.Array(repoIDs), pq.Array(t.ExcludeRefs), t.Mode, t.TagPattern, t.AnnotatedTagsOnly, models.TargetModeSubdirectory)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// errTemplate is why a repository cannot be created from a template; its
+// message is meant for a 400 response
+type errTemplate string
+
+func (e errTemplate) Error() string { return string(e) }
+
+// byName returns the template with the given name
+func (h *TemplateHandler) byName(ctx context.Context, name string) (*models.RepositoryTemplate, error) {
+	t, err := scanTemplate(h.DB.QueryRowContext(ctx,
+		`SELECT `+templateColumns+` FROM repository_templates WHERE name = $1`, strings.TrimSpace(name)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errTemplate("repository template " + name + " not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch repository template: %w", err)
+	}
+	return &t, nil
+}
+
+// fillFromTemplate fills in the settings req leaves unset from t. Labels
+// are merged, those of req taking precedence.
+func fillFromTemplate(req *models.CreateRepositoryRequest, t *models.RepositoryTemplate) {
+	merged := maps.Clone(t.Labels)
+	maps.Copy(merged, req.Labels)
+	req.Labels = merged
+	if req.SyncWindow == nil {
+		req.SyncWindow = t.SyncWindow
+	}
+	if req.SyncSLOSeconds == nil {
+		req.SyncSLOSeconds = t.SyncSLOSeconds
+	}
+	if req.CloneFilter == "" && t.CloneFilter != nil {
+		req.CloneFilter = *t.CloneFilter
+	}
+}
+
+// adoptTemplate makes t the template of repo, a new repository, setting
+// its sync interval and the ref filters and push mode of its targets
+func adoptTemplate(repo *models.Repository, t *models.RepositoryTemplate) {
+	repo.TemplateID = &t.ID
+	repo.SyncIntervalSeconds = t.SyncIntervalSeconds
+	for i := range repo.Targets {
+		target := &repo.Targets[i]
+		if t.ExcludeRefs != nil {
+			target.ExcludeRefs = slices.Clone(t.ExcludeRefs)
+		}
+		if t.Mode != nil && target.Mode != models.TargetModeSubdirectory {
+			target.Mode, target.TagPattern, target.AnnotatedTagsOnly = *t.Mode, t.TagPattern, t.AnnotatedTagsOnly
+		}
+	}
+}
+
+// fill fills in the settings req leaves unset from the template it names,
+// returning the template, or nil when it names none. It writes an error
+// and returns false when the template cannot be found.
+func (h *TemplateHandler) fill(w http.ResponseWriter, r *http.Request, req *models.CreateRepositoryRequest) (*models.RepositoryTemplate, bool) {
+	if strings.TrimSpace(req.Template) == "" {
+		return nil, true
+	}
+	t, err := h.byName(r.Context(), req.Template)
+	var invalid errTemplate
+	if errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to fetch repository template", http.StatusInternalServerError)
+		return nil, false
+	}
+	fillFromTemplate(req, t)
+	return t, true
+}
+
+// lookup returns the template with the given name from seen, fetching and
+// adding it when missing, or nil when name is empty. Bulk imports look up
+// each template once.
+func (h *TemplateHandler) lookup(ctx context.Context, seen map[string]*models.RepositoryTemplate, name string) (*models.RepositoryTemplate, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, nil
+	}
+	if t, ok := seen[name]; ok {
+		return t, nil
+	}
+	t, err := h.byName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	seen[name] = t
+	return t, nil
+}
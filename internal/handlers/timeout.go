@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Timeouts bound how long API requests may run, by class of route. When
+// the deadline of a request passes, or its client goes away, its context
+// is cancelled and so are the queries it is running. A class with 0 is
+// unbounded.
+type Timeouts struct {
+	// Default applies to routes of no other class
+	Default time.Duration
+	// Long applies to routes doing heavy work, such as imports, diffs and
+	// restores
+	Long time.Duration
+	// Stream applies to routes streaming responses of unbounded size
+	Stream time.Duration
+}
+
+// Timeout bounds the context of the requests next handles at d; 0 leaves
+// it unbounded
+func Timeout(d time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if d <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+
+	"github.com/gorilla/mux"
+)
+
+// TransferHandler serves the git transfer settings of repositories
+type TransferHandler struct {
+	DB       *database.DB
+	Defaults models.Transfer
+}
+
+// NewTransferHandler creates a new TransferHandler
+func NewTransferHandler(db *database.DB, defaults models.Transfer) *TransferHandler {
+	return &TransferHandler{DB: db, Defaults: defaults}
+}
+
+// GetRepositoryTransfer handles GET /repositories/{id}/transfer
+func (h *TransferHandler) GetRepositoryTransfer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var o models.Transfer
+	err := h.DB.QueryRowContext(r.Context(), `SELECT transfer FROM repositories WHERE id = $1`, id).Scan(&o)
+	if errors.Is(err, sql.ErrNoRows) || isInvalidUUID(err) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository transfer settings: %v", err)
+		http.Error(w, "failed to fetch transfer settings", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, http.StatusOK, models.RepositoryTransfer{
+		RepositoryID: id,
+		Overrides:    o,
+		Effective:    replication.EffectiveTransfer(h.Defaults, o),
+	})
+}
+
+// UpdateRepositoryTransfer handles PUT /repositories/{id}/transfer
+func (h *TransferHandler) UpdateRepositoryTransfer(w http.ResponseWriter, r *http.Request) {
+	var o models.Transfer
+	if !decodeBody(w, r, &o) {
+		return
+	}
+	if err := replication.ValidateTransfer(o); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	res, err := h.DB.ExecContext(r.Context(), `UPDATE repositories SET transfer = $2 WHERE id = $1`, id, o)
+	if isInvalidUUID(err) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update repository transfer settings: %v", err)
+		http.Error(w, "failed to update transfer settings", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, h.DB, "repository.transfer", "repository", id, map[string]any{"overrides": o})
+
+	writeBody(w, r, http.StatusOK, models.RepositoryTransfer{
+		RepositoryID: id,
+		Overrides:    o,
+		Effective:    replication.EffectiveTransfer(h.Defaults, o),
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/cache"
+	"gitsync/internal/database"
+	"gitsync/internal/deploykeys"
+	"gitsync/internal/models"
+	"gitsync/internal/trash"
+
+	"github.com/gorilla/mux"
+)
+
+// TrashHandler lists deleted repositories and targets and restores them
+type TrashHandler struct {
+	DB *database.DB
+	// RBAC limits non-admins to the entries of their teams
+	RBAC  bool
+	Trash *trash.Bin
+	Cache *cache.Cache
+	// DeployKeys are added again to the remotes of restored targets
+	DeployKeys *deploykeys.Manager
+}
+
+// NewTrashHandler creates a new TrashHandler
+func NewTrashHandler(db *database.DB, rbac bool, bin *trash.Bin, c *cache.Cache, keys *deploykeys.Manager) *TrashHandler {
+	return &TrashHandler{DB: db, RBAC: rbac, Trash: bin, Cache: c, DeployKeys: keys}
+}
+
+// deletedBy returns the user deleting a resource with r, nil when
+// anonymous
+func deletedBy(r *http.Request) *string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return &p.User
+	}
+	return nil
+}
+
+// ListTrash handles GET /trash, optionally filtered by type, newest first.
+// Entries are aliased as r so that scopeToTeams applies to their team.
+func (h *TrashHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	var conds []string
+	var args []any
+	switch v := r.URL.Query().Get("type"); v {
+	case "":
+	case trash.Repository, trash.Target:
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf("r.resource_type = $%d", len(args)))
+	default:
+		http.Error(w, "type must be repository or target", http.StatusBadRequest)
+		return
+	}
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, conds, args)
+	if !ok {
+		return
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT `+prefixColumns("r", trash.Columns)+` FROM trash r `+where+` ORDER BY r.deleted_at DESC`, args...)
+	if err != nil {
+		log.Printf("ERROR: failed to fetch trash: %v", err)
+		http.Error(w, "failed to fetch trash", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.TrashEntry{}
+	for rows.Next() {
+		e, err := trash.Scan(rows)
+		if err != nil {
+			http.Error(w, "failed to scan trash entry", http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, e)
+	}
+	writeBody(w, r, http.StatusOK, entries)
+}
+
+// RestoreTrash handles POST /trash/{id}/restore, putting the repository or
+// target back with its sync history
+func (h *TrashHandler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	conds, args, ok := scopeToTeams(w, r, h.RBAC, []string{"r.id = $1"}, []any{id})
+	if !ok {
+		return
+	}
+	var exists bool
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM trash r WHERE `+strings.Join(conds, " AND ")+`)`, args...).Scan(&exists)
+	if isInvalidUUID(err) || (err == nil && !exists) {
+		http.Error(w, "trash entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch trash entry: %v", err)
+		http.Error(w, "failed to restore", http.StatusInternalServerError)
+		return
+	}
+
+	e, err := h.Trash.Restore(ctx, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "trash entry not found", http.StatusNotFound)
+		return
+	case errors.Is(err, trash.ErrRepositoryDeleted):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case isUniqueViolation(err):
+		http.Error(w, "a "+e.ResourceType+" with the same URL or external ID has been created since", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("ERROR: failed to restore trash entry %s: %v", id, err)
+		http.Error(w, "failed to restore", http.StatusInternalServerError)
+		return
+	}
+
+	h.Cache.Invalidate(ctx, cache.Repositories)
+	recordAudit(r, h.DB, e.ResourceType+".restore", e.ResourceType, e.ResourceID, map[string]any{"trash_id": e.ID})
+
+	// The keys of the restored targets were removed with them
+	repoID := e.ResourceID
+	if e.RepositoryID != nil {
+		repoID = *e.RepositoryID
+	}
+	if h.DeployKeys.Enabled() {
+		go func() {
+			if err := h.DeployKeys.EnsureRepository(context.Background(), repoID); err != nil {
+				log.Printf("WARN: failed to add deploy keys for repository %s: %v", repoID, err)
+			}
+		}()
+	}
+	writeBody(w, r, http.StatusOK, e)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+	"gitsync/internal/replication"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// WebhookHandler receives the push webhooks installed on sources and
+// syncs the pushed repositories right away
+type WebhookHandler struct {
+	DB *database.DB
+	// Credentials opens the webhook secrets, sealed like credential secrets
+	Credentials *credentials.Store
+	// Secrets maps provider names to the secret of webhooks configured by
+	// hand, which authenticates deliveries for repositories without a
+	// managed webhook
+	Secrets map[string]string
+	// Runner queues the syncs and holds the planner saying whether
+	// automatic syncs of a repository are held back
+	Runner *replication.Runner
+	Clock  clock.Clock
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler(db *database.DB, creds *credentials.Store, secrets map[string]string, runner *replication.Runner, clk clock.Clock) *WebhookHandler {
+	return &WebhookHandler{DB: db, Credentials: creds, Secrets: secrets, Runner: runner, Clock: clk}
+}
+
+// ReceiveWebhook handles POST /webhooks/{provider}. The repository is
+// found by the clone URLs of the payload, which is then authenticated with
+// the secret of the repository's webhook, such as by X-Hub-Signature-256
+// for GitHub. The secret of a webhook managed by GitSync takes precedence;
+// repositories without one use the configured secret of the provider, for
+// webhooks set up by hand. Deliveries other than pushes, such as pings,
+// are acknowledged and ignored.
+func (h *WebhookHandler) ReceiveWebhook(w http.ResponseWriter, r *http.Request) {
+	p, err := provider.Lookup(mux.Vars(r)["provider"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read payload", http.StatusBadRequest)
+		return
+	}
+	var urls []string
+	for _, u := range provider.PushRepositoryURLs(body) {
+		if canonical, err := provider.CanonicalURL(u); err == nil {
+			urls = append(urls, canonical)
+		}
+	}
+	if len(urls) == 0 {
+		http.Error(w, "payload names no repository", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var id string
+	var stored, keyID *string
+	err = h.DB.QueryRowContext(ctx,
+		`SELECT r.id, wh.secret, wh.key_id FROM repositories r LEFT JOIN repository_webhooks wh ON wh.repository_id = r.id
+		 WHERE r.canonical_url = ANY($1) AND r.source_provider = $2 LIMIT 1`, pq.Array(urls), p.Name()).Scan(&id, &stored, &keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "repository not registered", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to fetch repository of webhook delivery: %v", err)
+		http.Error(w, "failed to fetch repository", http.StatusInternalServerError)
+		return
+	}
+	secret := h.Secrets[p.Name()]
+	if stored != nil && *stored != "" {
+		if secret, err = h.Credentials.Open(*stored, keyID); err != nil {
+			log.Printf("ERROR: failed to decrypt webhook secret of repository %s: %v", id, err)
+			http.Error(w, "failed to decrypt webhook secret", http.StatusInternalServerError)
+			return
+		}
+	}
+	// Without a secret no delivery can be authenticated
+	if secret == "" {
+		http.Error(w, provider.ErrInvalidSignature.Error(), http.StatusUnauthorized)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	event, err := p.ParseWebhook(r, secret)
+	if errors.Is(err, provider.ErrInvalidSignature) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	delivery := models.WebhookDelivery{RepositoryID: id}
+	if event == nil {
+		delivery.Detail = "not a push event"
+		writeBody(w, r, http.StatusOK, delivery)
+		return
+	}
+	delivery.Ref, delivery.After = event.Ref, event.After
+
+	// The push is source activity, which keeps the adaptive interval short
+	now := h.Clock.Now()
+	if _, err := h.DB.ExecContext(ctx, `UPDATE repositories SET source_changed_at = $2 WHERE id = $1`, id, now); err != nil {
+		log.Printf("ERROR: failed to record source activity of repository %s: %v", id, err)
+	}
+	// Pushes are synced like scheduled syncs, so whatever holds those back
+	// holds these back too
+	plan, err := h.Runner.Planner.Plan(ctx, id, now)
+	if err != nil {
+		log.Printf("ERROR: failed to plan sync of repository %s: %v", id, err)
+		http.Error(w, "failed to plan sync", http.StatusInternalServerError)
+		return
+	}
+	if plan.HeldBy != "" {
+		delivery.Detail = plan.HeldDetail
+		writeBody(w, r, http.StatusAccepted, delivery)
+		return
+	}
+	err = h.Runner.Enqueue(ctx, id, replication.TriggerWebhook)
+	switch {
+	case errors.Is(err, replication.ErrBusy):
+		delivery.Detail = "repository is already queued or syncing"
+	case err != nil:
+		log.Printf("ERROR: %v", err)
+		http.Error(w, "failed to queue sync", http.StatusInternalServerError)
+		return
+	default:
+		delivery.Queued = true
+	}
+
+	writeBody(w, r, http.StatusAccepted, delivery)
+}
@@ -0,0 +1,384 @@
+// Package hostkeys keeps the SSH host keys git trusts in the database and
+// writes them to the known_hosts file its ssh client reads, so that every
+// worker trusts the same keys whatever its own filesystem holds.
+package hostkeys
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+)
+
+// Policies for hosts without a trusted key
+const (
+	// Strict refuses to connect until a key of the host is pinned
+	Strict = "strict"
+	// TOFU trusts the key a host presents on first connection and refuses
+	// any other key afterwards
+	TOFU = "tofu"
+)
+
+// Sources of trusted keys
+const (
+	SourcePinned = "pinned"
+	SourceTOFU   = "tofu"
+)
+
+// keyTypes are the public key algorithms accepted
+var keyTypes = []string{
+	"ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521", "ssh-rsa",
+	"sk-ssh-ed25519@openssh.com", "sk-ecdsa-sha2-nistp256@openssh.com",
+}
+
+// ValidatePolicy checks a policy name
+func ValidatePolicy(policy string) error {
+	if policy != Strict && policy != TOFU {
+		return fmt.Errorf("unsupported host key policy %q. allowed: %s, %s", policy, Strict, TOFU)
+	}
+	return nil
+}
+
+// NormalizeHost checks a host in known_hosts notation, which is the host
+// name for port 22 and [host]:port for other ports, and returns it in
+// lowercase with [host]:22 written as host
+func NormalizeHost(host string) (string, error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	name, port := host, ""
+	if rest, ok := strings.CutPrefix(host, "["); ok {
+		var found bool
+		if name, port, found = strings.Cut(rest, "]:"); !found {
+			return "", fmt.Errorf("host %q must be a name or [name]:port", host)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid port in host %q", host)
+		}
+	}
+	if name == "" || strings.ContainsAny(name, " \t,*?!|@[]:/") {
+		return "", fmt.Errorf("host %q must be a name or [name]:port", host)
+	}
+	if port == "" || port == "22" {
+		return name, nil
+	}
+	return "[" + name + "]:" + port, nil
+}
+
+// ParseKey parses a public key in authorized_keys format, type and base64
+// key followed by an optional comment, and returns its SHA256 fingerprint
+// as ssh shows it
+func ParseKey(line string) (typ, key, fingerprint string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", "", errors.New("key must be given as type and base64 key")
+	}
+	typ, key = fields[0], fields[1]
+	if !slices.Contains(keyTypes, typ) {
+		return "", "", "", fmt.Errorf("unsupported key type %q. allowed: %s", typ, strings.Join(keyTypes, ", "))
+	}
+	blob, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", "", "", errors.New("key is not valid base64")
+	}
+	// The key starts with its type as a length-prefixed string
+	if len(blob) < 4 || int(binary.BigEndian.Uint32(blob)) > len(blob)-4 ||
+		string(blob[4:4+binary.BigEndian.Uint32(blob)]) != typ {
+		return "", "", "", fmt.Errorf("key is not of type %s", typ)
+	}
+	sum := sha256.Sum256(blob)
+	return typ, key, "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// Columns are the columns of the ssh_host_keys table Scan reads
+const Columns = `id, host, key_type, public_key, fingerprint, source, created_by, created_at`
+
+// Scan reads a key selected with Columns
+func Scan(row interface{ Scan(...any) error }) (models.SSHHostKey, error) {
+	var k models.SSHHostKey
+	err := row.Scan(&k.ID, &k.Host, &k.Type, &k.Key, &k.Fingerprint, &k.Source, &k.CreatedBy, &k.CreatedAt)
+	return k, err
+}
+
+// Store keeps the trusted host keys and the known_hosts file written from
+// them
+type Store struct {
+	DB *database.DB
+	// File is the known_hosts file; keys are not managed when it is empty
+	File   string
+	Policy string
+	Clock  clock.Clock
+	IDs    ids.Generator
+
+	mu sync.Mutex
+	// written holds the lines of File as last written. Lines found there
+	// besides these were added by ssh on first use.
+	written map[string]bool
+}
+
+// NewStore creates a Store writing the known_hosts file at file
+func NewStore(db *database.DB, file, policy string, clk clock.Clock, gen ids.Generator) *Store {
+	return &Store{DB: db, File: file, Policy: policy, Clock: clk, IDs: gen}
+}
+
+// Enabled reports whether host keys are managed
+func (s *Store) Enabled() bool {
+	return s != nil && s.File != ""
+}
+
+// Run keeps the known_hosts file in step with the keys trusted by every
+// worker until ctx is cancelled
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	if !s.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: host key sync failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync records the keys ssh trusted on first use since the last sync and
+// rewrites the known_hosts file with the trusted keys. Keys ssh adds while
+// the file is rewritten are lost and trusted again on next use, as are the
+// keys in a file left by an earlier run, whose hosts may have been
+// forgotten since.
+func (s *Store) Sync(ctx context.Context) error {
+	if !s.Enabled() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Policy == TOFU && s.written != nil {
+		if err := s.learn(ctx); err != nil {
+			return err
+		}
+	}
+	return s.write(ctx)
+}
+
+// learn records the keys of the known_hosts file that were not written to
+// it, for hosts without a trusted key. A host whose key was trusted by
+// another worker in the meantime keeps that key.
+func (s *Store) learn(ctx context.Context) error {
+	f, err := os.Open(s.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	defer f.Close()
+
+	learned := make(map[string][]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || s.written[line] {
+			continue
+		}
+		host, key, _ := strings.Cut(line, " ")
+		if host, err = NormalizeHost(host); err != nil {
+			continue
+		}
+		learned[host] = append(learned[host], key)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	for host, keys := range learned {
+		if err := s.trust(ctx, host, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trust records the keys ssh trusted on first use for host
+func (s *Store) trust(ctx context.Context, host string, keys []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var known bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM ssh_host_keys WHERE host = $1)`, host).Scan(&known); err != nil {
+		return fmt.Errorf("failed to fetch host keys of %s: %w", host, err)
+	}
+	if known {
+		return nil
+	}
+	for _, line := range keys {
+		typ, key, fp, err := ParseKey(line)
+		if err != nil {
+			continue
+		}
+		if err := s.insert(ctx, tx, host, typ, key, fp, SourceTOFU, nil); err != nil {
+			return err
+		}
+		log.Printf("Trusted %s host key %s of %s on first use", typ, fp, host)
+	}
+	return tx.Commit()
+}
+
+func (s *Store) insert(ctx context.Context, tx *sql.Tx, host, typ, key, fp, source string, by *string) error {
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO ssh_host_keys (id, host, key_type, public_key, fingerprint, source, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 ON CONFLICT (host, key_type, public_key) DO NOTHING`,
+		s.IDs.NewID(), host, typ, key, fp, source, by, s.Clock.Now()); err != nil {
+		return fmt.Errorf("failed to save host key of %s: %w", host, err)
+	}
+	return nil
+}
+
+// write replaces the known_hosts file with the trusted keys
+func (s *Store) write(ctx context.Context) error {
+	rows, err := s.DB.QueryContext(ctx, `SELECT host, key_type, public_key FROM ssh_host_keys ORDER BY host, created_at`)
+	if err != nil {
+		return fmt.Errorf("failed to fetch host keys: %w", err)
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	written := make(map[string]bool)
+	for rows.Next() {
+		var host, typ, key string
+		if err := rows.Scan(&host, &typ, &key); err != nil {
+			return fmt.Errorf("failed to scan host key: %w", err)
+		}
+		line := host + " " + typ + " " + key
+		written[line] = true
+		b.WriteString(line + "\n")
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch host keys: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.File), 0o700); err != nil {
+		return fmt.Errorf("failed to create known hosts directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.File), ".known_hosts-*")
+	if err != nil {
+		return fmt.Errorf("failed to write known hosts: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write known hosts: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write known hosts: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.File); err != nil {
+		return fmt.Errorf("failed to write known hosts: %w", err)
+	}
+	s.written = written
+	return nil
+}
+
+// Pin replaces the trusted keys of host with keys, given in authorized_keys
+// format, and returns them. Rotating the key of a host is pinning the new
+// one.
+func (s *Store) Pin(ctx context.Context, host string, keys []string, by *string) ([]models.SSHHostKey, error) {
+	type parsed struct{ typ, key, fp string }
+	var pins []parsed
+	for i, line := range keys {
+		typ, key, fp, err := ParseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w", i, err)
+		}
+		pins = append(pins, parsed{typ, key, fp})
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ssh_host_keys WHERE host = $1`, host); err != nil {
+		return nil, fmt.Errorf("failed to remove host keys of %s: %w", host, err)
+	}
+	for _, p := range pins {
+		if err := s.insert(ctx, tx, host, p.typ, p.key, p.fp, SourcePinned, by); err != nil {
+			return nil, err
+		}
+	}
+	pinned, err := list(ctx, tx, host)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pinned, s.afterChange(ctx)
+}
+
+// Forget removes the trusted keys of host and reports whether it had any.
+// With the TOFU policy the next connection trusts the key it presents.
+func (s *Store) Forget(ctx context.Context, host string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM ssh_host_keys WHERE host = $1`, host)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove host keys of %s: %w", host, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, s.afterChange(ctx)
+}
+
+// afterChange rewrites the known_hosts file of this worker; the others
+// pick the change up on their next sync
+func (s *Store) afterChange(ctx context.Context) error {
+	if !s.Enabled() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(context.WithoutCancel(ctx))
+}
+
+func list(ctx context.Context, tx *sql.Tx, host string) ([]models.SSHHostKey, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+Columns+` FROM ssh_host_keys WHERE host = $1 ORDER BY created_at, key_type`, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch host keys of %s: %w", host, err)
+	}
+	defer rows.Close()
+	keys := []models.SSHHostKey{}
+	for rows.Next() {
+		k, err := Scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan host key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
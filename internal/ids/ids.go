@@ -0,0 +1,57 @@
+// Package ids generates the identifiers of new records. IDs are UUIDs
+// because every id column is of type uuid; the format only decides how
+// they are laid out.
+package ids
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Generator hands out new record IDs
+type Generator interface {
+	NewID() string
+}
+
+// Random generates random (version 4) UUIDs
+type Random struct{}
+
+// NewID implements Generator
+func (Random) NewID() string { return uuid.NewString() }
+
+// Sortable generates time-ordered (version 7) UUIDs, which sort by creation
+// time like ULIDs and keep index inserts append-only
+type Sortable struct{}
+
+// NewID implements Generator
+func (Sortable) NewID() string { return uuid.Must(uuid.NewV7()).String() }
+
+// Sequence generates 00000000-0000-0000-0000-000000000001,
+// 00000000-0000-0000-0000-000000000002 and so on, for reproducible output
+type Sequence struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+// NewID implements Generator
+func (s *Sequence) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012x", s.next)
+}
+
+// FromEnv reads ID_FORMAT: random (default) or sortable
+func FromEnv() (Generator, error) {
+	switch format := os.Getenv("ID_FORMAT"); format {
+	case "", "random":
+		return Random{}, nil
+	case "sortable":
+		return Sortable{}, nil
+	default:
+		return nil, fmt.Errorf("ID_FORMAT must be random or sortable, got %q", format)
+	}
+}
@@ -0,0 +1,105 @@
+// Package labels implements key/value labels on repositories and the
+// selector syntax used to match them.
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]{0,61}[a-z0-9])?$`)
+
+// Validate checks label keys and values
+func Validate(labels map[string]string) error {
+	for k, v := range labels {
+		if !keyPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if len(v) > 255 {
+			return fmt.Errorf("label %q value is too long", k)
+		}
+	}
+	return nil
+}
+
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+// Selector matches label sets. The syntax is a comma separated list of
+// requirements that must all hold: "key=value", "key!=value", "key" (key is
+// present) and "!key" (key is absent).
+type Selector struct {
+	raw          string
+	requirements []requirement
+}
+
+// ParseSelector parses a selector expression. The empty string matches
+// everything.
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{raw: strings.TrimSpace(s)}
+	if sel.raw == "" {
+		return sel, nil
+	}
+
+	for _, part := range strings.Split(sel.raw, ",") {
+		part = strings.TrimSpace(part)
+		var req requirement
+		switch {
+		case strings.Contains(part, "!="):
+			k, v, _ := strings.Cut(part, "!=")
+			req = requirement{key: strings.TrimSpace(k), op: "!=", values: []string{strings.TrimSpace(v)}}
+		case strings.Contains(part, "="):
+			k, v, _ := strings.Cut(part, "=")
+			req = requirement{key: strings.TrimSpace(k), op: "=", values: []string{strings.TrimSpace(v)}}
+		case strings.HasPrefix(part, "!"):
+			req = requirement{key: strings.TrimSpace(part[1:]), op: "!"}
+		default:
+			req = requirement{key: part, op: "exists"}
+		}
+		if !keyPattern.MatchString(req.key) {
+			return Selector{}, fmt.Errorf("invalid selector requirement %q", part)
+		}
+		sel.requirements = append(sel.requirements, req)
+	}
+	return sel, nil
+}
+
+// Empty reports whether the selector matches everything
+func (s Selector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+// String returns the selector expression
+func (s Selector) String() string {
+	return s.raw
+}
+
+// Matches reports whether labels satisfy every requirement
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s.requirements {
+		v, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || v != req.values[0] {
+				return false
+			}
+		case "!=":
+			if ok && v == req.values[0] {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,323 @@
+// Package maintenance repacks cached mirrors and object pools, so fetches
+// into them and pushes from them stay fast as they accumulate loose
+// objects and packs over months of syncs.
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+)
+
+// Config sets when mirrors are repacked
+type Config struct {
+	// Interval is how often each mirror and pool is repacked regardless of
+	// its state; 0 only repacks those over a threshold
+	Interval time.Duration
+	// MaxLooseObjects repacks a mirror early once it holds more loose
+	// objects; 0 disables the threshold
+	MaxLooseObjects int
+	// MaxPacks repacks a mirror early once its objects are spread over
+	// more packs; 0 disables the threshold
+	MaxPacks int
+	// Concurrency bounds the repacks of mirrors in progress at once
+	Concurrency int
+}
+
+// ConfigFromEnv reads MAINTENANCE_INTERVAL, MAINTENANCE_MAX_LOOSE_OBJECTS,
+// MAINTENANCE_MAX_PACKS and MAINTENANCE_CONCURRENCY, defaulting to a
+// weekly repack, early once past the thresholds of git gc --auto, one at
+// a time
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Interval: 7 * 24 * time.Hour, MaxLooseObjects: 6700, MaxPacks: 50, Concurrency: 1}
+	if raw := os.Getenv("MAINTENANCE_INTERVAL"); raw != "" {
+		var err error
+		if cfg.Interval, err = time.ParseDuration(raw); err != nil || cfg.Interval < 0 {
+			return cfg, fmt.Errorf("MAINTENANCE_INTERVAL must be a non-negative duration")
+		}
+	}
+	for name, field := range map[string]*int{
+		"MAINTENANCE_MAX_LOOSE_OBJECTS": &cfg.MaxLooseObjects,
+		"MAINTENANCE_MAX_PACKS":         &cfg.MaxPacks,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("%s must be a non-negative number", name)
+		}
+		*field = n
+	}
+	if raw := os.Getenv("MAINTENANCE_CONCURRENCY"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("MAINTENANCE_CONCURRENCY must be a positive number")
+		}
+		cfg.Concurrency = n
+	}
+	return cfg, nil
+}
+
+// Enabled reports whether mirrors are repacked on a schedule at all
+func (c Config) Enabled() bool {
+	return c.Interval > 0 || c.MaxLooseObjects > 0 || c.MaxPacks > 0
+}
+
+// marker is the file in a repository whose modification time records its
+// last repack
+const marker = "gitsync-maintenance"
+
+// ErrNotCached is returned by Repository for repositories without a
+// cached mirror
+var ErrNotCached = errors.New("repository has no cached mirror")
+
+// Job repacks the mirrors below CacheDir, and the pools below its pool
+// directory. Mirrors are repacked on the sync runner, so no sync of their
+// repository runs meanwhile; pools are shared and repacked in place, which
+// git allows alongside fetches.
+type Job struct {
+	Git      *git.Runner
+	Runner   *replication.Runner
+	CacheDir string
+	Config   Config
+	Clock    clock.Clock
+
+	inflight atomic.Int32
+}
+
+// NewJob creates a Job
+func NewJob(runner *git.Runner, syncs *replication.Runner, cacheDir string, cfg Config, clk clock.Clock) *Job {
+	return &Job{Git: runner, Runner: syncs, CacheDir: cacheDir, Config: cfg, Clock: clk}
+}
+
+// Run repacks the mirrors and pools that are due every interval until ctx
+// is cancelled
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		j.queueDue(ctx)
+		j.repackPools(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueDue queues repacks of the mirrors that are due. Mirrors borrowing
+// their objects from a pool hold little but refs and are left to it.
+func (j *Job) queueDue(ctx context.Context) {
+	entries, err := os.ReadDir(j.CacheDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("ERROR: failed to read cache directory: %v", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		if int(j.inflight.Load()) >= j.Config.Concurrency || ctx.Err() != nil {
+			return
+		}
+		if !entry.IsDir() || entry.Name() == replication.PoolDir {
+			continue
+		}
+		id, dir := entry.Name(), filepath.Join(j.CacheDir, entry.Name())
+		if pooled(dir) {
+			continue
+		}
+		due, err := j.due(ctx, dir)
+		if err != nil {
+			log.Printf("WARN: failed to inspect mirror of repository %s: %v", id, err)
+			continue
+		}
+		if !due {
+			continue
+		}
+		j.inflight.Add(1)
+		queued := j.Runner.Submit(id, func(ctx context.Context) {
+			defer j.inflight.Add(-1)
+			if _, _, err := j.repack(ctx, dir); err != nil {
+				log.Printf("WARN: maintenance of mirror of repository %s failed: %v", id, err)
+			}
+		})
+		// A repository busy syncing is picked up again next time
+		if !queued {
+			j.inflight.Add(-1)
+		}
+	}
+}
+
+// repackPools repacks the object pools that are due
+func (j *Job) repackPools(ctx context.Context) {
+	root := filepath.Join(j.CacheDir, replication.PoolDir)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("ERROR: failed to read pool directory: %v", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || ctx.Err() != nil {
+			continue
+		}
+		pool := filepath.Join(root, entry.Name())
+		due, err := j.due(ctx, pool)
+		if err == nil && due {
+			_, _, err = j.repack(ctx, pool)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("WARN: maintenance of object pool %s failed: %v", entry.Name(), err)
+		}
+	}
+}
+
+// Repository repacks the cached mirror of the repository with the given
+// ID right away, or the pool it borrows its objects from. It runs on the
+// sync runner and fails with replication.ErrBusy while the repository is
+// syncing.
+func (j *Job) Repository(ctx context.Context, repoID string) (models.MaintenanceResult, error) {
+	res := models.MaintenanceResult{RepositoryID: repoID}
+	err := j.Runner.Do(ctx, repoID, func(ctx context.Context) error {
+		dir := filepath.Join(j.CacheDir, repoID)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return ErrNotCached
+		}
+		if pool, ok := poolOf(dir); ok {
+			res.Pooled, dir = true, pool
+		}
+		res.StartedAt = j.Clock.Now()
+		var err error
+		res.Before, res.After, err = j.repack(ctx, dir)
+		res.FinishedAt = j.Clock.Now()
+		return err
+	})
+	return res, err
+}
+
+// due reports whether the repository at dir is due for a repack. One
+// never repacked starts its interval now.
+func (j *Job) due(ctx context.Context, dir string) (bool, error) {
+	stats, err := j.stats(ctx, dir)
+	if err != nil {
+		return false, err
+	}
+	if (j.Config.MaxLooseObjects > 0 && stats.LooseObjects > j.Config.MaxLooseObjects) ||
+		(j.Config.MaxPacks > 0 && stats.Packs > j.Config.MaxPacks) {
+		return true, nil
+	}
+	info, err := os.Stat(filepath.Join(dir, marker))
+	if os.IsNotExist(err) {
+		return false, j.mark(dir)
+	}
+	if err != nil {
+		return false, err
+	}
+	return j.Config.Interval > 0 && j.Clock.Now().Sub(info.ModTime()) >= j.Config.Interval, nil
+}
+
+// repack runs git gc on the repository at dir, writing a commit graph and,
+// unless it borrows objects, a reachability bitmap, and returns its stats
+// before and after
+func (j *Job) repack(ctx context.Context, dir string) (models.MirrorStats, models.MirrorStats, error) {
+	before, err := j.stats(ctx, dir)
+	if err != nil {
+		return before, before, err
+	}
+	defer keepModTime(dir)()
+	config := map[string]string{"gc.writeCommitGraph": "true"}
+	if _, ok := poolOf(dir); !ok {
+		config["repack.writeBitmaps"] = "true"
+		config["pack.writeBitmapHashCache"] = "true"
+	}
+	if _, err := j.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"gc", "--quiet"}, Config: config}); err != nil {
+		return before, before, err
+	}
+	if err := j.mark(dir); err != nil {
+		return before, before, err
+	}
+	after, err := j.stats(ctx, dir)
+	return before, after, err
+}
+
+// keepModTime returns a func restoring the modification time dir has now.
+// Retention prunes mirrors by the modification time of their directory,
+// which maintenance does not count as use.
+func keepModTime(dir string) func() {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return func() {}
+	}
+	return func() { os.Chtimes(dir, info.ModTime(), info.ModTime()) }
+}
+
+// mark records a repack of the repository at dir now
+func (j *Job) mark(dir string) error {
+	defer keepModTime(dir)()
+	path := filepath.Join(dir, marker)
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		return fmt.Errorf("failed to record maintenance: %w", err)
+	}
+	now := j.Clock.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// stats reads git count-objects of the repository at dir
+func (j *Job) stats(ctx context.Context, dir string) (models.MirrorStats, error) {
+	var s models.MirrorStats
+	out, err := j.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"count-objects", "-v"}})
+	if err != nil {
+		return s, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		switch key {
+		case "count":
+			s.LooseObjects = int(n)
+		case "packs":
+			s.Packs = int(n)
+		case "size", "size-pack":
+			s.SizeBytes += n * 1024
+		}
+	}
+	return s, nil
+}
+
+// pooled reports whether the mirror at dir borrows its objects from a pool
+func pooled(dir string) bool {
+	_, ok := poolOf(dir)
+	return ok
+}
+
+// poolOf returns the pool the repository at dir borrows its objects from
+func poolOf(dir string) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "objects", "info", "alternates"))
+	if err != nil {
+		return "", false
+	}
+	objects, _, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	if objects == "" {
+		return "", false
+	}
+	return filepath.Dir(objects), true
+}
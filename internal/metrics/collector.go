@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/worker"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeTimeout bounds the queries of one scrape
+const scrapeTimeout = 10 * time.Second
+
+var (
+	queueRunsDesc = prometheus.NewDesc("gitsync_sync_queue_runs",
+		"Sync runs queued or running on any instance, by status.", []string{"status"}, nil)
+	queueOldestDesc = prometheus.NewDesc("gitsync_sync_queue_oldest_seconds",
+		"Age of the oldest queued sync run; 0 when none is queued.", nil, nil)
+	poolWorkersDesc = prometheus.NewDesc("gitsync_worker_pool_workers",
+		"Workers of a pool of this instance.", []string{"pool"}, nil)
+	poolBusyDesc = prometheus.NewDesc("gitsync_worker_pool_busy_workers",
+		"Workers of a pool of this instance running a job.", []string{"pool"}, nil)
+	poolQueuedDesc = prometheus.NewDesc("gitsync_worker_pool_queued_jobs",
+		"Jobs waiting for a worker of a pool of this instance.", []string{"pool"}, nil)
+	lastSuccessDesc = prometheus.NewDesc("gitsync_target_last_success_timestamp_seconds",
+		"When the latest successful sync of an approved target of an active repository finished, or the target was created when none did. "+
+			"time() minus this is the replication lag.", []string{"repository_id", "target_id"}, nil)
+	deadLetteredDesc = prometheus.NewDesc("gitsync_targets_dead_lettered",
+		"Targets dead-lettered after failing too many syncs in a row.", nil, nil)
+)
+
+// Collector reads the sync queue, the worker pools of this instance and
+// the replication lag of every target on each scrape
+type Collector struct {
+	DB    *database.DB
+	Pools []*worker.Pool
+}
+
+// NewCollector creates a Collector
+func NewCollector(db *database.DB, pools ...*worker.Pool) *Collector {
+	return &Collector{DB: db, Pools: pools}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{queueRunsDesc, queueOldestDesc, poolWorkersDesc, poolBusyDesc, poolQueuedDesc, lastSuccessDesc, deadLetteredDesc} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector. Metrics whose query fails are
+// left out of the scrape.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, p := range c.Pools {
+		stats := p.Stats()
+		ch <- prometheus.MustNewConstMetric(poolWorkersDesc, prometheus.GaugeValue, float64(stats.Size), p.Name)
+		ch <- prometheus.MustNewConstMetric(poolBusyDesc, prometheus.GaugeValue, float64(stats.Busy), p.Name)
+		ch <- prometheus.MustNewConstMetric(poolQueuedDesc, prometheus.GaugeValue, float64(stats.Queued), p.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+	if err := c.collectQueue(ctx, ch); err != nil {
+		log.Printf("ERROR: failed to collect sync queue metrics: %v", err)
+	}
+	if err := c.collectTargets(ctx, ch); err != nil {
+		log.Printf("ERROR: failed to collect target metrics: %v", err)
+	}
+}
+
+func (c *Collector) collectQueue(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var queued, running int
+	var oldest *time.Time
+	err := c.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE status = $1), COUNT(*) FILTER (WHERE status = $2), MIN(created_at) FILTER (WHERE status = $1)
+		 FROM sync_runs WHERE status IN ($1, $2)`,
+		models.SyncRunQueued, models.SyncRunRunning).Scan(&queued, &running, &oldest)
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(queueRunsDesc, prometheus.GaugeValue, float64(queued), models.SyncRunQueued)
+	ch <- prometheus.MustNewConstMetric(queueRunsDesc, prometheus.GaugeValue, float64(running), models.SyncRunRunning)
+	age := 0.0
+	if oldest != nil {
+		age = max(time.Since(*oldest).Seconds(), 0)
+	}
+	ch <- prometheus.MustNewConstMetric(queueOldestDesc, prometheus.GaugeValue, age)
+	return nil
+}
+
+func (c *Collector) collectTargets(ctx context.Context, ch chan<- prometheus.Metric) error {
+	rows, err := c.DB.QueryContext(ctx,
+		`SELECT t.repository_id, t.id,
+		        COALESCE((SELECT MAX(e.finished_at) FROM executions e WHERE e.target_id = t.id AND e.status = $1), t.created_at)
+		 FROM repositories r JOIN replication_targets t ON t.repository_id = r.id
+		 WHERE r.source_state = $2 AND r.enabled AND t.approval_state = $3`,
+		models.ExecutionSuccess, models.SourceActive, models.ApprovalApproved)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var repoID, targetID string
+		var lastSuccess time.Time
+		if err := rows.Scan(&repoID, &targetID, &lastSuccess); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue,
+			float64(lastSuccess.UnixNano())/1e9, repoID, targetID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var dead int
+	if err := c.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM replication_targets WHERE dead_lettered_at IS NOT NULL`).Scan(&dead); err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(deadLetteredDesc, prometheus.GaugeValue, float64(dead))
+	return nil
+}
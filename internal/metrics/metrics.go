@@ -0,0 +1,124 @@
+// Package metrics exposes Prometheus metrics of syncs, the sync queue, the
+// workers and the HTTP API.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitsync/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry holds the metrics recorded as things happen, shared by every
+// App of the process
+var registry = prometheus.NewRegistry()
+
+// syncBuckets span syncs from a no-op ls-remote to the first clone of a
+// large monorepo
+var syncBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+var (
+	targetSyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_target_syncs_total",
+		Help: "Finished syncs of a target by outcome: success, failed or awaiting_approval.",
+	}, []string{"repository_id", "target_id", "status"})
+	targetSyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitsync_target_sync_duration_seconds",
+		Help:    "Duration of the syncs of single targets by outcome.",
+		Buckets: syncBuckets,
+	}, []string{"status"})
+	runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitsync_sync_run_duration_seconds",
+		Help:    "Duration of the syncs of repositories to all their targets, by trigger and outcome.",
+		Buckets: syncBuckets,
+	}, []string{"trigger", "status"})
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_http_requests_total",
+		Help: "Requests to the API by route, method and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitsync_http_request_duration_seconds",
+		Help:    "Duration of requests to the API by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		targetSyncs, targetSyncDuration, runDuration, httpRequests, httpDuration,
+	)
+}
+
+// ObserveTargetSync counts a finished sync of a target
+func ObserveTargetSync(repoID, targetID string, status models.ExecutionStatus, d time.Duration) {
+	targetSyncs.WithLabelValues(repoID, targetID, string(status)).Inc()
+	targetSyncDuration.WithLabelValues(string(status)).Observe(d.Seconds())
+}
+
+// ObserveRun records a finished sync of a repository. Triggers are
+// labelled by their kind, so group:<run id> counts as group.
+func ObserveRun(trigger, status string, d time.Duration) {
+	kind, _, _ := strings.Cut(trigger, ":")
+	runDuration.WithLabelValues(kind, status).Observe(d.Seconds())
+}
+
+// Handler serves the metrics of the process together with those read by
+// collector on each scrape
+func Handler(collector prometheus.Collector) http.Handler {
+	scraped := prometheus.NewRegistry()
+	scraped.MustRegister(collector)
+	return promhttp.HandlerFor(prometheus.Gatherers{registry, scraped}, promhttp.HandlerOpts{})
+}
+
+// Middleware counts the requests to the routes of a mux router. Routes are
+// labelled by their path template, so /repositories/{id} is one route.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		started := time.Now()
+		next.ServeHTTP(rec, r)
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(started).Seconds())
+	})
+}
+
+// statusRecorder remembers the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = status, true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses incremental
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
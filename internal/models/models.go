@@ -1,35 +1,1501 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Labels are key/value pairs attached to a repository, stored as JSONB
+type Labels map[string]string
+
+// Value implements driver.Valuer
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(l)
+}
+
+// Scan implements sql.Scanner
+func (l *Labels) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*l = Labels{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Labels", src)
+	}
+	return json.Unmarshal(data, l)
+}
+
+// SyncWindow is a daily window, from Start to End (HH:MM in Timezone),
+// during which scheduled syncs may start. A window whose end is before its
+// start wraps past midnight. It is stored as JSONB.
+type SyncWindow struct {
+	Start string `json:"start" yaml:"start"`
+	End   string `json:"end" yaml:"end"`
+	// Timezone is an IANA name such as Europe/Berlin; UTC when empty
+	Timezone string `json:"timezone,omitempty" yaml:"timezone"`
+}
+
+// Value implements driver.Valuer
+func (w SyncWindow) Value() (driver.Value, error) {
+	return json.Marshal(w)
+}
+
+// Scan implements sql.Scanner
+func (w *SyncWindow) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, w)
+	case string:
+		return json.Unmarshal([]byte(v), w)
+	default:
+		return fmt.Errorf("cannot scan %T into SyncWindow", src)
+	}
+}
 
 // Repository represents a git repository to be replicated
 type Repository struct {
-	ID             string    `json:"id"`
-	Name           string    `json:"name"`
-	SourceProvider string    `json:"source_provider"`
-	SourceURL      string    `json:"source_url"`
-	CreatedAt      time.Time `json:"created_at"`
-	Targets        []Target  `json:"targets,omitempty"`
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	SourceProvider string `json:"source_provider"`
+	SourceURL      string `json:"source_url"`
+	// AlternateSourceURLs are mirrors of the source, fetched in order when
+	// the source URL is unreachable. They share its provider and credential.
+	AlternateSourceURLs []string `json:"alternate_source_urls,omitempty"`
+	CredentialID        *string  `json:"credential_id,omitempty"`
+	SourceState         string   `json:"source_state"`
+	ArchiveAction       *string  `json:"archive_action,omitempty"`
+	Labels              Labels   `json:"labels"`
+	Owner               *string  `json:"owner,omitempty"`
+	Team                *string  `json:"team,omitempty"`
+	// SyncSLOSeconds is the longest a target may go without a successful
+	// sync before an incident is opened; nil disables monitoring
+	SyncSLOSeconds *int `json:"sync_slo_seconds,omitempty"`
+	// CloneFilter makes the cached mirror a partial clone (for example
+	// blob:limit=1m or tree:0); missing objects are fetched when pushed
+	CloneFilter *string `json:"clone_filter,omitempty"`
+	// CachePool groups repositories whose mirrors share objects, such as
+	// forks of one upstream; the source URL is used when unset
+	CachePool *string `json:"cache_pool,omitempty"`
+	// SyncIntervalSeconds replaces the adaptive sync interval when set
+	SyncIntervalSeconds *int `json:"sync_interval_seconds,omitempty"`
+	// SyncSchedule is a cron expression that schedules syncs instead of
+	// any sync interval, such as 0 */6 * * *
+	SyncSchedule *string `json:"sync_schedule,omitempty"`
+	// SyncWindow restricts when scheduled syncs may start, replacing the
+	// global window; syncs that fall due outside it wait for it to open
+	SyncWindow *SyncWindow `json:"sync_window,omitempty"`
+	// Enabled is cleared to stop scheduled and webhook syncs of the
+	// repository; manual syncs still run
+	Enabled bool `json:"enabled"`
+	// SizeExceededAt is set when a sync was aborted because the repository
+	// is larger than the size cap, of SizeBytes. Flagged repositories are
+	// not synced on schedule until a manual sync succeeds.
+	SizeExceededAt *time.Time `json:"size_exceeded_at,omitempty"`
+	SizeBytes      *int64     `json:"size_bytes,omitempty"`
+	// Transfer overrides the global transfer settings for syncs of the
+	// repository; it is served by its own endpoint
+	Transfer Transfer `json:"-"`
+	// ManagedBy is gitops for repositories declared in the GitOps state
+	// file; they are reconciled to match it
+	ManagedBy *string `json:"managed_by,omitempty"`
+	// TemplateID is the template the repository was created from or last
+	// had applied
+	TemplateID *string `json:"template_id,omitempty"`
+	// ExternalID is a caller-assigned identifier, unique among
+	// repositories, for cross-referencing from other systems
+	ExternalID *string   `json:"external_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Targets    []Target  `json:"targets,omitempty"`
+	// Health is computed when listing or getting repositories
+	Health *RepositoryHealth `json:"health,omitempty"`
+	// Schedule is computed when getting a repository
+	Schedule *RepositorySchedule `json:"schedule,omitempty"`
 }
 
+// RepositorySchedule says when a repository is synced next and why, as
+// detailed by its schedule report
+type RepositorySchedule struct {
+	IntervalSeconds int64 `json:"interval_seconds"`
+	// Reason is why the interval was chosen, such as hot or cron
+	Reason string `json:"reason"`
+	// Cron is the cron expression syncs follow, if any
+	Cron       string     `json:"cron,omitempty"`
+	LastSyncAt *time.Time `json:"last_sync_at,omitempty"`
+	NextSyncAt time.Time  `json:"next_sync_at"`
+	// HeldBy is what keeps scheduled syncs from starting, if anything
+	HeldBy string `json:"held_by,omitempty"`
+}
+
+// RepositoryHealth rates how well a repository is mirrored, from 0 to 100.
+// The success rate of its recent syncs weighs 40 points, the share of
+// approved targets in sync 30, credentials accepted by source and targets
+// 15 and an active push webhook 15.
+type RepositoryHealth struct {
+	Score int `json:"score"`
+	// SuccessRate is the share of the syncs of the last seven days that
+	// succeeded; a repository without any counts as half healthy
+	SuccessRate *float64 `json:"success_rate,omitempty"`
+	// DriftedTargets are approved targets whose latest sync failed, that
+	// were never synced or that are in breach of the sync SLO
+	Targets        int  `json:"targets"`
+	DriftedTargets int  `json:"drifted_targets"`
+	CredentialsOK  bool `json:"credentials_ok"`
+	// Webhook is the status of the push webhook, none when there is none;
+	// it counts as healthy when webhooks are disabled
+	Webhook string `json:"webhook"`
+}
+
+// Source states of a repository
+const (
+	SourceActive   = "active"
+	SourceArchived = "archived"
+	SourceDeleted  = "deleted"
+)
+
+// Actions applied to targets when the source is archived or deleted
+const (
+	ArchiveActionFlag    = "flag"
+	ArchiveActionArchive = "archive"
+	ArchiveActionBanner  = "banner"
+)
+
 // Target represents a replication target for a repository
 type Target struct {
-	ID           string    `json:"id"`
-	RepositoryID string    `json:"repository_id"`
-	Provider     string    `json:"provider"`
-	RemoteURL    string    `json:"remote_url"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           string  `json:"id"`
+	RepositoryID string  `json:"repository_id"`
+	Provider     string  `json:"provider"`
+	RemoteURL    string  `json:"remote_url"`
+	CredentialID *string `json:"credential_id,omitempty"`
+	// Priority orders the targets of a sync, lowest first, so critical
+	// mirrors are updated before the rest
+	Priority int `json:"priority"`
+	// Required targets must succeed for a sync to succeed; the others are
+	// best-effort
+	Required bool `json:"required"`
+	// ExcludeRefs are ref patterns never pushed to the target, such as
+	// refs/heads/internal/*
+	ExcludeRefs []string `json:"exclude_refs"`
+	// Mode is all, tags or subdirectory. A tags target receives only the
+	// tags matching TagPattern, if set, and only annotated (including
+	// signed) ones when AnnotatedTagsOnly is set. A subdirectory target
+	// receives the branches and tags with the history of Subdirectory only,
+	// split off as the root of the repository.
+	Mode              string  `json:"mode"`
+	TagPattern        *string `json:"tag_pattern,omitempty"`
+	AnnotatedTagsOnly bool    `json:"annotated_tags_only"`
+	Subdirectory      *string `json:"subdirectory,omitempty"`
+	// Rewrite rewrites the history pushed to the target; nil pushes the
+	// source history as is
+	Rewrite *RewriteRules `json:"rewrite,omitempty"`
+	// HTTPHeaders are sent with git and provider API requests to the
+	// target, for remotes behind authenticating proxies
+	HTTPHeaders HTTPHeaders `json:"http_headers,omitempty"`
+	// Protected targets receive force pushes and ref deletions only once
+	// two operators approved them
+	Protected bool `json:"protected"`
+	// ApprovalState is pending_approval until an admin approves a target
+	// created by a non-admin; only approved targets are synced
+	ApprovalState string     `json:"approval_state"`
+	CreatedBy     *string    `json:"created_by,omitempty"`
+	ApprovedBy    *string    `json:"approved_by,omitempty"`
+	ApprovedAt    *time.Time `json:"approved_at,omitempty"`
+	ExternalID    *string    `json:"external_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	// SyncFailures counts the syncs of the target that failed in a row.
+	// Failed syncs are retried at RetryAt until too many failed, when the
+	// target is dead-lettered: left out of syncs until it is re-driven.
+	SyncFailures   int        `json:"sync_failures"`
+	RetryAt        *time.Time `json:"retry_at,omitempty"`
+	DeadLetteredAt *time.Time `json:"dead_lettered_at,omitempty"`
+	// LastExecution is the latest sync of the target, set when getting
+	// its repository
+	LastExecution *Execution `json:"last_execution,omitempty"`
+}
+
+// TargetRedrive is the outcome of re-driving a dead-lettered target
+type TargetRedrive struct {
+	TargetID string `json:"target_id"`
+	// Run is the sync of the target queued, unless the repository was
+	// queued or syncing already, as Detail explains
+	Run    *SyncRun `json:"run,omitempty"`
+	Detail string   `json:"detail,omitempty"`
+}
+
+// RewriteRules filter the history pushed to a target. Rewritten commits
+// differ from the source ones, but the same source commit and rules always
+// give the same rewritten commit.
+type RewriteRules struct {
+	// MaxBlobMB drops files larger than this many MiB; 0 keeps them all
+	MaxBlobMB int `json:"max_blob_mb,omitempty" yaml:"max_blob_mb"`
+	// ExcludePaths drops the files matching these path.Match patterns,
+	// or below a matching directory, such as internal or *.key
+	ExcludePaths []string `json:"exclude_paths,omitempty" yaml:"exclude_paths"`
+}
+
+// Value implements driver.Valuer
+func (r RewriteRules) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+// Scan implements sql.Scanner
+func (r *RewriteRules) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	default:
+		return fmt.Errorf("cannot scan %T into RewriteRules", src)
+	}
+}
+
+// HTTPHeaders are extra HTTP headers by name, stored as JSONB. Their values
+// may be secrets, such as gateway tokens, so only the names are ever
+// rendered as JSON.
+type HTTPHeaders map[string]string
+
+// MarshalJSON renders the sorted header names
+func (h HTTPHeaders) MarshalJSON() ([]byte, error) {
+	return json.Marshal(slices.Sorted(maps.Keys(h)))
+}
+
+// SchemaAs documents HTTPHeaders as the list MarshalJSON renders
+func (HTTPHeaders) SchemaAs() any {
+	return []string{}
+}
+
+// Value implements driver.Valuer. No headers are stored as NULL.
+func (h HTTPHeaders) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]string(h))
+}
+
+// Scan implements sql.Scanner
+func (h *HTTPHeaders) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into HTTPHeaders", src)
+	}
+	return json.Unmarshal(data, (*map[string]string)(h))
+}
+
+// IdentityRule replaces an identity in the history pushed to a target,
+// like a line of a mailmap file
+type IdentityRule struct {
+	// MatchEmail is the address replaced, compared case-insensitively;
+	// *@example.com matches every address of the domain
+	MatchEmail string `json:"match_email" yaml:"match_email"`
+	// MatchName limits the rule to this name; such rules win over those
+	// matching the address alone
+	MatchName string `json:"match_name,omitempty" yaml:"match_name"`
+	// Name and Email replace the matched ones; either is kept when empty
+	Name  string `json:"name,omitempty" yaml:"name"`
+	Email string `json:"email,omitempty" yaml:"email"`
+}
+
+// IdentityRules are stored as JSONB
+type IdentityRules []IdentityRule
+
+// Value implements driver.Valuer
+func (r IdentityRules) Value() (driver.Value, error) {
+	if r == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(r)
+}
+
+// Scan implements sql.Scanner
+func (r *IdentityRules) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	default:
+		return fmt.Errorf("cannot scan %T into IdentityRules", src)
+	}
+}
+
+// IdentityMapping is a version of the identity rules of a target. The
+// latest version applies to the authors, committers and taggers of the
+// history pushed to it; a version without rules stops the rewriting.
+type IdentityMapping struct {
+	TargetID  string        `json:"target_id"`
+	Version   int           `json:"version"`
+	Rules     IdentityRules `json:"rules"`
+	CreatedBy *string       `json:"created_by,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// IdentityMappingRequest is the request body for a new version of the
+// identity mapping of a target
+type IdentityMappingRequest struct {
+	Rules []IdentityRule `json:"rules"`
+}
+
+// BranchDiff compares a branch of the source with the same branch on a
+// target
+type BranchDiff struct {
+	Ref       string `json:"ref"`
+	SourceSHA string `json:"source_sha"`
+	TargetSHA string `json:"target_sha"`
+	// Behind counts the source commits missing from the target, Ahead the
+	// target commits missing from the source
+	Behind int `json:"behind"`
+	Ahead  int `json:"ahead"`
+}
+
+// TargetDiff is the drift of a target from the source of its repository
+type TargetDiff struct {
+	TargetID string `json:"target_id"`
+	InSync   bool   `json:"in_sync"`
+	// Branches lists the branches present on both sides that differ
+	Branches []BranchDiff `json:"branches"`
+	// MissingRefs are source branches and tags absent from the target,
+	// ExtraRefs target branches and tags absent from the source
+	MissingRefs []string `json:"missing_refs"`
+	ExtraRefs   []string `json:"extra_refs"`
+	// MovedTags are tags present on both sides pointing at different
+	// objects
+	MovedTags  []string  `json:"moved_tags"`
+	ComparedAt time.Time `json:"compared_at"`
 }
 
+// DefaultTargetPriority is the priority of targets created without one
+const DefaultTargetPriority = 100
+
+// Replication modes of a target
+const (
+	TargetModeAll          = "all"
+	TargetModeTags         = "tags"
+	TargetModeSubdirectory = "subdirectory"
+)
+
+// Approval states of a target
+const (
+	ApprovalPending  = "pending_approval"
+	ApprovalApproved = "approved"
+)
+
 // CreateRepositoryRequest is the request body for creating a repository
 type CreateRepositoryRequest struct {
 	Name           string `json:"name"`
 	SourceProvider string `json:"source_provider"`
 	SourceURL      string `json:"source_url"`
+	// AlternateSourceURLs are fetched in order when SourceURL is unreachable
+	AlternateSourceURLs []string `json:"alternate_source_urls,omitempty"`
+	// Credential is the name of a credential profile used to access the source
+	Credential string `json:"credential,omitempty"`
+	// ArchiveAction is applied to targets when the source is archived or
+	// deleted: flag, archive or banner
+	ArchiveAction  string `json:"archive_action,omitempty"`
+	Labels         Labels `json:"labels,omitempty"`
+	Owner          string `json:"owner,omitempty"`
+	Team           string `json:"team,omitempty"`
+	SyncSLOSeconds *int   `json:"sync_slo_seconds,omitempty"`
+	CloneFilter    string `json:"clone_filter,omitempty"`
+	CachePool      string `json:"cache_pool,omitempty"`
+	// SyncSchedule is a cron expression that schedules syncs
+	SyncSchedule string `json:"sync_schedule,omitempty"`
+	// SyncWindow restricts when scheduled syncs may start
+	SyncWindow *SyncWindow `json:"sync_window,omitempty"`
+	ExternalID string      `json:"external_id,omitempty"`
+	// SkipDefaultTargets opts a new repository out of the default targets
+	SkipDefaultTargets bool `json:"skip_default_targets,omitempty"`
+	// Template names a repository template whose settings fill in those
+	// the request leaves unset
+	Template string `json:"template,omitempty"`
+}
+
+// UpdateRepositoryRequest changes the fields of a repository it sets,
+// leaving the others as they are
+type UpdateRepositoryRequest struct {
+	Name           *string `json:"name,omitempty"`
+	SourceProvider *string `json:"source_provider,omitempty"`
+	SourceURL      *string `json:"source_url,omitempty"`
+	// SyncSchedule is a cron expression that schedules syncs; empty
+	// removes it
+	SyncSchedule *string `json:"sync_schedule,omitempty"`
+	Enabled      *bool   `json:"enabled,omitempty"`
+}
+
+// CSVImportResult reports an import of a CSV inventory. Nothing is written
+// unless every row is valid.
+type CSVImportResult struct {
+	// ValidateOnly is set when the rows were only checked
+	ValidateOnly bool `json:"validate_only"`
+	// Repositories and Targets count what the rows create
+	Repositories int              `json:"repositories"`
+	Targets      int              `json:"targets"`
+	Errors       []ImportRowError `json:"errors"`
+	// Created are the repositories created, with their targets
+	Created []Repository `json:"created,omitempty"`
+}
+
+// ImportRowError is why a row of an import was rejected
+type ImportRowError struct {
+	// Line is the line of the row in the file, the header being line 1
+	Line int `json:"line"`
+	// Column is the column at fault, when the error is about one
+	Column string `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+// MergeRepositoryRequest names the duplicate merged into a repository
+type MergeRepositoryRequest struct {
+	// DuplicateID is the repository of the same upstream that is merged
+	// and then deleted
+	DuplicateID string `json:"duplicate_id"`
+}
+
+// RepositoryMerge reports what a merge moved from the duplicate
+type RepositoryMerge struct {
+	RepositoryID string `json:"repository_id"`
+	DuplicateID  string `json:"duplicate_id"`
+	// MovedTargets are the targets of the duplicate that now belong to the
+	// repository
+	MovedTargets []string `json:"moved_targets"`
+	// MergedTargets are the targets of the duplicate whose remote the
+	// repository replicates to as well; their history moved to the target
+	// of the repository and they were deleted
+	MergedTargets []string `json:"merged_targets"`
+	// Executions is the number of sync executions moved
+	Executions int64 `json:"executions"`
+}
+
+// RepositoryPromotion reports a target promoted to the source of its
+// repository
+type RepositoryPromotion struct {
+	RepositoryID string `json:"repository_id"`
+	// PromotedTargetID is the target that became the source. It is in the
+	// trash with its sync history.
+	PromotedTargetID       string `json:"promoted_target_id"`
+	SourceProvider         string `json:"source_provider"`
+	SourceURL              string `json:"source_url"`
+	PreviousSourceProvider string `json:"previous_source_provider"`
+	PreviousSourceURL      string `json:"previous_source_url"`
+	// DemotedTarget is the target the previous source became, when it was
+	// demoted
+	DemotedTarget *Target `json:"demoted_target,omitempty"`
+	// DroppedAlternateSourceURLs are the alternate sources removed because
+	// they are the new source or of another provider
+	DroppedAlternateSourceURLs []string `json:"dropped_alternate_source_urls"`
+	// SyncQueued reports whether a sync from the new source was queued
+	SyncQueued bool `json:"sync_queued"`
 }
 
 // CreateTargetRequest is the request body for creating a target
 type CreateTargetRequest struct {
-	Provider  string `json:"provider"`
+	Provider string `json:"provider"`
+	// RemoteURL may hold {{org}}, {{name}}, {{path}} and {{host}}, which
+	// are filled in from the source URL of the repository
 	RemoteURL string `json:"remote_url"`
+	// Credential is the name of a credential profile used to push to the target
+	Credential string `json:"credential,omitempty"`
+	// Priority defaults to 100 and Required to true
+	Priority    *int     `json:"priority,omitempty"`
+	Required    *bool    `json:"required,omitempty"`
+	ExcludeRefs []string `json:"exclude_refs,omitempty"`
+	// Mode defaults to all; TagPattern (such as v*) and AnnotatedTagsOnly
+	// need mode tags, and Subdirectory (such as services/api) mode
+	// subdirectory
+	Mode              string `json:"mode,omitempty"`
+	TagPattern        string `json:"tag_pattern,omitempty"`
+	AnnotatedTagsOnly bool   `json:"annotated_tags_only,omitempty"`
+	Subdirectory      string `json:"subdirectory,omitempty"`
+	ExternalID        string `json:"external_id,omitempty"`
+	// Rewrite filters the history pushed to the target
+	Rewrite *RewriteRules `json:"rewrite,omitempty"`
+	// HTTPHeaders are sent with git and provider API requests to the
+	// target by name, such as a gateway token or X-Org. An Authorization
+	// header replaces the credential in git requests, for remotes with
+	// another auth scheme than basic. Values are never returned.
+	HTTPHeaders map[string]string `json:"http_headers,omitempty"`
+	// Protected holds force pushes and ref deletions for the approval of
+	// two operators
+	Protected bool `json:"protected,omitempty"`
+}
+
+// UpdateTargetRequest changes the fields of a target it sets, leaving the
+// others as they are
+type UpdateTargetRequest struct {
+	RemoteURL *string `json:"remote_url,omitempty"`
+	// Credential is the name of a credential profile; empty removes it
+	Credential  *string  `json:"credential,omitempty"`
+	Priority    *int     `json:"priority,omitempty"`
+	Required    *bool    `json:"required,omitempty"`
+	ExcludeRefs []string `json:"exclude_refs,omitempty"`
+	Protected   *bool    `json:"protected,omitempty"`
+}
+
+// BulkTargetRequest adds the same target to many repositories. Its remote
+// URL is a template such as ssh://git@gitea.corp/{{org}}/{{name}}.git,
+// filled in from the source of each repository.
+type BulkTargetRequest struct {
+	RepositoryIDs []string            `json:"repository_ids"`
+	Target        CreateTargetRequest `json:"target"`
+}
+
+// BulkTargetResult reports a bulk creation of targets. Nothing is created
+// unless every target is valid.
+type BulkTargetResult struct {
+	// ValidateOnly is set when the targets were only checked
+	ValidateOnly bool              `json:"validate_only"`
+	Targets      int               `json:"targets"`
+	Errors       []BulkTargetError `json:"errors"`
+	Created      []Target          `json:"created,omitempty"`
+}
+
+// BulkTargetError is why the target of a repository was rejected
+type BulkTargetError struct {
+	RepositoryID string `json:"repository_id"`
+	// RemoteURL is the remote URL the template resolved to, when it did
+	RemoteURL string `json:"remote_url,omitempty"`
+	Error     string `json:"error"`
+}
+
+// Credential is a named, reusable secret. The secret itself is never
+// returned by the API.
+type Credential struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Provider string  `json:"provider"`
+	Host     *string `json:"host,omitempty"`
+	// Kind is one of the Credential kinds
+	Kind string `json:"kind"`
+	// Username goes with the password of basic credentials
+	Username  *string   `json:"username,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Kinds of credential profiles
+const (
+	// CredentialToken is a provider API token, which authenticates git over
+	// HTTPS as well
+	CredentialToken = "token"
+	// CredentialBasic is a username and password for git over HTTPS only
+	CredentialBasic = "basic"
+	// CredentialSSHKey is a private key, in OpenSSH or PEM format, for git
+	// over SSH only
+	CredentialSSHKey = "ssh_key"
+)
+
+// CreateCredentialRequest is the request body for creating a credential profile
+type CreateCredentialRequest struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Host     string `json:"host,omitempty"`
+	// Kind is token when empty
+	Kind     string `json:"kind,omitempty"`
+	Username string `json:"username,omitempty"`
+	Secret   string `json:"secret"`
+}
+
+// ReplaceCredentialRequest is the request body for rotating a credential's secret
+type ReplaceCredentialRequest struct {
+	Secret string `json:"secret"`
+	// Username replaces the username of basic credentials when set
+	Username string `json:"username,omitempty"`
+	// Verify checks the new secret against the provider before replacing
+	Verify bool `json:"verify"`
+}
+
+// CredentialUsage lists the resources that reference a credential profile
+type CredentialUsage struct {
+	Repositories []Repository `json:"repositories"`
+	Targets      []Target     `json:"targets"`
+}
+
+// ExecutionStatus is the state of a replication execution
+type ExecutionStatus string
+
+const (
+	ExecutionPending   ExecutionStatus = "pending"
+	ExecutionRunning   ExecutionStatus = "running"
+	ExecutionSuccess   ExecutionStatus = "success"
+	ExecutionFailed    ExecutionStatus = "failed"
+	ExecutionThrottled ExecutionStatus = "throttled"
+	// ExecutionAwaitingApproval is a sync of a protected target held back
+	// until its force pushes and ref deletions are approved
+	ExecutionAwaitingApproval ExecutionStatus = "awaiting_approval"
+)
+
+// Execution records a single replication run of a repository to a target
+type Execution struct {
+	ID           string          `json:"id"`
+	RepositoryID string          `json:"repository_id"`
+	TargetID     string          `json:"target_id"`
+	Status       ExecutionStatus `json:"status"`
+	Error        *string         `json:"error,omitempty"`
+	// SourceURL is the source or alternate source the run fetched from
+	SourceURL *string `json:"source_url,omitempty"`
+	// Trigger is what started the sync, such as schedule:stale or
+	// group:<run id>
+	Trigger    *string    `json:"trigger,omitempty"`
+	RetryAt    *time.Time `json:"retry_at,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// NotificationChannel is an outbound destination for notification events
+type NotificationChannel struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	URL         string   `json:"url"`
+	Template    *string  `json:"template,omitempty"`
+	ContentType string   `json:"content_type"`
+	Events      []string `json:"events"`
+	// RepositoryIDs and LabelSelector limit the channel to events about
+	// matching repositories; both empty means every repository
+	RepositoryIDs []string  `json:"repository_ids"`
+	LabelSelector *string   `json:"label_selector,omitempty"`
+	Enabled       bool      `json:"enabled"`
+	ExternalID    *string   `json:"external_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Secret authenticates incident channels and is never returned
+	Secret string `json:"-"`
+}
+
+// CreateNotificationChannelRequest is the request body for creating a channel
+type CreateNotificationChannelRequest struct {
+	Name string `json:"name"`
+	// Type selects the payload format; "template" renders Template
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	Template    string `json:"template,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Events limits the channel to these event types; empty means all
+	Events        []string `json:"events,omitempty"`
+	RepositoryIDs []string `json:"repository_ids,omitempty"`
+	LabelSelector string   `json:"label_selector,omitempty"`
+	// Secret is the PagerDuty routing key or Opsgenie API key of incident
+	// channels
+	Secret     string `json:"secret,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+// NotificationDelivery is the log entry of one event sent to a channel
+type NotificationDelivery struct {
+	ID           string     `json:"id"`
+	ChannelID    string     `json:"channel_id"`
+	EventType    string     `json:"event_type"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	ResponseCode *int       `json:"response_code,omitempty"`
+	Error        *string    `json:"error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// NotificationRule routes matching events to a set of channels. Rules are
+// evaluated in ascending priority; a matching rule with Stop set ends
+// evaluation.
+type NotificationRule struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Priority      int      `json:"priority"`
+	EventTypes    []string `json:"event_types"`
+	LabelSelector *string  `json:"label_selector,omitempty"`
+	Providers     []string `json:"providers"`
+	MinSeverity   *string  `json:"min_severity,omitempty"`
+	// ActiveFrom and ActiveTo bound the time of day (HH:MM in Timezone)
+	// during which the rule applies; the window may wrap past midnight
+	ActiveFrom *string   `json:"active_from,omitempty"`
+	ActiveTo   *string   `json:"active_to,omitempty"`
+	Timezone   string    `json:"timezone"`
+	ChannelIDs []string  `json:"channel_ids"`
+	Stop       bool      `json:"stop"`
+	Enabled    bool      `json:"enabled"`
+	ExternalID *string   `json:"external_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// NotificationRuleRequest is the request body for creating or replacing a rule
+type NotificationRuleRequest struct {
+	Name          string   `json:"name"`
+	Priority      *int     `json:"priority,omitempty"`
+	EventTypes    []string `json:"event_types,omitempty"`
+	LabelSelector string   `json:"label_selector,omitempty"`
+	Providers     []string `json:"providers,omitempty"`
+	MinSeverity   string   `json:"min_severity,omitempty"`
+	ActiveFrom    string   `json:"active_from,omitempty"`
+	ActiveTo      string   `json:"active_to,omitempty"`
+	Timezone      string   `json:"timezone,omitempty"`
+	ChannelIDs    []string `json:"channel_ids"`
+	Stop          bool     `json:"stop"`
+	Enabled       *bool    `json:"enabled,omitempty"`
+	ExternalID    string   `json:"external_id,omitempty"`
+}
+
+// Notification is an inbox entry as seen by one user
+type Notification struct {
+	ID           string    `json:"id"`
+	EventType    string    `json:"event_type"`
+	Severity     string    `json:"severity"`
+	Title        string    `json:"title"`
+	Message      string    `json:"message"`
+	RepositoryID *string   `json:"repository_id,omitempty"`
+	TargetID     *string   `json:"target_id,omitempty"`
+	Read         bool      `json:"read"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Policy is an admin-defined constraint on the sources and targets of
+// repositories. Kind selects which of Domains, Providers and Scope apply.
+type Policy struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Kind          string  `json:"kind"`
+	LabelSelector *string `json:"label_selector,omitempty"`
+	// Domains lists the allowed target hosts of allowed_target_domains;
+	// "*.example.com" matches any subdomain
+	Domains []string `json:"domains"`
+	// Providers lists the providers whose public hosts
+	// forbid_public_providers rejects; empty means all
+	Providers []string `json:"providers"`
+	// Scope is what require_ssh applies to: sources, targets or all
+	Scope      string    `json:"scope"`
+	Enabled    bool      `json:"enabled"`
+	ExternalID *string   `json:"external_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PolicyRequest is the request body for creating or replacing a policy
+type PolicyRequest struct {
+	Name          string   `json:"name"`
+	Kind          string   `json:"kind"`
+	LabelSelector string   `json:"label_selector,omitempty"`
+	Domains       []string `json:"domains,omitempty"`
+	Providers     []string `json:"providers,omitempty"`
+	Scope         string   `json:"scope,omitempty"`
+	Enabled       *bool    `json:"enabled,omitempty"`
+	ExternalID    string   `json:"external_id,omitempty"`
+}
+
+// PolicyViolation records a create or sync blocked by a policy
+type PolicyViolation struct {
+	ID           string    `json:"id"`
+	PolicyID     *string   `json:"policy_id,omitempty"`
+	PolicyName   string    `json:"policy_name"`
+	Stage        string    `json:"stage"`
+	RepositoryID *string   `json:"repository_id,omitempty"`
+	TargetID     *string   `json:"target_id,omitempty"`
+	URL          string    `json:"url"`
+	Message      string    `json:"message"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Integrity finding kinds
+const (
+	// FindingMirrorCorrupt is a cached mirror failing git fsck after a fetch
+	FindingMirrorCorrupt = "mirror_corrupt"
+	// FindingTargetMismatch is a target whose refs differ from those pushed
+	FindingTargetMismatch = "target_mismatch"
+)
+
+// IntegrityFinding records corruption found by mirror verification
+type IntegrityFinding struct {
+	ID           string    `json:"id"`
+	RepositoryID string    `json:"repository_id"`
+	TargetID     *string   `json:"target_id,omitempty"`
+	ExecutionID  *string   `json:"execution_id,omitempty"`
+	Kind         string    `json:"kind"`
+	Detail       string    `json:"detail"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Push approval statuses
+const (
+	PushApprovalPending  = "pending"
+	PushApprovalApproved = "approved"
+	PushApprovalRejected = "rejected"
+	// PushApprovalExecuted approvals were pushed to their target
+	PushApprovalExecuted = "executed"
+	// PushApprovalSuperseded approvals were replaced by one for other
+	// changes, as the source moved on before they were pushed
+	PushApprovalSuperseded = "superseded"
+)
+
+// PushApproval holds the force pushes and ref deletions a sync would make
+// on a protected target until two operators approve them
+type PushApproval struct {
+	ID           string `json:"id"`
+	RepositoryID string `json:"repository_id"`
+	TargetID     string `json:"target_id"`
+	// ExecutionID is the execution held for the approval
+	ExecutionID *string `json:"execution_id,omitempty"`
+	Status      string  `json:"status"`
+	// Forced are the refs that would be moved to commits not descending
+	// from those last pushed, and Deleted the refs that would be deleted
+	Forced  []string `json:"forced"`
+	Deleted []string `json:"deleted"`
+	// Fingerprint identifies the changes, with the commits they move refs
+	// from and to
+	Fingerprint string     `json:"fingerprint"`
+	ApprovedBy  []string   `json:"approved_by"`
+	RejectedBy  *string    `json:"rejected_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	ExecutedAt  *time.Time `json:"executed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Anomaly kinds
+const (
+	// AnomalySizeGrowth is a fetch growing a mirror many times over
+	AnomalySizeGrowth = "size_growth"
+	// AnomalyRefDeletions is a fetch deleting many refs at once
+	AnomalyRefDeletions = "ref_deletions"
+	// AnomalyHistoryRewrite is a fetch rewriting the history of a ref
+	// expected to only move forward, such as the main branch
+	AnomalyHistoryRewrite = "history_rewrite"
+)
+
+// Anomaly is a change of a source suspicious enough to pause syncs of its
+// repository to the targets until an admin acknowledges it, protecting
+// them from a compromised or mistaken source
+type Anomaly struct {
+	ID           string   `json:"id"`
+	RepositoryID string   `json:"repository_id"`
+	Kinds        []string `json:"kinds"`
+	// SizeBefore and SizeAfter are the size of the mirror around the fetch
+	SizeBefore int64 `json:"size_before_bytes"`
+	SizeAfter  int64 `json:"size_after_bytes"`
+	// Deleted are the refs the fetch deleted, and Rewritten those whose
+	// history it rewrote
+	Deleted        []string   `json:"deleted"`
+	Rewritten      []string   `json:"rewritten"`
+	Acknowledged   bool       `json:"acknowledged"`
+	AcknowledgedBy *string    `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// SecretFinding is a possible credential found in commits about to be
+// pushed. A sync holding one is quarantined: its targets fail without
+// pushing until the commits are rewritten at the source or an admin allows
+// the finding.
+type SecretFinding struct {
+	ID           string  `json:"id"`
+	RepositoryID string  `json:"repository_id"`
+	TargetID     *string `json:"target_id,omitempty"`
+	ExecutionID  *string `json:"execution_id,omitempty"`
+	// Rule names the pattern that matched, such as aws-access-key
+	Rule   string `json:"rule"`
+	Commit string `json:"commit"`
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	// Match is the matched text with all but its first characters masked
+	Match string `json:"match"`
+	// Fingerprint identifies the finding across syncs
+	Fingerprint string     `json:"fingerprint"`
+	Allowed     bool       `json:"allowed"`
+	AllowedBy   *string    `json:"allowed_by,omitempty"`
+	AllowedAt   *time.Time `json:"allowed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ComplianceExport is a signed archive of audit and sync records for a period
+type ComplianceExport struct {
+	ID          string    `json:"id"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Format      string    `json:"format"`
+	Status      string    `json:"status"`
+	RequestedBy string    `json:"requested_by"`
+	// Location is the object storage URL when the archive was uploaded
+	Location   *string    `json:"location,omitempty"`
+	SHA256     *string    `json:"sha256,omitempty"`
+	Error      *string    `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	FilePath   *string    `json:"-"`
+}
+
+// CreateComplianceExportRequest is the request body for starting an export
+type CreateComplianceExportRequest struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Format is csv (default) or json (JSON Lines)
+	Format string `json:"format,omitempty"`
+}
+
+// Backup snapshot statuses
+const (
+	SnapshotRunning   = "running"
+	SnapshotSucceeded = "succeeded"
+	SnapshotFailed    = "failed"
+)
+
+// BackupSnapshot is a git bundle of every ref of a repository kept in
+// object storage
+type BackupSnapshot struct {
+	ID             string `json:"id"`
+	RepositoryID   string `json:"repository_id"`
+	RepositoryName string `json:"repository_name"`
+	Status         string `json:"status"`
+	// Location is the URL of the bundle in object storage
+	Location   *string    `json:"location,omitempty"`
+	SizeBytes  *int64     `json:"size_bytes,omitempty"`
+	SHA256     *string    `json:"sha256,omitempty"`
+	Error      *string    `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ObjectKey  *string    `json:"-"`
+}
+
+// RestoreRequest is the request body for restoring a repository from a
+// backup snapshot. Exactly one of TargetID and RemoteURL is set.
+type RestoreRequest struct {
+	// SnapshotID defaults to the latest successful snapshot
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	// TargetID is a target of the repository to restore
+	TargetID string `json:"target_id,omitempty"`
+	// RemoteURL is a new remote to restore to, of Provider, pushed to with
+	// the credential profile named Credential
+	RemoteURL  string `json:"remote_url,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	Credential string `json:"credential,omitempty"`
+}
+
+// RestoreResult describes a completed restore
+type RestoreResult struct {
+	SnapshotID string   `json:"snapshot_id"`
+	RemoteURL  string   `json:"remote_url"`
+	Refs       []string `json:"refs"`
+}
+
+// MirrorStats describe how the objects of a cached mirror are stored
+type MirrorStats struct {
+	LooseObjects int `json:"loose_objects"`
+	Packs        int `json:"packs"`
+	// SizeBytes is the size of the loose objects and packs
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// MaintenanceResult describes a completed repack of the cached mirror of a
+// repository
+type MaintenanceResult struct {
+	RepositoryID string `json:"repository_id"`
+	// Pooled is set when the mirror borrows its objects from an object
+	// pool; the pool is then repacked and described instead
+	Pooled     bool        `json:"pooled"`
+	Before     MirrorStats `json:"before"`
+	After      MirrorStats `json:"after"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+}
+
+// BackupStatus rolls up the latest snapshot of every repository
+type BackupStatus struct {
+	Enabled bool `json:"enabled"`
+	// Store is the object storage URL snapshots are written to
+	Store           string `json:"store,omitempty"`
+	IntervalSeconds int    `json:"interval_seconds,omitempty"`
+	RetentionDays   int    `json:"retention_days,omitempty"`
+	Repositories    int    `json:"repositories"`
+	// Current repositories have a successful snapshot from the last two
+	// intervals; Failing ones failed their latest snapshot; Stale ones
+	// have no snapshot or only an older one
+	Current        int        `json:"current"`
+	Failing        int        `json:"failing"`
+	Stale          int        `json:"stale"`
+	Snapshots      int        `json:"snapshots"`
+	StoredBytes    int64      `json:"stored_bytes"`
+	LastSnapshotAt *time.Time `json:"last_snapshot_at,omitempty"`
+}
+
+// Consistency audit statuses
+const (
+	ConsistencyAuditRunning   = "running"
+	ConsistencyAuditCompleted = "completed"
+	ConsistencyAuditFailed    = "failed"
+)
+
+// Consistency audit result statuses. Corrupt targets advertise the refs of
+// the source but failed to serve the objects of a sampled ref.
+const (
+	ConsistencyInSync  = "in_sync"
+	ConsistencyDrifted = "drifted"
+	ConsistencyCorrupt = "corrupt"
+	ConsistencyError   = "error"
+	ConsistencySkipped = "skipped"
+)
+
+// ConsistencyAudit is a comparison of every approved target with its
+// source, run apart from syncs
+type ConsistencyAudit struct {
+	ID string `json:"id"`
+	// Trigger is schedule or manual
+	Trigger string `json:"trigger"`
+	Status  string `json:"status"`
+	Targets int    `json:"targets"`
+	InSync  int    `json:"in_sync"`
+	Drifted int    `json:"drifted"`
+	Corrupt int    `json:"corrupt"`
+	Errors  int    `json:"errors"`
+	// Skipped targets belong to repositories that were busy syncing
+	Skipped    int        `json:"skipped"`
+	Error      *string    `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ConsistencyResult is the outcome of an audit for one target
+type ConsistencyResult struct {
+	RepositoryID   string      `json:"repository_id"`
+	RepositoryName string      `json:"repository_name"`
+	TargetID       string      `json:"target_id"`
+	RemoteURL      string      `json:"remote_url"`
+	Status         string      `json:"status"`
+	Diff           *TargetDiff `json:"diff,omitempty"`
+	// SampledRefs are the refs whose objects were fetched from the target,
+	// and FailedSamples those the target failed to serve
+	SampledRefs   []string  `json:"sampled_refs"`
+	FailedSamples []string  `json:"failed_samples"`
+	Detail        *string   `json:"detail,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// ConsistencyReport is an audit with the results of its targets
+type ConsistencyReport struct {
+	ConsistencyAudit
+	Results []ConsistencyResult `json:"results"`
+}
+
+// SyncStatsBucket aggregates the finished syncs started in one bucket
+type SyncStatsBucket struct {
+	Start     time.Time `json:"start"`
+	Syncs     int       `json:"syncs"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	// SuccessRate and AverageDurationSeconds are omitted without syncs
+	SuccessRate            *float64 `json:"success_rate,omitempty"`
+	BytesPushed            int64    `json:"bytes_pushed"`
+	AverageDurationSeconds *float64 `json:"average_duration_seconds,omitempty"`
+}
+
+// RepositorySyncStats counts the finished syncs of one repository
+type RepositorySyncStats struct {
+	RepositoryID string `json:"repository_id"`
+	// Name is empty for repositories deleted since
+	Name        string   `json:"name,omitempty"`
+	Syncs       int      `json:"syncs"`
+	Succeeded   int      `json:"succeeded"`
+	Failed      int      `json:"failed"`
+	SuccessRate *float64 `json:"success_rate,omitempty"`
+}
+
+// SyncStats aggregates the finished syncs between Since and Until into
+// buckets of GroupBy, by the UTC day they started on
+type SyncStats struct {
+	GroupBy    string                `json:"group_by"`
+	Since      time.Time             `json:"since"`
+	Until      time.Time             `json:"until"`
+	Totals     SyncStatsBucket       `json:"totals"`
+	Buckets    []SyncStatsBucket     `json:"buckets"`
+	TopFailing []RepositorySyncStats `json:"top_failing"`
+}
+
+// Retention is how long each kind of repository data is kept, in days
+type Retention struct {
+	// HistoryDays applies to execution records
+	HistoryDays int `json:"history_days"`
+	// LogDays applies to the error output stored with executions
+	LogDays int `json:"log_days"`
+	// CacheDays applies to cached mirror clones that have not been used
+	CacheDays int `json:"cache_days"`
+}
+
+// RetentionOverrides are the per-repository retention settings; nil fields
+// fall back to the global default
+type RetentionOverrides struct {
+	HistoryDays *int `json:"history_days"`
+	LogDays     *int `json:"log_days"`
+	CacheDays   *int `json:"cache_days"`
+}
+
+// RepositoryRetention reports a repository's overrides and the values in effect
+type RepositoryRetention struct {
+	RepositoryID string             `json:"repository_id"`
+	Overrides    RetentionOverrides `json:"overrides"`
+	Effective    Retention          `json:"effective"`
+}
+
+// Transfer tunes how git fetches from sources and pushes to targets. Nil
+// fields fall back to the global settings, and unset global settings to
+// the defaults of git. It is stored as JSONB.
+type Transfer struct {
+	// ProtocolVersion is the git wire protocol version, 0, 1 or 2. With
+	// version 2 fetches only list the refs they ask for.
+	ProtocolVersion *int `json:"protocol_version"`
+	// Negotiation is how fetches find the commits both sides already
+	// have: consecutive, skipping, which takes fewer round trips on
+	// histories with many refs, or noop, which sends none
+	Negotiation *string `json:"negotiation"`
+	// PackThreads is how many threads pack and index objects; 0 uses one
+	// per CPU
+	PackThreads *int `json:"pack_threads"`
+	// PackWindow is how many objects are tried as delta bases when packing
+	PackWindow *int `json:"pack_window"`
+	// PackWindowMemory caps the memory of the delta window of each thread,
+	// as a size such as 256m
+	PackWindowMemory *string `json:"pack_window_memory"`
+}
+
+// Value implements driver.Valuer; a Transfer without settings is NULL
+func (t Transfer) Value() (driver.Value, error) {
+	if t == (Transfer{}) {
+		return nil, nil
+	}
+	return json.Marshal(t)
+}
+
+// Scan implements sql.Scanner
+func (t *Transfer) Scan(src any) error {
+	*t = Transfer{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("cannot scan %T into Transfer", src)
+	}
+}
+
+// RepositoryTransfer reports a repository's transfer overrides and the
+// settings in effect
+type RepositoryTransfer struct {
+	RepositoryID string   `json:"repository_id"`
+	Overrides    Transfer `json:"overrides"`
+	Effective    Transfer `json:"effective"`
+}
+
+// SyncGroup is a named set of repositories that are synced, paused and
+// scheduled together
+type SyncGroup struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Description   *string  `json:"description,omitempty"`
+	RepositoryIDs []string `json:"repository_ids"`
+	// Paused groups are skipped by scheduled syncs and cannot be triggered
+	Paused bool `json:"paused"`
+	// SyncIntervalSeconds applies to members without their own interval
+	SyncIntervalSeconds *int      `json:"sync_interval_seconds,omitempty"`
+	ExternalID          *string   `json:"external_id,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// SyncGroupRequest is the body of POST /groups and PUT /groups/{id}
+type SyncGroupRequest struct {
+	Name                string   `json:"name"`
+	Description         string   `json:"description"`
+	RepositoryIDs       []string `json:"repository_ids"`
+	Paused              bool     `json:"paused"`
+	SyncIntervalSeconds *int     `json:"sync_interval_seconds"`
+	ExternalID          string   `json:"external_id"`
+}
+
+// Sync group statuses, rolled up from the latest execution of each
+// member's targets
+const (
+	GroupStatusPaused  = "paused"
+	GroupStatusSyncing = "syncing"
+	GroupStatusFailed  = "failed"
+	GroupStatusHealthy = "healthy"
+	GroupStatusNever   = "never_synced"
+)
+
+// SyncGroupStatus rolls up the state of a group's members. A member is
+// failed when the latest execution of one of its required targets failed.
+type SyncGroupStatus struct {
+	Status       string        `json:"status"`
+	Repositories int           `json:"repositories"`
+	Syncing      int           `json:"syncing"`
+	Failed       int           `json:"failed"`
+	Healthy      int           `json:"healthy"`
+	NeverSynced  int           `json:"never_synced"`
+	LastRun      *SyncGroupRun `json:"last_run,omitempty"`
+}
+
+// Sync group run statuses
+const (
+	GroupRunRunning   = "running"
+	GroupRunSucceeded = "succeeded"
+	GroupRunFailed    = "failed"
+)
+
+// SyncGroupRun is one triggered sync of every member of a group. Members
+// that were already syncing when it started are counted as skipped.
+type SyncGroupRun struct {
+	ID           string     `json:"id"`
+	GroupID      string     `json:"group_id"`
+	Status       string     `json:"status"`
+	TriggeredBy  *string    `json:"triggered_by,omitempty"`
+	Repositories int        `json:"repositories"`
+	Succeeded    int        `json:"succeeded"`
+	Failed       int        `json:"failed"`
+	Skipped      int        `json:"skipped"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// Sync run statuses
+const (
+	SyncRunQueued    = "queued"
+	SyncRunRunning   = "running"
+	SyncRunSucceeded = "succeeded"
+	SyncRunFailed    = "failed"
+)
+
+// SyncRun is one sync of a repository to its targets. It fails when the
+// sync could not start or a required target failed. Every sync is queued
+// until an instance claims it and running from then on; StartedAt is set
+// once the instance starts it.
+type SyncRun struct {
+	ID           string `json:"id"`
+	RepositoryID string `json:"repository_id"`
+	// Trigger is what started the sync, as recorded with its executions
+	Trigger string `json:"trigger"`
+	Status  string `json:"status"`
+	// TargetIDs limits the sync to these targets; empty syncs every
+	// approved target
+	TargetIDs []string `json:"target_ids"`
+	// Targets counts the targets synced, Succeeded and Failed their
+	// outcomes
+	Targets     int        `json:"targets"`
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	RequestedBy *string    `json:"requested_by,omitempty"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// DurationSeconds is set once the run finished
+	DurationSeconds *float64 `json:"duration_seconds,omitempty"`
+	// TraceParent is the W3C trace context of the request that queued the
+	// run when it was traced; the sync continues its trace
+	TraceParent *string `json:"traceparent,omitempty"`
+}
+
+// SyncRunResult is the execution of one target within a sync run
+type SyncRunResult struct {
+	ExecutionID     string          `json:"execution_id"`
+	TargetID        string          `json:"target_id"`
+	RemoteURL       string          `json:"remote_url"`
+	Status          ExecutionStatus `json:"status"`
+	Error           *string         `json:"error,omitempty"`
+	SourceURL       *string         `json:"source_url,omitempty"`
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      *time.Time      `json:"finished_at,omitempty"`
+	DurationSeconds *float64        `json:"duration_seconds,omitempty"`
+}
+
+// SyncRunDetail is a sync run with the results of its targets
+type SyncRunDetail struct {
+	SyncRun
+	Results []SyncRunResult `json:"results"`
+}
+
+// WebhookDelivery is how a push webhook delivery was handled
+type WebhookDelivery struct {
+	RepositoryID string `json:"repository_id"`
+	Ref          string `json:"ref"`
+	After        string `json:"after,omitempty"`
+	// Queued is false when the repository is already queued or syncing, or
+	// its scheduled syncs are held back, as Detail says
+	Queued bool   `json:"queued"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// FreezePeriod suspends automatic syncing of every repository between
+// StartsAt and EndsAt. Admins may still trigger syncs manually.
+type FreezePeriod struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Reason    *string   `json:"reason,omitempty"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedBy *string   `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FreezePeriodRequest is the body of POST /freeze-periods and
+// PUT /freeze-periods/{id}
+type FreezePeriodRequest struct {
+	Name     string    `json:"name"`
+	Reason   string    `json:"reason,omitempty"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+}
+
+// DefaultTarget is a target added to every repository created after it,
+// unless the repository opts out with skip_default_targets
+type DefaultTarget struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Target is the target added; its remote_url is a template such as
+	// ssh://git@dr.corp/{{org}}/{{name}}.git
+	Target    CreateTargetRequest `json:"target"`
+	CreatedBy *string             `json:"created_by,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// DefaultTargetRequest is the body of POST /default-targets and
+// PUT /default-targets/{id}
+type DefaultTargetRequest struct {
+	Name   string              `json:"name"`
+	Target CreateTargetRequest `json:"target"`
+}
+
+// RepositoryTemplate bundles configuration shared by many repositories. It
+// is applied when a repository is created from it and again whenever it is
+// re-applied; settings it leaves unset are the repositories' own.
+type RepositoryTemplate struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+	// Labels are merged into those of the repositories
+	Labels              Labels      `json:"labels"`
+	SyncIntervalSeconds *int        `json:"sync_interval_seconds,omitempty"`
+	SyncWindow          *SyncWindow `json:"sync_window,omitempty"`
+	SyncSLOSeconds      *int        `json:"sync_slo_seconds,omitempty"`
+	CloneFilter         *string     `json:"clone_filter,omitempty"`
+	// ExcludeRefs and the push mode, Mode with TagPattern and
+	// AnnotatedTagsOnly, are set on every target of the repositories
+	ExcludeRefs       []string `json:"exclude_refs,omitempty"`
+	Mode              *string  `json:"mode,omitempty"`
+	TagPattern        *string  `json:"tag_pattern,omitempty"`
+	AnnotatedTagsOnly bool     `json:"annotated_tags_only,omitempty"`
+	// NotificationRuleIDs are the notification rules the repositories are
+	// routed by; their label selectors match the labels of the template
+	NotificationRuleIDs []string  `json:"notification_rule_ids"`
+	CreatedBy           *string   `json:"created_by,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// RepositoryTemplateRequest is the body of POST /repository-templates and
+// PUT /repository-templates/{id}
+type RepositoryTemplateRequest struct {
+	Name                string      `json:"name"`
+	Description         string      `json:"description,omitempty"`
+	Labels              Labels      `json:"labels,omitempty"`
+	SyncIntervalSeconds *int        `json:"sync_interval_seconds,omitempty"`
+	SyncWindow          *SyncWindow `json:"sync_window,omitempty"`
+	SyncSLOSeconds      *int        `json:"sync_slo_seconds,omitempty"`
+	CloneFilter         string      `json:"clone_filter,omitempty"`
+	ExcludeRefs         []string    `json:"exclude_refs,omitempty"`
+	// Mode is all or tags; subdirectory needs a path of each target
+	Mode                string   `json:"mode,omitempty"`
+	TagPattern          string   `json:"tag_pattern,omitempty"`
+	AnnotatedTagsOnly   bool     `json:"annotated_tags_only,omitempty"`
+	NotificationRuleIDs []string `json:"notification_rule_ids,omitempty"`
+}
+
+// ApplyTemplateRequest is the body of POST /repository-templates/{id}/apply
+type ApplyTemplateRequest struct {
+	// RepositoryIDs are the repositories the template is applied to, and
+	// becomes the template of; all repositories of the template when empty
+	RepositoryIDs []string `json:"repository_ids,omitempty"`
+}
+
+// TemplateApplication reports what applying a template changed
+type TemplateApplication struct {
+	TemplateID   string `json:"template_id"`
+	Repositories int    `json:"repositories"`
+	Targets      int    `json:"targets"`
+}
+
+// BuildInfo identifies the running build and the optional features the
+// server was configured with
+type BuildInfo struct {
+	// Version is the semantic version of the release, dev for other builds
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Features maps each optional feature to whether it is enabled
+	Features map[string]bool `json:"features"`
+}
+
+// TrashEntry is a deleted repository or target that can be restored until
+// ExpiresAt
+type TrashEntry struct {
+	ID string `json:"id"`
+	// ResourceType is repository or target
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id"`
+	// Name is the name of a repository or the remote URL of a target
+	Name string `json:"name"`
+	// RepositoryID is the repository of a target
+	RepositoryID *string   `json:"repository_id,omitempty"`
+	Team         *string   `json:"team,omitempty"`
+	DeletedBy    *string   `json:"deleted_by,omitempty"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// SSHHostKey is a public key git trusts for an SSH host
+type SSHHostKey struct {
+	ID string `json:"id"`
+	// Host is in known_hosts notation: the host name for port 22 and
+	// [host]:port for other ports
+	Host string `json:"host"`
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	// Fingerprint is the SHA256 fingerprint as ssh shows it
+	Fingerprint string `json:"fingerprint"`
+	// Source is pinned for keys set through the API and tofu for keys
+	// trusted on first use
+	Source    string    `json:"source"`
+	CreatedBy *string   `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SSHHostKeysRequest pins the keys of a host, replacing those trusted for
+// it
+type SSHHostKeysRequest struct {
+	// Keys are public keys in authorized_keys format: type and base64 key,
+	// optionally followed by a comment
+	Keys []string `json:"keys"`
+}
+
+// Session is the browser session of a user logged in through the OIDC
+// provider
+type Session struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups"`
+	Admin  bool     `json:"admin"`
+	// CSRFToken must be sent in the X-CSRF-Token header of every request
+	// of the session other than GET, HEAD and OPTIONS
+	CSRFToken string    `json:"csrf_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gitsync/internal/models"
+)
+
+func init() {
+	RegisterFormatter("teams", teamsFormatter{})
+	RegisterFormatter("discord", discordFormatter{})
+}
+
+// eventFacts lists the event's details as ordered name/value pairs
+func eventFacts(ev Event) [][2]string {
+	var facts [][2]string
+	if ev.RepositoryName != "" {
+		facts = append(facts, [2]string{"Repository", ev.RepositoryName})
+	}
+	if ev.TargetID != "" {
+		facts = append(facts, [2]string{"Target", ev.TargetID})
+	}
+	facts = append(facts, [2]string{"Severity", ev.Severity})
+
+	keys := make([]string, 0, len(ev.Data))
+	for k := range ev.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		facts = append(facts, [2]string{k, fmt.Sprint(ev.Data[k])})
+	}
+	return facts
+}
+
+// teamsFormatter renders an Adaptive Card for Teams incoming webhooks and
+// Workflows
+type teamsFormatter struct{}
+
+func (teamsFormatter) Validate(ch models.NotificationChannel) error {
+	return requireHTTPS(ch.URL)
+}
+
+func (teamsFormatter) Format(ch models.NotificationChannel, ev Event) ([]byte, error) {
+	facts := []map[string]string{}
+	for _, f := range eventFacts(ev) {
+		facts = append(facts, map[string]string{"title": f[0], "value": f[1]})
+	}
+
+	color := "Good"
+	switch ev.Severity {
+	case SeverityWarning:
+		color = "Warning"
+	case SeverityCritical:
+		color = "Attention"
+	}
+
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []any{
+			map[string]any{"type": "TextBlock", "text": ev.Title(), "weight": "Bolder", "size": "Medium", "color": color},
+			map[string]any{"type": "TextBlock", "text": ev.Message, "wrap": true},
+			map[string]any{"type": "FactSet", "facts": facts},
+			map[string]any{"type": "TextBlock", "text": ev.Time.UTC().Format("2006-01-02 15:04:05 MST"), "isSubtle": true, "size": "Small"},
+		},
+	}
+	return json.Marshal(map[string]any{
+		"type": "message",
+		"attachments": []any{map[string]any{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	})
+}
+
+// discordFormatter renders a Discord webhook message with one embed
+type discordFormatter struct{}
+
+func (discordFormatter) Validate(ch models.NotificationChannel) error {
+	return requireHTTPS(ch.URL)
+}
+
+func (discordFormatter) Format(ch models.NotificationChannel, ev Event) ([]byte, error) {
+	fields := []map[string]any{}
+	for _, f := range eventFacts(ev) {
+		fields = append(fields, map[string]any{"name": f[0], "value": truncate(f[1], 1024), "inline": true})
+	}
+
+	color := 0x2ecc71
+	switch ev.Severity {
+	case SeverityWarning:
+		color = 0xf1c40f
+	case SeverityCritical:
+		color = 0xe74c3c
+	}
+
+	return json.Marshal(map[string]any{
+		"username": "GitSync",
+		"embeds": []any{map[string]any{
+			"title":       truncate(ev.Title(), 256),
+			"description": truncate(ev.Message, 4096),
+			"color":       color,
+			"timestamp":   ev.Time.UTC().Format("2006-01-02T15:04:05Z"),
+			"fields":      fields,
+		}},
+	})
+}
+
+func requireHTTPS(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("webhook url must use https")
+	}
+	return nil
+}
+
+// truncate shortens s to at most n runes, as Discord rejects oversized fields
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
@@ -0,0 +1,325 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/labels"
+	"gitsync/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Delivery statuses recorded in the delivery log
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Dispatcher fans events out to the configured channels, retrying failed
+// deliveries and logging every attempt
+type Dispatcher struct {
+	DB          *database.DB
+	HTTP        *http.Client
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles on each retry
+	Backoff time.Duration
+	Clock   clock.Clock
+
+	queue chan Event
+}
+
+// NewDispatcher creates a Dispatcher with a bounded in-memory queue
+func NewDispatcher(db *database.DB, clk clock.Clock) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Clock:       clk,
+		HTTP:        &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		Backoff:     2 * time.Second,
+		queue:       make(chan Event, 1000),
+	}
+}
+
+// Notify queues ev for delivery. It never blocks; events are dropped with a
+// log line if the queue is full. A nil Dispatcher discards events.
+func (d *Dispatcher) Notify(ev Event) {
+	if d == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = d.Clock.Now()
+	}
+	if ev.Severity == "" {
+		ev.Severity = SeverityInfo
+	}
+	select {
+	case d.queue <- ev:
+	default:
+		log.Printf("WARN: notification queue full, dropping %s event", ev.Type)
+	}
+}
+
+// Run delivers queued events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-d.queue:
+			d.dispatch(ctx, ev)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, ev Event) {
+	d.record(ctx, ev)
+
+	channels, err := d.channelsFor(ctx, ev)
+	if err != nil {
+		log.Printf("ERROR: failed to load notification channels: %v", err)
+		return
+	}
+	for _, ch := range channels {
+		go d.Deliver(ctx, ch, ev)
+	}
+}
+
+// record stores ev in the in-app inbox. Successful syncs and test events
+// are too noisy to be worth a bell icon.
+func (d *Dispatcher) record(ctx context.Context, ev Event) {
+	if ev.Type == EventSyncSucceeded || ev.Type == EventTest {
+		return
+	}
+	if _, err := d.DB.ExecContext(ctx,
+		`INSERT INTO notifications (event_type, severity, title, message, repository_id, target_id, created_at)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, '')::uuid, $7)`,
+		ev.Type, ev.Severity, ev.Title(), ev.Message, ev.RepositoryID, ev.TargetID, ev.Time); err != nil {
+		log.Printf("ERROR: failed to record notification: %v", err)
+	}
+}
+
+// ChannelColumns is the column list expected by ScanChannel
+const ChannelColumns = `id, name, type, url, template, content_type, events, repository_ids, label_selector, enabled, external_id, created_at, secret`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// ScanChannel reads a notification channel selected with ChannelColumns
+func ScanChannel(row rowScanner) (models.NotificationChannel, error) {
+	var ch models.NotificationChannel
+	err := row.Scan(&ch.ID, &ch.Name, &ch.Type, &ch.URL, &ch.Template, &ch.ContentType,
+		pq.Array(&ch.Events), pq.Array(&ch.RepositoryIDs), &ch.LabelSelector, &ch.Enabled, &ch.ExternalID, &ch.CreatedAt, &ch.Secret)
+	return ch, err
+}
+
+// channelsFor resolves the channels ev is delivered to. Routing rules take
+// precedence: when any enabled rule matches, exactly the channels of the
+// matching rules are used. Otherwise each channel's own event and
+// repository subscriptions decide.
+func (d *Dispatcher) channelsFor(ctx context.Context, ev Event) ([]models.NotificationChannel, error) {
+	repo := d.repositoryInfo(ctx, ev.RepositoryID)
+
+	routed, err := d.route(ctx, ev, repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(routed) > 0 {
+		return d.channelsByID(ctx, routed)
+	}
+
+	rows, err := d.DB.QueryContext(ctx,
+		`SELECT `+ChannelColumns+` FROM notification_channels WHERE enabled`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []models.NotificationChannel
+	for rows.Next() {
+		ch, err := ScanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(ch.Events) > 0 && !slices.Contains(ch.Events, ev.Type) {
+			continue
+		}
+		if len(ch.RepositoryIDs) > 0 && !slices.Contains(ch.RepositoryIDs, ev.RepositoryID) {
+			continue
+		}
+		if ch.LabelSelector != nil && *ch.LabelSelector != "" {
+			sel, err := labels.ParseSelector(*ch.LabelSelector)
+			if err != nil || ev.RepositoryID == "" || !sel.Matches(repo.Labels) {
+				continue
+			}
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+// route evaluates the routing rules and returns the IDs of the channels of
+// every matching rule
+func (d *Dispatcher) route(ctx context.Context, ev Event, repo repoInfo) ([]string, error) {
+	rows, err := d.DB.QueryContext(ctx,
+		`SELECT `+RuleColumns+` FROM notification_rules WHERE enabled ORDER BY priority, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := d.Clock.Now()
+	var ids []string
+	for rows.Next() {
+		rule, err := ScanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !ruleMatches(rule, ev, repo, now) {
+			continue
+		}
+		for _, id := range rule.ChannelIDs {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	return ids, rows.Err()
+}
+
+func (d *Dispatcher) channelsByID(ctx context.Context, ids []string) ([]models.NotificationChannel, error) {
+	rows, err := d.DB.QueryContext(ctx,
+		`SELECT `+ChannelColumns+` FROM notification_channels WHERE enabled AND id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []models.NotificationChannel
+	for rows.Next() {
+		ch, err := ScanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+func (d *Dispatcher) repositoryInfo(ctx context.Context, repoID string) repoInfo {
+	var info repoInfo
+	if repoID == "" {
+		return info
+	}
+	if err := d.DB.QueryRowContext(ctx,
+		`SELECT source_provider, labels FROM repositories WHERE id = $1`, repoID).Scan(&info.Provider, &info.Labels); err != nil {
+		log.Printf("WARN: failed to load repository %s for notification routing: %v", repoID, err)
+	}
+	return info
+}
+
+// Deliver sends ev to ch with retries and records the outcome in the
+// delivery log, returning the final error
+func (d *Dispatcher) Deliver(ctx context.Context, ch models.NotificationChannel, ev Event) error {
+	var deliveryID string
+	if err := d.DB.QueryRowContext(ctx,
+		`INSERT INTO notification_deliveries (channel_id, event_type, status) VALUES ($1, $2, $3) RETURNING id`,
+		ch.ID, ev.Type, DeliveryPending).Scan(&deliveryID); err != nil {
+		log.Printf("ERROR: failed to record notification delivery: %v", err)
+	}
+
+	attempts, code, err := d.send(ctx, ch, ev)
+
+	status := DeliveryDelivered
+	var errText *string
+	if err != nil {
+		status = DeliveryFailed
+		msg := err.Error()
+		errText = &msg
+		log.Printf("WARN: notification to channel %s failed after %d attempts: %v", ch.Name, attempts, err)
+	}
+	var responseCode *int
+	if code != 0 {
+		responseCode = &code
+	}
+
+	if deliveryID != "" {
+		if _, dbErr := d.DB.ExecContext(ctx,
+			`UPDATE notification_deliveries
+			 SET status = $2, attempts = $3, response_code = $4, error = $5, finished_at = $6
+			 WHERE id = $1`,
+			deliveryID, status, attempts, responseCode, errText, d.Clock.Now()); dbErr != nil {
+			log.Printf("ERROR: failed to update notification delivery: %v", dbErr)
+		}
+	}
+	return err
+}
+
+// send performs the HTTP delivery with exponential backoff, returning the
+// number of attempts and the last response code
+func (d *Dispatcher) send(ctx context.Context, ch models.NotificationChannel, ev Event) (int, int, error) {
+	formatter, err := LookupFormatter(ch.Type)
+	if err != nil {
+		return 0, 0, err
+	}
+	body, err := formatter.Format(ch, ev)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	delay := d.Backoff
+	var code int
+	for attempt := 1; ; attempt++ {
+		code, err = d.post(ctx, ch, ev, formatter, body)
+		// Client errors other than rate limiting will not succeed on retry
+		permanent := code >= 400 && code < 500 && code != http.StatusTooManyRequests
+		if err == nil || permanent || attempt >= d.MaxAttempts {
+			return attempt, code, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, code, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, ch models.NotificationChannel, ev Event, formatter Formatter, body []byte) (int, error) {
+	var req *http.Request
+	var err error
+	if rq, ok := formatter.(Requester); ok {
+		req, err = rq.Request(ctx, ch, ev, body)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, ch.URL, bytes.NewReader(body))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", ch.ContentType)
+	req.Header.Set("User-Agent", "GitSync")
+
+	resp, err := d.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,69 @@
+// Package notify delivers GitSync events to external notification channels.
+package notify
+
+import "time"
+
+// Event types emitted by GitSync
+const (
+	EventSyncSucceeded  = "sync.succeeded"
+	EventSyncFailed     = "sync.failed"
+	EventSourceArchived = "source.archived"
+	EventSourceDeleted  = "source.deleted"
+	EventDigestDaily    = "digest.daily"
+	EventDigestWeekly   = "digest.weekly"
+	EventSLOBreached    = "slo.breached"
+	EventSLORecovered   = "slo.recovered"
+	EventTest           = "notification.test"
+
+	EventAnomalyDetected     = "anomaly.detected"
+	EventAnomalyAcknowledged = "anomaly.acknowledged"
+)
+
+// Severities of an Event
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Event is something that happened which channels may be told about
+type Event struct {
+	Type           string         `json:"type"`
+	Severity       string         `json:"severity"`
+	RepositoryID   string         `json:"repository_id,omitempty"`
+	RepositoryName string         `json:"repository_name,omitempty"`
+	TargetID       string         `json:"target_id,omitempty"`
+	Message        string         `json:"message"`
+	Time           time.Time      `json:"time"`
+	Data           map[string]any `json:"data,omitempty"`
+}
+
+// Title returns a short human readable summary of the event type
+func (e Event) Title() string {
+	switch e.Type {
+	case EventSyncSucceeded:
+		return "Sync succeeded"
+	case EventSyncFailed:
+		return "Sync failed"
+	case EventSourceArchived:
+		return "Source repository archived"
+	case EventSourceDeleted:
+		return "Source repository deleted"
+	case EventDigestDaily:
+		return "Daily GitSync digest"
+	case EventDigestWeekly:
+		return "Weekly GitSync digest"
+	case EventSLOBreached:
+		return "Sync SLO breached"
+	case EventSLORecovered:
+		return "Sync SLO recovered"
+	case EventTest:
+		return "Test notification"
+	case EventAnomalyDetected:
+		return "Anomalous source change"
+	case EventAnomalyAcknowledged:
+		return "Anomalous source change acknowledged"
+	default:
+		return e.Type
+	}
+}
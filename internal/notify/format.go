@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gitsync/internal/models"
+)
+
+// Formatter renders an event into the request body for a channel type
+type Formatter interface {
+	// Validate checks the channel configuration when it is created
+	Validate(ch models.NotificationChannel) error
+	// Format renders the event for the channel
+	Format(ch models.NotificationChannel, ev Event) ([]byte, error)
+}
+
+// Requester is implemented by formatters whose channels need more than the
+// body POSTed to the channel URL, such as per-event endpoints or
+// authentication headers
+type Requester interface {
+	Request(ctx context.Context, ch models.NotificationChannel, ev Event, body []byte) (*http.Request, error)
+}
+
+var (
+	formattersMu sync.RWMutex
+	formatters   = make(map[string]Formatter)
+)
+
+// RegisterFormatter makes a channel type available
+func RegisterFormatter(channelType string, f Formatter) {
+	formattersMu.Lock()
+	defer formattersMu.Unlock()
+	formatters[channelType] = f
+}
+
+// LookupFormatter returns the formatter of a channel type
+func LookupFormatter(channelType string) (Formatter, error) {
+	formattersMu.RLock()
+	defer formattersMu.RUnlock()
+
+	if f, ok := formatters[channelType]; ok {
+		return f, nil
+	}
+	types := make([]string, 0, len(formatters))
+	for t := range formatters {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return nil, fmt.Errorf("unsupported channel type %q. allowed: %s", channelType, strings.Join(types, ", "))
+}
+
+func init() {
+	RegisterFormatter("template", templateFormatter{})
+}
+
+// templateFuncs are available to channel templates
+var templateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, including quotes for strings
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// templateFormatter renders the channel's Go template with the event as
+// data. Without a template the event is sent as JSON.
+type templateFormatter struct{}
+
+func (templateFormatter) Validate(ch models.NotificationChannel) error {
+	if ch.Template == nil {
+		return nil
+	}
+	_, err := template.New(ch.Name).Funcs(templateFuncs).Parse(*ch.Template)
+	return err
+}
+
+func (templateFormatter) Format(ch models.NotificationChannel, ev Event) ([]byte, error) {
+	if ch.Template == nil || strings.TrimSpace(*ch.Template) == "" {
+		return json.Marshal(ev)
+	}
+	tmpl, err := template.New(ch.Name).Funcs(templateFuncs).Parse(*ch.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ev); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gitsync/internal/models"
+)
+
+func init() {
+	RegisterFormatter("pagerduty", pagerDutyFormatter{})
+	RegisterFormatter("opsgenie", opsgenieFormatter{})
+}
+
+// IncidentEvents are the events incident channels subscribe to by default
+var IncidentEvents = []string{EventSLOBreached, EventSLORecovered, EventAnomalyDetected, EventAnomalyAcknowledged}
+
+// IsIncidentChannel reports whether a channel type opens incidents rather
+// than posting messages
+func IsIncidentChannel(channelType string) bool {
+	return channelType == "pagerduty" || channelType == "opsgenie"
+}
+
+// incidentKey deduplicates incidents per repository and target, so repeated
+// breaches update one incident and a recovery resolves it; likewise for
+// anomalies of a repository and their acknowledgment
+func incidentKey(ev Event) string {
+	kind := ev.Type
+	switch ev.Type {
+	case EventSLOBreached, EventSLORecovered:
+		kind = "slo"
+	case EventAnomalyDetected, EventAnomalyAcknowledged:
+		kind = "anomaly"
+	}
+	return strings.Join([]string{"gitsync", kind, ev.RepositoryID, ev.TargetID}, "/")
+}
+
+// resolves reports whether ev closes the incident opened by an earlier event
+func resolves(ev Event) bool {
+	return ev.Type == EventSLORecovered || ev.Type == EventAnomalyAcknowledged
+}
+
+func requireSecret(ch models.NotificationChannel, what string) error {
+	if ch.Secret == "" {
+		return fmt.Errorf("secret (%s) is required", what)
+	}
+	return requireHTTPS(ch.URL)
+}
+
+// incidentDetails flattens the event data for incident custom fields
+func incidentDetails(ev Event) map[string]string {
+	details := map[string]string{}
+	for _, f := range eventFacts(ev) {
+		details[f[0]] = f[1]
+	}
+	return details
+}
+
+// pagerDutyFormatter sends Events API v2 trigger and resolve events. The
+// channel URL is the events endpoint, normally
+// https://events.pagerduty.com/v2/enqueue, and the secret the routing key.
+type pagerDutyFormatter struct{}
+
+func (pagerDutyFormatter) Validate(ch models.NotificationChannel) error {
+	return requireSecret(ch, "routing key")
+}
+
+func (pagerDutyFormatter) Format(ch models.NotificationChannel, ev Event) ([]byte, error) {
+	payload := map[string]any{
+		"routing_key":  ch.Secret,
+		"dedup_key":    incidentKey(ev),
+		"event_action": "trigger",
+	}
+	if resolves(ev) {
+		payload["event_action"] = "resolve"
+		return json.Marshal(payload)
+	}
+
+	severity := ev.Severity
+	if severity == "" {
+		severity = SeverityInfo
+	}
+	payload["payload"] = map[string]any{
+		"summary":        truncate(ev.Title()+": "+ev.Message, 1024),
+		"source":         "gitsync",
+		"severity":       severity,
+		"timestamp":      ev.Time,
+		"custom_details": incidentDetails(ev),
+	}
+	return json.Marshal(payload)
+}
+
+// opsgenieFormatter creates and closes Opsgenie alerts. The channel URL is
+// the alerts endpoint, normally https://api.opsgenie.com/v2/alerts, and the
+// secret an API integration key.
+type opsgenieFormatter struct{}
+
+func (opsgenieFormatter) Validate(ch models.NotificationChannel) error {
+	return requireSecret(ch, "API key")
+}
+
+func (opsgenieFormatter) Format(ch models.NotificationChannel, ev Event) ([]byte, error) {
+	if resolves(ev) {
+		return json.Marshal(map[string]string{"source": "GitSync", "note": ev.Message})
+	}
+
+	priority := "P5"
+	switch ev.Severity {
+	case SeverityCritical:
+		priority = "P1"
+	case SeverityWarning:
+		priority = "P3"
+	}
+	return json.Marshal(map[string]any{
+		"message":     truncate(ev.Title()+": "+ev.Message, 130),
+		"alias":       incidentKey(ev),
+		"description": ev.Message,
+		"priority":    priority,
+		"source":      "GitSync",
+		"details":     incidentDetails(ev),
+	})
+}
+
+// Request implements Requester: alerts are closed through a separate
+// endpoint addressed by alias
+func (opsgenieFormatter) Request(ctx context.Context, ch models.NotificationChannel, ev Event, body []byte) (*http.Request, error) {
+	endpoint := ch.URL
+	if resolves(ev) {
+		endpoint = strings.TrimSuffix(ch.URL, "/") + "/" + url.PathEscape(incidentKey(ev)) + "/close?identifierType=alias"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "GenieKey "+ch.Secret)
+	return req, nil
+}
@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"gitsync/internal/labels"
+	"gitsync/internal/models"
+	"gitsync/internal/schedule"
+
+	"github.com/lib/pq"
+)
+
+// RuleColumns is the column list expected by ScanRule
+const RuleColumns = `id, name, priority, event_types, label_selector, providers, min_severity,
+	active_from, active_to, timezone, channel_ids, stop, enabled, external_id, created_at`
+
+// ScanRule reads a notification rule selected with RuleColumns
+func ScanRule(row rowScanner) (models.NotificationRule, error) {
+	var r models.NotificationRule
+	err := row.Scan(&r.ID, &r.Name, &r.Priority, pq.Array(&r.EventTypes), &r.LabelSelector, pq.Array(&r.Providers),
+		&r.MinSeverity, &r.ActiveFrom, &r.ActiveTo, &r.Timezone, pq.Array(&r.ChannelIDs), &r.Stop, &r.Enabled, &r.ExternalID, &r.CreatedAt)
+	return r, err
+}
+
+var severityRank = map[string]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+// ValidateRule checks a rule's selector, severity and time window
+func ValidateRule(r models.NotificationRule) error {
+	if len(r.ChannelIDs) == 0 {
+		return errors.New("channel_ids is required")
+	}
+	if r.LabelSelector != nil {
+		if _, err := labels.ParseSelector(*r.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label_selector: %w", err)
+		}
+	}
+	if r.MinSeverity != nil {
+		if _, ok := severityRank[*r.MinSeverity]; !ok {
+			return fmt.Errorf("invalid min_severity %q. allowed: info, warning, critical", *r.MinSeverity)
+		}
+	}
+	if (r.ActiveFrom == nil) != (r.ActiveTo == nil) {
+		return errors.New("active_from and active_to must be set together")
+	}
+	for _, t := range []*string{r.ActiveFrom, r.ActiveTo} {
+		if t != nil {
+			if _, err := time.Parse("15:04", *t); err != nil {
+				return fmt.Errorf("invalid time %q, expected HH:MM", *t)
+			}
+		}
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", r.Timezone)
+	}
+	return nil
+}
+
+// repoInfo is the repository context rules are evaluated against
+type repoInfo struct {
+	Provider string
+	Labels   models.Labels
+}
+
+// ruleMatches reports whether rule applies to ev at now
+func ruleMatches(rule models.NotificationRule, ev Event, repo repoInfo, now time.Time) bool {
+	if len(rule.EventTypes) > 0 && !slices.Contains(rule.EventTypes, ev.Type) {
+		return false
+	}
+	if len(rule.Providers) > 0 && !slices.Contains(rule.Providers, repo.Provider) {
+		return false
+	}
+	if rule.MinSeverity != nil && severityRank[ev.Severity] < severityRank[*rule.MinSeverity] {
+		return false
+	}
+	if rule.LabelSelector != nil && *rule.LabelSelector != "" {
+		sel, err := labels.ParseSelector(*rule.LabelSelector)
+		if err != nil || !sel.Matches(repo.Labels) {
+			return false
+		}
+	}
+	if rule.ActiveFrom != nil && rule.ActiveTo != nil && !inWindow(*rule.ActiveFrom, *rule.ActiveTo, rule.Timezone, now) {
+		return false
+	}
+	return true
+}
+
+// inWindow reports whether now falls in the daily [from, to) window in tz.
+// A window whose end is before its start wraps past midnight.
+func inWindow(from, to, tz string, now time.Time) bool {
+	return schedule.InWindow(models.SyncWindow{Start: from, End: to, Timezone: tz}, now)
+}
@@ -0,0 +1,85 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// azureVersion is the Blob service API version requested; it allows block
+// blobs of up to 5000 MiB in a single put
+const azureVersion = "2021-08-06"
+
+// Azure stores objects as block blobs in an Azure Blob Storage container,
+// authorized by a shared access signature
+type Azure struct {
+	// ContainerURL is https://<account>.blob.core.windows.net/<container>
+	ContainerURL string
+	Prefix       string
+	// SAS is the query string of a shared access signature allowing read,
+	// write and delete in the container
+	SAS  string
+	HTTP *http.Client
+}
+
+// Put uploads body as the block blob Prefix+key and returns its URL
+func (a *Azure) Put(ctx context.Context, key string, body io.Reader, size int64, _ string) (string, error) {
+	resp, err := a.do(ctx, http.MethodPut, key, body, size)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return a.ContainerURL + "/" + a.Prefix + key, nil
+}
+
+// Open streams the blob Prefix+key
+func (a *Azure) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := a.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the blob Prefix+key
+func (a *Azure) Delete(ctx context.Context, key string) error {
+	resp, err := a.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (a *Azure) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	url := a.ContainerURL + "/" + uriEncode(a.Prefix+key)
+	if a.SAS != "" {
+		url += "?" + a.SAS
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("azure blob %s failed: %w", strings.ToLower(method), err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("azure blob %s failed: %w", strings.ToLower(method), ErrNotFound)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("azure blob %s failed with status %d: %s", strings.ToLower(method), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,57 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Dir stores objects as files below Path
+type Dir struct {
+	Path string
+}
+
+// Put writes body to Path/key through a temporary file, so a partial
+// object is never visible, and returns the file path
+func (d *Dir) Put(_ context.Context, key string, body io.Reader, _ int64, _ string) (string, error) {
+	path := filepath.Join(d.Path, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", fmt.Errorf("failed to create store directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create object: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write object: %w", err)
+	}
+	return path, nil
+}
+
+// Open opens the file Path/key
+func (d *Dir) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(d.Path, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open %s: %w", key, ErrNotFound)
+	}
+	return f, err
+}
+
+// Delete removes the file Path/key; a missing file is not an error
+func (d *Dir) Delete(_ context.Context, key string) error {
+	err := os.Remove(filepath.Join(d.Path, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
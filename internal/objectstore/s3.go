@@ -0,0 +1,199 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the payload hash of requests without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 stores objects with AWS Signature Version 4. Endpoint may point at an
+// S3-compatible service such as MinIO or Google Cloud Storage, in which
+// case path-style URLs are used.
+type S3 struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Scheme prefixes the locations of stored objects; s3 when empty
+	Scheme string
+	HTTP   *http.Client
+}
+
+// S3FromEnv configures a bucket from <prefix>_BUCKET, <prefix>_REGION,
+// <prefix>_PREFIX, <prefix>_ENDPOINT and the standard AWS credential
+// variables. It returns nil when no bucket is configured.
+func S3FromEnv(prefix string) *S3 {
+	bucket := os.Getenv(prefix + "_BUCKET")
+	if bucket == "" {
+		return nil
+	}
+	region := os.Getenv(prefix + "_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		Endpoint:     strings.TrimSuffix(os.Getenv(prefix+"_ENDPOINT"), "/"),
+		Region:       region,
+		Bucket:       bucket,
+		Prefix:       os.Getenv(prefix + "_PREFIX"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		HTTP:         &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Put uploads body under Prefix+key and returns its s3:// URL
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, sum string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPut, key, body, size, sum)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "s3"
+	}
+	return scheme + "://" + s.Bucket + "/" + s.Prefix + key, nil
+}
+
+// Open streams the object under Prefix+key
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the object under Prefix+key
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, emptySHA256)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for the object under Prefix+key and fails on
+// any status but 2xx
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, sum string) (*http.Response, error) {
+	path := "/" + uriEncode(s.Prefix+key)
+	host := s.Bucket + ".s3." + s.Region + ".amazonaws.com"
+	scheme := "https"
+	if s.Endpoint != "" {
+		scheme, host, _ = strings.Cut(s.Endpoint, "://")
+		path = "/" + s.Bucket + path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, scheme+"://"+host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	s.sign(req, host, path, sum, time.Now().UTC())
+
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s failed: %w", strings.ToLower(method), err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("s3 %s failed: %w", strings.ToLower(method), ErrNotFound)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 %s failed with status %d: %s", strings.ToLower(method), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (s *S3) sign(req *http.Request, host, path, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := map[string]string{
+		"host":                 host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers = append([]string{"content-type"}, headers...)
+		values["content-type"] = ct
+	}
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+		headers = append(headers, "x-amz-security-token")
+		values["x-amz-security-token"] = s.SessionToken
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(values[h]) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode percent-encodes everything but RFC 3986 unreserved characters
+// and slashes, as SigV4 requires for S3 object keys
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+// Package objectstore keeps files in S3, Google Cloud Storage, Azure Blob
+// Storage or a local directory.
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned, wrapped, for objects that do not exist
+var ErrNotFound = errors.New("object not found")
+
+// Store keeps objects under keys
+type Store interface {
+	// Put uploads size bytes of body, whose SHA-256 is sum in hex, under
+	// key and returns the location of the object
+	Put(ctx context.Context, key string, body io.Reader, size int64, sum string) (string, error)
+	// Open streams the object under key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object under key
+	Delete(ctx context.Context, key string) error
+}
+
+// FromURL configures a store from a URL:
+//
+//	s3://<bucket>/<prefix>         AWS credentials from the environment; region
+//	                               and endpoint query parameters
+//	gs://<bucket>/<prefix>         HMAC keys from GCS_HMAC_ACCESS_KEY and
+//	                               GCS_HMAC_SECRET
+//	azure://<account>/<container>/<prefix>
+//	                               SAS token from AZURE_STORAGE_SAS_TOKEN
+//	file:///<path> or /<path>      a local directory
+//
+// It returns nil for an empty URL.
+func FromURL(raw string) (Store, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	// Transfers of large objects are bounded by their context instead
+	client := &http.Client{}
+
+	switch u.Scheme {
+	case "s3":
+		region := u.Query().Get("region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &S3{
+			Endpoint:     strings.TrimSuffix(u.Query().Get("endpoint"), "/"),
+			Region:       region,
+			Bucket:       u.Host,
+			Prefix:       prefix,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			HTTP:         client,
+		}, nil
+	case "gs":
+		// Cloud Storage accepts S3 requests signed with its HMAC keys
+		return &S3{
+			Endpoint:  "https://storage.googleapis.com",
+			Region:    "auto",
+			Bucket:    u.Host,
+			Prefix:    prefix,
+			AccessKey: os.Getenv("GCS_HMAC_ACCESS_KEY"),
+			SecretKey: os.Getenv("GCS_HMAC_SECRET"),
+			Scheme:    "gs",
+			HTTP:      client,
+		}, nil
+	case "azure":
+		container, blobPrefix, _ := strings.Cut(prefix, "/")
+		if u.Host == "" || container == "" {
+			return nil, fmt.Errorf("azure store URL must be azure://<account>/<container>[/<prefix>]")
+		}
+		return &Azure{
+			ContainerURL: "https://" + u.Host + ".blob.core.windows.net/" + container,
+			Prefix:       blobPrefix,
+			SAS:          strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+			HTTP:         client,
+		}, nil
+	case "file", "":
+		if u.Path == "" {
+			return nil, fmt.Errorf("file store URL needs a path")
+		}
+		return &Dir{Path: u.Path}, nil
+	}
+	return nil, fmt.Errorf("unsupported store scheme %q. allowed: s3, gs, azure, file", u.Scheme)
+}
@@ -0,0 +1,276 @@
+// Package openapi builds the OpenAPI 3 document of the API from the routes
+// as they are registered, so the published spec cannot drift from the
+// handlers that serve it.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Info describes the API as a whole
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Operation is the metadata of one route
+type Operation struct {
+	Summary     string
+	Description string
+	Tag         string
+	// Params lists query and header parameters. Path parameters are taken
+	// from the route template; listing one here only adds a description.
+	Params []Param
+	// Body is a value of the request body type, nil when there is none
+	Body any
+	// Status is the success status, 200 when zero
+	Status int
+	// Response is a value of the response body type, nil when there is none
+	Response any
+	// Produces is the response content type, application/json when empty
+	Produces string
+	// Errors maps error statuses to their description
+	Errors map[int]string
+}
+
+// Param is a request parameter
+type Param struct {
+	Name string
+	// In is query, header or path; query when empty
+	In          string
+	Description string
+	// Type is string, integer or boolean; string when empty
+	Type     string
+	Required bool
+}
+
+// SecurityScheme describes how callers authenticate
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Spec accumulates the document. It is built while routes are registered
+// and only read afterwards.
+type Spec struct {
+	doc     document
+	schemas *schemas
+}
+
+type document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Servers    []server                         `json:"servers,omitempty"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components components                       `json:"components"`
+	Security   []map[string][]string            `json:"security,omitempty"`
+}
+
+type server struct {
+	URL string `json:"url"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	OperationID string               `json:"operationId,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []parameter          `json:"parameters,omitempty"`
+	RequestBody *requestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New creates an empty Spec
+func New(info Info) *Spec {
+	s := &Spec{schemas: newSchemas()}
+	s.doc = document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]map[string]*operation),
+		Components: components{
+			Schemas:         s.schemas.defs,
+			SecuritySchemes: make(map[string]SecurityScheme),
+		},
+	}
+	return s
+}
+
+// AddSecurity declares a scheme every operation requires. Schemes added
+// separately are all required together.
+func (s *Spec) AddSecurity(name string, scheme SecurityScheme) {
+	s.doc.Components.SecuritySchemes[name] = scheme
+	if len(s.doc.Security) == 0 {
+		s.doc.Security = []map[string][]string{{}}
+	}
+	s.doc.Security[0][name] = []string{}
+}
+
+// AddAlternativeSecurity declares a scheme callers may use instead of the
+// schemes added before
+func (s *Spec) AddAlternativeSecurity(name string, scheme SecurityScheme) {
+	s.doc.Components.SecuritySchemes[name] = scheme
+	s.doc.Security = append(s.doc.Security, map[string][]string{name: {}})
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// Add documents the route method path
+func (s *Spec) Add(method, path string, op Operation) {
+	// Strip mux patterns such as {id:[0-9]+}
+	path = pathParam.ReplaceAllString(path, "{$1}")
+
+	o := &operation{
+		Summary:     op.Summary,
+		Description: op.Description,
+		OperationID: operationID(method, path),
+		Responses:   make(map[string]*response),
+	}
+	if op.Tag != "" {
+		o.Tags = []string{op.Tag}
+	}
+
+	described := make(map[string]Param)
+	for _, p := range op.Params {
+		if p.In == "path" {
+			described[p.Name] = p
+		}
+	}
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		p := described[m[1]]
+		o.Parameters = append(o.Parameters, parameter{
+			Name: m[1], In: "path", Description: p.Description, Required: true,
+			Schema: &Schema{Type: paramType(p.Type)},
+		})
+	}
+	for _, p := range op.Params {
+		if p.In == "path" {
+			continue
+		}
+		in := p.In
+		if in == "" {
+			in = "query"
+		}
+		o.Parameters = append(o.Parameters, parameter{
+			Name: p.Name, In: in, Description: p.Description, Required: p.Required,
+			Schema: &Schema{Type: paramType(p.Type)},
+		})
+	}
+
+	if op.Body != nil {
+		o.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]mediaType{"application/json": {Schema: s.schemas.of(op.Body)}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &response{Description: http.StatusText(status)}
+	if op.Response != nil {
+		produces := op.Produces
+		if produces == "" {
+			produces = "application/json"
+		}
+		schema := &Schema{Type: "string", Format: "binary"}
+		if produces == "application/json" {
+			schema = s.schemas.of(op.Response)
+		}
+		success.Content = map[string]mediaType{produces: {Schema: schema}}
+	}
+	o.Responses[strconv.Itoa(status)] = success
+	for code, desc := range op.Errors {
+		o.Responses[strconv.Itoa(code)] = &response{
+			Description: desc,
+			Content:     map[string]mediaType{"text/plain": {Schema: &Schema{Type: "string"}}},
+		}
+	}
+
+	if s.doc.Paths[path] == nil {
+		s.doc.Paths[path] = make(map[string]*operation)
+	}
+	s.doc.Paths[path][strings.ToLower(method)] = o
+}
+
+func paramType(t string) string {
+	if t == "" {
+		return "string"
+	}
+	return t
+}
+
+// operationID derives a stable identifier such as
+// post_repositories_id_targets
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(path, "/") {
+		seg = strings.Trim(seg, "{}")
+		if seg == "" {
+			continue
+		}
+		b.WriteByte('_')
+		b.WriteString(strings.ReplaceAll(seg, "-", "_"))
+	}
+	return b.String()
+}
+
+// ServeHTTP serves the document with a server entry for the host and
+// scheme the caller used, honouring X-Forwarded-Proto and X-Forwarded-Host
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	doc := s.doc
+	doc.Servers = []server{{URL: baseURL(r)}}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme, _, _ = strings.Cut(proto, ",")
+	}
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host, _, _ = strings.Cut(fwd, ",")
+	}
+	return strings.TrimSpace(scheme) + "://" + strings.TrimSpace(host)
+}
@@ -0,0 +1,285 @@
+// Package policy enforces admin-defined constraints on where repositories
+// are replicated from and to.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"gitsync/internal/database"
+	"gitsync/internal/labels"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+
+	"github.com/lib/pq"
+)
+
+// Policy kinds
+const (
+	// KindAllowedTargetDomains only allows targets on the listed hosts
+	KindAllowedTargetDomains = "allowed_target_domains"
+	// KindForbidPublicProviders rejects targets on the public hosts of the
+	// listed providers, e.g. github.com for repositories labelled internal
+	KindForbidPublicProviders = "forbid_public_providers"
+	// KindRequireSSH requires SSH URLs, and so SSH credentials
+	KindRequireSSH = "require_ssh"
+	// KindRequireOwner requires repositories to name an owner
+	KindRequireOwner = "require_owner"
+	// KindRequireTeam requires repositories to name a team
+	KindRequireTeam = "require_team"
+)
+
+// Scopes of require_ssh
+const (
+	ScopeSources = "sources"
+	ScopeTargets = "targets"
+	ScopeAll     = "all"
+)
+
+// Stages at which policies are evaluated
+const (
+	StageCreate = "create"
+	StageSync   = "sync"
+)
+
+// Columns is the column list expected by Scan
+const Columns = `id, name, kind, label_selector, domains, providers, scope, enabled, external_id, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Scan reads a policy selected with Columns
+func Scan(row rowScanner) (models.Policy, error) {
+	var p models.Policy
+	err := row.Scan(&p.ID, &p.Name, &p.Kind, &p.LabelSelector, pq.Array(&p.Domains), pq.Array(&p.Providers),
+		&p.Scope, &p.Enabled, &p.ExternalID, &p.CreatedAt)
+	return p, err
+}
+
+// Validate checks a policy definition
+func Validate(p models.Policy) error {
+	if p.LabelSelector != nil {
+		if _, err := labels.ParseSelector(*p.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label_selector: %w", err)
+		}
+	}
+	switch p.Kind {
+	case KindAllowedTargetDomains:
+		if len(p.Domains) == 0 {
+			return errors.New("domains is required")
+		}
+	case KindForbidPublicProviders:
+		for _, name := range p.Providers {
+			if _, err := provider.Lookup(name); err != nil {
+				return err
+			}
+		}
+	case KindRequireOwner, KindRequireTeam:
+	case KindRequireSSH:
+		switch p.Scope {
+		case ScopeSources, ScopeTargets, ScopeAll:
+		default:
+			return fmt.Errorf("invalid scope %q. allowed: sources, targets, all", p.Scope)
+		}
+	default:
+		return fmt.Errorf("invalid kind %q. allowed: %s", p.Kind, strings.Join([]string{
+			KindAllowedTargetDomains, KindForbidPublicProviders, KindRequireSSH, KindRequireOwner, KindRequireTeam,
+		}, ", "))
+	}
+	return nil
+}
+
+// Subject is what a policy is evaluated against: the source of Repository,
+// or one of its targets when Target is set
+type Subject struct {
+	Repository models.Repository
+	Target     *models.Target
+}
+
+func (s Subject) url() string {
+	if s.Target != nil {
+		return s.Target.RemoteURL
+	}
+	return s.Repository.SourceURL
+}
+
+// Violation describes how a subject breaks a policy
+type Violation struct {
+	PolicyID   string `json:"policy_id"`
+	PolicyName string `json:"policy_name"`
+	Message    string `json:"message"`
+}
+
+// ViolationError is returned by Enforce when a subject is blocked
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.PolicyName, v.Message))
+	}
+	return "policy violation: " + strings.Join(msgs, "; ")
+}
+
+// Check evaluates a single policy against s, returning the violation
+// message or "" when s complies
+func Check(p models.Policy, s Subject) string {
+	if p.LabelSelector != nil && *p.LabelSelector != "" {
+		sel, err := labels.ParseSelector(*p.LabelSelector)
+		if err != nil || !sel.Matches(s.Repository.Labels) {
+			return ""
+		}
+	}
+
+	u, err := provider.ParseRepoURL(s.url())
+	if err != nil {
+		return "unparseable url: " + err.Error()
+	}
+
+	switch p.Kind {
+	case KindAllowedTargetDomains:
+		if s.Target == nil {
+			return ""
+		}
+		for _, d := range p.Domains {
+			if matchDomain(d, u.Host) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("target host %s is not an allowed domain", u.Host)
+
+	case KindForbidPublicProviders:
+		if s.Target == nil {
+			return ""
+		}
+		for _, name := range provider.Names() {
+			if len(p.Providers) > 0 && !slices.Contains(p.Providers, name) {
+				continue
+			}
+			prov, _ := provider.Get(name)
+			if slices.Contains(prov.Hosts(), u.Host) {
+				return fmt.Sprintf("targets on public %s host %s are forbidden", name, u.Host)
+			}
+		}
+
+	case KindRequireOwner:
+		if s.Target == nil && (s.Repository.Owner == nil || *s.Repository.Owner == "") {
+			return "repository must have an owner"
+		}
+
+	case KindRequireTeam:
+		if s.Target == nil && (s.Repository.Team == nil || *s.Repository.Team == "") {
+			return "repository must have a team"
+		}
+
+	case KindRequireSSH:
+		applies := p.Scope == ScopeAll ||
+			(p.Scope == ScopeTargets && s.Target != nil) ||
+			(p.Scope == ScopeSources && s.Target == nil)
+		if applies && u.Scheme != "ssh" {
+			if s.Target != nil {
+				return "target url must use ssh"
+			}
+			return "source url must use ssh"
+		}
+	}
+	return ""
+}
+
+// matchDomain matches host against an exact domain or a "*." wildcard
+func matchDomain(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// Engine evaluates the enabled policies stored in the database
+type Engine struct {
+	DB *database.DB
+}
+
+// NewEngine creates an Engine
+func NewEngine(db *database.DB) *Engine {
+	return &Engine{DB: db}
+}
+
+// Enabled returns the enabled policies
+func (e *Engine) Enabled(ctx context.Context) ([]models.Policy, error) {
+	rows, err := e.DB.QueryContext(ctx, `SELECT `+Columns+` FROM policies WHERE enabled ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []models.Policy
+	for rows.Next() {
+		p, err := Scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// Evaluate returns the violations of every enabled policy by s
+func (e *Engine) Evaluate(ctx context.Context, s Subject) ([]Violation, error) {
+	policies, err := e.Enabled(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return evaluate(policies, s), nil
+}
+
+func evaluate(policies []models.Policy, s Subject) []Violation {
+	var violations []Violation
+	for _, p := range policies {
+		if msg := Check(p, s); msg != "" {
+			violations = append(violations, Violation{PolicyID: p.ID, PolicyName: p.Name, Message: msg})
+		}
+	}
+	return violations
+}
+
+// Enforce evaluates s at stage and records any violations. It returns a
+// *ViolationError when s is blocked.
+func (e *Engine) Enforce(ctx context.Context, stage string, s Subject) error {
+	policies, err := e.Enabled(ctx)
+	if err != nil {
+		return err
+	}
+	return e.EnforceWith(ctx, stage, policies, s)
+}
+
+// EnforceWith is Enforce against policies loaded once with Enabled, for
+// checking many subjects in a row
+func (e *Engine) EnforceWith(ctx context.Context, stage string, policies []models.Policy, s Subject) error {
+	violations := evaluate(policies, s)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	var targetID *string
+	if s.Target != nil && s.Target.ID != "" {
+		targetID = &s.Target.ID
+	}
+	for _, v := range violations {
+		log.Printf("WARN: policy %q blocked %s of %s: %s", v.PolicyName, stage, s.url(), v.Message)
+		if _, err := e.DB.ExecContext(ctx,
+			`INSERT INTO policy_violations (policy_id, policy_name, stage, repository_id, target_id, url, message)
+			 VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6, $7)`,
+			v.PolicyID, v.PolicyName, stage, s.Repository.ID, targetID, s.url(), v.Message); err != nil {
+			log.Printf("ERROR: failed to record policy violation: %v", err)
+		}
+	}
+	return &ViolationError{Violations: violations}
+}
@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Hook is a webhook installed on a source repository
+type Hook struct {
+	ID     string
+	URL    string
+	Active bool
+	// Push is set when the hook is subscribed to push events
+	Push bool
+}
+
+// DeployKey is an SSH key granted access to a single repository
+type DeployKey struct {
+	ID    string
+	Title string
+	// Key is the public key in authorized_keys format
+	Key      string
+	ReadOnly bool
+}
+
+// APIError is returned when a provider API responds with a non-2xx status
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 response from a provider API
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// resolve looks up the provider and parses the repository URL
+func resolve(providerName, repoURL string) (Provider, *RepoURL, error) {
+	p, err := Lookup(providerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := ParseRepoURL(repoURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid repository url: %w", err)
+	}
+	return p, u, nil
+}
+
+// GetRepository fetches metadata of the repository at repoURL
+func (c *Client) GetRepository(ctx context.Context, providerName, repoURL, token string) (*Metadata, error) {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetRepository(ctx, c, token, u)
+}
+
+// CreateRepository creates an empty repository at repoURL
+func (c *Client) CreateRepository(ctx context.Context, providerName, repoURL, token string, private bool) error {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return err
+	}
+	return p.CreateRepository(ctx, c, token, u, private)
+}
+
+// ArchiveRepository marks the repository at repoURL read-only
+func (c *Client) ArchiveRepository(ctx context.Context, providerName, repoURL, token string) error {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return err
+	}
+	return p.ArchiveRepository(ctx, c, token, u)
+}
+
+// DeleteRepository deletes the repository at repoURL
+func (c *Client) DeleteRepository(ctx context.Context, providerName, repoURL, token string) error {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return err
+	}
+	return p.DeleteRepository(ctx, c, token, u)
+}
+
+// ListHooks returns the webhooks installed on the repository at repoURL
+func (c *Client) ListHooks(ctx context.Context, providerName, repoURL, token string) ([]Hook, error) {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return p.ListHooks(ctx, c, token, u)
+}
+
+// CreateHook installs a push webhook delivering to hookURL signed with secret
+func (c *Client) CreateHook(ctx context.Context, providerName, repoURL, token, hookURL, secret string) (Hook, error) {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return Hook{}, err
+	}
+	return p.CreateHook(ctx, c, token, u, hookURL, secret)
+}
+
+// UpdateHook re-applies the delivery URL, secret and push subscription to an
+// existing webhook, re-activating it if it was disabled
+func (c *Client) UpdateHook(ctx context.Context, providerName, repoURL, token, hookID, hookURL, secret string) error {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return err
+	}
+	return p.UpdateHook(ctx, c, token, u, hookID, hookURL, secret)
+}
+
+// ListDeployKeys returns the deploy keys of the repository at repoURL
+func (c *Client) ListDeployKeys(ctx context.Context, providerName, repoURL, token string) ([]DeployKey, error) {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return p.ListDeployKeys(ctx, c, token, u)
+}
+
+// CreateDeployKey adds key as a write-enabled deploy key titled title to
+// the repository at repoURL
+func (c *Client) CreateDeployKey(ctx context.Context, providerName, repoURL, token, title, key string) (DeployKey, error) {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return DeployKey{}, err
+	}
+	return p.CreateDeployKey(ctx, c, token, u, title, key)
+}
+
+// DeleteDeployKey removes a deploy key from the repository at repoURL
+func (c *Client) DeleteDeployKey(ctx context.Context, providerName, repoURL, token, keyID string) error {
+	p, u, err := resolve(providerName, repoURL)
+	if err != nil {
+		return err
+	}
+	return p.DeleteDeployKey(ctx, c, token, u, keyID)
+}
+
+// VerifyToken checks that token is accepted by the provider instance at host
+func (c *Client) VerifyToken(ctx context.Context, p Provider, host, token string) error {
+	return c.Call(ctx, p, token, http.MethodGet, p.APIBase(host)+"/user", nil, nil)
+}
+
+// Call performs an authenticated JSON API request on behalf of p, decoding
+// the response into out when it is non-nil
+func (c *Client) Call(ctx context.Context, p Provider, token, method, endpoint string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	p.Authorize(req, token)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{
+			Method:     method,
+			URL:        endpoint,
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
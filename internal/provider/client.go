@@ -0,0 +1,275 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitsync/internal/clock"
+)
+
+// ErrThrottled is returned when a provider rate limit prevents a call from
+// being made within the allowed wait budget
+var ErrThrottled = errors.New("provider rate limit exceeded")
+
+// ThrottledError carries the time at which the provider will accept calls again
+type ThrottledError struct {
+	Host    string
+	RetryAt time.Time
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s: rate limited until %s", e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is makes errors.Is(err, ErrThrottled) match a ThrottledError
+func (e *ThrottledError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// RetryAt returns when a throttled call may be retried, if err is a throttling error
+func RetryAt(err error) (time.Time, bool) {
+	var te *ThrottledError
+	if errors.As(err, &te) {
+		return te.RetryAt, true
+	}
+	return time.Time{}, false
+}
+
+// ClientOptions configures the rate-limit behaviour of a Client
+type ClientOptions struct {
+	// MinRemaining defers calls once the remaining quota drops to this value
+	MinRemaining int
+	// MaxWait is the longest a call will block waiting for a limit to reset
+	// before giving up with a ThrottledError
+	MaxWait time.Duration
+	// MaxRetries bounds how many times a 429/403 rate-limit response is retried
+	MaxRetries int
+	// HTTPClient performs the actual requests
+	HTTPClient *http.Client
+	// Clock tells when rate limits reset; the wall clock when nil
+	Clock clock.Clock
+}
+
+// DefaultClientOptions returns the options used by NewClient when none are given
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		MinRemaining: 10,
+		MaxWait:      30 * time.Second,
+		MaxRetries:   3,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second, Transport: pooledTransport},
+	}
+}
+
+// pooledTransport keeps enough idle connections per provider host that
+// syncing thousands of repositories against one host reuses TLS sessions
+// instead of handshaking for every call
+var pooledTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 256
+	t.MaxIdleConnsPerHost = 64
+	t.IdleConnTimeout = 90 * time.Second
+	return t
+}()
+
+// Client is the single entry point for provider API calls. It tracks the
+// rate-limit state reported by each host and defers calls when the quota
+// is nearly exhausted.
+type Client struct {
+	opts ClientOptions
+
+	mu     sync.Mutex
+	limits map[string]*rateState
+}
+
+type rateState struct {
+	remaining int
+	known     bool
+	resetAt   time.Time
+}
+
+// NewClient creates a rate-limit aware provider API client
+func NewClient(opts ClientOptions) *Client {
+	defaults := DefaultClientOptions()
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = defaults.HTTPClient
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.System{}
+	}
+	return &Client{
+		opts:   opts,
+		limits: make(map[string]*rateState),
+	}
+}
+
+// headersKey is the context key of the headers set by WithHeaders
+type headersKey struct{}
+
+// WithHeaders returns a context whose API requests carry headers, for
+// instances behind authenticating proxies. They are set over the headers
+// of the provider, so an Authorization header replaces its credential.
+func WithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+// Do sends req, waiting for the host's rate limit to reset when needed.
+// Requests with a body must set GetBody so they can be retried.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := req.URL.Host
+	if headers, ok := ctx.Value(headersKey{}).(map[string]string); ok {
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx, host); err != nil {
+			return nil, err
+		}
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			req.Body = body
+		}
+
+		resp, err := c.opts.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		retryAt, limited := c.observe(host, resp)
+		if !limited {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if attempt >= c.opts.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return nil, &ThrottledError{Host: host, RetryAt: retryAt}
+		}
+	}
+}
+
+// wait blocks until the host's quota allows another call, or returns a
+// ThrottledError if that would take longer than MaxWait
+func (c *Client) wait(ctx context.Context, host string) error {
+	c.mu.Lock()
+	state := c.limits[host]
+	var until time.Time
+	now := c.opts.Clock.Now()
+	if state != nil && state.known && state.remaining <= c.opts.MinRemaining && now.Before(state.resetAt) {
+		until = state.resetAt
+	}
+	c.mu.Unlock()
+
+	if until.IsZero() {
+		return nil
+	}
+
+	delay := until.Sub(now)
+	if delay > c.opts.MaxWait {
+		return &ThrottledError{Host: host, RetryAt: until}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// observe records the rate-limit headers of resp and reports whether the
+// response itself was a rate-limit rejection
+func (c *Client) observe(host string, resp *http.Response) (time.Time, bool) {
+	now := c.opts.Clock.Now()
+	remaining, hasRemaining := headerInt(resp.Header, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	reset, hasReset := headerInt(resp.Header, "X-RateLimit-Reset", "RateLimit-Reset")
+
+	var resetAt time.Time
+	if hasReset {
+		resetAt = resetTime(now, int64(reset))
+	}
+	if retryAfter := parseRetryAfter(now, resp.Header.Get("Retry-After")); !retryAfter.IsZero() {
+		resetAt = retryAfter
+	}
+
+	limited := resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusForbidden && hasRemaining && remaining == 0)
+
+	c.mu.Lock()
+	state, ok := c.limits[host]
+	if !ok {
+		state = &rateState{}
+		c.limits[host] = state
+	}
+	if hasRemaining {
+		state.remaining = remaining
+		state.known = true
+	}
+	if limited {
+		state.remaining = 0
+		state.known = true
+	}
+	if !resetAt.IsZero() {
+		state.resetAt = resetAt
+	}
+	if limited && !state.resetAt.After(now) {
+		// No usable reset hint; back off for a short fixed period
+		state.resetAt = now.Add(time.Minute)
+	}
+	retryAt := state.resetAt
+	c.mu.Unlock()
+
+	return retryAt, limited
+}
+
+// resetTime interprets a reset header either as a unix timestamp (GitHub,
+// Gitea) or as a number of seconds from now (GitLab's RateLimit-Reset on
+// some versions)
+func resetTime(now time.Time, v int64) time.Time {
+	if v > 1_000_000_000 {
+		return time.Unix(v, 0)
+	}
+	return now.Add(time.Duration(v) * time.Second)
+}
+
+func parseRetryAfter(now time.Time, v string) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return now.Add(time.Duration(secs) * time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+func headerInt(h http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
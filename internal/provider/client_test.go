@@ -0,0 +1,41 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitsync/internal/clock"
+)
+
+func TestClientWait(t *testing.T) {
+	start := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	clk := clock.NewManual(start)
+	c := NewClient(ClientOptions{MinRemaining: 10, MaxWait: 30 * time.Second, Clock: clk})
+	const host = "api.github.com"
+
+	// A rejection with a reset hint past MaxWait throttles the host until
+	// the reset
+	retryAt, limited := c.observe(host, &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{"X-Ratelimit-Remaining": {"0"}, "Retry-After": {"300"}},
+	})
+	if !limited || !retryAt.Equal(start.Add(5*time.Minute)) {
+		t.Fatalf("observe = %s, %v, want %s, true", retryAt, limited, start.Add(5*time.Minute))
+	}
+	var throttled *ThrottledError
+	if err := c.wait(context.Background(), host); !errors.As(err, &throttled) || !throttled.RetryAt.Equal(retryAt) {
+		t.Fatalf("wait = %v, want a ThrottledError until %s", err, retryAt)
+	}
+
+	// Once the limit resets calls go through without waiting
+	clk.Advance(5 * time.Minute)
+	if err := c.wait(context.Background(), host); err != nil {
+		t.Errorf("wait after the reset = %v, want nil", err)
+	}
+	if err := c.wait(context.Background(), "gitlab.com"); err != nil {
+		t.Errorf("wait for another host = %v, want nil", err)
+	}
+}
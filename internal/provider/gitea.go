@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func init() {
+	Register(Gitea{})
+}
+
+// Gitea implements Provider for Gitea and Forgejo instances
+type Gitea struct{}
+
+// Name implements Provider
+func (Gitea) Name() string { return "gitea" }
+
+// Hosts implements Provider
+func (Gitea) Hosts() []string { return []string{"gitea.com", "codeberg.org"} }
+
+// ValidatePath implements Provider
+func (Gitea) ValidatePath(path string) error {
+	return ownerNamePath("gitea", path)
+}
+
+// APIBase implements Provider
+func (Gitea) APIBase(host string) string {
+	return "https://" + host + "/api/v1"
+}
+
+// Authorize implements Provider
+func (Gitea) Authorize(req *http.Request, token string) {
+	req.Header.Set("Authorization", "token "+token)
+}
+
+// GitAuth implements Provider
+func (Gitea) GitAuth(token string) (string, string) {
+	return token, "x-oauth-basic"
+}
+
+// GetRepository implements Provider
+func (g Gitea) GetRepository(ctx context.Context, c *Client, token string, repo *RepoURL) (*Metadata, error) {
+	return getOwnerNameRepository(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path)
+}
+
+// CreateRepository implements Provider
+func (g Gitea) CreateRepository(ctx context.Context, c *Client, token string, repo *RepoURL, private bool) error {
+	return createOwnerNameRepository(ctx, c, g, token, g.APIBase(repo.Host), repo, private)
+}
+
+// ArchiveRepository implements Provider
+func (g Gitea) ArchiveRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error {
+	return c.Call(ctx, g, token, http.MethodPatch, g.APIBase(repo.Host)+"/repos/"+repo.Path, map[string]any{"archived": true}, nil)
+}
+
+// DeleteRepository implements Provider
+func (g Gitea) DeleteRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error {
+	return c.Call(ctx, g, token, http.MethodDelete, g.APIBase(repo.Host)+"/repos/"+repo.Path, nil, nil)
+}
+
+// ListHooks implements Provider
+func (g Gitea) ListHooks(ctx context.Context, c *Client, token string, repo *RepoURL) ([]Hook, error) {
+	return listConfigHooks(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/hooks")
+}
+
+// CreateHook implements Provider
+func (g Gitea) CreateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookURL, secret string) (Hook, error) {
+	payload := configHookPayload(hookURL, secret)
+	payload["type"] = "gitea"
+	return createHook(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/hooks", hookURL, payload)
+}
+
+// UpdateHook implements Provider
+func (g Gitea) UpdateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookID, hookURL, secret string) error {
+	return c.Call(ctx, g, token, http.MethodPatch,
+		g.APIBase(repo.Host)+"/repos/"+repo.Path+"/hooks/"+hookID, configHookPayload(hookURL, secret), nil)
+}
+
+// ListDeployKeys implements Provider
+func (g Gitea) ListDeployKeys(ctx context.Context, c *Client, token string, repo *RepoURL) ([]DeployKey, error) {
+	return listOwnerNameKeys(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/keys")
+}
+
+// CreateDeployKey implements Provider
+func (g Gitea) CreateDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, title, key string) (DeployKey, error) {
+	return createOwnerNameKey(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/keys", title, key)
+}
+
+// DeleteDeployKey implements Provider
+func (g Gitea) DeleteDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, keyID string) error {
+	return c.Call(ctx, g, token, http.MethodDelete, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/keys/"+keyID, nil, nil)
+}
+
+// ParseWebhook implements Provider
+func (Gitea) ParseWebhook(r *http.Request, secret string) (*PushEvent, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+	if !validSignature(body, secret, r.Header.Get("X-Gitea-Signature")) {
+		return nil, ErrInvalidSignature
+	}
+	if r.Header.Get("X-Gitea-Event") != "push" {
+		return nil, nil
+	}
+	return decodeOwnerNamePush(body)
+}
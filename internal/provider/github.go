@@ -0,0 +1,284 @@
+package provider
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(GitHub{})
+}
+
+// GitHub implements Provider for github.com and GitHub Enterprise Server
+type GitHub struct{}
+
+// Name implements Provider
+func (GitHub) Name() string { return "github" }
+
+// Hosts implements Provider
+func (GitHub) Hosts() []string { return []string{"github.com"} }
+
+// ValidatePath implements Provider
+func (GitHub) ValidatePath(path string) error {
+	return ownerNamePath("github", path)
+}
+
+// APIBase implements Provider
+func (GitHub) APIBase(host string) string {
+	if host == "github.com" {
+		return "https://api.github.com"
+	}
+	return "https://" + host + "/api/v3"
+}
+
+// Authorize implements Provider
+func (GitHub) Authorize(req *http.Request, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// GitAuth implements Provider
+func (GitHub) GitAuth(token string) (string, string) {
+	return "x-access-token", token
+}
+
+// GetRepository implements Provider
+func (g GitHub) GetRepository(ctx context.Context, c *Client, token string, repo *RepoURL) (*Metadata, error) {
+	return getOwnerNameRepository(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path)
+}
+
+// CreateRepository implements Provider
+func (g GitHub) CreateRepository(ctx context.Context, c *Client, token string, repo *RepoURL, private bool) error {
+	return createOwnerNameRepository(ctx, c, g, token, g.APIBase(repo.Host), repo, private)
+}
+
+// ArchiveRepository implements Provider
+func (g GitHub) ArchiveRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error {
+	return c.Call(ctx, g, token, http.MethodPatch, g.APIBase(repo.Host)+"/repos/"+repo.Path, map[string]any{"archived": true}, nil)
+}
+
+// DeleteRepository implements Provider
+func (g GitHub) DeleteRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error {
+	return c.Call(ctx, g, token, http.MethodDelete, g.APIBase(repo.Host)+"/repos/"+repo.Path, nil, nil)
+}
+
+// ListHooks implements Provider
+func (g GitHub) ListHooks(ctx context.Context, c *Client, token string, repo *RepoURL) ([]Hook, error) {
+	return listConfigHooks(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/hooks")
+}
+
+// CreateHook implements Provider
+func (g GitHub) CreateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookURL, secret string) (Hook, error) {
+	payload := configHookPayload(hookURL, secret)
+	payload["name"] = "web"
+	return createHook(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/hooks", hookURL, payload)
+}
+
+// UpdateHook implements Provider
+func (g GitHub) UpdateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookID, hookURL, secret string) error {
+	return c.Call(ctx, g, token, http.MethodPatch,
+		g.APIBase(repo.Host)+"/repos/"+repo.Path+"/hooks/"+hookID, configHookPayload(hookURL, secret), nil)
+}
+
+// ListDeployKeys implements Provider
+func (g GitHub) ListDeployKeys(ctx context.Context, c *Client, token string, repo *RepoURL) ([]DeployKey, error) {
+	return listOwnerNameKeys(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/keys")
+}
+
+// CreateDeployKey implements Provider
+func (g GitHub) CreateDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, title, key string) (DeployKey, error) {
+	return createOwnerNameKey(ctx, c, g, token, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/keys", title, key)
+}
+
+// DeleteDeployKey implements Provider
+func (g GitHub) DeleteDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, keyID string) error {
+	return c.Call(ctx, g, token, http.MethodDelete, g.APIBase(repo.Host)+"/repos/"+repo.Path+"/keys/"+keyID, nil, nil)
+}
+
+// ParseWebhook implements Provider
+func (GitHub) ParseWebhook(r *http.Request, secret string) (*PushEvent, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+	signature := strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !validSignature(body, secret, signature) {
+		return nil, ErrInvalidSignature
+	}
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		return nil, nil
+	}
+	return decodeOwnerNamePush(body)
+}
+
+// ErrInvalidSignature is returned by ParseWebhook when a delivery is not
+// authenticated by the expected secret
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// validSignature checks a hex HMAC-SHA256 signature of body in constant time
+func validSignature(body []byte, secret, signature string) bool {
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// ownerNamePath enforces the two-segment owner/name layout
+func ownerNamePath(providerName, path string) error {
+	if strings.Count(path, "/") != 1 {
+		return fmt.Errorf("%s repository path must be owner/name", providerName)
+	}
+	return nil
+}
+
+// The GitHub and Gitea APIs share their repository and hook shapes, so the
+// helpers below serve both.
+
+func getOwnerNameRepository(ctx context.Context, c *Client, p Provider, token, endpoint string) (*Metadata, error) {
+	var raw struct {
+		DefaultBranch string `json:"default_branch"`
+		Private       bool   `json:"private"`
+		Archived      bool   `json:"archived"`
+		Size          int64  `json:"size"`
+		Description   string `json:"description"`
+	}
+	if err := c.Call(ctx, p, token, http.MethodGet, endpoint, nil, &raw); err != nil {
+		return nil, err
+	}
+	return &Metadata{
+		DefaultBranch: raw.DefaultBranch,
+		Private:       raw.Private,
+		Archived:      raw.Archived,
+		SizeKB:        raw.Size,
+		Description:   raw.Description,
+	}, nil
+}
+
+func createOwnerNameRepository(ctx context.Context, c *Client, p Provider, token, base string, repo *RepoURL, private bool) error {
+	owner, name, _ := strings.Cut(repo.Path, "/")
+	payload := map[string]any{"name": name, "private": private}
+
+	// Owners may be organizations or the authenticated user
+	err := c.Call(ctx, p, token, http.MethodPost, base+"/orgs/"+owner+"/repos", payload, nil)
+	if IsNotFound(err) {
+		err = c.Call(ctx, p, token, http.MethodPost, base+"/user/repos", payload, nil)
+	}
+	return err
+}
+
+func configHookPayload(hookURL, secret string) map[string]any {
+	return map[string]any{
+		"active": true,
+		"events": []string{"push"},
+		"config": map[string]string{
+			"url":          hookURL,
+			"content_type": "json",
+			"secret":       secret,
+		},
+	}
+}
+
+func listConfigHooks(ctx context.Context, c *Client, p Provider, token, endpoint string) ([]Hook, error) {
+	var raw []struct {
+		ID     int64    `json:"id"`
+		Active bool     `json:"active"`
+		Events []string `json:"events"`
+		Config struct {
+			URL string `json:"url"`
+		} `json:"config"`
+	}
+	if err := c.Call(ctx, p, token, http.MethodGet, endpoint, nil, &raw); err != nil {
+		return nil, err
+	}
+
+	hooks := make([]Hook, 0, len(raw))
+	for _, h := range raw {
+		hooks = append(hooks, Hook{ID: strconv.FormatInt(h.ID, 10), URL: h.Config.URL, Active: h.Active, Push: slices.Contains(h.Events, "push")})
+	}
+	return hooks, nil
+}
+
+func createHook(ctx context.Context, c *Client, p Provider, token, endpoint, hookURL string, payload map[string]any) (Hook, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.Call(ctx, p, token, http.MethodPost, endpoint, payload, &created); err != nil {
+		return Hook{}, err
+	}
+	return Hook{ID: strconv.FormatInt(created.ID, 10), URL: hookURL, Active: true, Push: true}, nil
+}
+
+// ownerNameKey is a deploy key as the GitHub and Gitea APIs return it
+type ownerNameKey struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Key      string `json:"key"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+func (k ownerNameKey) deployKey() DeployKey {
+	return DeployKey{ID: strconv.FormatInt(k.ID, 10), Title: k.Title, Key: k.Key, ReadOnly: k.ReadOnly}
+}
+
+func listOwnerNameKeys(ctx context.Context, c *Client, p Provider, token, endpoint string) ([]DeployKey, error) {
+	var raw []ownerNameKey
+	if err := c.Call(ctx, p, token, http.MethodGet, endpoint, nil, &raw); err != nil {
+		return nil, err
+	}
+	keys := make([]DeployKey, 0, len(raw))
+	for _, k := range raw {
+		keys = append(keys, k.deployKey())
+	}
+	return keys, nil
+}
+
+func createOwnerNameKey(ctx context.Context, c *Client, p Provider, token, endpoint, title, key string) (DeployKey, error) {
+	var created ownerNameKey
+	payload := map[string]any{"title": title, "key": key, "read_only": false}
+	if err := c.Call(ctx, p, token, http.MethodPost, endpoint, payload, &created); err != nil {
+		return DeployKey{}, err
+	}
+	return created.deployKey(), nil
+}
+
+func decodeOwnerNamePush(body []byte) (*PushEvent, error) {
+	var payload struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Repository struct {
+			CloneURL string `json:"clone_url"`
+			SSHURL   string `json:"ssh_url"`
+			HTMLURL  string `json:"html_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return &PushEvent{
+		CloneURLs: nonEmpty(payload.Repository.CloneURL, payload.Repository.SSHURL, payload.Repository.HTMLURL),
+		Ref:       payload.Ref,
+		After:     payload.After,
+	}, nil
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,220 @@
+package provider
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+)
+
+func init() {
+	Register(GitLab{})
+}
+
+// GitLab implements Provider for gitlab.com and self-managed GitLab
+type GitLab struct{}
+
+// Name implements Provider
+func (GitLab) Name() string { return "gitlab" }
+
+// Hosts implements Provider
+func (GitLab) Hosts() []string { return []string{"gitlab.com"} }
+
+// ValidatePath implements Provider. GitLab allows nested groups.
+func (GitLab) ValidatePath(p string) error {
+	if dir := path.Dir(p); dir == "." {
+		return errors.New("gitlab repository path must be group/name")
+	}
+	return nil
+}
+
+// APIBase implements Provider
+func (GitLab) APIBase(host string) string {
+	return "https://" + host + "/api/v4"
+}
+
+// Authorize implements Provider
+func (GitLab) Authorize(req *http.Request, token string) {
+	req.Header.Set("PRIVATE-TOKEN", token)
+}
+
+func (g GitLab) projectURL(repo *RepoURL) string {
+	return g.APIBase(repo.Host) + "/projects/" + url.PathEscape(repo.Path)
+}
+
+// GitAuth implements Provider
+func (GitLab) GitAuth(token string) (string, string) {
+	return "oauth2", token
+}
+
+// GetRepository implements Provider
+func (g GitLab) GetRepository(ctx context.Context, c *Client, token string, repo *RepoURL) (*Metadata, error) {
+	var raw struct {
+		DefaultBranch string `json:"default_branch"`
+		Visibility    string `json:"visibility"`
+		Archived      bool   `json:"archived"`
+		Description   string `json:"description"`
+		Statistics    struct {
+			RepositorySize int64 `json:"repository_size"`
+		} `json:"statistics"`
+	}
+	if err := c.Call(ctx, g, token, http.MethodGet, g.projectURL(repo)+"?statistics=true", nil, &raw); err != nil {
+		return nil, err
+	}
+	return &Metadata{
+		DefaultBranch: raw.DefaultBranch,
+		Private:       raw.Visibility != "public",
+		Archived:      raw.Archived,
+		SizeKB:        raw.Statistics.RepositorySize / 1024,
+		Description:   raw.Description,
+	}, nil
+}
+
+// CreateRepository implements Provider
+func (g GitLab) CreateRepository(ctx context.Context, c *Client, token string, repo *RepoURL, private bool) error {
+	var namespace struct {
+		ID int64 `json:"id"`
+	}
+	err := c.Call(ctx, g, token, http.MethodGet,
+		g.APIBase(repo.Host)+"/namespaces/"+url.PathEscape(path.Dir(repo.Path)), nil, &namespace)
+	if err != nil {
+		return fmt.Errorf("failed to resolve namespace: %w", err)
+	}
+
+	visibility := "public"
+	if private {
+		visibility = "private"
+	}
+	name := path.Base(repo.Path)
+	return c.Call(ctx, g, token, http.MethodPost, g.APIBase(repo.Host)+"/projects", map[string]any{
+		"name":         name,
+		"path":         name,
+		"namespace_id": namespace.ID,
+		"visibility":   visibility,
+	}, nil)
+}
+
+// ArchiveRepository implements Provider
+func (g GitLab) ArchiveRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error {
+	return c.Call(ctx, g, token, http.MethodPost, g.projectURL(repo)+"/archive", nil, nil)
+}
+
+// DeleteRepository implements Provider
+func (g GitLab) DeleteRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error {
+	return c.Call(ctx, g, token, http.MethodDelete, g.projectURL(repo), nil, nil)
+}
+
+// ListHooks implements Provider
+func (g GitLab) ListHooks(ctx context.Context, c *Client, token string, repo *RepoURL) ([]Hook, error) {
+	var raw []struct {
+		ID         int64  `json:"id"`
+		URL        string `json:"url"`
+		PushEvents bool   `json:"push_events"`
+	}
+	if err := c.Call(ctx, g, token, http.MethodGet, g.projectURL(repo)+"/hooks", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	hooks := make([]Hook, 0, len(raw))
+	for _, h := range raw {
+		hooks = append(hooks, Hook{ID: strconv.FormatInt(h.ID, 10), URL: h.URL, Active: true, Push: h.PushEvents})
+	}
+	return hooks, nil
+}
+
+func gitlabHookPayload(hookURL, secret string) map[string]any {
+	return map[string]any{
+		"url":         hookURL,
+		"token":       secret,
+		"push_events": true,
+	}
+}
+
+// CreateHook implements Provider
+func (g GitLab) CreateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookURL, secret string) (Hook, error) {
+	return createHook(ctx, c, g, token, g.projectURL(repo)+"/hooks", hookURL, gitlabHookPayload(hookURL, secret))
+}
+
+// UpdateHook implements Provider
+func (g GitLab) UpdateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookID, hookURL, secret string) error {
+	return c.Call(ctx, g, token, http.MethodPut, g.projectURL(repo)+"/hooks/"+hookID, gitlabHookPayload(hookURL, secret), nil)
+}
+
+// gitlabKey is a deploy key as the GitLab API returns it
+type gitlabKey struct {
+	ID      int64  `json:"id"`
+	Title   string `json:"title"`
+	Key     string `json:"key"`
+	CanPush bool   `json:"can_push"`
+}
+
+func (k gitlabKey) deployKey() DeployKey {
+	return DeployKey{ID: strconv.FormatInt(k.ID, 10), Title: k.Title, Key: k.Key, ReadOnly: !k.CanPush}
+}
+
+// ListDeployKeys implements Provider
+func (g GitLab) ListDeployKeys(ctx context.Context, c *Client, token string, repo *RepoURL) ([]DeployKey, error) {
+	var raw []gitlabKey
+	if err := c.Call(ctx, g, token, http.MethodGet, g.projectURL(repo)+"/deploy_keys", nil, &raw); err != nil {
+		return nil, err
+	}
+	keys := make([]DeployKey, 0, len(raw))
+	for _, k := range raw {
+		keys = append(keys, k.deployKey())
+	}
+	return keys, nil
+}
+
+// CreateDeployKey implements Provider
+func (g GitLab) CreateDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, title, key string) (DeployKey, error) {
+	var created gitlabKey
+	payload := map[string]any{"title": title, "key": key, "can_push": true}
+	if err := c.Call(ctx, g, token, http.MethodPost, g.projectURL(repo)+"/deploy_keys", payload, &created); err != nil {
+		return DeployKey{}, err
+	}
+	return created.deployKey(), nil
+}
+
+// DeleteDeployKey implements Provider
+func (g GitLab) DeleteDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, keyID string) error {
+	return c.Call(ctx, g, token, http.MethodDelete, g.projectURL(repo)+"/deploy_keys/"+keyID, nil, nil)
+}
+
+// ParseWebhook implements Provider. GitLab sends the secret verbatim in
+// X-Gitlab-Token rather than signing the payload.
+func (GitLab) ParseWebhook(r *http.Request, secret string) (*PushEvent, error) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+		return nil, ErrInvalidSignature
+	}
+	if r.Header.Get("X-Gitlab-Event") != "Push Hook" {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+	var payload struct {
+		Ref     string `json:"ref"`
+		After   string `json:"after"`
+		Project struct {
+			HTTPURL string `json:"git_http_url"`
+			SSHURL  string `json:"git_ssh_url"`
+			WebURL  string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return &PushEvent{
+		CloneURLs: nonEmpty(payload.Project.HTTPURL, payload.Project.SSHURL, payload.Project.WebURL),
+		Ref:       payload.Ref,
+		After:     payload.After,
+	}, nil
+}
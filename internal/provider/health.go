@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gitsync/internal/clock"
+)
+
+// Connectivity states reported by the Prober
+const (
+	StatusOK           = "ok"
+	StatusUnauthorized = "unauthorized"
+	StatusUnreachable  = "unreachable"
+	StatusThrottled    = "throttled"
+)
+
+// InstanceStatus is the last probe result for one provider instance
+type InstanceStatus struct {
+	Provider  string    `json:"provider"`
+	Host      string    `json:"host"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Prober periodically verifies that every configured provider instance is
+// reachable and accepts its credential
+type Prober struct {
+	Client *Client
+	Tokens map[string]string
+	Clock  clock.Clock
+
+	mu      sync.RWMutex
+	results map[string]InstanceStatus
+}
+
+// NewProber creates a Prober for the providers that have a token configured
+func NewProber(client *Client, tokens map[string]string, clk clock.Clock) *Prober {
+	return &Prober{
+		Client:  client,
+		Tokens:  tokens,
+		Clock:   clk,
+		results: make(map[string]InstanceStatus),
+	}
+}
+
+// ProbeAll checks every configured instance once
+func (p *Prober) ProbeAll(ctx context.Context) {
+	for providerName, token := range p.Tokens {
+		prov, ok := Get(providerName)
+		if !ok || token == "" {
+			continue
+		}
+		for _, host := range hostsFor(prov) {
+			status := p.probe(ctx, prov, host, token)
+			if status.Status != StatusOK {
+				log.Printf("WARN: provider %s at %s is %s: %s", providerName, host, status.Status, status.Error)
+			}
+
+			p.mu.Lock()
+			p.results[providerName+"/"+host] = status
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *Prober) probe(ctx context.Context, prov Provider, host, token string) InstanceStatus {
+	status := InstanceStatus{Provider: prov.Name(), Host: host, Status: StatusOK}
+
+	start := p.Clock.Now()
+	err := p.Client.VerifyToken(ctx, prov, host, token)
+	status.CheckedAt = p.Clock.Now()
+	status.LatencyMS = status.CheckedAt.Sub(start).Milliseconds()
+
+	if err != nil {
+		status.Error = err.Error()
+		var apiErr *APIError
+		switch {
+		case errors.Is(err, ErrThrottled):
+			status.Status = StatusThrottled
+		case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+			status.Status = StatusUnauthorized
+		default:
+			status.Status = StatusUnreachable
+		}
+	}
+	return status
+}
+
+// Statuses returns the latest result for every probed instance
+func (p *Prober) Statuses() []InstanceStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]InstanceStatus, 0, len(p.results))
+	for _, s := range p.results {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Provider != statuses[j].Provider {
+			return statuses[i].Provider < statuses[j].Provider
+		}
+		return statuses[i].Host < statuses[j].Host
+	})
+	return statuses
+}
+
+// Run probes all instances every interval until ctx is cancelled
+func (p *Prober) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.ProbeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
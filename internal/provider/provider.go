@@ -0,0 +1,146 @@
+// Package provider abstracts the git forges GitSync replicates between.
+//
+// Each forge implements the Provider interface and registers itself from an
+// init function. Additional forges can be compiled in by adding a package
+// that calls Register and importing it for side effects, for example from a
+// file guarded by a build tag in cmd/server.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metadata describes a repository as reported by the provider API
+type Metadata struct {
+	DefaultBranch string `json:"default_branch"`
+	Private       bool   `json:"private"`
+	Archived      bool   `json:"archived"`
+	SizeKB        int64  `json:"size_kb"`
+	Description   string `json:"description"`
+}
+
+// PushEvent is the provider-neutral content of a push webhook delivery
+type PushEvent struct {
+	// CloneURLs lists every clone URL the payload reports for the repository
+	CloneURLs []string
+	Ref       string
+	After     string
+}
+
+// PushRepositoryURLs returns the repository URLs a push webhook payload of
+// any provider reports, without authenticating it, so the repository and
+// the secret its delivery is checked with can be found
+func PushRepositoryURLs(body []byte) []string {
+	var payload struct {
+		Repository struct {
+			CloneURL string `json:"clone_url"`
+			SSHURL   string `json:"ssh_url"`
+			HTMLURL  string `json:"html_url"`
+		} `json:"repository"`
+		Project struct {
+			HTTPURL string `json:"git_http_url"`
+			SSHURL  string `json:"git_ssh_url"`
+			WebURL  string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	return nonEmpty(payload.Repository.CloneURL, payload.Repository.SSHURL, payload.Repository.HTMLURL,
+		payload.Project.HTTPURL, payload.Project.SSHURL, payload.Project.WebURL)
+}
+
+// Provider is implemented by every supported git forge
+type Provider interface {
+	// Name is the identifier used in source_provider and provider fields
+	Name() string
+	// Hosts returns the well-known public hosts of the forge
+	Hosts() []string
+	// ValidatePath checks the repository path layout the forge allows
+	ValidatePath(path string) error
+	// APIBase returns the REST API root of the instance at host
+	APIBase(host string) string
+	// Authorize adds the credential to an API request
+	Authorize(req *http.Request, token string)
+	// GitAuth returns the HTTP basic auth pair git should use with token
+	GitAuth(token string) (username, password string)
+
+	// GetRepository fetches repository metadata
+	GetRepository(ctx context.Context, c *Client, token string, repo *RepoURL) (*Metadata, error)
+	// CreateRepository creates an empty repository at repo
+	CreateRepository(ctx context.Context, c *Client, token string, repo *RepoURL, private bool) error
+	// ArchiveRepository marks the repository read-only
+	ArchiveRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error
+	// DeleteRepository deletes the repository
+	DeleteRepository(ctx context.Context, c *Client, token string, repo *RepoURL) error
+
+	// ListHooks returns the webhooks installed on the repository
+	ListHooks(ctx context.Context, c *Client, token string, repo *RepoURL) ([]Hook, error)
+	// CreateHook installs a push webhook delivering to hookURL
+	CreateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookURL, secret string) (Hook, error)
+	// UpdateHook re-applies URL, secret and push subscription to a webhook
+	UpdateHook(ctx context.Context, c *Client, token string, repo *RepoURL, hookID, hookURL, secret string) error
+
+	// ListDeployKeys returns the deploy keys of the repository
+	ListDeployKeys(ctx context.Context, c *Client, token string, repo *RepoURL) ([]DeployKey, error)
+	// CreateDeployKey adds a write-enabled deploy key to the repository
+	CreateDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, title, key string) (DeployKey, error)
+	// DeleteDeployKey removes a deploy key from the repository
+	DeleteDeployKey(ctx context.Context, c *Client, token string, repo *RepoURL, keyID string) error
+	// ParseWebhook authenticates a delivery with secret and decodes it.
+	// Non-push events return a nil event and no error.
+	ParseWebhook(r *http.Request, secret string) (*PushEvent, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Provider)
+)
+
+// Register makes a provider available by name. It panics if the name is
+// already taken, mirroring database/sql driver registration.
+func Register(p Provider) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, dup := registry[p.Name()]; dup {
+		panic("provider: Register called twice for " + p.Name())
+	}
+	registry[p.Name()] = p
+}
+
+// Get returns the registered provider with the given name
+func Get(name string) (Provider, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	p, ok := registry[name]
+	return p, ok
+}
+
+// Names returns the sorted names of all registered providers
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the named provider or an error listing the allowed names
+func Lookup(name string) (Provider, error) {
+	if p, ok := Get(name); ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unsupported provider %q. allowed: %s", name, strings.Join(Names(), ", "))
+}
@@ -0,0 +1,284 @@
+package providertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type hookRequest struct {
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Active *bool  `json:"active"`
+	Config struct {
+		URL    string `json:"url"`
+		Secret string `json:"secret"`
+	} `json:"config"`
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// GitLab project IDs are URL-encoded paths, so route on the raw path
+	p := r.URL.EscapedPath()
+	var rest string
+	var ok bool
+	switch s.Kind {
+	case GitHub:
+		rest, ok = strings.CutPrefix(p, "/api/v3")
+	case Gitea:
+		rest, ok = strings.CutPrefix(p, "/api/v1")
+	case GitLab:
+		rest, ok = strings.CutPrefix(p, "/api/v4")
+	}
+	if !ok {
+		notFound(w)
+		return
+	}
+	if rest == "/user" && r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, map[string]string{"login": "providertest"})
+		return
+	}
+	if s.Kind == GitLab {
+		s.serveGitLab(w, r, rest)
+		return
+	}
+	s.serveOwnerName(w, r, rest)
+}
+
+// serveOwnerName implements the GitHub and Gitea API, which share their
+// repository and hook shapes
+func (s *Server) serveOwnerName(w http.ResponseWriter, r *http.Request, rest string) {
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "orgs" && parts[2] == "repos":
+		s.createFromRequest(w, r, parts[1])
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[0] == "user" && parts[1] == "repos":
+		s.createFromRequest(w, r, "providertest")
+	case len(parts) >= 3 && parts[0] == "repos":
+		repo, ok := s.repos[parts[1]+"/"+parts[2]]
+		if !ok {
+			notFound(w)
+			return
+		}
+		s.serveRepo(w, r, repo, parts[3:])
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) createFromRequest(w http.ResponseWriter, r *http.Request, owner string) {
+	var req struct {
+		Name    string `json:"name"`
+		Private bool   `json:"private"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, `{"message":"invalid body"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	if err := s.createRepo(owner+"/"+req.Name, req.Private); err != nil {
+		http.Error(w, `{"message":"name already exists"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.repoJSON(s.repos[owner+"/"+req.Name]))
+}
+
+func (s *Server) serveRepo(w http.ResponseWriter, r *http.Request, repo *Repo, rest []string) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.repoJSON(repo))
+	case len(rest) == 0 && r.Method == http.MethodPatch:
+		var req struct {
+			Archived *bool `json:"archived"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Archived != nil {
+			repo.Archived = *req.Archived
+		}
+		writeJSON(w, http.StatusOK, s.repoJSON(repo))
+	case len(rest) == 0 && r.Method == http.MethodDelete:
+		os.RemoveAll(s.dir(repo.Path))
+		delete(s.repos, repo.Path)
+		w.WriteHeader(http.StatusNoContent)
+	case len(rest) == 1 && rest[0] == "archive" && r.Method == http.MethodPost:
+		repo.Archived = true
+		writeJSON(w, http.StatusCreated, s.repoJSON(repo))
+	case len(rest) == 1 && rest[0] == "hooks" && r.Method == http.MethodGet:
+		hooks := make([]map[string]any, 0, len(repo.Hooks))
+		for _, h := range repo.Hooks {
+			hooks = append(hooks, s.hookJSON(h))
+		}
+		writeJSON(w, http.StatusOK, hooks)
+	case len(rest) == 1 && rest[0] == "hooks" && r.Method == http.MethodPost:
+		var req hookRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		s.nextID++
+		h := &Hook{ID: s.nextID, Active: true}
+		applyHook(h, req)
+		repo.Hooks = append(repo.Hooks, h)
+		writeJSON(w, http.StatusCreated, s.hookJSON(h))
+	case len(rest) == 2 && rest[0] == "hooks" && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		id, _ := strconv.ParseInt(rest[1], 10, 64)
+		for _, h := range repo.Hooks {
+			if h.ID == id {
+				var req hookRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				applyHook(h, req)
+				writeJSON(w, http.StatusOK, s.hookJSON(h))
+				return
+			}
+		}
+		notFound(w)
+	case len(rest) == 1 && (rest[0] == "keys" || rest[0] == "deploy_keys") && r.Method == http.MethodGet:
+		keys := make([]map[string]any, 0, len(repo.DeployKeys))
+		for _, k := range repo.DeployKeys {
+			keys = append(keys, s.keyJSON(k))
+		}
+		writeJSON(w, http.StatusOK, keys)
+	case len(rest) == 1 && (rest[0] == "keys" || rest[0] == "deploy_keys") && r.Method == http.MethodPost:
+		var req struct {
+			Title    string `json:"title"`
+			Key      string `json:"key"`
+			ReadOnly *bool  `json:"read_only"`
+			CanPush  bool   `json:"can_push"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, k := range repo.DeployKeys {
+			if k.Key == req.Key {
+				http.Error(w, `{"message":"key is already in use"}`, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		s.nextID++
+		k := &DeployKey{ID: s.nextID, Title: req.Title, Key: req.Key, ReadOnly: !req.CanPush}
+		if req.ReadOnly != nil {
+			k.ReadOnly = *req.ReadOnly
+		}
+		repo.DeployKeys = append(repo.DeployKeys, k)
+		writeJSON(w, http.StatusCreated, s.keyJSON(k))
+	case len(rest) == 2 && (rest[0] == "keys" || rest[0] == "deploy_keys") && r.Method == http.MethodDelete:
+		id, _ := strconv.ParseInt(rest[1], 10, 64)
+		for i, k := range repo.DeployKeys {
+			if k.ID == id {
+				repo.DeployKeys = append(repo.DeployKeys[:i], repo.DeployKeys[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		notFound(w)
+	default:
+		notFound(w)
+	}
+}
+
+func applyHook(h *Hook, req hookRequest) {
+	if req.Config.URL != "" {
+		h.URL, h.Secret = req.Config.URL, req.Config.Secret
+	}
+	if req.URL != "" {
+		h.URL, h.Secret = req.URL, req.Token
+	}
+	if req.Active != nil {
+		h.Active = *req.Active
+	}
+}
+
+func (s *Server) serveGitLab(w http.ResponseWriter, r *http.Request, rest string) {
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "namespaces":
+		ns := unescape(parts[1])
+		id, ok := s.namespaces[ns]
+		if !ok {
+			s.nextID++
+			id = s.nextID
+			s.namespaces[ns] = id
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "full_path": ns})
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "projects":
+		var req struct {
+			Path        string `json:"path"`
+			NamespaceID int64  `json:"namespace_id"`
+			Visibility  string `json:"visibility"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		ns := ""
+		for p, id := range s.namespaces {
+			if id == req.NamespaceID {
+				ns = p
+			}
+		}
+		if ns == "" {
+			http.Error(w, `{"message":"namespace not found"}`, http.StatusNotFound)
+			return
+		}
+		full := path.Join(ns, req.Path)
+		if err := s.createRepo(full, req.Visibility != "public"); err != nil {
+			http.Error(w, `{"message":"has already been taken"}`, http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s.repoJSON(s.repos[full]))
+	case len(parts) >= 2 && parts[0] == "projects":
+		repo, ok := s.repos[unescape(parts[1])]
+		if !ok {
+			notFound(w)
+			return
+		}
+		s.serveRepo(w, r, repo, parts[2:])
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) repoJSON(repo *Repo) map[string]any {
+	v := map[string]any{
+		"default_branch": repo.DefaultBranch,
+		"archived":       repo.Archived,
+		"description":    repo.Description,
+	}
+	if s.Kind == GitLab {
+		visibility := "public"
+		if repo.Private {
+			visibility = "private"
+		}
+		v["visibility"] = visibility
+		v["path_with_namespace"] = repo.Path
+		v["http_url_to_repo"] = s.RepoURL(repo.Path)
+		v["statistics"] = map[string]int64{"repository_size": 0}
+	} else {
+		v["private"] = repo.Private
+		v["full_name"] = repo.Path
+		v["clone_url"] = s.RepoURL(repo.Path)
+		v["size"] = 0
+	}
+	return v
+}
+
+func (s *Server) hookJSON(h *Hook) map[string]any {
+	if s.Kind == GitLab {
+		return map[string]any{"id": h.ID, "url": h.URL, "push_events": true}
+	}
+	return map[string]any{"id": h.ID, "active": h.Active, "events": []string{"push"},
+		"config": map[string]string{"url": h.URL, "content_type": "json"}}
+}
+
+func (s *Server) keyJSON(k *DeployKey) map[string]any {
+	if s.Kind == GitLab {
+		return map[string]any{"id": k.ID, "title": k.Title, "key": k.Key, "can_push": !k.ReadOnly}
+	}
+	return map[string]any{"id": k.ID, "title": k.Title, "key": k.Key, "read_only": k.ReadOnly}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter) {
+	http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+}
@@ -0,0 +1,368 @@
+// Package providertest runs an in-process fake GitHub, GitLab or Gitea
+// for end-to-end tests. It serves git over the smart HTTP protocol through
+// git http-backend, the subset of the REST API GitSync uses, and sends push
+// webhooks on demand. No network access is needed; git must be installed.
+package providertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gitsync/internal/provider"
+)
+
+// Kinds of forge a Server can imitate
+const (
+	GitHub = "github"
+	GitLab = "gitlab"
+	Gitea  = "gitea"
+)
+
+// Repo is a repository hosted by the fake
+type Repo struct {
+	Path          string
+	Private       bool
+	Archived      bool
+	DefaultBranch string
+	Description   string
+	Hooks         []*Hook
+	DeployKeys    []*DeployKey
+}
+
+// Hook is a webhook registered on a Repo
+type Hook struct {
+	ID     int64
+	URL    string
+	Secret string
+	Active bool
+}
+
+// DeployKey is a deploy key added to a Repo
+type DeployKey struct {
+	ID       int64
+	Title    string
+	Key      string
+	ReadOnly bool
+}
+
+// Server is a fake forge listening on a local TLS port
+type Server struct {
+	Kind string
+	// URL is the base URL, such as https://127.0.0.1:40123
+	URL string
+	// Host is the host:port part of URL, as used in repository URLs
+	Host string
+	// Token is the only token accepted by the API and git endpoints; any
+	// token is accepted when empty
+	Token string
+	// Root holds the bare repositories as <path>.git
+	Root string
+
+	srv    *httptest.Server
+	caFile string
+
+	mu         sync.Mutex
+	repos      map[string]*Repo
+	namespaces map[string]int64
+	nextID     int64
+}
+
+// NewServer starts a fake forge of the given kind
+func NewServer(kind string) (*Server, error) {
+	switch kind {
+	case GitHub, GitLab, Gitea:
+	default:
+		return nil, fmt.Errorf("unsupported kind %q", kind)
+	}
+	root, err := os.MkdirTemp("", "providertest-")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Kind: kind, Root: root, repos: make(map[string]*Repo), namespaces: make(map[string]int64)}
+	s.srv = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	s.Host = strings.TrimPrefix(s.URL, "https://")
+
+	// git verifies the self-signed certificate against this file
+	s.caFile = filepath.Join(root, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.srv.Certificate().Raw})
+	if err := os.WriteFile(s.caFile, cert, 0o644); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close stops the server and removes its repositories
+func (s *Server) Close() {
+	s.srv.Close()
+	os.RemoveAll(s.Root)
+}
+
+// HTTPClient returns a client that trusts the server's certificate, for
+// provider.ClientOptions.HTTPClient
+func (s *Server) HTTPClient() *http.Client {
+	return s.srv.Client()
+}
+
+// GitConfig returns the git configuration needed to talk to the server,
+// for git.Runner.Config. It has no effect when GIT_SSL_CAINFO is set in
+// the environment, which takes precedence over configuration.
+func (s *Server) GitConfig() map[string]string {
+	return map[string]string{"http." + s.URL + "/.sslCAInfo": s.caFile}
+}
+
+// RepoURL returns the clone URL of the repository at path
+func (s *Server) RepoURL(path string) string {
+	return s.URL + "/" + path + ".git"
+}
+
+// ProviderURL returns the repository at path as a provider.RepoURL.
+// Providers build their API base from Host alone and ignore Port, so
+// unlike provider.ParseRepoURL, which splits the port into Port, the
+// returned Host keeps the server's port and API calls reach the server.
+func (s *Server) ProviderURL(path string) *provider.RepoURL {
+	return &provider.RepoURL{Scheme: "https", Host: s.Host, Path: path}
+}
+
+// Repo returns a copy of the repository at path
+func (s *Server) Repo(path string) (Repo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.repos[path]
+	if !ok {
+		return Repo{}, false
+	}
+	return *r, true
+}
+
+// CreateRepo creates an empty bare repository at path
+func (s *Server) CreateRepo(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createRepo(path, false)
+}
+
+func (s *Server) createRepo(path string, private bool) error {
+	if _, ok := s.repos[path]; ok {
+		return fmt.Errorf("repository %s already exists", path)
+	}
+	if err := runGit("", "init", "--bare", "--quiet", "--initial-branch=main", s.dir(path)); err != nil {
+		return err
+	}
+	s.repos[path] = &Repo{Path: path, Private: private, DefaultBranch: "main"}
+	return nil
+}
+
+func (s *Server) dir(path string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(path)+".git")
+}
+
+// Commit adds a commit with message on branch of the repository at path
+// and returns its SHA
+func (s *Server) Commit(path, branch, message string) (string, error) {
+	work, err := os.MkdirTemp("", "providertest-work-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(work)
+
+	bare := s.dir(path)
+	steps := [][]string{
+		{"init", "--quiet", work},
+		{"-C", work, "fetch", "--quiet", bare, "+refs/heads/*:refs/remotes/origin/*"},
+	}
+	for _, args := range steps {
+		if err := runGit("", args...); err != nil {
+			return "", err
+		}
+	}
+	// Continue the branch if it exists
+	if runGit("", "-C", work, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch) == nil {
+		if err := runGit("", "-C", work, "checkout", "--quiet", "-B", branch, "refs/remotes/origin/"+branch); err != nil {
+			return "", err
+		}
+	} else if err := runGit("", "-C", work, "checkout", "--quiet", "--orphan", branch); err != nil {
+		return "", err
+	}
+	if err := runGit("", "-C", work, "-c", "user.name=providertest", "-c", "user.email=providertest@localhost",
+		"commit", "--quiet", "--allow-empty", "-m", message); err != nil {
+		return "", err
+	}
+	if err := runGit("", "-C", work, "push", "--quiet", bare, "HEAD:refs/heads/"+branch); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("git", "-C", bare, "rev-parse", "refs/heads/"+branch).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Refs returns the branches and tags of the repository at path
+func (s *Server) Refs(path string) (map[string]string, error) {
+	out, err := exec.Command("git", "-C", s.dir(path), "for-each-ref", "--format=%(objectname) %(refname)", "refs/heads", "refs/tags").Output()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if sha, ref, ok := strings.Cut(line, " "); ok {
+			refs[ref] = sha
+		}
+	}
+	return refs, nil
+}
+
+// SendPush delivers a push webhook for ref of the repository at path to
+// every active hook, signed the way the imitated forge does
+func (s *Server) SendPush(ctx context.Context, path, ref, after string) error {
+	s.mu.Lock()
+	repo, ok := s.repos[path]
+	var hooks []Hook
+	if ok {
+		for _, h := range repo.Hooks {
+			if h.Active {
+				hooks = append(hooks, *h)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("repository %s does not exist", path)
+	}
+
+	cloneURL := s.RepoURL(path)
+	var payload any
+	if s.Kind == GitLab {
+		payload = map[string]any{"ref": ref, "after": after,
+			"project": map[string]string{"git_http_url": cloneURL, "web_url": s.URL + "/" + path}}
+	} else {
+		payload = map[string]any{"ref": ref, "after": after,
+			"repository": map[string]string{"clone_url": cloneURL, "html_url": s.URL + "/" + path}}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		signature := hex.EncodeToString(mac.Sum(nil))
+		switch s.Kind {
+		case GitHub:
+			req.Header.Set("X-GitHub-Event", "push")
+			req.Header.Set("X-Hub-Signature-256", "sha256="+signature)
+		case Gitea:
+			req.Header.Set("X-Gitea-Event", "push")
+			req.Header.Set("X-Gitea-Signature", signature)
+		case GitLab:
+			req.Header.Set("X-Gitlab-Event", "Push Hook")
+			req.Header.Set("X-Gitlab-Token", h.Secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("webhook %d: %w", h.ID, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook %d: unexpected status %d", h.ID, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+	if strings.HasSuffix(p, "/info/refs") || strings.HasSuffix(p, "/git-upload-pack") || strings.HasSuffix(p, "/git-receive-pack") {
+		s.serveGit(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+	s.serveAPI(w, r)
+}
+
+// serveGit hands smart HTTP requests to git http-backend
+func (s *Server) serveGit(w http.ResponseWriter, r *http.Request) {
+	if s.Token != "" {
+		user, pass, _ := r.BasicAuth()
+		if user != s.Token && pass != s.Token {
+			w.Header().Set("WWW-Authenticate", `Basic realm="providertest"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+	}
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + s.Root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			// Pushes are unauthenticated as far as http-backend knows
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.receivepack",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	}
+	h.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return true
+	}
+	var got string
+	switch s.Kind {
+	case GitHub:
+		got = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	case Gitea:
+		got = strings.TrimPrefix(r.Header.Get("Authorization"), "token ")
+	case GitLab:
+		got = r.Header.Get("PRIVATE-TOKEN")
+	}
+	return got == s.Token
+}
+
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unescape decodes a path segment, as GitLab project IDs are URL-encoded
+func unescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
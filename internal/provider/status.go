@@ -0,0 +1,20 @@
+package provider
+
+import (
+	"time"
+
+	"gitsync/internal/models"
+)
+
+// ExecutionOutcome maps the error returned by a replication run to the
+// execution status to record, so rate limiting is reported as throttled
+// rather than as a generic failure
+func ExecutionOutcome(err error) (models.ExecutionStatus, *time.Time) {
+	if err == nil {
+		return models.ExecutionSuccess, nil
+	}
+	if retryAt, ok := RetryAt(err); ok {
+		return models.ExecutionThrottled, &retryAt
+	}
+	return models.ExecutionFailed, nil
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gitsync/internal/models"
+)
+
+func TestExecutionOutcome(t *testing.T) {
+	reset := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	throttled := &ThrottledError{Host: "api.github.com", RetryAt: reset}
+	tests := []struct {
+		name    string
+		err     error
+		status  models.ExecutionStatus
+		retryAt *time.Time
+	}{
+		{name: "success", status: models.ExecutionSuccess},
+		{name: "failure", err: errors.New("remote rejected"), status: models.ExecutionFailed},
+		{name: "throttled", err: throttled, status: models.ExecutionThrottled, retryAt: &reset},
+		{name: "wrapped throttled", err: fmt.Errorf("failed to create target repository: %w", throttled), status: models.ExecutionThrottled, retryAt: &reset},
+		// Only a ThrottledError says when to retry
+		{name: "bare throttled sentinel", err: ErrThrottled, status: models.ExecutionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, retryAt := ExecutionOutcome(tt.err)
+			if status != tt.status {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+			switch {
+			case tt.retryAt == nil && retryAt != nil:
+				t.Errorf("retry at %s, want none", retryAt)
+			case tt.retryAt != nil && (retryAt == nil || !retryAt.Equal(*tt.retryAt)):
+				t.Errorf("retry at %v, want %s", retryAt, tt.retryAt)
+			}
+		})
+	}
+}
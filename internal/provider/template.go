@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// placeholder matches a {{name}} placeholder of a URL template
+var placeholder = regexp.MustCompile(`\{\{\s*([a-z_]*)\s*\}\}`)
+
+// Placeholders are the names a URL template may use. For a source at
+// https://gitlab.com/group/sub/repo.git, org is group/sub, name is repo,
+// path is group/sub/repo and host is gitlab.com.
+var Placeholders = []string{"org", "name", "path", "host"}
+
+// IsTemplate reports whether raw holds placeholders
+func IsTemplate(raw string) bool {
+	return strings.Contains(raw, "{{")
+}
+
+// ExpandTemplate replaces the placeholders of template with the parts of
+// the repository at sourceURL. Templates without placeholders are returned
+// unchanged.
+func ExpandTemplate(template, sourceURL string) (string, error) {
+	if !IsTemplate(template) {
+		return template, nil
+	}
+	src, err := ParseRepoURL(sourceURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid source url: %w", err)
+	}
+	values := map[string]string{
+		"org":  path.Dir(src.Path),
+		"name": path.Base(src.Path),
+		"path": src.Path,
+		"host": src.Host,
+	}
+	var unknown []string
+	out := placeholder.ReplaceAllStringFunc(template, func(m string) string {
+		name := placeholder.FindStringSubmatch(m)[1]
+		if !slices.Contains(Placeholders, name) {
+			unknown = append(unknown, m)
+		}
+		return values[name]
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s. allowed: {{%s}}", unknown[0], strings.Join(Placeholders, "}}, {{"))
+	}
+	if strings.Contains(out, "{{") || strings.Contains(out, "}}") {
+		return "", fmt.Errorf("unterminated placeholder in %q", template)
+	}
+	return out, nil
+}
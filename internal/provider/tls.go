@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// InstanceTLS sets how the certificates of self-hosted provider instances
+// are verified, for instances signed by an internal CA. A host with a port
+// applies to that port only, and one without to the default port.
+type InstanceTLS struct {
+	// CAFiles maps hosts to PEM bundles trusted for them on top of the
+	// system roots
+	CAFiles map[string]string
+	// Insecure lists hosts whose certificates are not verified at all,
+	// which lets anyone on the network path impersonate them
+	Insecure []string
+}
+
+// InstanceTLSFromEnv reads PROVIDER_CA_FILES, a comma separated list of
+// host=path pairs, and PROVIDER_TLS_INSECURE, a comma separated list of
+// hosts
+func InstanceTLSFromEnv() (InstanceTLS, error) {
+	t := InstanceTLS{CAFiles: make(map[string]string)}
+	for _, pair := range strings.Split(os.Getenv("PROVIDER_CA_FILES"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		host, path, ok := strings.Cut(pair, "=")
+		host, path = strings.ToLower(strings.TrimSpace(host)), strings.TrimSpace(path)
+		if !ok || host == "" || path == "" {
+			return t, fmt.Errorf("PROVIDER_CA_FILES entries must be host=path, got %q", pair)
+		}
+		t.CAFiles[host] = path
+	}
+	for _, host := range strings.Split(os.Getenv("PROVIDER_TLS_INSECURE"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			t.Insecure = append(t.Insecure, host)
+		}
+	}
+	return t, t.Validate()
+}
+
+// Validate checks that no host is both given a CA and left unverified
+func (t InstanceTLS) Validate() error {
+	for _, host := range t.Insecure {
+		if _, ok := t.CAFiles[host]; ok {
+			return fmt.Errorf("host %s has a CA file and is also set to skip verification", host)
+		}
+	}
+	return nil
+}
+
+// Client returns c with a transport applying t to requests to its hosts;
+// c itself is returned when t is empty. Hosts whose certificates are not
+// verified are logged loudly.
+func (t InstanceTLS) Client(c *http.Client) (*http.Client, error) {
+	if len(t.CAFiles) == 0 && len(t.Insecure) == 0 {
+		return c, nil
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	base, ok := c.Transport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+
+	hosts := make(map[string]http.RoundTripper)
+	for host, path := range t.CAFiles {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file of %s: %w", host, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file of %s holds no PEM certificates", host)
+		}
+		hosts[host] = withTLS(base, &tls.Config{RootCAs: pool})
+	}
+	for _, host := range t.Insecure {
+		log.Printf("WARN: TLS certificate verification is DISABLED for %s; its API and git traffic, credentials included, can be intercepted", host)
+		hosts[host] = withTLS(base, &tls.Config{InsecureSkipVerify: true})
+	}
+
+	out := *c
+	out.Transport = &hostTransport{base: base, hosts: hosts}
+	return &out, nil
+}
+
+func withTLS(base *http.Transport, cfg *tls.Config) *http.Transport {
+	t := base.Clone()
+	if base.TLSClientConfig != nil {
+		merged := base.TLSClientConfig.Clone()
+		merged.RootCAs, merged.InsecureSkipVerify = cfg.RootCAs, cfg.InsecureSkipVerify
+		cfg = merged
+	}
+	t.TLSClientConfig = cfg
+	return t
+}
+
+// hostTransport sends requests through the transport of their host,
+// falling back to base
+type hostTransport struct {
+	base  http.RoundTripper
+	hosts map[string]http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt, ok := t.hosts[strings.ToLower(req.URL.Host)]; ok {
+		return rt.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// GitConfig returns the git configuration applying t to HTTPS clones and
+// pushes, for git.Runner.Config. It has no effect when GIT_SSL_CAINFO or
+// GIT_SSL_NO_VERIFY is set in the environment.
+func (t InstanceTLS) GitConfig() map[string]string {
+	config := make(map[string]string)
+	for host, path := range t.CAFiles {
+		config["http.https://"+host+"/.sslCAInfo"] = path
+	}
+	for _, host := range t.Insecure {
+		config["http.https://"+host+"/.sslVerify"] = "false"
+	}
+	return config
+}
@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RepoURL is a parsed git clone URL
+type RepoURL struct {
+	Scheme string
+	User   string
+	Host   string
+	Port   string
+	// Path is the repository path without leading slash or trailing .git
+	Path string
+}
+
+// scpURL matches scp-style SSH syntax such as git@github.com:org/repo.git
+var scpURL = regexp.MustCompile(`^(?:([^@/:]+)@)?([^@/:]+):([^/].*)$`)
+
+// Schemes lists every URL scheme git can clone over. Which of them a
+// deployment accepts is decided by the validation package.
+var Schemes = []string{"https", "http", "ssh", "git"}
+
+// ParseRepoURL parses clone URLs in any of Schemes as well as scp-style SSH
+// syntax. Embedded passwords and tokens are rejected.
+func ParseRepoURL(raw string) (*RepoURL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, errors.New("url is empty")
+	}
+
+	if !strings.Contains(raw, "://") {
+		m := scpURL.FindStringSubmatch(raw)
+		if m == nil {
+			return nil, errors.New("url must include a scheme such as https:// or ssh://")
+		}
+		path, err := cleanPath(m[3])
+		if err != nil {
+			return nil, err
+		}
+		return &RepoURL{Scheme: "ssh", User: m[1], Host: strings.ToLower(m[2]), Path: path}, nil
+	}
+
+	// The parse error quotes the URL, which may hold a secret
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.New("malformed url")
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !slices.Contains(Schemes, scheme) {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("url has no host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("url must not contain a query or fragment")
+	}
+
+	parsed := &RepoURL{Scheme: scheme, Host: strings.ToLower(u.Hostname()), Port: u.Port()}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword || scheme != "ssh" {
+			return nil, errors.New("url must not contain embedded credentials")
+		}
+		parsed.User = u.User.Username()
+	}
+
+	if parsed.Path, err = cleanPath(u.Path); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// SplitCredentials removes credentials embedded in a clone URL, returning
+// the URL without them and the secret: the password, or for http(s) URLs a
+// token given as user name. SSH user names are not credentials and are
+// kept. URLs that do not parse are returned unchanged.
+func SplitCredentials(raw string) (string, string) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		return raw, ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw, ""
+	}
+	secret, hasPassword := u.User.Password()
+	switch {
+	case hasPassword:
+		if strings.ToLower(u.Scheme) == "ssh" {
+			u.User = url.User(u.User.Username())
+		} else {
+			u.User = nil
+		}
+	case strings.ToLower(u.Scheme) != "ssh":
+		secret = u.User.Username()
+		u.User = nil
+	default:
+		return raw, ""
+	}
+	return u.String(), secret
+}
+
+func cleanPath(p string) (string, error) {
+	p = strings.Trim(p, "/")
+	p = strings.TrimSuffix(p, ".git")
+	if p == "" {
+		return "", errors.New("url has no repository path")
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", fmt.Errorf("invalid repository path %q", p)
+		}
+	}
+	return p, nil
+}
+
+// String renders the URL in canonical form
+func (u *RepoURL) String() string {
+	host := u.Host
+	if u.Port != "" {
+		host += ":" + u.Port
+	}
+	if u.Scheme == "ssh" && u.User != "" {
+		host = u.User + "@" + host
+	}
+	return u.Scheme + "://" + host + "/" + u.Path
+}
+
+// Canonical renders the part of the URL that identifies the repository:
+// host and path. Clone URLs that differ only in scheme, user or port reach
+// the same repository.
+func (u *RepoURL) Canonical() string {
+	return u.Host + "/" + u.Path
+}
+
+// CanonicalURL parses raw and returns its canonical form, which
+// repositories are deduplicated by
+func CanonicalURL(raw string) (string, error) {
+	u, err := ParseRepoURL(raw)
+	if err != nil {
+		return "", err
+	}
+	return u.Canonical(), nil
+}
+
+// hostsFor returns the public hosts of a provider plus any self-hosted
+// instances configured via <PROVIDER>_HOSTS (comma separated)
+func hostsFor(p Provider) []string {
+	hosts := append([]string(nil), p.Hosts()...)
+	for _, h := range strings.Split(os.Getenv(strings.ToUpper(p.Name())+"_HOSTS"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// NormalizeURL validates raw as a clone URL for the given provider and
+// returns its canonical form: scp syntax rewritten to ssh://, lowercase
+// host and no trailing .git
+func NormalizeURL(providerName, raw string) (string, error) {
+	p, err := Lookup(providerName)
+	if err != nil {
+		return "", err
+	}
+	u, err := ParseRepoURL(raw)
+	if err != nil {
+		return "", err
+	}
+
+	// A host that belongs to another provider is always a mistake; unknown
+	// hosts are accepted as self-hosted instances
+	for _, name := range Names() {
+		if name == providerName {
+			continue
+		}
+		other, _ := Get(name)
+		for _, h := range hostsFor(other) {
+			if u.Host == h {
+				return "", fmt.Errorf("host %s belongs to provider %s", u.Host, name)
+			}
+		}
+	}
+
+	if err := p.ValidatePath(u.Path); err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,247 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+
+	"github.com/lib/pq"
+)
+
+// AnomalyConfig sets which changes of a source are anomalous
+type AnomalyConfig struct {
+	// SizeGrowth flags fetches growing a mirror by this factor or more; 0
+	// disables the check
+	SizeGrowth float64
+	// MinSize is the size in bytes below which grown mirrors are not
+	// flagged, so small repositories may grow freely
+	MinSize int64
+	// MaxRefDeletions flags fetches deleting more refs; 0 disables the
+	// check
+	MaxRefDeletions int
+	// RewriteRefs are the ref patterns flagged when their history is
+	// rewritten; empty disables the check
+	RewriteRefs []string
+}
+
+// AnomalyConfigFromEnv enables detection when ANOMALY_DETECTION is true,
+// flagging a 10x growth of mirrors of at least 10 MB, more than 10 deleted
+// refs and rewrites of main, master and tags by default.
+// ANOMALY_SIZE_GROWTH, ANOMALY_MIN_SIZE_MB, ANOMALY_MAX_REF_DELETIONS and
+// ANOMALY_REWRITE_REFS (comma separated ref patterns) change these; 0 or
+// an empty list disables a check.
+func AnomalyConfigFromEnv() (AnomalyConfig, error) {
+	if os.Getenv("ANOMALY_DETECTION") != "true" {
+		return AnomalyConfig{}, nil
+	}
+	cfg := AnomalyConfig{
+		SizeGrowth:      10,
+		MinSize:         10 << 20,
+		MaxRefDeletions: 10,
+		RewriteRefs:     []string{"refs/heads/main", "refs/heads/master", "refs/tags/*"},
+	}
+	if raw := os.Getenv("ANOMALY_SIZE_GROWTH"); raw != "" {
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || (f != 0 && f <= 1) {
+			return cfg, fmt.Errorf("ANOMALY_SIZE_GROWTH must be 0 or a factor above 1")
+		}
+		cfg.SizeGrowth = f
+	}
+	if raw := os.Getenv("ANOMALY_MIN_SIZE_MB"); raw != "" {
+		mb, err := strconv.ParseFloat(raw, 64)
+		if err != nil || mb < 0 {
+			return cfg, fmt.Errorf("ANOMALY_MIN_SIZE_MB must be a non-negative number")
+		}
+		cfg.MinSize = int64(mb * (1 << 20))
+	}
+	if raw := os.Getenv("ANOMALY_MAX_REF_DELETIONS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("ANOMALY_MAX_REF_DELETIONS must be a non-negative number")
+		}
+		cfg.MaxRefDeletions = n
+	}
+	if raw, ok := os.LookupEnv("ANOMALY_REWRITE_REFS"); ok {
+		cfg.RewriteRefs = nil
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				cfg.RewriteRefs = append(cfg.RewriteRefs, p)
+			}
+		}
+		if err := ValidateRefPatterns(cfg.RewriteRefs); err != nil {
+			return cfg, fmt.Errorf("invalid ANOMALY_REWRITE_REFS: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// Enabled reports whether any check is enabled
+func (c AnomalyConfig) Enabled() bool {
+	return c.SizeGrowth > 0 || c.MaxRefDeletions > 0 || len(c.RewriteRefs) > 0
+}
+
+// AnomalyDetector compares what a fetch changed in a mirror against
+// Config, records the anomalies it finds and alerts on them. Syncs of a
+// repository with an anomaly that was not acknowledged fail without
+// pushing.
+type AnomalyDetector struct {
+	DB       *database.DB
+	Notifier *notify.Dispatcher
+	Config   AnomalyConfig
+	Clock    clock.Clock
+	IDs      ids.Generator
+}
+
+// NewAnomalyDetector creates an AnomalyDetector
+func NewAnomalyDetector(db *database.DB, notifier *notify.Dispatcher, cfg AnomalyConfig, clk clock.Clock, gen ids.Generator) *AnomalyDetector {
+	return &AnomalyDetector{DB: db, Notifier: notifier, Config: cfg, Clock: clk, IDs: gen}
+}
+
+// AnomalyError is returned for syncs of a repository while an anomaly of
+// it awaits acknowledgment
+type AnomalyError struct {
+	Anomaly models.Anomaly
+}
+
+func (e *AnomalyError) Error() string {
+	return fmt.Sprintf("sync paused: anomaly %s (%s) of the source awaits acknowledgment",
+		e.Anomaly.ID, strings.Join(e.Anomaly.Kinds, ", "))
+}
+
+// Open returns an AnomalyError for the oldest anomaly of the repository
+// with the given ID that was not acknowledged, or nil
+func (d *AnomalyDetector) Open(ctx context.Context, repoID string) (*AnomalyError, error) {
+	var a models.Anomaly
+	err := d.DB.QueryRowContext(ctx,
+		`SELECT id, repository_id, kinds, created_at FROM anomalies
+		 WHERE repository_id = $1 AND NOT acknowledged ORDER BY created_at LIMIT 1`, repoID).
+		Scan(&a.ID, &a.RepositoryID, pq.Array(&a.Kinds), &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch anomalies: %w", err)
+	}
+	return &AnomalyError{Anomaly: a}, nil
+}
+
+// mirrorState is what a mirror held before a fetch
+type mirrorState struct {
+	refs map[string]string
+	size int64
+}
+
+// mirrorState records the branches and tags of the mirror at dir and the
+// size of sizeDir, where its objects are kept, ahead of a fetch. It
+// returns nil when detection is off or the mirror is missing, as the
+// first fetch has nothing to compare with.
+func (p *Pusher) mirrorState(ctx context.Context, dir, sizeDir string) *mirrorState {
+	if p.Anomalies == nil {
+		return nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	refs, err := p.localRefs(ctx, dir, "refs/heads", "refs/tags")
+	if err != nil {
+		log.Printf("WARN: failed to list refs of mirror %s: %v", dir, err)
+		return nil
+	}
+	return &mirrorState{refs: refs, size: dirSize(sizeDir)}
+}
+
+// checkFetch compares the mirror of repo at dir with its state before a
+// fetch and records the changes Anomalies flags, returning an
+// AnomalyError for them
+func (p *Pusher) checkFetch(ctx context.Context, repo models.Repository, dir, sizeDir string, before *mirrorState) error {
+	if before == nil {
+		return nil
+	}
+	cfg := p.Anomalies.Config
+	refs, err := p.localRefs(ctx, dir, "refs/heads", "refs/tags")
+	if err != nil {
+		return err
+	}
+	a := models.Anomaly{RepositoryID: repo.ID, SizeBefore: before.size, SizeAfter: dirSize(sizeDir), Deleted: []string{}, Rewritten: []string{}}
+
+	if cfg.SizeGrowth > 0 && before.size > 0 && a.SizeAfter >= cfg.MinSize &&
+		float64(a.SizeAfter) >= cfg.SizeGrowth*float64(before.size) {
+		a.Kinds = append(a.Kinds, models.AnomalySizeGrowth)
+	}
+	for _, ref := range slices.Sorted(maps.Keys(before.refs)) {
+		old, sha := before.refs[ref], refs[ref]
+		switch {
+		case sha == "":
+			a.Deleted = append(a.Deleted, ref)
+		case sha != old && MatchRef(cfg.RewriteRefs, ref):
+			descends := false
+			if !strings.HasPrefix(ref, "refs/tags/") {
+				if descends, err = p.descends(ctx, dir, old, sha); err != nil {
+					return err
+				}
+			}
+			if !descends {
+				a.Rewritten = append(a.Rewritten, ref)
+			}
+		}
+	}
+	if cfg.MaxRefDeletions > 0 && len(a.Deleted) > cfg.MaxRefDeletions {
+		a.Kinds = append(a.Kinds, models.AnomalyRefDeletions)
+	}
+	if len(a.Rewritten) > 0 {
+		a.Kinds = append(a.Kinds, models.AnomalyHistoryRewrite)
+	}
+	if len(a.Kinds) == 0 {
+		return nil
+	}
+	return p.Anomalies.record(ctx, repo, a)
+}
+
+// record stores a and alerts on it
+func (d *AnomalyDetector) record(ctx context.Context, repo models.Repository, a models.Anomaly) error {
+	ctx = context.WithoutCancel(ctx)
+	a.ID, a.CreatedAt = d.IDs.NewID(), d.Clock.Now()
+	if _, err := d.DB.ExecContext(ctx,
+		`INSERT INTO anomalies (id, repository_id, kinds, size_before, size_after, deleted, rewritten, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		a.ID, a.RepositoryID, pq.Array(a.Kinds), a.SizeBefore, a.SizeAfter, pq.Array(a.Deleted), pq.Array(a.Rewritten),
+		a.CreatedAt); err != nil {
+		return fmt.Errorf("failed to record anomaly: %w", err)
+	}
+	log.Printf("WARN: anomaly %s (%s) of repository %s; syncs paused until acknowledged",
+		a.ID, strings.Join(a.Kinds, ", "), repo.ID)
+
+	message := fmt.Sprintf("Syncs of %s are paused until the change is acknowledged:", repo.Name)
+	for _, kind := range a.Kinds {
+		switch kind {
+		case models.AnomalySizeGrowth:
+			message += fmt.Sprintf(" the mirror grew from %s to %s;", formatBytes(a.SizeBefore), formatBytes(a.SizeAfter))
+		case models.AnomalyRefDeletions:
+			message += fmt.Sprintf(" %d refs were deleted;", len(a.Deleted))
+		case models.AnomalyHistoryRewrite:
+			message += fmt.Sprintf(" the history of %s was rewritten;", strings.Join(a.Rewritten, ", "))
+		}
+	}
+	d.Notifier.Notify(notify.Event{
+		Type:           notify.EventAnomalyDetected,
+		Severity:       notify.SeverityCritical,
+		RepositoryID:   repo.ID,
+		RepositoryName: repo.Name,
+		Message:        strings.TrimSuffix(message, ";"),
+		Data:           map[string]any{"anomaly_id": a.ID, "kinds": strings.Join(a.Kinds, ", ")},
+	})
+	return &AnomalyError{Anomaly: a}
+}
@@ -0,0 +1,202 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// PushApprovers is the number of distinct operators who approve the force
+// pushes and ref deletions of a protected target
+const PushApprovers = 2
+
+// ApprovalError is returned when a push to a protected target would force
+// or delete refs without an approval of exactly those changes
+type ApprovalError struct {
+	// ID is the approval the push is held for, set by the syncer
+	ID          string
+	Forced      []string
+	Deleted     []string
+	Fingerprint string
+	// RejectedBy is set when the same changes were rejected before
+	RejectedBy string
+}
+
+func (e *ApprovalError) Error() string {
+	if e.RejectedBy != "" {
+		return fmt.Sprintf("push to protected target rejected by %s: %d forced updates and %d deletions",
+			e.RejectedBy, len(e.Forced), len(e.Deleted))
+	}
+	return fmt.Sprintf("push to protected target awaiting approval %s: %d forced updates and %d deletions",
+		e.ID, len(e.Forced), len(e.Deleted))
+}
+
+// destructive returns the changes refspecs would make to a target that
+// lose history: updates of refs last pushed as pushed to commits of source
+// not descending from them, and deletions. Tags never move forward, and
+// history rewritten by changed rules replaces every ref.
+func (p *Pusher) destructive(ctx context.Context, dir string, pushed, source map[string]string, refspecs []string, rewritten bool) (*ApprovalError, error) {
+	changes := &ApprovalError{}
+	h := sha256.New()
+	for _, spec := range refspecs {
+		if spec[0] == ':' {
+			changes.Deleted = append(changes.Deleted, spec[1:])
+			fmt.Fprintf(h, "delete %s %s\n", spec[1:], pushed[spec[1:]])
+			continue
+		}
+		ref, _, _ := strings.Cut(spec[1:], ":")
+		old := pushed[ref]
+		if old == "" {
+			continue
+		}
+		if !rewritten && !strings.HasPrefix(ref, "refs/tags/") {
+			descends, err := p.descends(ctx, dir, old, source[ref])
+			if err != nil {
+				return nil, err
+			}
+			if descends {
+				continue
+			}
+		}
+		changes.Forced = append(changes.Forced, ref)
+		fmt.Fprintf(h, "force %s %s %s\n", ref, old, source[ref])
+	}
+	if len(changes.Forced) == 0 && len(changes.Deleted) == 0 {
+		return nil, nil
+	}
+	changes.Fingerprint = hex.EncodeToString(h.Sum(nil))
+	return changes, nil
+}
+
+// descends reports whether commit descends from ancestor in the
+// repository at dir. A missing ancestor, as after a rewrite and gc, does
+// not.
+func (p *Pusher) descends(ctx context.Context, dir, ancestor, commit string) (bool, error) {
+	_, err := p.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"merge-base", "--is-ancestor", ancestor, commit}})
+	if err != nil && ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	return err == nil, nil
+}
+
+// approved returns the approved but not yet pushed changes of a protected
+// target, or an empty approval when there are none
+func (s *Syncer) approved(ctx context.Context, targetID string) (models.PushApproval, error) {
+	var a models.PushApproval
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT id, fingerprint FROM push_approvals WHERE target_id = $1 AND status = $2`,
+		targetID, models.PushApprovalApproved).Scan(&a.ID, &a.Fingerprint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, nil
+	}
+	if err != nil {
+		return a, fmt.Errorf("failed to fetch push approval: %w", err)
+	}
+	return a, nil
+}
+
+// holdForApproval records that the execution with the given ID was held
+// for the approval of held, and sets its ID. Open approvals of other
+// changes are superseded; the same changes share one approval across
+// syncs, and stay held once rejected.
+func (s *Syncer) holdForApproval(ctx context.Context, repoID, targetID, executionID string, held *ApprovalError) error {
+	ctx = context.WithoutCancel(ctx)
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record push approval: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := s.Clock.Now()
+	rows, err := tx.QueryContext(ctx,
+		`UPDATE push_approvals SET status = $3, decided_at = $4
+		 WHERE target_id = $1 AND fingerprint <> $2 AND status IN ($5, $6)
+		 RETURNING id`,
+		targetID, held.Fingerprint, models.PushApprovalSuperseded, now, models.PushApprovalPending, models.PushApprovalApproved)
+	if err != nil {
+		return fmt.Errorf("failed to supersede push approvals: %w", err)
+	}
+	var superseded []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan push approval: %w", err)
+		}
+		superseded = append(superseded, id)
+	}
+	rows.Close()
+
+	var status string
+	var rejectedBy *string
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, status, rejected_by FROM push_approvals
+		 WHERE target_id = $1 AND fingerprint = $2 AND status IN ($3, $4, $5)
+		 ORDER BY created_at DESC LIMIT 1`,
+		targetID, held.Fingerprint, models.PushApprovalPending, models.PushApprovalApproved, models.PushApprovalRejected).
+		Scan(&held.ID, &status, &rejectedBy)
+	created := errors.Is(err, sql.ErrNoRows)
+	switch {
+	case created:
+		held.ID = s.IDs.NewID()
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO push_approvals (id, repository_id, target_id, execution_id, status, forced, deleted, fingerprint, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			held.ID, repoID, targetID, executionID, models.PushApprovalPending, pq.Array(nonNil(held.Forced)),
+			pq.Array(nonNil(held.Deleted)), held.Fingerprint, now)
+	case err == nil && status == models.PushApprovalRejected && rejectedBy != nil:
+		held.RejectedBy = *rejectedBy
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record push approval: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record push approval: %w", err)
+	}
+
+	for _, id := range superseded {
+		audit.Record(ctx, s.DB, audit.System, "push_approval.supersede", "push_approval", id,
+			map[string]any{"repository_id": repoID, "target_id": targetID, "superseded_by": held.ID})
+	}
+	if created {
+		log.Printf("Push of repository %s to protected target %s held for approval %s", repoID, targetID, held.ID)
+		audit.Record(ctx, s.DB, audit.System, "push_approval.request", "push_approval", held.ID, map[string]any{
+			"repository_id": repoID, "target_id": targetID, "execution_id": executionID,
+			"forced": held.Forced, "deleted": held.Deleted,
+		})
+	}
+	return nil
+}
+
+// executeApproval records that the approved changes were pushed by the
+// execution with the given ID
+func (s *Syncer) executeApproval(ctx context.Context, repoID, targetID, executionID string, approval models.PushApproval) {
+	ctx = context.WithoutCancel(ctx)
+	if _, err := s.DB.ExecContext(ctx,
+		`UPDATE push_approvals SET status = $2, executed_at = $3 WHERE id = $1`,
+		approval.ID, models.PushApprovalExecuted, s.Clock.Now()); err != nil {
+		log.Printf("ERROR: failed to record execution of push approval %s: %v", approval.ID, err)
+		return
+	}
+	audit.Record(ctx, s.DB, audit.System, "push_approval.execute", "push_approval", approval.ID,
+		map[string]any{"repository_id": repoID, "target_id": targetID, "execution_id": executionID})
+}
+
+// nonNil returns s, or an empty slice for nil, which pq stores as NULL
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
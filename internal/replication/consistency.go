@@ -0,0 +1,112 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+)
+
+// TargetCheck is the outcome of comparing a target with its source
+type TargetCheck struct {
+	Target models.Target
+	Diff   *models.TargetDiff
+	// SampledRefs are the refs whose objects were fetched from the target,
+	// and FailedSamples those whose fetch failed or returned another object
+	SampledRefs   []string
+	FailedSamples []string
+	Err           error
+}
+
+// Check compares each approved target of repo with its source, as Diff
+// does, without pushing anything. With a positive sample it also fetches
+// the objects of up to that many refs of each target.
+func (s *Syncer) Check(ctx context.Context, repo models.Repository, sample int) ([]TargetCheck, error) {
+	targets, err := s.targets(ctx, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]TargetCheck, 0, len(targets))
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return checks, ctx.Err()
+		}
+		check := TargetCheck{Target: target, SampledRefs: []string{}, FailedSamples: []string{}}
+		diff, err := s.Diff(ctx, repo, target)
+		if err != nil {
+			check.Err = err
+			checks = append(checks, check)
+			continue
+		}
+		check.Diff = &diff
+		if sample > 0 {
+			check.SampledRefs, check.FailedSamples, check.Err = s.sampleObjects(ctx, target, sample)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// sampleObjects fetches up to n branches and tags of target, chosen at
+// random, into an empty repository at depth 1. The target has to send
+// every object of their trees, each hashed as it arrives, so a failed
+// fetch points at objects missing or corrupt on the target even when its
+// refs match the source.
+func (s *Syncer) sampleObjects(ctx context.Context, target models.Target, n int) ([]string, []string, error) {
+	sampled, failed := []string{}, []string{}
+	auth, err := s.TargetAuth(ctx, target)
+	if err != nil {
+		return sampled, failed, fmt.Errorf("failed to resolve target credential: %w", err)
+	}
+	remote, annotated, err := s.Pusher.Git.LsRemoteTags(ctx, target.RemoteURL, auth)
+	if err != nil {
+		return sampled, failed, fmt.Errorf("failed to list target refs: %w", err)
+	}
+	var refs []string
+	for ref := range remote {
+		if (strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, "refs/tags/")) && receives(target, ref, annotated[ref]) {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return sampled, failed, nil
+	}
+	slices.Sort(refs)
+	rand.Shuffle(len(refs), func(i, j int) { refs[i], refs[j] = refs[j], refs[i] })
+	refs = refs[:min(n, len(refs))]
+
+	dir, err := os.MkdirTemp("", "gitsync-sample-")
+	if err != nil {
+		return sampled, failed, err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := s.Pusher.Git.Run(ctx, git.Command{Args: []string{"init", "--quiet", "--bare", dir}}); err != nil {
+		return sampled, failed, err
+	}
+	for i, ref := range refs {
+		local := "refs/sample/" + strconv.Itoa(i)
+		_, err := s.Pusher.Git.Run(ctx, git.Command{Dir: dir, Auth: auth, Args: []string{
+			"fetch", "--quiet", "--no-tags", "--no-write-fetch-head", "--depth", "1", target.RemoteURL, "+" + ref + ":" + local,
+		}})
+		if ctx.Err() != nil {
+			return sampled, failed, ctx.Err()
+		}
+		sampled = append(sampled, ref)
+		if err != nil {
+			failed = append(failed, ref+": "+err.Error())
+			continue
+		}
+		out, err := s.Pusher.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"rev-parse", local}})
+		if got := strings.TrimSpace(string(out)); err != nil || got != remote[ref] {
+			failed = append(failed, fmt.Sprintf("%s: fetched %s, advertised %s", ref, got, remote[ref]))
+		}
+	}
+	slices.Sort(sampled)
+	return sampled, failed, nil
+}
@@ -0,0 +1,136 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+)
+
+// Diff compares the branches and tags of the source of repo, read through
+// its refreshed mirror, with those of target. The target's refs are fetched
+// into the mirror under a temporary namespace to count commits and removed
+// afterwards. Refs the target does not receive are left out on both sides,
+// and targets that rewrite history are compared with the rewritten refs.
+func (s *Syncer) Diff(ctx context.Context, repo models.Repository, target models.Target) (models.TargetDiff, error) {
+	diff := models.TargetDiff{TargetID: target.ID, Branches: []models.BranchDiff{},
+		MissingRefs: []string{}, ExtraRefs: []string{}, MovedTags: []string{}}
+
+	dir, err := s.Mirror(ctx, repo)
+	if err != nil {
+		return diff, err
+	}
+	targetAuth, err := s.TargetAuth(ctx, target)
+	if err != nil {
+		return diff, fmt.Errorf("failed to resolve target credential: %w", err)
+	}
+
+	source, err := s.Pusher.localRefs(ctx, dir, "refs/heads", "refs/tags")
+	if err != nil {
+		return diff, err
+	}
+	annotated, err := s.Pusher.annotatedTags(ctx, dir)
+	if err != nil {
+		return diff, err
+	}
+	source = targetRefs(source, annotated, target)
+	rules, err := s.Pusher.targetRules(ctx, target)
+	if err != nil {
+		return diff, err
+	}
+	if rules != nil {
+		if repo.CloneFilter != nil {
+			return diff, errRewriteFilter
+		}
+		if source, err = s.Pusher.rewrite(ctx, dir, target.ID, rules, source); err != nil {
+			return diff, err
+		}
+		dir = rewriteDir(dir, target.ID)
+	}
+
+	ns := "refs/gitsync-diff/" + target.ID
+	defer s.Pusher.deleteRefs(context.WithoutCancel(ctx), dir, ns)
+	if _, err := s.Pusher.Git.Run(ctx, git.Command{Dir: dir, Auth: targetAuth, Args: []string{
+		"fetch", "--quiet", "--no-tags", "--no-write-fetch-head", target.RemoteURL,
+		"+refs/heads/*:" + ns + "/heads/*", "+refs/tags/*:" + ns + "/tags/*",
+	}}); err != nil {
+		return diff, err
+	}
+
+	fetched, err := s.Pusher.localRefs(ctx, dir, ns)
+	if err != nil {
+		return diff, err
+	}
+	remote := make(map[string]string, len(fetched))
+	for ref, sha := range fetched {
+		// Whether a tag only the target holds is annotated is not checked
+		if ref = "refs/" + strings.TrimPrefix(ref, ns+"/"); receives(target, ref, true) {
+			remote[ref] = sha
+		}
+	}
+
+	for ref, sha := range source {
+		targetSHA, ok := remote[ref]
+		switch {
+		case !ok:
+			diff.MissingRefs = append(diff.MissingRefs, ref)
+		case targetSHA == sha:
+		case strings.HasPrefix(ref, "refs/tags/"):
+			diff.MovedTags = append(diff.MovedTags, ref)
+		default:
+			behind, ahead, err := s.Pusher.aheadBehind(ctx, dir, sha, targetSHA)
+			if err != nil {
+				return diff, err
+			}
+			diff.Branches = append(diff.Branches, models.BranchDiff{
+				Ref: ref, SourceSHA: sha, TargetSHA: targetSHA, Behind: behind, Ahead: ahead,
+			})
+		}
+	}
+	for ref := range remote {
+		if _, ok := source[ref]; !ok {
+			diff.ExtraRefs = append(diff.ExtraRefs, ref)
+		}
+	}
+
+	slices.SortFunc(diff.Branches, func(a, b models.BranchDiff) int { return strings.Compare(a.Ref, b.Ref) })
+	slices.Sort(diff.MissingRefs)
+	slices.Sort(diff.ExtraRefs)
+	slices.Sort(diff.MovedTags)
+	diff.InSync = len(diff.Branches)+len(diff.MissingRefs)+len(diff.ExtraRefs)+len(diff.MovedTags) == 0
+	diff.ComparedAt = s.Clock.Now()
+	return diff, nil
+}
+
+// aheadBehind counts the commits reachable from source but not target, and
+// the other way round
+func (p *Pusher) aheadBehind(ctx context.Context, dir, source, target string) (int, int, error) {
+	out, err := p.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"rev-list", "--left-right", "--count", source + "..." + target}})
+	if err != nil {
+		return 0, 0, err
+	}
+	left, right, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	behind, err1 := strconv.Atoi(left)
+	ahead, err2 := strconv.Atoi(right)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q", out)
+	}
+	return behind, ahead, nil
+}
+
+// deleteRefs removes the refs below prefix from the repository at dir
+func (p *Pusher) deleteRefs(ctx context.Context, dir, prefix string) {
+	refs, err := p.localRefs(ctx, dir, prefix)
+	if err != nil || len(refs) == 0 {
+		return
+	}
+	var stdin strings.Builder
+	for ref := range refs {
+		stdin.WriteString("delete " + ref + "\n")
+	}
+	p.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"update-ref", "--stdin"}, Stdin: strings.NewReader(stdin.String())})
+}
@@ -0,0 +1,256 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+	"gitsync/internal/provider/providertest"
+)
+
+// stubDriver is a database/sql driver that stands in for PostgreSQL in the
+// end-to-end tests. Writes succeed without effect and queries find
+// nothing, except the rewrite rules of a target, which it never has.
+type stubDriver struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error) { return stubConn{}, nil }
+
+type stubConn struct{}
+
+func (stubConn) Prepare(query string) (driver.Stmt, error) { return stubStmt{query: query}, nil }
+func (stubConn) Close() error                              { return nil }
+func (stubConn) Begin() (driver.Tx, error)                 { return stubTx{}, nil }
+
+type stubTx struct{}
+
+func (stubTx) Commit() error   { return nil }
+func (stubTx) Rollback() error { return nil }
+
+type stubStmt struct{ query string }
+
+func (stubStmt) Close() error  { return nil }
+func (stubStmt) NumInput() int { return -1 }
+
+func (stubStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+
+func (s stubStmt) Query([]driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "rewrite_pushed") {
+		return &stubRows{columns: []string{"rewrite_pushed"}, values: [][]driver.Value{{""}}}, nil
+	}
+	return &stubRows{}, nil
+}
+
+type stubRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var registerStub sync.Once
+
+// stubDB returns a database.DB backed by stubDriver
+func stubDB(t *testing.T) *database.DB {
+	registerStub.Do(func() { sql.Register("replicationtest", stubDriver{}) })
+	db, err := sql.Open("replicationtest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &database.DB{DB: db}
+}
+
+// forge starts a fake forge of kind, stopped when the test ends
+func forge(t *testing.T, kind string) *providertest.Server {
+	srv, err := providertest.NewServer(kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// e2e holds a repository on a fake GitHub replicated to a fake Gitea
+type e2e struct {
+	source, target *providertest.Server
+	pusher         *Pusher
+	repo           models.Repository
+	dest           models.Target
+}
+
+func newE2E(t *testing.T) *e2e {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	// The CA of each fake comes from its GitConfig, which the environment
+	// would override; t.Setenv restores it after the test
+	t.Setenv("GIT_SSL_CAINFO", "")
+	os.Unsetenv("GIT_SSL_CAINFO")
+	e := &e2e{source: forge(t, providertest.GitHub), target: forge(t, providertest.Gitea)}
+	if err := e.source.CreateRepo("org/app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.source.Commit("org/app", "main", "initial commit"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The target repository is created through the API, as syncs create
+	// missing ones
+	gitea, err := provider.Lookup(providertest.Gitea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := provider.NewClient(provider.ClientOptions{HTTPClient: e.target.HTTPClient()})
+	if err := gitea.CreateRepository(context.Background(), client, "token", e.target.ProviderURL("mirror/app"), true); err != nil {
+		t.Fatalf("CreateRepository failed: %v", err)
+	}
+
+	runner, err := git.NewRunner("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.Config = maps.Clone(e.source.GitConfig())
+	maps.Copy(runner.Config, e.target.GitConfig())
+	clk := clock.NewManual(time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC))
+	e.pusher = NewPusher(stubDB(t), runner, t.TempDir(), nil, 0, VerifyConnectivity, nil, nil, nil, nil, clk)
+
+	e.repo = models.Repository{
+		ID: "00000000-0000-0000-0000-000000000001", Name: "app",
+		SourceProvider: providertest.GitHub, SourceURL: e.source.RepoURL("org/app"),
+	}
+	e.dest = models.Target{
+		ID: "00000000-0000-0000-0000-000000000002", RepositoryID: e.repo.ID,
+		Provider: providertest.Gitea, RemoteURL: e.target.RepoURL("mirror/app"), Mode: models.TargetModeAll,
+	}
+	return e
+}
+
+// sync pushes the source refs to the target and checks they arrived
+func (e *e2e) sync(t *testing.T) Result {
+	t.Helper()
+	auth := &git.Auth{Username: "x-access-token", Password: "token"}
+	res, err := e.pusher.Push(context.Background(), e.repo, e.dest, auth, auth, "")
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	want, err := e.source.Refs("org/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.target.Refs("mirror/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("target refs = %v, want %v", got, want)
+	}
+	return res
+}
+
+func TestPushEndToEnd(t *testing.T) {
+	e := newE2E(t)
+	if _, err := e.source.Commit("org/app", "feature", "add feature"); err != nil {
+		t.Fatal(err)
+	}
+	res := e.sync(t)
+	if len(res.Updated) != 2 {
+		t.Errorf("updated refs = %v, want main and feature", res.Updated)
+	}
+}
+
+func TestWebhookEndToEnd(t *testing.T) {
+	e := newE2E(t)
+	e.sync(t)
+
+	// The receiver syncs on every verified push event, as the webhook
+	// handler queues one
+	const secret = "hook-secret"
+	github, err := provider.Lookup(providertest.GitHub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	synced := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event, err := github.ParseWebhook(r, secret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		auth := &git.Auth{Username: "x-access-token", Password: "token"}
+		if _, err := e.pusher.Push(r.Context(), e.repo, e.dest, auth, auth, ""); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		synced <- event.Ref
+	}))
+	defer receiver.Close()
+
+	client := provider.NewClient(provider.ClientOptions{HTTPClient: e.source.HTTPClient()})
+	hook, err := github.CreateHook(context.Background(), client, "token", e.source.ProviderURL("org/app"), receiver.URL, secret)
+	if err != nil {
+		t.Fatalf("CreateHook failed: %v", err)
+	}
+
+	sha, err := e.source.Commit("org/app", "main", "second commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.source.SendPush(context.Background(), "org/app", "refs/heads/main", sha); err != nil {
+		t.Fatalf("SendPush failed: %v", err)
+	}
+	select {
+	case ref := <-synced:
+		if ref != "refs/heads/main" {
+			t.Errorf("push event ref = %q, want refs/heads/main", ref)
+		}
+	default:
+		t.Fatal("the webhook delivery did not trigger a sync")
+	}
+	refs, err := e.target.Refs("mirror/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs["refs/heads/main"] != sha {
+		t.Errorf("target main = %s, want %s", refs["refs/heads/main"], sha)
+	}
+
+	// A delivery signed with another secret is refused and syncs nothing
+	if err := github.UpdateHook(context.Background(), client, "token", e.source.ProviderURL("org/app"), hook.ID, receiver.URL, "other"); err != nil {
+		t.Fatalf("UpdateHook failed: %v", err)
+	}
+	next, err := e.source.Commit("org/app", "main", "third commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.source.SendPush(context.Background(), "org/app", "refs/heads/main", next); err == nil {
+		t.Error("a delivery signed with another secret was accepted")
+	}
+	if refs, _ := e.target.Refs("mirror/app"); refs["refs/heads/main"] != sha {
+		t.Errorf("target main = %s after a refused delivery, want %s", refs["refs/heads/main"], sha)
+	}
+}
@@ -0,0 +1,73 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+)
+
+// reservedHeaders are set by git or the HTTP client and cannot be sent
+// per target
+var reservedHeaders = []string{
+	"Accept", "Connection", "Content-Encoding", "Content-Length", "Content-Type", "Git-Protocol", "Host", "Transfer-Encoding",
+}
+
+// ValidateHeaders checks the extra HTTP headers of a target
+func ValidateHeaders(headers map[string]string) error {
+	seen := make(map[string]bool, len(headers))
+	for name, value := range headers {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isTokenChar(r) }) >= 0 {
+			return fmt.Errorf("%q is not a valid header name", name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if slices.Contains(reservedHeaders, canonical) {
+			return fmt.Errorf("header %s cannot be set", canonical)
+		}
+		if seen[canonical] {
+			return fmt.Errorf("header %s is given more than once", canonical)
+		}
+		seen[canonical] = true
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("value of header %s must be a single line", canonical)
+		}
+		if strings.TrimSpace(value) == "" {
+			return errors.New("value of header " + canonical + " is empty")
+		}
+	}
+	return nil
+}
+
+// isTokenChar reports whether r may appear in a header name
+func isTokenChar(r rune) bool {
+	return r < 0x7f && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+}
+
+// TargetAuth returns the git credentials for target together with its
+// extra HTTP headers and deploy key
+func (s *Syncer) TargetAuth(ctx context.Context, target models.Target) (*git.Auth, error) {
+	auth, err := s.Auth(ctx, target.CredentialID, target.Provider)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.DeployKeys.PrivateKey(ctx, target)
+	if err != nil || (len(target.HTTPHeaders) == 0 && key == "") {
+		return auth, err
+	}
+	var a git.Auth
+	if auth != nil {
+		a = *auth
+	}
+	a.Headers = target.HTTPHeaders
+	// The deploy key of the target replaces the key of its credential
+	if key != "" {
+		a.SSHKey = key
+	}
+	return &a, nil
+}
@@ -0,0 +1,180 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+)
+
+// PoolDir is the directory below the cache directory that holds the shared
+// object pools
+const PoolDir = "pools"
+
+// Pools shares objects between the mirrors of repositories with the same
+// upstream. Each pool is a bare repository that fetches every member's
+// source under refs/members/<repository id>/; the member mirrors borrow
+// its objects through git alternates and only hold refs themselves.
+type Pools struct {
+	CacheDir string
+	Git      *git.Runner
+}
+
+// NewPools creates Pools below cacheDir
+func NewPools(cacheDir string, runner *git.Runner) *Pools {
+	return &Pools{CacheDir: cacheDir, Git: runner}
+}
+
+func (p *Pools) path(repo models.Repository) (string, error) {
+	key := repo.SourceURL
+	if repo.CachePool != nil {
+		key = *repo.CachePool
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Abs(filepath.Join(p.CacheDir, PoolDir, hex.EncodeToString(sum[:8])+".git"))
+}
+
+// Sync fetches the source of repo from sourceURL into its pool and then
+// updates the member mirror at dir from the pool, which transfers no
+// objects. The pool is chosen by the primary source URL, so failing over
+// to an alternate source keeps using it.
+func (p *Pools) Sync(ctx context.Context, repo models.Repository, sourceURL, dir string, auth *git.Auth) error {
+	pool, err := p.create(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	ns := "refs/members/" + repo.ID
+	if _, err := p.Git.Run(ctx, git.Command{
+		Dir:    pool,
+		Args:   []string{"fetch", "--prune", "--no-tags", "--quiet", sourceURL, "+refs/heads/*:" + ns + "/heads/*", "+refs/tags/*:" + ns + "/tags/*"},
+		Auth:   auth,
+		Config: TransferConfig(repo.Transfer),
+	}); err != nil {
+		return err
+	}
+
+	// Mirrors created before pooling have their own objects; replace them
+	alternates := filepath.Join(dir, "objects", "info", "alternates")
+	if _, err := os.Stat(alternates); err != nil {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to replace unpooled mirror: %w", err)
+		}
+		if _, err := p.Git.Run(ctx, git.Command{Args: []string{"init", "--bare", "--quiet", dir}}); err != nil {
+			return err
+		}
+		if err := os.WriteFile(alternates, []byte(filepath.Join(pool, "objects")+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to link mirror to pool: %w", err)
+		}
+	}
+
+	_, err = p.Git.Run(ctx, git.Command{
+		Dir:  dir,
+		Args: []string{"fetch", "--prune", "--no-tags", "--quiet", pool, "+" + ns + "/heads/*:refs/heads/*", "+" + ns + "/tags/*:refs/tags/*"},
+	})
+	return err
+}
+
+// Seed fetches the refs of repo from a bundle into its pool, so the next
+// Sync only fetches what changed since from the source
+func (p *Pools) Seed(ctx context.Context, repo models.Repository, bundle string) error {
+	pool, err := p.create(ctx, repo)
+	if err != nil {
+		return err
+	}
+	ns := "refs/members/" + repo.ID
+	_, err = p.Git.Run(ctx, git.Command{
+		Dir:  pool,
+		Args: []string{"fetch", "--no-tags", "--quiet", bundle, "+refs/heads/*:" + ns + "/heads/*", "+refs/tags/*:" + ns + "/tags/*"},
+	})
+	return err
+}
+
+// create returns the path of the pool of repo, creating it if missing
+func (p *Pools) create(ctx context.Context, repo models.Repository) (string, error) {
+	pool, err := p.path(repo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(pool); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(pool), 0o755); err != nil {
+			return "", fmt.Errorf("failed to create pool directory: %w", err)
+		}
+		if _, err := p.Git.Run(ctx, git.Command{Args: []string{"init", "--bare", "--quiet", pool}}); err != nil {
+			return "", err
+		}
+	}
+	return pool, nil
+}
+
+// Prune drops the refs of members whose mirror no longer exists and
+// removes pools without members. Objects that only those refs reached are
+// then garbage collected.
+func (p *Pools) Prune(ctx context.Context) error {
+	root := filepath.Join(p.CacheDir, PoolDir)
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read pool directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		pool := filepath.Join(root, entry.Name())
+		if err := p.prunePool(ctx, pool); err != nil {
+			log.Printf("WARN: failed to prune object pool %s: %v", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (p *Pools) prunePool(ctx context.Context, pool string) error {
+	out, err := p.Git.Run(ctx, git.Command{Dir: pool, Args: []string{"for-each-ref", "--format=%(refname)", "refs/members/"}})
+	if err != nil {
+		return err
+	}
+
+	var deletes strings.Builder
+	live := 0
+	members := make(map[string]bool)
+	for _, ref := range strings.Fields(string(out)) {
+		id, _, _ := strings.Cut(strings.TrimPrefix(ref, "refs/members/"), "/")
+		exists, seen := members[id]
+		if !seen {
+			_, err := os.Stat(filepath.Join(p.CacheDir, id))
+			exists = err == nil
+			members[id] = exists
+			if exists {
+				live++
+			}
+		}
+		if !exists {
+			fmt.Fprintf(&deletes, "delete %s\n", ref)
+		}
+	}
+
+	if live == 0 {
+		log.Printf("Removed object pool %s without members", filepath.Base(pool))
+		return os.RemoveAll(pool)
+	}
+	if deletes.Len() == 0 {
+		return nil
+	}
+	if _, err := p.Git.Run(ctx, git.Command{Dir: pool, Args: []string{"update-ref", "--stdin"}, Stdin: strings.NewReader(deletes.String())}); err != nil {
+		return err
+	}
+	// Keep recently written objects a member fetch may not have referenced yet
+	_, err = p.Git.Run(ctx, git.Command{Dir: pool, Args: []string{"gc", "--quiet", "--prune=1.hour.ago"}})
+	return err
+}
@@ -0,0 +1,533 @@
+// Package replication pushes source repositories to their replication
+// targets through a local mirror.
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+	"gitsync/internal/schedule"
+	"gitsync/internal/tracing"
+	"gitsync/internal/worker"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Pusher updates targets from mirrors kept at CacheDir/<repository id>. It
+// remembers the SHA last pushed for every ref of a target, so a sync whose
+// source did not change costs one ls-remote and no push at all.
+type Pusher struct {
+	DB       *database.DB
+	Git      *git.Runner
+	CacheDir string
+	// Pools shares objects between mirrors of full (unfiltered) clones
+	Pools *Pools
+	// Clock stamps pushed refs
+	Clock clock.Clock
+	// MaxSize aborts fetches that grow a mirror, or for pooled mirrors its
+	// pool, beyond this many bytes; 0 disables the cap
+	MaxSize int64
+	// Verify checks mirrors with git fsck after each fetch and targets
+	// against the pushed refs after each push; VerifyOff skips both
+	Verify string
+	// Secrets scans new commits for credentials before they are pushed;
+	// nil disables the scan
+	Secrets *SecretScanner
+	// Storage keeps copies of full mirrors that missing ones are restored
+	// from; partial clones are not kept
+	Storage CacheStorage
+	// Anomalies checks what each fetch changed and pauses syncs of
+	// repositories changed suspiciously; nil disables the check
+	Anomalies *AnomalyDetector
+	// Hosts bounds the pushes in progress to one host at once; nil leaves
+	// them unbounded
+	Hosts *worker.Limiter
+}
+
+// NewPusher creates a Pusher; a nil storage keeps mirrors on local disk
+// only
+func NewPusher(db *database.DB, runner *git.Runner, cacheDir string, pools *Pools, maxSize int64, verify string, secrets *SecretScanner, storage CacheStorage, anomalies *AnomalyDetector, hosts *worker.Limiter, clk clock.Clock) *Pusher {
+	if storage == nil {
+		storage = LocalStorage{}
+	}
+	return &Pusher{DB: db, Git: runner, CacheDir: cacheDir, Pools: pools, MaxSize: maxSize, Verify: verify, Secrets: secrets, Storage: storage, Anomalies: anomalies, Hosts: hosts, Clock: clk}
+}
+
+// Result describes what a push changed on the target
+type Result struct {
+	// Source is the source URL or alternate source URL the refs were read
+	// from
+	Source string
+	// Skipped is set when the source matched the last pushed state
+	Skipped bool
+	Updated []string
+	Deleted []string
+	// Approved is set when the push made the approved force pushes and ref
+	// deletions of a protected target
+	Approved bool
+	// Bytes is the size of the pack sent, as reported by git
+	Bytes int64
+}
+
+// Push brings target up to date with the source of repo. When the source
+// URL is unreachable the alternate source URLs are tried in order. A
+// protected target is only force pushed to or has refs deleted when the
+// changes match the fingerprint approved; others fail with an
+// ApprovalError.
+func (p *Pusher) Push(ctx context.Context, repo models.Repository, target models.Target, sourceAuth, targetAuth *git.Auth, approved string) (Result, error) {
+	var res Result
+	p = p.tuned(repo)
+
+	sourceURL, source, annotated, err := p.listSource(ctx, repo, sourceAuth)
+	if err != nil {
+		return res, err
+	}
+	res.Source = sourceURL
+	sourceAuth = forSource(sourceAuth, sourceURL)
+	if err := schedule.RecordSourceRefs(ctx, p.DB, repo.ID, refsHash(source)); err != nil {
+		log.Printf("WARN: %v", err)
+	}
+	source = targetRefs(source, annotated, target)
+	pushed, err := p.pushedRefs(ctx, target.ID)
+	if err != nil {
+		return res, err
+	}
+	rules, err := p.targetRules(ctx, target)
+	if err != nil {
+		return res, err
+	}
+	hash := rewriteHash(rules)
+	if rules != nil && repo.CloneFilter != nil {
+		return res, errRewriteFilter
+	}
+	pushedHash, err := p.pushedRewrite(ctx, target.ID)
+	if err != nil {
+		return res, err
+	}
+	current := pushed
+	if pushedHash != hash {
+		// The target holds history rewritten by other rules, so every ref
+		// is pushed again
+		current = make(map[string]string, len(pushed))
+		for ref := range pushed {
+			current[ref] = ""
+		}
+	}
+
+	refspecs := Refspecs(source, current)
+	if len(refspecs) == 0 {
+		res.Skipped = true
+		return res, nil
+	}
+
+	fetchCtx, span := tracing.Start(ctx, "fetch source")
+	dir, err := p.mirror(fetchCtx, repo, sourceURL, sourceAuth)
+	tracing.End(span, err)
+	if err != nil {
+		return res, err
+	}
+	if p.Verify != VerifyOff {
+		if err := p.fsck(ctx, dir); err != nil {
+			return res, err
+		}
+	}
+	if p.Secrets != nil {
+		if err := p.scanSecrets(ctx, repo.ID, dir, pushed, refspecs, scoped(sourceAuth, sourceURL)); err != nil {
+			return res, err
+		}
+	}
+	// The rewritten refs have the names of the source ones, so the same
+	// refspecs push them from the rewritten history
+	pushDir, pushedSHAs := dir, source
+	if rules != nil {
+		if pushedSHAs, err = p.rewrite(ctx, dir, target.ID, rules, source); err != nil {
+			return res, err
+		}
+		// Refs left without history are not pushed
+		for ref := range source {
+			if _, ok := pushedSHAs[ref]; !ok {
+				delete(source, ref)
+			}
+		}
+		if refspecs = Refspecs(source, current); len(refspecs) == 0 {
+			res.Skipped = true
+			return res, nil
+		}
+		pushDir = rewriteDir(dir, target.ID)
+	}
+	if target.Protected {
+		held, err := p.destructive(ctx, dir, pushed, source, refspecs, pushedHash != hash)
+		if err != nil {
+			return res, err
+		}
+		if held != nil && held.Fingerprint != approved {
+			return res, held
+		}
+		res.Approved = held != nil
+	}
+	// A partial mirror lazily fetches the objects the push needs from the
+	// source, so both remotes get their own URL-scoped credentials
+	var progress bytes.Buffer
+	cmd := git.Command{
+		Dir: pushDir, Args: append([]string{"push", "--porcelain", "--progress", target.RemoteURL}, refspecs...), Auth: targetAuth,
+		Stderr: &progress,
+	}
+	if repo.CloneFilter != nil {
+		cmd.Auth = scoped(targetAuth, target.RemoteURL)
+		cmd.Auths = []*git.Auth{scoped(sourceAuth, sourceURL)}
+	}
+	// The span includes the wait for a slot of the host
+	pushCtx, span := tracing.Start(ctx, "push target", attribute.Int("gitsync.refspecs", len(refspecs)))
+	release, err := p.Hosts.Acquire(pushCtx, remoteHost(target.RemoteURL))
+	if err == nil {
+		_, err = p.Git.Run(pushCtx, cmd)
+		release()
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return res, err
+	}
+	res.Bytes = packSize(progress.String())
+	// A target that does not match is left unrecorded, so the next sync
+	// pushes the refs again
+	if p.Verify != VerifyOff {
+		if err := p.verifyPushed(ctx, target, pushedSHAs, refspecs, targetAuth); err != nil {
+			return res, err
+		}
+	}
+
+	for _, spec := range refspecs {
+		if spec[0] == ':' {
+			res.Deleted = append(res.Deleted, spec[1:])
+		} else {
+			ref, _, _ := strings.Cut(spec[1:], ":")
+			res.Updated = append(res.Updated, ref)
+		}
+	}
+	if err := p.recordPushed(ctx, target.ID, source); err != nil {
+		return res, err
+	}
+	// Recorded last: until then the next sync pushes every ref again
+	if pushedHash != hash {
+		if hash == "" {
+			os.RemoveAll(rewriteDir(dir, target.ID))
+		}
+		return res, p.recordRewrite(ctx, target.ID, hash)
+	}
+	return res, nil
+}
+
+// Mirror refreshes the mirror of repo from the first of its sources that
+// answers and returns its path, verifying it when Verify is set
+func (p *Pusher) Mirror(ctx context.Context, repo models.Repository, sourceAuth *git.Auth) (string, error) {
+	p = p.tuned(repo)
+	sourceURL, _, _, err := p.listSource(ctx, repo, sourceAuth)
+	if err != nil {
+		return "", err
+	}
+	dir, err := p.mirror(ctx, repo, sourceURL, forSource(sourceAuth, sourceURL))
+	if err != nil {
+		return "", err
+	}
+	if p.Verify != VerifyOff {
+		if err := p.fsck(ctx, dir); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// tuned returns p running git with the transfer overrides of repo, which
+// apply on top of the global settings of the runner
+func (p *Pusher) tuned(repo models.Repository) *Pusher {
+	config := TransferConfig(repo.Transfer)
+	if len(config) == 0 {
+		return p
+	}
+	q := *p
+	q.Git = p.Git.With(config)
+	return &q
+}
+
+// Sources returns the URLs the source of repo can be read from, in the
+// order they are tried
+func Sources(repo models.Repository) []string {
+	return append([]string{repo.SourceURL}, repo.AlternateSourceURLs...)
+}
+
+// listSource lists the refs of the first source of repo that answers and
+// returns its URL with them and its annotated tags
+func (p *Pusher) listSource(ctx context.Context, repo models.Repository, auth *git.Auth) (string, map[string]string, map[string]bool, error) {
+	var errs []error
+	for _, url := range Sources(repo) {
+		refs, annotated, err := p.Git.LsRemoteTags(ctx, url, forSource(auth, url))
+		if err == nil {
+			if len(errs) > 0 {
+				log.Printf("WARN: repository %s failed over to source %s", repo.ID, url)
+			}
+			return url, refs, annotated, nil
+		}
+		if ctx.Err() != nil {
+			return "", nil, nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return "", nil, nil, fmt.Errorf("failed to list source refs: %w", errors.Join(errs...))
+}
+
+// forSource moves credentials scoped to one source URL to url; all sources
+// of a repository share its credential
+func forSource(auth *git.Auth, url string) *git.Auth {
+	if auth == nil || auth.URL == "" {
+		return auth
+	}
+	return scoped(auth, url)
+}
+
+// refsHash fingerprints a set of refs independent of map order
+func refsHash(refs map[string]string) string {
+	names := slices.Sorted(maps.Keys(refs))
+	h := sha256.New()
+	for _, ref := range names {
+		fmt.Fprintf(h, "%s %s\n", refs[ref], ref)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Refspecs returns the push refspecs that turn pushed into source: forced
+// updates for new or moved refs and deletions for refs gone from the source
+func Refspecs(source, pushed map[string]string) []string {
+	var specs []string
+	for ref, sha := range source {
+		if pushed[ref] != sha {
+			specs = append(specs, "+"+ref+":"+ref)
+		}
+	}
+	for ref := range pushed {
+		if _, ok := source[ref]; !ok {
+			specs = append(specs, ":"+ref)
+		}
+	}
+	slices.Sort(specs)
+	return specs
+}
+
+// writingObjects matches the final progress line of the pack git push sends,
+// such as "Writing objects: 100% (3/3), 1.20 KiB | 1.20 MiB/s, done."
+var writingObjects = regexp.MustCompile(`Writing objects: 100% \(\d+/\d+\), ([\d.]+) (bytes|KiB|MiB|GiB)`)
+
+// packSize returns the size of the pack reported in the progress output of
+// git push, or 0 when no objects were sent
+func packSize(progress string) int64 {
+	m := writingObjects.FindAllStringSubmatch(progress, -1)
+	if len(m) == 0 {
+		return 0
+	}
+	last := m[len(m)-1]
+	n, err := strconv.ParseFloat(last[1], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(n * packUnits[last[2]])
+}
+
+var packUnits = map[string]float64{"bytes": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30}
+
+func scoped(auth *git.Auth, url string) *git.Auth {
+	if auth == nil {
+		return nil
+	}
+	a := *auth
+	a.URL = url
+	return &a
+}
+
+// remoteHost returns the host of a remote URL, or the URL itself when it
+// does not parse, so it is still limited on its own
+func remoteHost(remoteURL string) string {
+	u, err := provider.ParseRepoURL(remoteURL)
+	if err != nil {
+		return remoteURL
+	}
+	return u.Host
+}
+
+// RemoveMirror removes the mirror of the repository with the given ID; the
+// pool it shared, if any, is pruned by retention once unused
+func (p *Pusher) RemoveMirror(repoID string) error {
+	if err := os.RemoveAll(filepath.Join(p.CacheDir, repoID)); err != nil {
+		return fmt.Errorf("failed to remove mirror of repository %s: %w", repoID, err)
+	}
+	return nil
+}
+
+// mirror creates or refreshes the bare mirror of repo from sourceURL and
+// returns its path. A mirror cloned with a different filter than the
+// repository now asks for is cloned again. Missing full mirrors are
+// restored from the cache storage first, and saved to it once fetched.
+// Fetches into an existing mirror are checked for anomalies.
+func (p *Pusher) mirror(ctx context.Context, repo models.Repository, sourceURL string, auth *git.Auth) (string, error) {
+	dir := filepath.Join(p.CacheDir, repo.ID)
+	filter := ""
+	if repo.CloneFilter != nil {
+		filter = *repo.CloneFilter
+	} else if p.Pools != nil {
+		// Partial clones keep their own objects; everything else is pooled
+		pool, err := p.Pools.path(repo)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			p.restore(ctx, repo.ID, func(bundle string) error {
+				return p.Pools.Seed(ctx, repo, bundle)
+			})
+		}
+		before := p.mirrorState(ctx, dir, pool)
+		if err := limitSize(ctx, pool, p.MaxSize, func(ctx context.Context) error {
+			return p.Pools.Sync(ctx, repo, sourceURL, dir, auth)
+		}); err != nil {
+			return "", err
+		}
+		if err := p.checkFetch(ctx, repo, dir, pool, before); err != nil {
+			return "", err
+		}
+		p.save(ctx, repo.ID, dir)
+		p.touch(dir)
+		return dir, nil
+	}
+
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) && filter == "" {
+		p.restore(ctx, repo.ID, func(bundle string) error {
+			if err := os.MkdirAll(p.CacheDir, 0o755); err != nil {
+				return fmt.Errorf("failed to create cache directory: %w", err)
+			}
+			if _, err := p.Git.Run(ctx, git.Command{Args: []string{"clone", "--mirror", "--quiet", bundle, dir}}); err != nil {
+				os.RemoveAll(dir)
+				return err
+			}
+			return nil
+		})
+		_, err = os.Stat(dir)
+	}
+	if err == nil {
+		// Missing config exits with an error; that means no filter
+		current, _ := p.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"config", "remote.origin.partialclonefilter"}})
+		if strings.TrimSpace(string(current)) != filter {
+			if err := os.RemoveAll(dir); err != nil {
+				return "", fmt.Errorf("failed to remove mirror with outdated filter: %w", err)
+			}
+			err = os.ErrNotExist
+		}
+	}
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(p.CacheDir, 0o755); err != nil {
+			return "", fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		args := []string{"clone", "--mirror", "--quiet"}
+		if filter != "" {
+			args = append(args, "--filter="+filter)
+		}
+		if err := limitSize(ctx, dir, p.MaxSize, func(ctx context.Context) error {
+			_, err := p.Git.Run(ctx, git.Command{Args: append(args, sourceURL, dir), Auth: auth})
+			return err
+		}); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	} else {
+		// Point origin at the source that answered; a partial mirror also
+		// fetches missing objects from it
+		if _, err := p.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"remote", "set-url", "origin", sourceURL}}); err != nil {
+			return "", err
+		}
+		before := p.mirrorState(ctx, dir, dir)
+		if err := limitSize(ctx, dir, p.MaxSize, func(ctx context.Context) error {
+			_, err := p.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"remote", "update", "--prune"}, Auth: auth})
+			return err
+		}); err != nil {
+			return "", err
+		}
+		if err := p.checkFetch(ctx, repo, dir, dir, before); err != nil {
+			return "", err
+		}
+	}
+
+	if filter == "" {
+		p.save(ctx, repo.ID, dir)
+	}
+	p.touch(dir)
+	return dir, nil
+}
+
+// touch marks a mirror as used; retention prunes mirrors by modification time
+func (p *Pusher) touch(dir string) {
+	now := p.Clock.Now()
+	os.Chtimes(dir, now, now)
+}
+
+func (p *Pusher) pushedRefs(ctx context.Context, targetID string) (map[string]string, error) {
+	rows, err := p.DB.QueryContext(ctx, `SELECT ref, sha FROM target_refs WHERE target_id = $1`, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pushed refs: %w", err)
+	}
+	defer rows.Close()
+
+	refs := make(map[string]string)
+	for rows.Next() {
+		var ref, sha string
+		if err := rows.Scan(&ref, &sha); err != nil {
+			return nil, fmt.Errorf("failed to scan pushed ref: %w", err)
+		}
+		refs[ref] = sha
+	}
+	return refs, rows.Err()
+}
+
+// recordPushed replaces the pushed state of a target with refs
+func (p *Pusher) recordPushed(ctx context.Context, targetID string, refs map[string]string) error {
+	names := make([]string, 0, len(refs))
+	shas := make([]string, 0, len(refs))
+	for ref, sha := range refs {
+		names = append(names, ref)
+		shas = append(shas, sha)
+	}
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record pushed refs: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM target_refs WHERE target_id = $1 AND ref <> ALL($2)`, targetID, pq.Array(names)); err != nil {
+		return fmt.Errorf("failed to record pushed refs: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO target_refs (target_id, ref, sha, pushed_at)
+		 SELECT $1, ref, sha, $4 FROM unnest($2::text[], $3::text[]) AS r(ref, sha)
+		 ON CONFLICT (target_id, ref) DO UPDATE SET sha = EXCLUDED.sha, pushed_at = EXCLUDED.pushed_at
+		 WHERE target_refs.sha <> EXCLUDED.sha`,
+		targetID, pq.Array(names), pq.Array(shas), p.Clock.Now()); err != nil {
+		return fmt.Errorf("failed to record pushed refs: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,107 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"gitsync/internal/models"
+)
+
+// ValidateRefPatterns checks ref patterns. A pattern is a full ref name in
+// path.Match syntax, such as refs/heads/release-?, except that a trailing
+// /* after a literal prefix matches every ref below it, nested or not, as
+// in refs/heads/internal/*.
+func ValidateRefPatterns(patterns []string) error {
+	for i, p := range patterns {
+		if !strings.HasPrefix(p, "refs/") {
+			return fmt.Errorf("pattern %d must start with refs/", i)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("pattern %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// MatchRef reports whether ref matches any of patterns
+func MatchRef(patterns []string, ref string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok && !strings.ContainsAny(prefix, "*?[\\") {
+			if strings.HasPrefix(ref, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateMode checks the replication mode of a target with the tag
+// selection and subdirectory that go with it
+func ValidateMode(mode, tagPattern string, annotatedOnly bool, subdirectory string) error {
+	if mode != models.TargetModeTags && (tagPattern != "" || annotatedOnly) {
+		return errors.New("tag_pattern and annotated_tags_only need mode tags")
+	}
+	if mode != models.TargetModeSubdirectory && subdirectory != "" {
+		return errors.New("subdirectory needs mode subdirectory")
+	}
+	switch mode {
+	case models.TargetModeAll:
+	case models.TargetModeTags:
+		if tagPattern != "" {
+			if _, err := path.Match(tagPattern, ""); err != nil {
+				return fmt.Errorf("invalid tag_pattern: %w", err)
+			}
+		}
+	case models.TargetModeSubdirectory:
+		if subdirectory == "" {
+			return errors.New("mode subdirectory needs a subdirectory")
+		}
+		if strings.HasPrefix(subdirectory, "/") || path.Clean(subdirectory) != subdirectory || subdirectory == "." ||
+			strings.HasPrefix(subdirectory, "../") || subdirectory == ".." {
+			return errors.New("subdirectory must be a relative path without ., .. or a trailing slash")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q. allowed: all, tags, subdirectory", mode)
+	}
+	return nil
+}
+
+// receives reports whether ref is pushed to target; annotated tells
+// whether a tag ref is an annotated tag
+func receives(target models.Target, ref string, annotated bool) bool {
+	if MatchRef(target.ExcludeRefs, ref) {
+		return false
+	}
+	if target.Mode != models.TargetModeTags {
+		return true
+	}
+	tag, ok := strings.CutPrefix(ref, "refs/tags/")
+	if !ok {
+		return false
+	}
+	if target.TagPattern != nil {
+		if ok, _ := path.Match(*target.TagPattern, tag); !ok {
+			return false
+		}
+	}
+	return annotated || !target.AnnotatedTagsOnly
+}
+
+// targetRefs returns the refs of source that target receives. Refs left
+// out are treated as absent from the source, so refs pushed before they
+// were left out are deleted from the target.
+func targetRefs(source map[string]string, annotated map[string]bool, target models.Target) map[string]string {
+	kept := make(map[string]string, len(source))
+	for ref, sha := range source {
+		if receives(target, ref, annotated[ref]) {
+			kept[ref] = sha
+		}
+	}
+	return kept
+}
@@ -0,0 +1,100 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+)
+
+// PushBundle pushes the branches and tags of the git bundle at path to
+// target, forcing them over whatever the target holds; refs missing from
+// the bundle or not received by the target are left alone. For a
+// configured target the pushed state is replaced by the bundle, so the next
+// sync pushes what the source changed since. It returns the pushed refs.
+func (p *Pusher) PushBundle(ctx context.Context, path string, target models.Target, auth *git.Auth) ([]string, error) {
+	if target.ID != "" {
+		rules, err := p.targetRules(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		if rules != nil {
+			return nil, errors.New("restoring to a target that rewrites history is not supported")
+		}
+	}
+	dir, err := os.MkdirTemp("", "gitsync-restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := p.Git.Run(ctx, git.Command{Args: []string{"clone", "--mirror", "--quiet", path, dir}}); err != nil {
+		return nil, err
+	}
+	refs, err := p.localRefs(ctx, dir, "refs/heads", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	annotated, err := p.annotatedTags(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if refs = targetRefs(refs, annotated, target); len(refs) == 0 {
+		return nil, fmt.Errorf("bundle holds no branches or tags the target receives")
+	}
+
+	refspecs := Refspecs(refs, nil)
+	if _, err := p.Git.Run(ctx, git.Command{Dir: dir, Args: append([]string{"push", "--porcelain", target.RemoteURL}, refspecs...), Auth: auth}); err != nil {
+		return nil, err
+	}
+	if p.Verify != VerifyOff {
+		if err := p.verifyPushed(ctx, target, refs, refspecs, auth); err != nil {
+			return nil, err
+		}
+	}
+	if target.ID != "" {
+		if err := p.recordPushed(ctx, target.ID, refs); err != nil {
+			return nil, err
+		}
+	}
+	return slices.Sorted(maps.Keys(refs)), nil
+}
+
+// localRefs lists the refs of the repository at dir below the given
+// prefixes as ref name to SHA
+func (p *Pusher) localRefs(ctx context.Context, dir string, prefixes ...string) (map[string]string, error) {
+	out, err := p.Git.Run(ctx, git.Command{Dir: dir,
+		Args: append([]string{"for-each-ref", "--format=%(objectname) %(refname)"}, prefixes...)})
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if sha, ref, ok := strings.Cut(line, " "); ok {
+			refs[ref] = sha
+		}
+	}
+	return refs, nil
+}
+
+// annotatedTags lists the annotated tags of the repository at dir
+func (p *Pusher) annotatedTags(ctx context.Context, dir string) (map[string]bool, error) {
+	out, err := p.Git.Run(ctx, git.Command{Dir: dir,
+		Args: []string{"for-each-ref", "--format=%(objecttype) %(refname)", "refs/tags"}})
+	if err != nil {
+		return nil, err
+	}
+	annotated := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if ref, ok := strings.CutPrefix(line, "tag "); ok {
+			annotated[ref] = true
+		}
+	}
+	return annotated, nil
+}
@@ -0,0 +1,198 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+
+	"github.com/lib/pq"
+)
+
+// RetryConfig sets how failed syncs of a target are retried
+type RetryConfig struct {
+	// MaxAttempts is how many syncs of a target may fail in a row before
+	// it is dead-lettered; 0 disables retries
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles with every
+	// further failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// RetryConfigFromEnv reads SYNC_RETRY_MAX_ATTEMPTS, SYNC_RETRY_BACKOFF and
+// SYNC_RETRY_MAX_BACKOFF, defaulting to 5 attempts a minute apart at first
+// and an hour apart at most
+func RetryConfigFromEnv() (RetryConfig, error) {
+	cfg := RetryConfig{MaxAttempts: 5, Backoff: time.Minute, MaxBackoff: time.Hour}
+	if raw := os.Getenv("SYNC_RETRY_MAX_ATTEMPTS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("SYNC_RETRY_MAX_ATTEMPTS must be a non-negative number")
+		}
+		cfg.MaxAttempts = n
+	}
+	for name, field := range map[string]*time.Duration{"SYNC_RETRY_BACKOFF": &cfg.Backoff, "SYNC_RETRY_MAX_BACKOFF": &cfg.MaxBackoff} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("%s must be a positive duration such as 30s", name)
+		}
+		*field = d
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		return cfg, fmt.Errorf("SYNC_RETRY_MAX_BACKOFF (%s) is below SYNC_RETRY_BACKOFF (%s)", cfg.MaxBackoff, cfg.Backoff)
+	}
+	return cfg, nil
+}
+
+// delay returns when to retry after the given number of failures in a row:
+// a random time between half and all of the backoff, so targets failing
+// together are not retried together
+func (c RetryConfig) delay(failures int) time.Duration {
+	backoff := c.Backoff
+	for i := 1; i < failures && backoff < c.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, c.MaxBackoff)
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// retried reports whether a failure counts towards the attempts of a
+// target. Failures held back for the whole repository, such as by its
+// size, an anomaly or leaked secrets, are resolved by their own means, a
+// push awaiting approval has not failed, and a cancelled sync, such as one
+// stopped by a shutdown, did not attempt the target.
+func retried(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var (
+		size     *SizeError
+		anomaly  *AnomalyError
+		secrets  *SecretError
+		approval *ApprovalError
+	)
+	return !errors.As(err, &size) && !errors.As(err, &anomaly) && !errors.As(err, &secrets) && !errors.As(err, &approval)
+}
+
+// recordAttempt updates the retry state of a target after a sync, which
+// err failed. A success clears it; a failure schedules a retry, or
+// dead-letters the target once it failed MaxAttempts times in a row; a
+// failure of a cancelled sync is not counted. A sync throttled by a
+// provider rate limit is retried once the limit resets rather than after
+// the backoff, without counting as a failure. It returns when the target
+// is retried, if it is.
+func (s *Syncer) recordAttempt(ctx context.Context, target models.Target, err error) *time.Time {
+	// A git command killed by the cancellation fails with its own error
+	if s.Retry.MaxAttempts == 0 || (err != nil && (!retried(err) || errors.Is(ctx.Err(), context.Canceled))) {
+		return nil
+	}
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		if _, err := s.DB.ExecContext(ctx,
+			`UPDATE replication_targets SET sync_failures = 0, retry_at = NULL, dead_lettered_at = NULL
+			 WHERE id = $1 AND (sync_failures > 0 OR dead_lettered_at IS NOT NULL)`, target.ID); err != nil {
+			log.Printf("ERROR: failed to clear retries of target %s: %v", target.ID, err)
+		}
+		return nil
+	}
+
+	if until, ok := provider.RetryAt(err); ok {
+		if _, err := s.DB.ExecContext(ctx,
+			`UPDATE replication_targets SET retry_at = $2 WHERE id = $1`, target.ID, until); err != nil {
+			log.Printf("ERROR: failed to schedule retry of target %s: %v", target.ID, err)
+		}
+		return &until
+	}
+
+	now := s.Clock.Now()
+	var failures int
+	var retryAt, deadLettered *time.Time
+	if err := s.DB.QueryRowContext(ctx,
+		`UPDATE replication_targets SET sync_failures = sync_failures + 1 WHERE id = $1 RETURNING sync_failures`,
+		target.ID).Scan(&failures); err != nil {
+		log.Printf("ERROR: failed to count failure of target %s: %v", target.ID, err)
+		return nil
+	}
+	if failures >= s.Retry.MaxAttempts {
+		deadLettered = &now
+		log.Printf("WARN: target %s dead-lettered after %d failed syncs in a row", target.ID, failures)
+	} else {
+		at := now.Add(s.Retry.delay(failures))
+		retryAt = &at
+	}
+	if _, err := s.DB.ExecContext(ctx,
+		`UPDATE replication_targets SET retry_at = $2, dead_lettered_at = COALESCE(dead_lettered_at, $3) WHERE id = $1`,
+		target.ID, retryAt, deadLettered); err != nil {
+		log.Printf("ERROR: failed to schedule retry of target %s: %v", target.ID, err)
+	}
+	return retryAt
+}
+
+// queueRetries queues a sync of the targets whose retry is due, of the
+// repositories the planner would sync now: retries of disabled, paused or
+// held back repositories, or outside their sync window, wait until these
+// would be synced again. Repositories queued or syncing are retried on a
+// later check.
+func (r *Runner) queueRetries(ctx context.Context, now time.Time) {
+	open, err := r.Planner.Open(ctx, now)
+	if err != nil {
+		log.Printf("ERROR: failed to find due retries: %v", err)
+		return
+	}
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT t.repository_id, array_agg(t.id::text ORDER BY t.priority, t.created_at)
+		 FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+		 WHERE t.retry_at <= $1 AND t.dead_lettered_at IS NULL AND t.approval_state = $2 AND r.enabled
+		 GROUP BY t.repository_id`, now, models.ApprovalApproved)
+	if err != nil {
+		log.Printf("ERROR: failed to find due retries: %v", err)
+		return
+	}
+	var due []models.SyncRun
+	for rows.Next() {
+		run := models.SyncRun{Trigger: TriggerRetry}
+		if err := rows.Scan(&run.RepositoryID, pq.Array(&run.TargetIDs)); err != nil {
+			log.Printf("ERROR: failed to scan due retry: %v", err)
+			continue
+		}
+		if open[run.RepositoryID] {
+			due = append(due, run)
+		}
+	}
+	rows.Close()
+
+	for _, run := range due {
+		if _, err := r.queue(ctx, run); err != nil && !errors.Is(err, ErrBusy) {
+			log.Printf("ERROR: %v", err)
+		}
+	}
+}
+
+// Redrive gives a dead-lettered target its attempts back and queues a sync
+// of it, requested by requestedBy. It returns ErrBusy, with the target
+// re-driven, when the repository is queued or syncing, in which case its
+// next sync includes the target again.
+func (r *Runner) Redrive(ctx context.Context, target models.Target, requestedBy string) (models.SyncRun, error) {
+	if _, err := r.DB.ExecContext(ctx,
+		`UPDATE replication_targets SET sync_failures = 0, retry_at = NULL, dead_lettered_at = NULL WHERE id = $1`,
+		target.ID); err != nil {
+		return models.SyncRun{}, fmt.Errorf("failed to re-drive target %s: %w", target.ID, err)
+	}
+	run := models.SyncRun{RepositoryID: target.RepositoryID, Trigger: TriggerRedrive, TargetIDs: []string{target.ID}}
+	if requestedBy != "" {
+		run.RequestedBy = &requestedBy
+	}
+	return r.queue(ctx, run)
+}
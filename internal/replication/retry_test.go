@@ -0,0 +1,108 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 10, Backoff: time.Minute, MaxBackoff: 10 * time.Minute}
+	tests := []struct {
+		failures int
+		backoff  time.Duration
+	}{
+		{failures: 0, backoff: time.Minute},
+		{failures: 1, backoff: time.Minute},
+		{failures: 2, backoff: 2 * time.Minute},
+		{failures: 3, backoff: 4 * time.Minute},
+		{failures: 4, backoff: 8 * time.Minute},
+		// Capped at MaxBackoff rather than doubled past it
+		{failures: 5, backoff: 10 * time.Minute},
+		{failures: 60, backoff: 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.failures), func(t *testing.T) {
+			// The delay is random within half and all of the backoff
+			for range 200 {
+				d := cfg.delay(tt.failures)
+				if d < tt.backoff/2 || d > tt.backoff {
+					t.Fatalf("delay(%d) = %s, want between %s and %s", tt.failures, d, tt.backoff/2, tt.backoff)
+				}
+			}
+		})
+	}
+
+	t.Run("jittered", func(t *testing.T) {
+		seen := map[time.Duration]bool{}
+		for range 50 {
+			seen[cfg.delay(3)] = true
+		}
+		if len(seen) < 2 {
+			t.Error("delay returned the same value every time")
+		}
+	})
+}
+
+func TestRetryConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempts   string
+		backoff    string
+		maxBackoff string
+		want       RetryConfig
+		err        string
+	}{
+		{name: "defaults", want: RetryConfig{MaxAttempts: 5, Backoff: time.Minute, MaxBackoff: time.Hour}},
+		{name: "disabled", attempts: "0", want: RetryConfig{Backoff: time.Minute, MaxBackoff: time.Hour}},
+		{name: "set", attempts: "3", backoff: "30s", maxBackoff: "5m", want: RetryConfig{MaxAttempts: 3, Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}},
+		{name: "negative attempts", attempts: "-1", err: "SYNC_RETRY_MAX_ATTEMPTS"},
+		{name: "invalid backoff", backoff: "soon", err: "SYNC_RETRY_BACKOFF"},
+		{name: "zero max backoff", maxBackoff: "0s", err: "SYNC_RETRY_MAX_BACKOFF"},
+		{name: "max below backoff", backoff: "2h", err: "is below SYNC_RETRY_BACKOFF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SYNC_RETRY_MAX_ATTEMPTS", tt.attempts)
+			t.Setenv("SYNC_RETRY_BACKOFF", tt.backoff)
+			t.Setenv("SYNC_RETRY_MAX_BACKOFF", tt.maxBackoff)
+			cfg, err := RetryConfigFromEnv()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("RetryConfigFromEnv error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RetryConfigFromEnv failed: %v", err)
+			}
+			if cfg != tt.want {
+				t.Errorf("RetryConfigFromEnv = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetried(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "push failure", err: errors.New("remote rejected"), want: true},
+		{name: "cancelled", err: fmt.Errorf("push failed: %w", context.Canceled), want: false},
+		{name: "timed out", err: context.DeadlineExceeded, want: true},
+		{name: "size", err: fmt.Errorf("sync held back: %w", &SizeError{}), want: false},
+		{name: "approval", err: &ApprovalError{}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retried(tt.err); got != tt.want {
+				t.Errorf("retried(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
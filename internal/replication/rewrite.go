@@ -0,0 +1,603 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// errRewriteFilter is returned for targets with rewrite rules of
+// repositories with a clone filter, whose mirrors lack the blobs rewriting
+// reads
+var errRewriteFilter = errors.New("history rewriting needs a full mirror, but the repository has a clone filter")
+
+// ValidateRewrite checks the rewrite rules of a target; nil rules are
+// valid and rewrite nothing
+func ValidateRewrite(rules *models.RewriteRules) error {
+	if rules == nil {
+		return nil
+	}
+	if rules.MaxBlobMB < 0 {
+		return errors.New("max_blob_mb must not be negative")
+	}
+	for i, p := range rules.ExcludePaths {
+		if p == "" || strings.HasPrefix(p, "/") || path.Clean(p) != p {
+			return fmt.Errorf("exclude_paths pattern %d must be a relative path without ., .. or a trailing slash", i)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("exclude_paths pattern %d: %w", i, err)
+		}
+	}
+	if rules.MaxBlobMB == 0 && len(rules.ExcludePaths) == 0 {
+		return errors.New("rewrite needs max_blob_mb or exclude_paths")
+	}
+	return nil
+}
+
+// historyRules are all the rules the history pushed to a target is
+// rewritten by
+type historyRules struct {
+	models.RewriteRules
+	// Subdirectory keeps only the history of this directory, which becomes
+	// the root of the rewritten tree
+	Subdirectory string `json:"subdirectory,omitempty"`
+	// Identities is the latest identity mapping of the target
+	Identities []models.IdentityRule `json:"identities,omitempty"`
+}
+
+// rewriteRules returns the rules of target, nil when it receives the
+// source history as is
+func rewriteRules(target models.Target) *historyRules {
+	if target.Rewrite == nil && target.Mode != models.TargetModeSubdirectory {
+		return nil
+	}
+	rules := &historyRules{}
+	if target.Rewrite != nil {
+		rules.RewriteRules = *target.Rewrite
+	}
+	if target.Mode == models.TargetModeSubdirectory && target.Subdirectory != nil {
+		rules.Subdirectory = *target.Subdirectory
+	}
+	return rules
+}
+
+// targetRules returns rewriteRules of target with its latest identity
+// mapping, nil when it receives the source history as is
+func (p *Pusher) targetRules(ctx context.Context, target models.Target) (*historyRules, error) {
+	rules := rewriteRules(target)
+	var identities models.IdentityRules
+	err := p.DB.QueryRowContext(ctx,
+		`SELECT rules FROM identity_mappings WHERE target_id = $1 ORDER BY version DESC LIMIT 1`, target.ID).Scan(&identities)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && len(identities) == 0) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity mapping: %w", err)
+	}
+	if rules == nil {
+		rules = &historyRules{}
+	}
+	rules.Identities = identities
+	return rules, nil
+}
+
+// ValidateIdentities checks the rules of an identity mapping
+func ValidateIdentities(rules []models.IdentityRule) error {
+	for i, rule := range rules {
+		if rule.MatchEmail == "" {
+			return fmt.Errorf("rule %d needs a match_email", i)
+		}
+		if rule.Name == "" && rule.Email == "" {
+			return fmt.Errorf("rule %d needs a name or an email", i)
+		}
+		for _, v := range []string{rule.MatchEmail, rule.MatchName, rule.Name, rule.Email} {
+			if strings.ContainsAny(v, "<>\n") {
+				return fmt.Errorf("rule %d: names and emails must not hold <, > or line breaks", i)
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteHash identifies the history rules give, "" for no rules
+func rewriteHash(rules *historyRules) string {
+	if rules == nil {
+		return ""
+	}
+	data, _ := json.Marshal(rules)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// excludedPath reports whether a file at name is dropped by patterns. A
+// pattern with a slash matches from the root, one without matches a file
+// or directory of that name at any depth; files below a matching
+// directory are dropped with it.
+func excludedPath(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		anchored := strings.Contains(pattern, "/")
+		for p := name; p != ""; {
+			dir, base := path.Split(p)
+			subject := base
+			if anchored {
+				subject = p
+			}
+			if ok, _ := path.Match(pattern, subject); ok {
+				return true
+			}
+			p = strings.TrimSuffix(dir, "/")
+		}
+	}
+	return false
+}
+
+// rewriteDir is where the rewritten history of a target is kept. It lives
+// inside the mirror at dir, whose objects it borrows, so both are removed
+// together.
+func rewriteDir(dir, targetID string) string {
+	return filepath.Join(dir, "rewrites", targetID)
+}
+
+// rewrite rewrites the refs of the mirror at dir that a target receives by
+// its rules and returns the rewritten refs. Refs left without history,
+// such as branches that never touched a split subdirectory, are missing
+// from them. Commits rewritten by earlier syncs are reused through the
+// fast-export and fast-import marks kept with the rewritten history; when
+// that state is lost or unusable the whole history is rewritten again,
+// which gives the same commits.
+func (p *Pusher) rewrite(ctx context.Context, dir, targetID string, rules *historyRules, refs map[string]string) (map[string]string, error) {
+	rw := rewriteDir(dir, targetID)
+	rewritten, err := p.rewriteHistory(ctx, dir, rw, targetID, rules, refs)
+	if err == nil || ctx.Err() != nil {
+		return rewritten, err
+	}
+	if _, statErr := os.Stat(filepath.Join(rw, "source.marks")); statErr != nil {
+		return nil, err
+	}
+	log.Printf("WARN: rewriting new commits for target %s failed, rewriting its whole history: %v", targetID, err)
+	if err := os.RemoveAll(rw); err != nil {
+		return nil, fmt.Errorf("failed to remove rewritten history: %w", err)
+	}
+	return p.rewriteHistory(ctx, dir, rw, targetID, rules, refs)
+}
+
+// rewriteHistory pipes the commits of refs not rewritten yet from
+// git fast-export in the mirror at dir, through rules, to git fast-import
+// in the repository at rw
+func (p *Pusher) rewriteHistory(ctx context.Context, dir, rw, targetID string, rules *historyRules, refs map[string]string) (map[string]string, error) {
+	hash := rewriteHash(rules)
+	if current, _ := os.ReadFile(filepath.Join(rw, "rules")); string(current) != hash {
+		if err := os.RemoveAll(rw); err != nil {
+			return nil, fmt.Errorf("failed to remove outdated rewritten history: %w", err)
+		}
+	}
+	if _, err := os.Stat(rw); os.IsNotExist(err) {
+		if err := p.initRewriteDir(ctx, dir, rw, hash); err != nil {
+			os.RemoveAll(rw)
+			return nil, err
+		}
+	}
+
+	filter := &historyFilter{rules: *rules}
+	if rules.MaxBlobMB > 0 {
+		large, err := p.largeBlobs(ctx, dir, int64(rules.MaxBlobMB)<<20)
+		if err != nil {
+			return nil, err
+		}
+		filter.large = large
+	}
+
+	sourceMarks, rewrittenMarks := filepath.Join(rw, "source.marks"), filepath.Join(rw, "rewritten.marks")
+	before, err := readMarks(rewrittenMarks)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) > 0 {
+		// Marks are only replaced once both sides have completed, so they
+		// always describe the same commits
+		exportArgs := []string{"fast-export", "--no-data", "--reencode=no", "--signed-tags=strip",
+			"--tag-of-filtered-object=rewrite", "--use-done-feature", "--export-marks=" + sourceMarks + ".new"}
+		if _, err := os.Stat(sourceMarks); err == nil {
+			exportArgs = append(exportArgs, "--import-marks="+sourceMarks)
+		}
+		var revs strings.Builder
+		for _, ref := range slices.Sorted(maps.Keys(refs)) {
+			revs.WriteString(ref + "\n")
+		}
+		// Limiting the export to the subdirectory leaves out the commits
+		// that do not change it
+		if rules.Subdirectory != "" {
+			revs.WriteString("--\n" + rules.Subdirectory + "\n")
+		}
+		if err := p.pipeHistory(ctx,
+			git.Command{Dir: dir, Args: append(exportArgs, "--stdin"), Stdin: strings.NewReader(revs.String())},
+			git.Command{Dir: rw, Args: []string{"fast-import", "--quiet", "--force", "--done",
+				"--import-marks-if-exists=" + rewrittenMarks, "--export-marks=" + rewrittenMarks + ".new"}},
+			filter); err != nil {
+			return nil, err
+		}
+	}
+
+	// Refs the target no longer receives go from the rewritten history
+	current, err := p.localRefs(ctx, rw, "refs")
+	if err != nil {
+		return nil, err
+	}
+	var stale strings.Builder
+	for ref := range current {
+		if _, ok := refs[ref]; !ok {
+			stale.WriteString("delete " + ref + "\n")
+		}
+	}
+	if stale.Len() > 0 {
+		if _, err := p.Git.Run(ctx, git.Command{Dir: rw, Args: []string{"update-ref", "--stdin"}, Stdin: strings.NewReader(stale.String())}); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(refs) > 0 {
+		if err := p.recordRewritten(ctx, targetID, hash, sourceMarks+".new", rewrittenMarks+".new", before); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(sourceMarks+".new", sourceMarks); err != nil {
+			return nil, fmt.Errorf("failed to save rewrite marks: %w", err)
+		}
+		if err := os.Rename(rewrittenMarks+".new", rewrittenMarks); err != nil {
+			return nil, fmt.Errorf("failed to save rewrite marks: %w", err)
+		}
+	}
+	// fast-import leaves a pack per run behind. Commits of deleted refs are
+	// kept, since the marks still name them.
+	if _, err := p.Git.Run(ctx, git.Command{Dir: rw, Args: []string{"gc", "--auto", "--quiet"},
+		Config: map[string]string{"gc.pruneExpire": "never"}}); err != nil {
+		log.Printf("WARN: failed to pack rewritten history of target %s: %v", targetID, err)
+	}
+	return p.localRefs(ctx, rw, "refs/heads", "refs/tags")
+}
+
+// initRewriteDir creates the bare repository at rw holding the history
+// rewritten by the rules with hash. It reads the source objects from the
+// mirror at dir, so only the trees and commits rewriting changes are
+// stored.
+func (p *Pusher) initRewriteDir(ctx context.Context, dir, rw, hash string) error {
+	if _, err := p.Git.Run(ctx, git.Command{Args: []string{"init", "--bare", "--quiet", rw}}); err != nil {
+		return err
+	}
+	objects, err := filepath.Abs(filepath.Join(dir, "objects"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(rw, "objects", "info", "alternates"), []byte(objects+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to link rewritten history to mirror: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(rw, "rules"), []byte(hash), 0o644); err != nil {
+		return fmt.Errorf("failed to save rewrite rules: %w", err)
+	}
+	return nil
+}
+
+// pipeHistory runs export into filter into import. A failure of one stops
+// the others; the errors of all that failed are returned.
+func (p *Pusher) pipeHistory(ctx context.Context, export, imp git.Command, filter *historyFilter) error {
+	exportR, exportW := io.Pipe()
+	importR, importW := io.Pipe()
+	exportErr, filterErr := make(chan error, 1), make(chan error, 1)
+	go func() {
+		export.Stdout = exportW
+		_, err := p.Git.Run(ctx, export)
+		exportW.CloseWithError(err)
+		exportErr <- err
+	}()
+	go func() {
+		err := filter.run(exportR, importW)
+		exportR.CloseWithError(io.ErrClosedPipe)
+		importW.CloseWithError(err)
+		filterErr <- err
+	}()
+	imp.Stdin = importR
+	_, err := p.Git.Run(ctx, imp)
+	importR.CloseWithError(io.ErrClosedPipe)
+
+	// The filter also fails when export does, or import stops reading
+	errs := []error{<-exportErr, err}
+	if fErr := <-filterErr; fErr != nil && !errors.Is(fErr, errs[0]) && !errors.Is(fErr, io.ErrClosedPipe) {
+		errs = append(errs, fmt.Errorf("failed to rewrite history: %w", fErr))
+	}
+	return errors.Join(errs...)
+}
+
+// largeBlobs lists the blobs of the mirror at dir over limit bytes
+func (p *Pusher) largeBlobs(ctx context.Context, dir string, limit int64) (map[string]bool, error) {
+	r, w := io.Pipe()
+	defer r.Close()
+	go func() {
+		_, err := p.Git.Run(ctx, git.Command{Dir: dir, Stdout: w, Args: []string{
+			"cat-file", "--batch-all-objects", "--unordered", "--batch-check=%(objecttype) %(objectsize) %(objectname)",
+		}})
+		w.CloseWithError(err)
+	}()
+	large := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 || fields[0] != "blob" {
+			continue
+		}
+		if size, _ := strconv.ParseInt(fields[1], 10, 64); size > limit {
+			large[fields[2]] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list blob sizes: %w", err)
+	}
+	return large, nil
+}
+
+// historyFilter rewrites a git fast-export --no-data stream by rules
+type historyFilter struct {
+	rules historyRules
+	// large holds the blobs over rules.MaxBlobMB
+	large map[string]bool
+}
+
+// run copies the stream from r to w, moving the files the rules move,
+// leaving out the changes of those they drop and replacing identities.
+// Identities in messages, such as Signed-off-by trailers, are kept.
+func (f *historyFilter) run(r io.Reader, w io.Writer) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	bw := bufio.NewWriterSize(w, 64*1024)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "data "):
+			// Messages are copied byte for byte; they may hold anything
+			n, err := strconv.ParseInt(strings.TrimSpace(line[5:]), 10, 64)
+			if err != nil {
+				return fmt.Errorf("malformed fast-export data line %q", line)
+			}
+			bw.WriteString(line)
+			if _, err := io.CopyN(bw, br, n); err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(line, "M "):
+			// M <mode> <blob> <path>
+			fields := strings.SplitN(strings.TrimSuffix(line[2:], "\n"), " ", 3)
+			if len(fields) != 3 {
+				return fmt.Errorf("malformed fast-export file change %q", line)
+			}
+			name, ok := f.path(unquotePath(fields[2]), fields[1])
+			if !ok {
+				continue
+			}
+			line = "M " + fields[0] + " " + fields[1] + " " + quotePath(name) + "\n"
+		case strings.HasPrefix(line, "D "):
+			name, ok := f.path(unquotePath(strings.TrimSuffix(line[2:], "\n")), "")
+			if !ok {
+				continue
+			}
+			line = "D " + quotePath(name) + "\n"
+		case strings.HasPrefix(line, "author "), strings.HasPrefix(line, "committer "), strings.HasPrefix(line, "tagger "):
+			line = f.identity(line)
+		}
+		if _, err := bw.WriteString(line); err != nil {
+			return err
+		}
+	}
+}
+
+// path returns the path of the file at name holding blob in the rewritten
+// history, or false when the file is dropped. Excluded paths are relative
+// to the subdirectory kept.
+func (f *historyFilter) path(name, blob string) (string, bool) {
+	if f.rules.Subdirectory != "" {
+		var ok bool
+		if name, ok = strings.CutPrefix(name, f.rules.Subdirectory+"/"); !ok {
+			return "", false
+		}
+	}
+	if f.large[blob] || excludedPath(f.rules.ExcludePaths, name) {
+		return "", false
+	}
+	return name, true
+}
+
+// unquotePath decodes a path git quoted for holding unusual characters
+func unquotePath(name string) string {
+	if strings.HasPrefix(name, `"`) {
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			return unquoted
+		}
+	}
+	return name
+}
+
+// identity applies the identity rules to an author, committer or tagger
+// line: <kind> [<name> ]<<email>> <when>
+func (f *historyFilter) identity(line string) string {
+	kind, rest, _ := strings.Cut(line, " ")
+	lt := strings.IndexByte(rest, '<')
+	gt := strings.IndexByte(rest, '>')
+	if lt < 0 || gt < lt {
+		return line
+	}
+	name, email, when := strings.TrimSpace(rest[:lt]), rest[lt+1:gt], rest[gt+1:]
+
+	var match *models.IdentityRule
+	for i, rule := range f.rules.Identities {
+		if !matchEmail(rule.MatchEmail, email) || (rule.MatchName != "" && rule.MatchName != name) {
+			continue
+		}
+		if match == nil || (match.MatchName == "" && rule.MatchName != "") {
+			match = &f.rules.Identities[i]
+		}
+	}
+	if match == nil {
+		return line
+	}
+	if match.Name != "" {
+		name = match.Name
+	}
+	if match.Email != "" {
+		email = match.Email
+	}
+	if name == "" {
+		return kind + " <" + email + ">" + when
+	}
+	return kind + " " + name + " <" + email + ">" + when
+}
+
+// matchEmail reports whether email matches pattern, an address or
+// *@domain, ignoring case
+func matchEmail(pattern, email string) bool {
+	if domain, ok := strings.CutPrefix(pattern, "*@"); ok {
+		at := strings.LastIndexByte(email, '@')
+		return at >= 0 && strings.EqualFold(email[at+1:], domain)
+	}
+	return strings.EqualFold(pattern, email)
+}
+
+// quotePath quotes name the way git does when it holds characters a
+// fast-import path cannot hold as is
+func quotePath(name string) string {
+	if !strings.ContainsAny(name, "\"\\\n") && !strings.ContainsFunc(name, unicode.IsControl) {
+		return name
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// readMarks reads a marks file as mark to object ID; a missing file has
+// no marks
+func readMarks(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewrite marks: %w", err)
+	}
+	marks := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if mark, sha, ok := strings.Cut(line, " "); ok {
+			marks[mark] = sha
+		}
+	}
+	return marks, nil
+}
+
+// recordRewritten stores the rewritten commit of each source commit first
+// marked since before. It fails when a commit was rewritten differently
+// before by the same rules, since pushing would then replace history the
+// target already holds.
+func (p *Pusher) recordRewritten(ctx context.Context, targetID, hash, sourceMarks, rewrittenMarks string, before map[string]string) error {
+	source, err := readMarks(sourceMarks)
+	if err != nil {
+		return err
+	}
+	rewritten, err := readMarks(rewrittenMarks)
+	if err != nil {
+		return err
+	}
+	var sources, rewrites []string
+	for mark, sha := range rewritten {
+		if _, ok := before[mark]; !ok && source[mark] != "" {
+			sources = append(sources, source[mark])
+			rewrites = append(rewrites, sha)
+		}
+	}
+
+	const batch = 5000
+	for len(sources) > 0 {
+		n := min(batch, len(sources))
+		var diverged string
+		err := p.DB.QueryRowContext(ctx,
+			`SELECT c.source_sha FROM rewritten_commits c
+			 JOIN unnest($3::text[], $4::text[]) AS n(source_sha, rewritten_sha) ON n.source_sha = c.source_sha
+			 WHERE c.target_id = $1 AND c.rules_hash = $2 AND c.rewritten_sha <> n.rewritten_sha
+			 LIMIT 1`,
+			targetID, hash, pq.Array(sources[:n]), pq.Array(rewrites[:n])).Scan(&diverged)
+		if err == nil {
+			return fmt.Errorf("commit %.12s was rewritten differently than for the last push; not pushing the diverged history", diverged)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to check rewritten commits: %w", err)
+		}
+		if _, err := p.DB.ExecContext(ctx,
+			`INSERT INTO rewritten_commits (target_id, rules_hash, source_sha, rewritten_sha)
+			 SELECT $1, $2, source_sha, rewritten_sha FROM unnest($3::text[], $4::text[]) AS n(source_sha, rewritten_sha)
+			 ON CONFLICT DO NOTHING`,
+			targetID, hash, pq.Array(sources[:n]), pq.Array(rewrites[:n])); err != nil {
+			return fmt.Errorf("failed to record rewritten commits: %w", err)
+		}
+		sources, rewrites = sources[n:], rewrites[n:]
+	}
+	return nil
+}
+
+// pushedRewrite returns the hash of the rules the history on a target was
+// rewritten with, "" for the source history
+func (p *Pusher) pushedRewrite(ctx context.Context, targetID string) (string, error) {
+	var hash string
+	err := p.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(rewrite_pushed, '') FROM replication_targets WHERE id = $1`, targetID).Scan(&hash)
+	if err != nil {
+		return "", fmt.Errorf("failed to load pushed rewrite rules: %w", err)
+	}
+	return hash, nil
+}
+
+// recordRewrite records that the history on a target was rewritten with
+// the rules of hash and forgets the commits rewritten by other rules
+func (p *Pusher) recordRewrite(ctx context.Context, targetID, hash string) error {
+	if _, err := p.DB.ExecContext(ctx,
+		`UPDATE replication_targets SET rewrite_pushed = NULLIF($2, '') WHERE id = $1`, targetID, hash); err != nil {
+		return fmt.Errorf("failed to record pushed rewrite rules: %w", err)
+	}
+	if _, err := p.DB.ExecContext(ctx,
+		`DELETE FROM rewritten_commits WHERE target_id = $1 AND rules_hash <> $2`, targetID, hash); err != nil {
+		return fmt.Errorf("failed to remove outdated rewritten commits: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/schedule"
+	"gitsync/internal/worker"
+
+	"github.com/lib/pq"
+)
+
+// Runner syncs repositories on a worker pool, both those the planner finds
+// due and those triggered explicitly. Syncs are queued as sync runs in the
+// database, which every instance claims them from, so they survive
+// restarts and are shared by the instances. Other jobs of a repository
+// are queued on the pool directly. A repository is synced by at most one
+// job at a time.
+type Runner struct {
+	DB      *database.DB
+	Syncer  *Syncer
+	Planner *schedule.Planner
+	Pool    *worker.Pool
+	// Instance names this process in the runs it claims
+	Instance string
+
+	mu sync.Mutex
+	// active holds the repositories queued or syncing on the pool
+	active map[string]bool
+	// stopped is closed once the job of a repository queued on the pool
+	// returned
+	stopped map[string]chan struct{}
+	// cancels stop the runs syncing here, by run ID
+	cancels map[string]context.CancelFunc
+	// frozen is the ID of the freeze period that last held back scheduled
+	// syncs, so each freeze is logged once
+	frozen string
+	// finishers are called with the finished runs of a kind of trigger
+	finishers map[string]func(context.Context, models.SyncRun)
+	// wake makes Dispatch claim runs right away
+	wake chan struct{}
+}
+
+// NewRunner creates a Runner
+func NewRunner(db *database.DB, syncer *Syncer, planner *schedule.Planner, pool *worker.Pool) *Runner {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "gitsync"
+	}
+	return &Runner{
+		DB: db, Syncer: syncer, Planner: planner, Pool: pool, Instance: instance + "-" + syncer.IDs.NewID()[:8],
+		active: map[string]bool{}, stopped: map[string]chan struct{}{}, cancels: map[string]context.CancelFunc{}, finishers: map[string]func(context.Context, models.SyncRun){}, wake: make(chan struct{}, 1),
+	}
+}
+
+// Triggers of syncs, recorded with their executions. Scheduled syncs
+// append the reason of their interval, as in schedule:hot.
+const (
+	TriggerSchedule = "schedule"
+	TriggerGroup    = "group"
+	// TriggerApproval syncs push the changes of a push approval
+	TriggerApproval = "approval"
+	// TriggerPromotion syncs fetch from a target just promoted to source
+	TriggerPromotion = "promotion"
+	// TriggerManual syncs are requested through the API
+	TriggerManual = "manual"
+	// TriggerWebhook syncs follow a push announced by the source's webhook
+	TriggerWebhook = "webhook"
+	// TriggerRetry syncs retry the targets whose last syncs failed
+	TriggerRetry = "retry"
+	// TriggerRedrive syncs retry a dead-lettered target on request
+	TriggerRedrive = "redrive"
+)
+
+// Enqueue queues a sync of the repository with the given ID, started by
+// trigger. It returns ErrBusy when the repository is already queued or
+// syncing.
+func (r *Runner) Enqueue(ctx context.Context, repoID, trigger string) error {
+	_, err := r.queue(ctx, models.SyncRun{RepositoryID: repoID, Trigger: trigger, TargetIDs: []string{}})
+	return err
+}
+
+// OnFinish calls fn with every run of the given kind of trigger once it
+// finished, on whichever instance ran it. The kind is the part of the
+// trigger before any colon, such as TriggerGroup for group:<run id>.
+func (r *Runner) OnFinish(kind string, fn func(context.Context, models.SyncRun)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finishers[kind] = fn
+}
+
+// Submit queues job for the repository with the given ID. Jobs of one
+// repository, syncs or otherwise, never overlap, so they may share its
+// mirror. It reports false when the repository is already queued or busy
+// or the pool queue is full.
+func (r *Runner) Submit(repoID string, job worker.Job) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active[repoID] {
+		return false
+	}
+	stopped := make(chan struct{})
+	queued := r.Pool.Submit(func(ctx context.Context) {
+		defer func() {
+			r.mu.Lock()
+			delete(r.active, repoID)
+			delete(r.stopped, repoID)
+			r.mu.Unlock()
+			close(stopped)
+			// The pool has room for another run
+			r.notify()
+		}()
+		job(ctx)
+	})
+	if queued {
+		r.active[repoID] = true
+		r.stopped[repoID] = stopped
+	}
+	return queued
+}
+
+// Hold keeps jobs of the repositories with the given IDs from starting
+// until release is called. It reports false, holding none, when one of
+// them is queued or busy.
+func (r *Runner) Hold(repoIDs ...string) (release func(), ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range repoIDs {
+		if r.active[id] {
+			return nil, false
+		}
+	}
+	for _, id := range repoIDs {
+		r.active[id] = true
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, id := range repoIDs {
+			delete(r.active, id)
+		}
+	}, true
+}
+
+// ErrBusy is returned when the repository is queued or busy
+var ErrBusy = errors.New("repository is busy syncing; retry later")
+
+// Do runs job for the repository with the given ID like Submit and waits
+// for its error. The job keeps running when ctx is cancelled while waiting.
+func (r *Runner) Do(ctx context.Context, repoID string, job func(context.Context) error) error {
+	done := make(chan error, 1)
+	if !r.Submit(repoID, func(ctx context.Context) { done <- job(ctx) }) {
+		return ErrBusy
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Repository loads the fields of a repository needed to sync it
+func (r *Runner) Repository(ctx context.Context, id string) (models.Repository, error) {
+	var repo models.Repository
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT id, name, source_provider, source_url, alternate_source_urls, credential_id, source_state,
+		        labels, owner, team, clone_filter, cache_pool, transfer
+		 FROM repositories WHERE id = $1`, id).
+		Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, pq.Array(&repo.AlternateSourceURLs),
+			&repo.CredentialID, &repo.SourceState, &repo.Labels, &repo.Owner, &repo.Team, &repo.CloneFilter, &repo.CachePool,
+			&repo.Transfer)
+	if err != nil {
+		return repo, fmt.Errorf("failed to fetch repository %s: %w", id, err)
+	}
+	return repo, nil
+}
+
+// Run queues the repositories that are due every interval until ctx is
+// cancelled
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.queueDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueDue queues the repositories that are due and the retries of failed
+// targets, unless a freeze period is in effect
+func (r *Runner) queueDue(ctx context.Context) {
+	now := r.Syncer.Clock.Now()
+	freeze, err := schedule.ActiveFreeze(ctx, r.DB, now)
+	if err != nil {
+		log.Printf("ERROR: failed to check freeze periods: %v", err)
+		return
+	}
+	if freeze != nil {
+		if r.frozen != freeze.ID {
+			log.Printf("Scheduled syncs suspended by freeze %q until %s", freeze.Name, freeze.EndsAt.Format(time.RFC3339))
+			r.frozen = freeze.ID
+		}
+		return
+	}
+	r.frozen = ""
+
+	due, err := r.Planner.Due(ctx, now)
+	if err != nil {
+		log.Printf("ERROR: failed to plan syncs: %v", err)
+		return
+	}
+	for _, plan := range due {
+		if err := r.Enqueue(ctx, plan.RepositoryID, TriggerSchedule+":"+plan.Reason); err != nil && !errors.Is(err, ErrBusy) {
+			log.Printf("ERROR: %v", err)
+		}
+	}
+	r.queueRetries(ctx, now)
+}
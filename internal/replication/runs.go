@@ -0,0 +1,354 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"gitsync/internal/metrics"
+	"gitsync/internal/models"
+	"gitsync/internal/tracing"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SyncRunColumns are the sync_runs columns read by ScanSyncRun
+const SyncRunColumns = `id, repository_id, trigger, status, target_ids, targets, succeeded, failed, requested_by, error, created_at, started_at, finished_at, trace_parent`
+
+// ScanSyncRun scans a row of SyncRunColumns
+func ScanSyncRun(row interface{ Scan(...any) error }) (models.SyncRun, error) {
+	var run models.SyncRun
+	err := row.Scan(&run.ID, &run.RepositoryID, &run.Trigger, &run.Status, pq.Array(&run.TargetIDs), &run.Targets,
+		&run.Succeeded, &run.Failed, &run.RequestedBy, &run.Error, &run.CreatedAt, &run.StartedAt, &run.FinishedAt, &run.TraceParent)
+	if err == nil && run.StartedAt != nil && run.FinishedAt != nil {
+		d := run.FinishedAt.Sub(*run.StartedAt).Seconds()
+		run.DurationSeconds = &d
+	}
+	return run, err
+}
+
+// Intervals of the sync queue
+const (
+	// claimInterval is how often Dispatch looks for runs queued by other
+	// instances
+	claimInterval = 2 * time.Second
+	// heartbeatInterval is how often an instance confirms it is still
+	// running the runs it claimed
+	heartbeatInterval = 30 * time.Second
+	// staleAfter is how long after its last heartbeat a run is taken to be
+	// abandoned by a stopped instance and claimed again
+	staleAfter = 2 * time.Minute
+)
+
+// Trigger queues a manual sync of the repository with the given ID,
+// limited to the targets with targetIDs when set, returning the queued
+// run. It returns ErrBusy, recording nothing, when the repository is
+// queued or busy.
+func (r *Runner) Trigger(ctx context.Context, repoID string, targetIDs []string, requestedBy string) (models.SyncRun, error) {
+	if targetIDs == nil {
+		targetIDs = []string{}
+	}
+	run := models.SyncRun{RepositoryID: repoID, Trigger: TriggerManual, TargetIDs: targetIDs}
+	if requestedBy != "" {
+		run.RequestedBy = &requestedBy
+	}
+	return r.queue(ctx, run)
+}
+
+// queue records run as queued for Dispatch to claim, along with the trace
+// of ctx. A repository has one queued or running run at most, so it
+// returns ErrBusy when it has one.
+func (r *Runner) queue(ctx context.Context, run models.SyncRun) (models.SyncRun, error) {
+	run.ID, run.Status, run.CreatedAt = r.Syncer.IDs.NewID(), models.SyncRunQueued, r.Syncer.Clock.Now()
+	if parent := tracing.TraceParent(ctx); parent != "" {
+		run.TraceParent = &parent
+	}
+	res, err := r.DB.ExecContext(ctx,
+		`INSERT INTO sync_runs (id, repository_id, trigger, status, target_ids, requested_by, created_at, trace_parent)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 ON CONFLICT (repository_id) WHERE status IN ('queued', 'running') DO NOTHING`,
+		run.ID, run.RepositoryID, run.Trigger, run.Status, pq.Array(run.TargetIDs), run.RequestedBy, run.CreatedAt, run.TraceParent)
+	if err != nil {
+		return run, fmt.Errorf("failed to queue sync of repository %s: %w", run.RepositoryID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return run, ErrBusy
+	}
+	r.notify()
+	return run, nil
+}
+
+// notify wakes Dispatch without waiting for it
+func (r *Runner) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatch claims queued runs and syncs them on the pool until ctx is
+// cancelled: right away when a run is queued or finishes here, and every
+// claimInterval for runs queued by other instances. Runs claimed by an
+// instance that stopped heartbeating them are claimed again.
+func (r *Runner) Dispatch(ctx context.Context) {
+	ticker := time.NewTicker(claimInterval)
+	defer ticker.Stop()
+	var beat time.Time
+	for {
+		if now := r.Syncer.Clock.Now(); now.Sub(beat) >= heartbeatInterval {
+			r.heartbeat(ctx, now)
+			beat = now
+		}
+		r.claim(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// heartbeat marks the runs claimed by this instance as still running, and
+// stops those syncing here that it no longer holds: cancelled, or claimed
+// again by another instance
+func (r *Runner) heartbeat(ctx context.Context, now time.Time) {
+	rows, err := r.DB.QueryContext(ctx,
+		`UPDATE sync_runs SET heartbeat_at = $3 WHERE claimed_by = $1 AND status = $2 RETURNING id`,
+		r.Instance, models.SyncRunRunning, now)
+	if err != nil {
+		log.Printf("ERROR: failed to heartbeat sync runs: %v", err)
+		return
+	}
+	held := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("ERROR: failed to scan sync run: %v", err)
+			rows.Close()
+			return
+		}
+		held[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("ERROR: failed to heartbeat sync runs: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, cancel := range r.cancels {
+		if !held[id] {
+			log.Printf("WARN: sync run %s is no longer held by this instance; stopping it", id)
+			cancel()
+		}
+	}
+}
+
+// Cancel fails the queued and running runs of the repository with the
+// given ID with reason. A run syncing here is stopped, and Cancel waits
+// for its job to return until ctx is done; runs syncing on other instances
+// are stopped there on their next heartbeat.
+func (r *Runner) Cancel(ctx context.Context, repoID, reason string) error {
+	rows, err := r.DB.QueryContext(ctx,
+		`UPDATE sync_runs SET status = $2, error = $3, finished_at = $4
+		 WHERE repository_id = $1 AND status IN ($5, $6) RETURNING id`,
+		repoID, models.SyncRunFailed, reason, r.Syncer.Clock.Now(), models.SyncRunQueued, models.SyncRunRunning)
+	if err != nil {
+		return fmt.Errorf("failed to cancel sync runs of repository %s: %w", repoID, err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan sync run: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to cancel sync runs of repository %s: %w", repoID, err)
+	}
+
+	var stopped chan struct{}
+	r.mu.Lock()
+	for _, id := range ids {
+		if cancel := r.cancels[id]; cancel != nil {
+			log.Printf("Cancelling sync run %s of repository %s: %s", id, repoID, reason)
+			cancel()
+			stopped = r.stopped[repoID]
+		}
+	}
+	r.mu.Unlock()
+	if stopped == nil {
+		return nil
+	}
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// claim takes as many queued runs as the pool has room for, oldest first,
+// skipping those claimed by other instances at the same time and those of
+// repositories busy here with other jobs, and submits them to the pool
+func (r *Runner) claim(ctx context.Context) {
+	stats := r.Pool.Stats()
+	free := r.Pool.Config.Max - stats.Busy - stats.Queued
+	if free <= 0 {
+		return
+	}
+	// Never nil: ANY of a NULL array matches nothing, not even with NOT
+	busy := []string{}
+	r.mu.Lock()
+	busy = slices.AppendSeq(busy, maps.Keys(r.active))
+	r.mu.Unlock()
+
+	now := r.Syncer.Clock.Now()
+	rows, err := r.DB.QueryContext(ctx,
+		`UPDATE sync_runs SET status = $1, claimed_by = $2, heartbeat_at = $3
+		 WHERE id IN (SELECT id FROM sync_runs
+		              WHERE (status = $4 OR (status = $1 AND COALESCE(heartbeat_at, started_at, created_at) < $5))
+		                AND NOT repository_id = ANY($6::uuid[])
+		              ORDER BY created_at LIMIT $7 FOR UPDATE SKIP LOCKED)
+		 RETURNING `+SyncRunColumns,
+		models.SyncRunRunning, r.Instance, now, models.SyncRunQueued, now.Add(-staleAfter), pq.Array(busy), free)
+	if err != nil {
+		log.Printf("ERROR: failed to claim sync runs: %v", err)
+		return
+	}
+	var claimed []models.SyncRun
+	for rows.Next() {
+		run, err := ScanSyncRun(rows)
+		if err != nil {
+			log.Printf("ERROR: failed to scan sync run: %v", err)
+			continue
+		}
+		claimed = append(claimed, run)
+	}
+	rows.Close()
+
+	for _, run := range claimed {
+		if run.StartedAt != nil {
+			log.Printf("WARN: sync run %s of repository %s was abandoned by its instance; running it again", run.ID, run.RepositoryID)
+		}
+		if r.Submit(run.RepositoryID, func(ctx context.Context) { r.sync(ctx, run) }) {
+			continue
+		}
+		// A job of the repository started here since, or the pool filled up
+		if _, err := r.DB.ExecContext(ctx,
+			`UPDATE sync_runs SET status = $2, claimed_by = NULL, heartbeat_at = NULL WHERE id = $1`,
+			run.ID, models.SyncRunQueued); err != nil {
+			log.Printf("ERROR: failed to return sync run %s to the queue: %v", run.ID, err)
+		}
+	}
+}
+
+// sync syncs the repository of a claimed run and records its outcome,
+// unless the run was cancelled since it was claimed. The sync is traced
+// within the trace of the request that queued it, if any.
+func (r *Runner) sync(ctx context.Context, run models.SyncRun) {
+	// Registered before the run is started, so Cancel either finds it here
+	// or fails it before it starts
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.mu.Lock()
+	r.cancels[run.ID] = cancel
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.cancels, run.ID)
+		r.mu.Unlock()
+	}()
+
+	if run.TraceParent != nil {
+		ctx = tracing.WithTraceParent(ctx, *run.TraceParent)
+	}
+	ctx, span := tracing.Start(ctx, "sync run",
+		attribute.String("gitsync.repository_id", run.RepositoryID),
+		attribute.String("gitsync.run_id", run.ID),
+		attribute.String("gitsync.trigger", run.Trigger))
+	repo, err := r.Repository(ctx, run.RepositoryID)
+	if err != nil {
+		r.finishRun(ctx, run, Outcome{}, err)
+		tracing.End(span, err)
+		return
+	}
+	started := r.Syncer.Clock.Now()
+	run.StartedAt = &started
+	res, err := r.DB.ExecContext(ctx,
+		`UPDATE sync_runs SET started_at = $2 WHERE id = $1 AND claimed_by = $3 AND status = $4`,
+		run.ID, started, r.Instance, models.SyncRunRunning)
+	if err != nil {
+		log.Printf("ERROR: failed to start sync run of repository %s: %v", run.RepositoryID, err)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("WARN: sync run %s of repository %s was cancelled before it started", run.ID, run.RepositoryID)
+		tracing.End(span, nil)
+		return
+	}
+
+	out, err := r.Syncer.Sync(ctx, repo, run)
+	if err != nil {
+		log.Printf("WARN: sync of repository %s failed: %v", run.RepositoryID, err)
+	}
+	r.finishRun(ctx, run, out, err)
+	tracing.End(span, err)
+}
+
+// finishRun records the outcome of a run and calls the finisher of its
+// trigger. A run this instance no longer holds, since another instance
+// claimed it again after it went stale or it was cancelled, keeps the
+// outcome recorded since.
+func (r *Runner) finishRun(ctx context.Context, run models.SyncRun, out Outcome, err error) {
+	status, succeeded, failed := models.SyncRunSucceeded, 0, 0
+	for _, t := range out.Targets {
+		if t.Error != "" {
+			failed++
+		} else {
+			succeeded++
+		}
+	}
+	if err == nil && !out.Success {
+		err = errors.New("a required target failed")
+	}
+	var message *string
+	if err != nil {
+		msg := err.Error()
+		status, message = models.SyncRunFailed, &msg
+	}
+	// The record is written even when ctx was cancelled mid-sync
+	ctx = context.WithoutCancel(ctx)
+	finished := r.Syncer.Clock.Now()
+	res, err := r.DB.ExecContext(ctx,
+		`UPDATE sync_runs SET status = $2, targets = $3, succeeded = $4, failed = $5, error = $6, finished_at = $7
+		 WHERE id = $1 AND claimed_by = $8 AND status = $9`,
+		run.ID, status, len(out.Targets), succeeded, failed, message, finished, r.Instance, models.SyncRunRunning)
+	if err != nil {
+		log.Printf("ERROR: failed to finish sync run %s: %v", run.ID, err)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("WARN: lost sync run %s of repository %s before it finished; its outcome is discarded", run.ID, run.RepositoryID)
+		return
+	}
+	if run.StartedAt != nil {
+		metrics.ObserveRun(run.Trigger, status, finished.Sub(*run.StartedAt))
+	}
+
+	run.Status, run.Error = status, message
+	kind, _, _ := strings.Cut(run.Trigger, ":")
+	r.mu.Lock()
+	finish := r.finishers[kind]
+	r.mu.Unlock()
+	if finish != nil {
+		finish(ctx, run)
+	}
+}
@@ -0,0 +1,240 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SecretRule is a pattern of a credential. When the pattern has a capture
+// group, the first one is the secret; otherwise the whole match is.
+type SecretRule struct {
+	ID    string `yaml:"id"`
+	Regex string `yaml:"regex"`
+
+	re *regexp.Regexp
+}
+
+// DefaultSecretRules cover the credentials most often leaked, after the
+// rules of gitleaks
+var DefaultSecretRules = []SecretRule{
+	{ID: "private-key", Regex: `-----BEGIN[ A-Z0-9_-]{0,100}PRIVATE KEY(?: BLOCK)?-----`},
+	{ID: "aws-access-key", Regex: `\b((?:AKIA|ASIA|ABIA|ACCA)[A-Z0-9]{16})\b`},
+	{ID: "github-token", Regex: `\b((?:ghp|gho|ghu|ghs|ghr)_[0-9a-zA-Z]{36})\b`},
+	{ID: "github-fine-grained-token", Regex: `\b(github_pat_[0-9a-zA-Z_]{82})\b`},
+	{ID: "gitlab-token", Regex: `\b(glpat-[0-9a-zA-Z_-]{20})\b`},
+	{ID: "slack-token", Regex: `\b(xox[baprs]-[0-9a-zA-Z-]{10,})\b`},
+	{ID: "slack-webhook", Regex: `(https://hooks\.slack\.com/services/[A-Za-z0-9+/]{43,})`},
+	{ID: "google-api-key", Regex: `\b(AIza[0-9A-Za-z_-]{35})\b`},
+	{ID: "stripe-secret-key", Regex: `\b((?:sk|rk)_live_[0-9a-zA-Z]{24,})\b`},
+	{ID: "generic-api-key", Regex: `(?i)(?:api[_-]?key|secret|token|passw(?:or)?d)["']?\s*[:=]\s*["']([0-9a-zA-Z_\-+/=]{20,})["']`},
+}
+
+// SecretScanner finds credentials in the lines commits add
+type SecretScanner struct {
+	Rules []SecretRule
+}
+
+// NewSecretScanner compiles rules
+func NewSecretScanner(rules []SecretRule) (*SecretScanner, error) {
+	s := &SecretScanner{}
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex of secret rule %s: %w", rule.ID, err)
+		}
+		rule.re = re
+		s.Rules = append(s.Rules, rule)
+	}
+	return s, nil
+}
+
+// SecretScannerFromEnv returns a scanner when SECRET_SCAN is true, nil
+// otherwise. SECRET_SCAN_RULES names a YAML file of further rules, a list
+// of id and regex pairs, checked along with DefaultSecretRules.
+func SecretScannerFromEnv() (*SecretScanner, error) {
+	if os.Getenv("SECRET_SCAN") != "true" {
+		return nil, nil
+	}
+	rules := DefaultSecretRules
+	if path := os.Getenv("SECRET_SCAN_RULES"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SECRET_SCAN_RULES: %w", err)
+		}
+		var extra []SecretRule
+		if err := yaml.UnmarshalStrict(data, &extra); err != nil {
+			return nil, fmt.Errorf("invalid SECRET_SCAN_RULES: %w", err)
+		}
+		for _, rule := range extra {
+			if rule.ID == "" || rule.Regex == "" {
+				return nil, fmt.Errorf("invalid SECRET_SCAN_RULES: every rule needs an id and a regex")
+			}
+		}
+		rules = append(rules[:len(rules):len(rules)], extra...)
+	}
+	return NewSecretScanner(rules)
+}
+
+// maxScannedLine bounds the length of an added line that is scanned, so
+// minified or generated files do not stall the scan
+const maxScannedLine = 4096
+
+// SecretError is returned when the commits about to be pushed hold
+// possible credentials that were not allowed
+type SecretError struct {
+	Findings []models.SecretFinding
+}
+
+func (e *SecretError) Error() string {
+	f := e.Findings[0]
+	return fmt.Sprintf("sync quarantined: %d possible secrets in new commits, first %s in %s:%d of commit %.12s",
+		len(e.Findings), f.Rule, f.Path, f.Line, f.Commit)
+}
+
+// scanSecrets scans the lines added by the commits reachable from the
+// refs about to be updated but not from those last pushed. Findings
+// allowed before are ignored. A partial mirror fetches the blobs it lacks
+// from the source with auth.
+func (p *Pusher) scanSecrets(ctx context.Context, repoID, dir string, pushed map[string]string, refspecs []string, auth *git.Auth) error {
+	var revs strings.Builder
+	for _, spec := range refspecs {
+		if spec[0] == ':' {
+			continue
+		}
+		ref, _, _ := strings.Cut(spec[1:], ":")
+		revs.WriteString(ref + "\n")
+	}
+	for _, sha := range pushed {
+		revs.WriteString("^" + sha + "\n")
+	}
+	// Tips last pushed may be gone from the mirror after a force push
+	out, err := p.Git.Run(ctx, git.Command{Dir: dir, Auth: auth, Stdin: strings.NewReader(revs.String()), Args: []string{
+		"log", "--ignore-missing", "--stdin", "--no-merges", "--format=commit %H",
+		"--patch", "--unified=0", "--no-color", "--no-ext-diff", "--no-renames",
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to read new commits for secret scanning: %w", err)
+	}
+	findings := p.Secrets.Scan(out)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	rows, err := p.DB.QueryContext(ctx,
+		`SELECT fingerprint FROM secret_findings WHERE repository_id = $1 AND allowed`, repoID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch allowed secret findings: %w", err)
+	}
+	defer rows.Close()
+	allowed := make(map[string]bool)
+	for rows.Next() {
+		var fingerprint string
+		if err := rows.Scan(&fingerprint); err != nil {
+			return fmt.Errorf("failed to scan allowed secret finding: %w", err)
+		}
+		allowed[fingerprint] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch allowed secret findings: %w", err)
+	}
+
+	var blocking []models.SecretFinding
+	for _, f := range findings {
+		if !allowed[f.Fingerprint] {
+			f.RepositoryID = repoID
+			blocking = append(blocking, f)
+		}
+	}
+	if len(blocking) == 0 {
+		return nil
+	}
+	return &SecretError{Findings: blocking}
+}
+
+// Scan returns the findings in the output of git log --patch --unified=0
+// with commits introduced by "commit <sha>" lines
+func (s *SecretScanner) Scan(log []byte) []models.SecretFinding {
+	var findings []models.SecretFinding
+	var commit, path string
+	line := 0
+	// header is set between a diff --git line and the first hunk of a file
+	header := false
+	sc := bufio.NewScanner(bytes.NewReader(log))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		text := sc.Text()
+		switch {
+		case strings.HasPrefix(text, "commit "):
+			commit, path = strings.TrimPrefix(text, "commit "), ""
+		case strings.HasPrefix(text, "diff --git "):
+			header, path = true, ""
+		case header && strings.HasPrefix(text, "+++ "):
+			// Deleted files have no new path; names with spaces end in a
+			// tab and unusual names are quoted
+			name := strings.TrimSuffix(strings.TrimPrefix(text, "+++ "), "\t")
+			if unquoted, err := strconv.Unquote(name); err == nil {
+				name = unquoted
+			}
+			if name != "/dev/null" {
+				path = strings.TrimPrefix(name, "b/")
+			}
+		case strings.HasPrefix(text, "@@ "):
+			header = false
+			// @@ -old[,n] +new[,n] @@
+			fields := strings.Fields(text)
+			if len(fields) >= 3 {
+				start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+				line, _ = strconv.Atoi(start)
+			}
+		case !header && strings.HasPrefix(text, "+") && path != "":
+			added := text[1:]
+			if len(added) > maxScannedLine {
+				added = added[:maxScannedLine]
+			}
+			for _, rule := range s.Rules {
+				m := rule.re.FindStringSubmatch(added)
+				if m == nil {
+					continue
+				}
+				secret := m[0]
+				if len(m) > 1 && m[1] != "" {
+					secret = m[1]
+				}
+				findings = append(findings, models.SecretFinding{
+					Rule: rule.ID, Commit: commit, Path: path, Line: line,
+					Match: mask(secret), Fingerprint: fingerprint(rule.ID, commit, path, line),
+				})
+			}
+			line++
+		}
+	}
+	return findings
+}
+
+// mask keeps the first four characters of secret, enough to recognize it
+// without disclosing it
+func mask(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", min(len(secret)-4, 16))
+}
+
+// fingerprint identifies a finding by where it was made
+func fingerprint(rule, commit, path string, line int) string {
+	sum := sha256.Sum256([]byte(rule + "\x00" + commit + "\x00" + path + "\x00" + strconv.Itoa(line)))
+	return hex.EncodeToString(sum[:])
+}
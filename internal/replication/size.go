@@ -0,0 +1,93 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// sizePollInterval is how often the cache is measured while fetching
+const sizePollInterval = time.Second
+
+// SizeError is returned when a repository is larger than the size cap
+type SizeError struct {
+	Size  int64
+	Limit int64
+	// Source is how the size was determined: provider API or fetch
+	Source string
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("repository size %s exceeds the cap of %s (per %s)", formatBytes(e.Size), formatBytes(e.Limit), e.Source)
+}
+
+func formatBytes(n int64) string {
+	const gb = 1 << 30
+	if n >= gb {
+		return fmt.Sprintf("%.1f GB", float64(n)/gb)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
+
+// limitSize runs fetch while measuring dir, and cancels it once dir grows
+// beyond limit. A limit of 0 disables the check.
+func limitSize(ctx context.Context, dir string, limit int64, fetch func(context.Context) error) error {
+	if limit <= 0 {
+		return fetch(ctx)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(sizePollInterval)
+		defer ticker.Stop()
+		for {
+			if size := dirSize(dir); size > limit {
+				cancel(&SizeError{Size: size, Limit: limit, Source: "fetch"})
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	err := fetch(ctx)
+	close(done)
+	var sizeErr *SizeError
+	if errors.As(context.Cause(ctx), &sizeErr) {
+		return sizeErr
+	}
+	if err != nil {
+		return err
+	}
+	// Fetches faster than the polling are caught afterwards
+	if size := dirSize(dir); size > limit {
+		return &SizeError{Size: size, Limit: limit, Source: "fetch"}
+	}
+	return nil
+}
+
+// dirSize returns the total size of the files below dir; files removed
+// while walking are skipped
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
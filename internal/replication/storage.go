@@ -0,0 +1,180 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/git"
+	"gitsync/internal/objectstore"
+)
+
+// CacheStorage keeps the state of cached mirrors. Git always works on the
+// mirrors below the local cache directory; storage beyond it lets workers
+// whose cache directory is empty, such as fresh pods, rebuild mirrors from
+// it instead of cloning their sources from scratch.
+type CacheStorage interface {
+	// Load downloads the saved copy of the mirror of a repository to a
+	// bundle file and returns its path, or "" when there is none. The
+	// caller removes the file.
+	Load(ctx context.Context, repoID string) (string, error)
+	// Save keeps a copy of the mirror of a repository at dir
+	Save(ctx context.Context, repoID, dir string) error
+	// Remove drops the copy of the mirror of a deleted repository
+	Remove(ctx context.Context, repoID string) error
+}
+
+// LocalStorage keeps mirrors in the local cache directory only, which has
+// to persist across restarts for them to survive
+type LocalStorage struct{}
+
+// Load implements CacheStorage; there is never a copy to load
+func (LocalStorage) Load(context.Context, string) (string, error) { return "", nil }
+
+// Save implements CacheStorage; the mirror is its own copy
+func (LocalStorage) Save(context.Context, string, string) error { return nil }
+
+// Remove implements CacheStorage; the mirror is removed by retention
+func (LocalStorage) Remove(context.Context, string) error { return nil }
+
+// BundleStorage stages a bundle of every mirror in object storage, under
+// <repository id>.bundle. A mirror is saved after a sync fetched into it,
+// at most once per Interval per worker, so a rebuilt mirror lags its
+// source by up to that and the next fetch catches it up.
+type BundleStorage struct {
+	Store    objectstore.Store
+	Git      *git.Runner
+	Interval time.Duration
+	Clock    clock.Clock
+
+	mu    sync.Mutex
+	saved map[string]time.Time
+}
+
+// NewBundleStorage creates a BundleStorage
+func NewBundleStorage(store objectstore.Store, runner *git.Runner, interval time.Duration, clk clock.Clock) *BundleStorage {
+	return &BundleStorage{Store: store, Git: runner, Interval: interval, Clock: clk, saved: make(map[string]time.Time)}
+}
+
+func bundleKey(repoID string) string {
+	return repoID + ".bundle"
+}
+
+// Load implements CacheStorage
+func (s *BundleStorage) Load(ctx context.Context, repoID string) (string, error) {
+	body, err := s.Store.Open(ctx, bundleKey(repoID))
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	f, err := os.CreateTemp("", "gitsync-mirror-*.bundle")
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to download mirror bundle: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// Save implements CacheStorage; mirrors saved within Interval are skipped
+func (s *BundleStorage) Save(ctx context.Context, repoID, dir string) error {
+	now := s.Clock.Now()
+	s.mu.Lock()
+	if last, ok := s.saved[repoID]; ok && now.Sub(last) < s.Interval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	tmp, err := os.CreateTemp("", "gitsync-mirror-*.bundle")
+	if err != nil {
+		return fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	// git replaces the file rather than writing to it, so it is opened
+	// only once written
+	if _, err := s.Git.Run(ctx, git.Command{Dir: dir, Args: []string{"bundle", "create", "--quiet", tmp.Name(), "--all"}}); err != nil {
+		return err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if _, err := s.Store.Put(ctx, bundleKey(repoID), f, size, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.saved[repoID] = now
+	s.mu.Unlock()
+	return nil
+}
+
+// Remove implements CacheStorage
+func (s *BundleStorage) Remove(ctx context.Context, repoID string) error {
+	s.mu.Lock()
+	delete(s.saved, repoID)
+	s.mu.Unlock()
+	err := s.Store.Delete(ctx, bundleKey(repoID))
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// restore seeds a mirror missing from the cache directory from the copy
+// in p.Storage, so only what changed since is fetched from the source.
+// Failures only cost the head start; the source is fetched either way.
+func (p *Pusher) restore(ctx context.Context, repoID string, seed func(bundle string) error) {
+	bundle, err := p.Storage.Load(ctx, repoID)
+	if err != nil {
+		log.Printf("WARN: failed to load stored mirror of repository %s: %v", repoID, err)
+		return
+	}
+	if bundle == "" {
+		return
+	}
+	defer os.Remove(bundle)
+	if err := seed(bundle); err != nil {
+		log.Printf("WARN: failed to restore mirror of repository %s: %v", repoID, err)
+		return
+	}
+	log.Printf("Restored mirror of repository %s from cache storage", repoID)
+}
+
+// save keeps a copy of the freshly fetched mirror of a repository in
+// p.Storage; syncs do not fail on it
+func (p *Pusher) save(ctx context.Context, repoID, dir string) {
+	if err := p.Storage.Save(ctx, repoID, dir); err != nil && ctx.Err() == nil {
+		log.Printf("WARN: failed to store mirror of repository %s: %v", repoID, err)
+	}
+}
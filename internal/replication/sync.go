@@ -0,0 +1,332 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/deploykeys"
+	"gitsync/internal/git"
+	"gitsync/internal/ids"
+	"gitsync/internal/metrics"
+	"gitsync/internal/models"
+	"gitsync/internal/policy"
+	"gitsync/internal/provider"
+	"gitsync/internal/stats"
+	"gitsync/internal/tracing"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Syncer replicates a repository to each of its approved targets in
+// priority order and records an execution per target
+type Syncer struct {
+	DB          *database.DB
+	Pusher      *Pusher
+	Credentials *credentials.Store
+	// Client reports source sizes ahead of fetching when the size cap of
+	// Pusher is set
+	Client   *provider.Client
+	Policies *policy.Engine
+	// DeployKeys holds the keys SSH targets authenticate with
+	DeployKeys *deploykeys.Manager
+	// Retry sets how failed syncs of a target are retried
+	Retry RetryConfig
+	Clock clock.Clock
+	IDs   ids.Generator
+}
+
+// NewSyncer creates a Syncer
+func NewSyncer(db *database.DB, pusher *Pusher, creds *credentials.Store, client *provider.Client, policies *policy.Engine, keys *deploykeys.Manager, retry RetryConfig, clk clock.Clock, gen ids.Generator) *Syncer {
+	return &Syncer{DB: db, Pusher: pusher, Credentials: creds, Client: client, Policies: policies, DeployKeys: keys, Retry: retry, Clock: clk, IDs: gen}
+}
+
+// TargetResult is the outcome of one target of a sync
+type TargetResult struct {
+	TargetID    string `json:"target_id"`
+	ExecutionID string `json:"execution_id"`
+	Required    bool   `json:"required"`
+	// Source is the source URL the target was updated from
+	Source  string   `json:"source,omitempty"`
+	Skipped bool     `json:"skipped"`
+	Updated []string `json:"updated,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+	Error   string   `json:"error,omitempty"`
+
+	err error
+}
+
+// Outcome summarizes the sync of a repository
+type Outcome struct {
+	Targets []TargetResult `json:"targets"`
+	// Success is set when every required target succeeded; best-effort
+	// targets may fail without failing the sync
+	Success bool `json:"success"`
+}
+
+// Sync pushes repo to its approved targets, or those among the target IDs
+// of run when set, lowest priority first, and records their executions
+// with run and its trigger. Dead-lettered targets are left out unless run
+// names them. A failed target does not stop the others. The error is only
+// set when the sync could not start at all.
+func (s *Syncer) Sync(ctx context.Context, repo models.Repository, run models.SyncRun) (Outcome, error) {
+	out := Outcome{Targets: []TargetResult{}, Success: true}
+
+	targets, err := s.targets(ctx, repo.ID)
+	if err != nil {
+		return out, err
+	}
+	if len(run.TargetIDs) > 0 {
+		targets = slices.DeleteFunc(targets, func(t models.Target) bool { return !slices.Contains(run.TargetIDs, t.ID) })
+	} else {
+		targets = slices.DeleteFunc(targets, func(t models.Target) bool { return t.DeadLetteredAt != nil })
+	}
+	policies, err := s.Policies.Enabled(ctx)
+	if err != nil {
+		return out, err
+	}
+	sourceAuth, err := s.Auth(ctx, repo.CredentialID, repo.SourceProvider)
+	if err != nil {
+		return out, fmt.Errorf("failed to resolve source credential: %w", err)
+	}
+
+	// Once the repository is known to be too large the remaining targets
+	// fail without fetching it again
+	var tooLarge *SizeError
+	if len(targets) > 0 {
+		tooLarge = s.checkSize(ctx, repo)
+	}
+	// Likewise once an anomaly pauses the repository
+	var paused *AnomalyError
+	if len(targets) > 0 && s.Pusher.Anomalies != nil {
+		if paused, err = s.Pusher.Anomalies.Open(ctx, repo.ID); err != nil {
+			return out, err
+		}
+	}
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return out, ctx.Err()
+		}
+		var blocked error
+		switch {
+		case tooLarge != nil:
+			blocked = tooLarge
+		case paused != nil:
+			blocked = paused
+		}
+		res := s.syncTarget(ctx, repo, target, run, policies, sourceAuth, blocked)
+		errors.As(res.err, &tooLarge)
+		errors.As(res.err, &paused)
+		if res.Error != "" && target.Required {
+			out.Success = false
+		}
+		out.Targets = append(out.Targets, res)
+	}
+	s.flagSize(ctx, repo.ID, tooLarge, out.Success)
+	return out, nil
+}
+
+// Mirror refreshes the mirror of repo with its source credential and
+// returns its path
+func (s *Syncer) Mirror(ctx context.Context, repo models.Repository) (string, error) {
+	sourceAuth, err := s.Auth(ctx, repo.CredentialID, repo.SourceProvider)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve source credential: %w", err)
+	}
+	return s.Pusher.Mirror(ctx, repo, sourceAuth)
+}
+
+// checkSize compares the size of the source reported by its provider with
+// the size cap. Sources whose provider does not answer are left to the
+// check during the fetch.
+func (s *Syncer) checkSize(ctx context.Context, repo models.Repository) *SizeError {
+	if s.Pusher.MaxSize <= 0 || s.Client == nil {
+		return nil
+	}
+	token, err := s.Credentials.Resolve(ctx, repo.CredentialID, repo.SourceProvider)
+	if err != nil || token == "" {
+		return nil
+	}
+	meta, err := s.Client.GetRepository(ctx, repo.SourceProvider, repo.SourceURL, token)
+	if err != nil {
+		log.Printf("WARN: failed to fetch size of repository %s: %v", repo.ID, err)
+		return nil
+	}
+	if size := meta.SizeKB * 1024; size > s.Pusher.MaxSize {
+		return &SizeError{Size: size, Limit: s.Pusher.MaxSize, Source: "provider API"}
+	}
+	return nil
+}
+
+// flagSize flags a repository found too large, and clears the flag once a
+// sync succeeds
+func (s *Syncer) flagSize(ctx context.Context, repoID string, tooLarge *SizeError, success bool) {
+	var err error
+	switch {
+	case tooLarge != nil:
+		log.Printf("WARN: repository %s flagged: %v", repoID, tooLarge)
+		_, err = s.DB.ExecContext(context.WithoutCancel(ctx),
+			`UPDATE repositories SET size_exceeded_at = $2, size_bytes = $3 WHERE id = $1`,
+			repoID, s.Clock.Now(), tooLarge.Size)
+	case success:
+		_, err = s.DB.ExecContext(ctx,
+			`UPDATE repositories SET size_exceeded_at = NULL WHERE id = $1 AND size_exceeded_at IS NOT NULL`, repoID)
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to update size flag of repository %s: %v", repoID, err)
+	}
+}
+
+// targets returns the approved targets of a repository in push order
+func (s *Syncer) targets(ctx context.Context, repoID string) ([]models.Target, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT id, repository_id, provider, remote_url, credential_id, priority, required, exclude_refs, mode, tag_pattern,
+		        annotated_tags_only, subdirectory, rewrite, http_headers, protected, dead_lettered_at
+		 FROM replication_targets
+		 WHERE repository_id = $1 AND approval_state = $2
+		 ORDER BY priority, created_at`, repoID, models.ApprovalApproved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []models.Target
+	for rows.Next() {
+		var t models.Target
+		if err := rows.Scan(&t.ID, &t.RepositoryID, &t.Provider, &t.RemoteURL, &t.CredentialID, &t.Priority, &t.Required,
+			pq.Array(&t.ExcludeRefs), &t.Mode, &t.TagPattern, &t.AnnotatedTagsOnly, &t.Subdirectory, &t.Rewrite, &t.HTTPHeaders, &t.Protected, &t.DeadLetteredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan target: %w", err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// syncTarget pushes repo to target and records the execution. A non-nil
+// blocked fails the target without pushing. A push to a protected target
+// held for approval leaves the execution awaiting it.
+func (s *Syncer) syncTarget(ctx context.Context, repo models.Repository, target models.Target, run models.SyncRun, policies []models.Policy, sourceAuth *git.Auth, blocked error) TargetResult {
+	res := TargetResult{TargetID: target.ID, ExecutionID: s.IDs.NewID(), Required: target.Required}
+	ctx, span := tracing.Start(ctx, "sync target",
+		attribute.String("gitsync.target_id", target.ID),
+		attribute.String("gitsync.execution_id", res.ExecutionID))
+	defer func() { tracing.End(span, res.err) }()
+	started := s.Clock.Now()
+	if _, err := s.DB.ExecContext(ctx,
+		`INSERT INTO executions (id, repository_id, target_id, status, trigger_reason, run_id, started_at)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, '')::uuid, $7)`,
+		res.ExecutionID, repo.ID, target.ID, models.ExecutionRunning, run.Trigger, run.ID, started); err != nil {
+		res.Error = fmt.Sprintf("failed to record execution: %v", err)
+		return res
+	}
+
+	var sent int64
+	err := blocked
+	if err == nil {
+		err = s.Policies.EnforceWith(ctx, policy.StageSync, policies, policy.Subject{Repository: repo, Target: &target})
+	}
+	if err == nil {
+		var targetAuth *git.Auth
+		targetAuth, err = s.TargetAuth(ctx, target)
+		var approval models.PushApproval
+		if err == nil && target.Protected {
+			approval, err = s.approved(ctx, target.ID)
+		}
+		if err == nil {
+			var pushed Result
+			pushed, err = s.Pusher.Push(ctx, repo, target, sourceAuth, targetAuth, approval.Fingerprint)
+			res.Source, res.Skipped, res.Updated, res.Deleted = pushed.Source, pushed.Skipped, pushed.Updated, pushed.Deleted
+			sent = pushed.Bytes
+			if err == nil && pushed.Approved {
+				s.executeApproval(ctx, repo.ID, target.ID, res.ExecutionID, approval)
+			}
+		}
+	}
+
+	var message *string
+	var held *ApprovalError
+	if errors.As(err, &held) {
+		if herr := s.holdForApproval(ctx, repo.ID, target.ID, res.ExecutionID, held); herr != nil {
+			err, held = herr, nil
+		}
+	}
+	// A provider rate limit throttles the execution until it resets
+	status, throttledUntil := provider.ExecutionOutcome(err)
+	if err != nil {
+		if held != nil && held.RejectedBy == "" {
+			status = models.ExecutionAwaitingApproval
+		}
+		res.Error, res.err = err.Error(), err
+		message = &res.Error
+		log.Printf("WARN: sync of repository %s to target %s failed: %v", repo.ID, target.ID, err)
+		var integrity *IntegrityError
+		if errors.As(err, &integrity) {
+			s.recordFinding(ctx, repo.ID, target.ID, res.ExecutionID, integrity)
+		}
+		var secrets *SecretError
+		if errors.As(err, &secrets) {
+			s.recordSecrets(ctx, target.ID, res.ExecutionID, secrets)
+		}
+	}
+	var source *string
+	if res.Source != "" {
+		source = &res.Source
+	}
+	var retryAt *time.Time
+	if status != models.ExecutionAwaitingApproval {
+		retryAt = s.recordAttempt(ctx, target, err)
+	}
+	if retryAt == nil {
+		retryAt = throttledUntil
+	}
+	// The record is written even when ctx was cancelled mid-push
+	finished := s.Clock.Now()
+	if _, err := s.DB.ExecContext(context.WithoutCancel(ctx),
+		`UPDATE executions SET status = $2, error = $3, source_url = $4, retry_at = $5, finished_at = $6 WHERE id = $1`,
+		res.ExecutionID, status, message, source, retryAt, finished); err != nil {
+		log.Printf("ERROR: failed to finish execution %s: %v", res.ExecutionID, err)
+	}
+	if err := stats.Record(context.WithoutCancel(ctx), s.DB, repo.ID, status, sent, started, finished); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+	metrics.ObserveTargetSync(repo.ID, target.ID, status, finished.Sub(started))
+	return res
+}
+
+// recordFinding stores corruption found while syncing a target
+func (s *Syncer) recordFinding(ctx context.Context, repoID, targetID, executionID string, found *IntegrityError) {
+	if _, err := s.DB.ExecContext(context.WithoutCancel(ctx),
+		`INSERT INTO integrity_findings (id, repository_id, target_id, execution_id, kind, detail, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		s.IDs.NewID(), repoID, targetID, executionID, found.Kind, found.Detail, s.Clock.Now()); err != nil {
+		log.Printf("ERROR: failed to record integrity finding for repository %s: %v", repoID, err)
+	}
+}
+
+// recordSecrets stores the possible credentials that quarantined a sync.
+// Findings already stored, by an earlier sync or another target, are kept
+// as they are.
+func (s *Syncer) recordSecrets(ctx context.Context, targetID, executionID string, found *SecretError) {
+	for _, f := range found.Findings {
+		if _, err := s.DB.ExecContext(context.WithoutCancel(ctx),
+			`INSERT INTO secret_findings (id, repository_id, target_id, execution_id, rule, commit_sha, path, line, match, fingerprint, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			 ON CONFLICT (repository_id, fingerprint) DO NOTHING`,
+			s.IDs.NewID(), f.RepositoryID, targetID, executionID, f.Rule, f.Commit, f.Path, f.Line, f.Match, f.Fingerprint, s.Clock.Now()); err != nil {
+			log.Printf("ERROR: failed to record secret finding for repository %s: %v", f.RepositoryID, err)
+			return
+		}
+	}
+}
+
+// Auth returns the git credentials for a resource of the given provider
+func (s *Syncer) Auth(ctx context.Context, credentialID *string, providerName string) (*git.Auth, error) {
+	return s.Credentials.GitAuth(ctx, credentialID, providerName)
+}
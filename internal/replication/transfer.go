@@ -0,0 +1,113 @@
+package replication
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"gitsync/internal/models"
+)
+
+// Negotiation algorithms of fetches
+var negotiations = []string{"consecutive", "skipping", "noop"}
+
+var sizePattern = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// TransferFromEnv reads the global transfer settings from
+// TRANSFER_PROTOCOL_VERSION, TRANSFER_NEGOTIATION, TRANSFER_PACK_THREADS,
+// TRANSFER_PACK_WINDOW and TRANSFER_PACK_WINDOW_MEMORY; unset ones are
+// left to git
+func TransferFromEnv() (models.Transfer, error) {
+	var t models.Transfer
+	for name, field := range map[string]**int{
+		"TRANSFER_PROTOCOL_VERSION": &t.ProtocolVersion,
+		"TRANSFER_PACK_THREADS":     &t.PackThreads,
+		"TRANSFER_PACK_WINDOW":      &t.PackWindow,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return t, fmt.Errorf("%s must be a number", name)
+		}
+		*field = &n
+	}
+	if v := os.Getenv("TRANSFER_NEGOTIATION"); v != "" {
+		t.Negotiation = &v
+	}
+	if v := os.Getenv("TRANSFER_PACK_WINDOW_MEMORY"); v != "" {
+		t.PackWindowMemory = &v
+	}
+	if err := ValidateTransfer(t); err != nil {
+		return t, fmt.Errorf("invalid transfer setting: %w", err)
+	}
+	return t, nil
+}
+
+// ValidateTransfer checks the settings t sets
+func ValidateTransfer(t models.Transfer) error {
+	if v := t.ProtocolVersion; v != nil && (*v < 0 || *v > 2) {
+		return fmt.Errorf("protocol_version must be 0, 1 or 2")
+	}
+	if v := t.Negotiation; v != nil && !slices.Contains(negotiations, *v) {
+		return fmt.Errorf("unsupported negotiation %q. allowed: %v", *v, negotiations)
+	}
+	if v := t.PackThreads; v != nil && *v < 0 {
+		return fmt.Errorf("pack_threads must not be negative")
+	}
+	if v := t.PackWindow; v != nil && *v < 0 {
+		return fmt.Errorf("pack_window must not be negative")
+	}
+	if v := t.PackWindowMemory; v != nil && !sizePattern.MatchString(*v) {
+		return fmt.Errorf("pack_window_memory must be a size such as 256m, got %q", *v)
+	}
+	return nil
+}
+
+// EffectiveTransfer applies overrides on top of the defaults
+func EffectiveTransfer(defaults, overrides models.Transfer) models.Transfer {
+	t := defaults
+	if overrides.ProtocolVersion != nil {
+		t.ProtocolVersion = overrides.ProtocolVersion
+	}
+	if overrides.Negotiation != nil {
+		t.Negotiation = overrides.Negotiation
+	}
+	if overrides.PackThreads != nil {
+		t.PackThreads = overrides.PackThreads
+	}
+	if overrides.PackWindow != nil {
+		t.PackWindow = overrides.PackWindow
+	}
+	if overrides.PackWindowMemory != nil {
+		t.PackWindowMemory = overrides.PackWindowMemory
+	}
+	return t
+}
+
+// TransferConfig returns the git configuration applying the settings t
+// sets. Pack settings apply both to packing pushes and to indexing
+// fetched packs.
+func TransferConfig(t models.Transfer) map[string]string {
+	config := make(map[string]string)
+	if t.ProtocolVersion != nil {
+		config["protocol.version"] = strconv.Itoa(*t.ProtocolVersion)
+	}
+	if t.Negotiation != nil {
+		config["fetch.negotiationAlgorithm"] = *t.Negotiation
+	}
+	if t.PackThreads != nil {
+		config["pack.threads"] = strconv.Itoa(*t.PackThreads)
+	}
+	if t.PackWindow != nil {
+		config["pack.window"] = strconv.Itoa(*t.PackWindow)
+	}
+	if t.PackWindowMemory != nil {
+		config["pack.windowMemory"] = *t.PackWindowMemory
+	}
+	return config
+}
@@ -0,0 +1,89 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gitsync/internal/git"
+	"gitsync/internal/models"
+)
+
+// Mirror verification modes
+const (
+	VerifyOff = ""
+	// VerifyConnectivity checks that every ref of a mirror reaches a
+	// complete history, without hashing blob contents
+	VerifyConnectivity = "connectivity"
+	// VerifyFull hashes and checks every object of a mirror
+	VerifyFull = "full"
+)
+
+// ValidateVerify checks a mirror verification mode
+func ValidateVerify(mode string) error {
+	switch mode {
+	case VerifyOff, VerifyConnectivity, VerifyFull:
+		return nil
+	}
+	return fmt.Errorf("unsupported verification mode %q. allowed: connectivity, full", mode)
+}
+
+// IntegrityError is returned when verification finds a corrupt mirror or a
+// target that does not hold what was pushed
+type IntegrityError struct {
+	// Kind is one of the models.Finding kinds
+	Kind   string
+	Detail string
+}
+
+func (e *IntegrityError) Error() string {
+	return "integrity check failed (" + e.Kind + "): " + e.Detail
+}
+
+// fsck checks the objects of the mirror at dir. The mirror is kept as is
+// on failure so the corruption can be inspected.
+func (p *Pusher) fsck(ctx context.Context, dir string) error {
+	args := []string{"fsck", "--no-progress", "--no-dangling"}
+	if p.Verify == VerifyConnectivity {
+		args = append(args, "--connectivity-only")
+	}
+	if _, err := p.Git.Run(ctx, git.Command{Dir: dir, Args: args}); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &IntegrityError{Kind: models.FindingMirrorCorrupt, Detail: err.Error()}
+	}
+	return nil
+}
+
+// verifyPushed lists the refs of the target and compares the object IDs of
+// those just pushed with the source. Git object IDs hash their content and
+// history, so matching tips mean the target received the same data.
+func (p *Pusher) verifyPushed(ctx context.Context, target models.Target, source map[string]string, refspecs []string, auth *git.Auth) error {
+	remote, err := p.Git.LsRemote(ctx, target.RemoteURL, auth)
+	if err != nil {
+		return fmt.Errorf("failed to list target refs for verification: %w", err)
+	}
+	var mismatched []string
+	for _, spec := range refspecs {
+		if ref, ok := strings.CutPrefix(spec, ":"); ok {
+			if sha, exists := remote[ref]; exists {
+				mismatched = append(mismatched, fmt.Sprintf("%s still at %s after deletion", ref, sha))
+			}
+			continue
+		}
+		ref, _, _ := strings.Cut(spec[1:], ":")
+		if got := remote[ref]; got != source[ref] {
+			if got == "" {
+				got = "missing"
+			}
+			mismatched = append(mismatched, fmt.Sprintf("%s is %s, pushed %s", ref, got, source[ref]))
+		}
+	}
+	if len(mismatched) == 0 {
+		return nil
+	}
+	slices.Sort(mismatched)
+	return &IntegrityError{Kind: models.FindingTargetMismatch, Detail: strings.Join(mismatched, "; ")}
+}
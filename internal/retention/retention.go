@@ -0,0 +1,210 @@
+// Package retention deletes repository data older than its retention period.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/replication"
+	"gitsync/internal/trash"
+)
+
+// DefaultsFromEnv reads RETENTION_HISTORY_DAYS, RETENTION_LOG_DAYS and
+// RETENTION_CACHE_DAYS, defaulting to 90, 30 and 7 days
+func DefaultsFromEnv() (models.Retention, error) {
+	d := models.Retention{HistoryDays: 90, LogDays: 30, CacheDays: 7}
+	for name, field := range map[string]*int{
+		"RETENTION_HISTORY_DAYS": &d.HistoryDays,
+		"RETENTION_LOG_DAYS":     &d.LogDays,
+		"RETENTION_CACHE_DAYS":   &d.CacheDays,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return d, fmt.Errorf("%s must be a positive number of days", name)
+		}
+		*field = n
+	}
+	return d, nil
+}
+
+// Effective applies overrides on top of the defaults
+func Effective(defaults models.Retention, o models.RetentionOverrides) models.Retention {
+	r := defaults
+	if o.HistoryDays != nil {
+		r.HistoryDays = *o.HistoryDays
+	}
+	if o.LogDays != nil {
+		r.LogDays = *o.LogDays
+	}
+	if o.CacheDays != nil {
+		r.CacheDays = *o.CacheDays
+	}
+	return r
+}
+
+// Job enforces retention periods. Cached mirrors are expected at
+// CacheDir/<repository id>; whatever uses a mirror must update the
+// directory's modification time so it is not pruned while in use.
+type Job struct {
+	DB       *database.DB
+	Defaults models.Retention
+	CacheDir string
+	// Pools is pruned of members whose mirror was removed
+	Pools *replication.Pools
+	// Storage drops the copies of mirrors of deleted repositories
+	Storage replication.CacheStorage
+}
+
+// NewJob creates a retention Job; a nil storage keeps mirrors on local
+// disk only
+func NewJob(db *database.DB, defaults models.Retention, cacheDir string, pools *replication.Pools, storage replication.CacheStorage) *Job {
+	if storage == nil {
+		storage = replication.LocalStorage{}
+	}
+	return &Job{DB: db, Defaults: defaults, CacheDir: cacheDir, Pools: pools, Storage: storage}
+}
+
+// Enforce deletes expired history, clears expired logs, empties the trash
+// of expired entries and removes stale mirror caches
+func (j *Job) Enforce(ctx context.Context) error {
+	res, err := j.DB.ExecContext(ctx,
+		`DELETE FROM executions e USING repositories r
+		 WHERE e.repository_id = r.id
+		   AND e.started_at < NOW() - make_interval(days => COALESCE(r.history_retention_days, $1))`,
+		j.Defaults.HistoryDays)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired executions: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Retention: deleted %d expired executions", n)
+	}
+
+	res, err = j.DB.ExecContext(ctx,
+		`DELETE FROM sync_runs s USING repositories r
+		 WHERE s.repository_id = r.id
+		   AND s.created_at < NOW() - make_interval(days => COALESCE(r.history_retention_days, $1))`,
+		j.Defaults.HistoryDays)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired sync runs: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Retention: deleted %d expired sync runs", n)
+	}
+
+	res, err = j.DB.ExecContext(ctx,
+		`UPDATE executions e SET error = NULL FROM repositories r
+		 WHERE e.repository_id = r.id AND e.error IS NOT NULL
+		   AND e.started_at < NOW() - make_interval(days => COALESCE(r.log_retention_days, $1))`,
+		j.Defaults.LogDays)
+	if err != nil {
+		return fmt.Errorf("failed to clear expired logs: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Retention: cleared logs of %d executions", n)
+	}
+
+	n, err := trash.Purge(ctx, j.DB)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Retention: deleted %d expired trash entries", n)
+	}
+
+	return j.pruneCache(ctx)
+}
+
+// pruneCache removes mirror clones that have not been modified within the
+// cache retention of their repository, including those of deleted
+// repositories, whose copies in the cache storage are dropped as well.
+// Repositories in the trash keep the default retention.
+func (j *Job) pruneCache(ctx context.Context) error {
+	if j.CacheDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(j.CacheDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	rows, err := j.DB.QueryContext(ctx, `SELECT id, COALESCE(cache_retention_days, $1) FROM repositories
+		 UNION ALL SELECT resource_id, $1 FROM trash WHERE resource_type = 'repository'`, j.Defaults.CacheDays)
+	if err != nil {
+		return fmt.Errorf("failed to fetch cache retention: %w", err)
+	}
+	days := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var d int
+		if err := rows.Scan(&id, &d); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan cache retention: %w", err)
+		}
+		days[id] = d
+	}
+	rows.Close()
+
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == replication.PoolDir {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		d, ok := days[entry.Name()]
+		if !ok {
+			d = 0 // repository no longer exists
+		}
+		if time.Since(info.ModTime()) < time.Duration(d)*24*time.Hour {
+			continue
+		}
+		if !ok {
+			if err := j.Storage.Remove(ctx, entry.Name()); err != nil {
+				log.Printf("WARN: failed to remove stored mirror %s: %v", entry.Name(), err)
+				continue
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(j.CacheDir, entry.Name())); err != nil {
+			log.Printf("WARN: failed to remove cached mirror %s: %v", entry.Name(), err)
+			continue
+		}
+		log.Printf("Retention: removed cached mirror %s", entry.Name())
+	}
+
+	if j.Pools == nil {
+		return nil
+	}
+	return j.Pools.Prune(ctx)
+}
+
+// Run enforces retention periodically until ctx is cancelled
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.Enforce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: retention run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
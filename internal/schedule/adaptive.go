@@ -0,0 +1,334 @@
+// Package schedule decides when each repository is synced next.
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+)
+
+// Reasons for the interval chosen for a repository
+const (
+	ReasonNew     = "new"     // source activity unknown yet
+	ReasonHot     = "hot"     // source changed within HotWindow
+	ReasonDefault = "default" // normal activity
+	ReasonStale   = "stale"   // source unchanged for longer than StaleAfter
+	ReasonFixed   = "fixed"   // repository has its own sync interval
+	ReasonGroup   = "group"   // sync interval of the repository's group
+	// ReasonCron repositories have their own sync schedule, and
+	// ReasonDefaultCron ones follow the server-wide schedule
+	ReasonCron        = "cron"
+	ReasonDefaultCron = "default_cron"
+)
+
+// Adaptive stretches the sync interval of repositories whose source has
+// been quiet and tightens it for repositories that change often
+type Adaptive struct {
+	Base time.Duration
+	Min  time.Duration
+	Max  time.Duration
+	// HotWindow is how recent a change must be for the Min interval
+	HotWindow time.Duration
+	// StaleAfter is how long a source must be unchanged before its interval
+	// grows beyond Base, in proportion to the quiet time
+	StaleAfter time.Duration
+}
+
+// AdaptiveFromEnv reads SYNC_INTERVAL, SYNC_INTERVAL_MIN and
+// SYNC_INTERVAL_MAX, defaulting to 15m, 5m and 24h
+func AdaptiveFromEnv() (Adaptive, error) {
+	a := Adaptive{
+		Base:       15 * time.Minute,
+		Min:        5 * time.Minute,
+		Max:        24 * time.Hour,
+		HotWindow:  24 * time.Hour,
+		StaleAfter: 14 * 24 * time.Hour,
+	}
+	for name, field := range map[string]*time.Duration{
+		"SYNC_INTERVAL":     &a.Base,
+		"SYNC_INTERVAL_MIN": &a.Min,
+		"SYNC_INTERVAL_MAX": &a.Max,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return a, fmt.Errorf("%s must be a positive duration", name)
+		}
+		*field = d
+	}
+	if a.Min > a.Base || a.Base > a.Max {
+		return a, fmt.Errorf("sync intervals must satisfy SYNC_INTERVAL_MIN <= SYNC_INTERVAL <= SYNC_INTERVAL_MAX")
+	}
+	return a, nil
+}
+
+// Interval returns the effective interval for a source last changed at
+// changedAt, and why it was chosen
+func (a Adaptive) Interval(changedAt *time.Time, now time.Time) (time.Duration, string) {
+	if changedAt == nil {
+		return a.Base, ReasonNew
+	}
+	quiet := now.Sub(*changedAt)
+	switch {
+	case quiet < a.HotWindow:
+		return a.Min, ReasonHot
+	case quiet < a.StaleAfter:
+		return a.Base, ReasonDefault
+	}
+	stretched := time.Duration(float64(a.Base) * float64(quiet) / float64(a.StaleAfter))
+	return min(stretched, a.Max), ReasonStale
+}
+
+// Reasons scheduled syncs of a repository are held back
+const (
+	HeldDisabled    = "disabled"      // repository is disabled
+	HeldSourceState = "source_state"  // source is archived, gone or otherwise not active
+	HeldSize        = "size_exceeded" // repository was flagged as too large
+	HeldGroup       = "group_paused"  // a group of the repository is paused
+	HeldFreeze      = "freeze"        // a freeze period is in effect
+)
+
+// Plan is the computed schedule of one repository
+type Plan struct {
+	RepositoryID    string        `json:"repository_id"`
+	Interval        time.Duration `json:"-"`
+	IntervalSeconds int64         `json:"interval_seconds"`
+	Reason          string        `json:"reason"`
+	// Schedule is the cron expression syncs follow, if any; Interval is
+	// then the time between the next sync and the one after
+	Schedule        string     `json:"schedule,omitempty"`
+	SourceChangedAt *time.Time `json:"source_changed_at,omitempty"`
+	LastSyncAt      *time.Time `json:"last_sync_at,omitempty"`
+	NextSyncAt      time.Time  `json:"next_sync_at"`
+	// HeldBy is set when scheduled syncs will not start at NextSyncAt,
+	// to one of the Held reasons, and HeldDetail says more about it
+	HeldBy     string `json:"held_by,omitempty"`
+	HeldDetail string `json:"held_detail,omitempty"`
+}
+
+// Report is the plan of a repository with its latest executions, which
+// say what triggered its recent syncs
+type Report struct {
+	Plan
+	RecentExecutions []models.Execution `json:"recent_executions"`
+}
+
+// Planner computes plans from the recorded source activity and history
+type Planner struct {
+	DB       *database.DB
+	Adaptive Adaptive
+	// Window restricts when scheduled syncs of repositories without a
+	// window of their own may start; nil allows any time
+	Window *models.SyncWindow
+	// Cron schedules syncs of repositories without a schedule or interval
+	// of their own, or of a group, instead of the adaptive interval
+	Cron *Cron
+}
+
+// NewPlanner creates a Planner
+func NewPlanner(db *database.DB, adaptive Adaptive, window *models.SyncWindow, cron *Cron) *Planner {
+	return &Planner{DB: db, Adaptive: adaptive, Window: window, Cron: cron}
+}
+
+// planQuery selects the sync activity of repositories. A repository in
+// several groups takes the shortest group interval.
+const planQuery = `SELECT r.id, r.source_changed_at, r.sync_interval_seconds, r.sync_window, r.sync_schedule,
+	        (SELECT MIN(g.sync_interval_seconds) FROM sync_group_members m JOIN sync_groups g ON g.id = m.group_id
+	         WHERE m.repository_id = r.id),
+	        MAX(e.started_at)
+	 FROM repositories r LEFT JOIN executions e ON e.repository_id = r.id`
+
+const planGroupBy = ` GROUP BY r.id, r.source_changed_at, r.sync_interval_seconds, r.sync_window, r.sync_schedule`
+
+// scheduled restricts planQuery to enabled, active repositories outside
+// paused groups and not flagged as too large
+const scheduled = ` WHERE r.enabled AND r.source_state = $1 AND r.size_exceeded_at IS NULL
+	   AND NOT EXISTS (SELECT 1 FROM sync_group_members m JOIN sync_groups g ON g.id = m.group_id
+	                   WHERE m.repository_id = r.id AND g.paused)`
+
+// plan schedules a repository. Its own cron expression, then fixedSeconds,
+// then groupSeconds, then the server-wide cron expression override the
+// adaptive interval. A sync falling due outside window, or the global
+// window when nil, is put off until it opens. A scheduled sync missed
+// while the service was down runs once, right away.
+func (p *Planner) plan(id string, changedAt *time.Time, fixedSeconds, groupSeconds *int, expr *string, window *models.SyncWindow, lastSync *time.Time, now time.Time) Plan {
+	interval, reason := p.Adaptive.Interval(changedAt, now)
+	// Stored expressions were validated; one that no longer parses is
+	// ignored rather than stopping the repository's syncs
+	var own, cron *Cron
+	if expr != nil {
+		if c, err := ParseCron(*expr); err == nil {
+			own = &c
+		}
+	}
+	switch {
+	case own != nil:
+		cron, reason = own, ReasonCron
+	case fixedSeconds != nil:
+		interval, reason = time.Duration(*fixedSeconds)*time.Second, ReasonFixed
+	case groupSeconds != nil:
+		interval, reason = time.Duration(*groupSeconds)*time.Second, ReasonGroup
+	case p.Cron != nil:
+		cron, reason = p.Cron, ReasonDefaultCron
+	}
+	next := now
+	if lastSync != nil {
+		next = lastSync.Add(interval)
+	}
+	schedule := ""
+	if cron != nil {
+		if lastSync != nil {
+			next = cron.Next(*lastSync)
+		}
+		interval, schedule = cron.Next(next).Sub(next), cron.String()
+	}
+	if window == nil {
+		window = p.Window
+	}
+	if window != nil {
+		from := next
+		if from.Before(now) {
+			from = now
+		}
+		if open := NextOpen(*window, from); open.After(now) {
+			next = open
+		}
+	}
+	return Plan{
+		RepositoryID:    id,
+		Interval:        interval,
+		IntervalSeconds: int64(interval / time.Second),
+		Reason:          reason,
+		Schedule:        schedule,
+		SourceChangedAt: changedAt,
+		LastSyncAt:      lastSync,
+		NextSyncAt:      next,
+	}
+}
+
+// Due returns the plans of enabled, active repositories outside paused
+// groups, and not flagged as too large, whose next sync is at or before now
+func (p *Planner) Due(ctx context.Context, now time.Time) ([]Plan, error) {
+	rows, err := p.DB.QueryContext(ctx, planQuery+scheduled+planGroupBy, models.SourceActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sync activity: %w", err)
+	}
+	defer rows.Close()
+
+	var due []Plan
+	for rows.Next() {
+		plan, err := p.scan(rows, now)
+		if err != nil {
+			return nil, err
+		}
+		if !plan.NextSyncAt.After(now) {
+			due = append(due, plan)
+		}
+	}
+	return due, rows.Err()
+}
+
+// Open returns the IDs of the repositories Due considers whose sync
+// window, or the global window when they have none, is open at now. Syncs
+// started outside the schedule, such as retries, only start for these.
+func (p *Planner) Open(ctx context.Context, now time.Time) (map[string]bool, error) {
+	rows, err := p.DB.QueryContext(ctx, `SELECT r.id, r.sync_window FROM repositories r`+scheduled, models.SourceActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schedulable repositories: %w", err)
+	}
+	defer rows.Close()
+
+	open := map[string]bool{}
+	for rows.Next() {
+		var id string
+		var window *models.SyncWindow
+		if err := rows.Scan(&id, &window); err != nil {
+			return nil, fmt.Errorf("failed to scan schedulable repository: %w", err)
+		}
+		if window == nil {
+			window = p.Window
+		}
+		if window == nil || !NextOpen(*window, now).After(now) {
+			open[id] = true
+		}
+	}
+	return open, rows.Err()
+}
+
+// Plan returns the plan of the repository with the given ID, saying what
+// holds back its scheduled syncs if anything does. It returns
+// sql.ErrNoRows when there is no such repository.
+func (p *Planner) Plan(ctx context.Context, id string, now time.Time) (Plan, error) {
+	plan, err := p.scan(p.DB.QueryRowContext(ctx, planQuery+` WHERE r.id = $1`+planGroupBy, id), now)
+	if err != nil {
+		return plan, err
+	}
+
+	var state string
+	var enabled, tooLarge bool
+	var paused *string
+	err = p.DB.QueryRowContext(ctx,
+		`SELECT r.enabled, r.source_state, r.size_exceeded_at IS NOT NULL,
+		        (SELECT MIN(g.name) FROM sync_group_members m JOIN sync_groups g ON g.id = m.group_id
+		         WHERE m.repository_id = r.id AND g.paused)
+		 FROM repositories r WHERE r.id = $1`, id).Scan(&enabled, &state, &tooLarge, &paused)
+	if err != nil {
+		return plan, fmt.Errorf("failed to fetch repository state: %w", err)
+	}
+	switch {
+	case !enabled:
+		plan.HeldBy, plan.HeldDetail = HeldDisabled, "repository is disabled"
+	case state != models.SourceActive:
+		plan.HeldBy, plan.HeldDetail = HeldSourceState, "source is "+state
+	case tooLarge:
+		plan.HeldBy, plan.HeldDetail = HeldSize, "repository exceeds the size limit"
+	case paused != nil:
+		plan.HeldBy, plan.HeldDetail = HeldGroup, "group "+*paused+" is paused"
+	default:
+		freeze, err := ActiveFreeze(ctx, p.DB, now)
+		if err != nil {
+			return plan, err
+		}
+		if freeze != nil {
+			plan.HeldBy, plan.HeldDetail = HeldFreeze, fmt.Sprintf("freeze %s until %s", freeze.Name, freeze.EndsAt.Format(time.RFC3339))
+		}
+	}
+	return plan, nil
+}
+
+// scan plans the repository in a row of planQuery
+func (p *Planner) scan(row interface{ Scan(...any) error }, now time.Time) (Plan, error) {
+	var id string
+	var changedAt, lastSync *time.Time
+	var fixed, group *int
+	var window *models.SyncWindow
+	var expr *string
+	if err := row.Scan(&id, &changedAt, &fixed, &window, &expr, &group, &lastSync); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Plan{}, err
+		}
+		return Plan{}, fmt.Errorf("failed to scan sync activity: %w", err)
+	}
+	return p.plan(id, changedAt, fixed, group, expr, window, lastSync, now), nil
+}
+
+// RecordSourceRefs notes a change of the source when the hash of its refs
+// differs from the one recorded last
+func RecordSourceRefs(ctx context.Context, db *database.DB, repoID, refsHash string) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE repositories SET source_refs_hash = $2, source_changed_at = NOW()
+		 WHERE id = $1 AND source_refs_hash IS DISTINCT FROM $2`, repoID, refsHash)
+	if err != nil {
+		return fmt.Errorf("failed to record source activity: %w", err)
+	}
+	return nil
+}
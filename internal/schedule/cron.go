@@ -0,0 +1,197 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression: five fields for the minute, hour, day
+// of month, month and day of week, each a list of values, ranges and steps
+// such as 0,30 or 9-17 or */15, or a shorthand such as @daily. Months and
+// days of week may be given by name (jan, mon) and 7 is Sunday as well as
+// 0. When both days are restricted, a time matching either one matches,
+// as in crontab(5). A CRON_TZ=Europe/Berlin prefix evaluates the
+// expression in that timezone instead of UTC.
+type Cron struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	// anyDay is set when the day of month or day of week is *, so the
+	// other one decides alone
+	anyDay bool
+	loc    *time.Location
+}
+
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseCron parses a cron expression
+func ParseCron(expr string) (Cron, error) {
+	c := Cron{expr: strings.TrimSpace(expr), loc: time.UTC}
+	spec := c.expr
+	if rest, ok := strings.CutPrefix(spec, "CRON_TZ="); ok {
+		name, fields, _ := strings.Cut(rest, " ")
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return c, fmt.Errorf("invalid timezone %q", name)
+		}
+		c.loc, spec = loc, strings.TrimSpace(fields)
+	}
+	if full, ok := cronShorthands[strings.ToLower(spec)]; ok {
+		spec = full
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return c, errors.New("expected 5 fields: minute, hour, day of month, month and day of week")
+	}
+
+	var err error
+	if c.minute, err = cronField(fields[0], 0, 59, nil); err != nil {
+		return c, fmt.Errorf("invalid minute: %w", err)
+	}
+	if c.hour, err = cronField(fields[1], 0, 23, nil); err != nil {
+		return c, fmt.Errorf("invalid hour: %w", err)
+	}
+	if c.dom, err = cronField(fields[2], 1, 31, nil); err != nil {
+		return c, fmt.Errorf("invalid day of month: %w", err)
+	}
+	if c.month, err = cronField(fields[3], 1, 12, monthNames); err != nil {
+		return c, fmt.Errorf("invalid month: %w", err)
+	}
+	if c.dow, err = cronField(fields[4], 0, 7, dayNames); err != nil {
+		return c, fmt.Errorf("invalid day of week: %w", err)
+	}
+	// Sunday is 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+
+	// Such as the 30th of February
+	if c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return c, errors.New("expression never matches")
+	}
+	return c, nil
+}
+
+// cronField parses one field into a bit set of the values from lo to hi it
+// matches. Names, when given, stand for the values from lo on.
+func cronField(field string, lo, hi int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return lo + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("%q is not a value from %d to %d", s, lo, hi)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, stepText, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if span != "*" {
+			first, last, isRange := strings.Cut(span, "-")
+			var err error
+			if from, err = value(first); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = value(last); err != nil {
+					return 0, err
+				}
+				if to < from {
+					return 0, fmt.Errorf("range %q ends before it starts", span)
+				}
+			} else if stepped {
+				// 5/15 runs from 5 to the end
+				to = hi
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// CronFromEnv reads the cron expression that schedules syncs of
+// repositories without a schedule or interval of their own from
+// SYNC_SCHEDULE. It returns nil when unset, keeping the adaptive schedule.
+func CronFromEnv() (*Cron, error) {
+	raw := os.Getenv("SYNC_SCHEDULE")
+	if raw == "" {
+		return nil, nil
+	}
+	c, err := ParseCron(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SYNC_SCHEDULE: %w", err)
+	}
+	return &c, nil
+}
+
+// String returns the expression as given
+func (c Cron) String() string {
+	return c.expr
+}
+
+// Next returns the first minute after t matched by c, in the location of
+// t, or the zero time when c matches none within five years
+func (c Cron) Next(t time.Time) time.Time {
+	next := t.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		y, m, d := next.Date()
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			next = time.Date(y, m+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.day(next):
+			next = time.Date(y, m, d+1, 0, 0, 0, 0, c.loc)
+		case c.hour&(1<<uint(next.Hour())) == 0:
+			next = time.Date(y, m, d, next.Hour()+1, 0, 0, 0, c.loc)
+		case c.minute&(1<<uint(next.Minute())) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next.In(t.Location())
+		}
+	}
+	return time.Time{}
+}
+
+// day reports whether the day of t is matched
+func (c Cron) day(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,112 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "*/15 * * * *"},
+		{expr: "0 9-17 * * mon-fri"},
+		{expr: "0 0 1 jan,jul *"},
+		{expr: "30 2 * * 7"},
+		{expr: "5/20 * * * *"},
+		{expr: "@daily"},
+		{expr: "@Weekly"},
+		{expr: "CRON_TZ=Europe/Berlin 0 3 * * *"},
+		{expr: "  0 0 * * *  "},
+		{expr: "0 0 * *", err: "expected 5 fields"},
+		{expr: "@sometimes", err: "expected 5 fields"},
+		{expr: "60 * * * *", err: "invalid minute"},
+		{expr: "0 24 * * *", err: "invalid hour"},
+		{expr: "0 0 0 * *", err: "invalid day of month"},
+		{expr: "0 0 * foo *", err: "invalid month"},
+		{expr: "0 0 * * 8", err: "invalid day of week"},
+		{expr: "*/0 * * * *", err: "invalid step"},
+		{expr: "0 17-9 * * *", err: "ends before it starts"},
+		{expr: "0 0 30 feb *", err: "never matches"},
+		{expr: "CRON_TZ=Nowhere/Else 0 0 * * *", err: "invalid timezone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("ParseCron(%q) failed: %v", tt.expr, err)
+				}
+				if c.String() != strings.TrimSpace(tt.expr) {
+					t.Errorf("String() = %q, want %q", c.String(), strings.TrimSpace(tt.expr))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseCron(%q) error = %v, want one containing %q", tt.expr, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{name: "next step", expr: "*/15 * * * *", from: "2024-03-04T10:07:30Z", want: "2024-03-04T10:15:00Z"},
+		{name: "after an exact match", expr: "*/15 * * * *", from: "2024-03-04T10:15:00Z", want: "2024-03-04T10:30:00Z"},
+		{name: "next hour", expr: "0 * * * *", from: "2024-03-04T10:59:00Z", want: "2024-03-04T11:00:00Z"},
+		{name: "next day", expr: "0 3 * * *", from: "2024-03-04T03:00:00Z", want: "2024-03-05T03:00:00Z"},
+		{name: "skips the weekend", expr: "0 9 * * mon-fri", from: "2024-03-08T10:00:00Z", want: "2024-03-11T09:00:00Z"},
+		{name: "sunday as 7", expr: "0 0 * * 7", from: "2024-03-04T00:00:00Z", want: "2024-03-10T00:00:00Z"},
+		{name: "next month", expr: "0 0 1 * *", from: "2024-01-31T12:00:00Z", want: "2024-02-01T00:00:00Z"},
+		{name: "next year", expr: "@yearly", from: "2024-06-01T00:00:00Z", want: "2025-01-01T00:00:00Z"},
+		{name: "leap day", expr: "0 0 29 feb *", from: "2024-03-01T00:00:00Z", want: "2028-02-29T00:00:00Z"},
+		// Either restricted day matches, as in crontab(5): the 13th is a
+		// Wednesday, before the next Friday
+		{name: "day of month or week", expr: "0 0 13 * fri", from: "2024-03-10T00:00:00Z", want: "2024-03-13T00:00:00Z"},
+		{name: "day of month and any week day", expr: "0 0 13 * *", from: "2024-03-10T00:00:00Z", want: "2024-03-13T00:00:00Z"},
+		{name: "in the location of the expression", expr: "CRON_TZ=Europe/Berlin 0 3 * * *", from: "2024-07-01T00:00:00Z", want: "2024-07-01T01:00:00Z"},
+		{name: "across a DST change", expr: "CRON_TZ=Europe/Berlin 0 3 * * *", from: "2024-03-30T12:00:00Z", want: "2024-03-31T01:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q) failed: %v", tt.expr, err)
+			}
+			got := c.Next(at(tt.from))
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.Format(time.RFC3339), want.Format(time.RFC3339))
+			}
+		})
+	}
+
+	t.Run("keeps the location of t", func(t *testing.T) {
+		c, err := ParseCron("0 3 * * *")
+		if err != nil {
+			t.Fatal(err)
+		}
+		from := time.Date(2024, 7, 1, 0, 0, 0, 0, berlin)
+		if got := c.Next(from); got.Location() != berlin {
+			t.Errorf("Next returned a time in %s, want %s", got.Location(), berlin)
+		}
+	})
+}
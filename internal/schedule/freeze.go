@@ -0,0 +1,43 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+)
+
+// FreezeColumns is the column list expected by ScanFreeze
+const FreezeColumns = `id, name, reason, starts_at, ends_at, created_by, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// ScanFreeze reads a freeze period selected with FreezeColumns
+func ScanFreeze(row rowScanner) (models.FreezePeriod, error) {
+	var f models.FreezePeriod
+	err := row.Scan(&f.ID, &f.Name, &f.Reason, &f.StartsAt, &f.EndsAt, &f.CreatedBy, &f.CreatedAt)
+	return f, err
+}
+
+// ActiveFreeze returns the freeze period in effect at now, or nil. Of
+// overlapping periods, the one ending last is returned. Periods are stored
+// in UTC.
+func ActiveFreeze(ctx context.Context, db *database.DB, now time.Time) (*models.FreezePeriod, error) {
+	f, err := ScanFreeze(db.QueryRowContext(ctx,
+		`SELECT `+FreezeColumns+` FROM freeze_periods
+		 WHERE starts_at <= $1 AND ends_at > $1
+		 ORDER BY ends_at DESC LIMIT 1`, now.UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch freeze periods: %w", err)
+	}
+	return &f, nil
+}
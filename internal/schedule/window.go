@@ -0,0 +1,96 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gitsync/internal/models"
+)
+
+// ValidateWindow checks the times and timezone of a sync window
+func ValidateWindow(w models.SyncWindow) error {
+	for _, t := range []string{w.Start, w.End} {
+		if _, err := time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("invalid time %q, expected HH:MM", t)
+		}
+	}
+	if w.Start == w.End {
+		return errors.New("window start and end must differ")
+	}
+	if _, err := time.LoadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", w.Timezone)
+	}
+	return nil
+}
+
+// WindowFromEnv reads the global sync window from SYNC_WINDOW, such as
+// 22:00-06:00, in SYNC_WINDOW_TIMEZONE. It returns nil when unset.
+func WindowFromEnv() (*models.SyncWindow, error) {
+	raw := os.Getenv("SYNC_WINDOW")
+	if raw == "" {
+		return nil, nil
+	}
+	start, end, ok := strings.Cut(raw, "-")
+	if !ok {
+		return nil, errors.New("SYNC_WINDOW must be HH:MM-HH:MM")
+	}
+	w := &models.SyncWindow{
+		Start:    strings.TrimSpace(start),
+		End:      strings.TrimSpace(end),
+		Timezone: os.Getenv("SYNC_WINDOW_TIMEZONE"),
+	}
+	if err := ValidateWindow(*w); err != nil {
+		return nil, fmt.Errorf("invalid SYNC_WINDOW: %w", err)
+	}
+	return w, nil
+}
+
+// minutes returns the start and end of w in minutes after midnight
+func minutes(w models.SyncWindow) (start, end int, loc *time.Location, err error) {
+	loc, err = time.LoadLocation(w.Timezone)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	s, err1 := time.Parse("15:04", w.Start)
+	e, err2 := time.Parse("15:04", w.End)
+	if err := errors.Join(err1, err2); err != nil {
+		return 0, 0, nil, err
+	}
+	return s.Hour()*60 + s.Minute(), e.Hour()*60 + e.Minute(), loc, nil
+}
+
+// InWindow reports whether now falls in the daily [start, end) window w.
+// An invalid window contains no time.
+func InWindow(w models.SyncWindow, now time.Time) bool {
+	s, e, loc, err := minutes(w)
+	if err != nil {
+		return false
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if s <= e {
+		return minute >= s && minute < e
+	}
+	return minute >= s || minute < e
+}
+
+// NextOpen returns t when it falls in w, and otherwise the next time w
+// opens. An invalid window does not hold t back.
+func NextOpen(w models.SyncWindow, t time.Time) time.Time {
+	if InWindow(w, t) {
+		return t
+	}
+	s, _, loc, err := minutes(w)
+	if err != nil {
+		return t
+	}
+	local := t.In(loc)
+	open := time.Date(local.Year(), local.Month(), local.Day(), s/60, s%60, 0, 0, loc)
+	if !open.After(t) {
+		open = time.Date(local.Year(), local.Month(), local.Day()+1, s/60, s%60, 0, 0, loc)
+	}
+	return open.In(t.Location())
+}
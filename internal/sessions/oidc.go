@@ -0,0 +1,274 @@
+package sessions
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clockSkew is the leeway given to the expiry of ID tokens
+const clockSkew = time.Minute
+
+// provider is the part of the discovery document of the provider GitSync
+// uses
+type provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// discover returns the discovery document of the provider, fetching it on
+// first use
+func (m *Manager) discover(ctx context.Context) (*provider, error) {
+	m.mu.Lock()
+	p := m.provider
+	m.mu.Unlock()
+	if p != nil {
+		return p, nil
+	}
+
+	p = &provider{}
+	if err := m.getJSON(ctx, strings.TrimSuffix(m.Config.Issuer, "/")+"/.well-known/openid-configuration", p); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	if p.Issuer != m.Config.Issuer {
+		return nil, fmt.Errorf("OIDC provider claims issuer %q rather than %q", p.Issuer, m.Config.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC provider does not support the authorization code flow")
+	}
+	m.mu.Lock()
+	m.provider = p
+	m.mu.Unlock()
+	return p, nil
+}
+
+// exchange redeems an authorization code at the provider and returns the
+// claims of the verified ID token
+func (m *Manager) exchange(ctx context.Context, code, verifier string) (map[string]any, error) {
+	p, err := m.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {m.Config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {m.Config.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if m.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(m.Config.ClientID), url.QueryEscape(m.Config.ClientSecret))
+	}
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &e)
+		if e.Error == "invalid_grant" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidLogin, e.Description)
+		}
+		return nil, fmt.Errorf("token endpoint returned %s %s", resp.Status, e.Error)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint returned no ID token")
+	}
+	return m.verify(ctx, p, tokens.IDToken)
+}
+
+// verify checks the signature, issuer, audience and expiry of an ID token
+// and returns its claims
+func (m *Manager) verify(ctx context.Context, p *provider, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+	key, err := m.key(ctx, p, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	valid := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		valid = header.Alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		valid = header.Alg == "ES256" && len(sig) == 64 &&
+			ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+	if !valid {
+		return nil, fmt.Errorf("ID token signature (%s) is invalid", header.Alg)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, fmt.Errorf("ID token was issued by %q", iss)
+	}
+	if !slices.Contains(stringList(claims["aud"]), m.Config.ClientID) {
+		return nil, fmt.Errorf("ID token is not meant for client %s", m.Config.ClientID)
+	}
+	exp, _ := claims["exp"].(float64)
+	if m.Clock.Now().Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("ID token expired")
+	}
+	return claims, nil
+}
+
+// key returns the signing key of the provider with the given ID. The keys
+// are fetched again for an unknown ID, as providers rotate them.
+func (m *Manager) key(ctx context.Context, p *provider, kid string) (crypto.PublicKey, error) {
+	m.mu.Lock()
+	k, ok := m.keys[kid]
+	m.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := m.getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch {
+		case jwk.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err := errors.Join(err1, err2); err != nil || len(e) > 4 {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errors.Join(err1, err2) != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	m.mu.Lock()
+	m.keys = keys
+	m.mu.Unlock()
+	if k, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("ID token is signed with unknown key %q", kid)
+	}
+	return k, nil
+}
+
+// getJSON fetches the JSON document at rawURL into v
+func (m *Manager) getJSON(ctx context.Context, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// identity returns the user name and groups named by the claims of an ID
+// token
+func (m *Manager) identity(claims map[string]any) (string, []string) {
+	var user string
+	for _, claim := range []string{m.Config.UserClaim, "email", "sub"} {
+		if user, _ = claims[claim].(string); user != "" {
+			break
+		}
+	}
+	return user, stringList(claims[m.Config.GroupsClaim])
+}
+
+// stringList returns a claim holding a string or a list of strings as a
+// list
+func stringList(claim any) []string {
+	out := []string{}
+	switch v := claim.(type) {
+	case string:
+		out = append(out, v)
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,394 @@
+// Package sessions logs browser users in through an OpenID Connect
+// provider and keeps their sessions in the database. Machines keep
+// authenticating through the headers of the proxy in front of GitSync; a
+// request the proxy identified never uses a session.
+package sessions
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gitsync/internal/audit"
+	"gitsync/internal/auth"
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// CookieName is the cookie carrying the token of a session
+	CookieName = "gitsync_session"
+	// LoginCookie ties a login in progress to the browser that started it
+	LoginCookie = "gitsync_login"
+	// CSRFHeader carries the CSRF token of the session on requests other
+	// than GET, HEAD and OPTIONS
+	CSRFHeader = "X-CSRF-Token"
+	// LoginTTL bounds how long a user may take to log in at the provider
+	LoginTTL = 10 * time.Minute
+)
+
+// ErrInvalidLogin is returned for callbacks of logins that were never
+// started, were completed already or expired
+var ErrInvalidLogin = errors.New("login is invalid or expired")
+
+// Config sets the OIDC provider users log in with
+type Config struct {
+	// Issuer is the issuer URL of the provider; login is disabled when
+	// empty
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the public URL of /auth/callback, as registered with
+	// the provider
+	RedirectURL string
+	Scopes      []string
+	// UserClaim names the ID token claim holding the user name; email and
+	// sub are used when it is missing
+	UserClaim string
+	// GroupsClaim names the ID token claim listing the groups of the user
+	GroupsClaim string
+	// TTL is how long a session lasts
+	TTL time.Duration
+}
+
+// ConfigFromEnv reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and
+// OIDC_REDIRECT_URL, and OIDC_SCOPES, OIDC_USER_CLAIM, OIDC_GROUPS_CLAIM
+// and SESSION_TTL, defaulting to the openid, profile and email scopes, the
+// preferred_username and groups claims and sessions of 8 hours
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       []string{"openid", "profile", "email"},
+		UserClaim:    "preferred_username",
+		GroupsClaim:  "groups",
+		TTL:          8 * time.Hour,
+	}
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	if v := os.Getenv("OIDC_USER_CLAIM"); v != "" {
+		cfg.UserClaim = v
+	}
+	if v := os.Getenv("OIDC_GROUPS_CLAIM"); v != "" {
+		cfg.GroupsClaim = v
+	}
+	if raw := os.Getenv("SESSION_TTL"); raw != "" {
+		var err error
+		if cfg.TTL, err = time.ParseDuration(raw); err != nil || cfg.TTL <= 0 {
+			return cfg, fmt.Errorf("SESSION_TTL must be a positive duration")
+		}
+	}
+	if !cfg.Enabled() {
+		return cfg, nil
+	}
+	if u, err := url.Parse(cfg.Issuer); err != nil || u.Host == "" {
+		return cfg, fmt.Errorf("OIDC_ISSUER must be an absolute URL")
+	}
+	if cfg.ClientID == "" {
+		return cfg, fmt.Errorf("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+	if u, err := url.Parse(cfg.RedirectURL); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return cfg, fmt.Errorf("OIDC_REDIRECT_URL must be the absolute URL of /auth/callback")
+	}
+	if !strings.Contains(" "+strings.Join(cfg.Scopes, " ")+" ", " openid ") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	return cfg, nil
+}
+
+// Enabled reports whether users log in through a provider
+func (c Config) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// Secure reports whether cookies are only sent over HTTPS, which they are
+// unless GitSync is served over plain HTTP
+func (c Config) Secure() bool {
+	return !strings.HasPrefix(c.RedirectURL, "http://")
+}
+
+// Manager logs users in through the provider of Config and authenticates
+// the requests of their sessions
+type Manager struct {
+	DB     *database.DB
+	Config Config
+	// AdminGroups are the groups whose members are admins, as for the
+	// proxy headers
+	AdminGroups []string
+	Client      *http.Client
+	Clock       clock.Clock
+
+	mu       sync.Mutex
+	provider *provider
+	keys     map[string]crypto.PublicKey
+}
+
+// NewManager creates a Manager
+func NewManager(db *database.DB, cfg Config, adminGroups []string, clk clock.Clock) *Manager {
+	return &Manager{
+		DB:          db,
+		Config:      cfg,
+		AdminGroups: adminGroups,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Clock:       clk,
+	}
+}
+
+// Enabled reports whether users log in through a provider
+func (m *Manager) Enabled() bool {
+	return m != nil && m.Config.Enabled()
+}
+
+// Begin starts a login that returns the user to returnTo, a path of
+// GitSync, and returns the URL of the provider to redirect the user to and
+// the state to keep in LoginCookie
+func (m *Manager) Begin(ctx context.Context, returnTo string) (string, string, error) {
+	p, err := m.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, nonce, verifier := randomToken(), randomToken(), randomToken()
+	// Only paths of GitSync, so the login cannot redirect elsewhere
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		returnTo = "/"
+	}
+	if _, err := m.DB.ExecContext(ctx,
+		`INSERT INTO oidc_logins (state, nonce, verifier, return_to, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		state, nonce, verifier, returnTo, m.Clock.Now()); err != nil {
+		return "", "", fmt.Errorf("failed to record login: %w", err)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {m.Config.ClientID},
+		"redirect_uri":          {m.Config.RedirectURL},
+		"scope":                 {strings.Join(m.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + q.Encode(), state, nil
+}
+
+// Complete finishes the login with the given state using the
+// authorization code the provider returned, and opens a session for the
+// user. It returns the token of the session and the path to return the
+// user to.
+func (m *Manager) Complete(ctx context.Context, state, code string) (string, models.Session, string, error) {
+	var s models.Session
+	var nonce, verifier, returnTo string
+	var started time.Time
+	err := m.DB.QueryRowContext(ctx,
+		`DELETE FROM oidc_logins WHERE state = $1 RETURNING nonce, verifier, return_to, created_at`, state).
+		Scan(&nonce, &verifier, &returnTo, &started)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", s, "", ErrInvalidLogin
+	}
+	if err != nil {
+		return "", s, "", fmt.Errorf("failed to fetch login: %w", err)
+	}
+	now := m.Clock.Now()
+	if now.Sub(started) > LoginTTL {
+		return "", s, "", ErrInvalidLogin
+	}
+
+	claims, err := m.exchange(ctx, code, verifier)
+	if err != nil {
+		return "", s, "", err
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return "", s, "", fmt.Errorf("%w: ID token nonce does not match", ErrInvalidLogin)
+	}
+	user, groups := m.identity(claims)
+	if user == "" {
+		return "", s, "", fmt.Errorf("ID token names no user in %s, email or sub", m.Config.UserClaim)
+	}
+
+	token := randomToken()
+	s = models.Session{
+		User:      user,
+		Groups:    groups,
+		Admin:     auth.NewPrincipal(user, groups, m.AdminGroups).Admin,
+		CSRFToken: randomToken(),
+		ExpiresAt: now.Add(m.Config.TTL),
+		CreatedAt: now,
+	}
+	id := hashToken(token)
+	if _, err := m.DB.ExecContext(ctx,
+		`INSERT INTO sessions (id, user_name, groups, csrf_token, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		id, s.User, pq.Array(s.Groups), s.CSRFToken, s.ExpiresAt, s.CreatedAt); err != nil {
+		return "", s, "", fmt.Errorf("failed to record session: %w", err)
+	}
+	log.Printf("User %s logged in through %s", user, m.Config.Issuer)
+	audit.Record(context.WithoutCancel(ctx), m.DB, user, "session.login", "session", id[:16],
+		map[string]any{"groups": s.Groups, "admin": s.Admin, "expires_at": s.ExpiresAt})
+	return token, s, returnTo, nil
+}
+
+// Lookup returns the session with the given token, if it exists and has
+// not expired
+func (m *Manager) Lookup(ctx context.Context, token string) (models.Session, bool, error) {
+	var s models.Session
+	err := m.DB.QueryRowContext(ctx,
+		`SELECT user_name, groups, csrf_token, expires_at, created_at FROM sessions WHERE id = $1 AND expires_at > $2`,
+		hashToken(token), m.Clock.Now()).
+		Scan(&s.User, pq.Array(&s.Groups), &s.CSRFToken, &s.ExpiresAt, &s.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, fmt.Errorf("failed to fetch session: %w", err)
+	}
+	if s.Groups == nil {
+		s.Groups = []string{}
+	}
+	s.Admin = auth.NewPrincipal(s.User, s.Groups, m.AdminGroups).Admin
+	return s, true, nil
+}
+
+// End deletes the session with the given token
+func (m *Manager) End(ctx context.Context, token string) error {
+	var user string
+	id := hashToken(token)
+	err := m.DB.QueryRowContext(ctx, `DELETE FROM sessions WHERE id = $1 RETURNING user_name`, id).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	audit.Record(context.WithoutCancel(ctx), m.DB, user, "session.logout", "session", id[:16], nil)
+	return nil
+}
+
+// Cookie returns the cookie setting name to value for ttl, or deleting it
+// when ttl is 0. Cookies are sent on top-level navigations from the
+// provider but not on requests other sites make.
+func (m *Manager) Cookie(name, value string, ttl time.Duration) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   m.Config.Secure(),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl <= 0 {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// Middleware attaches the user of the session cookie to requests the
+// proxy did not identify. Requests of a session other than GET, HEAD and
+// OPTIONS are refused without its CSRF token. Requests without a valid
+// session pass through anonymously.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	if !m.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.FromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cookie, err := r.Cookie(CookieName)
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s, found, err := m.Lookup(r.Context(), cookie.Value)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "failed to fetch session", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(CSRFHeader)), []byte(s.CSRFToken)) != 1 {
+				http.Error(w, "missing or invalid "+CSRFHeader+" header", http.StatusForbidden)
+				return
+			}
+		}
+		p := &auth.Principal{User: s.User, Groups: s.Groups, Admin: s.Admin}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+	})
+}
+
+// Run deletes expired sessions and abandoned logins every interval until
+// ctx is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	if !m.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.cleanup(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: session cleanup failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) cleanup(ctx context.Context) error {
+	now := m.Clock.Now()
+	if _, err := m.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, now); err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	if _, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE created_at <= $1`, now.Add(-LoginTTL)); err != nil {
+		return fmt.Errorf("failed to delete abandoned logins: %w", err)
+	}
+	return nil
+}
+
+// randomToken returns 256 random bits, encoded for URLs and cookies
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashToken returns the ID a session token is stored under, so the
+// database does not hold usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,142 @@
+// Package slo watches repositories with a sync SLO and raises incidents
+// when a target goes too long without a successful sync.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/notify"
+)
+
+// Monitor opens a breach when a target of a repository with a sync SLO has
+// not synced successfully within the SLO, and resolves it once it has
+type Monitor struct {
+	DB       *database.DB
+	Notifier *notify.Dispatcher
+}
+
+// NewMonitor creates a Monitor
+func NewMonitor(db *database.DB, notifier *notify.Dispatcher) *Monitor {
+	return &Monitor{DB: db, Notifier: notifier}
+}
+
+type targetState struct {
+	RepositoryID   string
+	RepositoryName string
+	TargetID       string
+	RemoteURL      string
+	SLO            time.Duration
+	// LastSuccess falls back to the target's creation time so new targets
+	// get a full SLO period to complete their first sync
+	LastSuccess time.Time
+	Breached    bool
+}
+
+// CheckAll evaluates every target of every repository with a sync SLO
+func (m *Monitor) CheckAll(ctx context.Context) error {
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT r.id, r.name, t.id, t.remote_url, r.sync_slo_seconds,
+		        COALESCE((SELECT MAX(e.finished_at) FROM executions e WHERE e.target_id = t.id AND e.status = $1), t.created_at),
+		        EXISTS(SELECT 1 FROM slo_breaches b WHERE b.target_id = t.id AND b.resolved_at IS NULL)
+		 FROM repositories r JOIN replication_targets t ON t.repository_id = r.id
+		 WHERE r.sync_slo_seconds IS NOT NULL AND r.source_state = $2 AND t.approval_state = $3`,
+		models.ExecutionSuccess, models.SourceActive, models.ApprovalApproved)
+	if err != nil {
+		return fmt.Errorf("failed to fetch targets: %w", err)
+	}
+
+	var targets []targetState
+	for rows.Next() {
+		var t targetState
+		var seconds int
+		if err := rows.Scan(&t.RepositoryID, &t.RepositoryName, &t.TargetID, &t.RemoteURL, &seconds,
+			&t.LastSuccess, &t.Breached); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan target: %w", err)
+		}
+		t.SLO = time.Duration(seconds) * time.Second
+		targets = append(targets, t)
+	}
+	rows.Close()
+
+	for _, t := range targets {
+		if err := m.check(ctx, t); err != nil {
+			log.Printf("WARN: SLO check failed for target %s: %v", t.TargetID, err)
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) check(ctx context.Context, t targetState) error {
+	lag := time.Since(t.LastSuccess)
+	data := map[string]any{
+		"remote_url":   t.RemoteURL,
+		"slo":          t.SLO.String(),
+		"last_success": t.LastSuccess.UTC().Format(time.RFC3339),
+	}
+
+	switch {
+	case lag > t.SLO && !t.Breached:
+		res, err := m.DB.ExecContext(ctx,
+			`INSERT INTO slo_breaches (repository_id, target_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			t.RepositoryID, t.TargetID)
+		if err != nil {
+			return fmt.Errorf("failed to record breach: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		m.Notifier.Notify(notify.Event{
+			Type:           notify.EventSLOBreached,
+			Severity:       notify.SeverityCritical,
+			RepositoryID:   t.RepositoryID,
+			RepositoryName: t.RepositoryName,
+			TargetID:       t.TargetID,
+			Message: fmt.Sprintf("%s has not synced to %s for %s (SLO %s)",
+				t.RepositoryName, t.RemoteURL, lag.Round(time.Minute), t.SLO),
+			Data: data,
+		})
+
+	case lag <= t.SLO && t.Breached:
+		res, err := m.DB.ExecContext(ctx,
+			`UPDATE slo_breaches SET resolved_at = NOW() WHERE target_id = $1 AND resolved_at IS NULL`, t.TargetID)
+		if err != nil {
+			return fmt.Errorf("failed to resolve breach: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		m.Notifier.Notify(notify.Event{
+			Type:           notify.EventSLORecovered,
+			Severity:       notify.SeverityInfo,
+			RepositoryID:   t.RepositoryID,
+			RepositoryName: t.RepositoryName,
+			TargetID:       t.TargetID,
+			Message:        fmt.Sprintf("%s is syncing to %s within its SLO again", t.RepositoryName, t.RemoteURL),
+			Data:           data,
+		})
+	}
+	return nil
+}
+
+// Run checks all targets periodically until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.CheckAll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: SLO check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
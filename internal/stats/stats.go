@@ -0,0 +1,171 @@
+// Package stats keeps a daily rollup of finished syncs per repository and
+// aggregates it into time-bucketed statistics for capacity planning and
+// SLO reporting.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+)
+
+// Bucket sizes statistics can be grouped by
+const (
+	Day   = "day"
+	Week  = "week"
+	Month = "month"
+)
+
+// MaxDays bounds how far back statistics reach
+const MaxDays = 3660
+
+// Record adds a finished sync to the rollup of the day it started on.
+// Only successful and failed syncs are counted.
+func Record(ctx context.Context, db *database.DB, repoID string, status models.ExecutionStatus, bytes int64, started, finished time.Time) error {
+	if status != models.ExecutionSuccess && status != models.ExecutionFailed {
+		return nil
+	}
+	succeeded, failed := 0, 0
+	if status == models.ExecutionSuccess {
+		succeeded = 1
+	} else {
+		failed = 1
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO sync_stats_daily (day, repository_id, syncs, succeeded, failed, bytes_pushed, duration_ms)
+		 VALUES ($1, $2, 1, $3, $4, $5, $6)
+		 ON CONFLICT (day, repository_id) DO UPDATE SET
+		     syncs = sync_stats_daily.syncs + 1,
+		     succeeded = sync_stats_daily.succeeded + EXCLUDED.succeeded,
+		     failed = sync_stats_daily.failed + EXCLUDED.failed,
+		     bytes_pushed = sync_stats_daily.bytes_pushed + EXCLUDED.bytes_pushed,
+		     duration_ms = sync_stats_daily.duration_ms + EXCLUDED.duration_ms`,
+		started.UTC().Format(time.DateOnly), repoID, succeeded, failed, bytes, finished.Sub(started).Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to record sync statistics of repository %s: %w", repoID, err)
+	}
+	return nil
+}
+
+// ParseGroupBy validates a bucket size, defaulting to Day
+func ParseGroupBy(raw string) (string, error) {
+	switch raw {
+	case "":
+		return Day, nil
+	case Day, Week, Month:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("invalid group_by %q. allowed: day, week, month", raw)
+	}
+}
+
+// ParseSince parses how far back statistics reach, as a number of days
+// such as 30d or a duration such as 72h, rounded up to whole days
+func ParseSince(raw string) (int, error) {
+	days := 0
+	if n, ok := strings.CutSuffix(raw, "d"); ok {
+		days, _ = strconv.Atoi(n)
+	} else if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+		days = int((d + 24*time.Hour - 1) / (24 * time.Hour))
+	}
+	if days <= 0 || days > MaxDays {
+		return 0, fmt.Errorf("since must be a number of days such as 30d or a duration such as 72h, up to %dd", MaxDays)
+	}
+	return days, nil
+}
+
+// Query aggregates the rollup of the last days days, today included, into
+// buckets of groupBy, as returned by ParseGroupBy, optionally for one
+// repository. Buckets start on UTC
+// day, week (Monday) or month boundaries, so the first may start before
+// the period does; it only counts the days within.
+func Query(ctx context.Context, db *database.DB, groupBy string, days int, repoID string, now time.Time) (models.SyncStats, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	res := models.SyncStats{
+		GroupBy: groupBy, Since: today.AddDate(0, 0, 1-days), Until: today.AddDate(0, 0, 1),
+		Buckets: []models.SyncStatsBucket{}, TopFailing: []models.RepositorySyncStats{},
+	}
+	since := res.Since.Format(time.DateOnly)
+
+	// Every bucket is listed, those without syncs included
+	rows, err := db.QueryContext(ctx,
+		`SELECT b.start, COALESCE(SUM(s.syncs), 0), COALESCE(SUM(s.succeeded), 0), COALESCE(SUM(s.failed), 0),
+		        COALESCE(SUM(s.bytes_pushed), 0), COALESCE(SUM(s.duration_ms), 0)
+		 FROM generate_series(date_trunc($1, $2::timestamp), $3::timestamp, ('1 ' || $1)::interval) AS b(start)
+		 LEFT JOIN sync_stats_daily s
+		     ON date_trunc($1, s.day::timestamp) = b.start AND s.day >= $2::date AND ($4 = '' OR s.repository_id = NULLIF($4, '')::uuid)
+		 GROUP BY b.start ORDER BY b.start`,
+		groupBy, since, today.Format(time.DateOnly), repoID)
+	if err != nil {
+		return res, fmt.Errorf("failed to aggregate sync statistics: %w", err)
+	}
+	defer rows.Close()
+	var totalMS int64
+	for rows.Next() {
+		var (
+			b          models.SyncStatsBucket
+			durationMS int64
+		)
+		if err := rows.Scan(&b.Start, &b.Syncs, &b.Succeeded, &b.Failed, &b.BytesPushed, &durationMS); err != nil {
+			return res, fmt.Errorf("failed to scan sync statistics: %w", err)
+		}
+		finish(&b, durationMS)
+		res.Buckets = append(res.Buckets, b)
+		res.Totals.Syncs += b.Syncs
+		res.Totals.Succeeded += b.Succeeded
+		res.Totals.Failed += b.Failed
+		res.Totals.BytesPushed += b.BytesPushed
+		totalMS += durationMS
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	res.Totals.Start = res.Since
+	finish(&res.Totals, totalMS)
+
+	// Repositories that were deleted since keep their ID only
+	rows, err = db.QueryContext(ctx,
+		`SELECT s.repository_id, COALESCE(r.name, ''), SUM(s.syncs), SUM(s.succeeded), SUM(s.failed)
+		 FROM sync_stats_daily s LEFT JOIN repositories r ON r.id = s.repository_id
+		 WHERE s.day >= $1::date AND ($2 = '' OR s.repository_id = NULLIF($2, '')::uuid)
+		 GROUP BY s.repository_id, r.name
+		 HAVING SUM(s.failed) > 0
+		 ORDER BY SUM(s.failed) DESC, r.name LIMIT 10`, since, repoID)
+	if err != nil {
+		return res, fmt.Errorf("failed to fetch failing repositories: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f models.RepositorySyncStats
+		if err := rows.Scan(&f.RepositoryID, &f.Name, &f.Syncs, &f.Succeeded, &f.Failed); err != nil {
+			return res, fmt.Errorf("failed to scan failing repository: %w", err)
+		}
+		f.SuccessRate = rate(f.Succeeded, f.Syncs)
+		res.TopFailing = append(res.TopFailing, f)
+	}
+	return res, rows.Err()
+}
+
+// finish derives the success rate and average duration of b from its
+// counts and total duration
+func finish(b *models.SyncStatsBucket, durationMS int64) {
+	b.SuccessRate = rate(b.Succeeded, b.Syncs)
+	if b.Syncs > 0 {
+		avg := float64(durationMS) / float64(b.Syncs) / 1000
+		b.AverageDurationSeconds = &avg
+	}
+}
+
+// rate is the share of n in total, nil without any
+func rate(n, total int) *float64 {
+	if total == 0 {
+		return nil
+	}
+	r := float64(n) / float64(total)
+	return &r
+}
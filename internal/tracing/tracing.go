@@ -0,0 +1,175 @@
+// Package tracing traces API requests, syncs, database queries and git
+// commands with OpenTelemetry and exports the spans over OTLP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitsync/internal/buildinfo"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentation names the tracer of the spans started here
+const instrumentation = "gitsync"
+
+// OTLP protocols
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http/protobuf"
+)
+
+// Config enables tracing. Spans started while it is disabled are dropped.
+type Config struct {
+	Enabled bool
+	// Protocol is ProtocolGRPC or ProtocolHTTP
+	Protocol string
+}
+
+// ConfigFromEnv enables tracing when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, unless OTEL_SDK_DISABLED is
+// true, and reads the protocol from OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or
+// OTEL_EXPORTER_OTLP_PROTOCOL, http/protobuf by default. The exporter reads
+// the other OTEL_EXPORTER_OTLP_* variables, such as its headers, the SDK
+// the sampler from OTEL_TRACES_SAMPLER, and OTEL_SERVICE_NAME overrides
+// the service name gitsync.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Protocol: ProtocolHTTP}
+	if raw := os.Getenv("OTEL_SDK_DISABLED"); raw != "" {
+		disabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("OTEL_SDK_DISABLED must be true or false")
+		}
+		if disabled {
+			return cfg, nil
+		}
+	}
+	cfg.Enabled = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		if raw != ProtocolGRPC && raw != ProtocolHTTP {
+			return cfg, fmt.Errorf("%s must be %s or %s", name, ProtocolGRPC, ProtocolHTTP)
+		}
+		cfg.Protocol = raw
+		break
+	}
+	return cfg, nil
+}
+
+// defaultProvider is the global tracer provider before any is installed.
+// It forwards to the first provider installed, even once that is stopped.
+var defaultProvider = otel.GetTracerProvider()
+
+// Setup installs a tracer provider exporting spans in batches per cfg and
+// the W3C trace context and baggage propagators, and returns the func that
+// exports the spans left, stops it and puts the previous provider back, or
+// a no-op one in place of the default
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	var client otlptrace.Client
+	switch cfg.Protocol {
+	case ProtocolGRPC:
+		client = otlptracegrpc.NewClient()
+	default:
+		client = otlptracehttp.NewClient()
+	}
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	// Attributes from the environment override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(instrumentation), semconv.ServiceVersion(buildinfo.Version)),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the traced service: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	previous := otel.GetTracerProvider()
+	if previous == defaultProvider {
+		previous = noop.NewTracerProvider()
+	}
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func(ctx context.Context) error {
+		otel.SetTracerProvider(previous)
+		return provider.Shutdown(ctx)
+	}, nil
+}
+
+// Start starts a span named name as a child of the span of ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed with err when set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceParent returns the W3C traceparent of the span of ctx, or "" when
+// it has none, for work that continues the trace elsewhere
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceParent returns ctx carrying the span of a traceparent returned
+// by TraceParent, so the spans started with it continue that trace
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// Middleware traces the requests to the routes of a mux router, continuing
+// the trace of their traceparent header. Spans are named by the method and
+// path template, so /repositories/{id} is one operation. Scrapes of
+// /metrics are not traced.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if current := mux.CurrentRoute(r); current != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					return r.Method + " " + tmpl
+				}
+			}
+			return r.Method
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !strings.HasPrefix(r.URL.Path, "/metrics")
+		}),
+	)
+}
+
+// Transport traces the requests sent through base, passing their trace
+// context along in a traceparent header
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
@@ -0,0 +1,265 @@
+// Package trash keeps deleted repositories and targets, along with their
+// sync history, for a while so that they can be restored.
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/database"
+	"gitsync/internal/ids"
+	"gitsync/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Types of resources in the trash
+const (
+	Repository = "repository"
+	Target     = "target"
+)
+
+// ErrRepositoryDeleted is returned when restoring a target whose
+// repository no longer exists
+var ErrRepositoryDeleted = errors.New("the repository of the target is deleted; restore it first")
+
+// table is a table holding rows of a deleted resource, selected by where
+// with the resource ID as $1. Rows are restored only when filter holds for
+// them as t.
+type table struct {
+	name   string
+	where  string
+	filter string
+}
+
+// ofTargets selects the rows of the targets of the repository $1
+const ofTargets = "target_id IN (SELECT id FROM replication_targets WHERE repository_id = $1)"
+
+// tables lists what is kept of each type of resource, in the order it is
+// restored. Notifications, SLO breaches and policy violations are not
+// kept; they describe the past rather than configure the resource.
+var tables = map[string][]table{
+	Repository: {
+		{name: "repositories", where: "id = $1"},
+		{name: "replication_targets", where: "repository_id = $1"},
+		{name: "repository_webhooks", where: "repository_id = $1"},
+		{name: "executions", where: "repository_id = $1"},
+		{name: "target_refs", where: ofTargets},
+		{name: "sync_group_members", where: "repository_id = $1",
+			filter: "EXISTS (SELECT 1 FROM sync_groups g WHERE g.id = t.group_id)"},
+		{name: "integrity_findings", where: "repository_id = $1"},
+		{name: "secret_findings", where: "repository_id = $1"},
+		{name: "push_approvals", where: "repository_id = $1"},
+		{name: "anomalies", where: "repository_id = $1"},
+		{name: "identity_mappings", where: ofTargets},
+		{name: "rewritten_commits", where: ofTargets},
+	},
+	Target: {
+		{name: "replication_targets", where: "id = $1"},
+		{name: "executions", where: "target_id = $1"},
+		{name: "target_refs", where: "target_id = $1"},
+		{name: "integrity_findings", where: "target_id = $1"},
+		{name: "push_approvals", where: "target_id = $1"},
+		{name: "identity_mappings", where: "target_id = $1"},
+		{name: "rewritten_commits", where: "target_id = $1"},
+	},
+}
+
+// Columns are the columns of the trash table Scan reads
+const Columns = `id, resource_type, resource_id, name, repository_id, team, deleted_by, deleted_at, expires_at`
+
+// Scan reads an entry selected with Columns, followed by the columns of
+// extra
+func Scan(row interface{ Scan(...any) error }, extra ...any) (models.TrashEntry, error) {
+	var e models.TrashEntry
+	err := row.Scan(append([]any{&e.ID, &e.ResourceType, &e.ResourceID, &e.Name, &e.RepositoryID, &e.Team, &e.DeletedBy,
+		&e.DeletedAt, &e.ExpiresAt}, extra...)...)
+	return e, err
+}
+
+// Bin moves deleted resources to the trash
+type Bin struct {
+	DB *database.DB
+	// Retention is how long deleted resources are kept; they are deleted
+	// outright when it is 0
+	Retention time.Duration
+	Clock     clock.Clock
+	IDs       ids.Generator
+}
+
+// NewBin creates a Bin
+func NewBin(db *database.DB, retention time.Duration, clk clock.Clock, gen ids.Generator) *Bin {
+	return &Bin{DB: db, Retention: retention, Clock: clk, IDs: gen}
+}
+
+// Discard deletes the resource of type typ with the given ID within tx,
+// keeping it in the trash first unless the retention is 0. Its sync
+// history, which does not cascade, is deleted along with it.
+func (b *Bin) Discard(ctx context.Context, tx *sql.Tx, typ, id string, deletedBy *string) error {
+	if b.Retention > 0 {
+		if err := b.keep(ctx, tx, typ, id, deletedBy); err != nil {
+			return err
+		}
+	}
+	column, table := "repository_id", "repositories"
+	if typ == Target {
+		column, table = "target_id", "replication_targets"
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM executions WHERE `+column+` = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete executions of %s %s: %w", typ, id, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete %s %s: %w", typ, id, err)
+	}
+	return nil
+}
+
+// keep copies the rows of the resource into a new trash entry
+func (b *Bin) keep(ctx context.Context, tx *sql.Tx, typ, id string, deletedBy *string) error {
+	var name string
+	var repoID, team *string
+	var err error
+	if typ == Repository {
+		err = tx.QueryRowContext(ctx, `SELECT name, team FROM repositories WHERE id = $1`, id).Scan(&name, &team)
+	} else {
+		err = tx.QueryRowContext(ctx,
+			`SELECT t.remote_url, t.repository_id, r.team FROM replication_targets t JOIN repositories r ON r.id = t.repository_id
+			 WHERE t.id = $1`, id).Scan(&name, &repoID, &team)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s %s: %w", typ, id, err)
+	}
+
+	data := make(map[string]json.RawMessage)
+	for _, t := range tables[typ] {
+		var rows json.RawMessage
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE(jsonb_agg(to_jsonb(t)), '[]') FROM `+t.name+` t WHERE `+t.where, id).Scan(&rows); err != nil {
+			return fmt.Errorf("failed to copy %s of %s %s: %w", t.name, typ, id, err)
+		}
+		data[t.name] = rows
+	}
+	doc, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	now := b.Clock.Now()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO trash (id, resource_type, resource_id, name, repository_id, team, data, deleted_by, deleted_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		b.IDs.NewID(), typ, id, name, repoID, team, doc, deletedBy, now, now.Add(b.Retention)); err != nil {
+		return fmt.Errorf("failed to move %s %s to the trash: %w", typ, id, err)
+	}
+	return nil
+}
+
+// Restore puts the resource of the trash entry with the given ID back and
+// removes the entry. It returns sql.ErrNoRows when there is no such entry,
+// ErrRepositoryDeleted for a target of a deleted repository and the
+// database error when a resource created since holds the same source or
+// remote URL.
+func (b *Bin) Restore(ctx context.Context, id string) (models.TrashEntry, error) {
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.TrashEntry{}, err
+	}
+	defer tx.Rollback()
+
+	var doc []byte
+	e, err := Scan(tx.QueryRowContext(ctx, `SELECT `+Columns+`, data FROM trash WHERE id = $1 FOR UPDATE`, id), &doc)
+	if err != nil {
+		return e, err
+	}
+	if e.ResourceType == Target {
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS(SELECT 1 FROM repositories WHERE id = $1)`, e.RepositoryID).Scan(&exists); err != nil {
+			return e, fmt.Errorf("failed to fetch repository: %w", err)
+		}
+		if !exists {
+			return e, ErrRepositoryDeleted
+		}
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &data); err != nil {
+		return e, fmt.Errorf("invalid trash entry %s: %w", id, err)
+	}
+	for _, t := range tables[e.ResourceType] {
+		if err := restoreRows(ctx, tx, t, data[t.name]); err != nil {
+			return e, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM trash WHERE id = $1`, id); err != nil {
+		return e, fmt.Errorf("failed to remove trash entry %s: %w", id, err)
+	}
+	return e, tx.Commit()
+}
+
+// restoreRows inserts the rows kept of t. Only the columns the rows hold
+// are set, so columns added since they were deleted take their defaults;
+// generated columns are left to the database.
+func restoreRows(ctx context.Context, tx *sql.Tx, t table, rows json.RawMessage) error {
+	if len(rows) == 0 {
+		// Tables kept since the entry was made
+		return nil
+	}
+	var kept []map[string]json.RawMessage
+	if err := json.Unmarshal(rows, &kept); err != nil {
+		return fmt.Errorf("invalid rows of %s: %w", t.name, err)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	cols, err := tx.QueryContext(ctx,
+		`SELECT column_name FROM information_schema.columns
+		 WHERE table_schema = current_schema() AND table_name = $1 AND is_generated = 'NEVER'
+		 ORDER BY ordinal_position`, t.name)
+	if err != nil {
+		return fmt.Errorf("failed to fetch columns of %s: %w", t.name, err)
+	}
+	var names []string
+	for cols.Next() {
+		var name string
+		if err := cols.Scan(&name); err != nil {
+			cols.Close()
+			return fmt.Errorf("failed to scan column of %s: %w", t.name, err)
+		}
+		if _, ok := kept[0][name]; ok {
+			names = append(names, pq.QuoteIdentifier(name))
+		}
+	}
+	cols.Close()
+	if err := cols.Err(); err != nil {
+		return fmt.Errorf("failed to fetch columns of %s: %w", t.name, err)
+	}
+
+	list := strings.Join(names, ", ")
+	query := `INSERT INTO ` + t.name + ` (` + list + `) SELECT ` + list +
+		` FROM jsonb_populate_recordset(NULL::` + t.name + `, $1::jsonb) t`
+	if t.filter != "" {
+		query += ` WHERE ` + t.filter
+	}
+	if _, err := tx.ExecContext(ctx, query, []byte(rows)); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", t.name, err)
+	}
+	return nil
+}
+
+// Purge deletes the entries kept longer than the retention they were
+// deleted under
+func Purge(ctx context.Context, db *database.DB) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM trash WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to empty the trash: %w", err)
+	}
+	return res.RowsAffected()
+}
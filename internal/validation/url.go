@@ -0,0 +1,104 @@
+// Package validation holds the input rules shared by every handler that
+// accepts repository or target URLs.
+package validation
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gitsync/internal/provider"
+)
+
+// URLPolicy is the per-deployment set of clone URL schemes that may be used
+// for sources and targets
+type URLPolicy struct {
+	Schemes []string
+	// ExtractCredentials moves credentials embedded in URLs into credential
+	// profiles instead of rejecting the URL
+	ExtractCredentials bool
+}
+
+// DefaultURLPolicy accepts https:// and ssh:// URLs
+func DefaultURLPolicy() URLPolicy {
+	return URLPolicy{Schemes: []string{"https", "ssh"}}
+}
+
+// NewURLPolicy builds a policy from scheme names, rejecting unknown ones
+func NewURLPolicy(schemes []string) (URLPolicy, error) {
+	var policy URLPolicy
+	for _, s := range schemes {
+		s = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "://"))
+		if s == "" {
+			continue
+		}
+		if !slices.Contains(provider.Schemes, s) {
+			return URLPolicy{}, fmt.Errorf("unknown url scheme %q. allowed: %s", s, strings.Join(provider.Schemes, ", "))
+		}
+		if !slices.Contains(policy.Schemes, s) {
+			policy.Schemes = append(policy.Schemes, s)
+		}
+	}
+	if len(policy.Schemes) == 0 {
+		return URLPolicy{}, fmt.Errorf("at least one url scheme must be allowed")
+	}
+	return policy, nil
+}
+
+// URLPolicyFromEnv reads ALLOWED_URL_SCHEMES (comma separated), falling back
+// to DefaultURLPolicy when unset, and EMBEDDED_CREDENTIALS: reject (the
+// default) or extract
+func URLPolicyFromEnv() (URLPolicy, error) {
+	policy := DefaultURLPolicy()
+	if v := os.Getenv("ALLOWED_URL_SCHEMES"); strings.TrimSpace(v) != "" {
+		var err error
+		if policy, err = NewURLPolicy(strings.Split(v, ",")); err != nil {
+			return policy, fmt.Errorf("invalid ALLOWED_URL_SCHEMES: %w", err)
+		}
+	}
+	switch os.Getenv("EMBEDDED_CREDENTIALS") {
+	case "", "reject":
+	case "extract":
+		policy.ExtractCredentials = true
+	default:
+		return policy, fmt.Errorf("invalid EMBEDDED_CREDENTIALS: must be reject or extract")
+	}
+	return policy, nil
+}
+
+// Allows reports whether scheme may be used
+func (p URLPolicy) Allows(scheme string) bool {
+	return slices.Contains(p.Schemes, scheme)
+}
+
+func (p URLPolicy) describe() string {
+	prefixed := make([]string, len(p.Schemes))
+	for i, s := range p.Schemes {
+		prefixed[i] = s + "://"
+	}
+	return strings.Join(prefixed, " or ")
+}
+
+// SplitCredentials takes credentials embedded in raw off the URL when the
+// policy extracts them, returning the URL and the secret. Otherwise raw is
+// returned as is and RepoURL rejects it.
+func (p URLPolicy) SplitCredentials(raw string) (string, string) {
+	if !p.ExtractCredentials {
+		return raw, ""
+	}
+	return provider.SplitCredentials(raw)
+}
+
+// RepoURL validates raw as a clone URL for providerName and returns its
+// canonical form. scp-style addresses count as ssh.
+func (p URLPolicy) RepoURL(providerName, raw string) (string, error) {
+	u, err := provider.ParseRepoURL(raw)
+	if err != nil {
+		return "", err
+	}
+	if !p.Allows(u.Scheme) {
+		return "", fmt.Errorf("url must start with %s", p.describe())
+	}
+	return provider.NormalizeURL(providerName, raw)
+}
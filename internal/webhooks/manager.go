@@ -0,0 +1,266 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gitsync/internal/clock"
+	"gitsync/internal/credentials"
+	"gitsync/internal/database"
+	"gitsync/internal/models"
+	"gitsync/internal/provider"
+)
+
+const (
+	statusActive = "active"
+	statusError  = "error"
+)
+
+// Manager installs and heals push webhooks on source repositories
+type Manager struct {
+	DB          *database.DB
+	Client      *provider.Client
+	Credentials *credentials.Store
+	Clock       clock.Clock
+	BaseURL     string
+}
+
+// NewManager creates a webhook Manager delivering to baseURL
+func NewManager(db *database.DB, client *provider.Client, creds *credentials.Store, clk clock.Clock, baseURL string) *Manager {
+	return &Manager{
+		DB:          db,
+		Client:      client,
+		Credentials: creds,
+		Clock:       clk,
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Enabled reports whether webhook management is configured
+func (m *Manager) Enabled() bool {
+	return m != nil && m.BaseURL != ""
+}
+
+// Ensure makes sure the repository has an active push webhook pointing at
+// our receiver. Repositories whose provider has no token are skipped.
+func (m *Manager) Ensure(ctx context.Context, repo models.Repository) error {
+	if !m.Enabled() {
+		return nil
+	}
+	token, err := m.Credentials.Resolve(ctx, repo.CredentialID, repo.SourceProvider)
+	if err != nil {
+		return fmt.Errorf("failed to resolve credential: %w", err)
+	}
+	if token == "" {
+		return nil
+	}
+
+	hookURL := m.BaseURL + "/webhooks/" + repo.SourceProvider
+
+	var hookID sql.NullString
+	var stored string
+	var keyID *string
+	err = m.DB.QueryRowContext(ctx,
+		`SELECT hook_id, secret, key_id FROM repository_webhooks WHERE repository_id = $1`, repo.ID).Scan(&hookID, &stored, &keyID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to load webhook state: %w", err)
+	}
+	secret, err := m.Credentials.Open(stored, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt webhook secret: %w", err)
+	}
+	fresh := secret == ""
+	if fresh {
+		if secret, err = newSecret(); err != nil {
+			return err
+		}
+	}
+
+	id, err := m.install(ctx, repo, token, hookID.String, hookURL, secret, fresh)
+	if err != nil {
+		m.record(ctx, repo.ID, hookID.String, hookURL, secret, statusError, err.Error())
+		return err
+	}
+	m.record(ctx, repo.ID, id, hookURL, secret, statusActive, "")
+	return nil
+}
+
+// install creates the hook, or re-applies its configuration when it exists
+// but drifted from it or fresh is set for a newly generated secret
+func (m *Manager) install(ctx context.Context, repo models.Repository, token, knownID, hookURL, secret string, fresh bool) (string, error) {
+	hooks, err := m.Client.ListHooks(ctx, repo.SourceProvider, repo.SourceURL, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	for _, h := range hooks {
+		if h.ID == knownID || h.URL == hookURL {
+			// The secret is write-only on every provider, so only our own
+			// hook, found by its ID, is known to have it already
+			if h.ID == knownID && h.URL == hookURL && h.Active && h.Push && !fresh {
+				return h.ID, nil
+			}
+			if err := m.Client.UpdateHook(ctx, repo.SourceProvider, repo.SourceURL, token, h.ID, hookURL, secret); err != nil {
+				return "", fmt.Errorf("failed to update webhook: %w", err)
+			}
+			return h.ID, nil
+		}
+	}
+
+	hook, err := m.Client.CreateHook(ctx, repo.SourceProvider, repo.SourceURL, token, hookURL, secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return hook.ID, nil
+}
+
+// record stores the state of the webhook of a repository, with its secret
+// sealed like credential secrets
+func (m *Manager) record(ctx context.Context, repoID, hookID, hookURL, secret, status, lastError string) {
+	stored, keyID, err := m.Credentials.Seal(secret)
+	if err != nil {
+		log.Printf("ERROR: failed to record webhook state for repository %s: %v", repoID, err)
+		return
+	}
+	_, err = m.DB.ExecContext(ctx,
+		`INSERT INTO repository_webhooks (repository_id, hook_id, hook_url, secret, key_id, status, last_error, last_checked_at)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, NULLIF($7, ''), $8)
+		 ON CONFLICT (repository_id) DO UPDATE
+		 SET hook_id = EXCLUDED.hook_id, hook_url = EXCLUDED.hook_url, secret = EXCLUDED.secret, key_id = EXCLUDED.key_id,
+		     status = EXCLUDED.status, last_error = EXCLUDED.last_error, last_checked_at = EXCLUDED.last_checked_at`,
+		repoID, hookID, hookURL, stored, keyID, status, lastError, m.Clock.Now())
+	if err != nil {
+		log.Printf("ERROR: failed to record webhook state for repository %s: %v", repoID, err)
+	}
+}
+
+// ReconcileAll runs Ensure for every registered repository
+func (m *Manager) ReconcileAll(ctx context.Context) error {
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT id, name, source_provider, source_url, credential_id, created_at FROM repositories`)
+	if err != nil {
+		return fmt.Errorf("failed to fetch repositories: %w", err)
+	}
+
+	var repos []models.Repository
+	for rows.Next() {
+		var repo models.Repository
+		if err := rows.Scan(&repo.ID, &repo.Name, &repo.SourceProvider, &repo.SourceURL, &repo.CredentialID, &repo.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan repository: %w", err)
+		}
+		repos = append(repos, repo)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch repositories: %w", err)
+	}
+
+	for _, repo := range repos {
+		if err := m.Ensure(ctx, repo); err != nil {
+			log.Printf("WARN: webhook reconcile failed for repository %s: %v", repo.ID, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Reseal encrypts the webhook secrets stored in plaintext or sealed with
+// an earlier master key with the current one, as credentials.Store.Reseal
+// does for credential secrets. Without a keyring it only checks that no
+// secret is encrypted, as none could be read.
+func (m *Manager) Reseal(ctx context.Context) error {
+	keys := m.Credentials.Keys
+	if keys == nil {
+		var sealed int
+		if err := m.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM repository_webhooks WHERE key_id IS NOT NULL`).Scan(&sealed); err != nil {
+			return fmt.Errorf("failed to count encrypted webhook secrets: %w", err)
+		}
+		if sealed > 0 {
+			return fmt.Errorf("%d webhook secrets are encrypted but no master key is configured", sealed)
+		}
+		return nil
+	}
+
+	type row struct {
+		repoID, stored string
+		keyID          *string
+	}
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT repository_id, secret, key_id FROM repository_webhooks WHERE key_id IS DISTINCT FROM $1`, keys.Current)
+	if err != nil {
+		return fmt.Errorf("failed to fetch webhook secrets to encrypt: %w", err)
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.repoID, &r.stored, &r.keyID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan webhook secret: %w", err)
+		}
+		stale = append(stale, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range stale {
+		secret, err := m.Credentials.Open(r.stored, r.keyID)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt webhook secret of repository %s: %w", r.repoID, err)
+		}
+		stored, keyID, err := m.Credentials.Seal(secret)
+		if err != nil {
+			return err
+		}
+		// Another instance may have resealed the row meanwhile
+		if _, err := m.DB.ExecContext(ctx,
+			`UPDATE repository_webhooks SET secret = $2, key_id = $3 WHERE repository_id = $1 AND key_id IS NOT DISTINCT FROM $4`,
+			r.repoID, stored, keyID, r.keyID); err != nil {
+			return fmt.Errorf("failed to encrypt webhook secret of repository %s: %w", r.repoID, err)
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("Encrypted %d webhook secrets with master key %s", len(stale), keys.Current)
+	}
+	return nil
+}
+
+// Run periodically reconciles webhooks until ctx is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	if !m.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.ReconcileAll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: webhook reconcile failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HostLimits bounds how many jobs talk to one host at once, so a burst of
+// syncs does not hammer a single provider
+type HostLimits struct {
+	// Default is the limit of hosts without one of their own; 0 leaves
+	// them unlimited
+	Default int
+	// Hosts holds the limits of single hosts by lowercase name
+	Hosts map[string]int
+}
+
+// HostLimitsFromEnv reads the default limit from WORKERS_PER_HOST and the
+// limits of single hosts from WORKERS_HOST_LIMITS, comma-separated
+// host=limit pairs such as github.com=8,gitlab.example.com=4
+func HostLimitsFromEnv() (HostLimits, error) {
+	limits := HostLimits{Hosts: map[string]int{}}
+	if raw := os.Getenv("WORKERS_PER_HOST"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("WORKERS_PER_HOST must be a positive number, or 0 for no limit")
+		}
+		limits.Default = n
+	}
+	for _, entry := range strings.Split(os.Getenv("WORKERS_HOST_LIMITS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		host, raw, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if !ok || host == "" || err != nil || n <= 0 {
+			return limits, fmt.Errorf("WORKERS_HOST_LIMITS must list host=limit pairs with positive limits, not %q", entry)
+		}
+		if _, dup := limits.Hosts[host]; dup {
+			return limits, fmt.Errorf("host %s is listed twice in WORKERS_HOST_LIMITS", host)
+		}
+		limits.Hosts[host] = n
+	}
+	return limits, nil
+}
+
+// limit returns the limit of host, 0 when unlimited
+func (l HostLimits) limit(host string) int {
+	if n, ok := l.Hosts[host]; ok {
+		return n
+	}
+	return l.Default
+}
+
+// HostStats is a snapshot of the slots of one host, published under
+// /debug/vars
+type HostStats struct {
+	Limit   int `json:"limit"`
+	Busy    int `json:"busy"`
+	Waiting int `json:"waiting"`
+}
+
+// limiters publishes the stats of every limiter by name
+var limiters = expvar.NewMap("worker_host_limits")
+
+// Limiter hands out the slots of HostLimits. A nil Limiter limits nothing.
+type Limiter struct {
+	Name   string
+	Limits HostLimits
+
+	mu      sync.Mutex
+	slots   map[string]chan struct{}
+	waiting map[string]int
+}
+
+// NewLimiter creates a Limiter and publishes its stats
+func NewLimiter(name string, limits HostLimits) *Limiter {
+	l := &Limiter{Name: name, Limits: limits, slots: map[string]chan struct{}{}, waiting: map[string]int{}}
+	limiters.Set(name, expvar.Func(func() any { return l.Stats() }))
+	return l
+}
+
+// Acquire waits for a slot of host and returns the func releasing it. It
+// returns the error of ctx when ctx is done first.
+func (l *Limiter) Acquire(ctx context.Context, host string) (release func(), err error) {
+	host = strings.ToLower(host)
+	if l == nil || l.Limits.limit(host) == 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.Limits.limit(host))
+		l.slots[host] = slots
+	}
+	l.waiting[host]++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting[host]--
+		l.mu.Unlock()
+	}()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the slots of every host that was limited so far
+func (l *Limiter) Stats() map[string]HostStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make(map[string]HostStats, len(l.slots))
+	for host, slots := range l.slots {
+		stats[host] = HostStats{Limit: cap(slots), Busy: len(slots), Waiting: l.waiting[host]}
+	}
+	return stats
+}
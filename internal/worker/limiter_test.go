@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostLimitsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		perHost string
+		hosts   string
+		want    HostLimits
+		err     string
+	}{
+		{name: "unset", want: HostLimits{Hosts: map[string]int{}}},
+		{name: "default only", perHost: "4", want: HostLimits{Default: 4, Hosts: map[string]int{}}},
+		{name: "no default limit", perHost: "0", want: HostLimits{Hosts: map[string]int{}}},
+		{name: "hosts", perHost: "2", hosts: " GitHub.com=8, gitlab.example.com = 4 ,",
+			want: HostLimits{Default: 2, Hosts: map[string]int{"github.com": 8, "gitlab.example.com": 4}}},
+		{name: "negative default", perHost: "-1", err: "WORKERS_PER_HOST"},
+		{name: "invalid default", perHost: "many", err: "WORKERS_PER_HOST"},
+		{name: "missing limit", hosts: "github.com", err: `not "github.com"`},
+		{name: "missing host", hosts: "=3", err: `not "=3"`},
+		{name: "zero limit", hosts: "github.com=0", err: "positive limits"},
+		{name: "duplicate host", hosts: "github.com=2,GITHUB.COM=3", err: "listed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WORKERS_PER_HOST", tt.perHost)
+			t.Setenv("WORKERS_HOST_LIMITS", tt.hosts)
+			limits, err := HostLimitsFromEnv()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("HostLimitsFromEnv error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HostLimitsFromEnv failed: %v", err)
+			}
+			if limits.Default != tt.want.Default || !maps.Equal(limits.Hosts, tt.want.Hosts) {
+				t.Errorf("HostLimitsFromEnv = %+v, want %+v", limits, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterAcquire(t *testing.T) {
+	limits := HostLimits{Default: 1, Hosts: map[string]int{"github.com": 2, "unlimited.example.com": 0}}
+	tests := []struct {
+		name string
+		host string
+		// slots is how many jobs may hold a slot of host at once, 0 for
+		// any number
+		slots int
+	}{
+		{name: "own limit", host: "github.com", slots: 2},
+		{name: "case-insensitive", host: "GitHub.com", slots: 2},
+		{name: "default limit", host: "gitlab.com", slots: 1},
+		{name: "unlimited", host: "unlimited.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter("test-"+t.Name(), limits)
+			// Unlimited hosts take any number of jobs
+			held := tt.slots
+			if held == 0 {
+				held = 5
+			}
+			var releases []func()
+			for range held {
+				release, err := l.Acquire(context.Background(), tt.host)
+				if err != nil {
+					t.Fatalf("Acquire of a free slot failed: %v", err)
+				}
+				releases = append(releases, release)
+			}
+			if tt.slots == 0 {
+				if len(l.Stats()) != 0 {
+					t.Errorf("unlimited host has stats %+v", l.Stats())
+				}
+				return
+			}
+
+			// Every slot is taken, so the next job waits until ctx is done
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if _, err := l.Acquire(ctx, tt.host); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Acquire of a full host = %v, want %v", err, context.DeadlineExceeded)
+			}
+			host := strings.ToLower(tt.host)
+			if got := l.Stats()[host]; got != (HostStats{Limit: tt.slots, Busy: tt.slots}) {
+				t.Errorf("Stats = %+v, want limit and busy %d with none waiting", got, tt.slots)
+			}
+
+			// A released slot goes to a waiting job
+			acquired := make(chan error, 1)
+			go func() {
+				release, err := l.Acquire(context.Background(), tt.host)
+				if err == nil {
+					defer release()
+				}
+				acquired <- err
+			}()
+			releases[0]()
+			select {
+			case err := <-acquired:
+				if err != nil {
+					t.Errorf("Acquire after a release failed: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Acquire did not get the released slot")
+			}
+			for _, release := range releases[1:] {
+				release()
+			}
+		})
+	}
+
+	t.Run("nil limiter", func(t *testing.T) {
+		var l *Limiter
+		release, err := l.Acquire(context.Background(), "github.com")
+		if err != nil {
+			t.Fatalf("Acquire of a nil limiter failed: %v", err)
+		}
+		release()
+	})
+}
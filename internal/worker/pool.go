@@ -0,0 +1,245 @@
+// Package worker runs background jobs on a pool that grows and shrinks
+// with demand, and bounds how many of them talk to one host at once.
+package worker
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job is a unit of work run by the pool
+type Job func(ctx context.Context)
+
+// Config bounds and tunes a Pool
+type Config struct {
+	Min int
+	Max int
+	// QueueSize is how many jobs may wait for a worker
+	QueueSize int
+	// TargetWait is the queue wait above which the pool grows
+	TargetWait time.Duration
+	// ScaleInterval is how often the pool size is reconsidered
+	ScaleInterval time.Duration
+	// IdleIntervals is how many consecutive idle intervals pass before a
+	// worker above Min is stopped
+	IdleIntervals int
+}
+
+// DefaultConfig returns the configuration used when nothing is set
+func DefaultConfig() Config {
+	return Config{
+		Min:           2,
+		Max:           16,
+		QueueSize:     10000,
+		TargetWait:    5 * time.Second,
+		ScaleInterval: 5 * time.Second,
+		IdleIntervals: 6,
+	}
+}
+
+// ConfigFromEnv reads WORKERS_MIN and WORKERS_MAX on top of DefaultConfig
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	for name, field := range map[string]*int{"WORKERS_MIN": &cfg.Min, "WORKERS_MAX": &cfg.Max} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("%s must be a positive number", name)
+		}
+		*field = n
+	}
+	if cfg.Min > cfg.Max {
+		return cfg, fmt.Errorf("WORKERS_MIN (%d) exceeds WORKERS_MAX (%d)", cfg.Min, cfg.Max)
+	}
+	return cfg, nil
+}
+
+// Stats is a snapshot of a pool, published under /debug/vars
+type Stats struct {
+	Size   int `json:"size"`
+	Busy   int `json:"busy"`
+	Queued int `json:"queued"`
+	// AvgWaitMillis is the mean queue wait of jobs started in the last
+	// scale interval
+	AvgWaitMillis int64 `json:"avg_wait_ms"`
+}
+
+// pools publishes the stats of every pool by name
+var pools = expvar.NewMap("worker_pools")
+
+type queued struct {
+	job      Job
+	enqueued time.Time
+}
+
+// Pool runs submitted jobs on between Min and Max workers. It grows while
+// jobs queue up or wait longer than TargetWait and shrinks back after the
+// queue has stayed empty for a while.
+type Pool struct {
+	Name   string
+	Config Config
+
+	jobs chan queued
+	quit chan struct{}
+	wg   sync.WaitGroup
+
+	size atomic.Int64
+	busy atomic.Int64
+
+	mu        sync.Mutex
+	waitSum   time.Duration
+	waitCount int64
+	lastWait  time.Duration
+	idleTicks int
+}
+
+// NewPool creates a Pool and publishes its stats
+func NewPool(name string, cfg Config) *Pool {
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	p := &Pool{
+		Name:   name,
+		Config: cfg,
+		jobs:   make(chan queued, cfg.QueueSize),
+		quit:   make(chan struct{}),
+	}
+	pools.Set(name, expvar.Func(func() any { return p.Stats() }))
+	return p
+}
+
+// Submit queues job without blocking. It reports false when the queue is
+// full.
+func (p *Pool) Submit(job Job) bool {
+	select {
+	case p.jobs <- queued{job: job, enqueued: time.Now()}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stats returns the current size and load of the pool
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	wait := p.lastWait
+	p.mu.Unlock()
+	return Stats{
+		Size:          int(p.size.Load()),
+		Busy:          int(p.busy.Load()),
+		Queued:        len(p.jobs),
+		AvgWaitMillis: wait.Milliseconds(),
+	}
+}
+
+// Run starts the minimum number of workers and adjusts the pool size until
+// ctx is cancelled, then waits for running jobs to finish
+func (p *Pool) Run(ctx context.Context) {
+	for i := 0; i < p.Config.Min; i++ {
+		p.start(ctx)
+	}
+
+	ticker := time.NewTicker(p.Config.ScaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.wg.Wait()
+			return
+		case <-ticker.C:
+			p.scale(ctx)
+		}
+	}
+}
+
+func (p *Pool) start(ctx context.Context) {
+	p.size.Add(1)
+	p.wg.Add(1)
+	go p.work(ctx)
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+	defer p.size.Add(-1)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.quit:
+			return
+		case q := <-p.jobs:
+			p.mu.Lock()
+			p.waitSum += time.Since(q.enqueued)
+			p.waitCount++
+			p.mu.Unlock()
+
+			p.busy.Add(1)
+			p.run(ctx, q.job)
+			p.busy.Add(-1)
+		}
+	}
+}
+
+// run executes job, keeping a panicking job from taking the worker down
+func (p *Pool) run(ctx context.Context, job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: job in pool %s panicked: %v", p.Name, r)
+		}
+	}()
+	job(ctx)
+}
+
+// scale compares queue depth and wait with the current size: backlog adds
+// workers at once, sustained idleness removes one at a time
+func (p *Pool) scale(ctx context.Context) {
+	p.mu.Lock()
+	var wait time.Duration
+	if p.waitCount > 0 {
+		wait = p.waitSum / time.Duration(p.waitCount)
+	}
+	p.lastWait = wait
+	p.waitSum, p.waitCount = 0, 0
+	p.mu.Unlock()
+
+	size := int(p.size.Load())
+	idle := size - int(p.busy.Load())
+	depth := len(p.jobs)
+
+	if (depth > idle || wait > p.Config.TargetWait) && size < p.Config.Max {
+		grow := min(p.Config.Max-size, max(1, depth-idle))
+		for i := 0; i < grow; i++ {
+			p.start(ctx)
+		}
+		p.idleTicks = 0
+		log.Printf("Worker pool %s grew to %d workers (queued %d, avg wait %s)", p.Name, size+grow, depth, wait)
+		return
+	}
+
+	if depth == 0 && idle > 0 && size > p.Config.Min {
+		p.idleTicks++
+		if p.idleTicks >= p.Config.IdleIntervals {
+			select {
+			case p.quit <- struct{}{}:
+				log.Printf("Worker pool %s shrank to %d workers", p.Name, size-1)
+			default:
+			}
+			p.idleTicks = 0
+		}
+		return
+	}
+	p.idleTicks = 0
+}
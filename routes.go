@@ -0,0 +1,1262 @@
+package gitsync
+
+import (
+	"expvar"
+	"net/http"
+	"time"
+
+	"gitsync/internal/auth"
+	"gitsync/internal/digest"
+	"gitsync/internal/federation"
+	"gitsync/internal/gitops"
+	"gitsync/internal/handlers"
+	"gitsync/internal/metrics"
+	"gitsync/internal/models"
+	"gitsync/internal/openapi"
+	"gitsync/internal/provider"
+	"gitsync/internal/schedule"
+	"gitsync/internal/sessions"
+	"gitsync/internal/tracing"
+
+	"github.com/gorilla/mux"
+	httpSwagger "github.com/swaggo/http-swagger/v2"
+)
+
+// route is an API endpoint together with its documentation
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	op      openapi.Operation
+}
+
+// Routes of the Long and Stream classes of handlers.Timeouts, by method and
+// path; the others are of the Default class
+var (
+	longRoutes = map[string]bool{
+		"POST /repositories/import":                       true,
+		"GET /repositories/{id}/targets/{target_id}/diff": true,
+		"POST /repositories/{id}/restore":                 true,
+		"POST /repositories/{id}/maintenance":             true,
+		"POST /notification-channels/{id}/test":           true,
+		"GET /reports/digest":                             true,
+		"POST /compliance/exports":                        true,
+	}
+	streamRoutes = map[string]bool{
+		"GET /repositories":                     true,
+		"GET /repositories/export.csv":          true,
+		"GET /compliance/exports/{id}/download": true,
+	}
+)
+
+// timeout returns the timeout of the class of rt
+func (rt route) timeout(t handlers.Timeouts) time.Duration {
+	switch key := rt.method + " " + rt.path; {
+	case longRoutes[key]:
+		return t.Long
+	case streamRoutes[key]:
+		return t.Stream
+	}
+	return t.Default
+}
+
+// newRouter registers the API routes of h and serves their OpenAPI
+// document at /openapi.json. Request bodies are capped at maxBody bytes,
+// and requests bounded by the timeout of the class of their route.
+func newRouter(h *handlers.Handler, identity auth.ProxyIdentity, logins *sessions.Manager, maxBody int64, timeouts handlers.Timeouts, collector *metrics.Collector) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware, metrics.Middleware)
+	// Callers are identified by the authenticating proxy in front of GitSync,
+	// or else by the session cookie of users logged in with SSO
+	r.Use(identity.Middleware)
+	r.Use(logins.Middleware)
+	r.Use(handlers.LimitBody(maxBody))
+
+	spec := openapi.New(openapi.Info{
+		Title:       "GitSync API",
+		Description: "API for managing git repositories and replication targets",
+		Version:     "1.0",
+	})
+	spec.AddSecurity("proxyUser", openapi.SecurityScheme{
+		Type: "apiKey",
+		In:   "header",
+		Name: identity.UserHeader,
+		Description: "User name set by the authenticating proxy. Group memberships are read from " +
+			identity.GroupsHeader + ".",
+	})
+	if logins.Enabled() {
+		spec.AddAlternativeSecurity("session", openapi.SecurityScheme{
+			Type: "apiKey",
+			In:   "cookie",
+			Name: sessions.CookieName,
+			Description: "Browser session opened by logging in at /auth/login. Requests other than GET, HEAD and OPTIONS " +
+				"must send the CSRF token of GET /auth/session in the " + sessions.CSRFHeader + " header.",
+		})
+	}
+	for _, rt := range routes(h) {
+		r.HandleFunc(rt.path, handlers.Timeout(rt.timeout(timeouts), rt.handler)).Methods(rt.method)
+		spec.Add(rt.method, rt.path, rt.op)
+	}
+	r.Handle("/openapi.json", spec).Methods("GET")
+
+	// Runtime counters such as worker pool sizes
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	// Prometheus metrics of syncs, the sync queue, workers and requests
+	r.Handle("/metrics", metrics.Handler(collector)).Methods("GET")
+
+	// Swagger UI
+	r.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
+		httpSwagger.URL("/openapi.json"),
+	))
+	return r
+}
+
+func routes(h *handlers.Handler) []route {
+	adminOnly := map[int]string{http.StatusForbidden: "Admin privileges required"}
+	inbox := map[int]string{http.StatusUnauthorized: "Authentication required"}
+
+	return []route{
+		{"GET", "/health", h.HealthCheck, openapi.Operation{
+			Summary: "Health check", Tag: "health",
+			Description: "Returns the health status of the service",
+			Response:    map[string]string{},
+		}},
+		{"GET", "/version", h.Version, openapi.Operation{
+			Summary: "Version and build info", Tag: "health",
+			Description: "Returns the semantic version, git commit, build date and Go version of the server, and which optional features are enabled, so clients can check compatibility",
+			Response:    models.BuildInfo{},
+		}},
+
+		{"GET", "/auth/login", h.Login, openapi.Operation{
+			Summary: "Log in with SSO", Tag: "auth",
+			Description: "Redirect the browser to the OIDC provider set by OIDC_ISSUER to log in. Afterwards the provider returns to /auth/callback, which opens a session and redirects to return_to. " +
+				"Browser sessions are for people; scripts and services keep authenticating through the proxy headers.",
+			Params: []openapi.Param{{Name: "return_to", Description: "Path to return to after logging in; / when unset"}},
+			Status: http.StatusFound,
+			Errors: map[int]string{http.StatusBadGateway: "OIDC provider unreachable", http.StatusServiceUnavailable: "SSO login is not configured"},
+		}},
+		{"GET", "/auth/callback", h.LoginCallback, openapi.Operation{
+			Summary: "Complete an SSO login", Tag: "auth",
+			Description: "Where the OIDC provider returns the browser. The authorization code is redeemed, the ID token verified and a session cookie set, valid for SESSION_TTL.",
+			Params: []openapi.Param{
+				{Name: "code", Description: "Authorization code"},
+				{Name: "state", Description: "State of the login, matching the login cookie"},
+			},
+			Status: http.StatusFound,
+			Errors: map[int]string{http.StatusBadRequest: "Login failed, expired or started elsewhere", http.StatusBadGateway: "OIDC provider error", http.StatusServiceUnavailable: "SSO login is not configured"},
+		}},
+		{"GET", "/auth/session", h.GetSession, openapi.Operation{
+			Summary: "Get the session", Tag: "auth",
+			Description: "Get the user and groups of the browser session and its CSRF token, which requests of the session other than GET, HEAD and OPTIONS must send in the X-CSRF-Token header.",
+			Response:    models.Session{},
+			Errors:      map[int]string{http.StatusUnauthorized: "Not logged in", http.StatusServiceUnavailable: "SSO login is not configured"},
+		}},
+		{"POST", "/auth/logout", h.Logout, openapi.Operation{
+			Summary: "Log out", Tag: "auth",
+			Description: "End the browser session and clear its cookie. Requires the X-CSRF-Token header.",
+			Status:      http.StatusNoContent,
+			Errors:      map[int]string{http.StatusForbidden: "Missing or invalid CSRF token", http.StatusServiceUnavailable: "SSO login is not configured"},
+		}},
+
+		{"POST", "/repositories", h.CreateRepository, openapi.Operation{
+			Summary: "Create a repository", Tag: "repositories",
+			Description: "Create a new repository with source provider and URL. It gets the default targets unless skip_default_targets is set. " +
+				"A template fills in the settings the request leaves unset and sets the push mode of the targets.",
+			Body: models.CreateRepositoryRequest{}, Status: http.StatusCreated, Response: models.Repository{},
+			Errors: map[int]string{
+				http.StatusBadRequest:          "Invalid repository",
+				http.StatusConflict:            "Repository already exists",
+				http.StatusUnprocessableEntity: "A default target cannot be added",
+			},
+		}},
+		{"GET", "/repositories", h.ListRepositories, openapi.Operation{
+			Summary: "List repositories", Tag: "repositories",
+			Description: "Get all repositories with their replication targets and a 0-100 health score rating recent sync success, targets in sync, credentials and the push webhook. With RBAC enabled, non-admins only see repositories of their teams. " +
+				"With page or per_page set, one page is listed: X-Total-Count is the number of matching repositories and a Link header with rel=next points at the next page, if any.",
+			Params: []openapi.Param{
+				{Name: "owner", Description: "Only repositories with this owner"},
+				{Name: "team", Description: "Only repositories of this team"},
+				{Name: "name", Description: "Only repositories with this name"},
+				{Name: "name_contains", Description: "Only repositories whose name contains this, ignoring case"},
+				{Name: "provider", Description: "Only repositories with this source provider"},
+				{Name: "source_url", Description: "Only the repository with this normalized source URL"},
+				{Name: "external_id", Description: "Only the repository with this external ID"},
+				{Name: "sync_status", Description: "Only repositories whose latest execution has this status, such as success or failed, or never for those never synced"},
+				{Name: "sort", Description: "health to list the least healthy repositories first, created_at, name or last_synced_at; prefixed with - for descending order. Newest first when omitted."},
+				{Name: "page", Type: "integer", Description: "Page to list, from 1"},
+				{Name: "per_page", Type: "integer", Description: "Repositories per page, up to 500 (default 50)"},
+			},
+			Response: []models.Repository{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid sort or page"},
+		}},
+		{"POST", "/repositories/import", h.ImportRepositories, openapi.Operation{
+			Summary: "Import repositories in bulk", Tag: "repositories",
+			Description: "Create many repositories at once, each with the default targets unless it sets skip_default_targets. All rows are validated and checked against policies first; then they are inserted in batches within one transaction.",
+			Body:        []models.CreateRepositoryRequest{}, Status: http.StatusCreated, Response: []models.Repository{},
+			Errors: map[int]string{
+				http.StatusBadRequest:          "Invalid repository",
+				http.StatusConflict:            "Repository already exists",
+				http.StatusUnprocessableEntity: "A default target cannot be added",
+			},
+		}},
+		{"POST", "/import/csv", h.ImportCSV, openapi.Operation{
+			Summary: "Import repositories from CSV", Tag: "repositories",
+			Description: "Create repositories and their targets from a CSV inventory of existing mirrors, sent as the text/csv body or the file field of a multipart form. " +
+				"The header row names the columns: name, provider, source_url and target_urls (separated by semicolons or spaces, and templates like those of targets) are required; " +
+				"target_provider (the provider when unset), credential, target_credential, owner, team, external_id, skip_default_targets and template are optional. " +
+				"Repositories get the default targets as well, unless skip_default_targets is true. " +
+				"Every row is validated and checked against policies first; if any fails, nothing is written and the errors are returned by line. " +
+				"Otherwise all repositories and targets are created in one transaction.",
+			Params: []openapi.Param{{Name: "validate", Description: "true to only validate the rows"}},
+			Status: http.StatusCreated, Response: models.CSVImportResult{},
+			Errors: map[int]string{
+				http.StatusBadRequest:          "Malformed CSV or unknown columns",
+				http.StatusConflict:            "Repository already exists",
+				http.StatusUnprocessableEntity: "Invalid rows; the result lists their errors",
+			},
+		}},
+		{"GET", "/repositories/export.csv", h.ExportRepositoriesCSV, openapi.Operation{
+			Summary: "Export repositories as CSV", Tag: "repositories",
+			Description: "Stream one CSV row per repository with its targets and its latest finished sync, for spreadsheets and reporting. Takes the filters of the listing; with RBAC enabled, non-admins only see repositories of their teams.",
+			Params: []openapi.Param{
+				{Name: "owner", Description: "Only repositories with this owner"},
+				{Name: "team", Description: "Only repositories of this team"},
+				{Name: "name", Description: "Only repositories with this name"},
+				{Name: "name_contains", Description: "Only repositories whose name contains this, ignoring case"},
+				{Name: "provider", Description: "Only repositories with this source provider"},
+				{Name: "source_url", Description: "Only the repository with this normalized source URL"},
+				{Name: "external_id", Description: "Only the repository with this external ID"},
+				{Name: "sync_status", Description: "Only repositories whose latest execution has this status, such as success or failed, or never for those never synced"},
+			},
+			Response: []byte{}, Produces: "text/csv",
+		}},
+		{"GET", "/repositories/{id}", h.GetRepository, openapi.Operation{
+			Summary: "Get a repository", Tag: "repositories",
+			Description: "Get a repository with its replication targets and the latest sync of each, and when it is synced next and why, as detailed by its schedule. With RBAC enabled, non-admins only see repositories of their teams.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Response:    models.Repository{},
+			Errors:      map[int]string{http.StatusNotFound: "Repository not found"},
+		}},
+		{"PUT", "/repositories/{id}", h.PutRepository, openapi.Operation{
+			Summary: "Create or replace a repository", Tag: "repositories",
+			Description: "Create the repository with the given ID, or replace every field of an existing one. Repeating the request has no further effect. A repository it creates gets the default targets unless skip_default_targets is set. " +
+				"A template fills in the settings the request leaves unset and sets the push mode of the targets.",
+			Params: []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Body:   models.CreateRepositoryRequest{}, Response: models.Repository{},
+			Errors: map[int]string{
+				http.StatusBadRequest:          "Invalid repository",
+				http.StatusConflict:            "Repository already exists or is managed by GitOps or federation",
+				http.StatusUnprocessableEntity: "A default target cannot be added",
+			},
+		}},
+		{"PATCH", "/repositories/{id}", h.PatchRepository, openapi.Operation{
+			Summary: "Update a repository", Tag: "repositories",
+			Description: "Change the name, source provider, source URL, sync schedule or enabled flag of a repository, leaving the fields the request omits as they are. " +
+				"The result is validated as a new repository would be. Disabled repositories are not synced on schedule or by push webhooks.",
+			Params: []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Body:   models.UpdateRepositoryRequest{}, Response: models.Repository{},
+			Errors: map[int]string{http.StatusBadRequest: "Invalid fields", http.StatusNotFound: "Repository not found",
+				http.StatusConflict: "Repository is managed by GitOps or federation, or another repository has the source URL"},
+		}},
+		{"DELETE", "/repositories/{id}", h.DeleteRepository, openapi.Operation{
+			Summary: "Delete a repository", Tag: "repositories",
+			Description: "Delete a repository with its targets and sync history, which are kept in the trash for restoring until the trash retention passes. " +
+				"Its mirror is removed, to be cloned again if the repository is restored.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "force", In: "query", Description: "Delete the repository even while a sync of it is queued or running on any instance, failing the sync run and stopping it: " +
+					"on this instance before the mirror is removed, on others on their next heartbeat"},
+			},
+			Status: http.StatusNoContent,
+			Errors: map[int]string{http.StatusNotFound: "Repository not found", http.StatusConflict: "Repository is managed by GitOps or federation, busy with another job, or queued or syncing without force"},
+		}},
+		{"POST", "/repositories/{id}/merge", h.MergeRepository, openapi.Operation{
+			Summary: "Merge a duplicate repository", Tag: "repositories",
+			Description: "Merge a repository mirroring the same upstream into this one, as found after URL normalization was tightened, rather than deleting and recreating it. " +
+				"The targets of the duplicate move over with their sync history, findings, approvals, group memberships and notification channel scopes; targets replicating to a remote this repository replicates to as well are folded into its target. " +
+				"This repository keeps its settings, gains the labels, owner, team and external ID it lacks, and its push webhook is re-applied. The duplicate is then deleted. Admin only.",
+			Params:   []openapi.Param{{Name: "id", In: "path", Description: "ID of the repository that is kept"}},
+			Body:     models.MergeRepositoryRequest{},
+			Response: models.RepositoryMerge{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Invalid duplicate_id",
+				http.StatusForbidden:  "Admin privileges required",
+				http.StatusNotFound:   "Repository not found",
+				http.StatusConflict:   "Repositories mirror different upstreams, the duplicate is managed by GitOps or federation or either is busy syncing",
+			},
+		}},
+		{"POST", "/repositories/{id}/promote", h.PromoteTarget, openapi.Operation{
+			Summary: "Promote a target to source", Tag: "repositories",
+			Description: "Fail the repository over to one of its targets during an upstream outage. The target becomes the source, with its provider and credential, " +
+				"and moves to the trash with its sync history. With demote=true the previous source becomes a target pushed to with its credential. " +
+				"Alternate sources of another provider are dropped, the push webhook moves to the new source and the source activity is reset, " +
+				"so the adaptive schedule syncs often until it settles. A sync from the new source is queued right away. " +
+				"Only approved targets replicating all refs without rewriting history can be promoted. Admin only.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "target", Description: "ID of the target to promote"},
+				{Name: "demote", Description: "true to keep the previous source as a target"},
+			},
+			Response: models.RepositoryPromotion{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Missing target or invalid demote",
+				http.StatusForbidden:  "Admin privileges required or policy violated",
+				http.StatusNotFound:   "Repository or target not found",
+				http.StatusConflict: "Target holds part of the history or is pending approval, another repository mirrors it, " +
+					"the repository is managed by GitOps or federation or it is busy syncing",
+			},
+		}},
+		{"POST", "/repositories/{id}/targets", h.CreateTarget, openapi.Operation{
+			Summary: "Create a replication target", Tag: "targets",
+			Description: "Add a replication target to an existing repository. The remote_url may be a template using {{org}}, {{name}}, {{path}} and {{host}}, filled in from the source URL of the repository.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Body:        models.CreateTargetRequest{}, Status: http.StatusCreated, Response: models.Target{},
+			Errors: map[int]string{http.StatusNotFound: "Repository not found", http.StatusConflict: "Target already exists"},
+		}},
+		{"POST", "/repositories/{id}/sync", h.SyncRepository, openapi.Operation{
+			Summary: "Sync a repository now", Tag: "repositories",
+			Description: "Queue a sync of every approved target of a repository, or of the targets given, without waiting for its schedule, " +
+				"such as after fixing a credential. Responds with the queued sync run right away; follow it under /syncs/{id}. " +
+				"During a freeze period only admins may sync, with override=true.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "target", Description: "Only sync this target; repeat for several"},
+				{Name: "override", Type: "boolean", Description: "Sync despite an active freeze period. Admin only."},
+			},
+			Status: http.StatusAccepted, Response: models.SyncRun{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Target is not an approved target of the repository",
+				http.StatusForbidden:  "Admin privileges required to override a freeze",
+				http.StatusNotFound:   "Repository not found",
+				http.StatusConflict:   "Repository is busy syncing or syncing is frozen",
+			},
+		}},
+		{"GET", "/repositories/{id}/syncs", h.ListRepositorySyncs, openapi.Operation{
+			Summary: "List the syncs of a repository", Tag: "repositories",
+			Description: "Get the most recent syncs of a repository, however they were triggered, newest first: when each ran, how long it took and how many targets succeeded or failed",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "status", Description: "Only syncs with this status: queued, running, succeeded or failed"},
+				{Name: "limit", Type: "integer", Description: "At most this many syncs, up to 200 (default 50)"},
+			},
+			Response: []models.SyncRun{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid limit", http.StatusNotFound: "Repository not found"},
+		}},
+		{"GET", "/syncs/{id}", h.GetSync, openapi.Operation{
+			Summary: "Get a sync", Tag: "repositories",
+			Description: "Get a sync run with the execution of each of its targets, including the error of those that failed",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Sync run ID"}},
+			Response:    models.SyncRunDetail{},
+			Errors:      map[int]string{http.StatusNotFound: "Sync run not found"},
+		}},
+		{"POST", "/webhooks/{provider}", h.ReceiveWebhook, openapi.Operation{
+			Summary: "Receive a push webhook", Tag: "webhooks",
+			Description: "Receiver of the push webhooks GitSync installs on sources when WEBHOOK_BASE_URL is set, such as /webhooks/github. " +
+				"The repository is found by the clone URL of the payload and the delivery authenticated with the secret of its webhook, " +
+				"or with <PROVIDER>_WEBHOOK_SECRET, such as GITHUB_WEBHOOK_SECRET, for repositories whose webhook was configured by hand " +
+				"(X-Hub-Signature-256 for GitHub, X-Gitea-Signature for Gitea, X-Gitlab-Token for GitLab). A push queues a sync right away " +
+				"unless the repository is queued or syncing already, or its scheduled syncs are held back; other events are ignored. " +
+				"Callers need no user identity.",
+			Params:   []openapi.Param{{Name: "provider", In: "path", Description: "Source provider, such as github"}},
+			Body:     map[string]any{},
+			Status:   http.StatusAccepted,
+			Response: models.WebhookDelivery{},
+			Errors: map[int]string{
+				http.StatusBadRequest:   "Payload names no repository or cannot be decoded",
+				http.StatusUnauthorized: "Invalid webhook signature",
+				http.StatusNotFound:     "Unknown provider or repository not registered",
+			},
+		}},
+		{"GET", "/repositories/{id}/schedule", h.GetRepositorySchedule, openapi.Operation{
+			Summary: "Get the sync schedule of a repository", Tag: "repositories",
+			Description: "Get the effective sync interval of a repository and why it was chosen (new, hot, default, stale, fixed, group, or cron and default_cron for repositories following their own or the server-wide cron schedule), when its next scheduled sync is due, what holds scheduled syncs back if anything does, and its latest executions with what triggered them",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Response:    schedule.Report{},
+			Errors:      map[int]string{http.StatusNotFound: "Repository not found"},
+		}},
+		{"GET", "/repositories/{id}/retention", h.GetRepositoryRetention, openapi.Operation{
+			Summary: "Get repository retention", Tag: "repositories",
+			Description: "Get how long sync history, logs and cached mirror data of a repository are kept",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Response:    models.RepositoryRetention{},
+			Errors:      map[int]string{http.StatusNotFound: "Repository not found"},
+		}},
+		{"PUT", "/repositories/{id}/retention", h.UpdateRepositoryRetention, openapi.Operation{
+			Summary: "Set repository retention", Tag: "repositories",
+			Description: "Override the global retention defaults for a repository; null restores the default",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Body:        models.RetentionOverrides{}, Response: models.RepositoryRetention{},
+			Errors: map[int]string{http.StatusNotFound: "Repository not found"},
+		}},
+		{"GET", "/repositories/{id}/transfer", h.GetRepositoryTransfer, openapi.Operation{
+			Summary: "Get repository transfer settings", Tag: "repositories",
+			Description: "Get the git wire protocol version, fetch negotiation and pack settings syncs of a repository use",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Response:    models.RepositoryTransfer{},
+			Errors:      map[int]string{http.StatusNotFound: "Repository not found"},
+		}},
+		{"PUT", "/repositories/{id}/transfer", h.UpdateRepositoryTransfer, openapi.Operation{
+			Summary: "Set repository transfer settings", Tag: "repositories",
+			Description: "Override the global transfer settings for syncs of a repository, for example to force protocol version 2 or raise pack threads for a large repository; null restores the global setting",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Body:        models.Transfer{}, Response: models.RepositoryTransfer{},
+			Errors: map[int]string{http.StatusBadRequest: "Invalid transfer settings", http.StatusNotFound: "Repository not found"},
+		}},
+		{"GET", "/repositories/{id}/targets", h.ListRepositoryTargets, openapi.Operation{
+			Summary: "List the targets of a repository", Tag: "targets",
+			Description: "Get the replication targets of a repository in the order they are synced",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Response:    []models.Target{},
+			Errors:      map[int]string{http.StatusNotFound: "Repository not found"},
+		}},
+		{"GET", "/repositories/{id}/targets/{target_id}", h.GetRepositoryTarget, openapi.Operation{
+			Summary: "Get a target of a repository", Tag: "targets",
+			Description: "Get a replication target of a repository",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "target_id", In: "path", Description: "Target ID"},
+			},
+			Response: models.Target{},
+			Errors:   map[int]string{http.StatusNotFound: "Target not found"},
+		}},
+		{"PATCH", "/repositories/{id}/targets/{target_id}", h.PatchTarget, openapi.Operation{
+			Summary: "Update a replication target", Tag: "targets",
+			Description: "Change the remote URL, credential, priority, required flag, excluded refs or protection of a target, leaving the fields the request omits as they are. " +
+				"The result is validated as a new target would be, and changing the remote URL requires approval again.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "target_id", In: "path", Description: "Target ID"},
+			},
+			Body: models.UpdateTargetRequest{}, Response: models.Target{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Invalid fields",
+				http.StatusNotFound:   "Target not found",
+				http.StatusConflict:   "Another target has the remote URL or the repository is managed by GitOps or federation",
+			},
+		}},
+		{"DELETE", "/repositories/{id}/targets/{target_id}", h.DeleteRepositoryTarget, openapi.Operation{
+			Summary: "Delete a target of a repository", Tag: "targets",
+			Description: "Delete a replication target and its sync history, which are kept in the trash for restoring until the trash retention passes. " +
+				"The remote repository is left as it is unless delete_remote is set.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "target_id", In: "path", Description: "Target ID"},
+				{Name: "delete_remote", In: "query", Description: "true to delete the remote repository at the provider as well, which restoring the target does not undo. Admin only."},
+			},
+			Status: http.StatusNoContent,
+			Errors: map[int]string{
+				http.StatusForbidden:  "Admin privileges required to delete the remote",
+				http.StatusNotFound:   "Target not found",
+				http.StatusConflict:   "Repository is managed by GitOps or federation, or is busy syncing",
+				http.StatusBadGateway: "Remote repository could not be deleted; the target is kept",
+			},
+		}},
+		{"PUT", "/repositories/{id}/targets/{target_id}", h.PutTarget, openapi.Operation{
+			Summary: "Create or replace a replication target", Tag: "targets",
+			Description: "Create the target with the given ID, or replace every field of an existing one. Changing the remote URL requires approval again.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "target_id", In: "path", Description: "Target ID"},
+			},
+			Body: models.CreateTargetRequest{}, Response: models.Target{},
+			Errors: map[int]string{
+				http.StatusNotFound: "Repository not found",
+				http.StatusConflict: "Target already exists, belongs to another repository or is managed by GitOps or federation",
+			},
+		}},
+		{"GET", "/repositories/{id}/targets/{target_id}/diff", h.DiffTarget, openapi.Operation{
+			Summary: "Compare a target with its source", Tag: "targets",
+			Description: "Fetch from the source and the target and report, per branch, the commits the target is behind and ahead of the source, " +
+				"plus refs missing from or extra on the target and tags pointing elsewhere. Computed on demand.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Repository ID"},
+				{Name: "target_id", In: "path", Description: "Target ID"},
+			},
+			Response: models.TargetDiff{},
+			Errors: map[int]string{
+				http.StatusNotFound:   "Target not found",
+				http.StatusConflict:   "Repository is busy syncing",
+				http.StatusBadGateway: "Source or target could not be fetched",
+			},
+		}},
+		{"POST", "/targets/bulk", h.BulkCreateTargets, openapi.Operation{
+			Summary: "Create targets in bulk from a template", Tag: "targets",
+			Description: "Add a target to each listed repository. The remote_url is a template such as ssh://git@gitea.corp/{{org}}/{{name}}.git; " +
+				"{{org}}, {{name}}, {{path}} and {{host}} are filled in from the source URL of each repository. " +
+				"Every target is validated and checked against policies first; if any fails, nothing is created and the errors are returned by repository. " +
+				"Otherwise all targets are created in one transaction.",
+			Params: []openapi.Param{{Name: "validate", Description: "true to only validate the targets"}},
+			Body:   models.BulkTargetRequest{}, Status: http.StatusCreated, Response: models.BulkTargetResult{},
+			Errors: map[int]string{
+				http.StatusBadRequest:          "Invalid request or template",
+				http.StatusConflict:            "Target already exists",
+				http.StatusUnprocessableEntity: "Invalid targets; the result lists their errors",
+			},
+		}},
+		{"GET", "/targets", h.ListTargets, openapi.Operation{
+			Summary: "List replication targets", Tag: "targets",
+			Description: "Get replication targets. With RBAC enabled, non-admins only see targets of their teams' repositories.",
+			Params: []openapi.Param{
+				{Name: "repository_id", Description: "Only targets of this repository"},
+				{Name: "remote_url", Description: "Only targets with this normalized remote URL"},
+				{Name: "external_id", Description: "Only the target with this external ID"},
+				{Name: "dead_lettered", Description: "true for only the targets dead-lettered after failing too many syncs in a row, false for only the others"},
+			},
+			Response: []models.Target{},
+		}},
+		{"GET", "/targets/{id}", h.GetTarget, openapi.Operation{
+			Summary: "Get a replication target", Tag: "targets",
+			Description: "Get a replication target",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Target ID"}},
+			Response:    models.Target{},
+			Errors:      map[int]string{http.StatusNotFound: "Target not found"},
+		}},
+		{"DELETE", "/targets/{id}", h.DeleteTarget, openapi.Operation{
+			Summary: "Delete a replication target", Tag: "targets",
+			Description: "Delete a replication target and its sync history, which are kept in the trash for restoring until the trash retention passes. " +
+				"The remote repository is left as it is unless delete_remote is set.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Target ID"},
+				{Name: "delete_remote", In: "query", Description: "true to delete the remote repository at the provider as well, which restoring the target does not undo. Admin only."},
+			},
+			Status: http.StatusNoContent,
+			Errors: map[int]string{
+				http.StatusForbidden:  "Admin privileges required to delete the remote",
+				http.StatusNotFound:   "Target not found",
+				http.StatusConflict:   "Repository is managed by GitOps or federation, or is busy syncing",
+				http.StatusBadGateway: "Remote repository could not be deleted; the target is kept",
+			},
+		}},
+		{"POST", "/targets/{id}/approve", h.ApproveTarget, openapi.Operation{
+			Summary: "Approve a replication target", Tag: "targets",
+			Description: "Approve a target created by a non-admin so it is included in syncs. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Target ID"}},
+			Response:    models.Target{},
+			Errors:      map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusNotFound: "Target not found"},
+		}},
+		{"POST", "/targets/{id}/redrive", h.RedriveTarget, openapi.Operation{
+			Summary: "Re-drive a dead-lettered target", Tag: "targets",
+			Description: "Failed syncs of a target are retried with exponential backoff until it fails SYNC_RETRY_MAX_ATTEMPTS syncs in a row, " +
+				"when it is dead-lettered and left out of syncs. Re-driving gives it its attempts back and queues a sync of it right away; " +
+				"when the repository is queued or syncing already, its next sync includes the target.",
+			Params:   []openapi.Param{{Name: "id", In: "path", Description: "Target ID"}},
+			Status:   http.StatusAccepted,
+			Response: models.TargetRedrive{},
+			Errors:   map[int]string{http.StatusNotFound: "Target not found", http.StatusConflict: "Target is not dead-lettered"},
+		}},
+		{"GET", "/targets/{id}/identity-mapping", h.GetIdentityMapping, openapi.Operation{
+			Summary: "Get the identity mapping of a target", Tag: "targets",
+			Description: "Get the latest version of the mailmap-style rules that rewrite author, committer and tagger identities in the history pushed to a target, or the version given",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Target ID"},
+				{Name: "version", In: "query", Description: "Version to get instead of the latest"},
+			},
+			Response: models.IdentityMapping{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid version", http.StatusNotFound: "Target or identity mapping not found"},
+		}},
+		{"GET", "/targets/{id}/identity-mapping/versions", h.ListIdentityMappings, openapi.Operation{
+			Summary: "List identity mapping versions", Tag: "targets",
+			Description: "List every version of the identity mapping of a target, newest first",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Target ID"}},
+			Response:    []models.IdentityMapping{},
+			Errors:      map[int]string{http.StatusNotFound: "Target not found"},
+		}},
+		{"PUT", "/targets/{id}/identity-mapping", h.PutIdentityMapping, openapi.Operation{
+			Summary: "Set the identity mapping of a target", Tag: "targets",
+			Description: "Store rules as the next version of the identity mapping of a target. A rule matches an email, or *@domain, and optionally a name, and replaces the name, the email or both; rules matching a name take precedence. The next sync pushes the history rewritten with them. An empty list turns rewriting off.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Target ID"}},
+			Body:        models.IdentityMappingRequest{},
+			Status:      http.StatusCreated,
+			Response:    models.IdentityMapping{},
+			Errors:      map[int]string{http.StatusBadRequest: "Invalid rules", http.StatusNotFound: "Target not found", http.StatusConflict: "Identity mapping changed concurrently"},
+		}},
+
+		{"GET", "/trash", h.ListTrash, openapi.Operation{
+			Summary: "List deleted repositories and targets", Tag: "trash",
+			Description: "List the repositories and targets deleted within the trash retention, newest first. They can be restored until they expire. With RBAC enabled, non-admins only see those of their teams.",
+			Params:      []openapi.Param{{Name: "type", Description: "Only entries of this type: repository or target"}},
+			Response:    []models.TrashEntry{},
+			Errors:      map[int]string{http.StatusBadRequest: "Invalid type"},
+		}},
+		{"POST", "/trash/{id}/restore", h.RestoreTrash, openapi.Operation{
+			Summary: "Restore a deleted repository or target", Tag: "trash",
+			Description: "Put a deleted repository, with its targets, or a deleted target back along with its sync history, and remove it from the trash. A target can only be restored while its repository exists.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Trash entry ID"}},
+			Response:    models.TrashEntry{},
+			Errors: map[int]string{http.StatusNotFound: "Trash entry not found",
+				http.StatusConflict: "Repository of the target is deleted, or the URL is taken by a newer repository or target"},
+		}},
+
+		{"GET", "/ssh-host-keys", h.ListSSHHostKeys, openapi.Operation{
+			Summary: "List trusted SSH host keys", Tag: "ssh-host-keys",
+			Description: "Get the host keys git trusts for SSH remotes, pinned through the API or trusted on first use",
+			Params:      []openapi.Param{{Name: "host", Description: "Only keys of this host, given as name or [name]:port"}},
+			Response:    []models.SSHHostKey{},
+			Errors:      map[int]string{http.StatusBadRequest: "Invalid host"},
+		}},
+		{"PUT", "/ssh-host-keys/{host}", h.PutSSHHostKeys, openapi.Operation{
+			Summary: "Pin the SSH host keys of a host", Tag: "ssh-host-keys",
+			Description: "Replace the keys trusted for a host with the given ones, such as after the host rotated its key. Connections to the host presenting any other key are refused. Admin only.",
+			Params:      []openapi.Param{{Name: "host", In: "path", Description: "Host name, or [name]:port for a port other than 22"}},
+			Body:        models.SSHHostKeysRequest{}, Response: []models.SSHHostKey{},
+			Errors: map[int]string{
+				http.StatusBadRequest:         "Invalid host or key",
+				http.StatusForbidden:          "Admin privileges required",
+				http.StatusServiceUnavailable: "SSH host keys are not managed",
+			},
+		}},
+		{"DELETE", "/ssh-host-keys/{host}", h.DeleteSSHHostKeys, openapi.Operation{
+			Summary: "Forget the SSH host keys of a host", Tag: "ssh-host-keys",
+			Description: "Remove the keys trusted for a host. With the tofu policy the next connection trusts the key the host presents; with the strict policy connections are refused until a key is pinned. Admin only.",
+			Params:      []openapi.Param{{Name: "host", In: "path", Description: "Host name, or [name]:port for a port other than 22"}},
+			Status:      http.StatusNoContent,
+			Errors: map[int]string{
+				http.StatusBadRequest:         "Invalid host",
+				http.StatusForbidden:          "Admin privileges required",
+				http.StatusNotFound:           "Host has no trusted keys",
+				http.StatusServiceUnavailable: "SSH host keys are not managed",
+			},
+		}},
+
+		{"GET", "/gitops/status", h.GetGitOpsStatus, openapi.Operation{
+			Summary: "GitOps reconciliation status", Tag: "gitops",
+			Description: "Get the state file revision last applied, the changes it made and the drift between the state file and the database that was not applied",
+			Response:    gitops.Status{},
+		}},
+		{"GET", "/federation/status", h.GetFederationStatus, openapi.Operation{
+			Summary: "Federation status", Tag: "federation",
+			Description: "Get the role of this instance, how it exchanges state with its peer and when it last did. " +
+				"On a standby, also the revision of the primary's state last applied, the changes it made and the drift not applied, including conflicts with repositories of the standby.",
+			Response: federation.Status{},
+		}},
+		{"GET", "/federation/state", h.GetFederationState, openapi.Operation{
+			Summary: "Export the state of a primary", Tag: "federation",
+			Description: "Get the repositories and approved targets of this primary as a GitOps state file, for its standby to pull. " +
+				"Credential profiles are referenced by name and must exist on the standby. The ETag is the revision of the state. Admin only.",
+			Errors: map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusConflict: "This instance is not a federation primary"},
+		}},
+		{"PUT", "/federation/state", h.PutFederationState, openapi.Operation{
+			Summary: "Push the state of the primary", Tag: "federation",
+			Description: "Apply the state of the primary, as exported by GET /federation/state, to this standby. " +
+				"Repositories it declares become managed by federation and read-only here. Those the standby already has are taken over or kept as conflicts, as FEDERATION_CONFLICT says. Admin only.",
+			Response: federation.Status{},
+			Errors: map[int]string{
+				http.StatusForbidden:             "Admin privileges required",
+				http.StatusConflict:              "This instance is not a standby accepting pushes, or has been promoted",
+				http.StatusRequestEntityTooLarge: "State exceeds the request body limit",
+				http.StatusUnprocessableEntity:   "State could not be applied",
+			},
+		}},
+		{"POST", "/federation/promote", h.PromoteFederation, openapi.Operation{
+			Summary: "Promote a standby", Tag: "federation",
+			Description: "Fail over to this standby: the repositories replicated from the primary become editable and its state is no longer applied. " +
+				"Change FEDERATION_ROLE before restarting, or replication resumes. Admin only.",
+			Response: federation.Status{},
+			Errors:   map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusConflict: "This instance is not a standby"},
+		}},
+
+		{"POST", "/groups", h.CreateGroup, openapi.Operation{
+			Summary: "Create a sync group", Tag: "groups",
+			Description: "Create a named group of repositories that are synced, paused and scheduled together",
+			Body:        models.SyncGroupRequest{}, Status: http.StatusCreated, Response: models.SyncGroup{},
+			Errors: map[int]string{http.StatusBadRequest: "Invalid group or unknown repository", http.StatusConflict: "Group already exists"},
+		}},
+		{"GET", "/groups", h.ListGroups, openapi.Operation{
+			Summary: "List sync groups", Tag: "groups",
+			Description: "Get all sync groups with their member repositories",
+			Params:      []openapi.Param{{Name: "external_id", Description: "Only the group with this external ID"}},
+			Response:    []models.SyncGroup{},
+		}},
+		{"GET", "/groups/{id}", h.GetGroup, openapi.Operation{
+			Summary: "Get a sync group", Tag: "groups",
+			Description: "Get a sync group with its member repositories",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Group ID"}},
+			Response:    models.SyncGroup{},
+			Errors:      map[int]string{http.StatusNotFound: "Group not found"},
+		}},
+		{"PUT", "/groups/{id}", h.PutGroup, openapi.Operation{
+			Summary: "Create or replace a sync group", Tag: "groups",
+			Description: "Create the group with the given ID, or replace every field and the members of an existing one",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Group ID"}},
+			Body:        models.SyncGroupRequest{}, Response: models.SyncGroup{},
+			Errors: map[int]string{http.StatusBadRequest: "Invalid group or unknown repository", http.StatusConflict: "Group already exists"},
+		}},
+		{"DELETE", "/groups/{id}", h.DeleteGroup, openapi.Operation{
+			Summary: "Delete a sync group", Tag: "groups",
+			Description: "Delete a sync group and its run history; the member repositories are kept",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Group ID"}},
+			Status:      http.StatusNoContent,
+			Errors:      map[int]string{http.StatusNotFound: "Group not found"},
+		}},
+		{"POST", "/groups/{id}/sync", h.SyncGroup, openapi.Operation{
+			Summary: "Sync a group", Tag: "groups",
+			Description: "Queue a sync of every member repository at once. Members already syncing are skipped; follow the run under /groups/{id}/runs. During a freeze period only admins may sync, with override=true.",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Group ID"},
+				{Name: "override", Type: "boolean", Description: "Sync despite an active freeze period. Admin only."},
+			},
+			Status: http.StatusAccepted, Response: models.SyncGroupRun{},
+			Errors: map[int]string{
+				http.StatusForbidden: "Admin privileges required to override a freeze",
+				http.StatusNotFound:  "Group not found",
+				http.StatusConflict:  "Group is paused or syncing is frozen",
+			},
+		}},
+		{"POST", "/groups/{id}/pause", h.PauseGroup, openapi.Operation{
+			Summary: "Pause a group", Tag: "groups",
+			Description: "Stop scheduled syncs of the group's members and reject manual group syncs until resumed",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Group ID"}},
+			Response:    models.SyncGroup{},
+			Errors:      map[int]string{http.StatusNotFound: "Group not found"},
+		}},
+		{"POST", "/groups/{id}/resume", h.ResumeGroup, openapi.Operation{
+			Summary: "Resume a group", Tag: "groups",
+			Description: "Resume scheduled syncs of the group's members",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Group ID"}},
+			Response:    models.SyncGroup{},
+			Errors:      map[int]string{http.StatusNotFound: "Group not found"},
+		}},
+		{"GET", "/groups/{id}/runs", h.ListGroupRuns, openapi.Operation{
+			Summary: "List group sync runs", Tag: "groups",
+			Description: "Get the 50 most recent syncs of the group with their succeeded, failed and skipped counts",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Group ID"}},
+			Response:    []models.SyncGroupRun{},
+			Errors:      map[int]string{http.StatusNotFound: "Group not found"},
+		}},
+		{"GET", "/groups/{id}/status", h.GetGroupStatus, openapi.Operation{
+			Summary: "Group status roll-up", Tag: "groups",
+			Description: "Count the members that are syncing, failed, healthy or never synced, judged by the latest execution of each approved target, along with the last group run",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Group ID"}},
+			Response:    models.SyncGroupStatus{},
+			Errors:      map[int]string{http.StatusNotFound: "Group not found"},
+		}},
+
+		{"POST", "/repository-templates", h.CreateRepositoryTemplate, openapi.Operation{
+			Summary: "Create a repository template", Tag: "repository-templates",
+			Description: "Bundle a schedule, clone and ref filters, push mode, labels and notification rules that repositories are created from by naming the template. " +
+				"Labels are merged into those of the repositories; the rules must select repositories with the labels of the template. Admin only.",
+			Body: models.RepositoryTemplateRequest{}, Status: http.StatusCreated, Response: models.RepositoryTemplate{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Invalid repository template",
+				http.StatusForbidden:  "Admin privileges required",
+				http.StatusConflict:   "Repository template with this name already exists",
+			},
+		}},
+		{"GET", "/repository-templates", h.ListRepositoryTemplates, openapi.Operation{
+			Summary: "List repository templates", Tag: "repository-templates",
+			Description: "Get all repository templates by name",
+			Response:    []models.RepositoryTemplate{},
+		}},
+		{"GET", "/repository-templates/{id}", h.GetRepositoryTemplate, openapi.Operation{
+			Summary: "Get a repository template", Tag: "repository-templates",
+			Description: "Get a repository template",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository template ID"}},
+			Response:    models.RepositoryTemplate{},
+			Errors:      map[int]string{http.StatusNotFound: "Repository template not found"},
+		}},
+		{"PUT", "/repository-templates/{id}", h.PutRepositoryTemplate, openapi.Operation{
+			Summary: "Create or replace a repository template", Tag: "repository-templates",
+			Description: "Create the repository template with the given ID, or replace an existing one. Its repositories pick up the changes when it is re-applied. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository template ID"}},
+			Body:        models.RepositoryTemplateRequest{}, Response: models.RepositoryTemplate{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Invalid repository template",
+				http.StatusForbidden:  "Admin privileges required",
+				http.StatusConflict:   "Repository template with this name already exists",
+			},
+		}},
+		{"DELETE", "/repository-templates/{id}", h.DeleteRepositoryTemplate, openapi.Operation{
+			Summary: "Delete a repository template", Tag: "repository-templates",
+			Description: "Delete a repository template. Its repositories keep their settings. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Repository template ID"}},
+			Status:      http.StatusNoContent,
+			Errors:      map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusNotFound: "Repository template not found"},
+		}},
+		{"POST", "/repository-templates/{id}/apply", h.ApplyRepositoryTemplate, openapi.Operation{
+			Summary: "Re-apply a repository template", Tag: "repository-templates",
+			Description: "Set the settings of the template on every repository created from it, or on the listed repositories, which it becomes the template of, and on all their targets. " +
+				"Settings the template leaves unset are kept, as is the mode of subdirectory targets. Repositories managed by GitOps or federation are skipped. " +
+				"Nothing is changed if the new labels of a repository violate a policy. Admin only.",
+			Params: []openapi.Param{{Name: "id", In: "path", Description: "Repository template ID"}},
+			Body:   models.ApplyTemplateRequest{}, Response: models.TemplateApplication{},
+			Errors: map[int]string{
+				http.StatusForbidden: "Admin privileges required or policy violated",
+				http.StatusNotFound:  "Repository template or repository not found",
+				http.StatusConflict:  "Repository is managed by GitOps or federation",
+			},
+		}},
+
+		{"POST", "/default-targets", h.CreateDefaultTarget, openapi.Operation{
+			Summary: "Create a default target", Tag: "default-targets",
+			Description: "Define a target added to every repository created from now on, unless it is created with skip_default_targets. " +
+				"The remote_url is a template such as ssh://git@dr.corp/{{org}}/{{name}}.git, filled in from the source URL of each repository. " +
+				"Targets added this way need no approval. Admin only.",
+			Body: models.DefaultTargetRequest{}, Status: http.StatusCreated, Response: models.DefaultTarget{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Invalid default target",
+				http.StatusForbidden:  "Admin privileges required",
+				http.StatusConflict:   "Default target with this name already exists",
+			},
+		}},
+		{"GET", "/default-targets", h.ListDefaultTargets, openapi.Operation{
+			Summary: "List default targets", Tag: "default-targets",
+			Description: "Get the targets new repositories get, by name",
+			Response:    []models.DefaultTarget{},
+		}},
+		{"GET", "/default-targets/{id}", h.GetDefaultTarget, openapi.Operation{
+			Summary: "Get a default target", Tag: "default-targets",
+			Description: "Get a default target",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Default target ID"}},
+			Response:    models.DefaultTarget{},
+			Errors:      map[int]string{http.StatusNotFound: "Default target not found"},
+		}},
+		{"PUT", "/default-targets/{id}", h.PutDefaultTarget, openapi.Operation{
+			Summary: "Create or replace a default target", Tag: "default-targets",
+			Description: "Create the default target with the given ID, or replace an existing one. Repositories created before keep the targets they got. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Default target ID"}},
+			Body:        models.DefaultTargetRequest{}, Response: models.DefaultTarget{},
+			Errors: map[int]string{
+				http.StatusBadRequest: "Invalid default target",
+				http.StatusForbidden:  "Admin privileges required",
+				http.StatusConflict:   "Default target with this name already exists",
+			},
+		}},
+		{"DELETE", "/default-targets/{id}", h.DeleteDefaultTarget, openapi.Operation{
+			Summary: "Delete a default target", Tag: "default-targets",
+			Description: "Stop adding a target to new repositories. The targets it added stay. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Default target ID"}},
+			Status:      http.StatusNoContent,
+			Errors:      map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusNotFound: "Default target not found"},
+		}},
+
+		{"POST", "/freeze-periods", h.CreateFreezePeriod, openapi.Operation{
+			Summary: "Create a freeze period", Tag: "freeze-periods",
+			Description: "Suspend automatic syncing of every repository between starts_at and ends_at. Admin only.",
+			Body:        models.FreezePeriodRequest{}, Status: http.StatusCreated, Response: models.FreezePeriod{},
+			Errors: map[int]string{http.StatusBadRequest: "Invalid freeze period", http.StatusForbidden: "Admin privileges required"},
+		}},
+		{"GET", "/freeze-periods", h.ListFreezePeriods, openapi.Operation{
+			Summary: "List freeze periods", Tag: "freeze-periods",
+			Description: "Get all freeze periods, latest start first",
+			Params:      []openapi.Param{{Name: "upcoming", Type: "boolean", Description: "Only periods that have not ended"}},
+			Response:    []models.FreezePeriod{},
+		}},
+		{"GET", "/freeze-periods/{id}", h.GetFreezePeriod, openapi.Operation{
+			Summary: "Get a freeze period", Tag: "freeze-periods",
+			Description: "Get a freeze period",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Freeze period ID"}},
+			Response:    models.FreezePeriod{},
+			Errors:      map[int]string{http.StatusNotFound: "Freeze period not found"},
+		}},
+		{"PUT", "/freeze-periods/{id}", h.PutFreezePeriod, openapi.Operation{
+			Summary: "Create or replace a freeze period", Tag: "freeze-periods",
+			Description: "Create the freeze period with the given ID, or replace an existing one, for example to end it early. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Freeze period ID"}},
+			Body:        models.FreezePeriodRequest{}, Response: models.FreezePeriod{},
+			Errors: map[int]string{http.StatusBadRequest: "Invalid freeze period", http.StatusForbidden: "Admin privileges required"},
+		}},
+		{"DELETE", "/freeze-periods/{id}", h.DeleteFreezePeriod, openapi.Operation{
+			Summary: "Delete a freeze period", Tag: "freeze-periods",
+			Description: "Delete a freeze period. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Freeze period ID"}},
+			Status:      http.StatusNoContent,
+			Errors:      map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusNotFound: "Freeze period not found"},
+		}},
+
+		{"GET", "/backups/status", h.GetBackupStatus, openapi.Operation{
+			Summary: "Get backup status", Tag: "backups",
+			Description: "Roll up the latest bundle snapshot of every repository. Snapshots are taken every BACKUP_INTERVAL when BACKUP_STORE is set.",
+			Response:    models.BackupStatus{},
+		}},
+		{"GET", "/backups/snapshots", h.ListBackupSnapshots, openapi.Operation{
+			Summary: "List backup snapshots", Tag: "backups",
+			Description: "Get the most recent bundle snapshots, including those of deleted repositories not yet expired",
+			Params:      []openapi.Param{{Name: "repository_id", Description: "Only snapshots of this repository"}},
+			Response:    []models.BackupSnapshot{},
+			Errors:      map[int]string{http.StatusBadRequest: "Invalid repository ID"},
+		}},
+		{"GET", "/backups/snapshots/{id}", h.GetBackupSnapshot, openapi.Operation{
+			Summary: "Get a backup snapshot", Tag: "backups",
+			Params:   []openapi.Param{{Name: "id", In: "path", Description: "Snapshot ID"}},
+			Response: models.BackupSnapshot{},
+			Errors:   map[int]string{http.StatusNotFound: "Backup snapshot not found"},
+		}},
+
+		{"GET", "/consistency-audits", h.ListConsistencyAudits, openapi.Operation{
+			Summary: "List consistency audits", Tag: "consistency",
+			Description: "Get the most recent audits comparing the refs of every approved target with its source, apart from syncs. " +
+				"Audits start every CONSISTENCY_AUDIT_INTERVAL when set; the latest finished one is also published under consistency_audit in /debug/vars.",
+			Response: []models.ConsistencyAudit{},
+		}},
+		{"GET", "/consistency-audits/{id}", h.GetConsistencyAudit, openapi.Operation{
+			Summary: "Get a consistency audit", Tag: "consistency",
+			Description: "Get an audit with the result of each target: in_sync, drifted, corrupt when a sampled ref failed to fetch, error, or skipped when the repository was busy syncing",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "Audit ID"},
+				{Name: "status", Description: "Only results with this status"},
+				{Name: "repository_id", Description: "Only results for this repository"},
+			},
+			Response: models.ConsistencyReport{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid repository ID", http.StatusNotFound: "Consistency audit not found"},
+		}},
+		{"POST", "/consistency-audits", h.StartConsistencyAudit, openapi.Operation{
+			Summary: "Start a consistency audit", Tag: "consistency",
+			Description: "Start an audit of every approved target now. The audit runs in the background. Admin only.",
+			Status:      http.StatusAccepted, Response: models.ConsistencyAudit{},
+			Errors: map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusConflict: "An audit is already running"},
+		}},
+
+		{"POST", "/repositories/{id}/maintenance", h.MaintainRepository, openapi.Operation{
+			Summary: "Repack the cached mirror of a repository", Tag: "repositories",
+			Description: "Run git gc on the cached mirror of a repository now, or on the object pool it borrows its objects from, and report how its objects were stored before and after. " +
+				"Mirrors are otherwise repacked on a schedule and once they pile up loose objects or packs. Responds once done. Admin only.",
+			Params:   []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Response: models.MaintenanceResult{},
+			Errors: map[int]string{
+				http.StatusForbidden: "Admin privileges required",
+				http.StatusNotFound:  "Repository has no cached mirror",
+				http.StatusConflict:  "Repository is busy syncing",
+			},
+		}},
+		{"POST", "/repositories/{id}/restore", h.RestoreRepository, openapi.Operation{
+			Summary: "Restore a repository from a backup snapshot", Tag: "backups",
+			Description: "Push the branches and tags of a bundle snapshot, by default the latest successful one, to a target of the repository or to a new remote, forcing them over what the remote holds. " +
+				"Responds once the push is done. Snapshots of deleted repositories can still be restored to a new remote. Admin only.",
+			Params: []openapi.Param{{Name: "id", In: "path", Description: "Repository ID"}},
+			Body:   models.RestoreRequest{}, Response: models.RestoreResult{},
+			Errors: map[int]string{
+				http.StatusBadRequest:         "Invalid restore request",
+				http.StatusForbidden:          "Admin privileges required",
+				http.StatusNotFound:           "Snapshot or target not found",
+				http.StatusConflict:           "Repository is busy syncing",
+				http.StatusServiceUnavailable: "No backup store configured",
+			},
+		}},
+
+		{"GET", "/integrity-findings", h.ListIntegrityFindings, openapi.Operation{
+			Summary: "List integrity findings", Tag: "integrity",
+			Description: "Get the most recent corruption found when MIRROR_VERIFY is set: mirrors failing git fsck after a fetch and targets whose refs do not match what was pushed",
+			Params: []openapi.Param{
+				{Name: "repository_id", Description: "Only findings for this repository"},
+				{Name: "kind", Description: "Only findings of this kind: mirror_corrupt or target_mismatch"},
+			},
+			Response: []models.IntegrityFinding{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid repository ID"},
+		}},
+		{"GET", "/secret-findings", h.ListSecretFindings, openapi.Operation{
+			Summary: "List secret findings", Tag: "secrets",
+			Description: "Get the most recent possible credentials found in new commits when SECRET_SCAN is set. A sync whose commits hold a finding that is not allowed is quarantined: its targets fail without pushing.",
+			Params: []openapi.Param{
+				{Name: "repository_id", Description: "Only findings for this repository"},
+				{Name: "allowed", Type: "boolean", Description: "Only allowed or only blocking findings"},
+			},
+			Response: []models.SecretFinding{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid filter"},
+		}},
+		{"POST", "/secret-findings/{id}/allow", h.AllowSecretFinding, openapi.Operation{
+			Summary: "Allow a secret finding", Tag: "secrets",
+			Description: "Mark a finding as a false positive or an accepted risk, so it no longer quarantines syncs of its repository. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Finding ID"}},
+			Response:    models.SecretFinding{},
+			Errors:      map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusNotFound: "Finding not found"},
+		}},
+
+		{"GET", "/anomalies", h.ListAnomalies, openapi.Operation{
+			Summary: "List anomalies", Tag: "anomalies",
+			Description: "Get the most recent anomalous source changes found when ANOMALY_DETECTION is set: fetches growing a mirror many times over, deleting many refs or rewriting the history of protected refs such as main. " +
+				"Syncs of a repository with an anomaly that is not acknowledged fail without pushing, and an alert is raised.",
+			Params: []openapi.Param{
+				{Name: "repository_id", Description: "Only anomalies of this repository"},
+				{Name: "acknowledged", Type: "boolean", Description: "Only acknowledged or only open anomalies"},
+			},
+			Response: []models.Anomaly{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid filter"},
+		}},
+		{"POST", "/anomalies/{id}/acknowledge", h.AcknowledgeAnomaly, openapi.Operation{
+			Summary: "Acknowledge an anomaly", Tag: "anomalies",
+			Description: "Accept an anomalous source change as intended. Once all anomalies of a repository are acknowledged, its next sync pushes the changes and the alert is resolved. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Anomaly ID"}},
+			Response:    models.Anomaly{},
+			Errors:      map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusNotFound: "Anomaly not found"},
+		}},
+		{"GET", "/push-approvals", h.ListPushApprovals, openapi.Operation{
+			Summary: "List push approvals", Tag: "push-approvals",
+			Description: "Get the most recent force pushes and ref deletions held for approval on protected targets. A sync that would rewrite or delete refs on a protected target leaves its execution awaiting_approval until two admins approve the changes.",
+			Params: []openapi.Param{
+				{Name: "status", Description: "Only approvals in this status: pending, approved, rejected, executed or superseded"},
+				{Name: "repository_id", Description: "Only approvals for this repository"},
+				{Name: "target_id", Description: "Only approvals for this target"},
+			},
+			Response: []models.PushApproval{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid repository or target ID"},
+		}},
+		{"GET", "/push-approvals/{id}", h.GetPushApproval, openapi.Operation{
+			Summary: "Get a push approval", Tag: "push-approvals",
+			Description: "Get the refs a held push would force or delete and who decided on it",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Push approval ID"}},
+			Response:    models.PushApproval{},
+			Errors:      map[int]string{http.StatusNotFound: "Push approval not found"},
+		}},
+		{"POST", "/push-approvals/{id}/approve", h.ApprovePushApproval, openapi.Operation{
+			Summary: "Approve a held push", Tag: "push-approvals",
+			Description: "Add the caller to the approvers of a pending push. Once a second admin approved it, the repository is synced to push the changes; a sync finding other changes supersedes the approval. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Push approval ID"}},
+			Response:    models.PushApproval{},
+			Errors: map[int]string{
+				http.StatusForbidden: "Admin privileges required",
+				http.StatusNotFound:  "Push approval not found",
+				http.StatusConflict:  "Push approval is decided already or was approved by the caller",
+			},
+		}},
+		{"POST", "/push-approvals/{id}/reject", h.RejectPushApproval, openapi.Operation{
+			Summary: "Reject a held push", Tag: "push-approvals",
+			Description: "Reject a pending or approved push that was not pushed yet. Syncs keep holding the same changes; only other changes are held for approval again. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Push approval ID"}},
+			Response:    models.PushApproval{},
+			Errors: map[int]string{
+				http.StatusForbidden: "Admin privileges required",
+				http.StatusNotFound:  "Push approval not found",
+				http.StatusConflict:  "Push approval is decided already",
+			},
+		}},
+
+		{"GET", "/providers/status", h.GetProviderStatus, openapi.Operation{
+			Summary: "Provider connectivity status", Tag: "providers",
+			Description: "Get the latest reachability and authorization check for each configured provider instance",
+			Response:    []provider.InstanceStatus{},
+		}},
+
+		{"POST", "/credentials", h.CreateCredential, openapi.Operation{
+			Summary: "Create a credential profile", Tag: "credentials",
+			Description: "Store a named secret that repositories and targets can reference: a provider API token (kind token, the default), " +
+				"a username and password for git over HTTPS (basic), or an SSH private key for git over SSH (ssh_key). " +
+				"Only tokens are used for provider API calls such as installing webhooks.",
+			Body: models.CreateCredentialRequest{}, Status: http.StatusCreated, Response: models.Credential{},
+			Errors: map[int]string{http.StatusBadRequest: "Invalid kind, or a username or secret that does not suit it", http.StatusConflict: "Credential already exists"},
+		}},
+		{"GET", "/credentials", h.ListCredentials, openapi.Operation{
+			Summary: "List credential profiles", Tag: "credentials",
+			Description: "Get all credential profiles without their secrets",
+			Response:    []models.Credential{},
+		}},
+		{"PUT", "/credentials/{name}", h.ReplaceCredential, openapi.Operation{
+			Summary: "Replace a credential secret", Tag: "credentials",
+			Description: "Rotate the secret of a credential profile, and the username of basic credentials, optionally verifying a token against the provider first",
+			Params:      []openapi.Param{{Name: "name", In: "path", Description: "Credential name"}},
+			Body:        models.ReplaceCredentialRequest{}, Response: models.Credential{},
+		}},
+		{"GET", "/credentials/{name}", h.GetCredential, openapi.Operation{
+			Summary: "Get a credential profile", Tag: "credentials",
+			Description: "Get a credential profile without its secret",
+			Params:      []openapi.Param{{Name: "name", In: "path", Description: "Credential name"}},
+			Response:    models.Credential{},
+			Errors:      map[int]string{http.StatusNotFound: "Credential not found"},
+		}},
+		{"DELETE", "/credentials/{name}", h.DeleteCredential, openapi.Operation{
+			Summary: "Delete a credential profile", Tag: "credentials",
+			Description: "Delete a credential profile that is no longer referenced",
+			Params:      []openapi.Param{{Name: "name", In: "path", Description: "Credential name"}},
+			Status:      http.StatusNoContent,
+		}},
+		{"GET", "/credentials/{name}/usage", h.GetCredentialUsage, openapi.Operation{
+			Summary: "List credential usage", Tag: "credentials",
+			Description: "Get the repositories and targets that reference a credential profile",
+			Params:      []openapi.Param{{Name: "name", In: "path", Description: "Credential name"}},
+			Response:    models.CredentialUsage{},
+		}},
+
+		{"POST", "/notification-channels", h.CreateNotificationChannel, openapi.Operation{
+			Summary: "Create a notification channel", Tag: "notifications",
+			Description: "Create an outbound channel defined by a URL and payload template",
+			Body:        models.CreateNotificationChannelRequest{}, Status: http.StatusCreated, Response: models.NotificationChannel{},
+		}},
+		{"GET", "/notification-channels", h.ListNotificationChannels, openapi.Operation{
+			Summary: "List notification channels", Tag: "notifications",
+			Description: "Get all notification channels",
+			Params:      []openapi.Param{{Name: "external_id", Description: "Only the channel with this external ID"}},
+			Response:    []models.NotificationChannel{},
+		}},
+		{"GET", "/notification-channels/{id}", h.GetNotificationChannel, openapi.Operation{
+			Summary: "Get a notification channel", Tag: "notifications",
+			Description: "Get a notification channel",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Channel ID"}},
+			Response:    models.NotificationChannel{},
+			Errors:      map[int]string{http.StatusNotFound: "Channel not found"},
+		}},
+		{"PUT", "/notification-channels/{id}", h.PutNotificationChannel, openapi.Operation{
+			Summary: "Create or replace a notification channel", Tag: "notifications",
+			Description: "Create the channel with the given ID, or replace every field of an existing one; its delivery log is kept",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Channel ID"}},
+			Body:        models.CreateNotificationChannelRequest{}, Response: models.NotificationChannel{},
+			Errors: map[int]string{http.StatusConflict: "Channel already exists"},
+		}},
+		{"DELETE", "/notification-channels/{id}", h.DeleteNotificationChannel, openapi.Operation{
+			Summary: "Delete a notification channel", Tag: "notifications",
+			Description: "Delete a notification channel and its delivery log",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Channel ID"}},
+			Status:      http.StatusNoContent,
+		}},
+		{"GET", "/notification-channels/{id}/deliveries", h.ListNotificationDeliveries, openapi.Operation{
+			Summary: "List channel deliveries", Tag: "notifications",
+			Description: "Get the most recent delivery attempts of a notification channel",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Channel ID"}},
+			Response:    []models.NotificationDelivery{},
+		}},
+		{"POST", "/notification-channels/{id}/test", h.TestNotificationChannel, openapi.Operation{
+			Summary: "Send a test notification", Tag: "notifications",
+			Description: "Deliver a test event to the channel synchronously and report the outcome",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Channel ID"}},
+			Status:      http.StatusNoContent,
+			Errors:      map[int]string{http.StatusNotFound: "Channel not found", http.StatusBadGateway: "Delivery failed"},
+		}},
+		{"POST", "/notification-rules", h.CreateNotificationRule, openapi.Operation{
+			Summary: "Create a notification routing rule", Tag: "notifications",
+			Description: "Route events matching labels, provider, severity and time of day to channels",
+			Body:        models.NotificationRuleRequest{}, Status: http.StatusCreated, Response: models.NotificationRule{},
+		}},
+		{"GET", "/notification-rules", h.ListNotificationRules, openapi.Operation{
+			Summary: "List notification routing rules", Tag: "notifications",
+			Description: "Get all routing rules in evaluation order",
+			Params:      []openapi.Param{{Name: "external_id", Description: "Only the rule with this external ID"}},
+			Response:    []models.NotificationRule{},
+		}},
+		{"GET", "/notification-rules/{id}", h.GetNotificationRule, openapi.Operation{
+			Summary: "Get a notification routing rule", Tag: "notifications",
+			Description: "Get a routing rule",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Rule ID"}},
+			Response:    models.NotificationRule{},
+			Errors:      map[int]string{http.StatusNotFound: "Rule not found"},
+		}},
+		{"PUT", "/notification-rules/{id}", h.UpdateNotificationRule, openapi.Operation{
+			Summary: "Create or replace a notification routing rule", Tag: "notifications",
+			Description: "Create the routing rule with the given ID, or replace every field of an existing one",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Rule ID"}},
+			Body:        models.NotificationRuleRequest{}, Response: models.NotificationRule{},
+			Errors: map[int]string{http.StatusConflict: "Rule already exists"},
+		}},
+		{"DELETE", "/notification-rules/{id}", h.DeleteNotificationRule, openapi.Operation{
+			Summary: "Delete a notification routing rule", Tag: "notifications",
+			Description: "Delete a routing rule",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Rule ID"}},
+			Status:      http.StatusNoContent,
+		}},
+
+		{"GET", "/reports/digest", h.GetDigest, openapi.Operation{
+			Summary: "Activity digest", Tag: "reports",
+			Description: "Summarize sync volume, failures, new repositories and drifted targets over the trailing day or week",
+			Params:      []openapi.Param{{Name: "period", Description: "daily (default) or weekly"}},
+			Response:    digest.Report{},
+			Errors:      map[int]string{http.StatusBadRequest: "Invalid period"},
+		}},
+		{"GET", "/stats/syncs", h.GetSyncStats, openapi.Operation{
+			Summary: "Sync statistics", Tag: "reports",
+			Description: "Aggregate finished syncs by the UTC day, week or month they started in: success rate, bytes pushed as reported by git and average duration, " +
+				"with totals and the repositories with the most failures. Computed from a daily rollup kept after executions expire.",
+			Params: []openapi.Param{
+				{Name: "group_by", Description: "day (default), week or month"},
+				{Name: "since", Description: "How far back, in days such as 30d (default) or a duration such as 72h"},
+				{Name: "repository_id", Description: "Only syncs of this repository"},
+			},
+			Response: models.SyncStats{},
+			Errors:   map[int]string{http.StatusBadRequest: "Invalid group_by, since or repository ID"},
+		}},
+
+		{"POST", "/policies", h.CreatePolicy, openapi.Operation{
+			Summary: "Create a policy", Tag: "policies",
+			Description: "Create a policy restricting allowed target domains, public providers or non-SSH URLs",
+			Body:        models.PolicyRequest{}, Status: http.StatusCreated, Response: models.Policy{},
+		}},
+		{"GET", "/policies", h.ListPolicies, openapi.Operation{
+			Summary: "List policies", Tag: "policies",
+			Description: "Get all policies",
+			Params:      []openapi.Param{{Name: "external_id", Description: "Only the policy with this external ID"}},
+			Response:    []models.Policy{},
+		}},
+		{"GET", "/policies/{id}", h.GetPolicy, openapi.Operation{
+			Summary: "Get a policy", Tag: "policies",
+			Description: "Get a policy",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Policy ID"}},
+			Response:    models.Policy{},
+			Errors:      map[int]string{http.StatusNotFound: "Policy not found"},
+		}},
+		{"PUT", "/policies/{id}", h.UpdatePolicy, openapi.Operation{
+			Summary: "Create or replace a policy", Tag: "policies",
+			Description: "Create the policy with the given ID, or replace every field of an existing one",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Policy ID"}},
+			Body:        models.PolicyRequest{}, Response: models.Policy{},
+			Errors: map[int]string{http.StatusConflict: "Policy already exists"},
+		}},
+		{"DELETE", "/policies/{id}", h.DeletePolicy, openapi.Operation{
+			Summary: "Delete a policy", Tag: "policies",
+			Description: "Delete a policy; its recorded violations are kept",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Policy ID"}},
+			Status:      http.StatusNoContent,
+		}},
+		{"GET", "/policy-violations", h.ListPolicyViolations, openapi.Operation{
+			Summary: "List policy violations", Tag: "policies",
+			Description: "Get the most recent creates and syncs blocked by policies",
+			Response:    []models.PolicyViolation{},
+		}},
+
+		{"POST", "/compliance/exports", h.CreateComplianceExport, openapi.Operation{
+			Summary: "Start a compliance export", Tag: "compliance",
+			Description: "Build a signed, hash-chained archive of the audit log and sync history for a period. Admin only.",
+			Body:        models.CreateComplianceExportRequest{}, Status: http.StatusAccepted, Response: models.ComplianceExport{},
+			Errors: map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusServiceUnavailable: "Signing key not configured"},
+		}},
+		{"GET", "/compliance/exports", h.ListComplianceExports, openapi.Operation{
+			Summary: "List compliance exports", Tag: "compliance",
+			Description: "Get all compliance exports, newest first. Admin only.",
+			Response:    []models.ComplianceExport{},
+			Errors:      adminOnly,
+		}},
+		{"GET", "/compliance/exports/{id}", h.GetComplianceExport, openapi.Operation{
+			Summary: "Get a compliance export", Tag: "compliance",
+			Description: "Get the status of a compliance export. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Export ID"}},
+			Response:    models.ComplianceExport{},
+			Errors:      map[int]string{http.StatusForbidden: "Admin privileges required", http.StatusNotFound: "Export not found"},
+		}},
+		{"GET", "/compliance/exports/{id}/download", h.DownloadComplianceExport, openapi.Operation{
+			Summary: "Download a compliance export", Tag: "compliance",
+			Description: "Download the archive of a finished compliance export. Admin only.",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Export ID"}},
+			Response:    []byte{}, Produces: "application/gzip",
+			Errors: map[int]string{
+				http.StatusForbidden: "Admin privileges required",
+				http.StatusNotFound:  "Export not found",
+				http.StatusConflict:  "Export not finished",
+			},
+		}},
+
+		{"GET", "/notifications", h.ListNotifications, openapi.Operation{
+			Summary: "List inbox notifications", Tag: "notifications",
+			Description: "Get the caller's most recent notifications with read state",
+			Params: []openapi.Param{
+				{Name: "unread", Type: "boolean", Description: "Only unread notifications"},
+				{Name: "limit", Type: "integer", Description: "Maximum number of notifications (default 50, max 200)"},
+			},
+			Response: []models.Notification{},
+			Errors:   inbox,
+		}},
+		{"GET", "/notifications/unread-count", h.GetUnreadCount, openapi.Operation{
+			Summary: "Count unread notifications", Tag: "notifications",
+			Description: "Get the number of unread notifications for the bell icon",
+			Response:    map[string]int{},
+			Errors:      inbox,
+		}},
+		{"POST", "/notifications/read-all", h.MarkAllNotificationsRead, openapi.Operation{
+			Summary: "Mark all notifications as read", Tag: "notifications",
+			Description: "Mark every notification created so far as read for the caller",
+			Status:      http.StatusNoContent,
+			Errors:      inbox,
+		}},
+		{"POST", "/notifications/{id}/read", h.MarkNotificationRead, openapi.Operation{
+			Summary: "Mark a notification as read", Tag: "notifications",
+			Description: "Mark a single notification as read for the caller",
+			Params:      []openapi.Param{{Name: "id", In: "path", Description: "Notification ID"}},
+			Status:      http.StatusNoContent,
+			Errors:      map[int]string{http.StatusUnauthorized: "Authentication required", http.StatusNotFound: "Notification not found"},
+		}},
+	}
+}
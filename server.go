@@ -0,0 +1,79 @@
+package gitsync
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ServerConfig sets how the standalone server listens
+type ServerConfig struct {
+	Addr string
+	// TLSCertFile and TLSKeyFile serve HTTPS, negotiating HTTP/2 with
+	// clients that support it; plain HTTP is served when they are empty
+	TLSCertFile string
+	TLSKeyFile  string
+	// H2C accepts cleartext HTTP/2 from clients with prior knowledge, such
+	// as a proxy in front of the server, along with HTTP/1.1
+	H2C bool
+	// MaxConcurrentStreams bounds the streams each HTTP/2 connection may
+	// have open at once
+	MaxConcurrentStreams int
+	// ReadHeaderTimeout bounds how long a client may take to send request
+	// headers, and IdleTimeout how long a connection is kept between
+	// requests. Responses have no write timeout, so streamed listings and
+	// event streams are not cut off.
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+}
+
+// ServerConfigFromEnv reads SERVER_HOST, SERVER_PORT, TLS_CERT_FILE,
+// TLS_KEY_FILE, HTTP2_CLEARTEXT and HTTP2_MAX_CONCURRENT_STREAMS, defaulting
+// to plain HTTP on 0.0.0.0:8080 and 250 streams
+func ServerConfigFromEnv() (ServerConfig, error) {
+	cfg := ServerConfig{
+		Addr:                 net.JoinHostPort(getEnv("SERVER_HOST", "0.0.0.0"), getEnv("SERVER_PORT", "8080")),
+		TLSCertFile:          os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:           os.Getenv("TLS_KEY_FILE"),
+		H2C:                  getEnv("HTTP2_CLEARTEXT", "false") == "true",
+		MaxConcurrentStreams: 250,
+		ReadHeaderTimeout:    10 * time.Second,
+		IdleTimeout:          2 * time.Minute,
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if raw := os.Getenv("HTTP2_MAX_CONCURRENT_STREAMS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("HTTP2_MAX_CONCURRENT_STREAMS must be a positive number")
+		}
+		cfg.MaxConcurrentStreams = n
+	}
+	return cfg, nil
+}
+
+// TLS reports whether cfg serves HTTPS
+func (cfg ServerConfig) TLS() bool {
+	return cfg.TLSCertFile != ""
+}
+
+// Server returns an HTTP server for the API as cfg sets it up. HTTP/2 is
+// offered over TLS, and in cleartext when H2C is set.
+func (a *App) Server(cfg ServerConfig) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.TLS())
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           a.Handler(),
+		Protocols:         &protocols,
+		HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: cfg.MaxConcurrentStreams},
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}